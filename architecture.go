@@ -0,0 +1,39 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// nodeSelectorForInstance returns the node selector of the pods of the
+// SQLiteInstance: the node selector of the spec, limited to nodes of
+// spec.architecture when set, so an image built for a single architecture
+// only runs where it can.
+func nodeSelectorForInstance(instance *kubelitedbv1.SQLiteInstance) map[string]string {
+	if instance.Spec.Architecture == "" {
+		return instance.Spec.NodeSelector
+	}
+	nodeSelector := map[string]string{}
+	for key, value := range instance.Spec.NodeSelector {
+		nodeSelector[key] = value
+	}
+	nodeSelector[corev1.LabelArchStable] = instance.Spec.Architecture
+	return nodeSelector
+}
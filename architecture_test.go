@@ -0,0 +1,142 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"maps"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/ktesting"
+)
+
+func TestArchitecture(t *testing.T) {
+	images := map[string]string{"arm64": "example.com/sqlite:3-arm64", "amd64": "example.com/sqlite:3-amd64"}
+	tests := []struct {
+		name         string
+		image        string
+		architecture string
+		images       map[string]string
+		nodeSelector map[string]string
+		wantImage    string
+		wantSelector map[string]string
+	}{
+		// The default image is published for every architecture
+		{name: "default image", wantImage: defaultImage},
+		{name: "image of the spec", image: "example.com/sqlite:3", wantImage: "example.com/sqlite:3"},
+		{
+			name:         "architecture with the default image",
+			architecture: "arm64",
+			wantImage:    defaultImage,
+			wantSelector: map[string]string{corev1.LabelArchStable: "arm64"},
+		},
+		{
+			name:         "image of the architecture",
+			image:        "example.com/sqlite:3",
+			architecture: "arm64",
+			images:       images,
+			wantImage:    "example.com/sqlite:3-arm64",
+			wantSelector: map[string]string{corev1.LabelArchStable: "arm64"},
+		},
+		{
+			name:         "no image for the architecture",
+			image:        "example.com/sqlite:3",
+			architecture: "s390x",
+			images:       images,
+			wantImage:    "example.com/sqlite:3",
+			wantSelector: map[string]string{corev1.LabelArchStable: "s390x"},
+		},
+		{
+			name:         "merged with the node selector of the spec",
+			architecture: "amd64",
+			images:       images,
+			nodeSelector: map[string]string{"disktype": "ssd"},
+			wantImage:    "example.com/sqlite:3-amd64",
+			wantSelector: map[string]string{"disktype": "ssd", corev1.LabelArchStable: "amd64"},
+		},
+		{
+			name:         "node selector of the spec only",
+			nodeSelector: map[string]string{"disktype": "ssd"},
+			wantImage:    defaultImage,
+			wantSelector: map[string]string{"disktype": "ssd"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newSQLiteInstance("test")
+			instance.Spec.ReadReplicas = 1
+			instance.Spec.Image = tt.image
+			instance.Spec.Architecture = tt.architecture
+			instance.Spec.ArchitectureImages = tt.images
+			instance.Spec.NodeSelector = maps.Clone(tt.nodeSelector)
+
+			for kind, podSpec := range map[string]corev1.PodSpec{
+				"StatefulSet": newStatefulSet(instance).Spec.Template.Spec,
+				"Deployment":  newReaderDeployment(instance).Spec.Template.Spec,
+				"DaemonSet":   newDaemonSet(instance, defaultHostPathBase).Spec.Template.Spec,
+			} {
+				if got := container(t, podSpec.Containers, "sqlite").Image; got != tt.wantImage {
+					t.Errorf("expected the %s to run %s, got %s", kind, tt.wantImage, got)
+				}
+				if !maps.Equal(podSpec.NodeSelector, tt.wantSelector) {
+					t.Errorf("expected the %s to select the nodes %v, got %v", kind, tt.wantSelector, podSpec.NodeSelector)
+				}
+			}
+			// The backups are taken with the same image, on the node of the pod
+			backup := newBackupJob(newSQLiteBackup("backup", instance.Name), instance).Spec.Template.Spec
+			if got := backup.InitContainers[0].Image; got != tt.wantImage {
+				t.Errorf("expected the backup to run %s, got %s", tt.wantImage, got)
+			}
+			if !maps.Equal(instance.Spec.NodeSelector, tt.nodeSelector) {
+				t.Errorf("expected the node selector of the spec to be left as is, got %v", instance.Spec.NodeSelector)
+			}
+		})
+	}
+}
+
+func TestArchitectureChangeUpdatesStatefulSet(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+	f.run(ctx, c, getKey(instance, t))
+
+	updated := f.getInstance(ctx, instance)
+	updated.Spec.Architecture = "arm64"
+	updated.Spec.ArchitectureImages = map[string]string{"arm64": "example.com/sqlite:3-arm64"}
+	updated.Generation++
+	if _, err := f.client.KubelitedbV1().SQLiteInstances(instance.Namespace).Update(ctx, updated, v1.UpdateOptions{}); err != nil {
+		t.Fatalf("error updating the SQLiteInstance: %v", err)
+	}
+	f.refreshCaches(ctx)
+	f.kubeclient.ClearActions()
+
+	f.run(ctx, c, getKey(instance, t))
+
+	if actions := writes(f.kubeclient.Actions(), "statefulsets"); len(actions) != 1 || actions[0].GetVerb() != "update" {
+		t.Fatalf("expected the StatefulSet to be updated, got %v", actions)
+	}
+	podSpec := f.getStatefulSet(ctx, instance).Spec.Template.Spec
+	if got := container(t, podSpec.Containers, "sqlite").Image; got != "example.com/sqlite:3-arm64" {
+		t.Errorf("expected the arm64 image, got %s", got)
+	}
+	if got := podSpec.NodeSelector[corev1.LabelArchStable]; got != "arm64" {
+		t.Errorf("expected the pods to run on arm64 nodes, got %v", podSpec.NodeSelector)
+	}
+}
@@ -0,0 +1,553 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	batchinformers "k8s.io/client-go/informers/batch/v1"
+	"k8s.io/client-go/kubernetes"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+	clientset "github.com/fortytwoapps/kubelitedb/pkg/generated/clientset/versioned"
+	informers "github.com/fortytwoapps/kubelitedb/pkg/generated/informers/externalversions/kubelitedb/v1"
+	listers "github.com/fortytwoapps/kubelitedb/pkg/generated/listers/kubelitedb/v1"
+)
+
+const backupControllerAgentName = "kubelitedb-backup-controller"
+
+const (
+	// BackupPhasePending is the phase of a SQLiteBackup waiting for its Job
+	BackupPhasePending = "Pending"
+	// BackupPhaseRunning is the phase of a SQLiteBackup whose Job is running
+	BackupPhaseRunning = "Running"
+	// BackupPhaseCompleted is the phase of a SQLiteBackup that was uploaded
+	BackupPhaseCompleted = "Completed"
+	// BackupPhaseFailed is the phase of a SQLiteBackup that did not complete
+	BackupPhaseFailed = "Failed"
+)
+
+const (
+	// ReasonSourceNotFound is used as the condition reason when the
+	// SQLiteInstance to back up does not exist
+	ReasonSourceNotFound = "SourceNotFound"
+	// ReasonJobRunning is used as the condition reason while the backup Job
+	// is running
+	ReasonJobRunning = "JobRunning"
+	// ReasonJobSucceeded is used as the condition reason when the backup Job
+	// succeeded
+	ReasonJobSucceeded = "JobSucceeded"
+	// ReasonJobFailed is used as the condition reason when the backup Job
+	// failed
+	ReasonJobFailed = "JobFailed"
+	// ReasonDeploymentModeUnsupported is used as the condition reason when
+	// the SQLiteInstance to back up keeps its database on the nodes
+	ReasonDeploymentModeUnsupported = "DeploymentModeUnsupported"
+
+	// MessageSourceNotFound is the message used when the SQLiteInstance to
+	// back up does not exist
+	MessageSourceNotFound = "SQLiteInstance %q does not exist"
+	// MessageDeploymentModeUnsupported is the message used when the
+	// SQLiteInstance to back up keeps its database on the nodes
+	MessageDeploymentModeUnsupported = "SQLiteInstance %q uses the DaemonSet deployment mode, which can't be backed up"
+	// MessageBackupCompleted is the message used for an Event fired when a
+	// SQLiteBackup completed
+	MessageBackupCompleted = "Backup uploaded to %s"
+)
+
+const (
+	// awsCLIImage is the container image uploading backups to object storage
+	awsCLIImage = "amazon/aws-cli:2.15.0"
+	// backupVolumeName is the name of the volume holding the snapshot of the
+	// database while it is uploaded
+	backupVolumeName = "backup"
+	// backupMountPath is where the backup volume is mounted
+	backupMountPath = "/backup"
+)
+
+// BackupController is the controller implementation for SQLiteBackup resources
+type BackupController struct {
+	kubeclientset       kubernetes.Interface
+	kubelitedbclientset clientset.Interface
+
+	sqliteBackupsLister   listers.SQLiteBackupLister
+	sqliteBackupsSynced   cache.InformerSynced
+	sqliteInstancesLister listers.SQLiteInstanceLister
+	sqliteInstancesSynced cache.InformerSynced
+	jobsLister            batchlisters.JobLister
+	jobsSynced            cache.InformerSynced
+
+	// instanceSelector matches the SQLiteInstances the controller is
+	// responsible for. Backups of other SQLiteInstances are ignored.
+	instanceSelector labels.Selector
+
+	workqueue workqueue.RateLimitingInterface
+	recorder  record.EventRecorder
+
+	// dryRun makes every write to the API server a dry run, so the Jobs and
+	// status updates the controller would make are only reported
+	dryRun bool
+}
+
+// NewBackupController returns a new controller running a Job for every
+// SQLiteBackup
+func NewBackupController(
+	ctx context.Context,
+	kubeclientset kubernetes.Interface,
+	kubelitedbclientset clientset.Interface,
+	sqliteBackupInformer informers.SQLiteBackupInformer,
+	sqliteInstanceInformer informers.SQLiteInstanceInformer,
+	jobInformer batchinformers.JobInformer,
+	instanceSelector labels.Selector,
+	dryRun bool) *BackupController {
+
+	logger := klog.FromContext(ctx)
+
+	controller := &BackupController{
+		kubeclientset:       kubeclientset,
+		kubelitedbclientset: kubelitedbclientset,
+
+		sqliteBackupsLister:   sqliteBackupInformer.Lister(),
+		sqliteBackupsSynced:   sqliteBackupInformer.Informer().HasSynced,
+		sqliteInstancesLister: sqliteInstanceInformer.Lister(),
+		sqliteInstancesSynced: sqliteInstanceInformer.Informer().HasSynced,
+		jobsLister:            jobInformer.Lister(),
+		jobsSynced:            jobInformer.Informer().HasSynced,
+		instanceSelector:      instanceSelector,
+		workqueue:             workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "SQLiteBackups"),
+		recorder:              newEventRecorder(ctx, kubeclientset, backupControllerAgentName),
+		dryRun:                dryRun,
+	}
+
+	logger.Info("Setting up backup event handlers")
+	sqliteBackupInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.enqueueSQLiteBackup,
+		UpdateFunc: func(old, new interface{}) {
+			controller.enqueueSQLiteBackup(new)
+		},
+	})
+	jobInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.handleObject,
+		UpdateFunc: func(old, new interface{}) {
+			newMeta := new.(v1.Object)
+			oldMeta := old.(v1.Object)
+			if newMeta.GetResourceVersion() == oldMeta.GetResourceVersion() {
+				return
+			}
+			controller.handleObject(new)
+		},
+		DeleteFunc: controller.handleObject,
+	})
+
+	return controller
+}
+
+// Run syncs the informer caches and starts workers. It will block until the
+// context is cancelled, at which point it will shutdown the workqueue.
+func (c *BackupController) Run(ctx context.Context, workers int) error {
+	defer utilruntime.HandleCrash()
+	defer c.workqueue.ShutDown()
+	logger := klog.FromContext(ctx)
+
+	logger.Info("Starting KubeLiteDB backup controller")
+
+	logger.Info("Waiting for backup informer caches to sync")
+	if ok := cache.WaitForCacheSync(ctx.Done(), c.sqliteBackupsSynced, c.sqliteInstancesSynced, c.jobsSynced); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	logger.Info("Starting backup workers", "count", workers)
+	for i := 0; i < workers; i++ {
+		go wait.UntilWithContext(ctx, c.runWorker, time.Second)
+	}
+
+	<-ctx.Done()
+	logger.Info("Shutting down backup workers")
+
+	return nil
+}
+
+// runWorker processes items on the workqueue until it is shut down
+func (c *BackupController) runWorker(ctx context.Context) {
+	for c.processNextWorkItem(ctx) {
+	}
+}
+
+// processNextWorkItem will read a single work item off the workqueue and
+// attempt to process it, by calling the syncHandler.
+func (c *BackupController) processNextWorkItem(ctx context.Context) bool {
+	obj, shutdown := c.workqueue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.workqueue.Done(obj)
+
+	key, ok := obj.(string)
+	if !ok {
+		c.workqueue.Forget(obj)
+		utilruntime.HandleError(fmt.Errorf("expected string in workqueue but got %#v", obj))
+		return true
+	}
+	if err := c.syncHandler(ctx, key); err != nil {
+		c.workqueue.AddRateLimited(key)
+		utilruntime.HandleError(fmt.Errorf("error syncing backup '%s': %s, requeuing", key, err.Error()))
+		return true
+	}
+	c.workqueue.Forget(obj)
+	return true
+}
+
+// syncHandler runs the backup Job of the SQLiteBackup and records its outcome in
+// the status of the SQLiteBackup.
+func (c *BackupController) syncHandler(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("invalid resource key: %s", key))
+		return nil
+	}
+
+	sqliteBackup, err := c.sqliteBackupsLister.SQLiteBackups(namespace).Get(name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	// Completed and failed backups are never retried
+	if sqliteBackup.Status.Phase == BackupPhaseCompleted || sqliteBackup.Status.Phase == BackupPhaseFailed {
+		return nil
+	}
+
+	status := sqliteBackup.Status.DeepCopy()
+
+	sqliteInstance, err := c.sqliteInstancesLister.SQLiteInstances(namespace).Get(sqliteBackup.Spec.InstanceName)
+	if errors.IsNotFound(err) {
+		msg := fmt.Sprintf(MessageSourceNotFound, sqliteBackup.Spec.InstanceName)
+		status.Phase = BackupPhaseFailed
+		setBackupCondition(status, sqliteBackup, v1.ConditionFalse, ReasonSourceNotFound, msg)
+		c.recorder.Event(sqliteBackup, corev1.EventTypeWarning, ReasonSourceNotFound, msg)
+		return c.updateSQLiteBackupStatus(ctx, sqliteBackup, status)
+	}
+	if err != nil {
+		return err
+	}
+	// The SQLiteInstance is reconciled by another controller, which also
+	// takes its backups
+	if !c.instanceSelector.Matches(labels.Set(sqliteInstance.Labels)) {
+		return nil
+	}
+	// The backup Job mounts the data PVC, which doesn't exist in the
+	// DaemonSet mode
+	if daemonSetMode(sqliteInstance) {
+		msg := fmt.Sprintf(MessageDeploymentModeUnsupported, sqliteBackup.Spec.InstanceName)
+		status.Phase = BackupPhaseFailed
+		setBackupCondition(status, sqliteBackup, v1.ConditionFalse, ReasonDeploymentModeUnsupported, msg)
+		c.recorder.Event(sqliteBackup, corev1.EventTypeWarning, ReasonDeploymentModeUnsupported, msg)
+		return c.updateSQLiteBackupStatus(ctx, sqliteBackup, status)
+	}
+
+	job, err := c.jobsLister.Jobs(namespace).Get(backupJobName(sqliteBackup))
+	if errors.IsNotFound(err) && sqliteInstance.Spec.BackupEncryption != nil {
+		// The backup is never uploaded unencrypted, so it fails rather than
+		// waiting for the key.
+		msg, keyErr := checkBackupEncryptionKey(ctx, c.kubeclientset, namespace, sqliteInstance.Spec.BackupEncryption)
+		if keyErr != nil {
+			return keyErr
+		}
+		if msg != "" {
+			status.Phase = BackupPhaseFailed
+			setBackupCondition(status, sqliteBackup, v1.ConditionFalse, ReasonEncryptionKeyInvalid, msg)
+			c.recorder.Event(sqliteBackup, corev1.EventTypeWarning, ReasonEncryptionKeyInvalid, msg)
+			return c.updateSQLiteBackupStatus(ctx, sqliteBackup, status)
+		}
+	}
+	if errors.IsNotFound(err) {
+		job, err = c.kubeclientset.BatchV1().Jobs(namespace).Create(ctx, newBackupJob(sqliteBackup, sqliteInstance), c.writer().createOptions(ctx, sqliteBackup, "Job", backupJobName(sqliteBackup)))
+	}
+	if err != nil {
+		return err
+	}
+
+	if !v1.IsControlledBy(job, sqliteBackup) {
+		msg := fmt.Sprintf(MessageResourceExists, job.Name)
+		c.recorder.Event(sqliteBackup, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return fmt.Errorf("%s", msg)
+	}
+
+	switch {
+	case jobHasCondition(job, batchv1.JobComplete):
+		status.Phase = BackupPhaseCompleted
+		status.ArtifactPath = backupArtifactPath(sqliteBackup, sqliteInstance)
+		status.CompletionTime = job.Status.CompletionTime
+		if status.CompletionTime == nil {
+			now := v1.Now()
+			status.CompletionTime = &now
+		}
+		// The SQLiteBackup is not synced again once completed, so the
+		// backup is recorded on the SQLiteInstance first.
+		if err := c.recordInstanceBackup(ctx, sqliteInstance, *status.CompletionTime, status.ArtifactPath); err != nil {
+			return err
+		}
+		setBackupCondition(status, sqliteBackup, v1.ConditionTrue, ReasonJobSucceeded, "")
+		c.recorder.Eventf(sqliteBackup, corev1.EventTypeNormal, ReasonJobSucceeded, MessageBackupCompleted, status.ArtifactPath)
+	case jobHasCondition(job, batchv1.JobFailed):
+		status.Phase = BackupPhaseFailed
+		setBackupCondition(status, sqliteBackup, v1.ConditionFalse, ReasonJobFailed, fmt.Sprintf("Job %q failed", job.Name))
+		c.recorder.Eventf(sqliteBackup, corev1.EventTypeWarning, ReasonJobFailed, "Job %q failed", job.Name)
+	case job.Status.Active > 0:
+		status.Phase = BackupPhaseRunning
+		setBackupCondition(status, sqliteBackup, v1.ConditionFalse, ReasonJobRunning, "")
+	default:
+		status.Phase = BackupPhasePending
+		setBackupCondition(status, sqliteBackup, v1.ConditionFalse, ReasonJobRunning, "")
+	}
+
+	return c.updateSQLiteBackupStatus(ctx, sqliteBackup, status)
+}
+
+// setBackupCondition sets the Complete condition on the status of the SQLiteBackup
+func setBackupCondition(status *kubelitedbv1.SQLiteBackupStatus, backup *kubelitedbv1.SQLiteBackup, conditionStatus v1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&status.Conditions, v1.Condition{
+		Type:               kubelitedbv1.ConditionComplete,
+		Status:             conditionStatus,
+		ObservedGeneration: backup.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// updateSQLiteBackupStatus writes the given status to the SQLiteBackup if it changed
+func (c *BackupController) updateSQLiteBackupStatus(ctx context.Context, sqliteBackup *kubelitedbv1.SQLiteBackup, status *kubelitedbv1.SQLiteBackupStatus) error {
+	if equality.Semantic.DeepEqual(sqliteBackup.Status, *status) {
+		return nil
+	}
+	sqliteBackupCopy := sqliteBackup.DeepCopy()
+	sqliteBackupCopy.Status = *status
+	_, err := c.kubelitedbclientset.KubelitedbV1().SQLiteBackups(sqliteBackup.Namespace).UpdateStatus(ctx, sqliteBackupCopy, c.writer().updateOptions(ctx, sqliteBackup, "SQLiteBackup", sqliteBackup, sqliteBackupCopy))
+	return err
+}
+
+// jobHasCondition returns whether the Job has a true condition of the given type
+func jobHasCondition(job *batchv1.Job, conditionType batchv1.JobConditionType) bool {
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == conditionType && condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// backupJobName returns the name of the Job running the given SQLiteBackup
+func backupJobName(backup *kubelitedbv1.SQLiteBackup) string {
+	return fmt.Sprintf("%s-backup", backup.Name)
+}
+
+// dataPVCName returns the name of the PVC created from the volume claim
+// template for the pod with the given ordinal, or the name of the volume shared
+// by every pod.
+func dataPVCName(instance *kubelitedbv1.SQLiteInstance, ordinal int) string {
+	if instance.Spec.VolumeClaimName != "" {
+		return instance.Spec.VolumeClaimName
+	}
+	if sharesVolume(instance) {
+		return fmt.Sprintf("%s-%s", dataVolumeName, statefulSetName(instance))
+	}
+	return fmt.Sprintf("%s-%s-%d", dataVolumeName, statefulSetName(instance), ordinal)
+}
+
+// backupArtifactKey returns the key of the backup within its bucket
+func backupArtifactKey(backup *kubelitedbv1.SQLiteBackup, instance *kubelitedbv1.SQLiteInstance) string {
+	prefix := backup.Spec.Path
+	if prefix == "" {
+		prefix = path.Join(instance.Namespace, instance.Name)
+	}
+	return path.Join(prefix, backup.Name, instance.Spec.DbName)
+}
+
+// backupArtifactPath returns the URL of the uploaded backup
+func backupArtifactPath(backup *kubelitedbv1.SQLiteBackup, instance *kubelitedbv1.SQLiteInstance) string {
+	return fmt.Sprintf("s3://%s/%s", backup.Spec.Bucket, backupArtifactKey(backup, instance))
+}
+
+// newBackupJob creates the Job backing up the database of the SQLiteInstance.
+func newBackupJob(backup *kubelitedbv1.SQLiteBackup, instance *kubelitedbv1.SQLiteInstance) *batchv1.Job {
+	uploadArgs := []string{"s3", "cp", backupSnapshotPath(instance), backupArtifactPath(backup, instance)}
+	if backup.Spec.Endpoint != "" {
+		uploadArgs = append(uploadArgs, "--endpoint-url", backup.Spec.Endpoint)
+	}
+	backoffLimit := int32(2)
+
+	return &batchv1.Job{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      backupJobName(backup),
+			Namespace: backup.Namespace,
+			OwnerReferences: []v1.OwnerReference{
+				*v1.NewControllerRef(backup, kubelitedbv1.SchemeGroupVersion.WithKind("SQLiteBackup")),
+			},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				Spec: newBackupPodSpec(instance, corev1.Container{
+					Name:  "upload",
+					Image: awsCLIImage,
+					Args:  uploadArgs,
+					Env:   objectStorageCredentials(backup.Spec.SecretRef),
+				}),
+			},
+		},
+	}
+}
+
+// backupSnapshotPath returns where the snapshot of the database is written
+// before it is uploaded
+func backupSnapshotPath(instance *kubelitedbv1.SQLiteInstance) string {
+	return path.Join(backupMountPath, instance.Spec.DbName)
+}
+
+// newBackupPodSpec creates the spec of a pod backing up the database of the
+// SQLiteInstance. The snapshot is uploaded to object storage by the given
+// container, encrypted first when spec.backupEncryption is set.
+func newBackupPodSpec(instance *kubelitedbv1.SQLiteInstance, upload corev1.Container) corev1.PodSpec {
+	spec := newSnapshotPodSpec(instance, upload)
+	if instance.Spec.BackupEncryption != nil {
+		addBackupEncryption(instance, &spec)
+	}
+	setSecurityContexts(instance, &spec)
+	return spec
+}
+
+// newSnapshotPodSpec creates the spec of a pod taking a consistent snapshot
+// of the database of the SQLiteInstance with the SQLite backup API from the
+// volume of the first pod, which is then read by the given container from
+// backupSnapshotPath. The pod runs on the node of the first pod, as the
+// volume may only be mounted from a single node.
+func newSnapshotPodSpec(instance *kubelitedbv1.SQLiteInstance, upload corev1.Container) corev1.PodSpec {
+	snapshotPath := backupSnapshotPath(instance)
+	upload.VolumeMounts = append(upload.VolumeMounts, corev1.VolumeMount{
+		Name:      backupVolumeName,
+		MountPath: backupMountPath,
+		ReadOnly:  true,
+	})
+
+	spec := corev1.PodSpec{
+		RestartPolicy: corev1.RestartPolicyNever,
+		// The snapshot is taken with the SQLite image
+		ImagePullSecrets: instance.Spec.ImagePullSecrets,
+		// The upload may authenticate as the service account, for example
+		// with workload identity
+		ServiceAccountName: instance.Spec.ServiceAccountName,
+		Affinity: &corev1.Affinity{
+			PodAffinity: &corev1.PodAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{
+					{
+						LabelSelector: &v1.LabelSelector{
+							MatchLabels: map[string]string{
+								"statefulset.kubernetes.io/pod-name": fmt.Sprintf("%s-0", statefulSetName(instance)),
+							},
+						},
+						TopologyKey: corev1.LabelHostname,
+					},
+				},
+			},
+		},
+		InitContainers: []corev1.Container{
+			{
+				Name:    "snapshot",
+				Image:   imageForInstance(instance),
+				Command: []string{"sqlite3", databasePath(instance), fmt.Sprintf(".backup %s", snapshotPath)},
+				VolumeMounts: []corev1.VolumeMount{
+					dataVolumeMount(instance),
+					{Name: backupVolumeName, MountPath: backupMountPath},
+				},
+			},
+		},
+		Containers: []corev1.Container{upload},
+		Volumes: []corev1.Volume{
+			{
+				Name: dataVolumeName,
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+						ClaimName: dataPVCName(instance, 0),
+					},
+				},
+			},
+			{
+				Name: backupVolumeName,
+				VolumeSource: corev1.VolumeSource{
+					EmptyDir: &corev1.EmptyDirVolumeSource{},
+				},
+			},
+		},
+	}
+	return spec
+}
+
+// enqueueSQLiteBackup puts the key of the SQLiteBackup onto the workqueue
+func (c *BackupController) enqueueSQLiteBackup(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.workqueue.Add(key)
+}
+
+// handleObject enqueues the SQLiteBackup owning the given Job, if any
+func (c *BackupController) handleObject(obj interface{}) {
+	object, ok := obj.(v1.Object)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("error decoding object, invalid type"))
+			return
+		}
+		object, ok = tombstone.Obj.(v1.Object)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("error decoding object tombstone, invalid type"))
+			return
+		}
+	}
+
+	ownerRef := v1.GetControllerOf(object)
+	if ownerRef == nil || ownerRef.Kind != "SQLiteBackup" {
+		return
+	}
+	sqliteBackup, err := c.sqliteBackupsLister.SQLiteBackups(object.GetNamespace()).Get(ownerRef.Name)
+	if err != nil {
+		return
+	}
+	c.enqueueSQLiteBackup(sqliteBackup)
+}
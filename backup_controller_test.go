@@ -0,0 +1,296 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubeinformers "k8s.io/client-go/informers"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+	"github.com/fortytwoapps/kubelitedb/pkg/generated/clientset/versioned/fake"
+	informers "github.com/fortytwoapps/kubelitedb/pkg/generated/informers/externalversions"
+)
+
+type backupFixture struct {
+	t *testing.T
+
+	client     *fake.Clientset
+	kubeclient *k8sfake.Clientset
+
+	backups     []*kubelitedbv1.SQLiteBackup
+	instances   []*kubelitedbv1.SQLiteInstance
+	jobs        []*batchv1.Job
+	kubeobjects []runtime.Object
+
+	dryRun bool
+	// instanceSelector matches the SQLiteInstances backed up, every one when
+	// nil
+	instanceSelector labels.Selector
+}
+
+func newBackupFixture(t *testing.T) *backupFixture {
+	return &backupFixture{t: t}
+}
+
+func (f *backupFixture) addBackup(backup *kubelitedbv1.SQLiteBackup) {
+	f.backups = append(f.backups, backup)
+}
+
+func (f *backupFixture) addInstance(instance *kubelitedbv1.SQLiteInstance) {
+	f.instances = append(f.instances, instance)
+}
+
+func (f *backupFixture) addJob(job *batchv1.Job) {
+	f.jobs = append(f.jobs, job)
+	f.kubeobjects = append(f.kubeobjects, job)
+}
+
+func newSQLiteBackup(name, instanceName string) *kubelitedbv1.SQLiteBackup {
+	return &kubelitedbv1.SQLiteBackup{
+		TypeMeta: v1.TypeMeta{APIVersion: kubelitedbv1.SchemeGroupVersion.String(), Kind: "SQLiteBackup"},
+		ObjectMeta: v1.ObjectMeta{
+			Name:       name,
+			Namespace:  v1.NamespaceDefault,
+			UID:        types.UID(name + "-uid"),
+			Generation: 1,
+		},
+		Spec: kubelitedbv1.SQLiteBackupSpec{
+			InstanceName: instanceName,
+			Bucket:       "backups",
+			SecretRef:    corev1.LocalObjectReference{Name: "s3-credentials"},
+		},
+	}
+}
+
+func (f *backupFixture) newController(ctx context.Context) *BackupController {
+	var objects []runtime.Object
+	for _, backup := range f.backups {
+		objects = append(objects, backup)
+	}
+	for _, instance := range f.instances {
+		objects = append(objects, instance)
+	}
+	f.client = fake.NewSimpleClientset(objects...)
+	f.kubeclient = k8sfake.NewSimpleClientset(f.kubeobjects...)
+
+	i := informers.NewSharedInformerFactory(f.client, noResyncPeriodFunc())
+	k8sI := kubeinformers.NewSharedInformerFactory(f.kubeclient, noResyncPeriodFunc())
+
+	selector := f.instanceSelector
+	if selector == nil {
+		selector = labels.Everything()
+	}
+	c := NewBackupController(ctx, f.kubeclient, f.client,
+		i.Kubelitedb().V1().SQLiteBackups(),
+		i.Kubelitedb().V1().SQLiteInstances(),
+		k8sI.Batch().V1().Jobs(),
+		selector,
+		f.dryRun,
+	)
+	c.sqliteBackupsSynced = alwaysReady
+	c.sqliteInstancesSynced = alwaysReady
+	c.jobsSynced = alwaysReady
+	c.recorder = record.NewFakeRecorder(100)
+
+	for _, backup := range f.backups {
+		i.Kubelitedb().V1().SQLiteBackups().Informer().GetIndexer().Add(backup)
+	}
+	for _, instance := range f.instances {
+		i.Kubelitedb().V1().SQLiteInstances().Informer().GetIndexer().Add(instance)
+	}
+	for _, job := range f.jobs {
+		k8sI.Batch().V1().Jobs().Informer().GetIndexer().Add(job)
+	}
+
+	return c
+}
+
+// run syncs the SQLiteBackup and returns it as written to the fake clientset.
+func (f *backupFixture) run(ctx context.Context, c *BackupController, backup *kubelitedbv1.SQLiteBackup) *kubelitedbv1.SQLiteBackup {
+	f.t.Helper()
+	key, err := cache.MetaNamespaceKeyFunc(backup)
+	if err != nil {
+		f.t.Fatalf("error getting the key of %s: %v", backup.Name, err)
+	}
+	if err := c.syncHandler(ctx, key); err != nil {
+		f.t.Fatalf("error syncing %s: %v", key, err)
+	}
+	got, err := f.client.KubelitedbV1().SQLiteBackups(backup.Namespace).Get(ctx, backup.Name, v1.GetOptions{})
+	if err != nil {
+		f.t.Fatalf("error getting SQLiteBackup %s: %v", backup.Name, err)
+	}
+	return got
+}
+
+func TestBackupSourceNotFound(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newBackupFixture(t)
+	backup := newSQLiteBackup("nightly", "missing")
+	f.addBackup(backup)
+	c := f.newController(ctx)
+
+	got := f.run(ctx, c, backup)
+
+	if got.Status.Phase != BackupPhaseFailed {
+		t.Errorf("expected phase %s, got %q", BackupPhaseFailed, got.Status.Phase)
+	}
+	condition := meta.FindStatusCondition(got.Status.Conditions, kubelitedbv1.ConditionComplete)
+	if condition == nil || condition.Status != v1.ConditionFalse || condition.Reason != ReasonSourceNotFound {
+		t.Errorf("expected condition False with reason %s, got %v", ReasonSourceNotFound, condition)
+	}
+	if actions := writes(f.kubeclient.Actions(), "jobs"); len(actions) != 0 {
+		t.Errorf("expected no Job writes, got %v", actions)
+	}
+}
+
+func TestBackupCreatesJob(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newBackupFixture(t)
+	instance := newSQLiteInstance("test")
+	backup := newSQLiteBackup("nightly", instance.Name)
+	f.addInstance(instance)
+	f.addBackup(backup)
+	c := f.newController(ctx)
+
+	got := f.run(ctx, c, backup)
+
+	job, err := f.kubeclient.BatchV1().Jobs(backup.Namespace).Get(ctx, backupJobName(backup), v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("error getting the backup Job: %v", err)
+	}
+	if !v1.IsControlledBy(job, backup) {
+		t.Errorf("expected the Job to be controlled by the SQLiteBackup")
+	}
+	var claim string
+	for _, volume := range job.Spec.Template.Spec.Volumes {
+		if volume.Name == dataVolumeName && volume.PersistentVolumeClaim != nil {
+			claim = volume.PersistentVolumeClaim.ClaimName
+		}
+	}
+	if want := dataPVCName(instance, 0); claim != want {
+		t.Errorf("expected the Job to mount PVC %s, got %q", want, claim)
+	}
+	upload := container(t, job.Spec.Template.Spec.Containers, "upload")
+	if want := "s3://backups/default/test/nightly/app.db"; !slices.Contains(upload.Args, want) {
+		t.Errorf("expected the upload to %s, got %v", want, upload.Args)
+	}
+	if got.Status.Phase != BackupPhasePending {
+		t.Errorf("expected phase %s, got %q", BackupPhasePending, got.Status.Phase)
+	}
+}
+
+func TestBackupJobStatus(t *testing.T) {
+	completed := v1.NewTime(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	tests := []struct {
+		name     string
+		status   batchv1.JobStatus
+		phase    string
+		reason   string
+		artifact string
+	}{
+		{name: "pending", phase: BackupPhasePending, reason: ReasonJobRunning},
+		{name: "running", status: batchv1.JobStatus{Active: 1}, phase: BackupPhaseRunning, reason: ReasonJobRunning},
+		{
+			name: "complete",
+			status: batchv1.JobStatus{
+				CompletionTime: &completed,
+				Conditions:     []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}},
+			},
+			phase:    BackupPhaseCompleted,
+			reason:   ReasonJobSucceeded,
+			artifact: "s3://backups/default/test/nightly/app.db",
+		},
+		{
+			name:   "failed",
+			status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}}},
+			phase:  BackupPhaseFailed,
+			reason: ReasonJobFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newBackupFixture(t)
+			instance := newSQLiteInstance("test")
+			backup := newSQLiteBackup("nightly", instance.Name)
+			job := newBackupJob(backup, instance)
+			job.Status = tt.status
+			f.addInstance(instance)
+			f.addBackup(backup)
+			f.addJob(job)
+			c := f.newController(ctx)
+
+			got := f.run(ctx, c, backup)
+
+			if got.Status.Phase != tt.phase {
+				t.Errorf("expected phase %s, got %q", tt.phase, got.Status.Phase)
+			}
+			condition := meta.FindStatusCondition(got.Status.Conditions, kubelitedbv1.ConditionComplete)
+			if condition == nil || condition.Reason != tt.reason {
+				t.Errorf("expected reason %s, got %v", tt.reason, condition)
+			}
+			if got.Status.ArtifactPath != tt.artifact {
+				t.Errorf("expected artifact %q, got %q", tt.artifact, got.Status.ArtifactPath)
+			}
+			if tt.phase == BackupPhaseCompleted && (got.Status.CompletionTime == nil || !got.Status.CompletionTime.Equal(&completed)) {
+				t.Errorf("expected completion time %v, got %v", completed, got.Status.CompletionTime)
+			}
+			if actions := writes(f.kubeclient.Actions(), "jobs"); len(actions) != 0 {
+				t.Errorf("expected no Job writes, got %v", actions)
+			}
+		})
+	}
+}
+
+func TestBackupFinishedIsNotRetried(t *testing.T) {
+	for _, phase := range []string{BackupPhaseCompleted, BackupPhaseFailed} {
+		t.Run(phase, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newBackupFixture(t)
+			instance := newSQLiteInstance("test")
+			backup := newSQLiteBackup("nightly", instance.Name)
+			backup.Status.Phase = phase
+			f.addInstance(instance)
+			f.addBackup(backup)
+			c := f.newController(ctx)
+
+			f.run(ctx, c, backup)
+
+			if actions := writes(f.kubeclient.Actions(), "jobs"); len(actions) != 0 {
+				t.Errorf("expected no Job writes, got %v", actions)
+			}
+			if actions := writes(f.client.Actions(), "sqlitebackups"); len(actions) != 0 {
+				t.Errorf("expected no SQLiteBackup writes, got %v", actions)
+			}
+		})
+	}
+}
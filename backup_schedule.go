@@ -0,0 +1,253 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+	"github.com/fortytwoapps/kubelitedb/pkg/validation"
+)
+
+const (
+	// ReasonBackupScheduled is used as the condition reason when the backup
+	// CronJob is in sync with the schedule
+	ReasonBackupScheduled = "BackupScheduled"
+	// ReasonInvalidBackupSchedule is used as the condition reason when the
+	// backup schedule or destination is invalid
+	ReasonInvalidBackupSchedule = "InvalidBackupSchedule"
+
+	// MessageInvalidBackupSchedule is the message used when the backup
+	// schedule or destination is invalid
+	MessageInvalidBackupSchedule = "Invalid backup schedule: %v"
+)
+
+// backupCronJobTemplateHashAnnotation records the hash of the job template of
+// the backup CronJob, so changes to the spec roll out to it
+const backupCronJobTemplateHashAnnotation = "kubelitedb.fortytwoapps.tech/job-template-hash"
+
+// scheduledUploadScript uploads the snapshot under a key unique to the run,
+// so scheduled backups don't overwrite each other. Once uploaded, the runs
+// beyond RETENTION_COUNT or older than RETENTION_MAX_AGE_SECONDS are pruned.
+// Failing to prune a run does not fail the backup, the run is pruned again by
+// the next one.
+const scheduledUploadScript = `set -e
+aws s3 cp "$SNAPSHOT_PATH" "$BACKUP_URL/$(date -u +%Y%m%dT%H%M%SZ)/$(basename "$SNAPSHOT_PATH")" ${BACKUP_ENDPOINT:+--endpoint-url "$BACKUP_ENDPOINT"}
+
+if [ -z "$RETENTION_COUNT" ] && [ -z "$RETENTION_MAX_AGE_SECONDS" ]; then
+  exit 0
+fi
+cutoff=0
+if [ -n "$RETENTION_MAX_AGE_SECONDS" ]; then
+  cutoff=$(date -u -d "@$(( $(date -u +%s) - RETENTION_MAX_AGE_SECONDS ))" +%Y%m%d%H%M%S)
+fi
+listing=$(aws s3 ls "$BACKUP_URL/" ${BACKUP_ENDPOINT:+--endpoint-url "$BACKUP_ENDPOINT"}) || {
+  echo "Failed to list backups, skipping pruning" >&2
+  exit 0
+}
+n=0
+for run in $(echo "$listing" | awk '$1 == "PRE" && $2 ~ /^[0-9]+T[0-9]+Z\/$/ { print $2 }' | sort -r); do
+  n=$((n + 1))
+  run=${run%/}
+  if { [ -n "$RETENTION_COUNT" ] && [ "$n" -gt "$RETENTION_COUNT" ]; } || [ "$(echo "$run" | tr -d TZ)" -lt "$cutoff" ]; then
+    echo "Pruning backup $run"
+    aws s3 rm --recursive "$BACKUP_URL/$run/" ${BACKUP_ENDPOINT:+--endpoint-url "$BACKUP_ENDPOINT"} || echo "Failed to prune backup $run" >&2
+  fi
+done
+`
+
+// backupCronJobName returns the name of the CronJob taking the scheduled
+// backups of the SQLiteInstance
+func backupCronJobName(instance *kubelitedbv1.SQLiteInstance) string {
+	return fmt.Sprintf("%s-backup", instance.Name)
+}
+
+// scheduledBackupURL returns the URL scheduled backups of the SQLiteInstance
+// are uploaded under
+func scheduledBackupURL(instance *kubelitedbv1.SQLiteInstance) string {
+	destination := instance.Spec.BackupDestination
+	prefix := destination.Path
+	if prefix == "" {
+		prefix = path.Join(instance.Namespace, instance.Name)
+	}
+	return fmt.Sprintf("s3://%s/%s", destination.Bucket, prefix)
+}
+
+// newBackupCronJob creates the CronJob backing up the database of the
+// SQLiteInstance on its backup schedule. Runs never overlap, as they would
+// upload the same snapshot.
+func newBackupCronJob(instance *kubelitedbv1.SQLiteInstance) *batchv1.CronJob {
+	destination := instance.Spec.BackupDestination
+	env := []corev1.EnvVar{
+		{Name: "SNAPSHOT_PATH", Value: backupSnapshotPath(instance)},
+		{Name: "BACKUP_URL", Value: scheduledBackupURL(instance)},
+		{Name: "BACKUP_ENDPOINT", Value: destination.Endpoint},
+	}
+	env = append(env, backupRetentionEnv(instance.Spec.BackupRetention)...)
+	env = append(env, objectStorageCredentials(destination.SecretRef)...)
+	backoffLimit := int32(2)
+
+	jobTemplate := batchv1.JobTemplateSpec{
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: v1.ObjectMeta{
+					Labels:      childLabels(instance, labelsForInstance(instance, componentBackup)),
+					Annotations: childAnnotations(instance),
+				},
+				Spec: newBackupPodSpec(instance, corev1.Container{
+					Name:    "upload",
+					Image:   awsCLIImage,
+					Command: []string{"/bin/sh", "-c", scheduledUploadScript},
+					Env:     env,
+				}),
+			},
+		},
+	}
+	jobTemplate.Annotations = map[string]string{
+		backupCronJobTemplateHashAnnotation: computeHash(jobTemplate),
+	}
+
+	return &batchv1.CronJob{
+		ObjectMeta: v1.ObjectMeta{
+			Name:            backupCronJobName(instance),
+			Namespace:       instance.Namespace,
+			Labels:          childLabels(instance, labelsForInstance(instance, componentBackup)),
+			Annotations:     childAnnotations(instance),
+			OwnerReferences: []v1.OwnerReference{newOwnerReference(instance)},
+		},
+		Spec: batchv1.CronJobSpec{
+			Schedule:          instance.Spec.BackupSchedule,
+			ConcurrencyPolicy: batchv1.ForbidConcurrent,
+			JobTemplate:       jobTemplate,
+		},
+	}
+}
+
+// backupRetentionEnv returns the environment variables configuring the
+// pruning of scheduled backups for the given retention.
+func backupRetentionEnv(retention *kubelitedbv1.BackupRetention) []corev1.EnvVar {
+	if retention == nil {
+		return nil
+	}
+	var env []corev1.EnvVar
+	if retention.Count != nil {
+		env = append(env, corev1.EnvVar{Name: "RETENTION_COUNT", Value: strconv.Itoa(int(*retention.Count))})
+	}
+	if retention.MaxAge != nil {
+		env = append(env, corev1.EnvVar{Name: "RETENTION_MAX_AGE_SECONDS", Value: strconv.FormatInt(int64(retention.MaxAge.Seconds()), 10)})
+	}
+	return env
+}
+
+// syncBackupCronJob creates, updates or deletes the CronJob taking the
+// scheduled backups of the SQLiteInstance, and records the outcome in the
+// BackupScheduled condition. The condition is removed when no schedule is set.
+// An invalid schedule leaves the CronJob as is, as it will not become valid by
+// retrying.
+func (c *Controller) syncBackupCronJob(ctx context.Context, sqliteInstance *kubelitedbv1.SQLiteInstance, status *kubelitedbv1.SQLiteInstanceStatus) error {
+	spec := sqliteInstance.Spec
+	scheduled := spec.BackupSchedule != ""
+	if scheduled || spec.BackupDestination != nil {
+		if errs := validation.ValidateBackupSchedule(spec.BackupSchedule, spec.BackupDestination, field.NewPath("spec")); len(errs) > 0 {
+			msg := fmt.Sprintf(MessageInvalidBackupSchedule, errs.ToAggregate())
+			setCondition(status, sqliteInstance, kubelitedbv1.ConditionBackupScheduled, v1.ConditionFalse, ReasonInvalidBackupSchedule, msg)
+			c.recorder.Event(sqliteInstance, corev1.EventTypeWarning, ReasonInvalidBackupSchedule, msg)
+			return nil
+		}
+	}
+
+	// The CronJob is left as is until the key can be used, so backups are
+	// never uploaded unencrypted.
+	if scheduled && spec.BackupEncryption != nil {
+		msg, err := checkBackupEncryptionKey(ctx, c.kubeclientset, sqliteInstance.Namespace, spec.BackupEncryption)
+		if err != nil {
+			return err
+		}
+		if msg != "" {
+			setCondition(status, sqliteInstance, kubelitedbv1.ConditionBackupScheduled, v1.ConditionFalse, ReasonEncryptionKeyInvalid, msg)
+			c.recorder.Event(sqliteInstance, corev1.EventTypeWarning, ReasonEncryptionKeyInvalid, msg)
+			return nil
+		}
+	}
+
+	cronJobs := c.kubeclientset.BatchV1().CronJobs(sqliteInstance.Namespace)
+	cronJob, err := cronJobs.Get(ctx, backupCronJobName(sqliteInstance), v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		if !scheduled {
+			meta.RemoveStatusCondition(&status.Conditions, kubelitedbv1.ConditionBackupScheduled)
+			return nil
+		}
+		_, err = cronJobs.Create(ctx, newBackupCronJob(sqliteInstance), c.createOptions(ctx, sqliteInstance, "CronJob", backupCronJobName(sqliteInstance)))
+		logWrite(ctx, "create", "CronJob", backupCronJobName(sqliteInstance), err)
+		if err != nil {
+			return err
+		}
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionBackupScheduled, v1.ConditionTrue, ReasonBackupScheduled, "")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if !v1.IsControlledBy(cronJob, sqliteInstance) {
+		msg := fmt.Sprintf(MessageResourceExists, cronJob.Name)
+		c.recorder.Event(sqliteInstance, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return fmt.Errorf("%s", msg)
+	}
+
+	if last := cronJob.Status.LastSuccessfulTime; last != nil {
+		recordBackup(status, *last, scheduledBackupURL(sqliteInstance)+"/")
+	}
+
+	if !scheduled {
+		err = cronJobs.Delete(ctx, cronJob.Name, c.deleteOptions(ctx, sqliteInstance, "CronJob", cronJob.Name))
+		logWrite(ctx, "delete", "CronJob", cronJob.Name, err)
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		meta.RemoveStatusCondition(&status.Conditions, kubelitedbv1.ConditionBackupScheduled)
+		return nil
+	}
+
+	desired := newBackupCronJob(sqliteInstance)
+	if cronJob.Spec.Schedule != desired.Spec.Schedule ||
+		cronJob.Spec.JobTemplate.Annotations[backupCronJobTemplateHashAnnotation] != desired.Spec.JobTemplate.Annotations[backupCronJobTemplateHashAnnotation] ||
+		metadataOutOfDate(cronJob, desired) {
+		cronJobCopy := cronJob.DeepCopy()
+		mergeMetadata(cronJobCopy, desired)
+		cronJobCopy.Spec.Schedule = desired.Spec.Schedule
+		cronJobCopy.Spec.JobTemplate = desired.Spec.JobTemplate
+		_, err = cronJobs.Update(ctx, cronJobCopy, c.updateOptions(ctx, sqliteInstance, "CronJob", cronJob, cronJobCopy))
+		logWrite(ctx, "update", "CronJob", cronJob.Name, err)
+		if err != nil {
+			return err
+		}
+	}
+	setCondition(status, sqliteInstance, kubelitedbv1.ConditionBackupScheduled, v1.ConditionTrue, ReasonBackupScheduled, "")
+	return nil
+}
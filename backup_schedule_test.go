@@ -0,0 +1,276 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// withBackupSchedule sets the backup schedule of the SQLiteInstance, with a
+// destination when the schedule is set.
+func withBackupSchedule(instance *kubelitedbv1.SQLiteInstance, schedule string) *kubelitedbv1.SQLiteInstance {
+	instance.Spec.BackupSchedule = schedule
+	instance.Spec.BackupDestination = nil
+	if schedule != "" {
+		instance.Spec.BackupDestination = &kubelitedbv1.BackupDestination{
+			Bucket:    "backups",
+			SecretRef: corev1.LocalObjectReference{Name: "s3-credentials"},
+		}
+	}
+	return instance
+}
+
+func TestSyncBackupCronJob(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string
+		schedule string
+		writes   []string
+		want     string
+		reason   string
+	}{
+		{name: "no schedule"},
+		{name: "create", schedule: "0 3 * * *", writes: []string{"create"}, want: "0 3 * * *", reason: ReasonBackupScheduled},
+		{name: "unchanged", existing: "0 3 * * *", schedule: "0 3 * * *", want: "0 3 * * *", reason: ReasonBackupScheduled},
+		{name: "update", existing: "0 3 * * *", schedule: "*/30 * * * *", writes: []string{"update"}, want: "*/30 * * * *", reason: ReasonBackupScheduled},
+		{name: "remove", existing: "0 3 * * *", schedule: "", writes: []string{"delete"}},
+		{name: "invalid", existing: "0 3 * * *", schedule: "every night", want: "0 3 * * *", reason: ReasonInvalidBackupSchedule},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := withBackupSchedule(newSQLiteInstance("test"), tt.schedule)
+			if tt.existing != "" {
+				f.addKubeObject(newBackupCronJob(withBackupSchedule(newSQLiteInstance("test"), tt.existing)))
+			}
+			c, _, _ := f.newController(ctx)
+			status := instance.Status.DeepCopy()
+
+			if err := c.syncBackupCronJob(ctx, instance, status); err != nil {
+				t.Fatalf("error syncing the backup CronJob: %v", err)
+			}
+
+			var verbs []string
+			for _, action := range writes(f.kubeclient.Actions(), "cronjobs") {
+				verbs = append(verbs, action.GetVerb())
+			}
+			if !slices.Equal(verbs, tt.writes) {
+				t.Errorf("expected CronJob writes %v, got %v", tt.writes, verbs)
+			}
+
+			cronJob, err := f.kubeclient.BatchV1().CronJobs(instance.Namespace).Get(ctx, backupCronJobName(instance), v1.GetOptions{})
+			if tt.want == "" {
+				if !errors.IsNotFound(err) {
+					t.Errorf("expected no backup CronJob, got %v", err)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("error getting the backup CronJob: %v", err)
+				}
+				if cronJob.Spec.Schedule != tt.want {
+					t.Errorf("expected schedule %q, got %q", tt.want, cronJob.Spec.Schedule)
+				}
+				if cronJob.Spec.ConcurrencyPolicy != batchv1.ForbidConcurrent {
+					t.Errorf("expected runs not to overlap, got %s", cronJob.Spec.ConcurrencyPolicy)
+				}
+			}
+
+			condition := meta.FindStatusCondition(status.Conditions, kubelitedbv1.ConditionBackupScheduled)
+			if tt.reason == "" {
+				if condition != nil {
+					t.Errorf("expected no %s condition, got %v", kubelitedbv1.ConditionBackupScheduled, condition)
+				}
+				return
+			}
+			if condition == nil || condition.Reason != tt.reason {
+				t.Errorf("expected reason %s, got %v", tt.reason, condition)
+			}
+		})
+	}
+}
+
+func TestBackupCronJobUploadsUnderDestination(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{name: "default path", want: "s3://backups/default/test"},
+		{name: "explicit path", path: "nightly", want: "s3://backups/nightly"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := withBackupSchedule(newSQLiteInstance("test"), "0 3 * * *")
+			instance.Spec.BackupDestination.Path = tt.path
+
+			cronJob := newBackupCronJob(instance)
+
+			upload := container(t, cronJob.Spec.JobTemplate.Spec.Template.Spec.Containers, "upload")
+			var url string
+			for _, env := range upload.Env {
+				if env.Name == "BACKUP_URL" {
+					url = env.Value
+				}
+			}
+			if url != tt.want {
+				t.Errorf("expected BACKUP_URL %q, got %q", tt.want, url)
+			}
+		})
+	}
+}
+
+func TestBackupRetentionEnv(t *testing.T) {
+	count := int32(3)
+	tests := []struct {
+		name      string
+		retention *kubelitedbv1.BackupRetention
+		want      []corev1.EnvVar
+	}{
+		{name: "no retention"},
+		{
+			name:      "count",
+			retention: &kubelitedbv1.BackupRetention{Count: &count},
+			want:      []corev1.EnvVar{{Name: "RETENTION_COUNT", Value: "3"}},
+		},
+		{
+			name:      "max age",
+			retention: &kubelitedbv1.BackupRetention{MaxAge: &v1.Duration{Duration: 7 * 24 * time.Hour}},
+			want:      []corev1.EnvVar{{Name: "RETENTION_MAX_AGE_SECONDS", Value: "604800"}},
+		},
+		{
+			name:      "count and max age",
+			retention: &kubelitedbv1.BackupRetention{Count: &count, MaxAge: &v1.Duration{Duration: time.Hour}},
+			want:      []corev1.EnvVar{{Name: "RETENTION_COUNT", Value: "3"}, {Name: "RETENTION_MAX_AGE_SECONDS", Value: "3600"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := backupRetentionEnv(tt.retention); !slices.Equal(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// fakeAWS stands in for the AWS CLI in the scheduled upload script. It lists
+// $FAKE_LISTING, or fails when $FAKE_LS_FAIL is set, and records the pruned
+// URLs in $FAKE_LOG, failing to prune $FAKE_RM_FAIL.
+const fakeAWS = `#!/bin/sh
+case "$2" in
+ls)
+  [ -z "$FAKE_LS_FAIL" ] || exit 1
+  printf '%s\n' "$FAKE_LISTING"
+  ;;
+rm)
+  echo "$4" >> "$FAKE_LOG"
+  [ "$4" != "$FAKE_RM_FAIL" ] || exit 1
+  ;;
+esac
+`
+
+// TestBackupRetentionPruning runs the scheduled upload script against a fake
+// AWS CLI listing backups taken the given number of hours ago.
+func TestBackupRetentionPruning(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no shell to run the scheduled upload script with")
+	}
+	now := time.Now().UTC()
+	run := func(hoursAgo int) string {
+		return now.Add(-time.Duration(hoursAgo) * time.Hour).Format("20060102T150405Z")
+	}
+	backups := []int{1, 2, 26, 50}
+
+	tests := []struct {
+		name   string
+		env    []string
+		failLS bool
+		// The backup whose pruning fails, in hours ago
+		failRM int
+		want   []int
+	}{
+		{name: "no retention"},
+		{name: "count", env: []string{"RETENTION_COUNT=2"}, want: []int{26, 50}},
+		{name: "count above the backups", env: []string{"RETENTION_COUNT=10"}},
+		{name: "max age", env: []string{"RETENTION_MAX_AGE_SECONDS=86400"}, want: []int{26, 50}},
+		{name: "count and max age", env: []string{"RETENTION_COUNT=1", "RETENTION_MAX_AGE_SECONDS=172800"}, want: []int{2, 26, 50}},
+		{name: "failed pruning", env: []string{"RETENTION_COUNT=1"}, failRM: 2, want: []int{2, 26, 50}},
+		{name: "failed listing", env: []string{"RETENTION_COUNT=1"}, failLS: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bin := t.TempDir()
+			if err := os.WriteFile(filepath.Join(bin, "aws"), []byte(fakeAWS), 0o755); err != nil {
+				t.Fatalf("error writing the fake AWS CLI: %v", err)
+			}
+			// Other prefixes under the destination are never pruned
+			listing := []string{"                           PRE other/"}
+			for _, hoursAgo := range backups {
+				listing = append(listing, "                           PRE "+run(hoursAgo)+"/")
+			}
+			log := filepath.Join(t.TempDir(), "pruned")
+			cmd := exec.Command(sh, "-c", scheduledUploadScript)
+			cmd.Env = append([]string{
+				"PATH=" + bin + ":/usr/bin:/bin",
+				"SNAPSHOT_PATH=/backup/app.db",
+				"BACKUP_URL=s3://backups/default/test",
+				"FAKE_LISTING=" + strings.Join(listing, "\n"),
+				"FAKE_LOG=" + log,
+			}, tt.env...)
+			if tt.failLS {
+				cmd.Env = append(cmd.Env, "FAKE_LS_FAIL=1")
+			}
+			if tt.failRM != 0 {
+				cmd.Env = append(cmd.Env, fmt.Sprintf("FAKE_RM_FAIL=s3://backups/default/test/%s/", run(tt.failRM)))
+			}
+
+			// Failures to list or prune backups don't fail the backup
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("error running the scheduled upload script: %v: %s", err, out)
+			}
+
+			var want []string
+			for _, hoursAgo := range tt.want {
+				want = append(want, fmt.Sprintf("s3://backups/default/test/%s/", run(hoursAgo)))
+			}
+			pruned, err := os.ReadFile(log)
+			if err != nil && !os.IsNotExist(err) {
+				t.Fatalf("error reading the pruned backups: %v", err)
+			}
+			if got := strings.Fields(string(pruned)); !slices.Equal(got, want) {
+				t.Errorf("expected the backups %v to be pruned, got %v", want, got)
+			}
+		})
+	}
+}
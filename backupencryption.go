@@ -0,0 +1,137 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+const (
+	// ReasonEncryptionKeyInvalid is used as the condition reason when the
+	// key backups are encrypted or decrypted with is missing or too short
+	ReasonEncryptionKeyInvalid = "EncryptionKeyInvalid"
+)
+
+const (
+	// opensslImage is the container image encrypting and decrypting backups
+	opensslImage = "alpine/openssl:3.1.4"
+	// backupEncryptionKeyKey is the key of the encryption key in the Secret
+	// referenced by a BackupEncryption
+	backupEncryptionKeyKey = "key"
+	// minBackupEncryptionKeyLength is the length in bytes an encryption key
+	// has to have at least
+	minBackupEncryptionKeyLength = 32
+)
+
+// encryptScript encrypts the snapshot in place with AES-256, deriving the key
+// from the passphrase with PBKDF2.
+const encryptScript = `set -e
+openssl enc -aes-256-cbc -pbkdf2 -salt -pass env:BACKUP_ENCRYPTION_KEY -in "$SNAPSHOT_PATH" -out "$SNAPSHOT_PATH.enc"
+mv "$SNAPSHOT_PATH.enc" "$SNAPSHOT_PATH"
+`
+
+// decryptScript decrypts the backup downloaded by the restore init container
+// and renames it to the database. Nothing was downloaded when the database
+// already existed. A backup that can't be decrypted, for example with the
+// wrong key, fails the init container.
+const decryptScript = `set -e
+if [ -f "$DATABASE_PATH" ] || [ ! -f "$DATABASE_PATH.restore" ]; then
+  exit 0
+fi
+openssl enc -d -aes-256-cbc -pbkdf2 -pass env:BACKUP_ENCRYPTION_KEY -in "$DATABASE_PATH.restore" -out "$DATABASE_PATH.decrypted"
+rm "$DATABASE_PATH.restore"
+mv "$DATABASE_PATH.decrypted" "$DATABASE_PATH"
+`
+
+// backupEncryptionKeyEnv returns the environment variable holding the key
+// backups are encrypted with.
+func backupEncryptionKeyEnv(encryption *kubelitedbv1.BackupEncryption) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: "BACKUP_ENCRYPTION_KEY",
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: encryption.SecretRef,
+				Key:                  backupEncryptionKeyKey,
+			},
+		},
+	}
+}
+
+// addBackupEncryption adds the init container encrypting the snapshot of the
+// database to the spec of a backup pod, between taking and uploading it.
+func addBackupEncryption(instance *kubelitedbv1.SQLiteInstance, spec *corev1.PodSpec) {
+	spec.InitContainers = append(spec.InitContainers, corev1.Container{
+		Name:    "encrypt",
+		Image:   opensslImage,
+		Command: []string{"/bin/sh", "-c", encryptScript},
+		Env: []corev1.EnvVar{
+			{Name: "SNAPSHOT_PATH", Value: backupSnapshotPath(instance)},
+			backupEncryptionKeyEnv(instance.Spec.BackupEncryption),
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: backupVolumeName, MountPath: backupMountPath},
+		},
+	})
+}
+
+// addDecryptInitContainer adds the init container decrypting the backup
+// restored from spec.restoreFrom, which runs after the restore init container.
+func addDecryptInitContainer(instance *kubelitedbv1.SQLiteInstance, template *corev1.PodTemplateSpec) {
+	template.Spec.InitContainers = append(template.Spec.InitContainers, corev1.Container{
+		Name:    "decrypt",
+		Image:   opensslImage,
+		Command: []string{"/bin/sh", "-c", decryptScript},
+		Env: []corev1.EnvVar{
+			{Name: "DATABASE_PATH", Value: databasePath(instance)},
+			backupEncryptionKeyEnv(instance.Spec.RestoreFrom.Encryption),
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			dataVolumeMount(instance),
+		},
+	})
+}
+
+// checkBackupEncryptionKey returns why the key referenced by the given
+// BackupEncryption can't be used, or an empty string when it can. A pod
+// referencing a missing key would not start, and a short key is easy to
+// guess.
+func checkBackupEncryptionKey(ctx context.Context, kubeclientset kubernetes.Interface, namespace string, encryption *kubelitedbv1.BackupEncryption) (string, error) {
+	name := encryption.SecretRef.Name
+	secret, err := kubeclientset.CoreV1().Secrets(namespace).Get(ctx, name, v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return fmt.Sprintf("encryption key Secret %q does not exist", name), nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get encryption key Secret %q: %w", name, err)
+	}
+	key, ok := secret.Data[backupEncryptionKeyKey]
+	switch {
+	case !ok:
+		return fmt.Sprintf("encryption key Secret %q has no %q key", name, backupEncryptionKeyKey), nil
+	case len(key) < minBackupEncryptionKeyLength:
+		return fmt.Sprintf("encryption key in Secret %q must be at least %d bytes long", name, minBackupEncryptionKeyLength), nil
+	}
+	return "", nil
+}
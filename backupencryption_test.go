@@ -0,0 +1,279 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// newEncryptionKeySecret returns the Secret holding the given backup
+// encryption key, or a Secret without one when key is empty.
+func newEncryptionKeySecret(name, key string) *corev1.Secret {
+	secret := &corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{Name: name, Namespace: v1.NamespaceDefault},
+		Data:       map[string][]byte{},
+	}
+	if key != "" {
+		secret.Data[backupEncryptionKeyKey] = []byte(key)
+	}
+	return secret
+}
+
+// validEncryptionKey is long enough to encrypt backups with
+var validEncryptionKey = strings.Repeat("k", minBackupEncryptionKeyLength)
+
+// expectEncryptionKeyEnv fails the test unless the container reads
+// BACKUP_ENCRYPTION_KEY from the key of the given Secret.
+func expectEncryptionKeyEnv(t *testing.T, container *corev1.Container, secretName string) {
+	t.Helper()
+	i := slices.IndexFunc(container.Env, func(env corev1.EnvVar) bool { return env.Name == "BACKUP_ENCRYPTION_KEY" })
+	if i < 0 {
+		t.Fatalf("expected container %s to have BACKUP_ENCRYPTION_KEY, got %v", container.Name, container.Env)
+	}
+	ref := container.Env[i].ValueFrom
+	if ref == nil || ref.SecretKeyRef == nil || ref.SecretKeyRef.Name != secretName || ref.SecretKeyRef.Key != backupEncryptionKeyKey {
+		t.Errorf("expected BACKUP_ENCRYPTION_KEY of container %s from key %q of Secret %s, got %v", container.Name, backupEncryptionKeyKey, secretName, ref)
+	}
+}
+
+// initContainerNames returns the names of the init containers of a pod spec
+func initContainerNames(spec corev1.PodSpec) []string {
+	var names []string
+	for _, container := range spec.InitContainers {
+		names = append(names, container.Name)
+	}
+	return names
+}
+
+func TestBackupEncryptionInitContainer(t *testing.T) {
+	tests := []struct {
+		name       string
+		encryption *kubelitedbv1.BackupEncryption
+		want       []string
+	}{
+		{name: "unencrypted", want: []string{"snapshot"}},
+		{
+			name:       "encrypted",
+			encryption: &kubelitedbv1.BackupEncryption{SecretRef: corev1.LocalObjectReference{Name: "backup-key"}},
+			want:       []string{"snapshot", "encrypt"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := withBackupSchedule(newSQLiteInstance("test"), "0 3 * * *")
+			instance.Spec.BackupEncryption = tt.encryption
+
+			// SQLiteBackups and scheduled backups both encrypt the snapshot
+			// before the upload container starts
+			for kind, spec := range map[string]corev1.PodSpec{
+				"Job":     newBackupJob(newSQLiteBackup("nightly", instance.Name), instance).Spec.Template.Spec,
+				"CronJob": newBackupCronJob(instance).Spec.JobTemplate.Spec.Template.Spec,
+			} {
+				if got := initContainerNames(spec); !slices.Equal(got, tt.want) {
+					t.Errorf("expected the %s to have the init containers %v, got %v", kind, tt.want, got)
+				}
+				if tt.encryption == nil {
+					continue
+				}
+				encrypt := &spec.InitContainers[1]
+				if got := envValue(encrypt, "SNAPSHOT_PATH"); got != backupSnapshotPath(instance) {
+					t.Errorf("expected the %s to encrypt %s, got %q", kind, backupSnapshotPath(instance), got)
+				}
+				expectEncryptionKeyEnv(t, encrypt, "backup-key")
+				for _, container := range spec.Containers {
+					if slices.ContainsFunc(container.Env, func(env corev1.EnvVar) bool { return env.Name == "BACKUP_ENCRYPTION_KEY" }) {
+						t.Errorf("expected container %s of the %s not to get the key", container.Name, kind)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreDecryptInitContainer(t *testing.T) {
+	tests := []struct {
+		name       string
+		encryption *kubelitedbv1.BackupEncryption
+		want       []string
+		encrypted  string
+	}{
+		{name: "unencrypted", want: []string{"restore"}},
+		{
+			name:       "encrypted",
+			encryption: &kubelitedbv1.BackupEncryption{SecretRef: corev1.LocalObjectReference{Name: "backup-key"}},
+			want:       []string{"restore", "decrypt"},
+			encrypted:  "true",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newRestoringSQLiteInstance("test")
+			instance.Spec.RestoreFrom.Encryption = tt.encryption
+			template := corev1.PodTemplateSpec{}
+
+			addRestoreInitContainer(instance, &template)
+
+			if got := initContainerNames(template.Spec); !slices.Equal(got, tt.want) {
+				t.Fatalf("expected the init containers %v, got %v", tt.want, got)
+			}
+			// The restore leaves an encrypted backup for the decrypt init
+			// container to move into place
+			if got := envValue(&template.Spec.InitContainers[0], "RESTORE_ENCRYPTED"); got != tt.encrypted {
+				t.Errorf("expected RESTORE_ENCRYPTED %q, got %q", tt.encrypted, got)
+			}
+			if tt.encryption == nil {
+				return
+			}
+			decrypt := &template.Spec.InitContainers[1]
+			if got := envValue(decrypt, "DATABASE_PATH"); got != databasePath(instance) {
+				t.Errorf("expected the decrypt init container to write %s, got %q", databasePath(instance), got)
+			}
+			expectEncryptionKeyEnv(t, decrypt, "backup-key")
+		})
+	}
+}
+
+func TestCheckBackupEncryptionKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret *corev1.Secret
+		want   string
+	}{
+		{name: "valid", secret: newEncryptionKeySecret("backup-key", validEncryptionKey)},
+		{name: "missing Secret", want: `encryption key Secret "backup-key" does not exist`},
+		{name: "missing key", secret: newEncryptionKeySecret("backup-key", ""), want: `encryption key Secret "backup-key" has no "key" key`},
+		{
+			name:   "short key",
+			secret: newEncryptionKeySecret("backup-key", validEncryptionKey[1:]),
+			want:   `encryption key in Secret "backup-key" must be at least 32 bytes long`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			kubeclient := k8sfake.NewSimpleClientset()
+			if tt.secret != nil {
+				kubeclient = k8sfake.NewSimpleClientset(tt.secret)
+			}
+			encryption := &kubelitedbv1.BackupEncryption{SecretRef: corev1.LocalObjectReference{Name: "backup-key"}}
+
+			got, err := checkBackupEncryptionKey(ctx, kubeclient, v1.NamespaceDefault, encryption)
+			if err != nil {
+				t.Fatalf("error checking the encryption key: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestBackupEncryptionKeyInvalid(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newBackupFixture(t)
+	instance := newSQLiteInstance("test")
+	instance.Spec.BackupEncryption = &kubelitedbv1.BackupEncryption{SecretRef: corev1.LocalObjectReference{Name: "backup-key"}}
+	backup := newSQLiteBackup("nightly", instance.Name)
+	f.addInstance(instance)
+	f.addBackup(backup)
+	f.kubeobjects = append(f.kubeobjects, newEncryptionKeySecret("backup-key", "short"))
+	c := f.newController(ctx)
+
+	got := f.run(ctx, c, backup)
+
+	// The backup is never uploaded unencrypted
+	if got.Status.Phase != BackupPhaseFailed {
+		t.Errorf("expected phase %s, got %q", BackupPhaseFailed, got.Status.Phase)
+	}
+	condition := meta.FindStatusCondition(got.Status.Conditions, kubelitedbv1.ConditionComplete)
+	if condition == nil || condition.Status != v1.ConditionFalse || condition.Reason != ReasonEncryptionKeyInvalid {
+		t.Errorf("expected condition False with reason %s, got %v", ReasonEncryptionKeyInvalid, condition)
+	}
+	if actions := writes(f.kubeclient.Actions(), "jobs"); len(actions) != 0 {
+		t.Errorf("expected no Job writes, got %v", actions)
+	}
+}
+
+func TestScheduledBackupEncryptionKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret *corev1.Secret
+		reason string
+	}{
+		{name: "valid", secret: newEncryptionKeySecret("backup-key", validEncryptionKey), reason: ReasonBackupScheduled},
+		{name: "missing Secret", reason: ReasonEncryptionKeyInvalid},
+		{name: "short key", secret: newEncryptionKeySecret("backup-key", "short"), reason: ReasonEncryptionKeyInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := withBackupSchedule(newSQLiteInstance("test"), "0 3 * * *")
+			instance.Spec.BackupEncryption = &kubelitedbv1.BackupEncryption{SecretRef: corev1.LocalObjectReference{Name: "backup-key"}}
+			if tt.secret != nil {
+				f.addKubeObject(tt.secret)
+			}
+			c, _, _ := f.newController(ctx)
+			status := instance.Status.DeepCopy()
+
+			if err := c.syncBackupCronJob(ctx, instance, status); err != nil {
+				t.Fatalf("error syncing the backup CronJob: %v", err)
+			}
+
+			condition := meta.FindStatusCondition(status.Conditions, kubelitedbv1.ConditionBackupScheduled)
+			if condition == nil || condition.Reason != tt.reason {
+				t.Errorf("expected reason %s, got %v", tt.reason, condition)
+			}
+			// The CronJob is only created once the key can be used
+			created := len(writes(f.kubeclient.Actions(), "cronjobs")) > 0
+			if want := tt.reason == ReasonBackupScheduled; created != want {
+				t.Errorf("expected the CronJob to be created %t, got %t", want, created)
+			}
+		})
+	}
+}
+
+func TestRestoreEncryptionKeyInvalid(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newRestoringSQLiteInstance("test")
+	instance.Spec.RestoreFrom.Encryption = &kubelitedbv1.BackupEncryption{SecretRef: corev1.LocalObjectReference{Name: "backup-key"}}
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+
+	f.run(ctx, c, getKey(instance, t))
+
+	// The decrypt init container could not start without the key
+	if actions := writes(f.kubeclient.Actions(), "statefulsets"); len(actions) != 0 {
+		t.Errorf("expected no StatefulSet writes, got %v", actions)
+	}
+	condition := meta.FindStatusCondition(f.getInstance(ctx, instance).Status.Conditions, kubelitedbv1.ConditionReady)
+	if condition == nil || condition.Status != v1.ConditionFalse || condition.Reason != ReasonEncryptionKeyInvalid {
+		t.Errorf("expected Ready False with reason %s, got %v", ReasonEncryptionKeyInvalid, condition)
+	}
+	expectEvent(t, f.recorder, corev1.EventTypeWarning, ReasonEncryptionKeyInvalid)
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// clientServiceName returns the name of the Service clients of the given
+// SQLiteInstance connect to.
+func clientServiceName(instance *kubelitedbv1.SQLiteInstance) string {
+	return fmt.Sprintf("%s-sqlite-client", instance.Name)
+}
+
+// serviceTypeForInstance returns the type of the client Service of the
+// SQLiteInstance, ClusterIP unless spec.serviceType says otherwise.
+func serviceTypeForInstance(instance *kubelitedbv1.SQLiteInstance) corev1.ServiceType {
+	if instance.Spec.ServiceType != "" {
+		return instance.Spec.ServiceType
+	}
+	return corev1.ServiceTypeClusterIP
+}
+
+// newClientService creates the Service clients of a SQLiteInstance connect to.
+// Unlike the headless Service it has a cluster IP, and it can be exposed
+// outside of the cluster. It resolves to the writer pod.
+func newClientService(instance *kubelitedbv1.SQLiteInstance) *corev1.Service {
+	annotations := childAnnotations(instance)
+	for key, value := range instance.Spec.ServiceAnnotations {
+		if strings.HasPrefix(key, reservedPrefix) {
+			continue
+		}
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[key] = value
+	}
+	return &corev1.Service{
+		ObjectMeta: v1.ObjectMeta{
+			Name:        clientServiceName(instance),
+			Namespace:   instance.Namespace,
+			Labels:      childLabels(instance, labelsForInstance(instance, componentDatabase)),
+			Annotations: annotations,
+			OwnerReferences: []v1.OwnerReference{
+				newOwnerReference(instance),
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			Type:     serviceTypeForInstance(instance),
+			Selector: writerSelector(instance),
+			Ports:    servicePorts(instance),
+		},
+	}
+}
+
+// servicePortsWithNodePorts returns the desired ports, keeping the node ports
+// allocated to the current ports of the same name. Node ports are only kept
+// for Services that still have them.
+func servicePortsWithNodePorts(current, desired []corev1.ServicePort, serviceType corev1.ServiceType) []corev1.ServicePort {
+	ports := make([]corev1.ServicePort, 0, len(desired))
+	for _, port := range desired {
+		if serviceType != corev1.ServiceTypeClusterIP {
+			for _, currentPort := range current {
+				if currentPort.Name == port.Name {
+					port.NodePort = currentPort.NodePort
+				}
+			}
+		}
+		ports = append(ports, port)
+	}
+	return ports
+}
+
+// syncClientService creates or updates the client Service of the
+// SQLiteInstance. It only exposes the TLS port, so it is deleted when TLS is
+// not set.
+func (c *Controller) syncClientService(ctx context.Context, sqliteInstance *kubelitedbv1.SQLiteInstance) error {
+	wanted := sqliteInstance.Spec.TLS != nil
+	desired := newClientService(sqliteInstance)
+
+	services := c.kubeclientset.CoreV1().Services(sqliteInstance.Namespace)
+	service, err := services.Get(ctx, desired.Name, v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		if !wanted {
+			return nil
+		}
+		_, err = services.Create(ctx, desired, c.createOptions(ctx, sqliteInstance, "Service", desired.Name))
+		logWrite(ctx, "create", "Service", desired.Name, err)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if !v1.IsControlledBy(service, sqliteInstance) {
+		msg := fmt.Sprintf(MessageResourceExists, service.Name)
+		c.recorder.Event(sqliteInstance, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return fmt.Errorf("%s", msg)
+	}
+
+	if !wanted {
+		err = services.Delete(ctx, service.Name, c.deleteOptions(ctx, sqliteInstance, "Service", service.Name))
+		logWrite(ctx, "delete", "Service", service.Name, err)
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	// The node ports are allocated by the API server, so they are kept
+	// rather than compared.
+	ports := servicePortsWithNodePorts(service.Spec.Ports, desired.Spec.Ports, desired.Spec.Type)
+	if service.Spec.Type != desired.Spec.Type ||
+		!equality.Semantic.DeepEqual(service.Spec.Selector, desired.Spec.Selector) ||
+		!equality.Semantic.DeepEqual(service.Spec.Ports, ports) ||
+		metadataOutOfDate(service, desired) {
+		serviceCopy := service.DeepCopy()
+		mergeMetadata(serviceCopy, desired)
+		serviceCopy.Spec.Type = desired.Spec.Type
+		serviceCopy.Spec.Selector = desired.Spec.Selector
+		serviceCopy.Spec.Ports = ports
+		_, err = services.Update(ctx, serviceCopy, c.updateOptions(ctx, sqliteInstance, "Service", service, serviceCopy))
+		logWrite(ctx, "update", "Service", service.Name, err)
+	}
+	return err
+}
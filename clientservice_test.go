@@ -0,0 +1,144 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/ktesting"
+)
+
+func TestClientServiceType(t *testing.T) {
+	tests := []struct {
+		name        string
+		serviceType corev1.ServiceType
+		want        corev1.ServiceType
+	}{
+		{name: "default", want: corev1.ServiceTypeClusterIP},
+		{name: "ClusterIP", serviceType: corev1.ServiceTypeClusterIP, want: corev1.ServiceTypeClusterIP},
+		{name: "NodePort", serviceType: corev1.ServiceTypeNodePort, want: corev1.ServiceTypeNodePort},
+		{name: "LoadBalancer", serviceType: corev1.ServiceTypeLoadBalancer, want: corev1.ServiceTypeLoadBalancer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := newTLSSQLiteInstance("test")
+			instance.Spec.ServiceType = tt.serviceType
+			f.addInstance(instance)
+			f.addKubeObject(newTLSSecret("cert"))
+			c, _, _ := f.newController(ctx)
+
+			f.run(ctx, c, getKey(instance, t))
+
+			client, err := f.kubeclient.CoreV1().Services(instance.Namespace).Get(ctx, clientServiceName(instance), v1.GetOptions{})
+			if err != nil {
+				t.Fatalf("error getting the client Service: %v", err)
+			}
+			if client.Spec.Type != tt.want {
+				t.Errorf("expected a %s client Service, got %s", tt.want, client.Spec.Type)
+			}
+			if !equality.Semantic.DeepEqual(client.Spec.Selector, writerSelector(instance)) {
+				t.Errorf("expected the client Service to select the writer, got %v", client.Spec.Selector)
+			}
+			// Pod discovery doesn't depend on how the database is exposed
+			headless, err := f.kubeclient.CoreV1().Services(instance.Namespace).Get(ctx, headlessServiceName(instance), v1.GetOptions{})
+			if err != nil {
+				t.Fatalf("error getting the headless Service: %v", err)
+			}
+			if headless.Spec.ClusterIP != corev1.ClusterIPNone || (headless.Spec.Type != "" && headless.Spec.Type != corev1.ServiceTypeClusterIP) {
+				t.Errorf("expected the headless Service to stay ClusterIP None, got %s %q", headless.Spec.Type, headless.Spec.ClusterIP)
+			}
+		})
+	}
+}
+
+func TestClientServiceAnnotations(t *testing.T) {
+	instance := newTLSSQLiteInstance("test")
+	instance.Spec.ServiceType = corev1.ServiceTypeLoadBalancer
+	instance.Spec.Annotations = map[string]string{"team": "payments"}
+	instance.Spec.ServiceAnnotations = map[string]string{
+		"service.beta.kubernetes.io/aws-load-balancer-internal": "true",
+		reservedPrefix + "owned":                                "true",
+	}
+
+	got := newClientService(instance).Annotations
+
+	want := map[string]string{"team": "payments", "service.beta.kubernetes.io/aws-load-balancer-internal": "true"}
+	if !equality.Semantic.DeepEqual(got, want) {
+		t.Errorf("expected annotations %v, got %v", want, got)
+	}
+	if _, ok := newHeadlessService(instance).Annotations["service.beta.kubernetes.io/aws-load-balancer-internal"]; ok {
+		t.Errorf("expected the Service annotations on the client Service only")
+	}
+}
+
+func TestClientServiceKeepsNodePorts(t *testing.T) {
+	tests := []struct {
+		name         string
+		serviceType  corev1.ServiceType
+		wantNodePort int32
+	}{
+		{name: "NodePort", serviceType: corev1.ServiceTypeNodePort, wantNodePort: 30443},
+		{name: "LoadBalancer", serviceType: corev1.ServiceTypeLoadBalancer, wantNodePort: 30443},
+		{name: "back to ClusterIP", serviceType: corev1.ServiceTypeClusterIP},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := newTLSSQLiteInstance("test")
+			instance.Spec.ServiceType = corev1.ServiceTypeNodePort
+			existing := newClientService(instance)
+			existing.Spec.Ports[0].NodePort = 30443
+			f.addKubeObject(existing)
+			instance.Spec.ServiceType = tt.serviceType
+			f.addInstance(instance)
+			f.addKubeObject(newTLSSecret("cert"))
+			c, _, _ := f.newController(ctx)
+
+			f.run(ctx, c, getKey(instance, t))
+
+			client, err := f.kubeclient.CoreV1().Services(instance.Namespace).Get(ctx, clientServiceName(instance), v1.GetOptions{})
+			if err != nil {
+				t.Fatalf("error getting the client Service: %v", err)
+			}
+			if client.Spec.Type != tt.serviceType || client.Spec.Ports[0].NodePort != tt.wantNodePort {
+				t.Errorf("expected a %s client Service with node port %d, got %s with %v", tt.serviceType, tt.wantNodePort, client.Spec.Type, client.Spec.Ports)
+			}
+		})
+	}
+}
+
+func TestClientServiceDeletedWithoutTLS(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	f.addKubeObject(newClientService(newTLSSQLiteInstance("test")))
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+
+	f.run(ctx, c, getKey(instance, t))
+
+	if _, err := f.kubeclient.CoreV1().Services(instance.Namespace).Get(ctx, clientServiceName(instance), v1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("expected the client Service to be deleted once TLS is unset, got %v", err)
+	}
+}
@@ -0,0 +1,256 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+const (
+	// ReasonCloneSourceNotFound is used as the condition reason when the
+	// SQLiteInstance to clone does not exist
+	ReasonCloneSourceNotFound = "CloneSourceNotFound"
+	// ReasonCloneSourceNotReady is used as the condition reason when the
+	// SQLiteInstance to clone is not ready
+	ReasonCloneSourceNotReady = "CloneSourceNotReady"
+	// ReasonCloneRunning is used as the condition reason while the clone Job
+	// is running
+	ReasonCloneRunning = "CloneRunning"
+	// ReasonCloneFailed is used as the condition reason when the clone Job
+	// failed
+	ReasonCloneFailed = "CloneFailed"
+	// ReasonCloneComplete is used as the condition reason once the database
+	// was cloned
+	ReasonCloneComplete = "CloneComplete"
+
+	// MessageCloneSourceNotReady is the message used when the SQLiteInstance
+	// to clone is not ready
+	MessageCloneSourceNotReady = "SQLiteInstance %q is not ready"
+	// MessageCloneFailed is the message used when the clone Job failed
+	MessageCloneFailed = "Job %q failed, delete it to retry the clone"
+	// MessageCloneComplete is the message used once the database was cloned
+	MessageCloneComplete = "Database cloned from SQLiteInstance %q"
+)
+
+// cloneMountPath is where the clone Job mounts the volume of the new
+// SQLiteInstance
+const cloneMountPath = "/clone"
+
+// cloneScript takes a consistent snapshot of the source database with the
+// SQLite backup API next to the new database, and then renames it, so a
+// partial snapshot is never mistaken for the database.
+const cloneScript = `set -e
+sqlite3 "$SOURCE_PATH" ".backup '$DATABASE_PATH.clone'"
+mv "$DATABASE_PATH.clone" "$DATABASE_PATH"
+`
+
+// needsClone returns whether the database of the SQLiteInstance still has to
+// be cloned from spec.cloneFrom.
+func needsClone(instance *kubelitedbv1.SQLiteInstance) bool {
+	return instance.Spec.CloneFrom != nil && !instance.Status.Cloned
+}
+
+// cloneJobName returns the name of the Job cloning the database into the
+// SQLiteInstance
+func cloneJobName(instance *kubelitedbv1.SQLiteInstance) string {
+	return fmt.Sprintf("%s-clone", instance.Name)
+}
+
+// newCloneJob creates the Job copying the database of the source
+// SQLiteInstance into the volume of the first pod of the new one. The Job runs
+// on the node of the first pod of the source, as its volume may only be
+// mounted from a single node.
+func newCloneJob(instance, source *kubelitedbv1.SQLiteInstance) *batchv1.Job {
+	backoffLimit := int32(2)
+
+	job := &batchv1.Job{
+		ObjectMeta: v1.ObjectMeta{
+			Name:            cloneJobName(instance),
+			Namespace:       instance.Namespace,
+			Labels:          childLabels(instance, labelsForInstance(instance, componentClone)),
+			Annotations:     childAnnotations(instance),
+			OwnerReferences: []v1.OwnerReference{newOwnerReference(instance)},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: v1.ObjectMeta{
+					Labels:      childLabels(instance, labelsForInstance(instance, componentClone)),
+					Annotations: childAnnotations(instance),
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					// The clone is taken with the image of the source
+					ImagePullSecrets: source.Spec.ImagePullSecrets,
+					Affinity: &corev1.Affinity{
+						PodAffinity: &corev1.PodAffinity{
+							RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{
+								{
+									LabelSelector: &v1.LabelSelector{
+										MatchLabels: map[string]string{
+											"statefulset.kubernetes.io/pod-name": fmt.Sprintf("%s-0", statefulSetName(source)),
+										},
+									},
+									TopologyKey: corev1.LabelHostname,
+								},
+							},
+						},
+					},
+					Containers: []corev1.Container{
+						{
+							Name:    "clone",
+							Image:   imageForInstance(source),
+							Command: []string{"/bin/sh", "-c", cloneScript},
+							Env: []corev1.EnvVar{
+								{Name: "SOURCE_PATH", Value: databasePath(source)},
+								{Name: "DATABASE_PATH", Value: path.Join(cloneMountPath, instance.Spec.DbName)},
+							},
+							VolumeMounts: []corev1.VolumeMount{
+								dataVolumeMount(source),
+								{Name: "clone", MountPath: cloneMountPath, SubPath: dataSubPath(instance)},
+							},
+						},
+					},
+					Volumes: []corev1.Volume{
+						{
+							Name: dataVolumeName,
+							VolumeSource: corev1.VolumeSource{
+								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+									ClaimName: dataPVCName(source, 0),
+								},
+							},
+						},
+						{
+							Name: "clone",
+							VolumeSource: corev1.VolumeSource{
+								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+									ClaimName: dataPVCName(instance, 0),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	// The clone is written with the user and group of the new instance
+	setSecurityContexts(instance, &job.Spec.Template.Spec)
+	return job
+}
+
+// syncClone clones the database of spec.cloneFrom into the SQLiteInstance
+// before its StatefulSet is created, and records the progress in the Cloned
+// condition. It returns whether the clone is done and the StatefulSet can be
+// created. The clone only runs once: instances whose StatefulSet already exists
+// are never cloned into, as that would overwrite their data.
+func (c *Controller) syncClone(ctx context.Context, sqliteInstance *kubelitedbv1.SQLiteInstance, status *kubelitedbv1.SQLiteInstanceStatus) (bool, error) {
+	if !needsClone(sqliteInstance) {
+		return true, nil
+	}
+	if _, err := c.statefulSetsLister.StatefulSets(sqliteInstance.Namespace).Get(statefulSetName(sqliteInstance)); err == nil {
+		return true, nil
+	} else if !errors.IsNotFound(err) {
+		return false, err
+	}
+
+	sourceName := sqliteInstance.Spec.CloneFrom.Name
+	source, err := c.sqliteInstancesLister.SQLiteInstances(sqliteInstance.Namespace).Get(sourceName)
+	if errors.IsNotFound(err) {
+		c.setCloneCondition(sqliteInstance, status, corev1.EventTypeWarning, ReasonCloneSourceNotFound, fmt.Sprintf(MessageSourceNotFound, sourceName))
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	jobs := c.kubeclientset.BatchV1().Jobs(sqliteInstance.Namespace)
+	job, err := jobs.Get(ctx, cloneJobName(sqliteInstance), v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		// The source is only snapshotted while its first pod is serving
+		if !meta.IsStatusConditionTrue(source.Status.Conditions, kubelitedbv1.ConditionReady) || source.Status.WriterReadyReplicas < 1 {
+			c.setCloneCondition(sqliteInstance, status, corev1.EventTypeWarning, ReasonCloneSourceNotReady, fmt.Sprintf(MessageCloneSourceNotReady, sourceName))
+			return false, nil
+		}
+
+		pvcs := c.kubeclientset.CoreV1().PersistentVolumeClaims(sqliteInstance.Namespace)
+		_, err = pvcs.Create(ctx, newDataPVC(sqliteInstance), c.createOptions(ctx, sqliteInstance, "PersistentVolumeClaim", dataPVCName(sqliteInstance, 0)))
+		logWrite(ctx, "create", "PersistentVolumeClaim", dataPVCName(sqliteInstance, 0), err)
+		if err != nil && !errors.IsAlreadyExists(err) {
+			return false, err
+		}
+		job, err = jobs.Create(ctx, newCloneJob(sqliteInstance, source), c.createOptions(ctx, sqliteInstance, "Job", cloneJobName(sqliteInstance)))
+		logWrite(ctx, "create", "Job", cloneJobName(sqliteInstance), err)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if !v1.IsControlledBy(job, sqliteInstance) {
+		msg := fmt.Sprintf(MessageResourceExists, job.Name)
+		c.recorder.Event(sqliteInstance, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return false, fmt.Errorf("%s", msg)
+	}
+
+	switch {
+	case jobHasCondition(job, batchv1.JobComplete):
+		status.Cloned = true
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionCloned, v1.ConditionTrue, ReasonCloneComplete, fmt.Sprintf(MessageCloneComplete, sourceName))
+		c.recorder.Eventf(sqliteInstance, corev1.EventTypeNormal, ReasonCloneComplete, MessageCloneComplete, sourceName)
+		// The pod of the Job no longer needs the volume once it completed
+		propagation := v1.DeletePropagationBackground
+		deleteOptions := c.deleteOptions(ctx, sqliteInstance, "Job", job.Name)
+		deleteOptions.PropagationPolicy = &propagation
+		err := jobs.Delete(ctx, job.Name, deleteOptions)
+		logWrite(ctx, "delete", "Job", job.Name, err)
+		if err != nil && !errors.IsNotFound(err) {
+			return false, err
+		}
+		return true, nil
+	case jobHasCondition(job, batchv1.JobFailed):
+		c.setCloneCondition(sqliteInstance, status, corev1.EventTypeWarning, ReasonCloneFailed, fmt.Sprintf(MessageCloneFailed, job.Name))
+	default:
+		c.setCloneCondition(sqliteInstance, status, "", ReasonCloneRunning, fmt.Sprintf("Cloning database from SQLiteInstance %q", sourceName))
+	}
+	return false, nil
+}
+
+// setCloneCondition records that the clone is not done in the Cloned,
+// Progressing and Ready conditions, and fires an Event of the given type if
+// one is set.
+func (c *Controller) setCloneCondition(sqliteInstance *kubelitedbv1.SQLiteInstance, status *kubelitedbv1.SQLiteInstanceStatus, eventType, reason, msg string) {
+	setCondition(status, sqliteInstance, kubelitedbv1.ConditionCloned, v1.ConditionFalse, reason, msg)
+	// A failed clone is not retried, so the instance stops progressing
+	progressing := v1.ConditionTrue
+	if reason == ReasonCloneFailed {
+		progressing = v1.ConditionFalse
+	}
+	setCondition(status, sqliteInstance, kubelitedbv1.ConditionProgressing, progressing, reason, msg)
+	setCondition(status, sqliteInstance, kubelitedbv1.ConditionReady, v1.ConditionFalse, reason, msg)
+	if eventType != "" {
+		c.recorder.Event(sqliteInstance, eventType, reason, msg)
+	}
+}
@@ -0,0 +1,221 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// newCloningSQLiteInstance returns a SQLiteInstance cloning the database of
+// the source SQLiteInstance.
+func newCloningSQLiteInstance(name, source string) *kubelitedbv1.SQLiteInstance {
+	instance := newSQLiteInstance(name)
+	instance.Spec.CloneFrom = &corev1.LocalObjectReference{Name: source}
+	return instance
+}
+
+// newReadySQLiteInstance returns a SQLiteInstance whose first pod is serving
+func newReadySQLiteInstance(name string) *kubelitedbv1.SQLiteInstance {
+	instance := newSQLiteInstance(name)
+	instance.Status.WriterReadyReplicas = 1
+	instance.Status.Conditions = []v1.Condition{{Type: kubelitedbv1.ConditionReady, Status: v1.ConditionTrue, Reason: SuccessSynced}}
+	return instance
+}
+
+func TestCloneJob(t *testing.T) {
+	instance := newCloningSQLiteInstance("staging", "production")
+	source := newSQLiteInstance("production")
+	source.Spec.DbName = "prod.db"
+
+	job := newCloneJob(instance, source)
+
+	if !v1.IsControlledBy(job, instance) {
+		t.Errorf("expected the Job to be controlled by the SQLiteInstance, got %v", job.OwnerReferences)
+	}
+	spec := job.Spec.Template.Spec
+	terms := spec.Affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if len(terms) != 1 || terms[0].LabelSelector.MatchLabels["statefulset.kubernetes.io/pod-name"] != "production-sqlite-0" || terms[0].TopologyKey != corev1.LabelHostname {
+		t.Errorf("expected the Job to run on the node of the first pod of the source, got %v", terms)
+	}
+	clone := container(t, spec.Containers, "clone")
+	if got := envValue(clone, "SOURCE_PATH"); got != "/data/prod.db" {
+		t.Errorf("expected the source database /data/prod.db, got %q", got)
+	}
+	if got := envValue(clone, "DATABASE_PATH"); got != "/clone/app.db" {
+		t.Errorf("expected the cloned database /clone/app.db, got %q", got)
+	}
+	claims := map[string]string{}
+	for _, volume := range spec.Volumes {
+		if volume.PersistentVolumeClaim != nil {
+			claims[volume.Name] = volume.PersistentVolumeClaim.ClaimName
+		}
+	}
+	if claims[dataVolumeName] != dataPVCName(source, 0) || claims["clone"] != dataPVCName(instance, 0) {
+		t.Errorf("expected the volumes of the first pods of the source and the clone, got %v", claims)
+	}
+}
+
+func TestCloneSource(t *testing.T) {
+	tests := []struct {
+		name       string
+		source     *kubelitedbv1.SQLiteInstance
+		wantReason string
+		wantJob    bool
+	}{
+		{name: "missing source", wantReason: ReasonCloneSourceNotFound},
+		{name: "source not ready", source: newSQLiteInstance("production"), wantReason: ReasonCloneSourceNotReady},
+		{name: "ready source", source: newReadySQLiteInstance("production"), wantReason: ReasonCloneRunning, wantJob: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := newCloningSQLiteInstance("staging", "production")
+			f.addInstance(instance)
+			if tt.source != nil {
+				f.addInstance(tt.source)
+			}
+			c, _, _ := f.newController(ctx)
+
+			result := f.run(ctx, c, getKey(instance, t))
+
+			if result.RequeueAfter != pendingRequeueAfter {
+				t.Errorf("expected a requeue after %s, got %+v", pendingRequeueAfter, result)
+			}
+			if len(writes(f.kubeclient.Actions(), "statefulsets")) > 0 {
+				t.Errorf("expected no StatefulSet before the clone is done")
+			}
+			_, err := f.kubeclient.BatchV1().Jobs(instance.Namespace).Get(ctx, cloneJobName(instance), v1.GetOptions{})
+			if tt.wantJob && err != nil {
+				t.Errorf("expected the clone Job to be created: %v", err)
+			}
+			if !tt.wantJob && !errors.IsNotFound(err) {
+				t.Errorf("expected no clone Job, got %v", err)
+			}
+			_, err = f.kubeclient.CoreV1().PersistentVolumeClaims(instance.Namespace).Get(ctx, dataPVCName(instance, 0), v1.GetOptions{})
+			if tt.wantJob && err != nil {
+				t.Errorf("expected the volume to clone into to be created: %v", err)
+			}
+
+			got := f.getInstance(ctx, instance)
+			condition := meta.FindStatusCondition(got.Status.Conditions, kubelitedbv1.ConditionCloned)
+			if condition == nil || condition.Status != v1.ConditionFalse || condition.Reason != tt.wantReason {
+				t.Errorf("expected condition %s False with reason %s, got %v", kubelitedbv1.ConditionCloned, tt.wantReason, condition)
+			}
+			if !meta.IsStatusConditionTrue(got.Status.Conditions, kubelitedbv1.ConditionProgressing) {
+				t.Errorf("expected the SQLiteInstance to be progressing while waiting for the clone")
+			}
+		})
+	}
+}
+
+func TestCloneJobStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		condition   batchv1.JobConditionType
+		wantCloned  bool
+		wantReason  string
+		wantRequeue bool
+	}{
+		{name: "running", wantReason: ReasonCloneRunning, wantRequeue: true},
+		{name: "complete", condition: batchv1.JobComplete, wantCloned: true, wantReason: ReasonCloneComplete},
+		{name: "failed", condition: batchv1.JobFailed, wantReason: ReasonCloneFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := newCloningSQLiteInstance("staging", "production")
+			source := newReadySQLiteInstance("production")
+			f.addInstance(instance)
+			f.addInstance(source)
+			job := newCloneJob(instance, source)
+			if tt.condition != "" {
+				job.Status.Conditions = []batchv1.JobCondition{{Type: tt.condition, Status: corev1.ConditionTrue}}
+			}
+			f.addKubeObject(job)
+			c, _, _ := f.newController(ctx)
+
+			result := f.run(ctx, c, getKey(instance, t))
+
+			got := f.getInstance(ctx, instance)
+			if got.Status.Cloned != tt.wantCloned {
+				t.Errorf("expected cloned %t, got %t", tt.wantCloned, got.Status.Cloned)
+			}
+			if condition := meta.FindStatusCondition(got.Status.Conditions, kubelitedbv1.ConditionCloned); condition == nil || condition.Reason != tt.wantReason {
+				t.Errorf("expected condition %s with reason %s, got %v", kubelitedbv1.ConditionCloned, tt.wantReason, condition)
+			}
+			if created := len(writes(f.kubeclient.Actions(), "statefulsets")) > 0; created != tt.wantCloned {
+				t.Errorf("expected the StatefulSet to be created %t, got %t", tt.wantCloned, created)
+			}
+			if tt.wantRequeue && result.RequeueAfter != pendingRequeueAfter {
+				t.Errorf("expected a requeue after %s while the clone runs, got %+v", pendingRequeueAfter, result)
+			}
+			if tt.condition == batchv1.JobFailed && result.RequeueAfter != 0 {
+				t.Errorf("expected a failed clone not to be requeued, got %+v", result)
+			}
+			_, err := f.kubeclient.BatchV1().Jobs(instance.Namespace).Get(ctx, job.Name, v1.GetOptions{})
+			if deleted := errors.IsNotFound(err); deleted != tt.wantCloned {
+				t.Errorf("expected the Job to be deleted %t, got %v", tt.wantCloned, err)
+			}
+		})
+	}
+}
+
+func TestCloneRunsOnce(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(f *fixture, instance *kubelitedbv1.SQLiteInstance)
+	}{
+		{
+			name:   "already cloned",
+			mutate: func(f *fixture, instance *kubelitedbv1.SQLiteInstance) { instance.Status.Cloned = true },
+		},
+		{
+			name: "existing StatefulSet",
+			mutate: func(f *fixture, instance *kubelitedbv1.SQLiteInstance) {
+				f.addKubeObject(newStatefulSet(instance))
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := newCloningSQLiteInstance("staging", "production")
+			tt.mutate(f, instance)
+			f.addInstance(instance)
+			f.addInstance(newReadySQLiteInstance("production"))
+			c, _, _ := f.newController(ctx)
+
+			f.run(ctx, c, getKey(instance, t))
+
+			if n := len(writes(f.kubeclient.Actions(), "jobs")); n > 0 {
+				t.Errorf("expected the database not to be cloned again, got %d Job writes", n)
+			}
+		})
+	}
+}
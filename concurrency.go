@@ -0,0 +1,115 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+	"sync"
+
+	"k8s.io/client-go/transport"
+)
+
+// limitConcurrentWrites returns a transport wrapper letting at most limit
+// requests creating, updating or deleting objects be in flight at a time, so
+// a burst of reconciles doesn't overwhelm the API server. Reads, including
+// the watches of the informers, are not limited. Writes are not limited when
+// limit is 0.
+func limitConcurrentWrites(limit int) transport.WrapperFunc {
+	return func(next http.RoundTripper) http.RoundTripper {
+		if limit <= 0 {
+			return next
+		}
+		return &writeLimitingRoundTripper{
+			next:  next,
+			slots: make(chan struct{}, limit),
+		}
+	}
+}
+
+// writeLimitingRoundTripper bounds the number of concurrent write requests
+// with a semaphore
+type writeLimitingRoundTripper struct {
+	next  http.RoundTripper
+	slots chan struct{}
+}
+
+// RoundTrip waits for a free slot before sending a write request, or until
+// the request is cancelled.
+func (rt *writeLimitingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return rt.next.RoundTrip(req)
+	}
+	select {
+	case rt.slots <- struct{}{}:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	defer func() { <-rt.slots }()
+	return rt.next.RoundTrip(req)
+}
+
+// WrappedRoundTripper returns the wrapped transport, so client-go can still
+// cancel requests on it.
+func (rt *writeLimitingRoundTripper) WrappedRoundTripper() http.RoundTripper {
+	return rt.next
+}
+
+// keyMutex serializes the work on a key, independent of the workqueue
+// handing out every key to a single worker at a time.
+type keyMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+// keyLock is the lock of a single key, with the number of workers holding or
+// waiting for it, so it can be dropped once unused.
+type keyLock struct {
+	sync.Mutex
+	users int
+}
+
+// newKeyMutex returns a keyMutex with no key locked.
+func newKeyMutex() *keyMutex {
+	return &keyMutex{locks: map[string]*keyLock{}}
+}
+
+// Lock blocks until the key is not locked by any other worker, and locks it.
+func (m *keyMutex) Lock(key string) {
+	m.mu.Lock()
+	lock, ok := m.locks[key]
+	if !ok {
+		lock = &keyLock{}
+		m.locks[key] = lock
+	}
+	lock.users++
+	m.mu.Unlock()
+
+	lock.Lock()
+}
+
+// Unlock unlocks the key, which must be locked.
+func (m *keyMutex) Unlock(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	lock := m.locks[key]
+	lock.users--
+	if lock.users == 0 {
+		delete(m.locks, key)
+	}
+	lock.Unlock()
+}
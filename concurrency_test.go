@@ -0,0 +1,269 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// blockingRoundTripper holds every request until released, recording how
+// many were in flight at most.
+type blockingRoundTripper struct {
+	release chan struct{}
+
+	mu       sync.Mutex
+	inFlight int
+	max      int
+}
+
+func newBlockingRoundTripper() *blockingRoundTripper {
+	return &blockingRoundTripper{release: make(chan struct{})}
+}
+
+func (rt *blockingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.mu.Lock()
+	rt.inFlight++
+	rt.max = max(rt.max, rt.inFlight)
+	rt.mu.Unlock()
+	<-rt.release
+	rt.mu.Lock()
+	rt.inFlight--
+	rt.mu.Unlock()
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+}
+
+// observed returns how many requests are in flight, and how many were at most.
+func (rt *blockingRoundTripper) observed() (int, int) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return rt.inFlight, rt.max
+}
+
+func TestLimitConcurrentWrites(t *testing.T) {
+	const requests = 8
+	tests := []struct {
+		name   string
+		limit  int
+		method string
+		want   int
+	}{
+		{name: "unlimited", method: http.MethodPost, want: requests},
+		{name: "single write", limit: 1, method: http.MethodPost, want: 1},
+		{name: "creates", limit: 3, method: http.MethodPost, want: 3},
+		{name: "updates", limit: 3, method: http.MethodPut, want: 3},
+		{name: "patches", limit: 3, method: http.MethodPatch, want: 3},
+		{name: "deletes", limit: 3, method: http.MethodDelete, want: 3},
+		{name: "limit above the requests", limit: 20, method: http.MethodPost, want: requests},
+		{name: "reads", limit: 1, method: http.MethodGet, want: requests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := newBlockingRoundTripper()
+			rt := limitConcurrentWrites(tt.limit)(backend)
+
+			var wg sync.WaitGroup
+			for i := 0; i < requests; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					req, _ := http.NewRequest(tt.method, "https://kubernetes.default.svc/api/v1/namespaces/default/configmaps", nil)
+					if _, err := rt.RoundTrip(req); err != nil {
+						t.Errorf("error sending the request: %v", err)
+					}
+				}()
+			}
+			err := wait.PollUntilContextTimeout(context.Background(), 5*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+				inFlight, _ := backend.observed()
+				return inFlight >= tt.want, nil
+			})
+			if err != nil {
+				t.Fatalf("timed out waiting for %d requests in flight", tt.want)
+			}
+			// Give the requests held back the chance to get through
+			time.Sleep(20 * time.Millisecond)
+			close(backend.release)
+			wg.Wait()
+
+			if _, got := backend.observed(); got != tt.want {
+				t.Errorf("expected at most %d requests in flight, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestLimitConcurrentWritesCancelled(t *testing.T) {
+	backend := newBlockingRoundTripper()
+	rt := limitConcurrentWrites(1)(backend)
+
+	// Take the only slot
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		req, _ := http.NewRequest(http.MethodPost, "https://kubernetes.default.svc/api/v1/namespaces/default/configmaps", nil)
+		rt.RoundTrip(req)
+	}()
+	err := wait.PollUntilContextTimeout(context.Background(), 5*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+		inFlight, _ := backend.observed()
+		return inFlight == 1, nil
+	})
+	if err != nil {
+		t.Fatalf("timed out waiting for the first write")
+	}
+
+	// A write waiting for a slot gives up once cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "https://kubernetes.default.svc/api/v1/namespaces/default/configmaps", nil)
+	if _, err := rt.RoundTrip(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the write to give up once cancelled, got %v", err)
+	}
+
+	// A read doesn't wait for a slot
+	req, _ = http.NewRequest(http.MethodGet, "https://kubernetes.default.svc/api/v1/namespaces/default/configmaps", nil)
+	read := make(chan struct{})
+	go func() {
+		defer close(read)
+		rt.RoundTrip(req)
+	}()
+	err = wait.PollUntilContextTimeout(context.Background(), 5*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+		inFlight, _ := backend.observed()
+		return inFlight == 2, nil
+	})
+	if err != nil {
+		t.Errorf("expected the read to be sent while the write holds the slot")
+	}
+	close(backend.release)
+	<-done
+	<-read
+}
+
+func TestLimitConcurrentWritesUnwraps(t *testing.T) {
+	backend := newBlockingRoundTripper()
+	if rt := limitConcurrentWrites(0)(backend); rt != http.RoundTripper(backend) {
+		t.Errorf("expected the transport to be left as is without a limit, got %T", rt)
+	}
+	wrapped, ok := limitConcurrentWrites(2)(backend).(interface{ WrappedRoundTripper() http.RoundTripper })
+	if !ok {
+		t.Fatalf("expected the limited transport to expose the wrapped one")
+	}
+	if wrapped.WrappedRoundTripper() != http.RoundTripper(backend) {
+		t.Errorf("expected the wrapped transport to be the backend")
+	}
+}
+
+// TestLimitConcurrentWritesClientset wraps the transport of a clientset the
+// way main does, and creates ConfigMaps from many workers at once.
+func TestLimitConcurrentWritesClientset(t *testing.T) {
+	const limit, workers = 2, 6
+	var mu sync.Mutex
+	var inFlight, most int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		most = max(most, inFlight)
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"test","namespace":"default"}}`))
+	}))
+	defer server.Close()
+
+	cfg := &rest.Config{Host: server.URL, QPS: -1}
+	cfg.Wrap(limitConcurrentWrites(limit))
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		t.Fatalf("error building the clientset: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			configMap := &corev1.ConfigMap{ObjectMeta: v1.ObjectMeta{Name: "test"}}
+			if _, err := client.CoreV1().ConfigMaps(v1.NamespaceDefault).Create(context.Background(), configMap, v1.CreateOptions{}); err != nil {
+				t.Errorf("error creating the ConfigMap: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if most > limit {
+		t.Errorf("expected at most %d creates in flight, got %d", limit, most)
+	}
+}
+
+func TestKeyMutex(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []string
+		// want is how many workers are expected to hold a lock at once
+		want int
+	}{
+		{name: "same key", keys: []string{"default/a", "default/a", "default/a", "default/a"}, want: 1},
+		{name: "different keys", keys: []string{"default/a", "default/b", "default/c"}, want: 3},
+		{name: "mixed keys", keys: []string{"default/a", "default/a", "default/b", "default/b"}, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newKeyMutex()
+			var mu sync.Mutex
+			var holding, most int
+			var wg sync.WaitGroup
+			for _, key := range tt.keys {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					m.Lock(key)
+					mu.Lock()
+					holding++
+					most = max(most, holding)
+					mu.Unlock()
+					time.Sleep(20 * time.Millisecond)
+					mu.Lock()
+					holding--
+					mu.Unlock()
+					m.Unlock(key)
+				}()
+			}
+			wg.Wait()
+
+			if most != tt.want {
+				t.Errorf("expected %d workers to hold a lock at once, got %d", tt.want, most)
+			}
+			// The locks are dropped once unused
+			if len(m.locks) != 0 {
+				t.Errorf("expected no locks to be kept, got %v", m.locks)
+			}
+		})
+	}
+}
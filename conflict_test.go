@@ -0,0 +1,167 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	core "k8s.io/client-go/testing"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// failStatusUpdates makes the first n status updates of SQLiteInstances fail
+// with err, or all of them when n is negative, calling before, when set,
+// ahead of each failure.
+func (f *fixture) failStatusUpdates(n int, err error, before func()) {
+	f.client.PrependReactor("update", "sqliteinstances", func(action core.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "status" || n == 0 {
+			return false, nil, nil
+		}
+		n--
+		if before != nil {
+			before()
+		}
+		return true, nil, err
+	})
+}
+
+// conflictError returns the error of a write to an outdated version of the
+// SQLiteInstance.
+func conflictError(instance *kubelitedbv1.SQLiteInstance) error {
+	return errors.NewConflict(schema.GroupResource{Group: kubelitedbv1.SchemeGroupVersion.Group, Resource: "sqliteinstances"},
+		instance.Name, fmt.Errorf("the object has been modified"))
+}
+
+// countActions returns the number of actions of the fake clientset with the
+// given verb and subresource on SQLiteInstances.
+func countActions(actions []core.Action, verb, subresource string) int {
+	count := 0
+	for _, action := range actions {
+		if action.GetResource().Resource == "sqliteinstances" && action.GetVerb() == verb && action.GetSubresource() == subresource {
+			count++
+		}
+	}
+	return count
+}
+
+func TestUpdateStatusRetriesConflicts(t *testing.T) {
+	conflict := conflictError(newSQLiteInstance("test"))
+	tests := []struct {
+		name     string
+		failures int
+		err      error
+		updates  int
+		gets     int
+		wantErr  bool
+	}{
+		{name: "no conflict", updates: 1},
+		{name: "conflict once", failures: 1, err: conflict, updates: 2, gets: 1},
+		{name: "conflicts until the retries run out", failures: -1, err: conflict, updates: 5, gets: 5, wantErr: true},
+		{name: "other error", failures: 1, err: fmt.Errorf("connection refused"), updates: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := newSQLiteInstance("test")
+			f.addInstance(instance)
+			c, _, _ := f.newController(ctx)
+			f.failStatusUpdates(tt.failures, tt.err, nil)
+
+			status := instance.Status.DeepCopy()
+			status.ObservedGeneration = instance.Generation
+			err := c.updateSQLiteInstanceStatus(ctx, instance, status)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %t, got %v", tt.wantErr, err)
+			}
+			actions := f.client.Actions()
+			if got := countActions(actions, "update", "status"); got != tt.updates {
+				t.Errorf("expected %d status updates, got %d", tt.updates, got)
+			}
+			if got := countActions(actions, "get", ""); got != tt.gets {
+				t.Errorf("expected %d gets of the latest SQLiteInstance, got %d", tt.gets, got)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := f.getInstance(ctx, instance); got.Status.ObservedGeneration != instance.Generation {
+				t.Errorf("expected the status to be written, got observed generation %d", got.Status.ObservedGeneration)
+			}
+		})
+	}
+}
+
+func TestUpdateStatusConflictKeepsLatestBackup(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+
+	// The backup controller records a backup between the read of the
+	// SQLiteInstance and the write of its status
+	lastBackup := v1.NewTime(time.Now().Add(-time.Minute).Truncate(time.Second))
+	f.failStatusUpdates(1, conflictError(instance), func() {
+		backedUp := instance.DeepCopy()
+		recordBackup(&backedUp.Status, lastBackup, "s3://backups/default/test/nightly/")
+		if err := f.client.Tracker().Update(kubelitedbv1.SchemeGroupVersion.WithResource("sqliteinstances"), backedUp, instance.Namespace); err != nil {
+			t.Errorf("error recording the backup: %v", err)
+		}
+	})
+
+	status := instance.Status.DeepCopy()
+	status.ObservedGeneration = instance.Generation
+	if err := c.updateSQLiteInstanceStatus(ctx, instance, status); err != nil {
+		t.Fatalf("error updating the status: %v", err)
+	}
+
+	got := f.getInstance(ctx, instance)
+	if got.Status.ObservedGeneration != instance.Generation {
+		t.Errorf("expected the status to be written, got observed generation %d", got.Status.ObservedGeneration)
+	}
+	if got.Status.LastBackupTime == nil || !got.Status.LastBackupTime.Equal(&lastBackup) {
+		t.Errorf("expected the backup at %v to be kept, got %v", lastBackup, got.Status.LastBackupTime)
+	}
+}
+
+func TestSyncStatusConflictIsNotResynced(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+	f.failStatusUpdates(1, conflictError(instance), nil)
+
+	// The conflict is retried within the sync, rather than failing it
+	f.run(ctx, c, getKey(instance, t))
+
+	if actions := writes(f.kubeclient.Actions(), "statefulsets"); len(actions) != 1 {
+		t.Errorf("expected the StatefulSet to be created once, got %v", actions)
+	}
+	if got := f.getInstance(ctx, instance); got.Status.Phase == "" {
+		t.Errorf("expected the status to be written")
+	}
+}
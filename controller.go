@@ -18,20 +18,37 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"hash/fnv"
+	"sync"
 	"time"
 
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/api/resource"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	"k8s.io/apimachinery/pkg/util/wait"
+	appsinformers "k8s.io/client-go/informers/apps/v1"
+	batchinformers "k8s.io/client-go/informers/batch/v1"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	policyinformers "k8s.io/client-go/informers/policy/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	policylisters "k8s.io/client-go/listers/policy/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 
@@ -40,6 +57,8 @@ import (
 	kubelitedbscheme "github.com/fortytwoapps/kubelitedb/pkg/generated/clientset/versioned/scheme"
 	informers "github.com/fortytwoapps/kubelitedb/pkg/generated/informers/externalversions/kubelitedb/v1"
 	listers "github.com/fortytwoapps/kubelitedb/pkg/generated/listers/kubelitedb/v1"
+	"github.com/fortytwoapps/kubelitedb/pkg/metrics"
+	"github.com/fortytwoapps/kubelitedb/pkg/validation"
 )
 
 const controllerAgentName = "kubelitedb-controller"
@@ -48,15 +67,97 @@ const (
 	// SuccessSynced is used as part of the Event 'reason' when a SQLiteInstance is synced
 	SuccessSynced = "Synced"
 	// ErrResourceExists is used as part of the Event 'reason' when a SQLiteInstance fails
-	// to sync due to a Deployment of the same name already existing.
+	// to sync due to a StatefulSet of the same name already existing.
 	ErrResourceExists = "ErrResourceExists"
 
 	// MessageResourceExists is the message used for Events when a resource
-	// fails to sync due to a Deployment already existing
+	// fails to sync due to a StatefulSet already existing
 	MessageResourceExists = "Resource %q already exists and is not managed by SQLiteInstance"
 	// MessageResourceSynced is the message used for an Event fired when a SQLiteInstance
 	// is synced successfully
 	MessageResourceSynced = "SQLiteInstance synced successfully"
+	// MessageInvalidDbName is the message used when the database name of a
+	// SQLiteInstance is not a safe file name
+	MessageInvalidDbName = "Invalid database name: %v"
+	// MessageInvalidPragmas is the message used when the pragmas of a
+	// SQLiteInstance are not supported
+	MessageInvalidPragmas = "Invalid pragmas: %v"
+	// MessageInvalidStorage is the message recorded in the status when the
+	// requested storage of a SQLiteInstance cannot be parsed
+	MessageInvalidStorage = "Invalid storage %q: %v"
+)
+
+const (
+	// ReasonInvalidDbName is used as the condition reason when the database
+	// name is not a safe file name
+	ReasonInvalidDbName = "InvalidDbName"
+	// ReasonInvalidPragmas is used as the condition reason when the pragmas
+	// are not supported
+	ReasonInvalidPragmas = "InvalidPragmas"
+	// ReasonInvalidStorage is used as the condition reason when the requested
+	// storage of a SQLiteInstance cannot be parsed
+	ReasonInvalidStorage = "InvalidStorage"
+	// ReasonVolumeClaimTemplateConfigured is used as the condition reason when
+	// the StatefulSet requests the storage of the SQLiteInstance
+	ReasonVolumeClaimTemplateConfigured = "VolumeClaimTemplateConfigured"
+	// ReasonWriterWouldBeRemoved is used as the condition reason when a scale
+	// down was blocked as it would remove the writer pod
+	ReasonWriterWouldBeRemoved = "WriterWouldBeRemoved"
+	// ReasonStorageClassImmutable is used as the condition reason when the
+	// storage class was changed after the volumes were provisioned
+	ReasonStorageClassImmutable = "StorageClassImmutable"
+	// ReasonPaused is used as the condition reason while reconciliation is
+	// paused
+	ReasonPaused = "Paused"
+	// ReasonAccessModeImmutable is used as the condition reason when the
+	// access mode was changed after the volumes were provisioned
+	ReasonAccessModeImmutable = "AccessModeImmutable"
+	// ReasonReconcileComplete is used as the condition reason when no changes
+	// were needed to match the spec
+	ReasonReconcileComplete = "ReconcileComplete"
+	// ReasonReplicasNotReady is used as the condition reason while not every
+	// pod of the StatefulSet is ready
+	ReasonReplicasNotReady = "ReplicasNotReady"
+
+	// MessageScaleDownBlocked is the message used when a scale down to zero
+	// replicas was blocked
+	MessageScaleDownBlocked = "Keeping %d replicas, scaling to 0 removes the writer pod unless the %s annotation is set to \"true\""
+	// MessageStorageClassImmutable is the message used when the storage
+	// class was changed after the volumes were provisioned
+	MessageStorageClassImmutable = "Storage class cannot be changed from %s to %s once the volumes are provisioned"
+	// MessageAccessModeImmutable is the message used when the access mode
+	// was changed after the volumes were provisioned
+	MessageAccessModeImmutable = "Access mode cannot be changed from %s to %s once the volumes are provisioned"
+	// MessagePaused is the message used while reconciliation is paused
+	MessagePaused = "Reconciliation is paused by the " + kubelitedbv1.PauseAnnotation + " annotation"
+	// MessageReplicasNotReady is the message used while not every pod of the
+	// StatefulSet is ready
+	MessageReplicasNotReady = "%d of %d replicas are ready"
+)
+
+// defaultImage is the container image used to run SQLite when the
+// SQLiteInstance does not specify one. It is set by --default-image, for
+// example to pull from a mirror.
+var defaultImage = "ghcr.io/fortytwoapps/kubelitedb:latest"
+
+const (
+	// defaultCPURequest and defaultMemoryRequest are requested by the SQLite
+	// container when the SQLiteInstance does not specify any resources
+	defaultCPURequest    = "100m"
+	defaultMemoryRequest = "128Mi"
+	// defaultTerminationGracePeriodSeconds gives the pods time to checkpoint
+	// the WAL and let Litestream replicate it before they are killed
+	defaultTerminationGracePeriodSeconds = 60
+	// dataVolumeName is the name of the volume holding the SQLite database
+	dataVolumeName = "data"
+	// dataMountPath is where the data volume is mounted in the SQLite container
+	dataMountPath = "/data"
+	// pendingRequeueAfter is how long to wait before checking a pending
+	// SQLiteInstance again
+	pendingRequeueAfter = 15 * time.Second
+	// templateHashAnnotation records the hash of the generated pod template so
+	// changes to the spec can be detected and rolled out
+	templateHashAnnotation = "kubelitedb.fortytwoapps.tech/template-hash"
 )
 
 // Controller is the controller implementation for SQLiteInstance resources
@@ -66,9 +167,63 @@ type Controller struct {
 
 	sqliteInstancesLister listers.SQLiteInstanceLister
 	sqliteInstancesSynced cache.InformerSynced
+	statefulSetsLister    appslisters.StatefulSetLister
+	statefulSetsSynced    cache.InformerSynced
+	pvcsLister            corelisters.PersistentVolumeClaimLister
+	pvcsSynced            cache.InformerSynced
+	deploymentsLister     appslisters.DeploymentLister
+	deploymentsSynced     cache.InformerSynced
+	servicesLister        corelisters.ServiceLister
+	servicesSynced        cache.InformerSynced
+	configMapsLister      corelisters.ConfigMapLister
+	configMapsSynced      cache.InformerSynced
+	pdbsLister            policylisters.PodDisruptionBudgetLister
+	pdbsSynced            cache.InformerSynced
+	cronJobsLister        batchlisters.CronJobLister
+	cronJobsSynced        cache.InformerSynced
 
 	workqueue workqueue.RateLimitingInterface
 	recorder  record.EventRecorder
+
+	// replicaLimit bounds the number of replicas of every SQLiteInstance
+	replicaLimit ReplicaLimit
+	// storagePressureThreshold is the percentage of a data volume that can
+	// be used before the StoragePressure condition is set. The usage is not
+	// collected when 0.
+	storagePressureThreshold int
+
+	// resyncJitter is the longest periodic resyncs of a SQLiteInstance are
+	// delayed by
+	resyncJitter time.Duration
+
+	// keyLocks serializes the reconciles of every SQLiteInstance
+	keyLocks *keyMutex
+
+	// debounce holds back the reconcile of edited SQLiteInstances until the
+	// edits settle
+	debounce *debouncer
+
+	// rolloutProgressDeadline is how long a rollout of the StatefulSet can
+	// take before it is reported as stalled
+	rolloutProgressDeadline time.Duration
+
+	// hostPathBase is the directory on the nodes below which SQLiteInstances
+	// in the DaemonSet mode keep their databases
+	hostPathBase string
+
+	// orphansCleaned records, by key, which SQLiteInstances the orphaned
+	// child objects were cleaned up for
+	orphansMu      sync.Mutex
+	orphansCleaned map[string]orphansCleaned
+
+	// fullReconciles records, by key, the last full reconcile of every
+	// SQLiteInstance, to skip the reconciles that would change nothing
+	fullReconcilesMu sync.Mutex
+	fullReconciles   map[string]fullReconcile
+
+	// dryRun makes every write to the API server a dry run, so the changes
+	// the controller would make are only reported
+	dryRun bool
 }
 
 // NewController returns a new KubeLiteDB controller
@@ -76,29 +231,59 @@ func NewController(
 	ctx context.Context,
 	kubeclientset kubernetes.Interface,
 	kubelitedbclientset clientset.Interface,
-	sqliteInstanceInformer informers.SQLiteInstanceInformer) *Controller {
+	sqliteInstanceInformer informers.SQLiteInstanceInformer,
+	statefulSetInformer appsinformers.StatefulSetInformer,
+	pvcInformer coreinformers.PersistentVolumeClaimInformer,
+	deploymentInformer appsinformers.DeploymentInformer,
+	serviceInformer coreinformers.ServiceInformer,
+	configMapInformer coreinformers.ConfigMapInformer,
+	pdbInformer policyinformers.PodDisruptionBudgetInformer,
+	cronJobInformer batchinformers.CronJobInformer,
+	rateLimiterOptions RateLimiterOptions,
+	replicaLimit ReplicaLimit,
+	storagePressureThreshold int,
+	resyncJitter time.Duration,
+	debounceWindow time.Duration,
+	rolloutProgressDeadline time.Duration,
+	hostPathBase string,
+	dryRun bool) *Controller {
 
 	logger := klog.FromContext(ctx)
 
-	// Create event broadcaster
-	// Add kubelitedb types to the default Kubernetes Scheme so Events can be
-	// logged for kubelitedb types.
-	utilruntime.Must(kubelitedbscheme.AddToScheme(scheme.Scheme))
-	logger.V(4).Info("Creating event broadcaster")
-
-	eventBroadcaster := record.NewBroadcaster(record.WithContext(ctx))
-	eventBroadcaster.StartStructuredLogging(0)
-	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeclientset.CoreV1().Events("")})
-	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: controllerAgentName})
+	recorder := newEventRecorder(ctx, kubeclientset, controllerAgentName)
 
 	controller := &Controller{
 		kubeclientset:       kubeclientset,
 		kubelitedbclientset: kubelitedbclientset,
 
-		sqliteInstancesLister: sqliteInstanceInformer.Lister(),
-		sqliteInstancesSynced: sqliteInstanceInformer.Informer().HasSynced,
-		workqueue:             workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "SQLiteInstances"),
-		recorder:              recorder,
+		sqliteInstancesLister:    sqliteInstanceInformer.Lister(),
+		sqliteInstancesSynced:    sqliteInstanceInformer.Informer().HasSynced,
+		statefulSetsLister:       statefulSetInformer.Lister(),
+		statefulSetsSynced:       statefulSetInformer.Informer().HasSynced,
+		pvcsLister:               pvcInformer.Lister(),
+		pvcsSynced:               pvcInformer.Informer().HasSynced,
+		deploymentsLister:        deploymentInformer.Lister(),
+		deploymentsSynced:        deploymentInformer.Informer().HasSynced,
+		servicesLister:           serviceInformer.Lister(),
+		servicesSynced:           serviceInformer.Informer().HasSynced,
+		configMapsLister:         configMapInformer.Lister(),
+		configMapsSynced:         configMapInformer.Informer().HasSynced,
+		pdbsLister:               pdbInformer.Lister(),
+		pdbsSynced:               pdbInformer.Informer().HasSynced,
+		cronJobsLister:           cronJobInformer.Lister(),
+		cronJobsSynced:           cronJobInformer.Informer().HasSynced,
+		workqueue:                workqueue.NewNamedRateLimitingQueue(newRateLimiter(rateLimiterOptions), "SQLiteInstances"),
+		recorder:                 recorder,
+		replicaLimit:             replicaLimit,
+		storagePressureThreshold: storagePressureThreshold,
+		resyncJitter:             resyncJitter,
+		debounce:                 newDebouncer(debounceWindow),
+		keyLocks:                 newKeyMutex(),
+		rolloutProgressDeadline:  rolloutProgressDeadline,
+		hostPathBase:             hostPathBase,
+		orphansCleaned:           map[string]orphansCleaned{},
+		fullReconciles:           map[string]fullReconcile{},
+		dryRun:                   dryRun,
 	}
 
 	logger.Info("Setting up event handlers")
@@ -106,46 +291,155 @@ func NewController(
 	sqliteInstanceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: controller.enqueueSQLiteInstance,
 		UpdateFunc: func(old, new interface{}) {
-			controller.enqueueSQLiteInstance(new)
+			oldInstance, newInstance := old.(*kubelitedbv1.SQLiteInstance), new.(*kubelitedbv1.SQLiteInstance)
+			if !sqliteInstanceChanged(oldInstance, newInstance) {
+				return
+			}
+			// Periodic resyncs deliver every SQLiteInstance at once, so they
+			// are spread out rather than reconciled in a burst.
+			if oldInstance.ResourceVersion == newInstance.ResourceVersion {
+				controller.enqueueSQLiteInstanceWithJitter(new)
+				return
+			}
+			controller.enqueueSQLiteInstanceDebounced(new)
 		},
 		DeleteFunc: controller.enqueueSQLiteInstance,
 	})
+	// Set up an event handler for when StatefulSet or PVC resources change. This
+	// handler will lookup the owner of the given resource, and if it is owned by
+	// a SQLiteInstance resource then the handler will enqueue that SQLiteInstance
+	// resource for processing. This way, we don't need to implement custom logic
+	// for handling StatefulSet or PVC resources. More info on this pattern:
+	// https://github.com/kubernetes/community/blob/8cafef897a22026d42f5e5bb3f104febe7e29830/contributors/devel/controllers.md
+	childHandler := cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.handleObject,
+		UpdateFunc: func(old, new interface{}) {
+			newMeta := new.(v1.Object)
+			oldMeta := old.(v1.Object)
+			if newMeta.GetResourceVersion() == oldMeta.GetResourceVersion() {
+				// Periodic resync will send update events for all known objects.
+				// Two different versions of the same object will always have
+				// different RVs.
+				return
+			}
+			controller.handleObject(new)
+		},
+		DeleteFunc: controller.handleObject,
+	}
+	statefulSetInformer.Informer().AddEventHandler(childHandler)
+	pvcInformer.Informer().AddEventHandler(childHandler)
+	// The ready read-only pods are reported in the status
+	deploymentInformer.Informer().AddEventHandler(childHandler)
+	// The other child objects are watched so a reconcile skipped by inSync
+	// still repairs them when they are changed or deleted.
+	serviceInformer.Informer().AddEventHandler(childHandler)
+	configMapInformer.Informer().AddEventHandler(childHandler)
+	pdbInformer.Informer().AddEventHandler(childHandler)
+	cronJobInformer.Informer().AddEventHandler(childHandler)
 
 	return controller
 }
 
+// sqliteInstanceChanged reports whether an update of a SQLiteInstance needs to
+// be reconciled. Updates that only change the status, such as the ones made by
+// the controller itself, leave the generation and metadata untouched and are
+// skipped, except for backups recorded by the backup controller. Periodic
+// resyncs, which do not change the resource version, are still reconciled to
+// correct any drift.
+func sqliteInstanceChanged(old, new *kubelitedbv1.SQLiteInstance) bool {
+	if old.ResourceVersion == new.ResourceVersion {
+		return true
+	}
+	return old.Generation != new.Generation ||
+		!new.DeletionTimestamp.Equal(old.DeletionTimestamp) ||
+		!equality.Semantic.DeepEqual(old.Labels, new.Labels) ||
+		!equality.Semantic.DeepEqual(old.Annotations, new.Annotations) ||
+		!equality.Semantic.DeepEqual(old.Finalizers, new.Finalizers) ||
+		!old.Status.LastBackupTime.Equal(new.Status.LastBackupTime)
+}
+
+// newEventRecorder returns an EventRecorder recording Events for the given
+// component to the API server.
+func newEventRecorder(ctx context.Context, kubeclientset kubernetes.Interface, component string) record.EventRecorder {
+	logger := klog.FromContext(ctx)
+
+	// Create event broadcaster
+	// Add kubelitedb types to the default Kubernetes Scheme so Events can be
+	// logged for kubelitedb types.
+	utilruntime.Must(kubelitedbscheme.AddToScheme(scheme.Scheme))
+	logger.V(4).Info("Creating event broadcaster", "component", component)
+
+	eventBroadcaster := record.NewBroadcaster(record.WithContext(ctx))
+	eventBroadcaster.StartStructuredLogging(0)
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeclientset.CoreV1().Events("")})
+	return eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: component})
+}
+
 // Run will set up the event handlers for types we are interested in, as well
-// as syncing informer caches and starting workers. It will block until stopCh
-// is closed, at which point it will shutdown the workqueue and wait for
-// workers to finish processing their current work items.
-func (c *Controller) Run(ctx context.Context, workers int) error {
+// as syncing informer caches and starting workers. It will block until the
+// context is cancelled, at which point it will shutdown the workqueue. With a
+// shutdown timeout, the workers first get up to that long to finish processing
+// the queued work items, so SQLiteInstances are not left half-reconciled.
+func (c *Controller) Run(ctx context.Context, workers int, shutdownTimeout time.Duration) error {
 	defer utilruntime.HandleCrash()
 	defer c.workqueue.ShutDown()
 	logger := klog.FromContext(ctx)
 
+	if workers < 1 {
+		return fmt.Errorf("invalid number of workers %d: must be at least 1", workers)
+	}
+
 	// Start the informer factories to begin populating the informer caches
 	logger.Info("Starting KubeLiteDB controller")
 
 	// Wait for the caches to be synced before starting workers
 	logger.Info("Waiting for informer caches to sync")
 
-	if ok := cache.WaitForCacheSync(ctx.Done(), c.sqliteInstancesSynced); !ok {
+	if ok := cache.WaitForCacheSync(ctx.Done(), c.sqliteInstancesSynced, c.statefulSetsSynced, c.pvcsSynced, c.deploymentsSynced,
+		c.servicesSynced, c.configMapsSynced, c.pdbsSynced, c.cronJobsSynced); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
+	// The workers outlive the context while the workqueue is drained, so the
+	// API requests of the in-flight syncs are not cancelled.
+	workerCtx, cancelWorkers := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelWorkers()
+
 	logger.Info("Starting workers", "count", workers)
 	// Launch workers to process SQLiteInstance resources
 	for i := 0; i < workers; i++ {
-		go wait.UntilWithContext(ctx, c.runWorker, time.Second)
+		go wait.UntilWithContext(workerCtx, c.runWorker, time.Second)
 	}
 
 	logger.Info("Started workers")
 	<-ctx.Done()
-	logger.Info("Shutting down workers")
+	if shutdownTimeout <= 0 {
+		logger.Info("Shutting down workers")
+		return nil
+	}
+
+	logger.Info("Draining workqueue before shutting down workers", "timeout", shutdownTimeout)
+	drained := make(chan struct{})
+	go func() {
+		c.workqueue.ShutDownWithDrain()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		logger.Info("Drained workqueue, shutting down workers")
+	case <-time.After(shutdownTimeout):
+		logger.Info("Timed out draining workqueue, shutting down workers", "timeout", shutdownTimeout)
+	}
 
 	return nil
 }
 
+// HasSynced returns whether the informer caches of the controller have synced
+func (c *Controller) HasSynced() bool {
+	return c.sqliteInstancesSynced() && c.statefulSetsSynced() && c.pvcsSynced() && c.deploymentsSynced() &&
+		c.servicesSynced() && c.configMapsSynced() && c.pdbsSynced() && c.cronJobsSynced()
+}
+
 // runWorker is a long-running function that will continually call the
 // processNextWorkItem function in order to read and process a message on the
 // workqueue.
@@ -159,6 +453,7 @@ func (c *Controller) runWorker(ctx context.Context) {
 func (c *Controller) processNextWorkItem(ctx context.Context) bool {
 	obj, shutdown := c.workqueue.Get()
 	logger := klog.FromContext(ctx)
+	metrics.WorkqueueDepth.Set(float64(c.workqueue.Len()))
 
 	if shutdown {
 		return false
@@ -174,16 +469,32 @@ func (c *Controller) processNextWorkItem(ctx context.Context) bool {
 			utilruntime.HandleError(fmt.Errorf("expected string in workqueue but got %#v", obj))
 			return nil
 		}
+		// A SQLiteInstance that is still being edited is synced once the
+		// edits settle.
+		if wait := c.debounce.wait(key, time.Now()); wait > 0 {
+			c.workqueue.AddAfter(key, wait)
+			return nil
+		}
 		// Run the syncHandler, passing it the namespace/name string of the
 		// SQLiteInstance resource to be synced.
-		if err := c.syncHandler(ctx, key); err != nil {
+		start := time.Now()
+		c.keyLocks.Lock(key)
+		result, err := c.syncHandler(ctx, key)
+		c.keyLocks.Unlock(key)
+		metrics.ReconcileDuration.Observe(time.Since(start).Seconds())
+		if err != nil {
+			metrics.ReconcileTotal.WithLabelValues(metrics.ResultError).Inc()
+			c.recordSyncError(ctx, key, err)
 			// Put the item back on the workqueue to handle any transient errors.
 			c.workqueue.AddRateLimited(key)
+			metrics.AddRetry(key)
 			return fmt.Errorf("error syncing '%s': %s, requeuing", key, err.Error())
 		}
-		// Finally, if no error occurs we Forget this item so it does not
-		// get queued again until another change happens.
-		c.workqueue.Forget(obj)
+		metrics.ReconcileTotal.WithLabelValues(metrics.ResultSuccess).Inc()
+		if c.requeueSynced(key, result) {
+			logger.Info("Successfully synced, requeuing", "resourceName", key)
+			return nil
+		}
 		logger.Info("Successfully synced", "resourceName", key)
 		return nil
 	}(obj)
@@ -198,14 +509,12 @@ func (c *Controller) processNextWorkItem(ctx context.Context) bool {
 
 // syncHandler compares the actual state with the desired, and attempts to
 // converge the two. It then updates the Status block of the SQLiteInstance resource
-// with the current status of the resource.
-func (c *Controller) syncHandler(ctx context.Context, key string) error {
-	// logger := klog.LoggerWithValues(klog.FromContext(ctx), "resourceName", key)
-
+// with the current status of the resource. The result says when to sync it again.
+func (c *Controller) syncHandler(ctx context.Context, key string) (reconcileResult, error) {
 	namespace, name, err := cache.SplitMetaNamespaceKey(key)
 	if err != nil {
 		utilruntime.HandleError(fmt.Errorf("invalid resource key: %s", key))
-		return nil
+		return reconcileResult{}, nil
 	}
 
 	// Get the SQLiteInstance resource with this namespace/name
@@ -213,117 +522,802 @@ func (c *Controller) syncHandler(ctx context.Context, key string) error {
 	if err != nil {
 		if errors.IsNotFound(err) {
 			utilruntime.HandleError(fmt.Errorf("sqliteinstance '%s' in work queue no longer exists", key))
-			return nil
+			metrics.DeleteInstance(key)
+			c.forgetOrphansCleaned(key)
+			c.forgetFullReconcile(key)
+			return reconcileResult{}, nil
 		}
-		return err
+		return reconcileResult{}, err
 	}
 
-	// Ensure the PVC exists
-	pvcName := fmt.Sprintf("%s-pvc", sqliteInstance.Name)
-	_, err = c.kubeclientset.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, pvcName, v1.GetOptions{})
-	if errors.IsNotFound(err) {
-		// Create the PVC
-		pvc := newPVC(sqliteInstance, pvcName)
-		_, err = c.kubeclientset.CoreV1().PersistentVolumeClaims(namespace).Create(ctx, pvc, v1.CreateOptions{})
+	// Every log line of the sync, including the ones of the helpers it calls,
+	// carries the SQLiteInstance it is about.
+	logger := klog.LoggerWithValues(klog.FromContext(ctx),
+		"namespace", namespace,
+		"name", name,
+		"generation", sqliteInstance.Generation,
+		"phase", sqliteInstance.Status.Phase,
+	)
+	ctx = klog.NewContext(ctx, logger)
+
+	// A SQLiteInstance that is being deleted only needs its external resources
+	// cleaned up, the child objects are garbage collected by Kubernetes.
+	if sqliteInstance.DeletionTimestamp != nil {
+		return c.finalizeSQLiteInstance(ctx, sqliteInstance)
+	}
+
+	// The status is computed while syncing and written once at the end. A
+	// sync writing the status succeeded, clearing the last error.
+	status := sqliteInstance.Status.DeepCopy()
+	status.LastError = nil
+
+	// A paused SQLiteInstance is left as is until the annotation is removed,
+	// which enqueues it again.
+	if sqliteInstance.Annotations[kubelitedbv1.PauseAnnotation] == "true" {
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionPaused, v1.ConditionTrue, ReasonPaused, MessagePaused)
+		return reconcileResult{}, c.updateSQLiteInstanceStatus(ctx, sqliteInstance, status)
 	}
+	meta.RemoveStatusCondition(&status.Conditions, kubelitedbv1.ConditionPaused)
+
+	sqliteInstance, err = c.ensureFinalizer(ctx, sqliteInstance)
 	if err != nil {
-		return err
+		return reconcileResult{}, err
+	}
+
+	// Most reconciles of a ready SQLiteInstance find nothing to change, so
+	// the child objects are only compared again once the spec changes or
+	// they were last compared a while ago.
+	if requeueAfter, ok := c.inSync(key, sqliteInstance, time.Now()); ok {
+		logger.V(4).Info("Child objects in sync, skipping reconcile")
+		return reconcileResult{RequeueAfter: requeueAfter}, nil
+	}
+
+	// The database name becomes a path in the data directory, so a name that
+	// could escape it is never rolled out. Retrying won't fix it either.
+	if errs := validation.ValidateDbName(sqliteInstance.Spec.DbName, field.NewPath("spec", "dbName")); len(errs) > 0 {
+		msg := fmt.Sprintf(MessageInvalidDbName, errs.ToAggregate())
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionProgressing, v1.ConditionFalse, ReasonInvalidDbName, msg)
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionReady, v1.ConditionFalse, ReasonInvalidDbName, msg)
+		c.recorder.Event(sqliteInstance, corev1.EventTypeWarning, ReasonInvalidDbName, msg)
+		return reconcileResult{}, c.updateSQLiteInstanceStatus(ctx, sqliteInstance, status)
+	}
+
+	// The pragmas are interpolated into SQL statements, so only validated
+	// pragmas are ever rolled out.
+	if errs := validation.ValidatePragmas(sqliteInstance.Spec.Pragmas, field.NewPath("spec", "pragmas")); len(errs) > 0 {
+		msg := fmt.Sprintf(MessageInvalidPragmas, errs.ToAggregate())
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionProgressing, v1.ConditionFalse, ReasonInvalidPragmas, msg)
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionReady, v1.ConditionFalse, ReasonInvalidPragmas, msg)
+		c.recorder.Event(sqliteInstance, corev1.EventTypeWarning, ReasonInvalidPragmas, msg)
+		return reconcileResult{}, c.updateSQLiteInstanceStatus(ctx, sqliteInstance, status)
 	}
 
-	// Ensure the Pod exists
-	podName := fmt.Sprintf("%s-pod", sqliteInstance.Name)
-	_, err = c.kubeclientset.CoreV1().Pods(namespace).Get(ctx, podName, v1.GetOptions{})
+	// The requested storage is used to size the volume claim template of the
+	// StatefulSet. An unparseable value will not become valid by retrying, so
+	// we record the failure in the status and wait for the spec to be edited.
+	if _, err := resource.ParseQuantity(sqliteInstance.Spec.Storage); err != nil {
+		msg := fmt.Sprintf(MessageInvalidStorage, sqliteInstance.Spec.Storage, err)
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionStorageProvisioned, v1.ConditionFalse, ReasonInvalidStorage, msg)
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionProgressing, v1.ConditionFalse, ReasonInvalidStorage, msg)
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionReady, v1.ConditionFalse, ReasonInvalidStorage, msg)
+		c.recorder.Event(sqliteInstance, corev1.EventTypeWarning, ReasonInvalidStorage, msg)
+		return reconcileResult{}, c.updateSQLiteInstanceStatus(ctx, sqliteInstance, status)
+	}
+
+	// The volumes can't be shrunk, so a smaller request is never rolled out.
+	// It is only resolved by requesting at least the provisioned storage.
+	msg, err := c.storageShrunk(sqliteInstance)
+	if err != nil {
+		return reconcileResult{}, err
+	}
+	if msg != "" {
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionStorageProvisioned, v1.ConditionFalse, ReasonStorageShrinkForbidden, msg)
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionProgressing, v1.ConditionFalse, ReasonStorageShrinkForbidden, msg)
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionReady, v1.ConditionFalse, ReasonStorageShrinkForbidden, msg)
+		c.recorder.Event(sqliteInstance, corev1.EventTypeWarning, ReasonStorageShrinkForbidden, msg)
+		return reconcileResult{}, c.updateSQLiteInstanceStatus(ctx, sqliteInstance, status)
+	}
+
+	// The decrypt init container can't start without the key, so the
+	// StatefulSet is not rolled out until the key can be used.
+	if needsRestore(sqliteInstance) && sqliteInstance.Spec.RestoreFrom.Encryption != nil {
+		msg, err := checkBackupEncryptionKey(ctx, c.kubeclientset, namespace, sqliteInstance.Spec.RestoreFrom.Encryption)
+		if err != nil {
+			return reconcileResult{}, err
+		}
+		if msg != "" {
+			setCondition(status, sqliteInstance, kubelitedbv1.ConditionProgressing, v1.ConditionFalse, ReasonEncryptionKeyInvalid, msg)
+			setCondition(status, sqliteInstance, kubelitedbv1.ConditionReady, v1.ConditionFalse, ReasonEncryptionKeyInvalid, msg)
+			c.recorder.Event(sqliteInstance, corev1.EventTypeWarning, ReasonEncryptionKeyInvalid, msg)
+			return reconcileResult{}, c.updateSQLiteInstanceStatus(ctx, sqliteInstance, status)
+		}
+	}
+
+	// The replicas are bounded by the controller. Clamped instances are synced
+	// with the maximum from here on, rejected ones wait for the spec to be
+	// edited.
+	sqliteInstance, ok := c.limitReplicas(sqliteInstance, status)
+	if !ok {
+		return reconcileResult{}, c.updateSQLiteInstanceStatus(ctx, sqliteInstance, status)
+	}
+
+	// Instances sharing an existing volume must keep their databases apart.
+	// The conflict is only resolved by editing or deleting one of them.
+	msg, err = c.volumeConflict(sqliteInstance)
+	if err != nil {
+		return reconcileResult{}, err
+	}
+	if msg != "" {
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionStorageProvisioned, v1.ConditionFalse, ReasonVolumeConflict, msg)
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionProgressing, v1.ConditionFalse, ReasonVolumeConflict, msg)
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionReady, v1.ConditionFalse, ReasonVolumeConflict, msg)
+		c.recorder.Event(sqliteInstance, corev1.EventTypeWarning, ReasonVolumeConflict, msg)
+		return reconcileResult{}, c.updateSQLiteInstanceStatus(ctx, sqliteInstance, status)
+	}
+
+	// Instances in the DaemonSet mode keep a database on every node rather
+	// than running a StatefulSet, so none of the objects below apply to them.
+	if daemonSetMode(sqliteInstance) {
+		return c.syncDaemonSet(ctx, key, sqliteInstance, status)
+	}
+
+	// The headless Service governs the StatefulSet and gives every pod a stable
+	// DNS name, so it is synced first.
+	if err := c.syncHeadlessService(ctx, sqliteInstance); err != nil {
+		return reconcileResult{}, err
+	}
+
+	// Voluntary disruptions of multi-replica instances evict one pod at a time
+	if err := c.syncPodDisruptionBudget(ctx, sqliteInstance); err != nil {
+		return reconcileResult{}, err
+	}
+
+	// The Litestream configuration is mounted into the pods, so it has to
+	// exist before the StatefulSet is rolled out.
+	if err := c.syncLitestreamConfigMap(ctx, sqliteInstance); err != nil {
+		return reconcileResult{}, err
+	}
+
+	// The pragmas are applied by an init container, so they have to exist
+	// before the StatefulSet is rolled out as well.
+	if err := c.syncPragmasConfigMap(ctx, sqliteInstance); err != nil {
+		return reconcileResult{}, err
+	}
+
+	// Pods sharing a ReadWriteMany volume all mount the same PVC, which has
+	// to exist before the StatefulSet is rolled out.
+	sharedPVC, err := c.syncSharedDataPVC(ctx, sqliteInstance)
+	if err != nil {
+		return reconcileResult{}, err
+	}
+
+	// Pods of an unbound volume stay pending without saying why, so the
+	// StatefulSet is only created once the shared volume is bound. The PVCs
+	// are watched, the requeue covers an existing PVC from
+	// spec.volumeClaimName that isn't.
+	waiting, err := c.waitForSharedVolume(ctx, sqliteInstance, status, sharedPVC)
+	if err != nil {
+		return reconcileResult{}, err
+	}
+	if waiting {
+		if err := c.updateSQLiteInstanceStatus(ctx, sqliteInstance, status); err != nil {
+			return reconcileResult{}, err
+		}
+		return reconcileResult{RequeueAfter: pendingRequeueAfter}, nil
+	}
+
+	// A cloned database has to be in place before the first pod of the
+	// StatefulSet starts.
+	cloned, err := c.syncClone(ctx, sqliteInstance, status)
+	if err != nil {
+		return reconcileResult{}, err
+	}
+	if !cloned {
+		if err := c.updateSQLiteInstanceStatus(ctx, sqliteInstance, status); err != nil {
+			return reconcileResult{}, err
+		}
+		if meta.IsStatusConditionTrue(status.Conditions, kubelitedbv1.ConditionProgressing) {
+			return reconcileResult{RequeueAfter: pendingRequeueAfter}, nil
+		}
+		return reconcileResult{}, nil
+	}
+
+	// The pods are rolled whenever the certificate they serve TLS with is
+	// rotated.
+	tlsHash, err := c.tlsSecretHash(ctx, sqliteInstance)
+	if err != nil {
+		return reconcileResult{}, err
+	}
+
+	desired := newStatefulSet(sqliteInstance)
+	setTLSSecretHash(&desired.Spec.Template, tlsHash)
+
+	// Get the StatefulSet with the name derived from the SQLiteInstance
+	statefulSet, err := c.statefulSetsLister.StatefulSets(namespace).Get(statefulSetName(sqliteInstance))
+	// If the resource doesn't exist, we'll create it
+	progressing := false
 	if errors.IsNotFound(err) {
-		// Create the Pod
-		pod := newPod(sqliteInstance, podName, pvcName)
-		_, err = c.kubeclientset.CoreV1().Pods(namespace).Create(ctx, pod, v1.CreateOptions{})
+		statefulSet, err = c.kubeclientset.AppsV1().StatefulSets(namespace).Create(ctx, desired.DeepCopy(), c.createOptions(ctx, sqliteInstance, "StatefulSet", statefulSetName(sqliteInstance)))
+		logWrite(ctx, "create", "StatefulSet", statefulSetName(sqliteInstance), err)
+		progressing = true
 	}
+	// If an error occurs during Get/Create, we'll requeue the item so we can
+	// attempt processing again later. This could have been caused by a
+	// temporary network failure, or any other transient reason.
 	if err != nil {
-		return err
+		return reconcileResult{}, err
+	}
+
+	// If the StatefulSet is not controlled by this SQLiteInstance resource, we
+	// should log a warning to the event recorder and return an error msg.
+	if !v1.IsControlledBy(statefulSet, sqliteInstance) {
+		msg := fmt.Sprintf(MessageResourceExists, statefulSet.Name)
+		c.recorder.Event(sqliteInstance, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return reconcileResult{}, fmt.Errorf("%s", msg)
+	}
+
+	// The volume claim templates of a StatefulSet can't be changed, so
+	// switching between a shared volume and volumes per pod is never rolled
+	// out. Retrying won't fix it either.
+	if statefulSetSharesVolume(statefulSet) != sharesVolume(sqliteInstance) {
+		current := corev1.ReadWriteOnce
+		if statefulSetSharesVolume(statefulSet) {
+			current = corev1.ReadWriteMany
+		}
+		msg := fmt.Sprintf(MessageAccessModeImmutable, current, accessModeForInstance(sqliteInstance))
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionProgressing, v1.ConditionFalse, ReasonAccessModeImmutable, msg)
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionReady, v1.ConditionFalse, ReasonAccessModeImmutable, msg)
+		c.recorder.Event(sqliteInstance, corev1.EventTypeWarning, ReasonAccessModeImmutable, msg)
+		return reconcileResult{}, c.updateSQLiteInstanceStatus(ctx, sqliteInstance, status)
+	}
+
+	// Scaling to zero removes the writer pod, so the StatefulSet keeps its
+	// replicas unless data loss was explicitly allowed. Suspending keeps the
+	// volumes of the pods, so nothing is lost.
+	if *statefulSet.Spec.Replicas > 0 && *desired.Spec.Replicas == 0 && !sqliteInstance.Spec.Suspend && !validation.AllowsDataLoss(sqliteInstance) {
+		msg := fmt.Sprintf(MessageScaleDownBlocked, *statefulSet.Spec.Replicas, kubelitedbv1.AllowDataLossAnnotation)
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionScaleDownBlocked, v1.ConditionTrue, ReasonWriterWouldBeRemoved, msg)
+		c.recorder.Event(sqliteInstance, corev1.EventTypeWarning, ReasonWriterWouldBeRemoved, msg)
+		*desired.Spec.Replicas = *statefulSet.Spec.Replicas
+	} else {
+		meta.RemoveStatusCondition(&status.Conditions, kubelitedbv1.ConditionScaleDownBlocked)
+	}
+
+	// Changing the pod template rolls every pod, so it waits for the
+	// maintenance window of the SQLiteInstance.
+	deferredIn := c.deferTemplateUpdate(sqliteInstance, status, statefulSet, desired, time.Now())
+
+	// If the replica count or the pod template of the StatefulSet no longer
+	// match the spec, we update the StatefulSet to converge the two.
+	if statefulSetOutOfDate(statefulSet, desired) {
+		statefulSetCopy := statefulSet.DeepCopy()
+		mergeMetadata(statefulSetCopy, desired)
+		statefulSetCopy.Spec.Replicas = desired.Spec.Replicas
+		statefulSetCopy.Spec.Template = desired.Spec.Template
+		statefulSetCopy.Spec.UpdateStrategy = desired.Spec.UpdateStrategy
+		_, err = c.kubeclientset.AppsV1().StatefulSets(namespace).Update(ctx, statefulSetCopy, c.updateOptions(ctx, sqliteInstance, "StatefulSet", statefulSet, statefulSetCopy))
+		logWrite(ctx, "update", "StatefulSet", statefulSet.Name, err)
+		if err != nil {
+			return reconcileResult{}, err
+		}
+		progressing = true
+	}
+
+	// Once a pod became ready the database was restored, and the restore is
+	// dropped from the pod template on the next sync.
+	if needsRestore(sqliteInstance) && statefulSet.Status.ReadyReplicas > 0 {
+		status.Restored = true
+	}
+
+	// The SQLiteInstance is only ready once every pod of the StatefulSet is.
+	// Pods that are not ready yet are still being rolled out.
+	status.Replicas = statefulSet.Status.Replicas
+	status.Selector = v1.FormatLabelSelector(statefulSet.Spec.Selector)
+	status.ReadyReplicas = statefulSet.Status.ReadyReplicas
+	status.WriterReadyReplicas = statefulSet.Status.ReadyReplicas
+	replicas := *desired.Spec.Replicas
+	allReady := status.ReadyReplicas == replicas
+	notReadyMessage := fmt.Sprintf(MessageReplicasNotReady, status.ReadyReplicas, replicas)
+
+	rolloutDeadlineIn := setRolloutCondition(status, sqliteInstance, statefulSet, replicas, progressing, c.rolloutProgressDeadline, time.Now())
+	setMigratedCondition(status, sqliteInstance, statefulSet, replicas, progressing)
+	// The storage class of provisioned volumes can't be changed, so a changed
+	// storage class is reported rather than rolled out. The volumes keep the
+	// class they were provisioned with.
+	current := volumeClaimTemplateStorageClass(statefulSet)
+	if sharedPVC != nil {
+		// The storage class of a PVC without one is defaulted when it is
+		// created, so only a requested class is compared.
+		current = sharedPVC.Spec.StorageClassName
+		if sqliteInstance.Spec.StorageClassName == nil {
+			current = nil
+		}
+	}
+	if !equality.Semantic.DeepEqual(current, sqliteInstance.Spec.StorageClassName) {
+		msg := fmt.Sprintf(MessageStorageClassImmutable, storageClassString(current), storageClassString(sqliteInstance.Spec.StorageClassName))
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionStorageProvisioned, v1.ConditionTrue, ReasonStorageClassImmutable, msg)
+		c.recorder.Event(sqliteInstance, corev1.EventTypeWarning, ReasonStorageClassImmutable, msg)
+	} else {
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionStorageProvisioned, v1.ConditionTrue, ReasonVolumeClaimTemplateConfigured, "")
+	}
+
+	// Volumes don't follow the volume claim template once provisioned, so a
+	// larger spec.storage and new annotations are set on the PVCs themselves.
+	volumeReplicas := int(replicas)
+	if sqliteInstance.Spec.Suspend {
+		volumeReplicas = sqliteInstance.Spec.Replicas
+	}
+	if err := c.expandDataVolumes(ctx, sqliteInstance, status, volumeReplicas); err != nil {
+		return reconcileResult{}, err
+	}
+	if err := c.syncDataPVCAnnotations(ctx, sqliteInstance, volumeReplicas); err != nil {
+		return reconcileResult{}, err
 	}
 
-	// Update the status block of the SQLiteInstance resource to reflect the current state of the world
-	err = c.updateSQLiteInstanceStatus(sqliteInstance)
+	// The PVCs are watched, so changes to their phase or capacity are picked
+	// up. The usage is only as recent as the last sync. The volumes of a
+	// suspended SQLiteInstance are kept, so they are still reported.
+	volumes, err := c.dataVolumeStatuses(ctx, sqliteInstance, volumeReplicas)
 	if err != nil {
-		return err
+		return reconcileResult{}, err
+	}
+	status.Volumes = volumes
+	pressure, reason, msg := storagePressureCondition(volumes, c.storagePressureThreshold)
+	setCondition(status, sqliteInstance, kubelitedbv1.ConditionStoragePressure, pressure, reason, msg)
+	// A suspended SQLiteInstance is not ready, as no pod serves the database
+	setSuspendedCondition(status, sqliteInstance)
+	switch {
+	case sqliteInstance.Spec.Suspend:
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionReady, v1.ConditionFalse, ReasonSuspended, MessageSuspended)
+	case allReady && !progressing:
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionReady, v1.ConditionTrue, SuccessSynced, MessageResourceSynced)
+	default:
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionReady, v1.ConditionFalse, ReasonReplicasNotReady, notReadyMessage)
 	}
 
+	if err := c.setReplicationCondition(ctx, sqliteInstance, status); err != nil {
+		return reconcileResult{}, err
+	}
+
+	if err := c.syncBackupCronJob(ctx, sqliteInstance, status); err != nil {
+		return reconcileResult{}, err
+	}
+	if err := c.syncIntegrityCheckCronJob(ctx, sqliteInstance, status); err != nil {
+		return reconcileResult{}, err
+	}
+	// The condition has to flip once the last backup gets too old, even
+	// without any change to the SQLiteInstance.
+	staleIn := setBackupStaleCondition(status, sqliteInstance, time.Now())
+
+	// The read-only pods open the database of the first pod, so they are
+	// only started once the StatefulSet exists.
+	if err := c.syncReaders(ctx, sqliteInstance, status, tlsHash); err != nil {
+		return reconcileResult{}, err
+	}
+	if err := c.syncClientService(ctx, sqliteInstance); err != nil {
+		return reconcileResult{}, err
+	}
+	if err := c.cleanupOrphans(ctx, key, sqliteInstance); err != nil {
+		return reconcileResult{}, err
+	}
+	status.Endpoints = endpointsForStatus(sqliteInstance, status)
+
+	// Update the status block of the SQLiteInstance resource to reflect the
+	// current state of the world, marking the current generation as reconciled.
+	status.ObservedGeneration = sqliteInstance.Generation
+	hash := specHash(sqliteInstance)
+	status.ObservedSpecHash = hash
+	err = c.updateSQLiteInstanceStatus(ctx, sqliteInstance, status)
+	if err != nil {
+		return reconcileResult{}, err
+	}
+	c.recordFullReconcile(key, hash, time.Now())
+
 	c.recorder.Event(sqliteInstance, corev1.EventTypeNormal, SuccessSynced, MessageResourceSynced)
-	return nil
+
+	// Pending instances are waiting on something expected to happen, like
+	// volumes binding or pods starting, so they are checked again after a
+	// fixed delay instead of being retried with backoff.
+	switch phase, _ := phaseForStatus(sqliteInstance, status); phase {
+	case kubelitedbv1.PhasePending, kubelitedbv1.PhaseProvisioning, kubelitedbv1.PhaseDegraded:
+		return reconcileResult{RequeueAfter: pendingRequeueAfter}, nil
+	}
+	return reconcileResult{RequeueAfter: soonest(soonest(staleIn, rolloutDeadlineIn), deferredIn)}, nil
 }
 
-func newPVC(instance *kubelitedbv1.SQLiteInstance, pvcName string) *corev1.PersistentVolumeClaim {
-	storageClassName := "standard" // Adjust the storage class as needed
-	return &corev1.PersistentVolumeClaim{
+// syncHeadlessService ensures the headless Service of the SQLiteInstance exists
+// and selects the pods of its StatefulSet.
+func (c *Controller) syncHeadlessService(ctx context.Context, sqliteInstance *kubelitedbv1.SQLiteInstance) error {
+	services := c.kubeclientset.CoreV1().Services(sqliteInstance.Namespace)
+	service, err := services.Get(ctx, headlessServiceName(sqliteInstance), v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = services.Create(ctx, newHeadlessService(sqliteInstance), c.createOptions(ctx, sqliteInstance, "Service", headlessServiceName(sqliteInstance)))
+		logWrite(ctx, "create", "Service", headlessServiceName(sqliteInstance), err)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if !v1.IsControlledBy(service, sqliteInstance) {
+		msg := fmt.Sprintf(MessageResourceExists, service.Name)
+		c.recorder.Event(sqliteInstance, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return fmt.Errorf("%s", msg)
+	}
+
+	desired := newHeadlessService(sqliteInstance)
+	if !equality.Semantic.DeepEqual(service.Spec.Selector, desired.Spec.Selector) ||
+		!equality.Semantic.DeepEqual(service.Spec.Ports, desired.Spec.Ports) ||
+		metadataOutOfDate(service, desired) {
+		serviceCopy := service.DeepCopy()
+		mergeMetadata(serviceCopy, desired)
+		serviceCopy.Spec.Selector = desired.Spec.Selector
+		serviceCopy.Spec.Ports = desired.Spec.Ports
+		_, err = services.Update(ctx, serviceCopy, c.updateOptions(ctx, sqliteInstance, "Service", service, serviceCopy))
+		logWrite(ctx, "update", "Service", service.Name, err)
+	}
+	return err
+}
+
+// headlessServiceName returns the name of the headless Service governing the
+// StatefulSet of the given SQLiteInstance.
+func headlessServiceName(instance *kubelitedbv1.SQLiteInstance) string {
+	return fmt.Sprintf("%s-sqlite", instance.Name)
+}
+
+// newHeadlessService creates a new headless Service for a SQLiteInstance
+// resource. Each pod of the StatefulSet gets a stable DNS name through it.
+func newHeadlessService(instance *kubelitedbv1.SQLiteInstance) *corev1.Service {
+	return &corev1.Service{
 		ObjectMeta: v1.ObjectMeta{
-			Name:      pvcName,
-			Namespace: instance.Namespace,
+			Name:        headlessServiceName(instance),
+			Namespace:   instance.Namespace,
+			Labels:      childLabels(instance, labelsForInstance(instance, componentDatabase)),
+			Annotations: childAnnotations(instance),
 			OwnerReferences: []v1.OwnerReference{
-				*v1.NewControllerRef(instance, kubelitedbv1.SchemeGroupVersion.WithKind("SQLiteInstance")),
+				newOwnerReference(instance),
 			},
 		},
-		Spec: corev1.PersistentVolumeClaimSpec{
-			AccessModes: []corev1.PersistentVolumeAccessMode{
-				corev1.ReadWriteOnce,
-			},
-			Resources: corev1.VolumeResourceRequirements{
-				Requests: corev1.ResourceList{
-					corev1.ResourceStorage: resource.MustParse(instance.Spec.Storage),
-				},
-			},
-			StorageClassName: &storageClassName,
+		Spec: corev1.ServiceSpec{
+			ClusterIP: corev1.ClusterIPNone,
+			Selector:  podLabels(instance),
+			Ports:     servicePorts(instance),
 		},
 	}
 }
 
-func newPod(instance *kubelitedbv1.SQLiteInstance, podName, pvcName string) *corev1.Pod {
-	labels := map[string]string{
+// podLabels returns the labels set on the pods of the given SQLiteInstance,
+// they are also used as selector by the StatefulSet and the Services.
+func podLabels(instance *kubelitedbv1.SQLiteInstance) map[string]string {
+	return map[string]string{
 		"app":        "sqlite",
 		"controller": instance.Name,
 	}
-	return &corev1.Pod{
+}
+
+// statefulSetName returns the name of the StatefulSet managed for the given
+// SQLiteInstance.
+func statefulSetName(instance *kubelitedbv1.SQLiteInstance) string {
+	return fmt.Sprintf("%s-sqlite", instance.Name)
+}
+
+// statefulSetOutOfDate returns whether the replica count, the pod template,
+// the update strategy or the metadata of the StatefulSet no longer match the
+// desired StatefulSet.
+func statefulSetOutOfDate(statefulSet, desired *appsv1.StatefulSet) bool {
+	return *statefulSet.Spec.Replicas != *desired.Spec.Replicas ||
+		statefulSet.Spec.Template.Annotations[templateHashAnnotation] != desired.Spec.Template.Annotations[templateHashAnnotation] ||
+		updateStrategyOutOfDate(statefulSet.Spec.UpdateStrategy, desired.Spec.UpdateStrategy) ||
+		metadataOutOfDate(statefulSet, desired)
+}
+
+// newStatefulSet creates a new StatefulSet for a SQLiteInstance resource. It also
+// sets the appropriate OwnerReferences on the resource so handleObject can
+// discover the SQLiteInstance resource that 'owns' it.
+func newStatefulSet(instance *kubelitedbv1.SQLiteInstance) *appsv1.StatefulSet {
+	// The selector can't be changed once the StatefulSet exists, so it only
+	// matches the original pod labels.
+	selector := podLabels(instance)
+	labels := labelsForInstance(instance, componentDatabase)
+	templateLabels := labelsForInstance(instance, componentDatabase)
+	templateLabels[roleLabel] = roleWriter
+	replicas := int32(instance.Spec.Replicas)
+	if instance.Spec.Suspend {
+		replicas = 0
+	}
+	template := corev1.PodTemplateSpec{
 		ObjectMeta: v1.ObjectMeta{
-			Name:      podName,
-			Namespace: instance.Namespace,
-			Labels:    labels,
-			OwnerReferences: []v1.OwnerReference{
-				*v1.NewControllerRef(instance, kubelitedbv1.SchemeGroupVersion.WithKind("SQLiteInstance")),
-			},
+			Labels:      childLabels(instance, templateLabels),
+			Annotations: childAnnotations(instance),
 		},
 		Spec: corev1.PodSpec{
+			NodeSelector:       nodeSelectorForInstance(instance),
+			Affinity:           instance.Spec.Affinity,
+			Tolerations:        instance.Spec.Tolerations,
+			PriorityClassName:  instance.Spec.PriorityClassName,
+			ImagePullSecrets:   instance.Spec.ImagePullSecrets,
+			ServiceAccountName: instance.Spec.ServiceAccountName,
+			// Only the pods of the StatefulSet are spread, the read-only pods
+			// are scheduled by their own affinity.
+			TopologySpreadConstraints: topologySpreadConstraintsForInstance(instance),
+			// Litestream replicates the remaining WAL when it receives SIGTERM,
+			// within the same grace period.
+			TerminationGracePeriodSeconds: terminationGracePeriodForInstance(instance),
 			Containers: []corev1.Container{
 				{
-					Name:  "sqlite",
-					Image: "ghcr.io/fortytwoapps/kubelitedb",
-					VolumeMounts: []corev1.VolumeMount{
-						{
-							Name:      "database-volume",
-							MountPath: "/data",
+					Name:      "sqlite",
+					Image:     imageForInstance(instance),
+					Command:   instance.Spec.Command,
+					Args:      instance.Spec.Args,
+					Resources: resourcesForInstance(instance),
+					Env: envForInstance(instance, []corev1.EnvVar{
+						{Name: "DATABASE_PATH", Value: databasePath(instance)},
+					}),
+					EnvFrom: instance.Spec.EnvFrom,
+					Lifecycle: &corev1.Lifecycle{
+						PreStop: &corev1.LifecycleHandler{
+							Exec: &corev1.ExecAction{
+								Command: checkpointCommand(instance),
+							},
 						},
 					},
+					VolumeMounts: []corev1.VolumeMount{
+						dataVolumeMount(instance),
+					},
 				},
 			},
-			Volumes: []corev1.Volume{
-				{
-					Name: "database-volume",
-					VolumeSource: corev1.VolumeSource{
-						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-							ClaimName: pvcName,
-						},
-					},
+		},
+	}
+	addProbes(instance, &template.Spec.Containers[0])
+	if instance.Spec.Replication != nil {
+		addLitestreamSidecar(instance, &template)
+	}
+	if instance.Spec.LegacyDatabasePath != "" {
+		addMigrationInitContainer(instance, &template)
+	}
+	if needsRestore(instance) {
+		addRestoreInitContainer(instance, &template)
+	}
+	if instance.Spec.InitSQL != nil {
+		addInitSQLInitContainer(instance, &template)
+	}
+	if len(pragmasForInstance(instance)) > 0 {
+		addPragmas(instance, &template)
+	}
+	if wal := instance.Spec.WAL; wal != nil && wal.CheckpointInterval != nil {
+		addCheckpointSidecar(instance, &template)
+	}
+	if instance.Spec.TempDir != nil {
+		addTempDir(instance, &template)
+	}
+	if instance.Spec.TLS != nil {
+		addTLSProxySidecar(instance, &template)
+	}
+	if sharesVolume(instance) {
+		template.Spec.Volumes = append(template.Spec.Volumes, corev1.Volume{
+			Name: dataVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: dataPVCName(instance, 0),
 				},
 			},
+		})
+	}
+	setSecurityContexts(instance, &template.Spec)
+	addSidecars(instance, &template.Spec)
+	if template.Annotations == nil {
+		template.Annotations = map[string]string{}
+	}
+	template.Annotations[templateHashAnnotation] = computeHash(template)
+	statefulSet := &appsv1.StatefulSet{
+		ObjectMeta: v1.ObjectMeta{
+			Name:        statefulSetName(instance),
+			Namespace:   instance.Namespace,
+			Labels:      childLabels(instance, labels),
+			Annotations: childAnnotations(instance),
+			OwnerReferences: []v1.OwnerReference{
+				newOwnerReference(instance),
+			},
+		},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:    &replicas,
+			ServiceName: headlessServiceName(instance),
+			Selector: &v1.LabelSelector{
+				MatchLabels: selector,
+			},
+			Template:       template,
+			UpdateStrategy: updateStrategyForInstance(instance),
+			// The PVCs created from the volume claim template are removed together
+			// with the StatefulSet, which is in turn owned by the SQLiteInstance.
+			PersistentVolumeClaimRetentionPolicy: &appsv1.StatefulSetPersistentVolumeClaimRetentionPolicy{
+				WhenDeleted: appsv1.DeletePersistentVolumeClaimRetentionPolicyType,
+				WhenScaled:  appsv1.RetainPersistentVolumeClaimRetentionPolicyType,
+			},
+		},
+	}
+
+	// Pods sharing a ReadWriteMany volume mount the PVC synced by
+	// syncSharedDataPVC instead of getting their own volume.
+	if sharesVolume(instance) {
+		return statefulSet
+	}
+	statefulSet.Spec.VolumeClaimTemplates = []corev1.PersistentVolumeClaim{
+		{
+			ObjectMeta: v1.ObjectMeta{
+				Name:        dataVolumeName,
+				Labels:      childLabels(instance, labels),
+				Annotations: volumeClaimAnnotations(instance),
+			},
+			Spec: newDataPVCSpec(instance),
 		},
 	}
+	return statefulSet
 }
 
-func (c *Controller) updateSQLiteInstanceStatus(sqliteInstance *kubelitedbv1.SQLiteInstance) error {
-	sqliteInstanceCopy := sqliteInstance.DeepCopy()
-	sqliteInstanceCopy.Status.Phase = "Running"
-	// Update status fields here, e.g., sqliteInstanceCopy.Status.Phase = "Running"
+// imageForInstance returns the container image running SQLite for the given
+// SQLiteInstance: the image of its architecture, the image of the spec or
+// the default image.
+func imageForInstance(instance *kubelitedbv1.SQLiteInstance) string {
+	if image := instance.Spec.ArchitectureImages[instance.Spec.Architecture]; instance.Spec.Architecture != "" && image != "" {
+		return image
+	}
+	if instance.Spec.Image != "" {
+		return instance.Spec.Image
+	}
+	return defaultImage
+}
 
-	_, err := c.kubelitedbclientset.KubelitedbV1().SQLiteInstances(sqliteInstance.Namespace).UpdateStatus(context.TODO(), sqliteInstanceCopy, v1.UpdateOptions{})
-	return err
+// resourcesForInstance returns the compute resources of the SQLite container
+// for the given SQLiteInstance, falling back to modest requests.
+func resourcesForInstance(instance *kubelitedbv1.SQLiteInstance) corev1.ResourceRequirements {
+	if len(instance.Spec.Resources.Requests) > 0 || len(instance.Spec.Resources.Limits) > 0 {
+		return *instance.Spec.Resources.DeepCopy()
+	}
+	return corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(defaultCPURequest),
+			corev1.ResourceMemory: resource.MustParse(defaultMemoryRequest),
+		},
+	}
+}
+
+// envForInstance returns the environment variables of the SQLite container
+// for the given SQLiteInstance: the ones from the spec followed by the given
+// ones set by the controller. Reserved variables in the spec are dropped, and
+// the variables set by the controller take precedence over spec.envFrom.
+func envForInstance(instance *kubelitedbv1.SQLiteInstance, managed []corev1.EnvVar) []corev1.EnvVar {
+	var env []corev1.EnvVar
+	for _, envVar := range instance.Spec.Env {
+		if !validation.IsReservedEnvVar(envVar.Name) {
+			env = append(env, envVar)
+		}
+	}
+	return append(env, managed...)
+}
+
+// volumeClaimTemplateStorageClass returns the storage class requested by the
+// volume claim template of the StatefulSet.
+func volumeClaimTemplateStorageClass(statefulSet *appsv1.StatefulSet) *string {
+	for _, template := range statefulSet.Spec.VolumeClaimTemplates {
+		if template.Name == dataVolumeName {
+			return template.Spec.StorageClassName
+		}
+	}
+	return nil
+}
+
+// storageClassString describes a storage class for messages. A nil storage
+// class uses the cluster default, while an empty one disables dynamic
+// provisioning.
+func storageClassString(storageClassName *string) string {
+	switch {
+	case storageClassName == nil:
+		return "the cluster default"
+	case *storageClassName == "":
+		return "no storage class"
+	default:
+		return fmt.Sprintf("%q", *storageClassName)
+	}
+}
+
+// terminationGracePeriodForInstance returns the termination grace period of
+// the pods of the given SQLiteInstance, falling back to the default.
+func terminationGracePeriodForInstance(instance *kubelitedbv1.SQLiteInstance) *int64 {
+	if instance.Spec.TerminationGracePeriodSeconds != nil {
+		seconds := *instance.Spec.TerminationGracePeriodSeconds
+		return &seconds
+	}
+	seconds := int64(defaultTerminationGracePeriodSeconds)
+	return &seconds
+}
+
+// checkpointCommand returns the command checkpointing the WAL into the
+// database before the SQLite container stops.
+func checkpointCommand(instance *kubelitedbv1.SQLiteInstance) []string {
+	return []string{"sqlite3", databasePath(instance), fmt.Sprintf("PRAGMA wal_checkpoint(%s);", checkpointMode(instance))}
+}
+
+// checkpointMode returns the mode the controller checkpoints the WAL of the
+// SQLiteInstance with. Litestream controls checkpoints itself while it
+// replicates, so only a passive checkpoint, which doesn't wait on its read
+// lock, is run then.
+func checkpointMode(instance *kubelitedbv1.SQLiteInstance) string {
+	if instance.Spec.Replication != nil {
+		return "PASSIVE"
+	}
+	return "TRUNCATE"
+}
+
+// newOwnerReference returns an OwnerReference marking the SQLiteInstance as the
+// managing controller of a child object. Owner deletion is blocked until the
+// child is removed, so deleting a SQLiteInstance cascades to all its children
+// through the Kubernetes garbage collector.
+func newOwnerReference(instance *kubelitedbv1.SQLiteInstance) v1.OwnerReference {
+	return *v1.NewControllerRef(instance, kubelitedbv1.SchemeGroupVersion.WithKind("SQLiteInstance"))
+}
+
+// computeHash returns a short, stable hash of the given object. It is used to
+// detect changes to generated pod templates without comparing fields that the
+// API server defaults.
+func computeHash(obj interface{}) string {
+	hasher := fnv.New32a()
+	data, _ := json.Marshal(obj)
+	hasher.Write(data)
+	return rand.SafeEncodeString(fmt.Sprint(hasher.Sum32()))
+}
+
+// setCondition sets a condition on the status of the SQLiteInstance, observed
+// at its current generation. The transition time of the condition only changes
+// when its status does.
+func setCondition(status *kubelitedbv1.SQLiteInstanceStatus, instance *kubelitedbv1.SQLiteInstance, conditionType string, conditionStatus v1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&status.Conditions, v1.Condition{
+		Type:               conditionType,
+		Status:             conditionStatus,
+		ObservedGeneration: instance.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// updateSQLiteInstanceStatus writes the given status to the SQLiteInstance,
+// deriving the phase from the observed state. A write conflicting with a
+// change to the SQLiteInstance is retried on the latest version of it, rather
+// than syncing it all over again.
+func (c *Controller) updateSQLiteInstanceStatus(ctx context.Context, sqliteInstance *kubelitedbv1.SQLiteInstance, status *kubelitedbv1.SQLiteInstanceStatus) error {
+	sqliteInstances := c.kubelitedbclientset.KubelitedbV1().SQLiteInstances(sqliteInstance.Namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		sqliteInstanceCopy := sqliteInstance.DeepCopy()
+		sqliteInstanceCopy.Status = *status
+		sqliteInstanceCopy.Status.Phase, sqliteInstanceCopy.Status.Message = phaseForStatus(sqliteInstance, status)
+		if from, to := sqliteInstance.Status.Phase, sqliteInstanceCopy.Status.Phase; !validPhaseTransition(from, to) {
+			klog.FromContext(ctx).Info("Unexpected phase transition", "sqliteInstance", klog.KObj(sqliteInstance), "from", from, "to", to)
+		}
+		if key, err := cache.MetaNamespaceKeyFunc(sqliteInstance); err == nil {
+			metrics.SetInstancePhase(key, string(sqliteInstanceCopy.Status.Phase))
+		}
+
+		// Skip the write when nothing changed, the status is recomputed on every
+		// sync and writing it unconditionally only causes needless API traffic.
+		if equality.Semantic.DeepEqual(sqliteInstance.Status, sqliteInstanceCopy.Status) {
+			return nil
+		}
+
+		_, err := sqliteInstances.UpdateStatus(ctx, sqliteInstanceCopy, c.updateOptions(ctx, sqliteInstance, "SQLiteInstance", sqliteInstance, sqliteInstanceCopy))
+		if !errors.IsConflict(err) {
+			return err
+		}
+		latest, getErr := sqliteInstances.Get(ctx, sqliteInstance.Name, v1.GetOptions{})
+		if getErr != nil {
+			return getErr
+		}
+		// Backups are recorded by the backup controller in the meantime, so
+		// the most recent one is kept.
+		if latest.Status.LastBackupTime != nil {
+			recordBackup(status, *latest.Status.LastBackupTime, latest.Status.LastBackupLocation)
+		}
+		sqliteInstance = latest
+		return err
+	})
 }
 
 // enqueueSQLiteInstance takes a SQLiteInstance resource and converts it into a namespace/name
@@ -337,4 +1331,85 @@ func (c *Controller) enqueueSQLiteInstance(obj interface{}) {
 		return
 	}
 	c.workqueue.Add(key)
+	metrics.WorkqueueDepth.Set(float64(c.workqueue.Len()))
+}
+
+// enqueueSQLiteInstanceWithJitter puts the key of the SQLiteInstance onto the
+// work queue after a random delay of up to the resync jitter.
+func (c *Controller) enqueueSQLiteInstanceWithJitter(obj interface{}) {
+	if c.resyncJitter <= 0 {
+		c.enqueueSQLiteInstance(obj)
+		return
+	}
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.workqueue.AddAfter(key, time.Duration(rand.Int63nRange(0, int64(c.resyncJitter))))
+}
+
+// enqueueSQLiteInstanceDebounced puts the key of an edited SQLiteInstance
+// onto the work queue once the debounce window of its last edit closes.
+func (c *Controller) enqueueSQLiteInstanceDebounced(obj interface{}) {
+	if c.debounce.window <= 0 {
+		c.enqueueSQLiteInstance(obj)
+		return
+	}
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	if c.debounce.edited(key, time.Now()) {
+		c.workqueue.AddAfter(key, c.debounce.window)
+	}
+}
+
+// handleObject will take any resource implementing metav1.Object and attempt
+// to find the SQLiteInstance resource that 'owns' it. It does this by looking at
+// the objects metadata.ownerReferences field for an appropriate OwnerReference.
+// Objects owned by a StatefulSet, such as the PVCs created from its volume
+// claim templates, are resolved through the StatefulSet. It then enqueues that
+// SQLiteInstance resource to be processed. If the object does not have an
+// appropriate OwnerReference, it will simply be skipped.
+func (c *Controller) handleObject(obj interface{}) {
+	var object v1.Object
+	var ok bool
+	logger := klog.FromContext(context.Background())
+	if object, ok = obj.(v1.Object); !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("error decoding object, invalid type"))
+			return
+		}
+		object, ok = tombstone.Obj.(v1.Object)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("error decoding object tombstone, invalid type"))
+			return
+		}
+		logger.V(4).Info("Recovered deleted object", "resourceName", object.GetName())
+	}
+	logger.V(4).Info("Processing object", "object", klog.KObj(object))
+
+	ownerRef := v1.GetControllerOf(object)
+	if ownerRef != nil && ownerRef.Kind == "StatefulSet" {
+		statefulSet, err := c.statefulSetsLister.StatefulSets(object.GetNamespace()).Get(ownerRef.Name)
+		if err != nil {
+			logger.V(4).Info("Ignore orphaned object", "object", klog.KObj(object), "statefulset", ownerRef.Name)
+			return
+		}
+		ownerRef = v1.GetControllerOf(statefulSet)
+	}
+	if ownerRef == nil || ownerRef.Kind != "SQLiteInstance" {
+		return
+	}
+
+	sqliteInstance, err := c.sqliteInstancesLister.SQLiteInstances(object.GetNamespace()).Get(ownerRef.Name)
+	if err != nil {
+		logger.V(4).Info("Ignore orphaned object", "object", klog.KObj(object), "sqliteinstance", ownerRef.Name)
+		return
+	}
+
+	c.enqueueSQLiteInstance(sqliteInstance)
 }
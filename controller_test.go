@@ -0,0 +1,1658 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	kubeinformers "k8s.io/client-go/informers"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	core "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+	clientset "github.com/fortytwoapps/kubelitedb/pkg/generated/clientset/versioned"
+	"github.com/fortytwoapps/kubelitedb/pkg/generated/clientset/versioned/fake"
+	informers "github.com/fortytwoapps/kubelitedb/pkg/generated/informers/externalversions"
+	"github.com/fortytwoapps/kubelitedb/pkg/metrics"
+)
+
+var noResyncPeriodFunc = func() time.Duration { return 0 }
+
+type fixture struct {
+	t *testing.T
+
+	client        *fake.Clientset
+	kubeclient    *k8sfake.Clientset
+	recorder      *record.FakeRecorder
+	informers     informers.SharedInformerFactory
+	kubeInformers kubeinformers.SharedInformerFactory
+
+	// Objects to put in the informer caches
+	sqliteInstanceLister []*kubelitedbv1.SQLiteInstance
+	kubeLister           []runtime.Object
+	// Objects from here preloaded into the fake clientsets
+	objects     []runtime.Object
+	kubeobjects []runtime.Object
+
+	// Options of the controller
+	dryRun                   bool
+	replicaLimit             ReplicaLimit
+	storagePressureThreshold int
+	resyncJitter             time.Duration
+	debounceWindow           time.Duration
+}
+
+func newFixture(t *testing.T) *fixture {
+	return &fixture{t: t}
+}
+
+// addInstance adds the SQLiteInstance to the informer cache and the fake
+// clientset.
+func (f *fixture) addInstance(instance *kubelitedbv1.SQLiteInstance) {
+	f.sqliteInstanceLister = append(f.sqliteInstanceLister, instance)
+	f.objects = append(f.objects, instance)
+}
+
+// addKubeObject adds a child object to the informer cache of its kind, when
+// the controller watches it, and the fake clientset.
+func (f *fixture) addKubeObject(obj runtime.Object) {
+	f.kubeLister = append(f.kubeLister, obj)
+	f.kubeobjects = append(f.kubeobjects, obj)
+}
+
+func newSQLiteInstance(name string) *kubelitedbv1.SQLiteInstance {
+	return &kubelitedbv1.SQLiteInstance{
+		TypeMeta: v1.TypeMeta{APIVersion: kubelitedbv1.SchemeGroupVersion.String(), Kind: "SQLiteInstance"},
+		ObjectMeta: v1.ObjectMeta{
+			Name:       name,
+			Namespace:  v1.NamespaceDefault,
+			UID:        types.UID(name + "-uid"),
+			Generation: 1,
+			Finalizers: []string{cleanupFinalizer},
+		},
+		Spec: kubelitedbv1.SQLiteInstanceSpec{
+			DbName:   "app.db",
+			Storage:  "1Gi",
+			Replicas: 1,
+		},
+	}
+}
+
+func (f *fixture) newController(ctx context.Context) (*Controller, informers.SharedInformerFactory, kubeinformers.SharedInformerFactory) {
+	f.client = fake.NewSimpleClientset(f.objects...)
+	f.kubeclient = k8sfake.NewSimpleClientset(f.kubeobjects...)
+
+	i := informers.NewSharedInformerFactory(f.client, noResyncPeriodFunc())
+	k8sI := kubeinformers.NewSharedInformerFactory(f.kubeclient, noResyncPeriodFunc())
+
+	c := NewController(ctx, f.kubeclient, f.client,
+		i.Kubelitedb().V1().SQLiteInstances(),
+		k8sI.Apps().V1().StatefulSets(),
+		k8sI.Core().V1().PersistentVolumeClaims(),
+		k8sI.Apps().V1().Deployments(),
+		k8sI.Core().V1().Services(),
+		k8sI.Core().V1().ConfigMaps(),
+		k8sI.Policy().V1().PodDisruptionBudgets(),
+		k8sI.Batch().V1().CronJobs(),
+		DefaultRateLimiterOptions(),
+		f.replicaLimit,
+		f.storagePressureThreshold,
+		f.resyncJitter,
+		f.debounceWindow,
+		10*time.Minute,
+		defaultHostPathBase,
+		f.dryRun,
+	)
+	c.sqliteInstancesSynced = alwaysReady
+	c.statefulSetsSynced = alwaysReady
+	c.pvcsSynced = alwaysReady
+	c.deploymentsSynced = alwaysReady
+	c.servicesSynced = alwaysReady
+	c.configMapsSynced = alwaysReady
+	c.pdbsSynced = alwaysReady
+	c.cronJobsSynced = alwaysReady
+	f.recorder = record.NewFakeRecorder(100)
+	c.recorder = f.recorder
+	f.informers, f.kubeInformers = i, k8sI
+
+	for _, instance := range f.sqliteInstanceLister {
+		i.Kubelitedb().V1().SQLiteInstances().Informer().GetIndexer().Add(instance)
+	}
+	for _, obj := range f.kubeLister {
+		var informer cache.SharedIndexInformer
+		switch obj.(type) {
+		case *appsv1.StatefulSet:
+			informer = k8sI.Apps().V1().StatefulSets().Informer()
+		case *corev1.PersistentVolumeClaim:
+			informer = k8sI.Core().V1().PersistentVolumeClaims().Informer()
+		case *appsv1.Deployment:
+			informer = k8sI.Apps().V1().Deployments().Informer()
+		case *corev1.Service:
+			informer = k8sI.Core().V1().Services().Informer()
+		case *corev1.ConfigMap:
+			informer = k8sI.Core().V1().ConfigMaps().Informer()
+		case *policyv1.PodDisruptionBudget:
+			informer = k8sI.Policy().V1().PodDisruptionBudgets().Informer()
+		case *batchv1.CronJob:
+			informer = k8sI.Batch().V1().CronJobs().Informer()
+		default:
+			continue
+		}
+		informer.GetIndexer().Add(obj)
+	}
+
+	return c, i, k8sI
+}
+
+func alwaysReady() bool { return true }
+
+// refreshCaches replaces the content of the informer caches with the objects
+// in the fake clientsets, so the next sync sees the writes of the last one.
+func (f *fixture) refreshCaches(ctx context.Context) {
+	f.t.Helper()
+	instances, err := f.client.KubelitedbV1().SQLiteInstances("").List(ctx, v1.ListOptions{})
+	if err != nil {
+		f.t.Fatalf("error listing SQLiteInstances: %v", err)
+	}
+	var objs []interface{}
+	for i := range instances.Items {
+		objs = append(objs, &instances.Items[i])
+	}
+	f.replace(f.informers.Kubelitedb().V1().SQLiteInstances().Informer(), objs)
+
+	for _, kind := range []struct {
+		informer cache.SharedIndexInformer
+		list     func() (runtime.Object, error)
+	}{
+		{f.kubeInformers.Apps().V1().StatefulSets().Informer(), func() (runtime.Object, error) {
+			return f.kubeclient.AppsV1().StatefulSets("").List(ctx, v1.ListOptions{})
+		}},
+		{f.kubeInformers.Core().V1().PersistentVolumeClaims().Informer(), func() (runtime.Object, error) {
+			return f.kubeclient.CoreV1().PersistentVolumeClaims("").List(ctx, v1.ListOptions{})
+		}},
+		{f.kubeInformers.Apps().V1().Deployments().Informer(), func() (runtime.Object, error) {
+			return f.kubeclient.AppsV1().Deployments("").List(ctx, v1.ListOptions{})
+		}},
+		{f.kubeInformers.Core().V1().Services().Informer(), func() (runtime.Object, error) {
+			return f.kubeclient.CoreV1().Services("").List(ctx, v1.ListOptions{})
+		}},
+		{f.kubeInformers.Core().V1().ConfigMaps().Informer(), func() (runtime.Object, error) {
+			return f.kubeclient.CoreV1().ConfigMaps("").List(ctx, v1.ListOptions{})
+		}},
+		{f.kubeInformers.Policy().V1().PodDisruptionBudgets().Informer(), func() (runtime.Object, error) {
+			return f.kubeclient.PolicyV1().PodDisruptionBudgets("").List(ctx, v1.ListOptions{})
+		}},
+		{f.kubeInformers.Batch().V1().CronJobs().Informer(), func() (runtime.Object, error) {
+			return f.kubeclient.BatchV1().CronJobs("").List(ctx, v1.ListOptions{})
+		}},
+	} {
+		list, err := kind.list()
+		if err != nil {
+			f.t.Fatalf("error listing objects: %v", err)
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			f.t.Fatalf("error extracting objects: %v", err)
+		}
+		objs := make([]interface{}, 0, len(items))
+		for _, item := range items {
+			objs = append(objs, item)
+		}
+		f.replace(kind.informer, objs)
+	}
+}
+
+func (f *fixture) replace(informer cache.SharedIndexInformer, objs []interface{}) {
+	f.t.Helper()
+	if err := informer.GetIndexer().Replace(objs, ""); err != nil {
+		f.t.Fatalf("error replacing the informer cache: %v", err)
+	}
+}
+
+// run syncs the SQLiteInstance with the given key and fails the test on an
+// error.
+func (f *fixture) run(ctx context.Context, c *Controller, key string) reconcileResult {
+	f.t.Helper()
+	result, err := c.syncHandler(ctx, key)
+	if err != nil {
+		f.t.Fatalf("error syncing %s: %v", key, err)
+	}
+	return result
+}
+
+// writes returns the create, update, patch and delete actions of the fake
+// clientset on the given resource.
+func writes(actions []core.Action, resource string) []core.Action {
+	var ret []core.Action
+	for _, action := range actions {
+		if action.GetResource().Resource != resource {
+			continue
+		}
+		switch action.GetVerb() {
+		case "create", "update", "patch", "delete":
+			ret = append(ret, action)
+		}
+	}
+	return ret
+}
+
+// getInstance returns the SQLiteInstance as stored in the fake clientset.
+func (f *fixture) getInstance(ctx context.Context, instance *kubelitedbv1.SQLiteInstance) *kubelitedbv1.SQLiteInstance {
+	f.t.Helper()
+	got, err := f.client.KubelitedbV1().SQLiteInstances(instance.Namespace).Get(ctx, instance.Name, v1.GetOptions{})
+	if err != nil {
+		f.t.Fatalf("error getting SQLiteInstance %s: %v", instance.Name, err)
+	}
+	return got
+}
+
+// getStatefulSet returns the StatefulSet of the SQLiteInstance as stored in
+// the fake clientset.
+func (f *fixture) getStatefulSet(ctx context.Context, instance *kubelitedbv1.SQLiteInstance) *appsv1.StatefulSet {
+	f.t.Helper()
+	got, err := f.kubeclient.AppsV1().StatefulSets(instance.Namespace).Get(ctx, statefulSetName(instance), v1.GetOptions{})
+	if err != nil {
+		f.t.Fatalf("error getting StatefulSet of %s: %v", instance.Name, err)
+	}
+	return got
+}
+
+func getKey(instance *kubelitedbv1.SQLiteInstance, t *testing.T) string {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(instance)
+	if err != nil {
+		t.Errorf("Unexpected error getting key for SQLiteInstance %v: %v", instance.Name, err)
+		return ""
+	}
+	return key
+}
+
+func TestSyncStatefulSet(t *testing.T) {
+	tests := []struct {
+		name string
+		// existing is the replica count of the StatefulSet before the sync,
+		// none when 0
+		existing int
+		// notControlled makes the existing StatefulSet belong to someone else
+		notControlled bool
+		replicas      int
+		writes        []string
+		wantErr       bool
+	}{
+		{name: "create", replicas: 3, writes: []string{"create"}},
+		{name: "unchanged", existing: 3, replicas: 3},
+		{name: "scale up", existing: 1, replicas: 3, writes: []string{"update"}},
+		{name: "scale down", existing: 3, replicas: 1, writes: []string{"update"}},
+		{name: "not controlled", existing: 1, notControlled: true, replicas: 3, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := newSQLiteInstance("test")
+			if tt.existing > 0 {
+				old := newSQLiteInstance("test")
+				old.Spec.Replicas = tt.existing
+				sts := newStatefulSet(old)
+				if tt.notControlled {
+					sts.OwnerReferences = nil
+				}
+				f.addKubeObject(sts)
+			}
+			instance.Spec.Replicas = tt.replicas
+			f.addInstance(instance)
+			c, _, _ := f.newController(ctx)
+
+			_, err := c.syncHandler(ctx, getKey(instance, t))
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("expected an error to be %t, got %v", tt.wantErr, err)
+			}
+
+			var verbs []string
+			for _, action := range writes(f.kubeclient.Actions(), "statefulsets") {
+				verbs = append(verbs, action.GetVerb())
+			}
+			if !slices.Equal(verbs, tt.writes) {
+				t.Errorf("expected StatefulSet writes %v, got %v", tt.writes, verbs)
+			}
+			sts := f.getStatefulSet(ctx, instance)
+			if tt.wantErr {
+				expectEvent(t, f.recorder, corev1.EventTypeWarning, ErrResourceExists)
+				if int(*sts.Spec.Replicas) != tt.existing {
+					t.Errorf("expected the StatefulSet to be left at %d replicas, got %d", tt.existing, *sts.Spec.Replicas)
+				}
+				return
+			}
+			if sts.Name != "test-sqlite" {
+				t.Errorf("expected the StatefulSet to be named test-sqlite, got %s", sts.Name)
+			}
+			if int(*sts.Spec.Replicas) != tt.replicas {
+				t.Errorf("expected %d replicas, got %d", tt.replicas, *sts.Spec.Replicas)
+			}
+			if !v1.IsControlledBy(sts, instance) {
+				t.Errorf("expected the StatefulSet to be controlled by the SQLiteInstance, got %v", sts.OwnerReferences)
+			}
+			if got := container(t, sts.Spec.Template.Spec.Containers, "sqlite").Image; got != defaultImage {
+				t.Errorf("expected image %q, got %q", defaultImage, got)
+			}
+		})
+	}
+}
+
+func TestStorage(t *testing.T) {
+	tests := []struct {
+		name    string
+		storage string
+		valid   bool
+	}{
+		{name: "gibibytes", storage: "1Gi", valid: true},
+		{name: "megabytes", storage: "500M", valid: true},
+		{name: "invalid", storage: "lots", valid: false},
+		{name: "empty", storage: "", valid: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := newSQLiteInstance("test")
+			instance.Spec.Storage = tt.storage
+			f.addInstance(instance)
+			c, _, _ := f.newController(ctx)
+
+			f.run(ctx, c, getKey(instance, t))
+
+			got := f.getInstance(ctx, instance)
+			condition := meta.FindStatusCondition(got.Status.Conditions, kubelitedbv1.ConditionStorageProvisioned)
+			if condition == nil {
+				t.Fatalf("expected a %s condition", kubelitedbv1.ConditionStorageProvisioned)
+			}
+			if !tt.valid {
+				if condition.Status != v1.ConditionFalse || condition.Reason != ReasonInvalidStorage {
+					t.Errorf("expected condition False with reason %s, got %s %s", ReasonInvalidStorage, condition.Status, condition.Reason)
+				}
+				if got.Status.Phase != kubelitedbv1.PhaseFailed || got.Status.Message == "" {
+					t.Errorf("expected phase %s with a message, got %q %q", kubelitedbv1.PhaseFailed, got.Status.Phase, got.Status.Message)
+				}
+				if n := len(writes(f.kubeclient.Actions(), "statefulsets")); n != 0 {
+					t.Errorf("expected no StatefulSet writes, got %d", n)
+				}
+				return
+			}
+
+			sts := f.getStatefulSet(ctx, instance)
+			if len(sts.Spec.VolumeClaimTemplates) != 1 {
+				t.Fatalf("expected 1 volume claim template, got %d", len(sts.Spec.VolumeClaimTemplates))
+			}
+			template := sts.Spec.VolumeClaimTemplates[0]
+			if want, got := resource.MustParse(tt.storage), template.Spec.Resources.Requests[corev1.ResourceStorage]; want.Cmp(got) != 0 {
+				t.Errorf("expected a request of %s, got %s", want.String(), got.String())
+			}
+			if condition.Status != v1.ConditionTrue {
+				t.Errorf("expected condition True, got %s", condition.Status)
+			}
+		})
+	}
+}
+
+func TestStorageUnchangedDoesNotUpdateStatefulSet(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	f.addInstance(instance)
+	sts := newStatefulSet(instance)
+	f.addKubeObject(sts)
+	c, _, _ := f.newController(ctx)
+
+	f.run(ctx, c, getKey(instance, t))
+
+	if actions := writes(f.kubeclient.Actions(), "statefulsets"); len(actions) != 0 {
+		t.Errorf("expected no StatefulSet writes, got %v", actions)
+	}
+}
+
+func TestOwnerReferences(t *testing.T) {
+	instance := newSQLiteInstance("test")
+	instance.Spec.ReadReplicas = 1
+	instance.Spec.AccessMode = corev1.ReadWriteMany
+	instance.Spec.Pragmas = map[string]string{"journal_mode": "wal"}
+
+	tests := []struct {
+		name string
+		obj  v1.Object
+	}{
+		{name: "StatefulSet", obj: newStatefulSet(instance)},
+		{name: "headless Service", obj: newHeadlessService(instance)},
+		{name: "shared PVC", obj: newDataPVC(instance)},
+		{name: "PodDisruptionBudget", obj: newPodDisruptionBudget(instance)},
+		{name: "pragmas ConfigMap", obj: newPragmasConfigMap(instance)},
+		{name: "reader Deployment", obj: newReaderDeployment(instance)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			refs := tt.obj.GetOwnerReferences()
+			if len(refs) != 1 {
+				t.Fatalf("expected 1 owner reference, got %d", len(refs))
+			}
+			ref := refs[0]
+			if ref.UID != instance.UID || ref.Kind != "SQLiteInstance" || ref.Name != instance.Name ||
+				ref.APIVersion != kubelitedbv1.SchemeGroupVersion.String() {
+				t.Errorf("expected owner reference to %s SQLiteInstance %s, got %s %s %s %s",
+					kubelitedbv1.SchemeGroupVersion, instance.UID, ref.APIVersion, ref.Kind, ref.Name, ref.UID)
+			}
+			if ref.Controller == nil || !*ref.Controller || ref.BlockOwnerDeletion == nil || !*ref.BlockOwnerDeletion {
+				t.Errorf("expected a controller reference blocking owner deletion, got %+v", ref)
+			}
+			if !v1.IsControlledBy(tt.obj, instance) {
+				t.Errorf("expected %s to be controlled by the SQLiteInstance", tt.name)
+			}
+		})
+	}
+}
+
+func TestSetConditionTransitionTime(t *testing.T) {
+	past := v1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	tests := []struct {
+		name    string
+		status  v1.ConditionStatus
+		reason  string
+		changed bool
+	}{
+		{name: "same status", status: v1.ConditionFalse, reason: ReasonInvalidStorage, changed: false},
+		{name: "same status, new reason", status: v1.ConditionFalse, reason: ReasonReplicasNotReady, changed: false},
+		{name: "new status", status: v1.ConditionTrue, reason: SuccessSynced, changed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newSQLiteInstance("test")
+			instance.Generation = 2
+			status := &kubelitedbv1.SQLiteInstanceStatus{
+				Conditions: []v1.Condition{{
+					Type:               kubelitedbv1.ConditionReady,
+					Status:             v1.ConditionFalse,
+					ObservedGeneration: 1,
+					LastTransitionTime: past,
+					Reason:             ReasonInvalidStorage,
+				}},
+			}
+
+			setCondition(status, instance, kubelitedbv1.ConditionReady, tt.status, tt.reason, "")
+
+			condition := meta.FindStatusCondition(status.Conditions, kubelitedbv1.ConditionReady)
+			if changed := !condition.LastTransitionTime.Equal(&past); changed != tt.changed {
+				t.Errorf("expected the transition time to change %t, got %s", tt.changed, condition.LastTransitionTime)
+			}
+			if condition.Status != tt.status || condition.Reason != tt.reason {
+				t.Errorf("expected %s %s, got %s %s", tt.status, tt.reason, condition.Status, condition.Reason)
+			}
+			if condition.ObservedGeneration != instance.Generation {
+				t.Errorf("expected observed generation %d, got %d", instance.Generation, condition.ObservedGeneration)
+			}
+		})
+	}
+}
+
+func TestSyncSetsConditions(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+
+	f.run(ctx, c, getKey(instance, t))
+
+	got := f.getInstance(ctx, instance)
+	for _, want := range []v1.Condition{
+		{Type: kubelitedbv1.ConditionReady, Status: v1.ConditionFalse},
+		{Type: kubelitedbv1.ConditionProgressing, Status: v1.ConditionTrue},
+		{Type: kubelitedbv1.ConditionStorageProvisioned, Status: v1.ConditionTrue},
+	} {
+		condition := meta.FindStatusCondition(got.Status.Conditions, want.Type)
+		if condition == nil {
+			t.Errorf("expected a %s condition", want.Type)
+			continue
+		}
+		if condition.Status != want.Status || condition.Reason == "" || condition.LastTransitionTime.IsZero() {
+			t.Errorf("expected %s %s with a reason and transition time, got %+v", want.Type, want.Status, condition)
+		}
+	}
+	if got.Status.Phase != kubelitedbv1.PhaseProvisioning {
+		t.Errorf("expected phase %s derived from the conditions, got %s", kubelitedbv1.PhaseProvisioning, got.Status.Phase)
+	}
+}
+
+func TestObservedGeneration(t *testing.T) {
+	tests := []struct {
+		name string
+		// Whether creating the StatefulSet fails
+		fail bool
+		want int64
+	}{
+		{name: "successful sync", want: 2},
+		{name: "failed sync", fail: true, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := newSQLiteInstance("test")
+			// The spec was bumped since the last sync
+			instance.Generation = 2
+			instance.Status.ObservedGeneration = 1
+			f.addInstance(instance)
+			c, _, _ := f.newController(ctx)
+			if tt.fail {
+				f.kubeclient.PrependReactor("create", "statefulsets", func(action core.Action) (bool, runtime.Object, error) {
+					return true, nil, fmt.Errorf("injected error")
+				})
+			}
+
+			if got := f.getInstance(ctx, instance); got.Status.ObservedGeneration >= got.Generation {
+				t.Fatalf("expected the observed generation to lag before the sync, got %d for generation %d", got.Status.ObservedGeneration, got.Generation)
+			}
+			_, err := c.syncHandler(ctx, getKey(instance, t))
+			if (err != nil) != tt.fail {
+				t.Fatalf("expected an error %t, got %v", tt.fail, err)
+			}
+
+			if got := f.getInstance(ctx, instance); got.Status.ObservedGeneration != tt.want {
+				t.Errorf("expected observed generation %d, got %d", tt.want, got.Status.ObservedGeneration)
+			}
+		})
+	}
+}
+
+func TestUpdateStatusCancelledContext(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL)
+	}))
+	defer server.Close()
+	client, err := clientset.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatalf("error creating clientset: %v", err)
+	}
+
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+	c.kubelitedbclientset = client
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	status := instance.Status.DeepCopy()
+	setCondition(status, instance, kubelitedbv1.ConditionReady, v1.ConditionFalse, ReasonInvalidStorage, "")
+	if err := c.updateSQLiteInstanceStatus(ctx, instance, status); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestStatusWrittenOnlyWhenChanged(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	f.addInstance(instance)
+	f.addKubeObject(newStatefulSet(instance))
+	c, _, _ := f.newController(ctx)
+
+	f.run(ctx, c, getKey(instance, t))
+	f.refreshCaches(ctx)
+	f.run(ctx, c, getKey(instance, t))
+
+	var statusWrites int
+	for _, action := range writes(f.client.Actions(), "sqliteinstances") {
+		if action.GetSubresource() == "status" {
+			statusWrites++
+		}
+	}
+	if statusWrites != 1 {
+		t.Errorf("expected 1 status write over two syncs, got %d", statusWrites)
+	}
+}
+
+func TestHeadlessServiceSelectsPods(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(*kubelitedbv1.SQLiteInstance)
+	}{
+		{name: "default"},
+		{name: "with labels", mutate: func(instance *kubelitedbv1.SQLiteInstance) {
+			instance.Labels = map[string]string{"team": "storage"}
+		}},
+		{name: "with read replicas", mutate: func(instance *kubelitedbv1.SQLiteInstance) {
+			instance.Spec.ReadReplicas = 2
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newSQLiteInstance("test")
+			if tt.mutate != nil {
+				tt.mutate(instance)
+			}
+			service := newHeadlessService(instance)
+			sts := newStatefulSet(instance)
+
+			if service.Name != "test-sqlite" || sts.Spec.ServiceName != service.Name {
+				t.Errorf("expected the StatefulSet to be governed by Service test-sqlite, got %q and %q", service.Name, sts.Spec.ServiceName)
+			}
+			if service.Spec.ClusterIP != corev1.ClusterIPNone {
+				t.Errorf("expected a headless Service, got cluster IP %q", service.Spec.ClusterIP)
+			}
+			if !equality.Semantic.DeepEqual(service.Spec.Selector, sts.Spec.Selector.MatchLabels) {
+				t.Errorf("expected the Service selector %v to match the StatefulSet selector %v", service.Spec.Selector, sts.Spec.Selector.MatchLabels)
+			}
+			if !labels.SelectorFromSet(service.Spec.Selector).Matches(labels.Set(sts.Spec.Template.Labels)) {
+				t.Errorf("expected the Service selector %v to select the pod template labels %v", service.Spec.Selector, sts.Spec.Template.Labels)
+			}
+		})
+	}
+}
+
+func TestSyncHeadlessServiceIsIdempotent(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	f.addInstance(instance)
+	f.addKubeObject(newHeadlessService(instance))
+	c, _, _ := f.newController(ctx)
+
+	if err := c.syncHeadlessService(ctx, instance); err != nil {
+		t.Fatalf("error syncing the headless Service: %v", err)
+	}
+	if actions := writes(f.kubeclient.Actions(), "services"); len(actions) != 0 {
+		t.Errorf("expected no Service writes, got %v", actions)
+	}
+}
+
+// reconcileMetrics scrapes the number of reconciles with each result and the
+// number of observed reconcile durations from the registry.
+func reconcileMetrics(t *testing.T) (success, failure float64, durations uint64) {
+	t.Helper()
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatalf("error gathering metrics: %v", err)
+	}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			switch family.GetName() {
+			case "kubelitedb_reconcile_total":
+				for _, label := range metric.GetLabel() {
+					switch {
+					case label.GetName() == "result" && label.GetValue() == metrics.ResultSuccess:
+						success = metric.GetCounter().GetValue()
+					case label.GetName() == "result" && label.GetValue() == metrics.ResultError:
+						failure = metric.GetCounter().GetValue()
+					}
+				}
+			case "kubelitedb_reconcile_duration_seconds":
+				durations = metric.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+	return success, failure, durations
+}
+
+func TestReconcileMetrics(t *testing.T) {
+	tests := []struct {
+		name string
+		// Whether creating the StatefulSet fails
+		fail bool
+	}{
+		{name: "success"},
+		{name: "error", fail: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := newSQLiteInstance("test")
+			f.addInstance(instance)
+			c, _, _ := f.newController(ctx)
+			defer c.workqueue.ShutDown()
+			if tt.fail {
+				f.kubeclient.PrependReactor("create", "statefulsets", func(action core.Action) (bool, runtime.Object, error) {
+					return true, nil, fmt.Errorf("injected error")
+				})
+			}
+			success, failure, durations := reconcileMetrics(t)
+
+			c.workqueue.Add(getKey(instance, t))
+			c.processNextWorkItem(ctx)
+
+			gotSuccess, gotFailure, gotDurations := reconcileMetrics(t)
+			wantSuccess, wantFailure := success+1, failure
+			if tt.fail {
+				wantSuccess, wantFailure = success, failure+1
+			}
+			if gotSuccess != wantSuccess || gotFailure != wantFailure {
+				t.Errorf("expected %v successful and %v failed reconciles, got %v and %v", wantSuccess, wantFailure, gotSuccess, gotFailure)
+			}
+			if gotDurations != durations+1 {
+				t.Errorf("expected %d observed durations, got %d", durations+1, gotDurations)
+			}
+		})
+	}
+}
+
+// reconcileRetries returns the retries counted for the SQLiteInstance with the
+// given name, and whether it has a series at all.
+func reconcileRetries(t *testing.T, namespace, name string) (float64, bool) {
+	t.Helper()
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatalf("error gathering metrics: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != "kubelitedb_reconcile_retries_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["namespace"] == namespace && labels["name"] == name {
+				return metric.GetCounter().GetValue(), true
+			}
+		}
+	}
+	return 0, false
+}
+
+func TestReconcileRetriesMetric(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("retried")
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+	defer c.workqueue.ShutDown()
+	fail := true
+	f.kubeclient.PrependReactor("create", "statefulsets", func(action core.Action) (bool, runtime.Object, error) {
+		if fail {
+			return true, nil, fmt.Errorf("injected error")
+		}
+		return false, nil, nil
+	})
+
+	c.workqueue.Add(getKey(instance, t))
+	for want := 1; want <= 3; want++ {
+		// The failed key is added back after the backoff, which Get waits for
+		c.processNextWorkItem(ctx)
+		if got, _ := reconcileRetries(t, instance.Namespace, instance.Name); got != float64(want) {
+			t.Fatalf("expected %d retries, got %v", want, got)
+		}
+	}
+
+	fail = false
+	c.processNextWorkItem(ctx)
+	if got, ok := reconcileRetries(t, instance.Namespace, instance.Name); ok {
+		t.Errorf("expected the retries to be forgotten after a successful reconcile, got %v", got)
+	}
+}
+
+// instanceMetrics returns the number of SQLiteInstances counted in each phase
+func instanceMetrics(t *testing.T) map[string]float64 {
+	t.Helper()
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatalf("error gathering metrics: %v", err)
+	}
+	phases := map[string]float64{}
+	for _, family := range families {
+		if family.GetName() != "kubelitedb_instances" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "phase" {
+					phases[label.GetValue()] = metric.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+	return phases
+}
+
+func TestInstanceMetrics(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	running := newSQLiteInstance("phase-running")
+	sts := newStatefulSet(running)
+	sts.Status.Replicas, sts.Status.ReadyReplicas, sts.Status.UpdatedReplicas = 1, 1, 1
+	f.addKubeObject(sts)
+	f.addInstance(running)
+	provisioning := newSQLiteInstance("phase-provisioning")
+	f.addInstance(provisioning)
+	c, _, _ := f.newController(ctx)
+	before := instanceMetrics(t)
+
+	f.run(ctx, c, getKey(running, t))
+	f.run(ctx, c, getKey(provisioning, t))
+
+	got := instanceMetrics(t)
+	runningPhase, provisioningPhase := string(kubelitedbv1.PhaseRunning), string(kubelitedbv1.PhaseProvisioning)
+	if got[runningPhase] != before[runningPhase]+1 || got[provisioningPhase] != before[provisioningPhase]+1 {
+		t.Errorf("expected one more SQLiteInstance %s and %s, got %v before and %v after the syncs", runningPhase, provisioningPhase, before, got)
+	}
+
+	// Syncing again doesn't count the SQLiteInstance twice
+	f.run(ctx, c, getKey(running, t))
+	if again := instanceMetrics(t); again[runningPhase] != got[runningPhase] {
+		t.Errorf("expected %v SQLiteInstances %s after syncing again, got %v", got[runningPhase], runningPhase, again[runningPhase])
+	}
+
+	if err := f.informers.Kubelitedb().V1().SQLiteInstances().Informer().GetIndexer().Delete(running); err != nil {
+		t.Fatalf("error deleting the SQLiteInstance from the cache: %v", err)
+	}
+	f.run(ctx, c, getKey(running, t))
+
+	deleted := instanceMetrics(t)
+	if deleted[runningPhase] != before[runningPhase] || deleted[provisioningPhase] != got[provisioningPhase] {
+		t.Errorf("expected only the deleted SQLiteInstance to no longer be counted, got %v before and %v after the deletion", got, deleted)
+	}
+}
+
+func TestHandleObject(t *testing.T) {
+	instance := newSQLiteInstance("test")
+	sts := newStatefulSet(instance)
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: v1.ObjectMeta{
+			Name:            dataPVCName(instance, 0),
+			Namespace:       instance.Namespace,
+			OwnerReferences: []v1.OwnerReference{*v1.NewControllerRef(sts, appsv1.SchemeGroupVersion.WithKind("StatefulSet"))},
+		},
+	}
+	unowned := newHeadlessService(instance)
+	unowned.OwnerReferences = nil
+	orphaned := newHeadlessService(newSQLiteInstance("gone"))
+
+	tests := []struct {
+		name string
+		obj  interface{}
+		want string
+	}{
+		{name: "owned by the SQLiteInstance", obj: sts, want: "default/test"},
+		{name: "owned by its StatefulSet", obj: pvc, want: "default/test"},
+		{name: "deleted", obj: cache.DeletedFinalStateUnknown{Key: "default/test-sqlite", Obj: sts}, want: "default/test"},
+		{name: "not owned", obj: unowned},
+		{name: "owner gone", obj: orphaned},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			f.addInstance(instance)
+			f.addKubeObject(sts)
+			c, _, _ := f.newController(ctx)
+			defer c.workqueue.ShutDown()
+
+			c.handleObject(tt.obj)
+
+			if tt.want == "" {
+				if c.workqueue.Len() != 0 {
+					t.Errorf("expected nothing to be enqueued, got %d items", c.workqueue.Len())
+				}
+				return
+			}
+			if c.workqueue.Len() != 1 {
+				t.Fatalf("expected 1 item to be enqueued, got %d", c.workqueue.Len())
+			}
+			if key, _ := c.workqueue.Get(); key != tt.want {
+				t.Errorf("expected %s to be enqueued, got %v", tt.want, key)
+			}
+		})
+	}
+}
+
+func TestStatefulSetDeletionEnqueuesOwner(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	f.addInstance(instance)
+	c, _, k8sI := f.newController(ctx)
+	defer c.workqueue.ShutDown()
+	k8sI.Start(ctx.Done())
+	k8sI.WaitForCacheSync(ctx.Done())
+
+	// next waits for the next key to be enqueued and marks it as done
+	next := func() interface{} {
+		t.Helper()
+		keys := make(chan interface{})
+		go func() {
+			key, _ := c.workqueue.Get()
+			c.workqueue.Done(key)
+			keys <- key
+		}()
+		select {
+		case key := <-keys:
+			return key
+		case <-time.After(10 * time.Second):
+			t.Fatalf("expected the SQLiteInstance to be enqueued")
+			return nil
+		}
+	}
+
+	sts := newStatefulSet(instance)
+	if _, err := f.kubeclient.AppsV1().StatefulSets(sts.Namespace).Create(ctx, sts, v1.CreateOptions{}); err != nil {
+		t.Fatalf("error creating StatefulSet: %v", err)
+	}
+	if key := next(); key != getKey(instance, t) {
+		t.Fatalf("expected %s to be enqueued on create, got %v", getKey(instance, t), key)
+	}
+
+	if err := f.kubeclient.AppsV1().StatefulSets(sts.Namespace).Delete(ctx, sts.Name, v1.DeleteOptions{}); err != nil {
+		t.Fatalf("error deleting StatefulSet: %v", err)
+	}
+	if key := next(); key != getKey(instance, t) {
+		t.Errorf("expected %s to be enqueued on delete, got %v", getKey(instance, t), key)
+	}
+}
+
+// container returns the container with the given name, failing the test when
+// it's missing.
+func container(t *testing.T, containers []corev1.Container, name string) *corev1.Container {
+	t.Helper()
+	for i := range containers {
+		if containers[i].Name == name {
+			return &containers[i]
+		}
+	}
+	t.Fatalf("expected a %s container in %v", name, containers)
+	return nil
+}
+
+func TestImage(t *testing.T) {
+	tests := []struct {
+		name  string
+		image string
+		want  string
+	}{
+		{name: "default", image: "", want: defaultImage},
+		{name: "explicit", image: "example.com/sqlite:3.45", want: "example.com/sqlite:3.45"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newSQLiteInstance("test")
+			instance.Spec.Image = tt.image
+
+			sts := newStatefulSet(instance)
+
+			if got := container(t, sts.Spec.Template.Spec.Containers, "sqlite").Image; got != tt.want {
+				t.Errorf("expected image %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestImageChangeRollsPods(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	f.addKubeObject(newStatefulSet(instance))
+	instance.Spec.Image = "example.com/sqlite:3.45"
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+
+	f.run(ctx, c, getKey(instance, t))
+
+	sts := f.getStatefulSet(ctx, instance)
+	if got := container(t, sts.Spec.Template.Spec.Containers, "sqlite").Image; got != instance.Spec.Image {
+		t.Errorf("expected image %q, got %q", instance.Spec.Image, got)
+	}
+	old := newStatefulSet(newSQLiteInstance("test"))
+	if sts.Spec.Template.Annotations[templateHashAnnotation] == old.Spec.Template.Annotations[templateHashAnnotation] {
+		t.Errorf("expected the template hash to change with the image")
+	}
+}
+
+func TestCommandOverride(t *testing.T) {
+	tests := []struct {
+		name    string
+		command []string
+		args    []string
+	}{
+		{name: "default"},
+		{name: "command", command: []string{"/usr/local/bin/sqlite-server"}},
+		{name: "args", args: []string{"--listen", ":5432"}},
+		{name: "command and args", command: []string{"/usr/local/bin/sqlite-server"}, args: []string{"--listen", ":5432"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newSQLiteInstance("test")
+			instance.Spec.ReadReplicas = 1
+			instance.Spec.Command = tt.command
+			instance.Spec.Args = tt.args
+
+			for kind, containers := range map[string][]corev1.Container{
+				"StatefulSet": newStatefulSet(instance).Spec.Template.Spec.Containers,
+				"Deployment":  newReaderDeployment(instance).Spec.Template.Spec.Containers,
+			} {
+				sqlite := container(t, containers, "sqlite")
+				// Unset fields keep the entrypoint of the image
+				if !slices.Equal(sqlite.Command, tt.command) || !slices.Equal(sqlite.Args, tt.args) {
+					t.Errorf("expected the %s to run %v %v, got %v %v", kind, tt.command, tt.args, sqlite.Command, sqlite.Args)
+				}
+			}
+			// The probes and the checkpoint on shutdown are kept when overridden
+			sqlite := container(t, newStatefulSet(instance).Spec.Template.Spec.Containers, "sqlite")
+			if sqlite.ReadinessProbe == nil || sqlite.LivenessProbe == nil || sqlite.Lifecycle == nil || sqlite.Lifecycle.PreStop == nil {
+				t.Errorf("expected the probes and preStop hook to be kept, got %+v", sqlite)
+			}
+		})
+	}
+}
+
+func TestCommandChangeRollsPods(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	old := newStatefulSet(instance)
+	f.addKubeObject(old)
+	instance.Spec.Command = []string{"/usr/local/bin/sqlite-server"}
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+
+	f.run(ctx, c, getKey(instance, t))
+
+	sts := f.getStatefulSet(ctx, instance)
+	if got := container(t, sts.Spec.Template.Spec.Containers, "sqlite").Command; !slices.Equal(got, instance.Spec.Command) {
+		t.Errorf("expected command %v, got %v", instance.Spec.Command, got)
+	}
+	if sts.Spec.Template.Annotations[templateHashAnnotation] == old.Spec.Template.Annotations[templateHashAnnotation] {
+		t.Errorf("expected the template hash to change with the command")
+	}
+}
+
+func TestPriorityClassName(t *testing.T) {
+	for _, priorityClassName := range []string{"", "database-critical"} {
+		t.Run(priorityClassName, func(t *testing.T) {
+			instance := newSQLiteInstance("test")
+			instance.Spec.ReadReplicas = 1
+			instance.Spec.PriorityClassName = priorityClassName
+
+			if got := newStatefulSet(instance).Spec.Template.Spec.PriorityClassName; got != priorityClassName {
+				t.Errorf("expected the StatefulSet pods to have priority class %q, got %q", priorityClassName, got)
+			}
+			if got := newReaderDeployment(instance).Spec.Template.Spec.PriorityClassName; got != priorityClassName {
+				t.Errorf("expected the read-only pods to have priority class %q, got %q", priorityClassName, got)
+			}
+		})
+	}
+}
+
+func TestPriorityClassNameChangeRollsPods(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	old := newStatefulSet(instance)
+	f.addKubeObject(old)
+	instance.Spec.PriorityClassName = "database-critical"
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+
+	f.run(ctx, c, getKey(instance, t))
+
+	sts := f.getStatefulSet(ctx, instance)
+	if got := sts.Spec.Template.Spec.PriorityClassName; got != instance.Spec.PriorityClassName {
+		t.Errorf("expected priority class %q, got %q", instance.Spec.PriorityClassName, got)
+	}
+	if sts.Spec.Template.Annotations[templateHashAnnotation] == old.Spec.Template.Annotations[templateHashAnnotation] {
+		t.Errorf("expected the template hash to change with the priority class")
+	}
+}
+
+func TestResources(t *testing.T) {
+	tests := []struct {
+		name      string
+		resources corev1.ResourceRequirements
+		want      corev1.ResourceRequirements
+	}{
+		{
+			name: "default",
+			want: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse(defaultCPURequest),
+					corev1.ResourceMemory: resource.MustParse(defaultMemoryRequest),
+				},
+			},
+		},
+		{
+			name: "requests and limits",
+			resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("250m")},
+				Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")},
+			},
+			want: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("250m")},
+				Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")},
+			},
+		},
+		{
+			name: "limits only",
+			resources: corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")},
+			},
+			want: corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newSQLiteInstance("test")
+			instance.Spec.Resources = tt.resources
+
+			sts := newStatefulSet(instance)
+
+			got := container(t, sts.Spec.Template.Spec.Containers, "sqlite").Resources
+			if !equality.Semantic.DeepEqual(got, tt.want) {
+				t.Errorf("expected resources %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestResourcesChangeRollsPods(t *testing.T) {
+	instance := newSQLiteInstance("test")
+	before := newStatefulSet(instance)
+	instance.Spec.Resources.Limits = corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")}
+	after := newStatefulSet(instance)
+
+	if before.Spec.Template.Annotations[templateHashAnnotation] == after.Spec.Template.Annotations[templateHashAnnotation] {
+		t.Errorf("expected the template hash to change with the resources")
+	}
+}
+
+func TestReadyReplicas(t *testing.T) {
+	tests := []struct {
+		name        string
+		replicas    int
+		statefulSet bool
+		ready       int32
+		wantReady   v1.ConditionStatus
+		wantPhase   kubelitedbv1.SQLiteInstancePhase
+	}{
+		{name: "missing StatefulSet", replicas: 1, wantReady: v1.ConditionFalse, wantPhase: kubelitedbv1.PhaseProvisioning},
+		{name: "no pod ready", replicas: 1, statefulSet: true, ready: 0, wantReady: v1.ConditionFalse, wantPhase: kubelitedbv1.PhaseProvisioning},
+		{name: "every pod ready", replicas: 1, statefulSet: true, ready: 1, wantReady: v1.ConditionTrue, wantPhase: kubelitedbv1.PhaseRunning},
+		{name: "scaled to zero", replicas: 0, statefulSet: true, ready: 0, wantReady: v1.ConditionTrue, wantPhase: kubelitedbv1.PhaseRunning},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := newSQLiteInstance("test")
+			instance.Spec.Replicas = tt.replicas
+			f.addInstance(instance)
+			if tt.statefulSet {
+				sts := newStatefulSet(instance)
+				sts.Status.Replicas = int32(tt.replicas)
+				sts.Status.ReadyReplicas = tt.ready
+				f.addKubeObject(sts)
+			}
+			c, _, _ := f.newController(ctx)
+
+			f.run(ctx, c, getKey(instance, t))
+
+			got := f.getInstance(ctx, instance)
+			if got.Status.ReadyReplicas != tt.ready {
+				t.Errorf("expected %d ready replicas, got %d", tt.ready, got.Status.ReadyReplicas)
+			}
+			if condition := meta.FindStatusCondition(got.Status.Conditions, kubelitedbv1.ConditionReady); condition == nil || condition.Status != tt.wantReady {
+				t.Errorf("expected condition %s %s, got %v", kubelitedbv1.ConditionReady, tt.wantReady, condition)
+			}
+			if got.Status.Phase != tt.wantPhase {
+				t.Errorf("expected phase %s, got %s", tt.wantPhase, got.Status.Phase)
+			}
+		})
+	}
+}
+
+func TestInvalidDbName(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	instance.Spec.DbName = "../escape.db"
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+
+	f.run(ctx, c, getKey(instance, t))
+
+	got := f.getInstance(ctx, instance)
+	condition := meta.FindStatusCondition(got.Status.Conditions, kubelitedbv1.ConditionReady)
+	if condition == nil || condition.Status != v1.ConditionFalse || condition.Reason != ReasonInvalidDbName {
+		t.Errorf("expected condition False with reason %s, got %v", ReasonInvalidDbName, condition)
+	}
+	if got.Status.Phase != kubelitedbv1.PhaseFailed {
+		t.Errorf("expected phase %s, got %s", kubelitedbv1.PhaseFailed, got.Status.Phase)
+	}
+	if actions := writes(f.kubeclient.Actions(), "statefulsets"); len(actions) != 0 {
+		t.Errorf("expected no StatefulSet writes, got %v", actions)
+	}
+	expectEvent(t, f.recorder, corev1.EventTypeWarning, ReasonInvalidDbName)
+}
+
+func TestScheduling(t *testing.T) {
+	affinity := &corev1.Affinity{
+		NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+					MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "disktype", Operator: corev1.NodeSelectorOpIn, Values: []string{"ssd"}}},
+				}},
+			},
+		},
+	}
+	tolerations := []corev1.Toleration{{Key: "storage", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule}}
+	nodeSelector := map[string]string{"node.kubernetes.io/instance-type": "i3.large"}
+
+	tests := []struct {
+		name   string
+		mutate func(spec *kubelitedbv1.SQLiteInstanceSpec)
+		check  func(t *testing.T, spec corev1.PodSpec)
+		rolls  bool
+	}{
+		{
+			name:   "defaults",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {},
+			check: func(t *testing.T, spec corev1.PodSpec) {
+				if spec.NodeSelector != nil || spec.Affinity != nil || spec.Tolerations != nil {
+					t.Errorf("expected the scheduler defaults, got %v %v %v", spec.NodeSelector, spec.Affinity, spec.Tolerations)
+				}
+			},
+		},
+		{
+			name:   "node selector",
+			rolls:  true,
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.NodeSelector = nodeSelector },
+			check: func(t *testing.T, spec corev1.PodSpec) {
+				if !equality.Semantic.DeepEqual(spec.NodeSelector, nodeSelector) {
+					t.Errorf("expected node selector %v, got %v", nodeSelector, spec.NodeSelector)
+				}
+			},
+		},
+		{
+			name:   "affinity",
+			rolls:  true,
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.Affinity = affinity },
+			check: func(t *testing.T, spec corev1.PodSpec) {
+				if !equality.Semantic.DeepEqual(spec.Affinity, affinity) {
+					t.Errorf("expected affinity %v, got %v", affinity, spec.Affinity)
+				}
+			},
+		},
+		{
+			name:   "tolerations",
+			rolls:  true,
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.Tolerations = tolerations },
+			check: func(t *testing.T, spec corev1.PodSpec) {
+				if !equality.Semantic.DeepEqual(spec.Tolerations, tolerations) {
+					t.Errorf("expected tolerations %v, got %v", tolerations, spec.Tolerations)
+				}
+			},
+		},
+	}
+	defaults := newStatefulSet(newSQLiteInstance("test"))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newSQLiteInstance("test")
+			tt.mutate(&instance.Spec)
+
+			sts := newStatefulSet(instance)
+
+			tt.check(t, sts.Spec.Template.Spec)
+			changed := sts.Spec.Template.Annotations[templateHashAnnotation] != defaults.Spec.Template.Annotations[templateHashAnnotation]
+			if changed != tt.rolls {
+				t.Errorf("expected the template hash to change %v, got %v", tt.rolls, changed)
+			}
+		})
+	}
+}
+
+// expectEvent fails the test unless an Event of the given type and reason was
+// recorded. Events recorded before it are skipped.
+func expectEvent(t *testing.T, recorder *record.FakeRecorder, eventType, reason string) string {
+	t.Helper()
+	for {
+		select {
+		case event := <-recorder.Events:
+			if strings.HasPrefix(event, eventType+" "+reason+" ") {
+				return event
+			}
+		default:
+			t.Errorf("expected a %s Event with reason %s", eventType, reason)
+			return ""
+		}
+	}
+}
+
+func TestInvalidStorage(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	instance.Spec.Storage = "lots"
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+
+	result, err := c.syncHandler(ctx, getKey(instance, t))
+
+	if err != nil {
+		t.Fatalf("expected an invalid storage not to be retried, got %v", err)
+	}
+	if result != (reconcileResult{}) {
+		t.Errorf("expected an invalid storage not to be requeued, got %+v", result)
+	}
+	got := f.getInstance(ctx, instance)
+	condition := meta.FindStatusCondition(got.Status.Conditions, kubelitedbv1.ConditionStorageProvisioned)
+	if condition == nil || condition.Status != v1.ConditionFalse || !strings.Contains(condition.Message, `"lots"`) {
+		t.Errorf("expected condition False with the parse error, got %v", condition)
+	}
+	if event := expectEvent(t, f.recorder, corev1.EventTypeWarning, ReasonInvalidStorage); event != "" && !strings.Contains(event, `"lots"`) {
+		t.Errorf("expected the Event to carry the parse error, got %q", event)
+	}
+}
+
+func TestPreStopCheckpoint(t *testing.T) {
+	seconds := func(s int64) *int64 { return &s }
+	tests := []struct {
+		name        string
+		gracePeriod *int64
+		replicated  bool
+		wantGrace   int64
+		wantMode    string
+	}{
+		{name: "defaults", wantGrace: defaultTerminationGracePeriodSeconds, wantMode: "TRUNCATE"},
+		{name: "grace period", gracePeriod: seconds(120), wantGrace: 120, wantMode: "TRUNCATE"},
+		{name: "no grace period", gracePeriod: seconds(0), wantGrace: 0, wantMode: "TRUNCATE"},
+		{name: "replicated", replicated: true, wantGrace: defaultTerminationGracePeriodSeconds, wantMode: "PASSIVE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newSQLiteInstance("test")
+			if tt.replicated {
+				instance = newReplicatedSQLiteInstance("test")
+			}
+			instance.Spec.TerminationGracePeriodSeconds = tt.gracePeriod
+
+			spec := newStatefulSet(instance).Spec.Template.Spec
+
+			if spec.TerminationGracePeriodSeconds == nil || *spec.TerminationGracePeriodSeconds != tt.wantGrace {
+				t.Errorf("expected a grace period of %d seconds, got %v", tt.wantGrace, spec.TerminationGracePeriodSeconds)
+			}
+			sqlite := container(t, spec.Containers, "sqlite")
+			if sqlite.Lifecycle == nil || sqlite.Lifecycle.PreStop == nil || sqlite.Lifecycle.PreStop.Exec == nil {
+				t.Fatalf("expected a preStop exec hook, got %v", sqlite.Lifecycle)
+			}
+			want := []string{"sqlite3", databasePath(instance), fmt.Sprintf("PRAGMA wal_checkpoint(%s);", tt.wantMode)}
+			if got := sqlite.Lifecycle.PreStop.Exec.Command; !slices.Equal(got, want) {
+				t.Errorf("expected the preStop hook %v, got %v", want, got)
+			}
+		})
+	}
+}
+
+func TestStorageClass(t *testing.T) {
+	class := func(name string) *string { return &name }
+	tests := []struct {
+		name         string
+		storageClass *string
+	}{
+		{name: "cluster default", storageClass: nil},
+		{name: "no class", storageClass: class("")},
+		{name: "named", storageClass: class("fast-ssd")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newSQLiteInstance("test")
+			instance.Spec.StorageClassName = tt.storageClass
+
+			sts := newStatefulSet(instance)
+
+			if got := volumeClaimTemplateStorageClass(sts); !equality.Semantic.DeepEqual(got, tt.storageClass) {
+				t.Errorf("expected storage class %s, got %s", storageClassString(tt.storageClass), storageClassString(got))
+			}
+		})
+	}
+}
+
+func TestStorageClassChangeWarns(t *testing.T) {
+	fast, slow := "fast-ssd", "slow-hdd"
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	instance.Spec.StorageClassName = &fast
+	f.addKubeObject(newStatefulSet(instance))
+	instance = instance.DeepCopy()
+	instance.Spec.StorageClassName = &slow
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+
+	f.run(ctx, c, getKey(instance, t))
+
+	got := f.getInstance(ctx, instance)
+	condition := meta.FindStatusCondition(got.Status.Conditions, kubelitedbv1.ConditionStorageProvisioned)
+	if condition == nil || condition.Reason != ReasonStorageClassImmutable {
+		t.Errorf("expected reason %s, got %v", ReasonStorageClassImmutable, condition)
+	}
+	expectEvent(t, f.recorder, corev1.EventTypeWarning, ReasonStorageClassImmutable)
+	if current := volumeClaimTemplateStorageClass(f.getStatefulSet(ctx, instance)); current == nil || *current != fast {
+		t.Errorf("expected the volumes to keep storage class %q, got %s", fast, storageClassString(current))
+	}
+}
+
+func TestScaleToZero(t *testing.T) {
+	tests := []struct {
+		name         string
+		annotations  map[string]string
+		blocked      bool
+		wantReplicas int32
+	}{
+		{name: "without the annotation", blocked: true, wantReplicas: 1},
+		{name: "with the annotation", annotations: map[string]string{kubelitedbv1.AllowDataLossAnnotation: "true"}, wantReplicas: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := newSQLiteInstance("test")
+			f.addKubeObject(newStatefulSet(instance))
+			instance = instance.DeepCopy()
+			instance.Annotations = tt.annotations
+			instance.Spec.Replicas = 0
+			f.addInstance(instance)
+			c, _, _ := f.newController(ctx)
+
+			f.run(ctx, c, getKey(instance, t))
+
+			if got := *f.getStatefulSet(ctx, instance).Spec.Replicas; got != tt.wantReplicas {
+				t.Errorf("expected %d replicas, got %d", tt.wantReplicas, got)
+			}
+			condition := meta.FindStatusCondition(f.getInstance(ctx, instance).Status.Conditions, kubelitedbv1.ConditionScaleDownBlocked)
+			if !tt.blocked {
+				if condition != nil {
+					t.Errorf("expected no %s condition, got %v", kubelitedbv1.ConditionScaleDownBlocked, condition)
+				}
+				return
+			}
+			if condition == nil || condition.Status != v1.ConditionTrue || condition.Reason != ReasonWriterWouldBeRemoved {
+				t.Fatalf("expected condition %s True with reason %s, got %v", kubelitedbv1.ConditionScaleDownBlocked, ReasonWriterWouldBeRemoved, condition)
+			}
+			if !strings.Contains(condition.Message, kubelitedbv1.AllowDataLossAnnotation) {
+				t.Errorf("expected the message to name the %s annotation, got %q", kubelitedbv1.AllowDataLossAnnotation, condition.Message)
+			}
+			expectEvent(t, f.recorder, corev1.EventTypeWarning, ReasonWriterWouldBeRemoved)
+		})
+	}
+}
+
+func TestRunInvalidWorkers(t *testing.T) {
+	for _, workers := range []int{0, -1} {
+		t.Run(fmt.Sprint(workers), func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			c, _, _ := f.newController(ctx)
+
+			err := c.Run(ctx, workers, 0)
+
+			if err == nil || !strings.Contains(err.Error(), "invalid number of workers") {
+				t.Errorf("expected an error about the invalid number of workers, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSQLiteInstanceChanged(t *testing.T) {
+	now := v1.Now()
+	tests := []struct {
+		name   string
+		update func(instance *kubelitedbv1.SQLiteInstance)
+		want   bool
+	}{
+		{name: "periodic resync", update: func(instance *kubelitedbv1.SQLiteInstance) {}, want: true},
+		{name: "resource version only", update: func(instance *kubelitedbv1.SQLiteInstance) { instance.ResourceVersion = "2" }},
+		{
+			name: "status only",
+			update: func(instance *kubelitedbv1.SQLiteInstance) {
+				instance.ResourceVersion = "2"
+				instance.Status.Phase = kubelitedbv1.PhaseRunning
+				instance.Status.ReadyReplicas = 1
+			},
+		},
+		{
+			name: "spec",
+			update: func(instance *kubelitedbv1.SQLiteInstance) {
+				instance.ResourceVersion, instance.Generation = "2", 2
+				instance.Spec.Storage = "2Gi"
+			},
+			want: true,
+		},
+		{
+			name: "labels",
+			update: func(instance *kubelitedbv1.SQLiteInstance) {
+				instance.ResourceVersion = "2"
+				instance.Labels = map[string]string{"team": "payments"}
+			},
+			want: true,
+		},
+		{
+			name: "backup recorded",
+			update: func(instance *kubelitedbv1.SQLiteInstance) {
+				instance.ResourceVersion = "2"
+				instance.Status.LastBackupTime = &now
+			},
+			want: true,
+		},
+		{
+			name: "deletion",
+			update: func(instance *kubelitedbv1.SQLiteInstance) {
+				instance.ResourceVersion = "2"
+				instance.DeletionTimestamp = &now
+			},
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := newSQLiteInstance("test")
+			old.ResourceVersion, old.Generation = "1", 1
+			new := old.DeepCopy()
+			tt.update(new)
+
+			if got := sqliteInstanceChanged(old, new); got != tt.want {
+				t.Errorf("expected changed %t, got %t", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestStatusUpdatesNotEnqueued(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	f := newFixture(t)
+	statusOnly := newSQLiteInstance("status-only")
+	edited := newSQLiteInstance("edited")
+	for _, instance := range []*kubelitedbv1.SQLiteInstance{statusOnly, edited} {
+		instance.ResourceVersion, instance.Generation = "1", 1
+		f.addInstance(instance)
+	}
+	c, i, _ := f.newController(ctx)
+	defer c.workqueue.ShutDown()
+	i.Start(ctx.Done())
+	i.WaitForCacheSync(ctx.Done())
+	drain := func(n int) []string {
+		t.Helper()
+		err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+			return c.workqueue.Len() >= n, nil
+		})
+		if err != nil {
+			t.Fatalf("timed out waiting for %d queued SQLiteInstances, got %d", n, c.workqueue.Len())
+		}
+		var keys []string
+		for c.workqueue.Len() > 0 {
+			key, _ := c.workqueue.Get()
+			keys = append(keys, key.(string))
+			c.workqueue.Done(key)
+		}
+		return keys
+	}
+	// The initial list is delivered as a resync of the prepopulated cache
+	drain(2)
+
+	statusOnly = statusOnly.DeepCopy()
+	statusOnly.ResourceVersion = "2"
+	statusOnly.Status.Phase = kubelitedbv1.PhaseRunning
+	if _, err := f.client.KubelitedbV1().SQLiteInstances(statusOnly.Namespace).UpdateStatus(ctx, statusOnly, v1.UpdateOptions{}); err != nil {
+		t.Fatalf("error updating the status: %v", err)
+	}
+	// The edit is delivered after the status update, so once it is queued
+	// the status update was handled as well.
+	edited = edited.DeepCopy()
+	edited.ResourceVersion, edited.Generation = "2", 2
+	edited.Spec.Storage = "2Gi"
+	if _, err := f.client.KubelitedbV1().SQLiteInstances(edited.Namespace).Update(ctx, edited, v1.UpdateOptions{}); err != nil {
+		t.Fatalf("error updating the spec: %v", err)
+	}
+
+	if got, want := drain(1), []string{getKey(edited, t)}; !slices.Equal(got, want) {
+		t.Errorf("expected only %v to be queued, got %v", want, got)
+	}
+}
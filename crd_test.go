@@ -0,0 +1,223 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/fortytwoapps/kubelitedb/pkg/webhook"
+)
+
+// crdSchema is the part of an OpenAPI schema of a CRD the tests look at
+type crdSchema struct {
+	Type       string               `json:"type"`
+	Properties map[string]crdSchema `json:"properties"`
+}
+
+// crdColumn is an additional printer column of a CRD version
+type crdColumn struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	JSONPath string `json:"jsonPath"`
+}
+
+// crdVersion is the part of a version of a CRD the tests look at
+type crdVersion struct {
+	Name                     string      `json:"name"`
+	Served                   bool        `json:"served"`
+	Storage                  bool        `json:"storage"`
+	AdditionalPrinterColumns []crdColumn `json:"additionalPrinterColumns"`
+	Subresources             struct {
+		Scale *crdScale `json:"scale"`
+	} `json:"subresources"`
+	Schema struct {
+		OpenAPIV3Schema crdSchema `json:"openAPIV3Schema"`
+	} `json:"schema"`
+}
+
+// crdScale is the scale subresource of a CRD version
+type crdScale struct {
+	SpecReplicasPath   string `json:"specReplicasPath"`
+	StatusReplicasPath string `json:"statusReplicasPath"`
+	LabelSelectorPath  string `json:"labelSelectorPath"`
+}
+
+// crd is the part of a CustomResourceDefinition manifest the tests look at
+type crd struct {
+	Spec struct {
+		Versions   []crdVersion `json:"versions"`
+		Conversion struct {
+			Strategy string `json:"strategy"`
+			Webhook  struct {
+				ClientConfig struct {
+					Service struct {
+						Path string `json:"path"`
+					} `json:"service"`
+				} `json:"clientConfig"`
+			} `json:"webhook"`
+		} `json:"conversion"`
+	} `json:"spec"`
+}
+
+// readCRD reads the CustomResourceDefinition manifest at path.
+func readCRD(t *testing.T, path string) *crd {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("error reading %s: %v", path, err)
+	}
+	var ret crd
+	if err := yaml.Unmarshal(data, &ret); err != nil {
+		t.Fatalf("error parsing %s: %v", path, err)
+	}
+	return &ret
+}
+
+// crdV1 returns the v1 version of the CustomResourceDefinition manifest at path.
+func crdV1(t *testing.T, path string) *crdVersion {
+	t.Helper()
+	manifest := readCRD(t, path)
+	i := slices.IndexFunc(manifest.Spec.Versions, func(version crdVersion) bool {
+		return version.Name == "v1"
+	})
+	if i < 0 {
+		t.Fatalf("expected the CRD to serve v1")
+	}
+	return &manifest.Spec.Versions[i]
+}
+
+// schemaField returns the schema of the field at jsonPath, if any.
+func schemaField(schema crdSchema, jsonPath string) (crdSchema, bool) {
+	for _, name := range strings.Split(strings.TrimPrefix(jsonPath, "."), ".") {
+		var ok bool
+		if schema, ok = schema.Properties[name]; !ok {
+			return crdSchema{}, false
+		}
+	}
+	return schema, true
+}
+
+func TestSQLiteInstancePrinterColumns(t *testing.T) {
+	version := crdV1(t, "crds/sqliteinstances.yaml")
+
+	tests := []struct {
+		name     string
+		jsonPath string
+		typ      string
+	}{
+		{name: "Storage", jsonPath: ".spec.storage", typ: "string"},
+		{name: "Replicas", jsonPath: ".spec.replicas", typ: "integer"},
+		{name: "Ready", jsonPath: ".status.readyReplicas", typ: "integer"},
+		{name: "Phase", jsonPath: ".status.phase", typ: "string"},
+		{name: "Age", jsonPath: ".metadata.creationTimestamp", typ: "date"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := slices.IndexFunc(version.AdditionalPrinterColumns, func(column crdColumn) bool {
+				return column.Name == tt.name
+			})
+			if j < 0 {
+				t.Fatalf("expected a %s column", tt.name)
+			}
+			column := version.AdditionalPrinterColumns[j]
+			if column.JSONPath != tt.jsonPath || column.Type != tt.typ {
+				t.Errorf("expected a %s column of %s, got a %s column of %s", tt.typ, tt.jsonPath, column.Type, column.JSONPath)
+			}
+			// Fields missing from the schema are pruned, leaving the column empty
+			if strings.HasPrefix(tt.jsonPath, ".metadata.") {
+				return
+			}
+			schema, ok := schemaField(version.Schema.OpenAPIV3Schema, tt.jsonPath)
+			if !ok {
+				t.Fatalf("expected %s in the schema", tt.jsonPath)
+			}
+			if tt.typ != "date" && schema.Type != tt.typ {
+				t.Errorf("expected %s to be a %s in the schema, got %q", tt.jsonPath, tt.typ, schema.Type)
+			}
+		})
+	}
+}
+
+func TestSQLiteInstanceScaleSubresource(t *testing.T) {
+	version := crdV1(t, "crds/sqliteinstances.yaml")
+	scale := version.Subresources.Scale
+	if scale == nil {
+		t.Fatalf("expected the v1 CRD to have a scale subresource")
+	}
+
+	tests := []struct {
+		name     string
+		got      string
+		jsonPath string
+		typ      string
+	}{
+		{name: "spec replicas", got: scale.SpecReplicasPath, jsonPath: ".spec.replicas", typ: "integer"},
+		{name: "status replicas", got: scale.StatusReplicasPath, jsonPath: ".status.replicas", typ: "integer"},
+		{name: "label selector", got: scale.LabelSelectorPath, jsonPath: ".status.selector", typ: "string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.jsonPath {
+				t.Fatalf("expected the %s path %s, got %q", tt.name, tt.jsonPath, tt.got)
+			}
+			schema, ok := schemaField(version.Schema.OpenAPIV3Schema, tt.jsonPath)
+			if !ok {
+				t.Fatalf("expected %s in the schema", tt.jsonPath)
+			}
+			if schema.Type != tt.typ {
+				t.Errorf("expected %s to be a %s in the schema, got %q", tt.jsonPath, tt.typ, schema.Type)
+			}
+		})
+	}
+}
+
+func TestSQLiteInstanceConversion(t *testing.T) {
+	manifest := readCRD(t, "crds/sqliteinstances.yaml")
+
+	tests := []struct {
+		name    string
+		storage bool
+	}{
+		{name: "v1", storage: true},
+		{name: "v1beta1", storage: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := slices.IndexFunc(manifest.Spec.Versions, func(version crdVersion) bool {
+				return version.Name == tt.name
+			})
+			if i < 0 {
+				t.Fatalf("expected the CRD to have %s", tt.name)
+			}
+			version := manifest.Spec.Versions[i]
+			if !version.Served || version.Storage != tt.storage {
+				t.Errorf("expected %s to be served with storage %t, got served %t with storage %t", tt.name, tt.storage, version.Served, version.Storage)
+			}
+		})
+	}
+
+	// Objects of both versions are converted by the webhook server
+	conversion := manifest.Spec.Conversion
+	if conversion.Strategy != "Webhook" || conversion.Webhook.ClientConfig.Service.Path != webhook.ConvertPath {
+		t.Errorf("expected a Webhook conversion on %s, got %s on %q", webhook.ConvertPath, conversion.Strategy, conversion.Webhook.ClientConfig.Service.Path)
+	}
+}
@@ -0,0 +1,249 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+const (
+	// ReasonHostPathConfigured is used as the condition reason when the pods
+	// of a DaemonSet keep the database on a hostPath volume
+	ReasonHostPathConfigured = "HostPathConfigured"
+	// ReasonDaemonSetUpdated is used as the condition reason while the
+	// DaemonSet is being rolled out
+	ReasonDaemonSetUpdated = "DaemonSetUpdated"
+
+	// MessageDaemonSetUpdated is the message used while the DaemonSet is
+	// being rolled out
+	MessageDaemonSetUpdated = "DaemonSet %q is being rolled out"
+	// MessageHostPathConfigured is the message used when the pods of a
+	// DaemonSet keep the database on a hostPath volume
+	MessageHostPathConfigured = "The database of every node is kept in %s"
+
+	// hostPathPermissionsContainerName is the name of the init container
+	// handing the hostPath directory to the user the pods run as
+	hostPathPermissionsContainerName = "host-path-permissions"
+)
+
+// defaultHostPathBase is the directory on the nodes below which SQLiteInstances
+// in the DaemonSet mode keep their databases by default
+const defaultHostPathBase = "/var/lib/kubelitedb"
+
+// hostPathPermissionsScript creates the directory of the database and hands
+// the data volume to OWNER.
+const hostPathPermissionsScript = `set -e
+mkdir -p "$DATA_DIR"
+chown -R "$OWNER" "$DATA_ROOT"
+`
+
+// daemonSetMode returns whether the SQLiteInstance runs a pod with its own
+// database on every node rather than a StatefulSet.
+func daemonSetMode(instance *kubelitedbv1.SQLiteInstance) bool {
+	return instance.Spec.DeploymentMode == kubelitedbv1.DeploymentModeDaemonSet
+}
+
+// daemonSetName returns the name of the DaemonSet managed for the given
+// SQLiteInstance.
+func daemonSetName(instance *kubelitedbv1.SQLiteInstance) string {
+	return fmt.Sprintf("%s-sqlite", instance.Name)
+}
+
+// hostPathForInstance returns the directory on every node holding the
+// database of the SQLiteInstance, below the given base path.
+func hostPathForInstance(instance *kubelitedbv1.SQLiteInstance, hostPathBase string) string {
+	return path.Join(hostPathBase, instance.Namespace, instance.Name)
+}
+
+// newDaemonSet creates the DaemonSet running the pods of a SQLiteInstance in
+// the DaemonSet mode. The pods are the ones of the StatefulSet, with the data
+// volume on the node instead of a PVC.
+func newDaemonSet(instance *kubelitedbv1.SQLiteInstance, hostPathBase string) *appsv1.DaemonSet {
+	template := newStatefulSet(instance).Spec.Template
+	delete(template.Annotations, templateHashAnnotation)
+
+	hostPathType := corev1.HostPathDirectoryOrCreate
+	template.Spec.Volumes = append(template.Spec.Volumes, corev1.Volume{
+		Name: dataVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			HostPath: &corev1.HostPathVolumeSource{
+				Path: hostPathForInstance(instance, hostPathBase),
+				Type: &hostPathType,
+			},
+		},
+	})
+	addHostPathPermissionsInitContainer(instance, &template)
+	template.Annotations[templateHashAnnotation] = computeHash(template)
+
+	return &appsv1.DaemonSet{
+		ObjectMeta: v1.ObjectMeta{
+			Name:        daemonSetName(instance),
+			Namespace:   instance.Namespace,
+			Labels:      childLabels(instance, labelsForInstance(instance, componentDatabase)),
+			Annotations: childAnnotations(instance),
+			OwnerReferences: []v1.OwnerReference{
+				newOwnerReference(instance),
+			},
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &v1.LabelSelector{
+				MatchLabels: podLabels(instance),
+			},
+			Template: template,
+		},
+	}
+}
+
+// addHostPathPermissionsInitContainer adds the init container handing the
+// hostPath directory, which the kubelet creates as root, to the user the
+// other containers run as. It is the only container running as root.
+func addHostPathPermissionsInitContainer(instance *kubelitedbv1.SQLiteInstance, template *corev1.PodTemplateSpec) {
+	user, group := int64(defaultRunAsUser), int64(defaultRunAsUser)
+	if podSecurityContext := template.Spec.SecurityContext; podSecurityContext != nil {
+		if podSecurityContext.RunAsUser != nil {
+			user = *podSecurityContext.RunAsUser
+		}
+		if podSecurityContext.RunAsGroup != nil {
+			group = *podSecurityContext.RunAsGroup
+		}
+	}
+
+	root := int64(0)
+	runAsNonRoot := false
+	template.Spec.InitContainers = append([]corev1.Container{{
+		Name:    hostPathPermissionsContainerName,
+		Image:   imageForInstance(instance),
+		Command: []string{"/bin/sh", "-c", hostPathPermissionsScript},
+		Env: []corev1.EnvVar{
+			{Name: "DATA_ROOT", Value: dataMountPath},
+			{Name: "DATA_DIR", Value: path.Join(dataMountPath, dataSubPath(instance))},
+			{Name: "OWNER", Value: strconv.FormatInt(user, 10) + ":" + strconv.FormatInt(group, 10)},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: dataVolumeName, MountPath: dataMountPath},
+		},
+		SecurityContext: &corev1.SecurityContext{
+			RunAsUser:    &root,
+			RunAsNonRoot: &runAsNonRoot,
+			Capabilities: &corev1.Capabilities{
+				Drop: []corev1.Capability{"ALL"},
+				Add:  []corev1.Capability{"CHOWN"},
+			},
+		},
+	}}, template.Spec.InitContainers...)
+}
+
+// syncDaemonSet ensures the DaemonSet of a SQLiteInstance in the DaemonSet
+// mode matches the spec, and records its state in the status. The pods have
+// no PVCs or Services, so only the DaemonSet and the configuration mounted
+// into its pods are synced.
+func (c *Controller) syncDaemonSet(ctx context.Context, key string, sqliteInstance *kubelitedbv1.SQLiteInstance, status *kubelitedbv1.SQLiteInstanceStatus) (reconcileResult, error) {
+	if err := c.syncPragmasConfigMap(ctx, sqliteInstance); err != nil {
+		return reconcileResult{}, err
+	}
+	tlsHash, err := c.tlsSecretHash(ctx, sqliteInstance)
+	if err != nil {
+		return reconcileResult{}, err
+	}
+
+	desired := newDaemonSet(sqliteInstance, c.hostPathBase)
+	setTLSSecretHash(&desired.Spec.Template, tlsHash)
+
+	daemonSets := c.kubeclientset.AppsV1().DaemonSets(sqliteInstance.Namespace)
+	daemonSet, err := daemonSets.Get(ctx, daemonSetName(sqliteInstance), v1.GetOptions{})
+	progressing := false
+	if errors.IsNotFound(err) {
+		daemonSet, err = daemonSets.Create(ctx, desired.DeepCopy(), c.createOptions(ctx, sqliteInstance, "DaemonSet", daemonSetName(sqliteInstance)))
+		logWrite(ctx, "create", "DaemonSet", daemonSetName(sqliteInstance), err)
+		progressing = true
+	}
+	if err != nil {
+		return reconcileResult{}, err
+	}
+
+	if !v1.IsControlledBy(daemonSet, sqliteInstance) {
+		msg := fmt.Sprintf(MessageResourceExists, daemonSet.Name)
+		c.recorder.Event(sqliteInstance, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return reconcileResult{}, fmt.Errorf("%s", msg)
+	}
+
+	if daemonSet.Spec.Template.Annotations[templateHashAnnotation] != desired.Spec.Template.Annotations[templateHashAnnotation] ||
+		metadataOutOfDate(daemonSet, desired) {
+		daemonSetCopy := daemonSet.DeepCopy()
+		mergeMetadata(daemonSetCopy, desired)
+		daemonSetCopy.Spec.Template = desired.Spec.Template
+		_, err = daemonSets.Update(ctx, daemonSetCopy, c.updateOptions(ctx, sqliteInstance, "DaemonSet", daemonSet, daemonSetCopy))
+		logWrite(ctx, "update", "DaemonSet", daemonSet.Name, err)
+		if err != nil {
+			return reconcileResult{}, err
+		}
+		progressing = true
+	}
+
+	// Every scheduled pod serves a database of its own, so the SQLiteInstance
+	// is ready once all of them are.
+	status.Replicas = daemonSet.Status.DesiredNumberScheduled
+	status.Selector = v1.FormatLabelSelector(daemonSet.Spec.Selector)
+	status.ReadyReplicas = daemonSet.Status.NumberReady
+	status.WriterReadyReplicas = daemonSet.Status.NumberReady
+	allReady := status.Replicas > 0 && status.ReadyReplicas == status.Replicas
+	notReadyMessage := fmt.Sprintf(MessageReplicasNotReady, status.ReadyReplicas, status.Replicas)
+
+	setCondition(status, sqliteInstance, kubelitedbv1.ConditionStorageProvisioned, v1.ConditionTrue, ReasonHostPathConfigured,
+		fmt.Sprintf(MessageHostPathConfigured, hostPathForInstance(sqliteInstance, c.hostPathBase)))
+	switch {
+	case progressing:
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionProgressing, v1.ConditionTrue, ReasonDaemonSetUpdated, fmt.Sprintf(MessageDaemonSetUpdated, daemonSet.Name))
+	case !allReady:
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionProgressing, v1.ConditionTrue, ReasonReplicasNotReady, notReadyMessage)
+	default:
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionProgressing, v1.ConditionFalse, ReasonReconcileComplete, "")
+	}
+	if allReady && !progressing {
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionReady, v1.ConditionTrue, SuccessSynced, MessageResourceSynced)
+	} else {
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionReady, v1.ConditionFalse, ReasonReplicasNotReady, notReadyMessage)
+	}
+
+	if err := c.cleanupOrphans(ctx, key, sqliteInstance); err != nil {
+		return reconcileResult{}, err
+	}
+
+	status.ObservedGeneration = sqliteInstance.Generation
+	status.ObservedSpecHash = specHash(sqliteInstance)
+	if err := c.updateSQLiteInstanceStatus(ctx, sqliteInstance, status); err != nil {
+		return reconcileResult{}, err
+	}
+	c.recorder.Event(sqliteInstance, corev1.EventTypeNormal, SuccessSynced, MessageResourceSynced)
+
+	// DaemonSets are not watched, so the rollout is checked again after a
+	// fixed delay.
+	if progressing || !allReady {
+		return reconcileResult{RequeueAfter: pendingRequeueAfter}, nil
+	}
+	return reconcileResult{}, nil
+}
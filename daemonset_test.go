@@ -0,0 +1,261 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// newDaemonSetSQLiteInstance returns a SQLiteInstance in the DaemonSet
+// deployment mode.
+func newDaemonSetSQLiteInstance(name string) *kubelitedbv1.SQLiteInstance {
+	instance := newSQLiteInstance(name)
+	instance.Spec.DeploymentMode = kubelitedbv1.DeploymentModeDaemonSet
+	return instance
+}
+
+// getDaemonSet returns the DaemonSet of the SQLiteInstance as stored in the
+// fake clientset.
+func (f *fixture) getDaemonSet(ctx context.Context, instance *kubelitedbv1.SQLiteInstance) *appsv1.DaemonSet {
+	f.t.Helper()
+	got, err := f.kubeclient.AppsV1().DaemonSets(instance.Namespace).Get(ctx, daemonSetName(instance), v1.GetOptions{})
+	if err != nil {
+		f.t.Fatalf("error getting DaemonSet of %s: %v", instance.Name, err)
+	}
+	return got
+}
+
+func TestNewDaemonSet(t *testing.T) {
+	user, group := int64(1000), int64(2000)
+	tests := []struct {
+		name               string
+		podSecurityContext *corev1.PodSecurityContext
+		hostPathBase       string
+		hostPath           string
+		owner              string
+	}{
+		{
+			name:         "default",
+			hostPathBase: defaultHostPathBase,
+			hostPath:     "/var/lib/kubelitedb/default/test",
+			owner:        "65532:65532",
+		},
+		{
+			name:               "custom user and base path",
+			podSecurityContext: &corev1.PodSecurityContext{RunAsUser: &user, RunAsGroup: &group},
+			hostPathBase:       "/mnt/data/",
+			hostPath:           "/mnt/data/default/test",
+			owner:              "1000:2000",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newDaemonSetSQLiteInstance("test")
+			instance.Spec.PodSecurityContext = tt.podSecurityContext
+
+			daemonSet := newDaemonSet(instance, tt.hostPathBase)
+
+			if !v1.IsControlledBy(daemonSet, instance) {
+				t.Errorf("expected the DaemonSet to be controlled by the SQLiteInstance")
+			}
+			podSpec := daemonSet.Spec.Template.Spec
+			i := slices.IndexFunc(podSpec.Volumes, func(volume corev1.Volume) bool { return volume.Name == dataVolumeName })
+			if i < 0 || podSpec.Volumes[i].HostPath == nil {
+				t.Fatalf("expected a hostPath data volume, got %v", podSpec.Volumes)
+			}
+			hostPath := podSpec.Volumes[i].HostPath
+			if hostPath.Path != tt.hostPath || hostPath.Type == nil || *hostPath.Type != corev1.HostPathDirectoryOrCreate {
+				t.Errorf("expected the directory %s to be created on the node, got %v", tt.hostPath, hostPath)
+			}
+
+			// The directory is handed to the user of the pods before the
+			// database is opened
+			if len(podSpec.InitContainers) == 0 || podSpec.InitContainers[0].Name != hostPathPermissionsContainerName {
+				t.Fatalf("expected the %s init container to run first, got %v", hostPathPermissionsContainerName, podSpec.InitContainers)
+			}
+			permissions := podSpec.InitContainers[0]
+			if !slices.Contains(permissions.Env, corev1.EnvVar{Name: "OWNER", Value: tt.owner}) {
+				t.Errorf("expected the directory to be owned by %s, got %v", tt.owner, permissions.Env)
+			}
+			securityContext := permissions.SecurityContext
+			if securityContext == nil || securityContext.RunAsUser == nil || *securityContext.RunAsUser != 0 ||
+				securityContext.Capabilities == nil || !slices.Equal(securityContext.Capabilities.Add, []corev1.Capability{"CHOWN"}) {
+				t.Errorf("expected the init container to run as root with only CHOWN, got %v", securityContext)
+			}
+			for _, container := range podSpec.Containers {
+				if container.SecurityContext != nil && container.SecurityContext.RunAsUser != nil && *container.SecurityContext.RunAsUser == 0 {
+					t.Errorf("expected container %s not to run as root", container.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestDaemonSetSync(t *testing.T) {
+	tests := []struct {
+		name      string
+		scheduled int32
+		ready     int32
+		phase     kubelitedbv1.SQLiteInstancePhase
+		readyCond v1.ConditionStatus
+		requeue   bool
+	}{
+		{name: "all ready", scheduled: 3, ready: 3, phase: kubelitedbv1.PhaseRunning, readyCond: v1.ConditionTrue},
+		{name: "rolling out", scheduled: 3, ready: 1, readyCond: v1.ConditionFalse, requeue: true},
+		{name: "no nodes", readyCond: v1.ConditionFalse, requeue: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := newDaemonSetSQLiteInstance("test")
+			f.addInstance(instance)
+			c, _, _ := f.newController(ctx)
+
+			// The first sync creates the DaemonSet and waits for its rollout
+			result := f.run(ctx, c, getKey(instance, t))
+			if result.RequeueAfter != pendingRequeueAfter {
+				t.Errorf("expected a requeue after %s while the DaemonSet is created, got %+v", pendingRequeueAfter, result)
+			}
+			daemonSet := f.getDaemonSet(ctx, instance)
+			if !v1.IsControlledBy(daemonSet, instance) {
+				t.Errorf("expected the DaemonSet to be controlled by the SQLiteInstance")
+			}
+			for _, resource := range []string{"statefulsets", "persistentvolumeclaims", "services"} {
+				if actions := writes(f.kubeclient.Actions(), resource); len(actions) != 0 {
+					t.Errorf("expected no %s writes in the DaemonSet mode, got %v", resource, actions)
+				}
+			}
+
+			daemonSet.Status.DesiredNumberScheduled = tt.scheduled
+			daemonSet.Status.NumberReady = tt.ready
+			if _, err := f.kubeclient.AppsV1().DaemonSets(instance.Namespace).UpdateStatus(ctx, daemonSet, v1.UpdateOptions{}); err != nil {
+				t.Fatalf("error updating the DaemonSet status: %v", err)
+			}
+			f.refreshCaches(ctx)
+			f.kubeclient.ClearActions()
+
+			result = f.run(ctx, c, getKey(instance, t))
+
+			if actions := writes(f.kubeclient.Actions(), "daemonsets"); len(actions) != 0 {
+				t.Errorf("expected the DaemonSet to be left as is, got %v", actions)
+			}
+			if requeue := result.RequeueAfter == pendingRequeueAfter; requeue != tt.requeue {
+				t.Errorf("expected requeue %t, got %+v", tt.requeue, result)
+			}
+			got := f.getInstance(ctx, instance)
+			if got.Status.Replicas != tt.scheduled || got.Status.ReadyReplicas != tt.ready {
+				t.Errorf("expected %d of %d replicas ready, got %d of %d", tt.ready, tt.scheduled, got.Status.ReadyReplicas, got.Status.Replicas)
+			}
+			if tt.phase != "" && got.Status.Phase != tt.phase {
+				t.Errorf("expected phase %s, got %s", tt.phase, got.Status.Phase)
+			}
+			if condition := meta.FindStatusCondition(got.Status.Conditions, kubelitedbv1.ConditionReady); condition == nil || condition.Status != tt.readyCond {
+				t.Errorf("expected Ready %s, got %v", tt.readyCond, condition)
+			}
+			condition := meta.FindStatusCondition(got.Status.Conditions, kubelitedbv1.ConditionStorageProvisioned)
+			if condition == nil || condition.Status != v1.ConditionTrue || condition.Reason != ReasonHostPathConfigured {
+				t.Errorf("expected StorageProvisioned True with reason %s, got %v", ReasonHostPathConfigured, condition)
+			}
+		})
+	}
+}
+
+func TestDaemonSetTemplateChangeUpdatesDaemonSet(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newDaemonSetSQLiteInstance("test")
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+	f.run(ctx, c, getKey(instance, t))
+	oldHash := f.getDaemonSet(ctx, instance).Spec.Template.Annotations[templateHashAnnotation]
+
+	updated := f.getInstance(ctx, instance)
+	updated.Spec.Image = "example.com/sqlite:2"
+	updated.Generation++
+	if _, err := f.client.KubelitedbV1().SQLiteInstances(instance.Namespace).Update(ctx, updated, v1.UpdateOptions{}); err != nil {
+		t.Fatalf("error updating the SQLiteInstance: %v", err)
+	}
+	f.refreshCaches(ctx)
+	f.kubeclient.ClearActions()
+
+	f.run(ctx, c, getKey(instance, t))
+
+	if actions := writes(f.kubeclient.Actions(), "daemonsets"); len(actions) != 1 || actions[0].GetVerb() != "update" {
+		t.Fatalf("expected the DaemonSet to be updated, got %v", actions)
+	}
+	daemonSet := f.getDaemonSet(ctx, instance)
+	if daemonSet.Spec.Template.Annotations[templateHashAnnotation] == oldHash {
+		t.Errorf("expected the template hash to change")
+	}
+	if got := container(t, daemonSet.Spec.Template.Spec.Containers, "sqlite").Image; got != updated.Spec.Image {
+		t.Errorf("expected the image %s, got %s", updated.Spec.Image, got)
+	}
+}
+
+func TestDaemonSetNotControlled(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newDaemonSetSQLiteInstance("test")
+	daemonSet := newDaemonSet(instance, defaultHostPathBase)
+	daemonSet.OwnerReferences = nil
+	f.addInstance(instance)
+	f.addKubeObject(daemonSet)
+	c, _, _ := f.newController(ctx)
+
+	if _, err := c.syncHandler(ctx, getKey(instance, t)); err == nil {
+		t.Fatalf("expected an error syncing a SQLiteInstance whose DaemonSet it doesn't own")
+	}
+
+	expectEvent(t, f.recorder, corev1.EventTypeWarning, ErrResourceExists)
+	if actions := writes(f.kubeclient.Actions(), "daemonsets"); len(actions) != 0 {
+		t.Errorf("expected the DaemonSet to be left as is, got %v", actions)
+	}
+}
+
+func TestBackupDeploymentModeUnsupported(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newBackupFixture(t)
+	instance := newDaemonSetSQLiteInstance("test")
+	backup := newSQLiteBackup("nightly", instance.Name)
+	f.addInstance(instance)
+	f.addBackup(backup)
+	c := f.newController(ctx)
+
+	got := f.run(ctx, c, backup)
+
+	if got.Status.Phase != BackupPhaseFailed {
+		t.Errorf("expected phase %s, got %q", BackupPhaseFailed, got.Status.Phase)
+	}
+	condition := meta.FindStatusCondition(got.Status.Conditions, kubelitedbv1.ConditionComplete)
+	if condition == nil || condition.Status != v1.ConditionFalse || condition.Reason != ReasonDeploymentModeUnsupported {
+		t.Errorf("expected condition False with reason %s, got %v", ReasonDeploymentModeUnsupported, condition)
+	}
+	if actions := writes(f.kubeclient.Actions(), "jobs"); len(actions) != 0 {
+		t.Errorf("expected no Job writes, got %v", actions)
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// debouncer holds back the reconcile of a SQLiteInstance until it was not
+// edited for a window, so a burst of edits is reconciled once after the last
+// of them. The workqueue only dedupes keys that are queued at the same time.
+type debouncer struct {
+	window time.Duration
+
+	mu sync.Mutex
+	// settleAt records, by key, when the window of the last edit closes
+	settleAt map[string]time.Time
+}
+
+// newDebouncer returns a debouncer with the given window. Edits are not held
+// back when it is 0.
+func newDebouncer(window time.Duration) *debouncer {
+	return &debouncer{
+		window:   window,
+		settleAt: map[string]time.Time{},
+	}
+}
+
+// edited records an edit of the key at now, and returns whether the key has
+// to be queued. A key still held back is already queued, and is queued again
+// by wait until its window closes.
+func (d *debouncer) edited(key string, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, pending := d.settleAt[key]
+	d.settleAt[key] = now.Add(d.window)
+	return !pending
+}
+
+// wait returns how much longer the reconcile of the key is held back for.
+// The key is forgotten once its window closed, so the next edit starts a new
+// one.
+func (d *debouncer) wait(key string, now time.Time) time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	settleAt, pending := d.settleAt[key]
+	if !pending {
+		return 0
+	}
+	if remaining := settleAt.Sub(now); remaining > 0 {
+		return remaining
+	}
+	delete(d.settleAt, key)
+	return 0
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	core "k8s.io/client-go/testing"
+	"k8s.io/klog/v2/ktesting"
+
+	"github.com/fortytwoapps/kubelitedb/pkg/metrics"
+)
+
+func TestDebouncer(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(offset time.Duration) time.Time { return start.Add(offset) }
+	type step struct {
+		// edit records an edit at the offset, otherwise wait is called
+		edit   bool
+		offset time.Duration
+		want   bool
+		// wantWait is the wait returned at the offset
+		wantWait time.Duration
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "single edit",
+			steps: []step{
+				{edit: true, want: true},
+				{offset: 4 * time.Second, wantWait: 6 * time.Second},
+				{offset: 10 * time.Second},
+			},
+		},
+		{
+			name: "burst of edits is queued once",
+			steps: []step{
+				{edit: true, want: true},
+				{edit: true, offset: time.Second},
+				{edit: true, offset: 3 * time.Second},
+				{offset: 10 * time.Second, wantWait: 3 * time.Second},
+				{offset: 13 * time.Second},
+			},
+		},
+		{
+			name: "edit after the window closed starts a new one",
+			steps: []step{
+				{edit: true, want: true},
+				{offset: 11 * time.Second},
+				{edit: true, offset: 12 * time.Second, want: true},
+				{offset: 20 * time.Second, wantWait: 2 * time.Second},
+			},
+		},
+		{
+			name: "key forgotten once the window closed",
+			steps: []step{
+				{edit: true, want: true},
+				{offset: 10 * time.Second},
+				{offset: 10 * time.Second},
+				{edit: true, offset: 10 * time.Second, want: true},
+			},
+		},
+		{
+			name: "key never edited",
+			steps: []step{
+				{},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newDebouncer(10 * time.Second)
+			for i, s := range tt.steps {
+				if s.edit {
+					if got := d.edited("default/test", at(s.offset)); got != s.want {
+						t.Errorf("step %d: expected edited to return %t, got %t", i, s.want, got)
+					}
+					continue
+				}
+				if got := d.wait("default/test", at(s.offset)); got != s.wantWait {
+					t.Errorf("step %d: expected a wait of %s, got %s", i, s.wantWait, got)
+				}
+			}
+		})
+	}
+}
+
+func TestDebounceKeysAreIndependent(t *testing.T) {
+	now := time.Now()
+	d := newDebouncer(10 * time.Second)
+	if !d.edited("default/a", now) {
+		t.Fatalf("expected the first edit of a to be queued")
+	}
+	if !d.edited("default/b", now.Add(5*time.Second)) {
+		t.Errorf("expected the first edit of b to be queued while a is held back")
+	}
+	if got := d.wait("default/a", now.Add(10*time.Second)); got != 0 {
+		t.Errorf("expected a to settle after its own window, got a wait of %s", got)
+	}
+	if got := d.wait("default/b", now.Add(10*time.Second)); got != 5*time.Second {
+		t.Errorf("expected b to be held back for 5s, got %s", got)
+	}
+}
+
+func TestDebounceCoalescesEdits(t *testing.T) {
+	const window = 200 * time.Millisecond
+	tests := []struct {
+		name   string
+		window time.Duration
+		// wantDelayed is whether the edits are queued after the window
+		wantDelayed bool
+	}{
+		{name: "debounced", window: window, wantDelayed: true},
+		{name: "no window"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			f := newFixture(t)
+			f.debounceWindow = tt.window
+			instance := newSQLiteInstance("test")
+			f.addInstance(instance)
+			c, _, _ := f.newController(ctx)
+			queue := newEnqueueRecorder(c.workqueue)
+			c.workqueue = queue
+			defer queue.ShutDown()
+
+			var mu sync.Mutex
+			var synced []time.Time
+			f.kubeclient.PrependReactor("create", "statefulsets", func(core.Action) (bool, runtime.Object, error) {
+				mu.Lock()
+				synced = append(synced, time.Now())
+				mu.Unlock()
+				return false, nil, nil
+			})
+
+			reconciles := testutil.ToFloat64(metrics.ReconcileTotal.WithLabelValues(metrics.ResultSuccess))
+			var lastEdit time.Time
+			for i := 0; i < 5; i++ {
+				lastEdit = time.Now()
+				c.enqueueSQLiteInstanceDebounced(instance)
+				time.Sleep(10 * time.Millisecond)
+			}
+			added, delayed := queue.recorded()
+			if tt.wantDelayed {
+				if len(added) != 0 || len(delayed) != 1 || delayed[getKey(instance, t)] != window {
+					t.Fatalf("expected the edits to be queued once after %s, got %v and delayed %v", window, added, delayed)
+				}
+			} else if len(added) != 5 || len(delayed) != 0 {
+				t.Fatalf("expected every edit to be queued immediately, got %v and delayed %v", added, delayed)
+			}
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for c.processNextWorkItem(ctx) {
+				}
+			}()
+			err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				return len(synced) > 0, nil
+			})
+			if err != nil {
+				t.Fatalf("timed out waiting for the reconcile")
+			}
+			// Give any further reconcile the time to run
+			time.Sleep(2 * window)
+			queue.ShutDown()
+			<-done
+
+			mu.Lock()
+			defer mu.Unlock()
+			if got := testutil.ToFloat64(metrics.ReconcileTotal.WithLabelValues(metrics.ResultSuccess)) - reconciles; got != 1 {
+				t.Fatalf("expected the edits to be reconciled once, got %v reconciles", got)
+			}
+			if tt.wantDelayed && synced[0].Before(lastEdit.Add(window)) {
+				t.Errorf("expected the reconcile to run %s after the last edit, ran after %s", window, synced[0].Sub(lastEdit))
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"testing"
+
+	"k8s.io/klog/v2/ktesting"
+)
+
+// setDefaultImage sets --default-image for the duration of the test.
+func setDefaultImage(t *testing.T, image string) {
+	t.Helper()
+	previous := defaultImage
+	t.Cleanup(func() { defaultImage = previous })
+	if err := flag.CommandLine.Set("default-image", image); err != nil {
+		t.Fatalf("error setting --default-image: %v", err)
+	}
+}
+
+func TestDefaultImageFromEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		env  string
+		want string
+	}{
+		{name: "unset", want: "ghcr.io/fortytwoapps/kubelitedb:latest"},
+		{name: "set", env: "mirror.example.com/kubelitedb:latest", want: "mirror.example.com/kubelitedb:latest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(defaultImageEnv, tt.env)
+			if got := defaultImageFromEnv("ghcr.io/fortytwoapps/kubelitedb:latest"); got != tt.want {
+				t.Errorf("expected image %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDefaultImageFlag(t *testing.T) {
+	const mirror = "mirror.example.com/kubelitedb:latest"
+	tests := []struct {
+		name         string
+		image        string
+		architecture string
+		images       map[string]string
+		want         string
+	}{
+		{name: "default image", want: mirror},
+		{name: "spec image", image: "example.com/sqlite:3.45", want: "example.com/sqlite:3.45"},
+		{
+			name:         "architecture image",
+			architecture: "arm64",
+			images:       map[string]string{"arm64": "example.com/sqlite:3-arm64"},
+			want:         "example.com/sqlite:3-arm64",
+		},
+		{
+			name:         "architecture without image",
+			architecture: "arm64",
+			images:       map[string]string{"amd64": "example.com/sqlite:3-amd64"},
+			want:         mirror,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			setDefaultImage(t, mirror)
+			f := newFixture(t)
+			instance := newSQLiteInstance("test")
+			instance.Spec.Image = tt.image
+			instance.Spec.Architecture = tt.architecture
+			instance.Spec.ArchitectureImages = tt.images
+			f.addInstance(instance)
+			c, _, _ := f.newController(ctx)
+
+			f.run(ctx, c, getKey(instance, t))
+
+			sts := f.getStatefulSet(ctx, instance)
+			if got := container(t, sts.Spec.Template.Spec.Containers, "sqlite").Image; got != tt.want {
+				t.Errorf("expected image %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDefaultImageChangeRollsPods(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	old := newStatefulSet(instance)
+	f.addKubeObject(old)
+	f.addInstance(instance)
+	setDefaultImage(t, "mirror.example.com/kubelitedb:latest")
+	c, _, _ := f.newController(ctx)
+
+	f.run(ctx, c, getKey(instance, t))
+
+	sts := f.getStatefulSet(ctx, instance)
+	if got := container(t, sts.Spec.Template.Spec.Containers, "sqlite").Image; got != defaultImage {
+		t.Errorf("expected image %q, got %q", defaultImage, got)
+	}
+	if sts.Spec.Template.Annotations[templateHashAnnotation] == old.Spec.Template.Annotations[templateHashAnnotation] {
+		t.Errorf("expected the template hash to change with the default image")
+	}
+}
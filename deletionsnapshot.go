@@ -0,0 +1,122 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+	"github.com/fortytwoapps/kubelitedb/pkg/validation"
+)
+
+const (
+	// ReasonSnapshotRunning is used as the condition reason while the
+	// deletion waits for the snapshot of the database
+	ReasonSnapshotRunning = "SnapshotRunning"
+	// ReasonSnapshotFailed is used as the condition reason when the snapshot
+	// of the database failed, blocking the deletion
+	ReasonSnapshotFailed = "SnapshotFailed"
+	// ReasonSnapshotCompleted is used as the event reason when the snapshot
+	// of the database completed
+	ReasonSnapshotCompleted = "SnapshotCompleted"
+
+	// MessageSnapshotRunning is the message used while the deletion waits for
+	// the snapshot of the database
+	MessageSnapshotRunning = "Waiting for SQLiteBackup %q to back up the database before deleting it"
+	// MessageSnapshotFailed is the message used when the snapshot of the
+	// database failed
+	MessageSnapshotFailed = "SQLiteBackup %q failed, delete it to retry or remove the " + kubelitedbv1.SnapshotOnDeleteAnnotation + " annotation to delete the database without a snapshot"
+	// MessageSnapshotNoDestination is the message used when the snapshot
+	// can't be taken as there is no backup destination
+	MessageSnapshotNoDestination = "No spec.backupDestination to upload the snapshot to, set one or remove the " + kubelitedbv1.SnapshotOnDeleteAnnotation + " annotation to delete the database without a snapshot"
+	// MessageSnapshotCompleted is the message used when the snapshot of the
+	// database completed
+	MessageSnapshotCompleted = "Backed up the database to %s before deleting it"
+)
+
+// deletionSnapshotName returns the name of the SQLiteBackup taken before the
+// SQLiteInstance is deleted.
+func deletionSnapshotName(instance *kubelitedbv1.SQLiteInstance) string {
+	return fmt.Sprintf("%s-deletion-snapshot", instance.Name)
+}
+
+// newDeletionSnapshot creates the SQLiteBackup of the database taken before
+// the SQLiteInstance is deleted. It isn't owned by the SQLiteInstance, so the
+// record of the backup outlives it.
+func newDeletionSnapshot(instance *kubelitedbv1.SQLiteInstance) *kubelitedbv1.SQLiteBackup {
+	destination := instance.Spec.BackupDestination
+	return &kubelitedbv1.SQLiteBackup{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      deletionSnapshotName(instance),
+			Namespace: instance.Namespace,
+			Labels:    childLabels(instance, labelsForInstance(instance, componentBackup)),
+		},
+		Spec: kubelitedbv1.SQLiteBackupSpec{
+			InstanceName: instance.Name,
+			Bucket:       destination.Bucket,
+			Path:         destination.Path,
+			Endpoint:     destination.Endpoint,
+			SecretRef:    destination.SecretRef,
+		},
+	}
+}
+
+// takeDeletionSnapshot backs up the database of a SQLiteInstance being
+// deleted with the SnapshotOnDeleteAnnotation set, and returns whether the
+// deletion can go ahead. While the backup is running or after it failed, the
+// DeletionBlocked condition explains what the deletion waits for.
+func (c *Controller) takeDeletionSnapshot(ctx context.Context, sqliteInstance *kubelitedbv1.SQLiteInstance) (bool, error) {
+	if !validation.SnapshotsOnDelete(sqliteInstance) {
+		return true, nil
+	}
+
+	status := sqliteInstance.Status.DeepCopy()
+	if sqliteInstance.Spec.BackupDestination == nil {
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionDeletionBlocked, v1.ConditionTrue, ReasonSnapshotFailed, MessageSnapshotNoDestination)
+		c.recorder.Event(sqliteInstance, corev1.EventTypeWarning, ReasonSnapshotFailed, MessageSnapshotNoDestination)
+		return false, c.updateSQLiteInstanceStatus(ctx, sqliteInstance, status)
+	}
+
+	backups := c.kubelitedbclientset.KubelitedbV1().SQLiteBackups(sqliteInstance.Namespace)
+	name := deletionSnapshotName(sqliteInstance)
+	backup, err := backups.Get(ctx, name, v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		backup, err = backups.Create(ctx, newDeletionSnapshot(sqliteInstance), c.createOptions(ctx, sqliteInstance, "SQLiteBackup", name))
+		logWrite(ctx, "create", "SQLiteBackup", name, err)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	switch backup.Status.Phase {
+	case BackupPhaseCompleted:
+		c.recorder.Eventf(sqliteInstance, corev1.EventTypeNormal, ReasonSnapshotCompleted, MessageSnapshotCompleted, backup.Status.ArtifactPath)
+		return true, nil
+	case BackupPhaseFailed:
+		msg := fmt.Sprintf(MessageSnapshotFailed, name)
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionDeletionBlocked, v1.ConditionTrue, ReasonSnapshotFailed, msg)
+		c.recorder.Event(sqliteInstance, corev1.EventTypeWarning, ReasonSnapshotFailed, msg)
+	default:
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionDeletionBlocked, v1.ConditionTrue, ReasonSnapshotRunning, fmt.Sprintf(MessageSnapshotRunning, name))
+	}
+	return false, c.updateSQLiteInstanceStatus(ctx, sqliteInstance, status)
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"slices"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+func TestDeletionSnapshot(t *testing.T) {
+	destination := &kubelitedbv1.BackupDestination{
+		Bucket:    "backups",
+		SecretRef: corev1.LocalObjectReference{Name: "credentials"},
+	}
+	tests := []struct {
+		name        string
+		annotation  string
+		destination *kubelitedbv1.BackupDestination
+		// The phase of the existing snapshot, when there is one
+		snapshotPhase string
+		// Whether the finalizer is removed
+		finalized bool
+		// The reason of the DeletionBlocked condition, when blocked by the
+		// snapshot
+		reason       string
+		wantSnapshot bool
+		eventType    string
+		event        string
+	}{
+		{name: "opted out", destination: destination, finalized: true},
+		{name: "annotation not true", annotation: "false", destination: destination, finalized: true},
+		{name: "snapshot started", annotation: "true", destination: destination, reason: ReasonSnapshotRunning, wantSnapshot: true},
+		{name: "snapshot running", annotation: "true", destination: destination, snapshotPhase: BackupPhaseRunning, reason: ReasonSnapshotRunning, wantSnapshot: true},
+		{
+			name:          "snapshot completed",
+			annotation:    "true",
+			destination:   destination,
+			snapshotPhase: BackupPhaseCompleted,
+			finalized:     true,
+			wantSnapshot:  true,
+			eventType:     corev1.EventTypeNormal,
+			event:         ReasonSnapshotCompleted,
+		},
+		{
+			name:          "snapshot failed",
+			annotation:    "true",
+			destination:   destination,
+			snapshotPhase: BackupPhaseFailed,
+			reason:        ReasonSnapshotFailed,
+			wantSnapshot:  true,
+			eventType:     corev1.EventTypeWarning,
+			event:         ReasonSnapshotFailed,
+		},
+		{name: "snapshot failed and annotation removed", destination: destination, snapshotPhase: BackupPhaseFailed, finalized: true, wantSnapshot: true},
+		{name: "no destination", annotation: "true", reason: ReasonSnapshotFailed, eventType: corev1.EventTypeWarning, event: ReasonSnapshotFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := newSQLiteInstance("test")
+			now := v1.Now()
+			instance.DeletionTimestamp = &now
+			if tt.annotation != "" {
+				instance.Annotations = map[string]string{kubelitedbv1.SnapshotOnDeleteAnnotation: tt.annotation}
+			}
+			instance.Spec.BackupDestination = tt.destination
+			f.addInstance(instance)
+			if tt.snapshotPhase != "" {
+				snapshot := newDeletionSnapshot(instance)
+				snapshot.Status.Phase = tt.snapshotPhase
+				f.objects = append(f.objects, snapshot)
+			}
+			if tt.destination != nil {
+				// The purge of object storage already went through, so only
+				// the snapshot can hold up the deletion.
+				job := newCleanupJob(instance)
+				job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+				f.addKubeObject(job)
+			}
+			c, _, _ := f.newController(ctx)
+
+			result := f.run(ctx, c, getKey(instance, t))
+
+			got := f.getInstance(ctx, instance)
+			if finalized := !slices.Contains(got.Finalizers, cleanupFinalizer); finalized != tt.finalized {
+				t.Errorf("expected finalizer removed %t, got %t", tt.finalized, finalized)
+			}
+			if !tt.finalized && result.RequeueAfter != pendingRequeueAfter {
+				t.Errorf("expected a requeue after %s, got %+v", pendingRequeueAfter, result)
+			}
+			blocked := meta.FindStatusCondition(got.Status.Conditions, kubelitedbv1.ConditionDeletionBlocked)
+			if tt.reason != "" && (blocked == nil || blocked.Status != v1.ConditionTrue || blocked.Reason != tt.reason) {
+				t.Errorf("expected condition %s with reason %s, got %+v", kubelitedbv1.ConditionDeletionBlocked, tt.reason, blocked)
+			}
+			if tt.event != "" {
+				expectEvent(t, f.recorder, tt.eventType, tt.event)
+			}
+
+			snapshot, err := f.client.KubelitedbV1().SQLiteBackups(instance.Namespace).Get(ctx, deletionSnapshotName(instance), v1.GetOptions{})
+			if !tt.wantSnapshot {
+				if !errors.IsNotFound(err) {
+					t.Errorf("expected no snapshot, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected a snapshot: %v", err)
+			}
+			if snapshot.Spec.InstanceName != instance.Name || snapshot.Spec.Bucket != destination.Bucket {
+				t.Errorf("expected a snapshot of %s to %s, got %+v", instance.Name, destination.Bucket, snapshot.Spec)
+			}
+			if len(snapshot.OwnerReferences) != 0 {
+				t.Errorf("expected the snapshot to outlive the SQLiteInstance, got owners %v", snapshot.OwnerReferences)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// podDisruptionBudgetName returns the name of the PodDisruptionBudget
+// protecting the pods of the given SQLiteInstance.
+func podDisruptionBudgetName(instance *kubelitedbv1.SQLiteInstance) string {
+	return fmt.Sprintf("%s-sqlite", instance.Name)
+}
+
+// minAvailable returns how many pods of the SQLiteInstance have to stay
+// available during voluntary disruptions, so a single pod can be evicted at a
+// time. Instances with a single replica get no PodDisruptionBudget, as it
+// would block node drains.
+func minAvailable(instance *kubelitedbv1.SQLiteInstance) int32 {
+	if instance.Spec.Replicas <= 1 {
+		return 0
+	}
+	return int32(instance.Spec.Replicas - 1)
+}
+
+// newPodDisruptionBudget creates a new PodDisruptionBudget for a SQLiteInstance
+// resource, selecting the pods of its StatefulSet.
+func newPodDisruptionBudget(instance *kubelitedbv1.SQLiteInstance) *policyv1.PodDisruptionBudget {
+	available := intstr.FromInt32(minAvailable(instance))
+	return &policyv1.PodDisruptionBudget{
+		ObjectMeta: v1.ObjectMeta{
+			Name:        podDisruptionBudgetName(instance),
+			Namespace:   instance.Namespace,
+			Labels:      childLabels(instance, labelsForInstance(instance, componentDatabase)),
+			Annotations: childAnnotations(instance),
+			OwnerReferences: []v1.OwnerReference{
+				newOwnerReference(instance),
+			},
+		},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			MinAvailable: &available,
+			Selector: &v1.LabelSelector{
+				MatchLabels: podLabels(instance),
+			},
+		},
+	}
+}
+
+// syncPodDisruptionBudget creates or updates the PodDisruptionBudget of the
+// SQLiteInstance, and deletes it once the instance is scaled down to a single
+// replica.
+func (c *Controller) syncPodDisruptionBudget(ctx context.Context, sqliteInstance *kubelitedbv1.SQLiteInstance) error {
+	pdbs := c.kubeclientset.PolicyV1().PodDisruptionBudgets(sqliteInstance.Namespace)
+	wanted := minAvailable(sqliteInstance) > 0
+
+	pdb, err := pdbs.Get(ctx, podDisruptionBudgetName(sqliteInstance), v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		if !wanted {
+			return nil
+		}
+		_, err = pdbs.Create(ctx, newPodDisruptionBudget(sqliteInstance), c.createOptions(ctx, sqliteInstance, "PodDisruptionBudget", podDisruptionBudgetName(sqliteInstance)))
+		logWrite(ctx, "create", "PodDisruptionBudget", podDisruptionBudgetName(sqliteInstance), err)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if !v1.IsControlledBy(pdb, sqliteInstance) {
+		msg := fmt.Sprintf(MessageResourceExists, pdb.Name)
+		c.recorder.Event(sqliteInstance, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return fmt.Errorf("%s", msg)
+	}
+
+	if !wanted {
+		err = pdbs.Delete(ctx, pdb.Name, c.deleteOptions(ctx, sqliteInstance, "PodDisruptionBudget", pdb.Name))
+		logWrite(ctx, "delete", "PodDisruptionBudget", pdb.Name, err)
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	desired := newPodDisruptionBudget(sqliteInstance)
+	if pdb.Spec.MinAvailable == nil || *pdb.Spec.MinAvailable != *desired.Spec.MinAvailable ||
+		metadataOutOfDate(pdb, desired) {
+		pdbCopy := pdb.DeepCopy()
+		mergeMetadata(pdbCopy, desired)
+		pdbCopy.Spec.MinAvailable = desired.Spec.MinAvailable
+		_, err = pdbs.Update(ctx, pdbCopy, c.updateOptions(ctx, sqliteInstance, "PodDisruptionBudget", pdb, pdbCopy))
+		logWrite(ctx, "update", "PodDisruptionBudget", pdb.Name, err)
+	}
+	return err
+}
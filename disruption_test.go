@@ -0,0 +1,90 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/ktesting"
+)
+
+func TestSyncPodDisruptionBudget(t *testing.T) {
+	tests := []struct {
+		name     string
+		replicas int
+		existing bool
+		want     int32
+		verbs    []string
+	}{
+		{name: "no replicas", replicas: 0},
+		{name: "single replica", replicas: 1},
+		{name: "single replica deletes", replicas: 1, existing: true, verbs: []string{"delete"}},
+		{name: "two replicas", replicas: 2, want: 1, verbs: []string{"create"}},
+		{name: "three replicas", replicas: 3, want: 2, verbs: []string{"create"}},
+		{name: "scaled up", replicas: 3, existing: true, want: 2, verbs: []string{"update"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := newSQLiteInstance("test")
+			instance.Spec.Replicas = tt.replicas
+			if tt.existing {
+				existing := newSQLiteInstance("test")
+				existing.Spec.Replicas = 2
+				f.addKubeObject(newPodDisruptionBudget(existing))
+			}
+			c, _, _ := f.newController(ctx)
+
+			if err := c.syncPodDisruptionBudget(ctx, instance); err != nil {
+				t.Fatalf("error syncing the PodDisruptionBudget: %v", err)
+			}
+
+			var verbs []string
+			for _, action := range writes(f.kubeclient.Actions(), "poddisruptionbudgets") {
+				verbs = append(verbs, action.GetVerb())
+			}
+			if !slices.Equal(verbs, tt.verbs) {
+				t.Errorf("expected PodDisruptionBudget writes %v, got %v", tt.verbs, verbs)
+			}
+
+			pdb, err := f.kubeclient.PolicyV1().PodDisruptionBudgets(instance.Namespace).Get(ctx, podDisruptionBudgetName(instance), v1.GetOptions{})
+			if tt.want == 0 {
+				if !errors.IsNotFound(err) {
+					t.Errorf("expected no PodDisruptionBudget, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error getting the PodDisruptionBudget: %v", err)
+			}
+			if got := pdb.Spec.MinAvailable.IntValue(); got != int(tt.want) {
+				t.Errorf("expected minAvailable %d, got %d", tt.want, got)
+			}
+			if want := (&v1.LabelSelector{MatchLabels: podLabels(instance)}); !equality.Semantic.DeepEqual(pdb.Spec.Selector, want) {
+				t.Errorf("expected selector %v, got %v", want, pdb.Spec.Selector)
+			}
+			if !v1.IsControlledBy(pdb, instance) {
+				t.Errorf("expected the PodDisruptionBudget to be controlled by the SQLiteInstance")
+			}
+		})
+	}
+}
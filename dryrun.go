@@ -0,0 +1,135 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// ReasonDryRun is used as part of the Event 'reason' for the changes the
+// controller would make in dry run mode
+const ReasonDryRun = "DryRun"
+
+// owner is the object the writes of a controller are made for, which the
+// Events reporting them in dry run mode are recorded on
+type owner interface {
+	runtime.Object
+	v1.Object
+}
+
+// dryRunWriter builds the options of the API writes of a controller. In dry
+// run mode the API server validates and admits the writes without persisting
+// them, and every write is reported on the object it is made for.
+type dryRunWriter struct {
+	dryRun   bool
+	recorder record.EventRecorder
+}
+
+// dryRunOptions returns the dry run value of the options of API writes.
+func (w dryRunWriter) dryRunOptions() []string {
+	if !w.dryRun {
+		return nil
+	}
+	return []string{v1.DryRunAll}
+}
+
+// createOptions returns the options for creating an object for the owner,
+// reporting the create in dry run mode.
+func (w dryRunWriter) createOptions(ctx context.Context, owner owner, kind, name string) v1.CreateOptions {
+	if w.dryRun {
+		klog.FromContext(ctx).Info("Dry run: would create object", "owner", klog.KObj(owner), "kind", kind, "name", name)
+		w.recorder.Eventf(owner, corev1.EventTypeNormal, ReasonDryRun, "Would create %s %q", kind, name)
+	}
+	return v1.CreateOptions{DryRun: w.dryRunOptions()}
+}
+
+// updateOptions returns the options for updating an object of the owner from
+// current to desired, reporting the update and its diff in dry run mode.
+func (w dryRunWriter) updateOptions(ctx context.Context, owner owner, kind string, current, desired runtime.Object) v1.UpdateOptions {
+	if w.dryRun {
+		name := ""
+		if object, ok := desired.(v1.Object); ok {
+			name = object.GetName()
+		}
+		klog.FromContext(ctx).Info("Dry run: would update object", "owner", klog.KObj(owner), "kind", kind, "name", name, "diff", objectDiff(current, desired))
+		w.recorder.Eventf(owner, corev1.EventTypeNormal, ReasonDryRun, "Would update %s %q", kind, name)
+	}
+	return v1.UpdateOptions{DryRun: w.dryRunOptions()}
+}
+
+// deleteOptions returns the options for deleting an object of the owner,
+// reporting the delete in dry run mode.
+func (w dryRunWriter) deleteOptions(ctx context.Context, owner owner, kind, name string) v1.DeleteOptions {
+	if w.dryRun {
+		klog.FromContext(ctx).Info("Dry run: would delete object", "owner", klog.KObj(owner), "kind", kind, "name", name)
+		w.recorder.Eventf(owner, corev1.EventTypeNormal, ReasonDryRun, "Would delete %s %q", kind, name)
+	}
+	return v1.DeleteOptions{DryRun: w.dryRunOptions()}
+}
+
+// createOptions returns the options for creating a child object of the
+// SQLiteInstance, reporting the create in dry run mode.
+func (c *Controller) createOptions(ctx context.Context, sqliteInstance *kubelitedbv1.SQLiteInstance, kind, name string) v1.CreateOptions {
+	return c.writer().createOptions(ctx, sqliteInstance, kind, name)
+}
+
+// updateOptions returns the options for updating an object of the
+// SQLiteInstance from current to desired, reporting the update and its diff in
+// dry run mode.
+func (c *Controller) updateOptions(ctx context.Context, sqliteInstance *kubelitedbv1.SQLiteInstance, kind string, current, desired runtime.Object) v1.UpdateOptions {
+	return c.writer().updateOptions(ctx, sqliteInstance, kind, current, desired)
+}
+
+// deleteOptions returns the options for deleting a child object of the
+// SQLiteInstance, reporting the delete in dry run mode.
+func (c *Controller) deleteOptions(ctx context.Context, sqliteInstance *kubelitedbv1.SQLiteInstance, kind, name string) v1.DeleteOptions {
+	return c.writer().deleteOptions(ctx, sqliteInstance, kind, name)
+}
+
+// writer returns the dryRunWriter of the controller
+func (c *Controller) writer() dryRunWriter {
+	return dryRunWriter{dryRun: c.dryRun, recorder: c.recorder}
+}
+
+// writer returns the dryRunWriter of the backup controller, which reports
+// the writes with its own recorder
+func (c *BackupController) writer() dryRunWriter {
+	return dryRunWriter{dryRun: c.dryRun, recorder: c.recorder}
+}
+
+// objectDiff returns a human readable diff of two objects. The objects are
+// compared in their unstructured form, which only holds plain values.
+func objectDiff(current, desired runtime.Object) string {
+	currentMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(current)
+	if err != nil {
+		return err.Error()
+	}
+	desiredMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(desired)
+	if err != nil {
+		return err.Error()
+	}
+	return cmp.Diff(currentMap, desiredMap)
+}
@@ -0,0 +1,223 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+	"k8s.io/klog/v2/ktesting"
+
+	clientset "github.com/fortytwoapps/kubelitedb/pkg/generated/clientset/versioned"
+)
+
+// dryRunServer is an API server on which no object exists. It records the
+// writes it receives, answering them as the API server answers dry runs, and
+// fails the test on any write that would be persisted.
+type dryRunServer struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu     sync.Mutex
+	writes []string
+}
+
+func newDryRunServer(t *testing.T) *dryRunServer {
+	s := &dryRunServer{t: t}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func (s *dryRunServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodGet {
+		// Namespaced collections are empty, and every other object is missing
+		segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if (segments[0] == "api" && len(segments) == 5) || (segments[0] == "apis" && len(segments) == 6) {
+			io.WriteString(w, `{"items":[]}`)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`)
+		return
+	}
+
+	s.mu.Lock()
+	s.writes = append(s.writes, r.Method+" "+r.URL.Path)
+	s.mu.Unlock()
+	if dryRun := r.URL.Query()["dryRun"]; !slices.Equal(dryRun, []string{v1.DryRunAll}) {
+		s.t.Errorf("expected %s %s to be a dry run, got dryRun=%v", r.Method, r.URL.Path, dryRun)
+	}
+	if r.Method == http.MethodDelete {
+		io.WriteString(w, `{"kind":"Status","apiVersion":"v1","status":"Success"}`)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		s.t.Errorf("error reading %s %s: %v", r.Method, r.URL.Path, err)
+	}
+	w.Write(body)
+}
+
+// config returns the client configuration of the server.
+func (s *dryRunServer) config() *rest.Config {
+	return &rest.Config{Host: s.server.URL}
+}
+
+// received returns the writes the server received.
+func (s *dryRunServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.writes)
+}
+
+// newBufferedTestContext returns a context whose logger keeps the log
+// entries, along with the function returning them.
+func newBufferedTestContext(t *testing.T) (context.Context, func() string) {
+	logger := ktesting.NewLogger(t, ktesting.NewConfig(ktesting.BufferLogs(true)))
+	buffer := logger.GetSink().(ktesting.Underlier).GetBuffer()
+	return klog.NewContext(context.Background(), logger), buffer.String
+}
+
+// logged returns whether a line of the logs contains every substring.
+func logged(logs string, substrings ...string) bool {
+	for _, line := range strings.Split(logs, "\n") {
+		found := true
+		for _, substring := range substrings {
+			found = found && strings.Contains(line, substring)
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDryRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing bool
+		writes   []string
+		logged   []string
+		diff     *regexp.Regexp
+		events   []string
+	}{
+		{
+			name: "create",
+			writes: []string{
+				"POST /api/v1/namespaces/default/services",
+				"POST /apis/apps/v1/namespaces/default/statefulsets",
+				"PUT /apis/kubelitedb.fortytwoapps.tech/v1/namespaces/default/sqliteinstances/test/status",
+			},
+			logged: []string{"Dry run: would create object", `kind="StatefulSet" name="test-sqlite"`},
+			events: []string{`Normal DryRun Would create StatefulSet "test-sqlite"`},
+		},
+		{
+			name:     "update",
+			existing: true,
+			writes: []string{
+				"POST /api/v1/namespaces/default/services",
+				"PUT /apis/apps/v1/namespaces/default/statefulsets/test-sqlite",
+				"PUT /apis/kubelitedb.fortytwoapps.tech/v1/namespaces/default/sqliteinstances/test/status",
+			},
+			logged: []string{"Dry run: would update object", `kind="StatefulSet" name="test-sqlite"`},
+			// The whitespace of the diff is deliberately unstable
+			diff:   regexp.MustCompile(`\+[\s\p{Zs}]+"image":[\s\p{Zs}]+string\("example\.com/sqlite:3\.45"\)`),
+			events: []string{`Normal DryRun Would update StatefulSet "test-sqlite"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, logs := newBufferedTestContext(t)
+			server := newDryRunServer(t)
+			f := newFixture(t)
+			f.dryRun = true
+			instance := newSQLiteInstance("test")
+			if tt.existing {
+				f.addKubeObject(newStatefulSet(instance))
+			}
+			instance = instance.DeepCopy()
+			instance.Spec.Image = "example.com/sqlite:3.45"
+			f.addInstance(instance)
+			c, _, _ := f.newController(ctx)
+			c.kubeclientset = kubernetes.NewForConfigOrDie(server.config())
+			c.kubelitedbclientset = clientset.NewForConfigOrDie(server.config())
+
+			f.run(ctx, c, getKey(instance, t))
+
+			if got := server.received(); !slices.Equal(got, tt.writes) {
+				t.Errorf("expected the dry run writes %v, got %v", tt.writes, got)
+			}
+			if !logged(logs(), tt.logged...) {
+				t.Errorf("expected an entry logging %v", tt.logged)
+			}
+			if tt.diff != nil && !tt.diff.MatchString(logs()) {
+				t.Errorf("expected the logs to contain a diff matching %s", tt.diff)
+			}
+			var events []string
+			for len(f.recorder.Events) > 0 {
+				events = append(events, <-f.recorder.Events)
+			}
+			for _, want := range tt.events {
+				if !slices.Contains(events, want) {
+					t.Errorf("expected an Event %q, got %v", want, events)
+				}
+			}
+		})
+	}
+}
+
+func TestBackupDryRun(t *testing.T) {
+	ctx, logs := newBufferedTestContext(t)
+	server := newDryRunServer(t)
+	f := newBackupFixture(t)
+	f.dryRun = true
+	instance := newSQLiteInstance("test")
+	backup := newSQLiteBackup("nightly", instance.Name)
+	f.addInstance(instance)
+	f.addBackup(backup)
+	c := f.newController(ctx)
+	c.kubeclientset = kubernetes.NewForConfigOrDie(server.config())
+	c.kubelitedbclientset = clientset.NewForConfigOrDie(server.config())
+
+	if err := c.syncHandler(ctx, "default/nightly"); err != nil {
+		t.Fatalf("error syncing the backup: %v", err)
+	}
+
+	want := []string{
+		"POST /apis/batch/v1/namespaces/default/jobs",
+		"PUT /apis/kubelitedb.fortytwoapps.tech/v1/namespaces/default/sqlitebackups/nightly/status",
+	}
+	if got := server.received(); !slices.Equal(got, want) {
+		t.Errorf("expected the dry run writes %v, got %v", want, got)
+	}
+	if want := []string{"Dry run: would create object", `owner="default/nightly" kind="Job" name="nightly-backup"`}; !logged(logs(), want...) {
+		t.Errorf("expected an entry logging %v", want)
+	}
+}
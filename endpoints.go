@@ -0,0 +1,55 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// endpointsForStatus returns the in-cluster DNS names the SQLiteInstance can
+// be reached at, given its observed status: the client, write and read
+// Services once their pods are ready, followed by the ready writer pods. The
+// pods of the StatefulSet become ready in order of their ordinals, so the
+// ready pods are the ones with the lowest ordinals. The TLS port is included
+// when TLS is set.
+func endpointsForStatus(instance *kubelitedbv1.SQLiteInstance, status *kubelitedbv1.SQLiteInstanceStatus) []string {
+	var endpoints []string
+	add := func(host string) {
+		if instance.Spec.TLS != nil {
+			host = fmt.Sprintf("%s:%d", host, kubelitedbv1.TLSPort)
+		}
+		endpoints = append(endpoints, host)
+	}
+
+	if instance.Spec.TLS != nil && status.WriterReadyReplicas > 0 {
+		add(fmt.Sprintf("%s.%s.svc", clientServiceName(instance), instance.Namespace))
+	}
+	if instance.Spec.ReadReplicas > 0 {
+		if status.WriterReadyReplicas > 0 {
+			add(fmt.Sprintf("%s.%s.svc", writeServiceName(instance), instance.Namespace))
+		}
+		if status.ReaderReadyReplicas > 0 {
+			add(fmt.Sprintf("%s.%s.svc", readServiceName(instance), instance.Namespace))
+		}
+	}
+	for ordinal := int32(0); ordinal < status.WriterReadyReplicas; ordinal++ {
+		add(fmt.Sprintf("%s-%d.%s.%s.svc", statefulSetName(instance), ordinal, headlessServiceName(instance), instance.Namespace))
+	}
+	return endpoints
+}
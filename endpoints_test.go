@@ -0,0 +1,125 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"slices"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+func TestEndpointsForStatus(t *testing.T) {
+	tests := []struct {
+		name          string
+		readReplicas  int
+		tls           bool
+		writersReady  int32
+		readersReady  int32
+		wantEndpoints []string
+	}{
+		{name: "not ready"},
+		{
+			name:          "writer ready",
+			writersReady:  1,
+			wantEndpoints: []string{"test-sqlite-0.test-sqlite.default.svc"},
+		},
+		{
+			name:          "readers not ready",
+			readReplicas:  2,
+			writersReady:  1,
+			wantEndpoints: []string{"test-sqlite-write.default.svc", "test-sqlite-0.test-sqlite.default.svc"},
+		},
+		{
+			name:         "readers ready",
+			readReplicas: 2,
+			writersReady: 1,
+			readersReady: 2,
+			wantEndpoints: []string{
+				"test-sqlite-write.default.svc",
+				"test-sqlite-read.default.svc",
+				"test-sqlite-0.test-sqlite.default.svc",
+			},
+		},
+		{
+			name:         "several pods ready",
+			writersReady: 2,
+			wantEndpoints: []string{
+				"test-sqlite-0.test-sqlite.default.svc",
+				"test-sqlite-1.test-sqlite.default.svc",
+			},
+		},
+		{
+			name:         "TLS",
+			tls:          true,
+			writersReady: 1,
+			wantEndpoints: []string{
+				"test-sqlite-client.default.svc:8443",
+				"test-sqlite-0.test-sqlite.default.svc:8443",
+			},
+		},
+		{name: "TLS not ready", tls: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newSQLiteInstance("test")
+			instance.Spec.ReadReplicas = tt.readReplicas
+			if tt.tls {
+				instance.Spec.TLS = &kubelitedbv1.TLSSpec{SecretRef: corev1.LocalObjectReference{Name: "db-tls"}}
+			}
+			status := &kubelitedbv1.SQLiteInstanceStatus{WriterReadyReplicas: tt.writersReady, ReaderReadyReplicas: tt.readersReady}
+
+			if got := endpointsForStatus(instance, status); !slices.Equal(got, tt.wantEndpoints) {
+				t.Errorf("expected endpoints %v, got %v", tt.wantEndpoints, got)
+			}
+		})
+	}
+}
+
+func TestEndpointsFollowReadyPods(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	sts := newStatefulSet(instance)
+	sts.Status.Replicas, sts.Status.ReadyReplicas, sts.Status.UpdatedReplicas = 1, 1, 1
+	f.addKubeObject(sts)
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+	key := getKey(instance, t)
+
+	f.run(ctx, c, key)
+	if got, want := f.getInstance(ctx, instance).Status.Endpoints, []string{"test-sqlite-0.test-sqlite.default.svc"}; !slices.Equal(got, want) {
+		t.Errorf("expected endpoints %v, got %v", want, got)
+	}
+
+	// The pod going away removes its endpoint
+	sts = f.getStatefulSet(ctx, instance)
+	sts.Status.ReadyReplicas = 0
+	if _, err := f.kubeclient.AppsV1().StatefulSets(sts.Namespace).UpdateStatus(ctx, sts, v1.UpdateOptions{}); err != nil {
+		t.Fatalf("error updating the StatefulSet status: %v", err)
+	}
+	f.refreshCaches(ctx)
+
+	f.run(ctx, c, key)
+	if got := f.getInstance(ctx, instance).Status.Endpoints; len(got) != 0 {
+		t.Errorf("expected no endpoints once the pod is not ready, got %v", got)
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/klog/v2/ktesting"
+)
+
+func TestEnvForInstance(t *testing.T) {
+	tests := []struct {
+		name string
+		env  []corev1.EnvVar
+		want []corev1.EnvVar
+	}{
+		{
+			name: "no env",
+			want: []corev1.EnvVar{{Name: "DATABASE_PATH", Value: "/data/app.db"}},
+		},
+		{
+			name: "user env",
+			env:  []corev1.EnvVar{{Name: "LOG_LEVEL", Value: "debug"}},
+			want: []corev1.EnvVar{{Name: "LOG_LEVEL", Value: "debug"}, {Name: "DATABASE_PATH", Value: "/data/app.db"}},
+		},
+		{
+			name: "reserved env",
+			env:  []corev1.EnvVar{{Name: "DATABASE_PATH", Value: "/tmp/other.db"}, {Name: "SQLITE_TMPDIR", Value: "/tmp"}, {Name: "LOG_LEVEL", Value: "debug"}},
+			want: []corev1.EnvVar{{Name: "LOG_LEVEL", Value: "debug"}, {Name: "DATABASE_PATH", Value: "/data/app.db"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newSQLiteInstance("test")
+			instance.Spec.Env = tt.env
+			got := envForInstance(instance, []corev1.EnvVar{{Name: "DATABASE_PATH", Value: "/data/app.db"}})
+			if !equality.Semantic.DeepEqual(got, tt.want) {
+				t.Errorf("expected env %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEnv(t *testing.T) {
+	instance := newSQLiteInstance("test")
+	instance.Spec.Env = []corev1.EnvVar{
+		{Name: "LOG_LEVEL", Value: "debug"},
+		{Name: "DATABASE_PATH", Value: "/tmp/other.db"},
+	}
+	instance.Spec.EnvFrom = []corev1.EnvFromSource{
+		{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "settings"}}},
+	}
+
+	container := &newStatefulSet(instance).Spec.Template.Spec.Containers[0]
+
+	if got := envValue(container, "LOG_LEVEL"); got != "debug" {
+		t.Errorf("expected LOG_LEVEL debug, got %q", got)
+	}
+	if got, want := envValue(container, "DATABASE_PATH"), databasePath(instance); got != want {
+		t.Errorf("expected DATABASE_PATH %q, got %q", want, got)
+	}
+	var paths int
+	for _, env := range container.Env {
+		if env.Name == "DATABASE_PATH" {
+			paths++
+		}
+	}
+	if paths != 1 {
+		t.Errorf("expected DATABASE_PATH to be set once, got %d", paths)
+	}
+	if !equality.Semantic.DeepEqual(container.EnvFrom, instance.Spec.EnvFrom) {
+		t.Errorf("expected envFrom %v, got %v", instance.Spec.EnvFrom, container.EnvFrom)
+	}
+}
+
+func TestEnvChangeRollsPods(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(env []corev1.EnvVar) []corev1.EnvVar
+		want   bool
+	}{
+		{name: "unchanged", mutate: func(env []corev1.EnvVar) []corev1.EnvVar { return env }},
+		{
+			name:   "changed value",
+			mutate: func(env []corev1.EnvVar) []corev1.EnvVar { return []corev1.EnvVar{{Name: "LOG_LEVEL", Value: "info"}} },
+			want:   true,
+		},
+		{
+			name: "added variable",
+			mutate: func(env []corev1.EnvVar) []corev1.EnvVar {
+				return append(env, corev1.EnvVar{Name: "LOG_FORMAT", Value: "json"})
+			},
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := newSQLiteInstance("test")
+			instance.Spec.Env = []corev1.EnvVar{{Name: "LOG_LEVEL", Value: "debug"}}
+			sts := newStatefulSet(instance)
+			f.addKubeObject(sts)
+			instance.Spec.Env = tt.mutate(instance.Spec.Env)
+			f.addInstance(instance)
+			c, _, _ := f.newController(ctx)
+
+			f.run(ctx, c, getKey(instance, t))
+
+			got := f.getStatefulSet(ctx, instance).Spec.Template.Annotations[templateHashAnnotation]
+			if rolled := got != sts.Spec.Template.Annotations[templateHashAnnotation]; rolled != tt.want {
+				t.Errorf("expected the pods to roll %t, got template hash %q from %q", tt.want, got, sts.Spec.Template.Annotations[templateHashAnnotation])
+			}
+		})
+	}
+}
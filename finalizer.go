@@ -0,0 +1,106 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"slices"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog/v2"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// cleanupFinalizer is added to every SQLiteInstance so the controller gets a
+// chance to purge resources living outside of the cluster, which are not
+// removed by garbage collection of the owned objects.
+const cleanupFinalizer = "kubelitedb.fortytwoapps.tech/cleanup"
+
+// ensureFinalizer adds the cleanup finalizer to the SQLiteInstance if it is
+// missing and returns the updated object.
+func (c *Controller) ensureFinalizer(ctx context.Context, sqliteInstance *kubelitedbv1.SQLiteInstance) (*kubelitedbv1.SQLiteInstance, error) {
+	if slices.Contains(sqliteInstance.Finalizers, cleanupFinalizer) {
+		return sqliteInstance, nil
+	}
+	sqliteInstanceCopy := sqliteInstance.DeepCopy()
+	sqliteInstanceCopy.Finalizers = append(sqliteInstanceCopy.Finalizers, cleanupFinalizer)
+	return c.kubelitedbclientset.KubelitedbV1().SQLiteInstances(sqliteInstance.Namespace).Update(ctx, sqliteInstanceCopy, c.updateOptions(ctx, sqliteInstance, "SQLiteInstance", sqliteInstance, sqliteInstanceCopy))
+}
+
+// finalizeSQLiteInstance runs the cleanup logic for a SQLiteInstance that is
+// being deleted and then removes the cleanup finalizer so the object can be
+// garbage collected. Any error is returned so the deletion is retried. A
+// deletion waiting for a snapshot or for the purge of object storage is
+// checked again after the returned delay.
+func (c *Controller) finalizeSQLiteInstance(ctx context.Context, sqliteInstance *kubelitedbv1.SQLiteInstance) (reconcileResult, error) {
+	logger := klog.FromContext(ctx)
+
+	if !slices.Contains(sqliteInstance.Finalizers, cleanupFinalizer) {
+		return reconcileResult{}, nil
+	}
+
+	if sqliteInstance.Status.Phase != kubelitedbv1.PhaseTerminating {
+		sqliteInstanceCopy := sqliteInstance.DeepCopy()
+		sqliteInstanceCopy.Status.Phase, sqliteInstanceCopy.Status.Message = kubelitedbv1.PhaseTerminating, ""
+		updated, err := c.kubelitedbclientset.KubelitedbV1().SQLiteInstances(sqliteInstance.Namespace).UpdateStatus(ctx, sqliteInstanceCopy, c.updateOptions(ctx, sqliteInstance, "SQLiteInstance", sqliteInstance, sqliteInstanceCopy))
+		if errors.IsNotFound(err) {
+			return reconcileResult{}, nil
+		}
+		if err != nil {
+			return reconcileResult{}, err
+		}
+		// The finalizer is removed from the updated object, so the removal
+		// doesn't conflict with the status write.
+		sqliteInstance = updated
+	}
+
+	// The data volumes are only garbage collected once the finalizer is
+	// removed, so they can still be backed up.
+	snapshotted, err := c.takeDeletionSnapshot(ctx, sqliteInstance)
+	if err != nil {
+		return reconcileResult{}, err
+	}
+	if !snapshotted {
+		return reconcileResult{RequeueAfter: pendingRequeueAfter}, nil
+	}
+
+	cleanedUp, err := c.cleanupExternalStorage(ctx, sqliteInstance)
+	if err != nil {
+		return reconcileResult{}, err
+	}
+	if !cleanedUp {
+		return reconcileResult{RequeueAfter: pendingRequeueAfter}, nil
+	}
+
+	sqliteInstanceCopy := sqliteInstance.DeepCopy()
+	sqliteInstanceCopy.Finalizers = slices.DeleteFunc(sqliteInstanceCopy.Finalizers, func(f string) bool {
+		return f == cleanupFinalizer
+	})
+	_, err = c.kubelitedbclientset.KubelitedbV1().SQLiteInstances(sqliteInstance.Namespace).Update(ctx, sqliteInstanceCopy, c.updateOptions(ctx, sqliteInstance, "SQLiteInstance", sqliteInstance, sqliteInstanceCopy))
+	// The SQLiteInstance may already be gone if another worker finished the
+	// deletion in the meantime, in which case there is nothing left to do.
+	if errors.IsNotFound(err) {
+		return reconcileResult{}, nil
+	}
+	if err != nil {
+		return reconcileResult{}, err
+	}
+
+	logger.V(4).Info("Removed cleanup finalizer", "sqliteInstance", klog.KObj(sqliteInstance))
+	return reconcileResult{}, nil
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"slices"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+func TestEnsureFinalizer(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	instance.Finalizers = nil
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+
+	f.run(ctx, c, getKey(instance, t))
+
+	if got := f.getInstance(ctx, instance); !slices.Contains(got.Finalizers, cleanupFinalizer) {
+		t.Errorf("expected finalizer %s, got %v", cleanupFinalizer, got.Finalizers)
+	}
+}
+
+func TestFinalizeSQLiteInstance(t *testing.T) {
+	destination := &kubelitedbv1.BackupDestination{
+		Bucket:    "backups",
+		SecretRef: corev1.LocalObjectReference{Name: "credentials"},
+	}
+	tests := []struct {
+		name        string
+		destination *kubelitedbv1.BackupDestination
+		job         *batchv1.JobCondition
+		// Whether the finalizer is removed
+		finalized bool
+		// Reason of the expected DeletionBlocked condition
+		reason string
+	}{
+		{name: "nothing pushed", finalized: true},
+		{name: "cleanup started", destination: destination, reason: ReasonCleanupRunning},
+		{name: "cleanup completed", destination: destination, job: &batchv1.JobCondition{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}, finalized: true},
+		{name: "cleanup failed", destination: destination, job: &batchv1.JobCondition{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}, reason: ReasonCleanupFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := newSQLiteInstance("test")
+			now := v1.Now()
+			instance.DeletionTimestamp = &now
+			instance.Spec.BackupDestination = tt.destination
+			f.addInstance(instance)
+			if tt.job != nil {
+				job := newCleanupJob(instance)
+				job.Status.Conditions = []batchv1.JobCondition{*tt.job}
+				f.addKubeObject(job)
+			}
+			c, _, _ := f.newController(ctx)
+
+			result := f.run(ctx, c, getKey(instance, t))
+
+			got := f.getInstance(ctx, instance)
+			if finalized := !slices.Contains(got.Finalizers, cleanupFinalizer); finalized != tt.finalized {
+				t.Errorf("expected finalizer removed %t, got %t", tt.finalized, finalized)
+			}
+			if !tt.finalized && result.RequeueAfter != pendingRequeueAfter {
+				t.Errorf("expected a requeue after %s, got %+v", pendingRequeueAfter, result)
+			}
+			if tt.reason != "" {
+				condition := meta.FindStatusCondition(got.Status.Conditions, kubelitedbv1.ConditionDeletionBlocked)
+				if condition == nil || condition.Reason != tt.reason {
+					t.Errorf("expected condition %s with reason %s, got %+v", kubelitedbv1.ConditionDeletionBlocked, tt.reason, condition)
+				}
+			}
+			if tt.destination != nil && tt.job == nil {
+				if _, err := f.kubeclient.BatchV1().Jobs(instance.Namespace).Get(ctx, cleanupJobName(instance), v1.GetOptions{}); err != nil {
+					t.Errorf("expected the cleanup Job to be created: %v", err)
+				}
+			}
+			if actions := writes(f.kubeclient.Actions(), "statefulsets"); len(actions) != 0 {
+				t.Errorf("expected no StatefulSet writes for a deleted SQLiteInstance, got %v", actions)
+			}
+		})
+	}
+}
+
+func TestNewCleanupJobKeepsDeletionSnapshot(t *testing.T) {
+	instance := newSQLiteInstance("test")
+	instance.Spec.BackupDestination = &kubelitedbv1.BackupDestination{Bucket: "backups", Path: "prod/app"}
+	instance.Spec.Replication = &kubelitedbv1.ReplicationSpec{Bucket: "replicas"}
+
+	job := newCleanupJob(instance)
+	containers := job.Spec.Template.Spec.Containers
+	if len(containers) != 2 {
+		t.Fatalf("expected 2 containers, got %d", len(containers))
+	}
+	for i, url := range []string{"s3://backups/prod/app/", "s3://replicas/default/test/"} {
+		args := containers[i].Args
+		if !slices.Contains(args, url) {
+			t.Errorf("expected container %s to delete %s, got %v", containers[i].Name, url, args)
+		}
+		if !slices.Contains(args, deletionSnapshotName(instance)+"/*") {
+			t.Errorf("expected container %s to keep the deletion snapshot, got %v", containers[i].Name, args)
+		}
+	}
+	if got := job.Spec.Template.Labels[componentLabel]; got != componentCleanup {
+		t.Errorf("expected the pods of the Job to be labeled as the %s component, got %q", componentCleanup, got)
+	}
+}
+
+func TestFinalizeMissingSQLiteInstance(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	now := v1.Now()
+	instance.DeletionTimestamp = &now
+	// The instance is in the cache but already gone from the API server
+	f.sqliteInstanceLister = append(f.sqliteInstanceLister, instance)
+	c, _, _ := f.newController(ctx)
+
+	f.run(ctx, c, getKey(instance, t))
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/ktesting"
+)
+
+func TestImagePullSecrets(t *testing.T) {
+	tests := []struct {
+		name    string
+		secrets []corev1.LocalObjectReference
+	}{
+		{name: "unset"},
+		{name: "one secret", secrets: []corev1.LocalObjectReference{{Name: "registry"}}},
+		{name: "several secrets", secrets: []corev1.LocalObjectReference{{Name: "registry"}, {Name: "mirror"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := withBackupSchedule(newSQLiteInstance("test"), "0 * * * *")
+			instance.Spec.ReadReplicas = 1
+			instance.Spec.ImagePullSecrets = tt.secrets
+
+			// Every pod running the SQLite image pulls it with the secrets
+			for kind, podSpec := range map[string]corev1.PodSpec{
+				"StatefulSet":    newStatefulSet(instance).Spec.Template.Spec,
+				"Deployment":     newReaderDeployment(instance).Spec.Template.Spec,
+				"DaemonSet":      newDaemonSet(instance, defaultHostPathBase).Spec.Template.Spec,
+				"backup Job":     newBackupJob(newSQLiteBackup("backup", instance.Name), instance).Spec.Template.Spec,
+				"backup CronJob": newBackupCronJob(instance).Spec.JobTemplate.Spec.Template.Spec,
+				"clone Job":      newCloneJob(newSQLiteInstance("clone"), instance).Spec.Template.Spec,
+			} {
+				if !reflect.DeepEqual(podSpec.ImagePullSecrets, tt.secrets) {
+					t.Errorf("expected the %s to use the pull secrets %v, got %v", kind, tt.secrets, podSpec.ImagePullSecrets)
+				}
+			}
+		})
+	}
+}
+
+func TestImagePullSecretsChangeUpdatesStatefulSet(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+	f.run(ctx, c, getKey(instance, t))
+	oldHash := f.getStatefulSet(ctx, instance).Spec.Template.Annotations[templateHashAnnotation]
+
+	updated := f.getInstance(ctx, instance)
+	updated.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "registry"}}
+	updated.Generation++
+	if _, err := f.client.KubelitedbV1().SQLiteInstances(instance.Namespace).Update(ctx, updated, v1.UpdateOptions{}); err != nil {
+		t.Fatalf("error updating the SQLiteInstance: %v", err)
+	}
+	f.refreshCaches(ctx)
+	f.kubeclient.ClearActions()
+
+	f.run(ctx, c, getKey(instance, t))
+
+	if actions := writes(f.kubeclient.Actions(), "statefulsets"); len(actions) != 1 || actions[0].GetVerb() != "update" {
+		t.Fatalf("expected the StatefulSet to be updated, got %v", actions)
+	}
+	statefulSet := f.getStatefulSet(ctx, instance)
+	if statefulSet.Spec.Template.Annotations[templateHashAnnotation] == oldHash {
+		t.Errorf("expected the template hash to change")
+	}
+	if got := statefulSet.Spec.Template.Spec.ImagePullSecrets; !reflect.DeepEqual(got, updated.Spec.ImagePullSecrets) {
+		t.Errorf("expected the pull secrets %v, got %v", updated.Spec.ImagePullSecrets, got)
+	}
+}
+
+func TestImagePullSecretsSchema(t *testing.T) {
+	version := crdV1(t, "crds/sqliteinstances.yaml")
+	schema, ok := schemaField(version.Schema.OpenAPIV3Schema, ".spec.imagePullSecrets")
+	if !ok {
+		t.Fatalf("expected .spec.imagePullSecrets in the schema")
+	}
+	if schema.Type != "array" {
+		t.Errorf("expected .spec.imagePullSecrets to be an array, got %q", schema.Type)
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"path"
+
+	corev1 "k8s.io/api/core/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+const (
+	// initSQLVolumeName is the name of the volume holding the init SQL script
+	// referenced by spec.initSQL.configMapKeyRef
+	initSQLVolumeName = "init-sql"
+	// initSQLMountPath is where the init SQL script is mounted
+	initSQLMountPath = "/etc/kubelitedb/init"
+	// initSQLFile is the file name of the mounted init SQL script
+	initSQLFile = "init.sql"
+)
+
+// initSQLScript seeds a new database with the init SQL script. The database is
+// built next to the final path and then renamed, so a failed script is simply
+// run again on the next start. Nothing is run when the database exists, so
+// restarts and restored databases are left untouched.
+const initSQLScript = `set -e
+if [ -f "$DATABASE_PATH" ]; then
+  echo "Database $DATABASE_PATH already exists, skipping init SQL"
+  exit 0
+fi
+rm -f "$DATABASE_PATH.init"
+if [ -n "$INIT_SQL_PATH" ]; then
+  sqlite3 "$DATABASE_PATH.init" < "$INIT_SQL_PATH"
+else
+  printf '%s\n' "$INIT_SQL" | sqlite3 "$DATABASE_PATH.init"
+fi
+mv "$DATABASE_PATH.init" "$DATABASE_PATH"
+`
+
+// addInitSQLInitContainer adds the init container seeding a new database with
+// the init SQL script of the SQLiteInstance. It runs after the restore, so a
+// restored database is not seeded.
+func addInitSQLInitContainer(instance *kubelitedbv1.SQLiteInstance, template *corev1.PodTemplateSpec) {
+	initSQL := instance.Spec.InitSQL
+	container := corev1.Container{
+		Name:    "init-sql",
+		Image:   imageForInstance(instance),
+		Command: []string{"/bin/sh", "-c", initSQLScript},
+		Env: []corev1.EnvVar{
+			{Name: "DATABASE_PATH", Value: databasePath(instance)},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			dataVolumeMount(instance),
+		},
+	}
+
+	if initSQL.ConfigMapKeyRef != nil {
+		container.Env = append(container.Env, corev1.EnvVar{Name: "INIT_SQL_PATH", Value: path.Join(initSQLMountPath, initSQLFile)})
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      initSQLVolumeName,
+			MountPath: initSQLMountPath,
+			ReadOnly:  true,
+		})
+		template.Spec.Volumes = append(template.Spec.Volumes, corev1.Volume{
+			Name: initSQLVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: initSQL.ConfigMapKeyRef.LocalObjectReference,
+					Items: []corev1.KeyToPath{
+						{Key: initSQL.ConfigMapKeyRef.Key, Path: initSQLFile},
+					},
+				},
+			},
+		})
+	} else {
+		container.Env = append(container.Env, corev1.EnvVar{Name: "INIT_SQL", Value: initSQL.Inline})
+	}
+
+	template.Spec.InitContainers = append(template.Spec.InitContainers, container)
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// envValue returns the value of the environment variable of the container, or
+// an empty string when it isn't set.
+func envValue(container *corev1.Container, name string) string {
+	for _, env := range container.Env {
+		if env.Name == name {
+			return env.Value
+		}
+	}
+	return ""
+}
+
+func TestInitSQLInitContainer(t *testing.T) {
+	tests := []struct {
+		name       string
+		initSQL    *kubelitedbv1.InitSQLSource
+		wantInline string
+		wantPath   string
+	}{
+		{name: "no init SQL"},
+		{
+			name:       "inline",
+			initSQL:    &kubelitedbv1.InitSQLSource{Inline: "CREATE TABLE users (id INTEGER PRIMARY KEY);"},
+			wantInline: "CREATE TABLE users (id INTEGER PRIMARY KEY);",
+		},
+		{
+			name: "ConfigMap key",
+			initSQL: &kubelitedbv1.InitSQLSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "schema"},
+				Key:                  "schema.sql",
+			}},
+			wantPath: "/etc/kubelitedb/init/init.sql",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newSQLiteInstance("test")
+			instance.Spec.InitSQL = tt.initSQL
+
+			template := newStatefulSet(instance).Spec.Template
+
+			if tt.initSQL == nil {
+				if hasInitContainer(template, "init-sql") {
+					t.Errorf("expected no init-sql init container, got %v", template.Spec.InitContainers)
+				}
+				return
+			}
+			initContainer := container(t, template.Spec.InitContainers, "init-sql")
+			if got := envValue(initContainer, "DATABASE_PATH"); got != "/data/app.db" {
+				t.Errorf("expected the database path /data/app.db, got %q", got)
+			}
+			if got := envValue(initContainer, "INIT_SQL"); got != tt.wantInline {
+				t.Errorf("expected the inline script %q, got %q", tt.wantInline, got)
+			}
+			if got := envValue(initContainer, "INIT_SQL_PATH"); got != tt.wantPath {
+				t.Errorf("expected the script path %q, got %q", tt.wantPath, got)
+			}
+
+			mounted := slices.ContainsFunc(initContainer.VolumeMounts, func(mount corev1.VolumeMount) bool {
+				return mount.Name == initSQLVolumeName && mount.MountPath == initSQLMountPath && mount.ReadOnly
+			})
+			i := slices.IndexFunc(template.Spec.Volumes, func(volume corev1.Volume) bool { return volume.Name == initSQLVolumeName })
+			if tt.initSQL.ConfigMapKeyRef == nil {
+				if mounted || i >= 0 {
+					t.Errorf("expected no %s volume for an inline script", initSQLVolumeName)
+				}
+				return
+			}
+			if !mounted {
+				t.Errorf("expected the %s volume to be mounted read-only at %s, got %v", initSQLVolumeName, initSQLMountPath, initContainer.VolumeMounts)
+			}
+			if i < 0 {
+				t.Fatalf("expected a %s volume, got %v", initSQLVolumeName, template.Spec.Volumes)
+			}
+			source := template.Spec.Volumes[i].ConfigMap
+			want := []corev1.KeyToPath{{Key: "schema.sql", Path: initSQLFile}}
+			if source == nil || source.Name != "schema" || !slices.Equal(source.Items, want) {
+				t.Errorf("expected the schema.sql key of the schema ConfigMap as %s, got %v", initSQLFile, template.Spec.Volumes[i])
+			}
+		})
+	}
+}
+
+func TestInitSQLAfterRestore(t *testing.T) {
+	instance := newRestoringSQLiteInstance("test")
+	instance.Spec.InitSQL = &kubelitedbv1.InitSQLSource{Inline: "CREATE TABLE users (id INTEGER PRIMARY KEY);"}
+
+	template := newStatefulSet(instance).Spec.Template
+
+	var names []string
+	for _, container := range template.Spec.InitContainers {
+		names = append(names, container.Name)
+	}
+	restore, initSQL := slices.Index(names, "restore"), slices.Index(names, "init-sql")
+	if restore < 0 || initSQL < restore {
+		t.Errorf("expected the init-sql init container to run after the restore, got %v", names)
+	}
+}
+
+// TestInitSQLScript runs the script of the init container against a fake
+// sqlite3, which writes the SQL it reads into the database file.
+func TestInitSQLScript(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no shell to run the init SQL script with")
+	}
+	bin := t.TempDir()
+	fake := "#!/bin/sh\ncat > \"$1\"\n"
+	if err := os.WriteFile(filepath.Join(bin, "sqlite3"), []byte(fake), 0o755); err != nil {
+		t.Fatalf("error writing the fake sqlite3: %v", err)
+	}
+	data := t.TempDir()
+	database := filepath.Join(data, "app.db")
+	run := func(script string) {
+		t.Helper()
+		cmd := exec.Command(sh, "-c", initSQLScript)
+		cmd.Env = []string{"PATH=" + bin + ":/usr/bin:/bin", "DATABASE_PATH=" + database, "INIT_SQL=" + script}
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("error running the init SQL script: %v: %s", err, out)
+		}
+	}
+
+	run("CREATE TABLE users (id INTEGER PRIMARY KEY);")
+	got, err := os.ReadFile(database)
+	if err != nil {
+		t.Fatalf("expected the database to be created: %v", err)
+	}
+	if want := "CREATE TABLE users (id INTEGER PRIMARY KEY);\n"; string(got) != want {
+		t.Errorf("expected the database to be seeded with %q, got %q", want, got)
+	}
+	if _, err := os.Stat(database + ".init"); !os.IsNotExist(err) {
+		t.Errorf("expected the database to be renamed into place, got %v", err)
+	}
+
+	// A restarted pod finds the database and leaves it untouched
+	run("DROP TABLE users;")
+	if again, _ := os.ReadFile(database); string(again) != string(got) {
+		t.Errorf("expected the existing database to be left untouched, got %q", again)
+	}
+}
@@ -0,0 +1,257 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+	"github.com/fortytwoapps/kubelitedb/pkg/validation"
+)
+
+const (
+	// ReasonIntegrityCheckPassed is used as the condition reason when the
+	// last integrity check passed
+	ReasonIntegrityCheckPassed = "IntegrityCheckPassed"
+	// ReasonIntegrityCheckFailed is used as the condition and event reason
+	// when the last integrity check failed
+	ReasonIntegrityCheckFailed = "IntegrityCheckFailed"
+	// ReasonInvalidIntegrityCheck is used as the event reason when the
+	// integrity check schedule is invalid
+	ReasonInvalidIntegrityCheck = "InvalidIntegrityCheck"
+
+	// MessageIntegrityCheckFailed is the message used when the last integrity
+	// check failed
+	MessageIntegrityCheckFailed = "The integrity check finished at %s failed, see the logs of the Jobs of CronJob %q"
+	// MessageInvalidIntegrityCheck is the message used when the integrity
+	// check schedule is invalid
+	MessageInvalidIntegrityCheck = "Invalid integrity check: %v"
+)
+
+// integrityCheckScript checks the snapshot of the database, and fails unless
+// SQLite reports it as ok. The snapshot is opened as immutable, as its volume
+// is mounted read-only.
+const integrityCheckScript = `result=$(sqlite3 "file:$SNAPSHOT_PATH?immutable=1" "PRAGMA integrity_check") || exit 1
+echo "$result"
+[ "$result" = "ok" ]
+`
+
+// integrityCheckCronJobName returns the name of the CronJob checking the
+// integrity of the database of the SQLiteInstance
+func integrityCheckCronJobName(instance *kubelitedbv1.SQLiteInstance) string {
+	return fmt.Sprintf("%s-integrity-check", instance.Name)
+}
+
+// newIntegrityCheckCronJob creates the CronJob checking a snapshot of the
+// database of the SQLiteInstance on its integrity check schedule. The live
+// database is never read for longer than the snapshot takes, so the check
+// doesn't hold back checkpoints of the WAL.
+func newIntegrityCheckCronJob(instance *kubelitedbv1.SQLiteInstance) *batchv1.CronJob {
+	backoffLimit := int32(2)
+	spec := newSnapshotPodSpec(instance, corev1.Container{
+		Name:    "check",
+		Image:   imageForInstance(instance),
+		Command: []string{"/bin/sh", "-c", integrityCheckScript},
+		Env: []corev1.EnvVar{
+			{Name: "SNAPSHOT_PATH", Value: backupSnapshotPath(instance)},
+		},
+	})
+	setSecurityContexts(instance, &spec)
+
+	jobTemplate := batchv1.JobTemplateSpec{
+		ObjectMeta: v1.ObjectMeta{
+			// The Jobs are listed by these labels to find the last check
+			Labels: childLabels(instance, labelsForInstance(instance, componentIntegrityCheck)),
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: v1.ObjectMeta{
+					Labels:      childLabels(instance, labelsForInstance(instance, componentIntegrityCheck)),
+					Annotations: childAnnotations(instance),
+				},
+				Spec: spec,
+			},
+		},
+	}
+	jobTemplate.Annotations = map[string]string{
+		backupCronJobTemplateHashAnnotation: computeHash(jobTemplate),
+	}
+
+	return &batchv1.CronJob{
+		ObjectMeta: v1.ObjectMeta{
+			Name:            integrityCheckCronJobName(instance),
+			Namespace:       instance.Namespace,
+			Labels:          childLabels(instance, labelsForInstance(instance, componentIntegrityCheck)),
+			Annotations:     childAnnotations(instance),
+			OwnerReferences: []v1.OwnerReference{newOwnerReference(instance)},
+		},
+		Spec: batchv1.CronJobSpec{
+			Schedule:          instance.Spec.IntegrityCheck.Schedule,
+			ConcurrencyPolicy: batchv1.ForbidConcurrent,
+			JobTemplate:       jobTemplate,
+		},
+	}
+}
+
+// syncIntegrityCheckCronJob creates, updates or deletes the CronJob checking
+// the integrity of the database of the SQLiteInstance, and records the
+// outcome of its last finished Job in the status. Jobs are not watched, so
+// the outcome is recorded by the next sync after the Job finished. An invalid
+// schedule leaves the CronJob as is, as it will not become valid by retrying.
+func (c *Controller) syncIntegrityCheckCronJob(ctx context.Context, sqliteInstance *kubelitedbv1.SQLiteInstance, status *kubelitedbv1.SQLiteInstanceStatus) error {
+	// The outcome of the last check is reported until the next check, even
+	// when the checks are no longer scheduled.
+	defer setCorruptedCondition(status, sqliteInstance)
+
+	check := sqliteInstance.Spec.IntegrityCheck
+	if check != nil {
+		if errs := validation.ValidateIntegrityCheck(check, field.NewPath("spec", "integrityCheck")); len(errs) > 0 {
+			c.recorder.Event(sqliteInstance, corev1.EventTypeWarning, ReasonInvalidIntegrityCheck, fmt.Sprintf(MessageInvalidIntegrityCheck, errs.ToAggregate()))
+			return nil
+		}
+	}
+
+	cronJobs := c.kubeclientset.BatchV1().CronJobs(sqliteInstance.Namespace)
+	cronJob, err := cronJobs.Get(ctx, integrityCheckCronJobName(sqliteInstance), v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		if check == nil {
+			return nil
+		}
+		_, err = cronJobs.Create(ctx, newIntegrityCheckCronJob(sqliteInstance), c.createOptions(ctx, sqliteInstance, "CronJob", integrityCheckCronJobName(sqliteInstance)))
+		logWrite(ctx, "create", "CronJob", integrityCheckCronJobName(sqliteInstance), err)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if !v1.IsControlledBy(cronJob, sqliteInstance) {
+		msg := fmt.Sprintf(MessageResourceExists, cronJob.Name)
+		c.recorder.Event(sqliteInstance, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return fmt.Errorf("%s", msg)
+	}
+
+	if err := c.recordIntegrityCheck(ctx, sqliteInstance, status, cronJob); err != nil {
+		return err
+	}
+
+	if check == nil {
+		err = cronJobs.Delete(ctx, cronJob.Name, c.deleteOptions(ctx, sqliteInstance, "CronJob", cronJob.Name))
+		logWrite(ctx, "delete", "CronJob", cronJob.Name, err)
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		return nil
+	}
+
+	desired := newIntegrityCheckCronJob(sqliteInstance)
+	if cronJob.Spec.Schedule != desired.Spec.Schedule ||
+		cronJob.Spec.JobTemplate.Annotations[backupCronJobTemplateHashAnnotation] != desired.Spec.JobTemplate.Annotations[backupCronJobTemplateHashAnnotation] ||
+		metadataOutOfDate(cronJob, desired) {
+		cronJobCopy := cronJob.DeepCopy()
+		mergeMetadata(cronJobCopy, desired)
+		cronJobCopy.Spec.Schedule = desired.Spec.Schedule
+		cronJobCopy.Spec.JobTemplate = desired.Spec.JobTemplate
+		_, err = cronJobs.Update(ctx, cronJobCopy, c.updateOptions(ctx, sqliteInstance, "CronJob", cronJob, cronJobCopy))
+		logWrite(ctx, "update", "CronJob", cronJob.Name, err)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordIntegrityCheck records the outcome of the last finished Job of the
+// integrity check CronJob in the status, unless a more recent check is
+// recorded already. A newly recorded failure is reported with a Warning event.
+func (c *Controller) recordIntegrityCheck(ctx context.Context, sqliteInstance *kubelitedbv1.SQLiteInstance, status *kubelitedbv1.SQLiteInstanceStatus, cronJob *batchv1.CronJob) error {
+	selector := labels.SelectorFromSet(labels.Set{
+		instanceLabel:  sqliteInstance.Name,
+		componentLabel: componentIntegrityCheck,
+	})
+	jobs, err := c.kubeclientset.BatchV1().Jobs(sqliteInstance.Namespace).List(ctx, v1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return fmt.Errorf("failed to list integrity check Jobs: %w", err)
+	}
+
+	var finished *v1.Time
+	passed := false
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		if !v1.IsControlledBy(job, cronJob) {
+			continue
+		}
+		at, ok, done := jobOutcome(job)
+		if done && (finished == nil || finished.Before(&at)) {
+			finished, passed = &at, ok
+		}
+	}
+	if finished == nil || (status.LastIntegrityCheck != nil && !status.LastIntegrityCheck.Before(finished)) {
+		return nil
+	}
+
+	status.LastIntegrityCheck = finished
+	status.IntegrityOK = &passed
+	if !passed {
+		c.recorder.Event(sqliteInstance, corev1.EventTypeWarning, ReasonIntegrityCheckFailed,
+			fmt.Sprintf(MessageIntegrityCheckFailed, finished.UTC().Format(time.RFC3339), cronJob.Name))
+	}
+	return nil
+}
+
+// jobOutcome returns when the Job finished and whether it completed. done is
+// false while the Job is still running.
+func jobOutcome(job *batchv1.Job) (at v1.Time, ok bool, done bool) {
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobComplete:
+			return condition.LastTransitionTime, true, true
+		case batchv1.JobFailed:
+			return condition.LastTransitionTime, false, true
+		}
+	}
+	return v1.Time{}, false, false
+}
+
+// setCorruptedCondition sets the Corrupted condition of the SQLiteInstance
+// from the last recorded integrity check. The condition is removed until the
+// database was checked.
+func setCorruptedCondition(status *kubelitedbv1.SQLiteInstanceStatus, instance *kubelitedbv1.SQLiteInstance) {
+	switch {
+	case status.IntegrityOK == nil || status.LastIntegrityCheck == nil:
+		meta.RemoveStatusCondition(&status.Conditions, kubelitedbv1.ConditionCorrupted)
+	case *status.IntegrityOK:
+		setCondition(status, instance, kubelitedbv1.ConditionCorrupted, v1.ConditionFalse, ReasonIntegrityCheckPassed, "")
+	default:
+		setCondition(status, instance, kubelitedbv1.ConditionCorrupted, v1.ConditionTrue, ReasonIntegrityCheckFailed,
+			fmt.Sprintf(MessageIntegrityCheckFailed, status.LastIntegrityCheck.UTC().Format(time.RFC3339), integrityCheckCronJobName(instance)))
+	}
+}
@@ -0,0 +1,460 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// withIntegrityCheck schedules integrity checks of the SQLiteInstance, or
+// stops them when the schedule is empty.
+func withIntegrityCheck(instance *kubelitedbv1.SQLiteInstance, schedule string) *kubelitedbv1.SQLiteInstance {
+	instance.Spec.IntegrityCheck = nil
+	if schedule != "" {
+		instance.Spec.IntegrityCheck = &kubelitedbv1.IntegrityCheckSpec{Schedule: schedule}
+	}
+	return instance
+}
+
+// newIntegrityCheckJob returns a Job of the integrity check CronJob that
+// finished with the given condition at the given time, or is still running
+// when the condition is empty.
+func newIntegrityCheckJob(cronJob *batchv1.CronJob, name string, condition batchv1.JobConditionType, finished time.Time) *batchv1.Job {
+	job := &batchv1.Job{
+		ObjectMeta: v1.ObjectMeta{
+			Name:            name,
+			Namespace:       cronJob.Namespace,
+			Labels:          cronJob.Spec.JobTemplate.Labels,
+			OwnerReferences: []v1.OwnerReference{*v1.NewControllerRef(cronJob, batchv1.SchemeGroupVersion.WithKind("CronJob"))},
+		},
+	}
+	if condition != "" {
+		job.Status.Conditions = []batchv1.JobCondition{{
+			Type:               condition,
+			Status:             corev1.ConditionTrue,
+			LastTransitionTime: v1.NewTime(finished),
+		}}
+	}
+	return job
+}
+
+func TestNewIntegrityCheckCronJob(t *testing.T) {
+	instance := withIntegrityCheck(newSQLiteInstance("test"), "0 4 * * 0")
+	// The snapshot is checked as is, never encrypted like a backup
+	instance.Spec.BackupEncryption = &kubelitedbv1.BackupEncryption{SecretRef: corev1.LocalObjectReference{Name: "backup-key"}}
+	cronJob := newIntegrityCheckCronJob(instance)
+
+	if cronJob.Name != "test-integrity-check" {
+		t.Errorf("expected name test-integrity-check, got %s", cronJob.Name)
+	}
+	if cronJob.Spec.Schedule != "0 4 * * 0" {
+		t.Errorf("expected schedule %q, got %q", "0 4 * * 0", cronJob.Spec.Schedule)
+	}
+	if cronJob.Spec.ConcurrencyPolicy != batchv1.ForbidConcurrent {
+		t.Errorf("expected checks not to overlap, got %s", cronJob.Spec.ConcurrencyPolicy)
+	}
+	if !v1.IsControlledBy(cronJob, instance) {
+		t.Errorf("expected the CronJob to be controlled by the SQLiteInstance, got %v", cronJob.OwnerReferences)
+	}
+	for name, labels := range map[string]map[string]string{
+		"CronJob":      cronJob.Labels,
+		"Job template": cronJob.Spec.JobTemplate.Labels,
+		"pod template": cronJob.Spec.JobTemplate.Spec.Template.Labels,
+	} {
+		if labels[instanceLabel] != instance.Name || labels[componentLabel] != componentIntegrityCheck {
+			t.Errorf("expected the %s to be labelled as the integrity check of %s, got %v", name, instance.Name, labels)
+		}
+	}
+
+	spec := cronJob.Spec.JobTemplate.Spec.Template.Spec
+	if spec.RestartPolicy != corev1.RestartPolicyNever {
+		t.Errorf("expected restart policy Never, got %s", spec.RestartPolicy)
+	}
+	if got := initContainerNames(spec); !slices.Equal(got, []string{"snapshot"}) {
+		t.Errorf("expected the init containers [snapshot], got %v", got)
+	}
+	check := container(t, spec.Containers, "check")
+	if len(spec.Containers) != 1 {
+		t.Errorf("expected only the check container, got %d containers", len(spec.Containers))
+	}
+	if check.Image != imageForInstance(instance) {
+		t.Errorf("expected the image %q, got %q", imageForInstance(instance), check.Image)
+	}
+	if !slices.Equal(check.Command, []string{"/bin/sh", "-c", integrityCheckScript}) {
+		t.Errorf("expected the integrity check script, got %v", check.Command)
+	}
+	if got := envValue(check, "SNAPSHOT_PATH"); got != backupSnapshotPath(instance) {
+		t.Errorf("expected SNAPSHOT_PATH %q, got %q", backupSnapshotPath(instance), got)
+	}
+	if len(check.VolumeMounts) != 1 || check.VolumeMounts[0].Name != backupVolumeName || !check.VolumeMounts[0].ReadOnly {
+		t.Errorf("expected only the snapshot volume mounted read-only, got %v", check.VolumeMounts)
+	}
+}
+
+func TestIntegrityCheckCronJobTemplateHash(t *testing.T) {
+	instance := withIntegrityCheck(newSQLiteInstance("test"), "0 4 * * 0")
+	hash := newIntegrityCheckCronJob(instance).Spec.JobTemplate.Annotations[backupCronJobTemplateHashAnnotation]
+	if hash == "" {
+		t.Fatalf("expected the Job template to be hashed")
+	}
+	if got := newIntegrityCheckCronJob(instance).Spec.JobTemplate.Annotations[backupCronJobTemplateHashAnnotation]; got != hash {
+		t.Errorf("expected a stable hash, got %s and %s", hash, got)
+	}
+	instance.Spec.Image = "example.com/sqlite:3.45"
+	if got := newIntegrityCheckCronJob(instance).Spec.JobTemplate.Annotations[backupCronJobTemplateHashAnnotation]; got == hash {
+		t.Errorf("expected the hash to change with the image")
+	}
+}
+
+func TestSyncIntegrityCheckCronJob(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string
+		schedule string
+		// image of the SQLiteInstance, which changes the Job template
+		image  string
+		writes []string
+		want   string
+		event  string
+	}{
+		{name: "no check"},
+		{name: "create", schedule: "0 4 * * 0", writes: []string{"create"}, want: "0 4 * * 0"},
+		{name: "unchanged", existing: "0 4 * * 0", schedule: "0 4 * * 0", want: "0 4 * * 0"},
+		{name: "schedule changed", existing: "0 4 * * 0", schedule: "@daily", writes: []string{"update"}, want: "@daily"},
+		{name: "template changed", existing: "0 4 * * 0", schedule: "0 4 * * 0", image: "example.com/sqlite:3.45", writes: []string{"update"}, want: "0 4 * * 0"},
+		{name: "remove", existing: "0 4 * * 0", writes: []string{"delete"}},
+		{name: "invalid", existing: "0 4 * * 0", schedule: "every sunday", want: "0 4 * * 0", event: ReasonInvalidIntegrityCheck},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := withIntegrityCheck(newSQLiteInstance("test"), tt.schedule)
+			instance.Spec.Image = tt.image
+			if tt.existing != "" {
+				f.addKubeObject(newIntegrityCheckCronJob(withIntegrityCheck(newSQLiteInstance("test"), tt.existing)))
+			}
+			c, _, _ := f.newController(ctx)
+			status := instance.Status.DeepCopy()
+
+			if err := c.syncIntegrityCheckCronJob(ctx, instance, status); err != nil {
+				t.Fatalf("error syncing the integrity check CronJob: %v", err)
+			}
+
+			var verbs []string
+			for _, action := range writes(f.kubeclient.Actions(), "cronjobs") {
+				verbs = append(verbs, action.GetVerb())
+			}
+			if !slices.Equal(verbs, tt.writes) {
+				t.Errorf("expected CronJob writes %v, got %v", tt.writes, verbs)
+			}
+			cronJob, err := f.kubeclient.BatchV1().CronJobs(instance.Namespace).Get(ctx, integrityCheckCronJobName(instance), v1.GetOptions{})
+			if tt.want == "" {
+				if !errors.IsNotFound(err) {
+					t.Errorf("expected no integrity check CronJob, got %v", err)
+				}
+			} else if err != nil {
+				t.Fatalf("error getting the integrity check CronJob: %v", err)
+			} else if cronJob.Spec.Schedule != tt.want {
+				t.Errorf("expected schedule %q, got %q", tt.want, cronJob.Spec.Schedule)
+			}
+			if tt.event != "" {
+				expectEvent(t, f.recorder, corev1.EventTypeWarning, tt.event)
+			}
+			// Nothing was checked yet
+			if condition := meta.FindStatusCondition(status.Conditions, kubelitedbv1.ConditionCorrupted); condition != nil {
+				t.Errorf("expected no %s condition, got %v", kubelitedbv1.ConditionCorrupted, condition)
+			}
+		})
+	}
+}
+
+func TestSyncIntegrityCheckCronJobNotControlled(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := withIntegrityCheck(newSQLiteInstance("test"), "0 4 * * 0")
+	cronJob := newIntegrityCheckCronJob(instance)
+	cronJob.OwnerReferences = nil
+	f.addKubeObject(cronJob)
+	c, _, _ := f.newController(ctx)
+
+	if err := c.syncIntegrityCheckCronJob(ctx, instance, instance.Status.DeepCopy()); err == nil {
+		t.Errorf("expected an error for a CronJob not controlled by the SQLiteInstance")
+	}
+	expectEvent(t, f.recorder, corev1.EventTypeWarning, ErrResourceExists)
+	if got := writes(f.kubeclient.Actions(), "cronjobs"); len(got) > 0 {
+		t.Errorf("expected the CronJob to be left alone, got %v", got)
+	}
+}
+
+func TestRecordIntegrityCheck(t *testing.T) {
+	older := time.Date(2024, 6, 2, 4, 0, 0, 0, time.UTC)
+	newer := older.Add(7 * 24 * time.Hour)
+	type job struct {
+		name      string
+		condition batchv1.JobConditionType
+		finished  time.Time
+		// Whether the Job belongs to another CronJob
+		foreign bool
+	}
+	tests := []struct {
+		name string
+		// schedule of the SQLiteInstance, the checks are no longer scheduled
+		// when empty
+		schedule string
+		jobs     []job
+		// recorded is the check recorded by a previous sync, with recordedOK
+		// its outcome
+		recorded   *time.Time
+		recordedOK bool
+		wantAt     *time.Time
+		wantOK     bool
+		// wantCorrupted is the status of the Corrupted condition, none when
+		// empty
+		wantCorrupted v1.ConditionStatus
+		wantEvent     bool
+	}{
+		{name: "no checks yet", schedule: "@weekly"},
+		{name: "running", schedule: "@weekly", jobs: []job{{name: "a"}}},
+		{
+			name:          "passed",
+			schedule:      "@weekly",
+			jobs:          []job{{name: "a", condition: batchv1.JobComplete, finished: older}},
+			wantAt:        &older,
+			wantOK:        true,
+			wantCorrupted: v1.ConditionFalse,
+		},
+		{
+			name:          "failed",
+			schedule:      "@weekly",
+			jobs:          []job{{name: "a", condition: batchv1.JobFailed, finished: older}},
+			wantAt:        &older,
+			wantCorrupted: v1.ConditionTrue,
+			wantEvent:     true,
+		},
+		{
+			name:     "last check wins",
+			schedule: "@weekly",
+			jobs: []job{
+				{name: "a", condition: batchv1.JobFailed, finished: older},
+				{name: "b", condition: batchv1.JobComplete, finished: newer},
+			},
+			wantAt:        &newer,
+			wantOK:        true,
+			wantCorrupted: v1.ConditionFalse,
+		},
+		{
+			name:     "running after a failure",
+			schedule: "@weekly",
+			jobs: []job{
+				{name: "a", condition: batchv1.JobFailed, finished: older},
+				{name: "b"},
+			},
+			wantAt:        &older,
+			wantCorrupted: v1.ConditionTrue,
+			wantEvent:     true,
+		},
+		{
+			name:          "failure already recorded",
+			schedule:      "@weekly",
+			jobs:          []job{{name: "a", condition: batchv1.JobFailed, finished: older}},
+			recorded:      &older,
+			wantAt:        &older,
+			wantCorrupted: v1.ConditionTrue,
+		},
+		{
+			name:          "new failure after a pass",
+			schedule:      "@weekly",
+			jobs:          []job{{name: "b", condition: batchv1.JobFailed, finished: newer}},
+			recorded:      &older,
+			recordedOK:    true,
+			wantAt:        &newer,
+			wantCorrupted: v1.ConditionTrue,
+			wantEvent:     true,
+		},
+		{
+			name:          "Job older than the recorded check",
+			schedule:      "@weekly",
+			jobs:          []job{{name: "a", condition: batchv1.JobFailed, finished: older}},
+			recorded:      &newer,
+			recordedOK:    true,
+			wantAt:        &newer,
+			wantOK:        true,
+			wantCorrupted: v1.ConditionFalse,
+		},
+		{
+			name:     "Job of another CronJob",
+			schedule: "@weekly",
+			jobs:     []job{{name: "a", condition: batchv1.JobFailed, finished: older, foreign: true}},
+		},
+		{
+			name:          "checks no longer scheduled",
+			jobs:          []job{{name: "a", condition: batchv1.JobFailed, finished: older}},
+			wantAt:        &older,
+			wantCorrupted: v1.ConditionTrue,
+			wantEvent:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := withIntegrityCheck(newSQLiteInstance("test"), tt.schedule)
+			cronJob := newIntegrityCheckCronJob(withIntegrityCheck(newSQLiteInstance("test"), "@weekly"))
+			cronJob.UID = types.UID("integrity-check-uid")
+			f.addKubeObject(cronJob)
+			other := cronJob.DeepCopy()
+			other.UID = types.UID("other-uid")
+			for _, j := range tt.jobs {
+				owner := cronJob
+				if j.foreign {
+					owner = other
+				}
+				f.addKubeObject(newIntegrityCheckJob(owner, j.name, j.condition, j.finished))
+			}
+			status := instance.Status.DeepCopy()
+			if tt.recorded != nil {
+				at, ok := v1.NewTime(*tt.recorded), tt.recordedOK
+				status.LastIntegrityCheck, status.IntegrityOK = &at, &ok
+			}
+			c, _, _ := f.newController(ctx)
+
+			if err := c.syncIntegrityCheckCronJob(ctx, instance, status); err != nil {
+				t.Fatalf("error syncing the integrity check CronJob: %v", err)
+			}
+
+			switch {
+			case tt.wantAt == nil:
+				if status.LastIntegrityCheck != nil || status.IntegrityOK != nil {
+					t.Errorf("expected no integrity check recorded, got %v and %v", status.LastIntegrityCheck, status.IntegrityOK)
+				}
+			case status.LastIntegrityCheck == nil || !status.LastIntegrityCheck.Time.Equal(*tt.wantAt):
+				t.Errorf("expected the check at %s to be recorded, got %v", tt.wantAt, status.LastIntegrityCheck)
+			case status.IntegrityOK == nil || *status.IntegrityOK != tt.wantOK:
+				t.Errorf("expected IntegrityOK %t, got %v", tt.wantOK, status.IntegrityOK)
+			}
+			condition := meta.FindStatusCondition(status.Conditions, kubelitedbv1.ConditionCorrupted)
+			if tt.wantCorrupted == "" {
+				if condition != nil {
+					t.Errorf("expected no %s condition, got %v", kubelitedbv1.ConditionCorrupted, condition)
+				}
+			} else if condition == nil || condition.Status != tt.wantCorrupted {
+				t.Errorf("expected the %s condition to be %s, got %v", kubelitedbv1.ConditionCorrupted, tt.wantCorrupted, condition)
+			}
+			if tt.wantEvent {
+				event := expectEvent(t, f.recorder, corev1.EventTypeWarning, ReasonIntegrityCheckFailed)
+				if !strings.Contains(event, cronJob.Name) {
+					t.Errorf("expected the event to point at CronJob %s, got %q", cronJob.Name, event)
+				}
+			} else if events := drainEvents(f.recorder); len(events) > 0 {
+				t.Errorf("expected no events, got %v", events)
+			}
+		})
+	}
+}
+
+func TestIntegrityCheckStatusSync(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := withIntegrityCheck(newSQLiteInstance("test"), "@weekly")
+	f.addInstance(instance)
+	cronJob := newIntegrityCheckCronJob(instance)
+	cronJob.UID = types.UID("integrity-check-uid")
+	f.addKubeObject(cronJob)
+	finished := time.Now().Add(-time.Hour).Truncate(time.Second)
+	f.addKubeObject(newIntegrityCheckJob(cronJob, "failed", batchv1.JobFailed, finished))
+	c, _, _ := f.newController(ctx)
+
+	f.run(ctx, c, getKey(instance, t))
+
+	got := f.getInstance(ctx, instance)
+	if got.Status.LastIntegrityCheck == nil || !got.Status.LastIntegrityCheck.Time.Equal(finished) {
+		t.Errorf("expected the check at %s to be recorded, got %v", finished, got.Status.LastIntegrityCheck)
+	}
+	if got.Status.IntegrityOK == nil || *got.Status.IntegrityOK {
+		t.Errorf("expected the failed check to be recorded, got %v", got.Status.IntegrityOK)
+	}
+	if !meta.IsStatusConditionTrue(got.Status.Conditions, kubelitedbv1.ConditionCorrupted) {
+		t.Errorf("expected the %s condition to be true, got %v", kubelitedbv1.ConditionCorrupted, got.Status.Conditions)
+	}
+	expectEvent(t, f.recorder, corev1.EventTypeWarning, ReasonIntegrityCheckFailed)
+}
+
+func TestIntegrityCheckScript(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no shell to run the integrity check script with")
+	}
+	bin := t.TempDir()
+	// The fake sqlite3 prints RESULT for the immutable snapshot, and fails
+	// with EXIT when set
+	fake := `#!/bin/sh
+[ "$1" = "file:$SNAPSHOT_PATH?immutable=1" ] || { echo "unexpected database $1" >&2; exit 2; }
+[ "$2" = "PRAGMA integrity_check" ] || { echo "unexpected statement $2" >&2; exit 2; }
+printf '%s\n' "$RESULT"
+exit "${EXIT:-0}"
+`
+	if err := os.WriteFile(filepath.Join(bin, "sqlite3"), []byte(fake), 0o755); err != nil {
+		t.Fatalf("error writing the fake sqlite3: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		result string
+		exit   string
+		want   bool
+	}{
+		{name: "ok", result: "ok", want: true},
+		{name: "corrupted", result: "*** in database main ***\nPage 5: btreeInitPage() returns error code 11"},
+		{name: "sqlite fails", result: "Error: database disk image is malformed", exit: "1"},
+		{name: "ok but sqlite fails", result: "ok", exit: "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := exec.Command(sh, "-c", integrityCheckScript)
+			cmd.Env = []string{
+				"PATH=" + bin + ":/usr/bin:/bin",
+				"SNAPSHOT_PATH=/backup/app.db",
+				"RESULT=" + tt.result,
+				"EXIT=" + tt.exit,
+			}
+			out, err := cmd.CombinedOutput()
+			if tt.want != (err == nil) {
+				t.Errorf("expected the check to pass to be %t, got %v: %s", tt.want, err, out)
+			}
+			if strings.Contains(string(out), "unexpected") {
+				t.Errorf("expected the snapshot to be checked: %s", out)
+			}
+		})
+	}
+}
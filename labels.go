@@ -0,0 +1,144 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// reservedPrefix is the prefix of the labels and annotations used by the
+// controller itself. User defined labels and annotations with this prefix are
+// ignored.
+const reservedPrefix = "kubelitedb.fortytwoapps.tech/"
+
+// The recommended labels set on every child object of a SQLiteInstance, see
+// https://kubernetes.io/docs/concepts/overview/working-with-objects/common-labels/
+const (
+	nameLabel      = "app.kubernetes.io/name"
+	instanceLabel  = "app.kubernetes.io/instance"
+	managedByLabel = "app.kubernetes.io/managed-by"
+	componentLabel = "app.kubernetes.io/component"
+)
+
+// The components of a SQLiteInstance, recorded in the component label
+const (
+	componentDatabase = "database"
+	componentReader   = "reader"
+	componentBackup   = "backup"
+	componentClone    = "clone"
+	// componentCleanup is the component of the Job purging object storage
+	// when the SQLiteInstance is deleted
+	componentCleanup = "cleanup"
+	// componentIntegrityCheck is the component of the scheduled integrity
+	// checks
+	componentIntegrityCheck = "integrity-check"
+)
+
+// labelsForInstance returns the labels of the child objects of the
+// SQLiteInstance built for the given component. These are the recommended
+// labels, merged with the selector labels of the pods of the component for the
+// database and the read-only pods. The pods of the other components don't get
+// the selector labels, so they are never selected by the Services.
+func labelsForInstance(instance *kubelitedbv1.SQLiteInstance, component string) map[string]string {
+	labels := map[string]string{
+		nameLabel:      "sqlite",
+		instanceLabel:  instance.Name,
+		managedByLabel: controllerAgentName,
+		componentLabel: component,
+	}
+	switch component {
+	case componentDatabase:
+		labels = mergeMaps(labels, podLabels(instance))
+	case componentReader:
+		labels = mergeMaps(labels, readerLabels(instance))
+	}
+	return labels
+}
+
+// childLabels returns the labels of a child object of the SQLiteInstance: the
+// labels of spec.labels merged with the labels the controller requires, which
+// can't be overwritten as they are used by selectors.
+func childLabels(instance *kubelitedbv1.SQLiteInstance, required map[string]string) map[string]string {
+	labels := map[string]string{}
+	for key, value := range instance.Spec.Labels {
+		if !strings.HasPrefix(key, reservedPrefix) {
+			labels[key] = value
+		}
+	}
+	for key, value := range required {
+		labels[key] = value
+	}
+	return labels
+}
+
+// childAnnotations returns the annotations of spec.annotations to set on the
+// child objects of the SQLiteInstance, or nil when there are none.
+func childAnnotations(instance *kubelitedbv1.SQLiteInstance) map[string]string {
+	var annotations map[string]string
+	for key, value := range instance.Spec.Annotations {
+		if strings.HasPrefix(key, reservedPrefix) {
+			continue
+		}
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[key] = value
+	}
+	return annotations
+}
+
+// metadataOutOfDate returns whether the current object lacks any of the
+// labels or annotations of the desired object.
+func metadataOutOfDate(current, desired v1.Object) bool {
+	return !containsAll(current.GetLabels(), desired.GetLabels()) ||
+		!containsAll(current.GetAnnotations(), desired.GetAnnotations())
+}
+
+// mergeMetadata sets the labels and annotations of the desired object on the
+// current object. Labels and annotations set by others are kept.
+func mergeMetadata(current, desired v1.Object) {
+	current.SetLabels(mergeMaps(current.GetLabels(), desired.GetLabels()))
+	current.SetAnnotations(mergeMaps(current.GetAnnotations(), desired.GetAnnotations()))
+}
+
+// containsAll returns whether every key of want is set to the same value in m
+func containsAll(m, want map[string]string) bool {
+	for key, value := range want {
+		if current, ok := m[key]; !ok || current != value {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeMaps returns m with the entries of other set on it
+func mergeMaps(m, other map[string]string) map[string]string {
+	if len(other) == 0 {
+		return m
+	}
+	if m == nil {
+		m = map[string]string{}
+	}
+	for key, value := range other {
+		m[key] = value
+	}
+	return m
+}
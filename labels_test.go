@@ -0,0 +1,191 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/ktesting"
+)
+
+func TestChildLabels(t *testing.T) {
+	instance := newSQLiteInstance("test")
+	instance.Spec.Labels = map[string]string{
+		"team":                    "payments",
+		"app":                     "hijacked",
+		componentLabel:            "hijacked",
+		reservedPrefix + "role":   "reader",
+		"example.com/cost-center": "42",
+	}
+	instance.Spec.Annotations = map[string]string{
+		"example.com/owner":    "payments",
+		templateHashAnnotation: "hijacked",
+	}
+
+	sts := newStatefulSet(instance)
+	objects := []v1.Object{
+		sts,
+		&sts.Spec.Template,
+		&sts.Spec.VolumeClaimTemplates[0],
+		newHeadlessService(instance),
+		newPodDisruptionBudget(instance),
+	}
+	for _, object := range objects {
+		labels := object.GetLabels()
+		if labels["team"] != "payments" || labels["example.com/cost-center"] != "42" {
+			t.Errorf("expected the labels of the spec on %T, got %v", object, labels)
+		}
+		if labels["app"] != "sqlite" || labels[componentLabel] != componentDatabase {
+			t.Errorf("expected the required labels to be kept on %T, got %v", object, labels)
+		}
+		if _, ok := labels[reservedPrefix+"role"]; ok && labels[reservedPrefix+"role"] != roleWriter {
+			t.Errorf("expected reserved labels of the spec to be dropped on %T, got %v", object, labels)
+		}
+		annotations := object.GetAnnotations()
+		if annotations["example.com/owner"] != "payments" {
+			t.Errorf("expected the annotations of the spec on %T, got %v", object, annotations)
+		}
+		if annotations[templateHashAnnotation] == "hijacked" {
+			t.Errorf("expected reserved annotations of the spec to be dropped on %T, got %v", object, annotations)
+		}
+	}
+	if want := podLabels(instance); !equality.Semantic.DeepEqual(sts.Spec.Selector.MatchLabels, want) {
+		t.Errorf("expected the selector %v to be left as is, got %v", want, sts.Spec.Selector.MatchLabels)
+	}
+}
+
+func TestSpecLabelsAddedToExistingChildren(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	service := newHeadlessService(instance)
+	service.Labels["added-by"] = "someone-else"
+	f.addKubeObject(service)
+	instance = instance.DeepCopy()
+	instance.Spec.Labels = map[string]string{"team": "payments"}
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+
+	f.run(ctx, c, getKey(instance, t))
+
+	got, err := f.kubeclient.CoreV1().Services(instance.Namespace).Get(ctx, service.Name, v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("error getting the headless Service: %v", err)
+	}
+	if got.Labels["team"] != "payments" {
+		t.Errorf("expected the labels of the spec to be added, got %v", got.Labels)
+	}
+	if got.Labels["added-by"] != "someone-else" {
+		t.Errorf("expected the labels set by others to be kept, got %v", got.Labels)
+	}
+	if got.Spec.ClusterIP != corev1.ClusterIPNone {
+		t.Errorf("expected the Service to stay headless, got %q", got.Spec.ClusterIP)
+	}
+}
+
+func TestMetadataOutOfDate(t *testing.T) {
+	tests := []struct {
+		name    string
+		current map[string]string
+		desired map[string]string
+		want    bool
+	}{
+		{name: "equal", current: map[string]string{"a": "1"}, desired: map[string]string{"a": "1"}, want: false},
+		{name: "extra labels", current: map[string]string{"a": "1", "b": "2"}, desired: map[string]string{"a": "1"}, want: false},
+		{name: "missing label", current: map[string]string{"b": "2"}, desired: map[string]string{"a": "1"}, want: true},
+		{name: "changed label", current: map[string]string{"a": "2"}, desired: map[string]string{"a": "1"}, want: true},
+		{name: "nothing desired", current: nil, desired: nil, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current := &v1.ObjectMeta{Labels: tt.current}
+			desired := &v1.ObjectMeta{Labels: tt.desired}
+
+			if got := metadataOutOfDate(current, desired); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+			mergeMetadata(current, desired)
+			if metadataOutOfDate(current, desired) {
+				t.Errorf("expected the merged labels %v to contain %v", current.Labels, tt.desired)
+			}
+		})
+	}
+}
+
+func TestLabelsForInstance(t *testing.T) {
+	recommended := map[string]string{
+		nameLabel:      "sqlite",
+		instanceLabel:  "test",
+		managedByLabel: controllerAgentName,
+	}
+	tests := []struct {
+		component string
+		// The labels set on top of the recommended ones
+		want map[string]string
+	}{
+		{component: componentDatabase, want: map[string]string{componentLabel: componentDatabase, "app": "sqlite", "controller": "test"}},
+		{component: componentReader, want: map[string]string{componentLabel: componentReader, "app": "sqlite-reader", "controller": "test", roleLabel: roleReader}},
+		{component: componentBackup, want: map[string]string{componentLabel: componentBackup}},
+		{component: componentClone, want: map[string]string{componentLabel: componentClone}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.component, func(t *testing.T) {
+			want := mergeMaps(mergeMaps(nil, recommended), tt.want)
+			if got := labelsForInstance(newSQLiteInstance("test"), tt.component); !equality.Semantic.DeepEqual(got, want) {
+				t.Errorf("expected labels %v, got %v", want, got)
+			}
+		})
+	}
+}
+
+func TestSelectorsMatchPodLabels(t *testing.T) {
+	instance := newSQLiteInstance("test")
+	instance.Spec.ReadReplicas = 1
+	sts := newStatefulSet(instance)
+	readers := newReaderDeployment(instance)
+	tests := []struct {
+		name     string
+		selector map[string]string
+		labels   map[string]string
+	}{
+		{name: "StatefulSet", selector: sts.Spec.Selector.MatchLabels, labels: sts.Spec.Template.Labels},
+		{name: "headless Service", selector: newHeadlessService(instance).Spec.Selector, labels: sts.Spec.Template.Labels},
+		{name: "PodDisruptionBudget", selector: newPodDisruptionBudget(instance).Spec.Selector.MatchLabels, labels: sts.Spec.Template.Labels},
+		{name: "reader Deployment", selector: readers.Spec.Selector.MatchLabels, labels: readers.Spec.Template.Labels},
+		{
+			name:     "read Service",
+			selector: newRoleService(instance, readServiceName(instance), readerLabels(instance)).Spec.Selector,
+			labels:   readers.Spec.Template.Labels,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if len(tt.selector) == 0 {
+				t.Fatalf("expected a selector")
+			}
+			if !containsAll(tt.labels, tt.selector) {
+				t.Errorf("expected selector %v to be a subset of the pod labels %v", tt.selector, tt.labels)
+			}
+		})
+	}
+	if containsAll(readers.Spec.Template.Labels, sts.Spec.Selector.MatchLabels) {
+		t.Errorf("expected the read-only pods not to be selected by the StatefulSet")
+	}
+}
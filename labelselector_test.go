@@ -0,0 +1,155 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2/ktesting"
+
+	"github.com/fortytwoapps/kubelitedb/pkg/generated/clientset/versioned/fake"
+)
+
+func TestLabelSelector(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector string
+		want     []string
+	}{
+		{name: "every SQLiteInstance", selector: "", want: []string{"default/payments", "default/search", "default/unlabelled"}},
+		{name: "equality", selector: "team=payments", want: []string{"default/payments"}},
+		{name: "inequality", selector: "team!=payments", want: []string{"default/search", "default/unlabelled"}},
+		{name: "existence", selector: "team", want: []string{"default/payments", "default/search"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			payments := newSQLiteInstance("payments")
+			payments.Labels = map[string]string{"team": "payments"}
+			search := newSQLiteInstance("search")
+			search.Labels = map[string]string{"team": "search"}
+			client := fake.NewSimpleClientset(payments, search, newSQLiteInstance("unlabelled"))
+			kubeclient := k8sfake.NewSimpleClientset()
+
+			// The factories are built the way main builds them for --label-selector.
+			k8sI, _, instanceI := newInformerFactories(kubeclient, client, noResyncPeriodFunc(), "", tt.selector)
+			c := NewController(ctx, kubeclient, client,
+				instanceI.Kubelitedb().V1().SQLiteInstances(),
+				k8sI.Apps().V1().StatefulSets(),
+				k8sI.Core().V1().PersistentVolumeClaims(),
+				k8sI.Apps().V1().Deployments(),
+				k8sI.Core().V1().Services(),
+				k8sI.Core().V1().ConfigMaps(),
+				k8sI.Policy().V1().PodDisruptionBudgets(),
+				k8sI.Batch().V1().CronJobs(),
+				DefaultRateLimiterOptions(),
+				ReplicaLimit{Mode: ReplicaLimitClamp},
+				0,
+				0,
+				0,
+				10*time.Minute,
+				defaultHostPathBase,
+				false,
+			)
+			instanceI.Start(ctx.Done())
+			k8sI.Start(ctx.Done())
+			if !cache.WaitForCacheSync(ctx.Done(), c.HasSynced) {
+				t.Fatal("timed out waiting for the informer caches to sync")
+			}
+
+			cached, err := c.sqliteInstancesLister.List(labels.Everything())
+			if err != nil {
+				t.Fatalf("error listing the cached SQLiteInstances: %v", err)
+			}
+			var got []string
+			for _, instance := range cached {
+				got = append(got, getKey(instance, t))
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expected the SQLiteInstances %v to be cached, got %v", tt.want, got)
+			}
+
+			err = wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+				return c.workqueue.Len() >= len(tt.want), nil
+			})
+			if err != nil {
+				t.Fatalf("timed out waiting for the SQLiteInstances to be queued: %v", err)
+			}
+			var queued []string
+			for c.workqueue.Len() > 0 {
+				key, _ := c.workqueue.Get()
+				queued = append(queued, key.(string))
+				c.workqueue.Done(key)
+			}
+			slices.Sort(queued)
+			if !slices.Equal(queued, tt.want) {
+				t.Errorf("expected the SQLiteInstances %v to be queued, got %v", tt.want, queued)
+			}
+		})
+	}
+}
+
+func TestBackupLabelSelector(t *testing.T) {
+	tests := []struct {
+		name     string
+		labels   map[string]string
+		wantJobs int
+	}{
+		{name: "matching SQLiteInstance", labels: map[string]string{"team": "payments"}, wantJobs: 1},
+		{name: "other SQLiteInstance", labels: map[string]string{"team": "search"}},
+		{name: "unlabelled SQLiteInstance"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newBackupFixture(t)
+			f.instanceSelector = labels.SelectorFromSet(labels.Set{"team": "payments"})
+			instance := newSQLiteInstance("test")
+			instance.Labels = tt.labels
+			backup := newSQLiteBackup("nightly", instance.Name)
+			f.addInstance(instance)
+			f.addBackup(backup)
+			c := f.newController(ctx)
+
+			got := f.run(ctx, c, backup)
+
+			var jobs int
+			for _, action := range writes(f.kubeclient.Actions(), "jobs") {
+				if action.GetVerb() == "create" {
+					jobs++
+				}
+			}
+			if jobs != tt.wantJobs {
+				t.Errorf("expected %d backup Jobs, got %d", tt.wantJobs, jobs)
+			}
+			// Backups of other SQLiteInstances are left to their controller
+			if tt.wantJobs == 0 && (got.Status.Phase != "" || len(got.Status.Conditions) != 0) {
+				t.Errorf("expected the status to be left alone, got %+v", got.Status)
+			}
+		})
+	}
+}
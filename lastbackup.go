@@ -0,0 +1,93 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+const (
+	// ReasonBackupStale is used as the condition reason when the last
+	// successful backup is older than spec.backupStaleAfter
+	ReasonBackupStale = "BackupStale"
+	// ReasonNoBackup is used as the condition reason when the database was
+	// never backed up
+	ReasonNoBackup = "NoBackup"
+	// ReasonBackupRecent is used as the condition reason when the last
+	// successful backup is recent enough
+	ReasonBackupRecent = "BackupRecent"
+
+	// MessageBackupStale is the message used when the last successful backup
+	// is older than spec.backupStaleAfter
+	MessageBackupStale = "The last backup completed at %s, more than %s ago"
+	// MessageNoBackup is the message used when the database was never backed
+	// up
+	MessageNoBackup = "The database was never backed up"
+)
+
+// recordBackup records a successful backup in the status, unless a more
+// recent one is recorded already. It returns whether the status changed.
+func recordBackup(status *kubelitedbv1.SQLiteInstanceStatus, completed v1.Time, location string) bool {
+	if status.LastBackupTime != nil && !status.LastBackupTime.Before(&completed) {
+		return false
+	}
+	status.LastBackupTime = &completed
+	status.LastBackupLocation = location
+	return true
+}
+
+// setBackupStaleCondition sets the BackupStale condition of the
+// SQLiteInstance from the last successful backup, and returns how long until
+// a recent backup becomes stale. The condition is removed when
+// spec.backupStaleAfter is not set.
+func setBackupStaleCondition(status *kubelitedbv1.SQLiteInstanceStatus, instance *kubelitedbv1.SQLiteInstance, now time.Time) time.Duration {
+	if instance.Spec.BackupStaleAfter == nil {
+		meta.RemoveStatusCondition(&status.Conditions, kubelitedbv1.ConditionBackupStale)
+		return 0
+	}
+	staleAfter := instance.Spec.BackupStaleAfter.Duration
+	if status.LastBackupTime == nil {
+		setCondition(status, instance, kubelitedbv1.ConditionBackupStale, v1.ConditionTrue, ReasonNoBackup, MessageNoBackup)
+		return 0
+	}
+	staleAt := status.LastBackupTime.Add(staleAfter)
+	if !now.Before(staleAt) {
+		msg := fmt.Sprintf(MessageBackupStale, status.LastBackupTime.UTC().Format(time.RFC3339), staleAfter)
+		setCondition(status, instance, kubelitedbv1.ConditionBackupStale, v1.ConditionTrue, ReasonBackupStale, msg)
+		return 0
+	}
+	setCondition(status, instance, kubelitedbv1.ConditionBackupStale, v1.ConditionFalse, ReasonBackupRecent, "")
+	return staleAt.Sub(now)
+}
+
+// recordInstanceBackup records a completed SQLiteBackup in the status of the
+// SQLiteInstance it backed up.
+func (c *BackupController) recordInstanceBackup(ctx context.Context, sqliteInstance *kubelitedbv1.SQLiteInstance, completed v1.Time, location string) error {
+	sqliteInstanceCopy := sqliteInstance.DeepCopy()
+	if !recordBackup(&sqliteInstanceCopy.Status, completed, location) {
+		return nil
+	}
+	_, err := c.kubelitedbclientset.KubelitedbV1().SQLiteInstances(sqliteInstance.Namespace).UpdateStatus(ctx, sqliteInstanceCopy, c.writer().updateOptions(ctx, sqliteInstance, "SQLiteInstance", sqliteInstance, sqliteInstanceCopy))
+	return err
+}
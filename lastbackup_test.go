@@ -0,0 +1,207 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+func TestRecordBackup(t *testing.T) {
+	older := v1.NewTime(time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC))
+	newer := v1.NewTime(time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC))
+	tests := []struct {
+		name         string
+		recorded     *v1.Time
+		completed    v1.Time
+		wantChanged  bool
+		wantTime     v1.Time
+		wantLocation string
+	}{
+		{name: "first backup", completed: newer, wantChanged: true, wantTime: newer, wantLocation: "s3://backups/new"},
+		{name: "more recent backup", recorded: &older, completed: newer, wantChanged: true, wantTime: newer, wantLocation: "s3://backups/new"},
+		{name: "older backup", recorded: &newer, completed: older, wantTime: newer, wantLocation: "s3://backups/old"},
+		{name: "same backup", recorded: &newer, completed: newer, wantTime: newer, wantLocation: "s3://backups/old"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := &kubelitedbv1.SQLiteInstanceStatus{LastBackupTime: tt.recorded}
+			if tt.recorded != nil {
+				status.LastBackupLocation = "s3://backups/old"
+			}
+
+			if got := recordBackup(status, tt.completed, "s3://backups/new"); got != tt.wantChanged {
+				t.Errorf("expected changed %t, got %t", tt.wantChanged, got)
+			}
+			if status.LastBackupTime == nil || !status.LastBackupTime.Equal(&tt.wantTime) || status.LastBackupLocation != tt.wantLocation {
+				t.Errorf("expected the backup of %v at %q, got %v at %q", tt.wantTime, tt.wantLocation, status.LastBackupTime, status.LastBackupLocation)
+			}
+		})
+	}
+}
+
+func TestSetBackupStaleCondition(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	recent := v1.NewTime(now.Add(-time.Hour))
+	stale := v1.NewTime(now.Add(-25 * time.Hour))
+	tests := []struct {
+		name        string
+		staleAfter  *v1.Duration
+		lastBackup  *v1.Time
+		wantStatus  v1.ConditionStatus
+		wantReason  string
+		wantStaleIn time.Duration
+	}{
+		{name: "not configured", lastBackup: &stale},
+		{name: "never backed up", staleAfter: &v1.Duration{Duration: 24 * time.Hour}, wantStatus: v1.ConditionTrue, wantReason: ReasonNoBackup},
+		{
+			name:        "recent",
+			staleAfter:  &v1.Duration{Duration: 24 * time.Hour},
+			lastBackup:  &recent,
+			wantStatus:  v1.ConditionFalse,
+			wantReason:  ReasonBackupRecent,
+			wantStaleIn: 23 * time.Hour,
+		},
+		{
+			name:       "at the threshold",
+			staleAfter: &v1.Duration{Duration: time.Hour},
+			lastBackup: &recent,
+			wantStatus: v1.ConditionTrue,
+			wantReason: ReasonBackupStale,
+		},
+		{
+			name:       "stale",
+			staleAfter: &v1.Duration{Duration: 24 * time.Hour},
+			lastBackup: &stale,
+			wantStatus: v1.ConditionTrue,
+			wantReason: ReasonBackupStale,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newSQLiteInstance("test")
+			instance.Spec.BackupStaleAfter = tt.staleAfter
+			status := &kubelitedbv1.SQLiteInstanceStatus{
+				LastBackupTime: tt.lastBackup,
+				// Left over from before the threshold was removed
+				Conditions: []v1.Condition{{Type: kubelitedbv1.ConditionBackupStale, Status: v1.ConditionTrue, Reason: ReasonBackupStale}},
+			}
+
+			if got := setBackupStaleCondition(status, instance, now); got != tt.wantStaleIn {
+				t.Errorf("expected the backup to become stale in %s, got %s", tt.wantStaleIn, got)
+			}
+
+			condition := meta.FindStatusCondition(status.Conditions, kubelitedbv1.ConditionBackupStale)
+			if tt.wantReason == "" {
+				if condition != nil {
+					t.Errorf("expected no %s condition, got %v", kubelitedbv1.ConditionBackupStale, condition)
+				}
+				return
+			}
+			if condition == nil || condition.Status != tt.wantStatus || condition.Reason != tt.wantReason {
+				t.Errorf("expected condition %s with reason %s, got %v", tt.wantStatus, tt.wantReason, condition)
+			}
+		})
+	}
+}
+
+func TestBackupCompletionRecordedOnInstance(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newBackupFixture(t)
+	completed := v1.NewTime(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	instance := newSQLiteInstance("test")
+	backup := newSQLiteBackup("nightly", instance.Name)
+	job := newBackupJob(backup, instance)
+	job.Status = batchv1.JobStatus{
+		CompletionTime: &completed,
+		Conditions:     []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}},
+	}
+	f.addInstance(instance)
+	f.addBackup(backup)
+	f.addJob(job)
+	c := f.newController(ctx)
+
+	f.run(ctx, c, backup)
+
+	got, err := f.client.KubelitedbV1().SQLiteInstances(instance.Namespace).Get(ctx, instance.Name, v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("error getting the SQLiteInstance: %v", err)
+	}
+	if got.Status.LastBackupTime == nil || !got.Status.LastBackupTime.Equal(&completed) {
+		t.Errorf("expected the last backup at %v, got %v", completed, got.Status.LastBackupTime)
+	}
+	if want := "s3://backups/default/test/nightly/app.db"; got.Status.LastBackupLocation != want {
+		t.Errorf("expected the last backup location %q, got %q", want, got.Status.LastBackupLocation)
+	}
+}
+
+func TestScheduledBackupRecorded(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	lastSuccessful := v1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	instance := withBackupSchedule(newSQLiteInstance("test"), "0 3 * * *")
+	cronJob := newBackupCronJob(instance)
+	cronJob.Status.LastSuccessfulTime = &lastSuccessful
+	f.addKubeObject(cronJob)
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+
+	f.run(ctx, c, getKey(instance, t))
+
+	got := f.getInstance(ctx, instance)
+	if got.Status.LastBackupTime == nil || !got.Status.LastBackupTime.Equal(&lastSuccessful) {
+		t.Errorf("expected the last backup at %v, got %v", lastSuccessful, got.Status.LastBackupTime)
+	}
+	if want := scheduledBackupURL(instance) + "/"; got.Status.LastBackupLocation != want {
+		t.Errorf("expected the last backup location %q, got %q", want, got.Status.LastBackupLocation)
+	}
+}
+
+func TestBackupStaleRequeue(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	lastBackup := v1.NewTime(time.Now().Add(-30 * time.Minute))
+	instance := newSQLiteInstance("test")
+	instance.Spec.BackupStaleAfter = &v1.Duration{Duration: time.Hour}
+	instance.Status.LastBackupTime = &lastBackup
+	statefulSet := newStatefulSet(instance)
+	statefulSet.Status.Replicas = 1
+	statefulSet.Status.ReadyReplicas = 1
+	statefulSet.Status.UpdatedReplicas = 1
+	f.addKubeObject(statefulSet)
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+
+	result := f.run(ctx, c, getKey(instance, t))
+
+	// The SQLiteInstance is synced again once the backup would become stale
+	if result.RequeueAfter < 29*time.Minute || result.RequeueAfter > 30*time.Minute {
+		t.Errorf("expected a requeue within 30m, got %s", result.RequeueAfter)
+	}
+	condition := meta.FindStatusCondition(f.getInstance(ctx, instance).Status.Conditions, kubelitedbv1.ConditionBackupStale)
+	if condition == nil || condition.Status != v1.ConditionFalse {
+		t.Errorf("expected the backup not to be stale, got %v", condition)
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+
+	"github.com/fortytwoapps/kubelitedb/pkg/metrics"
+)
+
+// serviceAccountNamespaceFile holds the namespace of the pod the controller
+// runs in, when running in-cluster.
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// runWithLeaderElection blocks until the lease in namespace is acquired and
+// then calls run. The context passed to run is cancelled when leadership is
+// lost, so only a single replica of the controller reconciles at a time.
+// Leadership transitions are recorded as Events on the lease.
+func runWithLeaderElection(ctx context.Context, kubeClient kubernetes.Interface, namespace, name string, run func(context.Context)) {
+	logger := klog.FromContext(ctx)
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		logger.Error(err, "Error getting hostname")
+		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+	}
+	// Add a unique suffix so replicas sharing a hostname don't share an identity
+	identity := hostname + "_" + string(uuid.NewUUID())
+
+	lock, err := resourcelock.New(resourcelock.LeasesResourceLock, namespace, name,
+		kubeClient.CoreV1(), kubeClient.CoordinationV1(),
+		resourcelock.ResourceLockConfig{
+			Identity:      identity,
+			EventRecorder: newEventRecorder(ctx, kubeClient, controllerAgentName),
+		})
+	if err != nil {
+		logger.Error(err, "Error creating leader election lock")
+		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		ReleaseOnCancel: true,
+		LeaseDuration:   15 * time.Second,
+		RenewDeadline:   10 * time.Second,
+		RetryPeriod:     2 * time.Second,
+		Callbacks:       newLeaderCallbacks(ctx, identity, cancel, run),
+	})
+}
+
+// newLeaderCallbacks returns the leader election callbacks calling run once
+// leadership is acquired, and cancelling its context once it is lost. The
+// IsLeader metric follows the leadership, to tell flapping leaders apart.
+func newLeaderCallbacks(ctx context.Context, identity string, cancel context.CancelFunc, run func(context.Context)) leaderelection.LeaderCallbacks {
+	logger := klog.FromContext(ctx)
+	return leaderelection.LeaderCallbacks{
+		OnStartedLeading: func(ctx context.Context) {
+			logger.Info("Started leading", "identity", identity)
+			metrics.IsLeader.Set(1)
+			run(ctx)
+		},
+		OnStoppedLeading: func() {
+			logger.Info("Stopped leading", "identity", identity)
+			metrics.IsLeader.Set(0)
+			cancel()
+		},
+		OnNewLeader: func(leader string) {
+			if leader != identity {
+				logger.Info("New leader elected", "leader", leader)
+			}
+		},
+	}
+}
+
+// detectNamespace returns the namespace the controller is running in, falling
+// back to the default namespace when running out-of-cluster.
+func detectNamespace() string {
+	if data, err := os.ReadFile(serviceAccountNamespaceFile); err == nil {
+		if namespace := strings.TrimSpace(string(data)); namespace != "" {
+			return namespace
+		}
+	}
+	return "default"
+}
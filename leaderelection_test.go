@@ -0,0 +1,149 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/klog/v2/ktesting"
+
+	"github.com/fortytwoapps/kubelitedb/pkg/metrics"
+)
+
+func TestLeaderCallbacks(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	metrics.IsLeader.Set(0)
+	var ran bool
+	var leading float64
+	callbacks := newLeaderCallbacks(ctx, "me", cancel, func(ctx context.Context) {
+		ran = true
+		leading = testutil.ToFloat64(metrics.IsLeader)
+	})
+
+	callbacks.OnStartedLeading(ctx)
+	if !ran {
+		t.Errorf("expected run to be called once leading")
+	}
+	if leading != 1 {
+		t.Errorf("expected the is_leader gauge to be 1 while leading, got %v", leading)
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("expected the context to be alive while leading")
+	}
+
+	callbacks.OnStoppedLeading()
+	if ctx.Err() == nil {
+		t.Errorf("expected the context to be cancelled once leadership is lost")
+	}
+	if got := testutil.ToFloat64(metrics.IsLeader); got != 0 {
+		t.Errorf("expected the is_leader gauge to be 0 once leadership is lost, got %v", got)
+	}
+}
+
+func TestLeaderCallbacksFlapping(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	callbacks := newLeaderCallbacks(ctx, "me", cancel, func(context.Context) {})
+
+	// The gauge follows every transition of a flapping leader
+	for i := 0; i < 3; i++ {
+		callbacks.OnStartedLeading(ctx)
+		if got := testutil.ToFloat64(metrics.IsLeader); got != 1 {
+			t.Errorf("transition %d: expected the is_leader gauge to be 1 once leading, got %v", i, got)
+		}
+		callbacks.OnStoppedLeading()
+		if got := testutil.ToFloat64(metrics.IsLeader); got != 0 {
+			t.Errorf("transition %d: expected the is_leader gauge to be 0 once stopped, got %v", i, got)
+		}
+	}
+}
+
+func TestRunWithLeaderElection(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	kubeClient := k8sfake.NewSimpleClientset()
+
+	started := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runWithLeaderElection(ctx, kubeClient, v1.NamespaceDefault, "kubelitedb", func(ctx context.Context) {
+			close(started)
+			<-ctx.Done()
+		})
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("expected the lease to be acquired")
+	}
+	lease, err := kubeClient.CoordinationV1().Leases(v1.NamespaceDefault).Get(ctx, "kubelitedb", v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the lease to be created: %v", err)
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity == "" {
+		t.Fatalf("expected the lease to be held, got %+v", lease.Spec)
+	}
+	if got := testutil.ToFloat64(metrics.IsLeader); got != 1 {
+		t.Errorf("expected the is_leader gauge to be 1 while leading, got %v", got)
+	}
+
+	// The Events are recorded asynchronously
+	var message string
+	err = wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, wait.ForeverTestTimeout, true, func(ctx context.Context) (bool, error) {
+		events, err := kubeClient.CoreV1().Events(v1.NamespaceDefault).List(ctx, v1.ListOptions{})
+		if err != nil {
+			return false, err
+		}
+		for _, event := range events.Items {
+			if event.InvolvedObject.Name == lease.Name && event.Reason == "LeaderElection" {
+				message = event.Message
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		t.Fatalf("expected a LeaderElection Event on the lease: %v", err)
+	}
+	if want := *lease.Spec.HolderIdentity + " became leader"; !strings.Contains(message, want) {
+		t.Errorf("expected the Event message to contain %q, got %q", want, message)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("expected leader election to stop once the context is cancelled")
+	}
+	if got := testutil.ToFloat64(metrics.IsLeader); got != 0 {
+		t.Errorf("expected the is_leader gauge to be 0 once stopped, got %v", got)
+	}
+}
@@ -0,0 +1,36 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+
+	"k8s.io/klog/v2"
+)
+
+// logWrite logs the outcome of creating, updating or deleting a child object
+// of the SQLiteInstance being synced, with the values of the logger of the
+// sync. The child is logged as the object, as its name would shadow the name
+// of the SQLiteInstance.
+func logWrite(ctx context.Context, action, kind, name string, err error) {
+	logger := klog.FromContext(ctx).V(2)
+	if err != nil {
+		logger.Info("Failed to write child object", "action", action, "kind", kind, "object", name, "err", err)
+		return
+	}
+	logger.Info("Wrote child object", "action", action, "kind", kind, "object", name)
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	core "k8s.io/client-go/testing"
+	"k8s.io/klog/v2"
+	"k8s.io/klog/v2/ktesting"
+)
+
+// newVerboseTestContext returns a context whose logger keeps the log entries
+// up to the given verbosity, along with the function returning them.
+func newVerboseTestContext(t *testing.T, verbosity int) (context.Context, func() string) {
+	logger := ktesting.NewLogger(t, ktesting.NewConfig(ktesting.BufferLogs(true), ktesting.Verbosity(verbosity)))
+	buffer := logger.GetSink().(ktesting.Underlier).GetBuffer()
+	return klog.NewContext(context.Background(), logger), buffer.String
+}
+
+func TestSyncLogsContext(t *testing.T) {
+	tests := []struct {
+		name   string
+		fail   bool
+		logged [][]string
+	}{
+		{
+			name: "written",
+			logged: [][]string{
+				{"Wrote child object", `action="create"`, `kind="Service"`, `object="test-sqlite"`},
+				{"Wrote child object", `action="create"`, `kind="StatefulSet"`, `object="test-sqlite"`},
+			},
+		},
+		{
+			name: "failed",
+			fail: true,
+			logged: [][]string{
+				{"Failed to write child object", `action="create"`, `kind="StatefulSet"`, `object="test-sqlite"`, `err="injected error"`},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, logs := newVerboseTestContext(t, 2)
+			f := newFixture(t)
+			instance := newSQLiteInstance("test")
+			f.addInstance(instance)
+			c, _, _ := f.newController(ctx)
+			if tt.fail {
+				f.kubeclient.PrependReactor("create", "statefulsets", func(action core.Action) (bool, runtime.Object, error) {
+					return true, nil, fmt.Errorf("injected error")
+				})
+			}
+
+			if _, err := c.syncHandler(ctx, getKey(instance, t)); (err != nil) != tt.fail {
+				t.Fatalf("expected the sync to fail %t, got %v", tt.fail, err)
+			}
+
+			for _, want := range tt.logged {
+				if !logged(logs(), want...) {
+					t.Errorf("expected a log line with %v, got:\n%s", want, logs())
+				}
+			}
+			// Every line logged during the sync carries the SQLiteInstance
+			wantContext := []string{`namespace="default"`, `name="test"`, "generation=1", `phase=""`}
+			for _, line := range strings.Split(logs(), "\n") {
+				if !strings.Contains(line, "child object") {
+					continue
+				}
+				if !logged(line, wantContext...) {
+					t.Errorf("expected %v in the log line %q", wantContext, line)
+				}
+			}
+		})
+	}
+}
+
+func TestLogWriteVerbosity(t *testing.T) {
+	tests := []struct {
+		verbosity int
+		want      bool
+	}{
+		{verbosity: 1},
+		{verbosity: 2, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.verbosity), func(t *testing.T) {
+			ctx, logs := newVerboseTestContext(t, tt.verbosity)
+
+			logWrite(ctx, "update", "ConfigMap", "test-pragmas", nil)
+
+			if got := logged(logs(), "Wrote child object", `object="test-pragmas"`); got != tt.want {
+				t.Errorf("expected the write to be logged %t at verbosity %d, got %t:\n%s", tt.want, tt.verbosity, got, logs())
+			}
+		})
+	}
+}
@@ -17,25 +17,93 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"net/http"
+	"os"
 	"time"
+	// The time zones of maintenance windows are loaded from the embedded
+	// database, so they don't depend on the zoneinfo of the image.
+	_ "time/tzdata"
 
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
 
 	clientset "github.com/fortytwoapps/kubelitedb/pkg/generated/clientset/versioned"
 	informers "github.com/fortytwoapps/kubelitedb/pkg/generated/informers/externalversions"
+	"github.com/fortytwoapps/kubelitedb/pkg/metrics"
 	"github.com/fortytwoapps/kubelitedb/pkg/signals"
+	"github.com/fortytwoapps/kubelitedb/pkg/validation"
+	"github.com/fortytwoapps/kubelitedb/pkg/webhook"
 )
 
 var (
 	masterURL  string
 	kubeconfig string
+
+	watchNamespace string
+	labelSelector  string
+	resyncPeriod   time.Duration
+	resyncJitter   time.Duration
+
+	debounceWindow          time.Duration
+	rolloutProgressDeadline time.Duration
+
+	webhookBindAddress string
+	tlsCertFile        string
+	tlsPrivateKeyFile  string
+
+	defaultStorageClassName string
+
+	metricsBindAddress     string
+	healthProbeBindAddress string
+
+	enableLeaderElection    bool
+	leaderElectionNamespace string
+	leaderElectionID        string
+
+	rateLimiterOptions = DefaultRateLimiterOptions()
+	replicaLimit       = ReplicaLimit{Mode: ReplicaLimitClamp}
+
+	storagePressureThreshold int
+
+	hostPathBase string
+
+	dryRun bool
+
+	workers         int
+	shutdownTimeout time.Duration
+
+	maxConcurrentAPICalls int
 )
 
+// defaultImageEnv is the environment variable --default-image defaults to
+const defaultImageEnv = "KUBELITEDB_DEFAULT_IMAGE"
+
+// defaultImageFromEnv returns the image set in defaultImageEnv, or fallback
+// when it is not set.
+func defaultImageFromEnv(fallback string) string {
+	if image := os.Getenv(defaultImageEnv); image != "" {
+		return image
+	}
+	return fallback
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(validateCommand(os.Stdout, os.Stderr, os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "render" {
+		os.Exit(renderCommand(os.Stdout, os.Stderr, os.Args[2:]))
+	}
+
 	klog.InitFlags(nil)
 	flag.Parse()
 
@@ -43,6 +111,33 @@ func main() {
 	ctx := signals.SetupSignalHandler()
 	logger := klog.FromContext(ctx)
 
+	if workers < 1 {
+		logger.Error(nil, "Invalid number of workers, must be at least 1", "workers", workers)
+		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+	}
+	logger.Info("Configured controller workers", "workers", workers)
+	if errs := validation.ValidateImage(defaultImage, field.NewPath("defaultImage")); len(errs) > 0 {
+		logger.Error(errs.ToAggregate(), "Invalid default image", "image", defaultImage)
+		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+	}
+	if maxConcurrentAPICalls < 0 {
+		logger.Error(nil, "Invalid maximum of concurrent API calls, must be at least 0", "maxConcurrentAPICalls", maxConcurrentAPICalls)
+		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+	}
+	if storagePressureThreshold < 0 || storagePressureThreshold > 100 {
+		logger.Error(nil, "Invalid storage pressure threshold, must be between 0 and 100", "threshold", storagePressureThreshold)
+		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+	}
+	if err := replicaLimit.Valid(); err != nil {
+		logger.Error(err, "Invalid replica limit")
+		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+	}
+	instanceSelector, err := labels.Parse(labelSelector)
+	if err != nil {
+		logger.Error(err, "Invalid label selector", "selector", labelSelector)
+		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+	}
+
 	cfg, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfig)
 	if err != nil {
 		logger.Error(err, "Error building kubeconfig")
@@ -61,25 +156,213 @@ func main() {
 		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
 	}
 
-	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, time.Second*30)
-	kubeLiteDBInformerFactory := informers.NewSharedInformerFactory(kubeLiteDBClient, time.Second*30)
+	// The child objects are written through a separate clientset, so the
+	// limit doesn't hold back renewing the leader election lease.
+	childCfg := rest.CopyConfig(cfg)
+	childCfg.Wrap(limitConcurrentWrites(maxConcurrentAPICalls))
+	childClient, err := kubernetes.NewForConfig(childCfg)
+	if err != nil {
+		logger.Error(err, "Error building kubernetes clientset")
+		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+	}
 
-	controller := NewController(ctx, kubeClient, kubeLiteDBClient,
+	kubeInformerFactory, kubeLiteDBInformerFactory, instanceInformerFactory := newInformerFactories(kubeClient, kubeLiteDBClient, resyncPeriod, watchNamespace, labelSelector)
+
+	controller := NewController(ctx, childClient, kubeLiteDBClient,
+		instanceInformerFactory.Kubelitedb().V1().SQLiteInstances(),
+		kubeInformerFactory.Apps().V1().StatefulSets(),
+		kubeInformerFactory.Core().V1().PersistentVolumeClaims(),
+		kubeInformerFactory.Apps().V1().Deployments(),
+		kubeInformerFactory.Core().V1().Services(),
+		kubeInformerFactory.Core().V1().ConfigMaps(),
+		kubeInformerFactory.Policy().V1().PodDisruptionBudgets(),
+		kubeInformerFactory.Batch().V1().CronJobs(),
+		rateLimiterOptions,
+		replicaLimit,
+		storagePressureThreshold,
+		resyncJitter,
+		debounceWindow,
+		rolloutProgressDeadline,
+		hostPathBase,
+		dryRun,
+	)
+	backupController := NewBackupController(ctx, childClient, kubeLiteDBClient,
+		kubeLiteDBInformerFactory.Kubelitedb().V1().SQLiteBackups(),
 		kubeLiteDBInformerFactory.Kubelitedb().V1().SQLiteInstances(),
+		kubeInformerFactory.Batch().V1().Jobs(),
+		instanceSelector,
+		dryRun,
 	)
 
 	// notice that there is no need to run Start methods in a separate goroutine.
 	// Start method is non-blocking and runs all registered informers in a dedicated goroutine.
 	kubeInformerFactory.Start(ctx.Done())
 	kubeLiteDBInformerFactory.Start(ctx.Done())
+	instanceInformerFactory.Start(ctx.Done())
+
+	if metricsBindAddress != "" {
+		go serveMetrics(ctx, metricsBindAddress)
+	}
+	if healthProbeBindAddress != "" {
+		go serveHealthProbes(ctx, healthProbeBindAddress, controller.HasSynced)
+	}
+
+	// The admission webhooks are only served when a certificate is provided.
+	if tlsCertFile != "" && tlsPrivateKeyFile != "" {
+		webhookServer := webhook.NewServer(webhook.Config{
+			Addr:                    webhookBindAddress,
+			CertFile:                tlsCertFile,
+			KeyFile:                 tlsPrivateKeyFile,
+			DefaultStorageClassName: defaultStorageClassName,
+			// Every SQLiteInstance is checked, including those reconciled
+			// by another controller.
+			SQLiteInstances: kubeLiteDBInformerFactory.Kubelitedb().V1().SQLiteInstances().Lister(),
+		})
+		go func() {
+			if err := webhookServer.Run(ctx); err != nil {
+				logger.Error(err, "Error running webhook server")
+				klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+			}
+		}()
+	}
+
+	run := func(ctx context.Context) {
+		go func() {
+			if err := backupController.Run(ctx, 1); err != nil {
+				logger.Error(err, "Error running backup controller")
+				klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+			}
+		}()
+		if err := controller.Run(ctx, workers, shutdownTimeout); err != nil {
+			logger.Error(err, "Error running controller")
+			klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+		}
+	}
+
+	if !enableLeaderElection {
+		metrics.IsLeader.Set(1)
+		run(ctx)
+		return
+	}
+
+	namespace := leaderElectionNamespace
+	if namespace == "" {
+		namespace = detectNamespace()
+	}
+	runWithLeaderElection(ctx, kubeClient, namespace, leaderElectionID, run)
+}
 
-	if err = controller.Run(ctx, 2); err != nil {
-		logger.Error(err, "Error running controller")
+// newInformerFactories returns the informer factories of the Kubernetes
+// objects, of the kubelitedb objects and of the SQLiteInstances reconciled by
+// the controller. The informers watch every namespace unless namespace is set.
+// Every resync reconciles all SQLiteInstances again.
+func newInformerFactories(kubeClient kubernetes.Interface, kubeLiteDBClient clientset.Interface, resync time.Duration, namespace, selector string) (kubeinformers.SharedInformerFactory, informers.SharedInformerFactory, informers.SharedInformerFactory) {
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, resync, kubeinformers.WithNamespace(namespace))
+	kubeLiteDBInformerFactory := informers.NewSharedInformerFactoryWithOptions(kubeLiteDBClient, resync, informers.WithNamespace(namespace))
+	// Only the SQLiteInstances matching the selector are reconciled, the
+	// others are never seen by the controller.
+	instanceInformerFactory := informers.NewSharedInformerFactoryWithOptions(kubeLiteDBClient, resync,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *v1.ListOptions) {
+			options.LabelSelector = selector
+		}))
+	return kubeInformerFactory, kubeLiteDBInformerFactory, instanceInformerFactory
+}
+
+// serveMetrics serves the Prometheus metrics of the controller on addr until
+// the context is cancelled.
+func serveMetrics(ctx context.Context, addr string) {
+	logger := klog.FromContext(ctx)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	logger.Info("Starting metrics server", "address", addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error(err, "Error serving metrics")
+		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+	}
+}
+
+// serveHealthProbes serves the health probes of the controller on addr until
+// the context is cancelled.
+func serveHealthProbes(ctx context.Context, addr string, ready func() bool) {
+	logger := klog.FromContext(ctx)
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           healthProbeHandler(ready),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	logger.Info("Starting health probe server", "address", addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error(err, "Error serving health probes")
 		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
 	}
 }
 
+// healthProbeHandler serves /healthz, which succeeds as long as the process is
+// running, and /readyz, which only succeeds once ready returns true.
+func healthProbeHandler(ready func() bool) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !ready() {
+			http.Error(w, "informer caches not synced", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	return mux
+}
+
 func init() {
 	flag.StringVar(&kubeconfig, "kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
 	flag.StringVar(&masterURL, "master", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
+	flag.StringVar(&watchNamespace, "namespace", "", "The namespace the controller watches SQLiteInstances in. Watches every namespace when unset.")
+	flag.StringVar(&labelSelector, "label-selector", "", "Only reconcile the SQLiteInstances matching this label selector, and the SQLiteBackups of them, for example to split SQLiteInstances between several controllers. Every SQLiteInstance is reconciled when unset.")
+	flag.DurationVar(&resyncPeriod, "resync-period", 10*time.Minute, "How often every SQLiteInstance is reconciled again, correcting drift missed by watch events. Shorter periods put more load on the API server. Set to 0 to disable periodic resyncs.")
+	flag.DurationVar(&resyncJitter, "resync-jitter", 30*time.Second, "The longest a periodic resync of a SQLiteInstance is randomly delayed by, spreading the reconciles of a resync out. Changes to SQLiteInstances are reconciled immediately. Set to 0 to reconcile resyncs immediately as well.")
+	flag.StringVar(&webhookBindAddress, "webhook-bind-address", ":9443", "The address the admission webhook server binds to.")
+	flag.StringVar(&tlsCertFile, "tls-cert-file", "", "File containing the x509 certificate for serving the admission webhooks. The webhooks are disabled when unset.")
+	flag.StringVar(&tlsPrivateKeyFile, "tls-private-key-file", "", "File containing the x509 private key matching --tls-cert-file.")
+	flag.StringVar(&metricsBindAddress, "metrics-bind-address", ":8080", "The address the metrics endpoint binds to. Set to an empty string to disable it.")
+	flag.StringVar(&healthProbeBindAddress, "health-probe-bind-address", ":8081", "The address the /healthz and /readyz endpoints bind to. Set to an empty string to disable them.")
+	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false, "Enable leader election, ensuring only one replica of the controller is active at a time.")
+	flag.StringVar(&leaderElectionNamespace, "leader-election-namespace", "", "The namespace of the leader election lease. Defaults to the namespace the controller runs in.")
+	flag.StringVar(&leaderElectionID, "leader-election-id", "kubelitedb-controller", "The name of the leader election lease.")
+	flag.DurationVar(&rateLimiterOptions.BaseDelay, "requeue-base-delay", rateLimiterOptions.BaseDelay, "The delay before a failed SQLiteInstance is retried, doubled on every further failure.")
+	flag.DurationVar(&rateLimiterOptions.MaxDelay, "requeue-max-delay", rateLimiterOptions.MaxDelay, "The maximum delay before a failed SQLiteInstance is retried.")
+	flag.Float64Var(&rateLimiterOptions.QPS, "requeue-qps", rateLimiterOptions.QPS, "The overall rate at which failed SQLiteInstances are retried.")
+	flag.IntVar(&rateLimiterOptions.Burst, "requeue-burst", rateLimiterOptions.Burst, "The number of failed SQLiteInstances that can be retried at once above --requeue-qps.")
+	flag.IntVar(&workers, "workers", 2, "The number of SQLiteInstances reconciled concurrently. Must be at least 1.")
+	flag.IntVar(&maxConcurrentAPICalls, "max-concurrent-api-calls", 0, "The most requests creating, updating or deleting child objects the controller has in flight at a time, across all workers. Set to 0 to not limit them.")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", 0, "How long the in-flight and queued reconciles get to finish on shutdown. The controller shuts down immediately when 0.")
+	flag.IntVar(&replicaLimit.Max, "max-replicas", 0, "The maximum number of replicas of a SQLiteInstance. There is no limit when 0.")
+	flag.StringVar((*string)(&replicaLimit.Mode), "max-replicas-mode", string(replicaLimit.Mode), "How SQLiteInstances requesting more than --max-replicas are handled: \"clamp\" runs them with the maximum, \"reject\" leaves them as is until the spec is edited.")
+	flag.DurationVar(&debounceWindow, "debounce-window", time.Second, "How long a SQLiteInstance has to go without edits before it is reconciled, so a burst of edits is reconciled once after the last of them. Set to 0 to reconcile every edit immediately.")
+	flag.DurationVar(&rolloutProgressDeadline, "rollout-progress-deadline", 10*time.Minute, "How long a rollout of the StatefulSet of a SQLiteInstance can take before the Progressing condition reports it as stalled with the ProgressDeadlineExceeded reason. Set to 0 to never report a rollout as stalled.")
+	flag.IntVar(&storagePressureThreshold, "storage-pressure-threshold", 0, "The percentage of a data volume that can be used before the StoragePressure condition is set. The usage is fetched from the kubelets through the nodes/proxy subresource. Set to 0 to only compare the bound and requested capacity.")
+	flag.StringVar(&hostPathBase, "host-path-base", defaultHostPathBase, "The directory on the nodes below which SQLiteInstances in the DaemonSet deployment mode keep their databases, in <namespace>/<name>.")
+	// The default image can be set in the environment as well, so the
+	// render subcommand renders the same images as the controller.
+	flag.StringVar(&defaultImage, "default-image", defaultImageFromEnv(defaultImage), "The container image running SQLite for SQLiteInstances that do not set spec.image, for example a mirror of the default one. Defaults to the "+defaultImageEnv+" environment variable when set. Changing it rolls the pods of those SQLiteInstances.")
+	flag.BoolVar(&dryRun, "dry-run", false, "Only report the changes the controller would make to SQLiteInstances, SQLiteBackups and their child objects, without persisting them.")
+	flag.StringVar(&defaultStorageClassName, "default-storage-class", "", "The storage class set by the mutating webhook on SQLiteInstances that do not request one.")
 }
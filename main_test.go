@@ -0,0 +1,135 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2/ktesting"
+
+	"github.com/fortytwoapps/kubelitedb/pkg/generated/clientset/versioned/fake"
+)
+
+func TestHealthProbes(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	c, _, _ := f.newController(ctx)
+	// The caches of the fixture are always synced, except for the PVCs
+	// until the sync is simulated.
+	synced := false
+	c.pvcsSynced = func() bool { return synced }
+	server := httptest.NewServer(healthProbeHandler(c.HasSynced))
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		synced bool
+		path   string
+		want   int
+	}{
+		{name: "live before the sync", path: "/healthz", want: http.StatusOK},
+		{name: "not ready before the sync", path: "/readyz", want: http.StatusServiceUnavailable},
+		{name: "live after the sync", synced: true, path: "/healthz", want: http.StatusOK},
+		{name: "ready after the sync", synced: true, path: "/readyz", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			synced = tt.synced
+
+			resp, err := http.Get(server.URL + tt.path)
+			if err != nil {
+				t.Fatalf("error requesting %s: %v", tt.path, err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.want {
+				t.Errorf("expected status %d from %s, got %d: %s", tt.want, tt.path, resp.StatusCode, body)
+			}
+		})
+	}
+}
+
+func TestInformerFactoriesResync(t *testing.T) {
+	tests := []struct {
+		name   string
+		resync time.Duration
+		want   bool
+	}{
+		// The informers resync at most every second
+		{name: "periodic resync", resync: time.Second, want: true},
+		{name: "disabled", resync: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			instance := newSQLiteInstance("test")
+			sts := newStatefulSet(instance)
+			kube, kubeLiteDB, instances := newInformerFactories(k8sfake.NewSimpleClientset(sts), fake.NewSimpleClientset(instance), tt.resync, "", "")
+
+			// A resync delivers every cached object again as an update
+			var kubeResyncs, kubeLiteDBResyncs, instanceResyncs atomic.Int32
+			resyncs := func(count *atomic.Int32) cache.ResourceEventHandler {
+				return cache.ResourceEventHandlerFuncs{UpdateFunc: func(old, new interface{}) {
+					if old.(v1.Object).GetResourceVersion() == new.(v1.Object).GetResourceVersion() {
+						count.Add(1)
+					}
+				}}
+			}
+			kube.Apps().V1().StatefulSets().Informer().AddEventHandler(resyncs(&kubeResyncs))
+			kubeLiteDB.Kubelitedb().V1().SQLiteInstances().Informer().AddEventHandler(resyncs(&kubeLiteDBResyncs))
+			instances.Kubelitedb().V1().SQLiteInstances().Informer().AddEventHandler(resyncs(&instanceResyncs))
+			kube.Start(ctx.Done())
+			kubeLiteDB.Start(ctx.Done())
+			instances.Start(ctx.Done())
+
+			counts := map[string]*atomic.Int32{"Kubernetes": &kubeResyncs, "kubelitedb": &kubeLiteDBResyncs, "SQLiteInstance": &instanceResyncs}
+			resynced := func(context.Context) (bool, error) {
+				for _, count := range counts {
+					if count.Load() == 0 {
+						return false, nil
+					}
+				}
+				return true, nil
+			}
+			if tt.want {
+				if err := wait.PollUntilContextTimeout(ctx, 50*time.Millisecond, wait.ForeverTestTimeout, true, resynced); err != nil {
+					t.Errorf("timed out waiting for the informers to resync: %v", err)
+				}
+				return
+			}
+			// The informers would have resynced at least once in this time
+			time.Sleep(1500 * time.Millisecond)
+			for name, count := range counts {
+				if got := count.Load(); got != 0 {
+					t.Errorf("expected the %s informers not to resync, got %d resyncs", name, got)
+				}
+			}
+		})
+	}
+}
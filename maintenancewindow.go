@@ -0,0 +1,77 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+	"github.com/fortytwoapps/kubelitedb/pkg/validation"
+)
+
+const (
+	// ReasonOutsideMaintenanceWindow is used as the condition reason while
+	// changes to the pods wait for the maintenance window
+	ReasonOutsideMaintenanceWindow = "OutsideMaintenanceWindow"
+	// ReasonInvalidMaintenanceWindow is used as the event reason when the
+	// maintenance window can't be parsed
+	ReasonInvalidMaintenanceWindow = "InvalidMaintenanceWindow"
+
+	// MessageOutsideMaintenanceWindow is the message used while changes to
+	// the pods wait for the maintenance window
+	MessageOutsideMaintenanceWindow = "Changes to the pods are deferred until the maintenance window %q starts at %s"
+	// MessageInvalidMaintenanceWindow is the message used when the
+	// maintenance window can't be parsed
+	MessageInvalidMaintenanceWindow = "Ignoring invalid maintenance window %q: %v"
+)
+
+// deferTemplateUpdate keeps the pod template of an existing StatefulSet while
+// the SQLiteInstance is outside of its maintenance window, as changing it
+// rolls every pod. This includes rolls for a rotated TLS certificate. The
+// rest of the StatefulSet, such as its replicas and labels, is still
+// updated. It reports the deferral in the DeferredUpdate condition and
+// returns how long until the window starts, or 0 when nothing is deferred.
+// An invalid window defers nothing.
+func (c *Controller) deferTemplateUpdate(instance *kubelitedbv1.SQLiteInstance, status *kubelitedbv1.SQLiteInstanceStatus, statefulSet, desired *appsv1.StatefulSet, now time.Time) time.Duration {
+	annotation, ok := instance.Annotations[kubelitedbv1.MaintenanceWindowAnnotation]
+	if !ok || statefulSet.Spec.Template.Annotations[templateHashAnnotation] == desired.Spec.Template.Annotations[templateHashAnnotation] {
+		meta.RemoveStatusCondition(&status.Conditions, kubelitedbv1.ConditionDeferredUpdate)
+		return 0
+	}
+	window, err := validation.ParseMaintenanceWindow(annotation)
+	if err != nil {
+		c.recorder.Event(instance, corev1.EventTypeWarning, ReasonInvalidMaintenanceWindow, fmt.Sprintf(MessageInvalidMaintenanceWindow, annotation, err))
+		meta.RemoveStatusCondition(&status.Conditions, kubelitedbv1.ConditionDeferredUpdate)
+		return 0
+	}
+	if window.Contains(now) {
+		meta.RemoveStatusCondition(&status.Conditions, kubelitedbv1.ConditionDeferredUpdate)
+		return 0
+	}
+
+	desired.Spec.Template = *statefulSet.Spec.Template.DeepCopy()
+	next := window.Next(now)
+	setCondition(status, instance, kubelitedbv1.ConditionDeferredUpdate, v1.ConditionTrue, ReasonOutsideMaintenanceWindow,
+		fmt.Sprintf(MessageOutsideMaintenanceWindow, annotation, next.Format(time.RFC3339)))
+	return next.Sub(now)
+}
@@ -0,0 +1,233 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+	"github.com/fortytwoapps/kubelitedb/pkg/validation"
+)
+
+func TestDeferTemplateUpdate(t *testing.T) {
+	// 2024-06-01 is a Saturday
+	saturday := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		annotation string
+		// Whether the desired pod template differs from the current one
+		changed bool
+		// Whether the update was already deferred by a previous sync
+		deferred     bool
+		now          time.Time
+		wantDeferred bool
+		wantIn       time.Duration
+		wantEvent    bool
+	}{
+		{name: "no window", changed: true, now: saturday.Add(10 * time.Hour)},
+		{name: "template unchanged", annotation: "Sat 02:00-04:00", now: saturday.Add(10 * time.Hour)},
+		{name: "template unchanged after deferral", annotation: "Sat 02:00-04:00", deferred: true, now: saturday.Add(10 * time.Hour)},
+		{name: "in window", annotation: "Sat 02:00-04:00", changed: true, now: saturday.Add(3 * time.Hour)},
+		{name: "window opened after deferral", annotation: "Sat 02:00-04:00", changed: true, deferred: true, now: saturday.Add(2 * time.Hour)},
+		{
+			name:         "before window",
+			annotation:   "Sat 02:00-04:00",
+			changed:      true,
+			now:          saturday.Add(90 * time.Minute),
+			wantDeferred: true,
+			wantIn:       30 * time.Minute,
+		},
+		{
+			name:         "after window",
+			annotation:   "Sat 02:00-04:00",
+			changed:      true,
+			deferred:     true,
+			now:          saturday.Add(4 * time.Hour),
+			wantDeferred: true,
+			wantIn:       7*24*time.Hour - 2*time.Hour,
+		},
+		{
+			name:       "in window of time zone",
+			annotation: "Sat 02:00-04:00 America/New_York",
+			changed:    true,
+			// New York is 4 hours behind UTC in summer
+			now: saturday.Add(7 * time.Hour),
+		},
+		{
+			name:         "in UTC window but not in window of time zone",
+			annotation:   "Sat 02:00-04:00 Asia/Tokyo",
+			changed:      true,
+			now:          saturday.Add(3 * time.Hour),
+			wantDeferred: true,
+			// Tokyo is 9 hours ahead of UTC, so the window is Friday 17:00 UTC
+			wantIn: 6*24*time.Hour + 14*time.Hour,
+		},
+		{name: "invalid window", annotation: "weekends", changed: true, deferred: true, now: saturday, wantEvent: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			c, _, _ := f.newController(ctx)
+			instance := newSQLiteInstance("test")
+			if tt.annotation != "" {
+				instance.Annotations = map[string]string{kubelitedbv1.MaintenanceWindowAnnotation: tt.annotation}
+			}
+			statefulSet := newStatefulSet(instance)
+			changed := instance.DeepCopy()
+			if tt.changed {
+				changed.Spec.Image = "example.com/sqlite:3.45"
+			}
+			desired := newStatefulSet(changed)
+			status := &kubelitedbv1.SQLiteInstanceStatus{}
+			if tt.deferred {
+				setCondition(status, instance, kubelitedbv1.ConditionDeferredUpdate, v1.ConditionTrue, ReasonOutsideMaintenanceWindow, "deferred")
+			}
+
+			in := c.deferTemplateUpdate(instance, status, statefulSet, desired, tt.now)
+
+			if in != tt.wantIn {
+				t.Errorf("expected the update to be deferred for %v, got %v", tt.wantIn, in)
+			}
+			kept := desired.Spec.Template.Annotations[templateHashAnnotation] == statefulSet.Spec.Template.Annotations[templateHashAnnotation]
+			if tt.changed && kept != tt.wantDeferred {
+				t.Errorf("expected the current pod template to be kept to be %t, got %t", tt.wantDeferred, kept)
+			}
+			condition := meta.FindStatusCondition(status.Conditions, kubelitedbv1.ConditionDeferredUpdate)
+			if !tt.wantDeferred {
+				if condition != nil {
+					t.Errorf("expected no %s condition, got %+v", kubelitedbv1.ConditionDeferredUpdate, condition)
+				}
+			} else if condition == nil || condition.Status != v1.ConditionTrue || condition.Reason != ReasonOutsideMaintenanceWindow {
+				t.Errorf("expected a true %s condition with reason %s, got %+v", kubelitedbv1.ConditionDeferredUpdate, ReasonOutsideMaintenanceWindow, condition)
+			} else if next := startOfWindow(t, tt.annotation, tt.now.Add(tt.wantIn)); !strings.Contains(condition.Message, next) {
+				t.Errorf("expected the message to name the start of the window at %s, got %q", next, condition.Message)
+			}
+			if tt.wantEvent {
+				expectEvent(t, f.recorder, "Warning", ReasonInvalidMaintenanceWindow)
+			} else if events := drainEvents(f.recorder); len(events) > 0 {
+				t.Errorf("expected no events, got %v", events)
+			}
+		})
+	}
+}
+
+// startOfWindow formats the start of the window as the DeferredUpdate
+// condition does, in the time zone of the window.
+func startOfWindow(t *testing.T, annotation string, start time.Time) string {
+	t.Helper()
+	window, err := validation.ParseMaintenanceWindow(annotation)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return start.In(window.Location).Format(time.RFC3339)
+}
+
+// everyDay lists every day of the week for maintenance windows.
+const everyDay = "Mon,Tue,Wed,Thu,Fri,Sat,Sun"
+
+func TestMaintenanceWindowSync(t *testing.T) {
+	now := time.Now().UTC()
+	// A window around now on every day, and one only on a day later this week
+	inWindow := fmt.Sprintf("%s %s-%s", everyDay, now.Add(-time.Hour).Format("15:04"), now.Add(time.Hour).Format("15:04"))
+	outOfWindow := now.AddDate(0, 0, 3).Format("Mon") + " 02:00-03:00"
+	tests := []struct {
+		name         string
+		annotation   string
+		wantDeferred bool
+	}{
+		{name: "no window"},
+		{name: "in window", annotation: inWindow},
+		{name: "out of window", annotation: outOfWindow, wantDeferred: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := newSQLiteInstance("test")
+			sts := newStatefulSet(instance)
+			sts.Status.Replicas = 1
+			sts.Status.ReadyReplicas = 1
+			sts.Status.UpdatedReplicas = 1
+			f.addKubeObject(sts)
+			if tt.annotation != "" {
+				instance.Annotations = map[string]string{kubelitedbv1.MaintenanceWindowAnnotation: tt.annotation}
+			}
+			// The image rolls the pods, the label doesn't
+			instance.Spec.Image = "example.com/sqlite:3.45"
+			instance.Spec.Labels = map[string]string{"team": "storage"}
+			f.addInstance(instance)
+			c, _, _ := f.newController(ctx)
+
+			f.run(ctx, c, getKey(instance, t))
+			// Updating the labels syncs again soon, until the StatefulSet
+			// settled
+			f.refreshCaches(ctx)
+			result := f.run(ctx, c, getKey(instance, t))
+
+			updated := f.getStatefulSet(ctx, instance)
+			if updated.Labels["team"] != "storage" {
+				t.Errorf("expected the label to be set on the StatefulSet at any time, got %v", updated.Labels)
+			}
+			image := container(t, updated.Spec.Template.Spec.Containers, "sqlite").Image
+			if deferred := image != instance.Spec.Image; deferred != tt.wantDeferred {
+				t.Errorf("expected the image change to be deferred to be %t, got image %q", tt.wantDeferred, image)
+			}
+			if deferred := updated.Spec.Template.Annotations[templateHashAnnotation] == sts.Spec.Template.Annotations[templateHashAnnotation]; deferred != tt.wantDeferred {
+				t.Errorf("expected the pod template to be kept to be %t, got %t", tt.wantDeferred, deferred)
+			}
+			got := f.getInstance(ctx, instance)
+			if deferred := meta.IsStatusConditionTrue(got.Status.Conditions, kubelitedbv1.ConditionDeferredUpdate); deferred != tt.wantDeferred {
+				t.Errorf("expected the %s condition to be %t, got %+v", kubelitedbv1.ConditionDeferredUpdate, tt.wantDeferred, got.Status.Conditions)
+			}
+			if tt.wantDeferred {
+				// The window starts in two to four days
+				if result.RequeueAfter < 2*24*time.Hour || result.RequeueAfter > 4*24*time.Hour {
+					t.Errorf("expected a requeue at the start of the window, got %v", result.RequeueAfter)
+				}
+			} else if result.RequeueAfter > 24*time.Hour {
+				t.Errorf("expected no requeue for the window, got %v", result.RequeueAfter)
+			}
+		})
+	}
+}
+
+func TestDeferredUpdateIsNotInSync(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+	f.settle(ctx, c, instance)
+
+	// The window has to be checked again on every resync
+	got := f.getInstance(ctx, instance)
+	if _, ok := c.inSync(getKey(got, t), got, time.Now()); !ok {
+		t.Fatalf("expected the settled SQLiteInstance to be in sync")
+	}
+	setCondition(&got.Status, got, kubelitedbv1.ConditionDeferredUpdate, v1.ConditionTrue, ReasonOutsideMaintenanceWindow, "deferred")
+	if _, ok := c.inSync(getKey(got, t), got, time.Now()); ok {
+		t.Errorf("expected a SQLiteInstance with a deferred update not to be in sync")
+	}
+}
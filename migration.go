@@ -0,0 +1,110 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"path"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+const (
+	// ReasonDatabaseMigrated is used as the condition reason once every pod
+	// moved its database from spec.legacyDatabasePath
+	ReasonDatabaseMigrated = "DatabaseMigrated"
+	// ReasonMigrationPending is used as the condition reason while not every
+	// pod started with the migrate init container yet
+	ReasonMigrationPending = "MigrationPending"
+
+	// MessageMigrationPending is the message used while not every pod
+	// started with the migrate init container yet
+	MessageMigrationPending = "Waiting for the pods to move the database from %q, %d of %d pods updated and ready"
+)
+
+// migrationMountPath is where the migrate init container mounts the whole
+// data volume, as the legacy path may be outside the directory of the
+// database.
+const migrationMountPath = "/volume"
+
+// migrateScript moves the database from LEGACY_PATH to DATABASE_PATH. The
+// database is copied with the SQLite backup API, which includes the contents
+// of its WAL, to a temporary file that is then renamed, so a partial copy is
+// never mistaken for the database. The marker next to the database records
+// that the migration completed, so the database is only ever moved once. A
+// database already at DATABASE_PATH is never overwritten. Its directory is
+// created either way, as the marker is kept there.
+const migrateScript = `set -e
+if [ -f "$DATABASE_PATH.migrated" ]; then
+  exit 0
+fi
+mkdir -p "$(dirname "$DATABASE_PATH")"
+if [ -f "$LEGACY_PATH" ] && [ ! -f "$DATABASE_PATH" ]; then
+  echo "Moving database from $LEGACY_PATH to $DATABASE_PATH"
+  rm -f "$DATABASE_PATH.migrating"
+  sqlite3 "$LEGACY_PATH" ".backup '$DATABASE_PATH.migrating'"
+  mv "$DATABASE_PATH.migrating" "$DATABASE_PATH"
+  rm -f "$LEGACY_PATH" "$LEGACY_PATH-wal" "$LEGACY_PATH-shm"
+elif [ -f "$LEGACY_PATH" ]; then
+  echo "Database $DATABASE_PATH already exists, leaving $LEGACY_PATH in place"
+fi
+touch "$DATABASE_PATH.migrated"
+`
+
+// addMigrationInitContainer adds the init container moving the database from
+// spec.legacyDatabasePath to the pod template. It runs before any other init
+// container, so they find the database where it is kept now.
+func addMigrationInitContainer(instance *kubelitedbv1.SQLiteInstance, template *corev1.PodTemplateSpec) {
+	template.Spec.InitContainers = append(template.Spec.InitContainers, corev1.Container{
+		Name:    "migrate",
+		Image:   imageForInstance(instance),
+		Command: []string{"/bin/sh", "-c", migrateScript},
+		Env: []corev1.EnvVar{
+			{Name: "LEGACY_PATH", Value: path.Join(migrationMountPath, instance.Spec.LegacyDatabasePath)},
+			{Name: "DATABASE_PATH", Value: path.Join(migrationMountPath, dataSubPath(instance), instance.Spec.DbName)},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: dataVolumeName, MountPath: migrationMountPath},
+		},
+	})
+}
+
+// setMigratedCondition sets the Migrated condition once every pod of the
+// StatefulSet started with the migrate init container, as each of them moves
+// the database on its own volume. The condition is kept once set, and removed
+// when spec.legacyDatabasePath is not set.
+func setMigratedCondition(status *kubelitedbv1.SQLiteInstanceStatus, instance *kubelitedbv1.SQLiteInstance, statefulSet *appsv1.StatefulSet, replicas int32, updated bool) {
+	if instance.Spec.LegacyDatabasePath == "" {
+		meta.RemoveStatusCondition(&status.Conditions, kubelitedbv1.ConditionMigrated)
+		return
+	}
+	if meta.IsStatusConditionTrue(status.Conditions, kubelitedbv1.ConditionMigrated) {
+		return
+	}
+	stsStatus := statefulSet.Status
+	if !updated && stsStatus.ObservedGeneration >= statefulSet.Generation && stsStatus.UpdatedReplicas >= replicas && stsStatus.ReadyReplicas >= replicas {
+		setCondition(status, instance, kubelitedbv1.ConditionMigrated, v1.ConditionTrue, ReasonDatabaseMigrated, "")
+		return
+	}
+	msg := fmt.Sprintf(MessageMigrationPending, instance.Spec.LegacyDatabasePath, min(stsStatus.UpdatedReplicas, stsStatus.ReadyReplicas), replicas)
+	setCondition(status, instance, kubelitedbv1.ConditionMigrated, v1.ConditionFalse, ReasonMigrationPending, msg)
+}
@@ -0,0 +1,325 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+func TestMigrationInitContainer(t *testing.T) {
+	tests := []struct {
+		name       string
+		instance   *kubelitedbv1.SQLiteInstance
+		legacyPath string
+		// want is the expected DATABASE_PATH, no init container when empty
+		want string
+	}{
+		{name: "no legacy path", instance: newSQLiteInstance("test")},
+		{name: "legacy path", instance: newSQLiteInstance("test"), legacyPath: "data/app.db", want: "/volume/app.db"},
+		{
+			name:       "sub path",
+			instance:   newSubPathSQLiteInstance("test", "orders.db", 0),
+			legacyPath: "orders.db",
+			want:       "/volume/orders/orders.db",
+		},
+		{name: "before the restore", instance: newRestoringSQLiteInstance("test"), legacyPath: "old/app.db", want: "/volume/app.db"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.instance.Spec.LegacyDatabasePath = tt.legacyPath
+			template := newStatefulSet(tt.instance).Spec.Template
+			if tt.want == "" {
+				if hasInitContainer(template, "migrate") {
+					t.Errorf("expected no migrate init container, got %v", initContainerNames(template.Spec))
+				}
+				return
+			}
+
+			// The other init containers find the database where it is kept now
+			if names := initContainerNames(template.Spec); len(names) == 0 || names[0] != "migrate" {
+				t.Fatalf("expected the migrate init container to run first, got %v", names)
+			}
+			migrate := &template.Spec.InitContainers[0]
+			if got := envValue(migrate, "LEGACY_PATH"); got != "/volume/"+tt.legacyPath {
+				t.Errorf("expected LEGACY_PATH /volume/%s, got %q", tt.legacyPath, got)
+			}
+			if got := envValue(migrate, "DATABASE_PATH"); got != tt.want {
+				t.Errorf("expected DATABASE_PATH %s, got %q", tt.want, got)
+			}
+			// The whole volume is mounted, as the legacy path may be outside
+			// the directory of the database
+			want := []string{dataVolumeName + ":" + migrationMountPath + ":"}
+			var got []string
+			for _, mount := range migrate.VolumeMounts {
+				got = append(got, mount.Name+":"+mount.MountPath+":"+mount.SubPath)
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("expected the mounts %v, got %v", want, got)
+			}
+			if migrate.Image != imageForInstance(tt.instance) {
+				t.Errorf("expected the SQLite image, got %s", migrate.Image)
+			}
+		})
+	}
+}
+
+// TestMigrateScript runs the script of the migrate init container against a
+// fake sqlite3, which copies the database with .backup.
+func TestMigrateScript(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no shell to run the migrate script with")
+	}
+	bin := t.TempDir()
+	// The fake sqlite3 copies the database to the path quoted in .backup, or
+	// writes part of it and fails when FAIL_BACKUP is set
+	fake := `#!/bin/sh
+dest=$(echo "$2" | sed "s/^\.backup '\(.*\)'$/\1/")
+if [ -n "$FAIL_BACKUP" ]; then
+  head -c 2 "$1" > "$dest"
+  exit 1
+fi
+cat "$1" > "$dest"
+`
+	if err := os.WriteFile(filepath.Join(bin, "sqlite3"), []byte(fake), 0o755); err != nil {
+		t.Fatalf("error writing the fake sqlite3: %v", err)
+	}
+
+	type files map[string]string
+	tests := []struct {
+		name string
+		// before are the files on the volume before the script runs
+		before files
+		// failBackup makes the first run fail halfway through the copy
+		failBackup bool
+		// runs is how often the script runs, once when 0
+		runs int
+		// after are the files on the volume after the last run
+		after files
+	}{
+		{
+			name:   "legacy database",
+			before: files{"old/app.db": "legacy", "old/app.db-wal": "wal", "old/app.db-shm": "shm"},
+			after:  files{"data/app.db": "legacy", "data/app.db.migrated": ""},
+		},
+		{
+			name:  "no legacy database",
+			after: files{"data/app.db.migrated": ""},
+		},
+		{
+			name:   "database already at the new path",
+			before: files{"old/app.db": "legacy", "data/app.db": "current"},
+			after:  files{"old/app.db": "legacy", "data/app.db": "current", "data/app.db.migrated": ""},
+		},
+		{
+			name:   "already migrated",
+			before: files{"old/app.db": "legacy", "data/app.db.migrated": ""},
+			after:  files{"old/app.db": "legacy", "data/app.db.migrated": ""},
+		},
+		{
+			name:   "restarted pod",
+			before: files{"old/app.db": "legacy"},
+			runs:   2,
+			after:  files{"data/app.db": "legacy", "data/app.db.migrated": ""},
+		},
+		{
+			name:       "interrupted copy is retried",
+			before:     files{"old/app.db": "legacy"},
+			failBackup: true,
+			runs:       2,
+			after:      files{"data/app.db": "legacy", "data/app.db.migrated": ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			volume := t.TempDir()
+			for name, contents := range tt.before {
+				path := filepath.Join(volume, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatalf("error creating %s: %v", filepath.Dir(path), err)
+				}
+				if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+					t.Fatalf("error writing %s: %v", name, err)
+				}
+			}
+			runs := max(tt.runs, 1)
+			for i := 0; i < runs; i++ {
+				cmd := exec.Command(sh, "-c", migrateScript)
+				cmd.Env = []string{
+					"PATH=" + bin + ":/usr/bin:/bin",
+					"LEGACY_PATH=" + filepath.Join(volume, "old/app.db"),
+					"DATABASE_PATH=" + filepath.Join(volume, "data/app.db"),
+				}
+				if tt.failBackup && i == 0 {
+					cmd.Env = append(cmd.Env, "FAIL_BACKUP=1")
+					if out, err := cmd.CombinedOutput(); err == nil {
+						t.Fatalf("expected the interrupted copy to fail the script: %s", out)
+					}
+					// The partial copy is never mistaken for the database
+					if _, err := os.Stat(filepath.Join(volume, "data/app.db")); !os.IsNotExist(err) {
+						t.Fatalf("expected no database after the interrupted copy, got %v", err)
+					}
+					if _, err := os.Stat(filepath.Join(volume, "data/app.db.migrated")); !os.IsNotExist(err) {
+						t.Fatalf("expected the migration not to be marked complete, got %v", err)
+					}
+					continue
+				}
+				if out, err := cmd.CombinedOutput(); err != nil {
+					t.Fatalf("error running the migrate script: %v: %s", err, out)
+				}
+			}
+
+			got := files{}
+			err := filepath.WalkDir(volume, func(path string, d os.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return err
+				}
+				contents, err := os.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				name, _ := filepath.Rel(volume, path)
+				got[name] = string(contents)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("error reading the volume: %v", err)
+			}
+			if len(got) != len(tt.after) {
+				t.Errorf("expected the files %v, got %v", tt.after, got)
+			}
+			for name, contents := range tt.after {
+				if got[name] != contents {
+					t.Errorf("expected %s to hold %q, got %v", name, contents, got)
+				}
+			}
+		})
+	}
+}
+
+func TestSetMigratedCondition(t *testing.T) {
+	statefulSet := func(updated, ready int32) *appsv1.StatefulSet {
+		return rolloutStatus(updated, ready, "v1", "v2")
+	}
+	migrated := &v1.Condition{Type: kubelitedbv1.ConditionMigrated, Status: v1.ConditionTrue, Reason: ReasonDatabaseMigrated}
+	tests := []struct {
+		name        string
+		legacyPath  string
+		previous    *v1.Condition
+		statefulSet *appsv1.StatefulSet
+		updated     bool
+		// reason is the expected reason of the condition, none when empty
+		reason string
+		status v1.ConditionStatus
+	}{
+		{name: "no legacy path", statefulSet: statefulSet(3, 3)},
+		{name: "no legacy path removes the condition", previous: migrated, statefulSet: statefulSet(3, 3)},
+		{
+			name:        "pods not updated",
+			legacyPath:  "old/app.db",
+			statefulSet: statefulSet(1, 3),
+			reason:      ReasonMigrationPending,
+			status:      v1.ConditionFalse,
+		},
+		{
+			name:        "pods not ready",
+			legacyPath:  "old/app.db",
+			statefulSet: statefulSet(3, 2),
+			reason:      ReasonMigrationPending,
+			status:      v1.ConditionFalse,
+		},
+		{
+			name:        "StatefulSet just updated",
+			legacyPath:  "old/app.db",
+			statefulSet: statefulSet(3, 3),
+			updated:     true,
+			reason:      ReasonMigrationPending,
+			status:      v1.ConditionFalse,
+		},
+		{
+			name:        "every pod migrated",
+			legacyPath:  "old/app.db",
+			statefulSet: statefulSet(3, 3),
+			reason:      ReasonDatabaseMigrated,
+			status:      v1.ConditionTrue,
+		},
+		{
+			name:        "kept once migrated",
+			legacyPath:  "old/app.db",
+			previous:    migrated,
+			statefulSet: statefulSet(1, 1),
+			reason:      ReasonDatabaseMigrated,
+			status:      v1.ConditionTrue,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newSQLiteInstance("test")
+			instance.Spec.LegacyDatabasePath = tt.legacyPath
+			status := instance.Status.DeepCopy()
+			if tt.previous != nil {
+				meta.SetStatusCondition(&status.Conditions, *tt.previous)
+			}
+
+			setMigratedCondition(status, instance, tt.statefulSet, 3, tt.updated)
+
+			condition := meta.FindStatusCondition(status.Conditions, kubelitedbv1.ConditionMigrated)
+			if tt.reason == "" {
+				if condition != nil {
+					t.Errorf("expected no Migrated condition, got %+v", condition)
+				}
+				return
+			}
+			if condition == nil {
+				t.Fatalf("expected a Migrated condition")
+			}
+			if condition.Status != tt.status || condition.Reason != tt.reason {
+				t.Errorf("expected the condition %s/%s, got %s/%s", tt.status, tt.reason, condition.Status, condition.Reason)
+			}
+		})
+	}
+}
+
+func TestMigratedConditionSync(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	instance.Spec.LegacyDatabasePath = "old/app.db"
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+
+	f.run(ctx, c, getKey(instance, t))
+
+	if !hasInitContainer(f.getStatefulSet(ctx, instance).Spec.Template, "migrate") {
+		t.Errorf("expected the StatefulSet to move the database before the pods start")
+	}
+	condition := meta.FindStatusCondition(f.getInstance(ctx, instance).Status.Conditions, kubelitedbv1.ConditionMigrated)
+	if condition == nil || condition.Status != v1.ConditionFalse || condition.Reason != ReasonMigrationPending {
+		t.Errorf("expected the migration to be pending, got %+v", condition)
+	}
+}
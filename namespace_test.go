@@ -0,0 +1,115 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	kubeinformers "k8s.io/client-go/informers"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2/ktesting"
+
+	"github.com/fortytwoapps/kubelitedb/pkg/generated/clientset/versioned/fake"
+	informers "github.com/fortytwoapps/kubelitedb/pkg/generated/informers/externalversions"
+)
+
+func TestWatchNamespace(t *testing.T) {
+	tests := []struct {
+		name      string
+		namespace string
+		want      []string
+	}{
+		{name: "every namespace", namespace: "", want: []string{"default/test", "other/test"}},
+		{name: "single namespace", namespace: "default", want: []string{"default/test"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			instance := newSQLiteInstance("test")
+			other := newSQLiteInstance("test")
+			other.Namespace = "other"
+			client := fake.NewSimpleClientset(instance, other)
+			kubeclient := k8sfake.NewSimpleClientset()
+
+			// The factories are scoped the way main scopes them for --namespace.
+			i := informers.NewSharedInformerFactoryWithOptions(client, noResyncPeriodFunc(), informers.WithNamespace(tt.namespace))
+			k8sI := kubeinformers.NewSharedInformerFactoryWithOptions(kubeclient, noResyncPeriodFunc(), kubeinformers.WithNamespace(tt.namespace))
+			c := NewController(ctx, kubeclient, client,
+				i.Kubelitedb().V1().SQLiteInstances(),
+				k8sI.Apps().V1().StatefulSets(),
+				k8sI.Core().V1().PersistentVolumeClaims(),
+				k8sI.Apps().V1().Deployments(),
+				k8sI.Core().V1().Services(),
+				k8sI.Core().V1().ConfigMaps(),
+				k8sI.Policy().V1().PodDisruptionBudgets(),
+				k8sI.Batch().V1().CronJobs(),
+				DefaultRateLimiterOptions(),
+				ReplicaLimit{Mode: ReplicaLimitClamp},
+				0,
+				0,
+				0,
+				10*time.Minute,
+				defaultHostPathBase,
+				false,
+			)
+			i.Start(ctx.Done())
+			k8sI.Start(ctx.Done())
+			if !cache.WaitForCacheSync(ctx.Done(), c.HasSynced) {
+				t.Fatal("timed out waiting for the informer caches to sync")
+			}
+
+			cached, err := c.sqliteInstancesLister.List(labels.Everything())
+			if err != nil {
+				t.Fatalf("error listing the cached SQLiteInstances: %v", err)
+			}
+			var got []string
+			for _, instance := range cached {
+				got = append(got, getKey(instance, t))
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expected the SQLiteInstances %v to be cached, got %v", tt.want, got)
+			}
+
+			// The event handlers are notified after the caches have synced.
+			err = wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+				return c.workqueue.Len() >= len(tt.want), nil
+			})
+			if err != nil {
+				t.Fatalf("timed out waiting for the SQLiteInstances to be queued: %v", err)
+			}
+			var queued []string
+			for c.workqueue.Len() > 0 {
+				key, _ := c.workqueue.Get()
+				queued = append(queued, key.(string))
+				c.workqueue.Done(key)
+			}
+			slices.Sort(queued)
+			if !slices.Equal(queued, tt.want) {
+				t.Errorf("expected the SQLiteInstances %v to be queued, got %v", tt.want, queued)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+const (
+	// ReasonOrphanDeleted is used as the event reason when a child object
+	// the controller no longer manages was deleted
+	ReasonOrphanDeleted = "OrphanDeleted"
+	// MessageOrphanDeleted is the message used when a child object the
+	// controller no longer manages was deleted
+	MessageOrphanDeleted = "Deleted %s %q, which is no longer managed for the SQLiteInstance"
+)
+
+// orphansCleaned identifies the SQLiteInstance and generation the orphaned
+// child objects were last cleaned up for
+type orphansCleaned struct {
+	uid        types.UID
+	generation int64
+}
+
+// orphanKind lists and deletes the objects of a kind the controller creates
+// for SQLiteInstances.
+type orphanKind struct {
+	kind string
+	// names returns the names of the objects of the kind the controller
+	// manages for the SQLiteInstance, whether they are currently wanted or not
+	names  func(instance *kubelitedbv1.SQLiteInstance) []string
+	list   func(ctx context.Context, c *Controller, namespace string) ([]v1.Object, error)
+	delete func(ctx context.Context, c *Controller, namespace, name string, opts v1.DeleteOptions) error
+}
+
+// orphanKinds are the kinds of child objects cleaned up when they are no
+// longer managed. StatefulSets and PVCs hold the data of the database, so they
+// are never deleted as orphans.
+var orphanKinds = []orphanKind{
+	{
+		kind: "Service",
+		names: func(instance *kubelitedbv1.SQLiteInstance) []string {
+			return []string{headlessServiceName(instance), clientServiceName(instance), writeServiceName(instance), readServiceName(instance)}
+		},
+		list: func(ctx context.Context, c *Controller, namespace string) ([]v1.Object, error) {
+			list, err := c.kubeclientset.CoreV1().Services(namespace).List(ctx, v1.ListOptions{})
+			if err != nil {
+				return nil, err
+			}
+			objects := make([]v1.Object, 0, len(list.Items))
+			for i := range list.Items {
+				objects = append(objects, &list.Items[i])
+			}
+			return objects, nil
+		},
+		delete: func(ctx context.Context, c *Controller, namespace, name string, opts v1.DeleteOptions) error {
+			return c.kubeclientset.CoreV1().Services(namespace).Delete(ctx, name, opts)
+		},
+	},
+	{
+		kind: "ConfigMap",
+		names: func(instance *kubelitedbv1.SQLiteInstance) []string {
+			return []string{litestreamConfigMapName(instance), pragmasConfigMapName(instance)}
+		},
+		list: func(ctx context.Context, c *Controller, namespace string) ([]v1.Object, error) {
+			list, err := c.kubeclientset.CoreV1().ConfigMaps(namespace).List(ctx, v1.ListOptions{})
+			if err != nil {
+				return nil, err
+			}
+			objects := make([]v1.Object, 0, len(list.Items))
+			for i := range list.Items {
+				objects = append(objects, &list.Items[i])
+			}
+			return objects, nil
+		},
+		delete: func(ctx context.Context, c *Controller, namespace, name string, opts v1.DeleteOptions) error {
+			return c.kubeclientset.CoreV1().ConfigMaps(namespace).Delete(ctx, name, opts)
+		},
+	},
+	{
+		kind: "Deployment",
+		names: func(instance *kubelitedbv1.SQLiteInstance) []string {
+			return []string{readerDeploymentName(instance)}
+		},
+		list: func(ctx context.Context, c *Controller, namespace string) ([]v1.Object, error) {
+			list, err := c.kubeclientset.AppsV1().Deployments(namespace).List(ctx, v1.ListOptions{})
+			if err != nil {
+				return nil, err
+			}
+			objects := make([]v1.Object, 0, len(list.Items))
+			for i := range list.Items {
+				objects = append(objects, &list.Items[i])
+			}
+			return objects, nil
+		},
+		delete: func(ctx context.Context, c *Controller, namespace, name string, opts v1.DeleteOptions) error {
+			return c.kubeclientset.AppsV1().Deployments(namespace).Delete(ctx, name, opts)
+		},
+	},
+	{
+		kind: "PodDisruptionBudget",
+		names: func(instance *kubelitedbv1.SQLiteInstance) []string {
+			return []string{podDisruptionBudgetName(instance)}
+		},
+		list: func(ctx context.Context, c *Controller, namespace string) ([]v1.Object, error) {
+			list, err := c.kubeclientset.PolicyV1().PodDisruptionBudgets(namespace).List(ctx, v1.ListOptions{})
+			if err != nil {
+				return nil, err
+			}
+			objects := make([]v1.Object, 0, len(list.Items))
+			for i := range list.Items {
+				objects = append(objects, &list.Items[i])
+			}
+			return objects, nil
+		},
+		delete: func(ctx context.Context, c *Controller, namespace, name string, opts v1.DeleteOptions) error {
+			return c.kubeclientset.PolicyV1().PodDisruptionBudgets(namespace).Delete(ctx, name, opts)
+		},
+	},
+	{
+		kind: "CronJob",
+		names: func(instance *kubelitedbv1.SQLiteInstance) []string {
+			return []string{backupCronJobName(instance), integrityCheckCronJobName(instance)}
+		},
+		list: func(ctx context.Context, c *Controller, namespace string) ([]v1.Object, error) {
+			list, err := c.kubeclientset.BatchV1().CronJobs(namespace).List(ctx, v1.ListOptions{})
+			if err != nil {
+				return nil, err
+			}
+			objects := make([]v1.Object, 0, len(list.Items))
+			for i := range list.Items {
+				objects = append(objects, &list.Items[i])
+			}
+			return objects, nil
+		},
+		delete: func(ctx context.Context, c *Controller, namespace, name string, opts v1.DeleteOptions) error {
+			return c.kubeclientset.BatchV1().CronJobs(namespace).Delete(ctx, name, opts)
+		},
+	},
+}
+
+// cleanupOrphans deletes the child objects controlled by the SQLiteInstance
+// that the controller no longer manages, such as objects left behind by an
+// older version of the controller that named them differently. Listing every
+// object of the namespace is costly, so this only runs once per generation of
+// the SQLiteInstance and controller process.
+func (c *Controller) cleanupOrphans(ctx context.Context, key string, sqliteInstance *kubelitedbv1.SQLiteInstance) error {
+	current := orphansCleaned{uid: sqliteInstance.UID, generation: sqliteInstance.Generation}
+	c.orphansMu.Lock()
+	cleaned := c.orphansCleaned[key]
+	c.orphansMu.Unlock()
+	if cleaned == current {
+		return nil
+	}
+
+	logger := klog.FromContext(ctx)
+	for _, kind := range orphanKinds {
+		objects, err := kind.list(ctx, c, sqliteInstance.Namespace)
+		if err != nil {
+			return fmt.Errorf("failed to list %ss: %w", kind.kind, err)
+		}
+		names := kind.names(sqliteInstance)
+		for _, object := range objects {
+			if !v1.IsControlledBy(object, sqliteInstance) || slices.Contains(names, object.GetName()) {
+				continue
+			}
+			logger.Info("Deleting orphaned child object", "kind", kind.kind, "name", object.GetName())
+			err := kind.delete(ctx, c, sqliteInstance.Namespace, object.GetName(), c.deleteOptions(ctx, sqliteInstance, kind.kind, object.GetName()))
+			logWrite(ctx, "delete", kind.kind, object.GetName(), err)
+			if err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+			c.recorder.Eventf(sqliteInstance, corev1.EventTypeNormal, ReasonOrphanDeleted, MessageOrphanDeleted, kind.kind, object.GetName())
+		}
+	}
+
+	c.orphansMu.Lock()
+	c.orphansCleaned[key] = current
+	c.orphansMu.Unlock()
+	return nil
+}
+
+// forgetOrphansCleaned drops the record of the orphan cleanup of a deleted
+// SQLiteInstance.
+func (c *Controller) forgetOrphansCleaned(key string) {
+	c.orphansMu.Lock()
+	defer c.orphansMu.Unlock()
+	delete(c.orphansCleaned, key)
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// newOwnedObjectMeta returns the metadata of a child object of the
+// SQLiteInstance with the given name.
+func newOwnedObjectMeta(instance *kubelitedbv1.SQLiteInstance, name string) v1.ObjectMeta {
+	return v1.ObjectMeta{
+		Name:            name,
+		Namespace:       instance.Namespace,
+		OwnerReferences: []v1.OwnerReference{*v1.NewControllerRef(instance, kubelitedbv1.SchemeGroupVersion.WithKind("SQLiteInstance"))},
+	}
+}
+
+func TestCleanupOrphans(t *testing.T) {
+	instance := newSQLiteInstance("test")
+	other := newSQLiteInstance("other")
+	other.UID = types.UID("other-uid")
+	tests := []struct {
+		name        string
+		object      runtime.Object
+		wantDeleted bool
+	}{
+		{
+			name:        "orphaned Service",
+			object:      &corev1.Service{ObjectMeta: newOwnedObjectMeta(instance, "test-legacy")},
+			wantDeleted: true,
+		},
+		{
+			name:        "orphaned ConfigMap",
+			object:      &corev1.ConfigMap{ObjectMeta: newOwnedObjectMeta(instance, "test-legacy-config")},
+			wantDeleted: true,
+		},
+		{
+			name:   "managed Service",
+			object: &corev1.Service{ObjectMeta: newOwnedObjectMeta(instance, headlessServiceName(instance))},
+		},
+		{
+			name:   "Service not owned",
+			object: &corev1.Service{ObjectMeta: v1.ObjectMeta{Name: "test-legacy", Namespace: instance.Namespace}},
+		},
+		{
+			name:   "Service of another SQLiteInstance",
+			object: &corev1.Service{ObjectMeta: newOwnedObjectMeta(other, "test-legacy")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			f.addInstance(instance.DeepCopy())
+			f.addKubeObject(tt.object)
+			c, _, _ := f.newController(ctx)
+
+			f.run(ctx, c, getKey(instance, t))
+
+			object := tt.object.(v1.Object)
+			var err error
+			switch tt.object.(type) {
+			case *corev1.Service:
+				_, err = f.kubeclient.CoreV1().Services(object.GetNamespace()).Get(ctx, object.GetName(), v1.GetOptions{})
+			case *corev1.ConfigMap:
+				_, err = f.kubeclient.CoreV1().ConfigMaps(object.GetNamespace()).Get(ctx, object.GetName(), v1.GetOptions{})
+			}
+			if deleted := errors.IsNotFound(err); deleted != tt.wantDeleted {
+				t.Errorf("expected deleted %t, got %t (%v)", tt.wantDeleted, deleted, err)
+			}
+			if tt.wantDeleted {
+				expectEvent(t, f.recorder, corev1.EventTypeNormal, ReasonOrphanDeleted)
+			}
+		})
+	}
+}
+
+func TestCleanupOrphansOncePerGeneration(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+	key := getKey(instance, t)
+
+	// refreshCaches lists across namespaces, the cleanup in the namespace of
+	// the SQLiteInstance
+	listed := func() int {
+		var n int
+		for _, action := range f.kubeclient.Actions() {
+			if action.GetVerb() == "list" && action.GetResource().Resource == "services" && action.GetNamespace() == instance.Namespace {
+				n++
+			}
+		}
+		return n
+	}
+
+	f.run(ctx, c, key)
+	if got := listed(); got != 1 {
+		t.Fatalf("expected Services to be listed once, got %d", got)
+	}
+	f.refreshCaches(ctx)
+	f.run(ctx, c, key)
+	if got := listed(); got != 1 {
+		t.Errorf("expected no listing for the same generation, got %d lists", got)
+	}
+
+	instance = f.getInstance(ctx, instance)
+	instance.Generation++
+	if _, err := f.client.KubelitedbV1().SQLiteInstances(instance.Namespace).Update(ctx, instance, v1.UpdateOptions{}); err != nil {
+		t.Fatalf("error updating the SQLiteInstance: %v", err)
+	}
+	f.refreshCaches(ctx)
+	f.run(ctx, c, key)
+	if got := listed(); got != 2 {
+		t.Errorf("expected Services to be listed again for a new generation, got %d lists", got)
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+func TestPause(t *testing.T) {
+	tests := []struct {
+		name       string
+		annotation string
+		wantPaused bool
+	}{
+		{name: "paused", annotation: "true", wantPaused: true},
+		{name: "not true", annotation: "false"},
+		{name: "no annotation"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := newSQLiteInstance("test")
+			if tt.annotation != "" {
+				instance.Annotations = map[string]string{kubelitedbv1.PauseAnnotation: tt.annotation}
+			}
+			f.addInstance(instance)
+			c, _, _ := f.newController(ctx)
+			defer c.workqueue.ShutDown()
+
+			f.run(ctx, c, getKey(instance, t))
+
+			var childWrites []string
+			for _, action := range f.kubeclient.Actions() {
+				switch action.GetVerb() {
+				case "create", "update", "patch", "delete":
+					if resource := action.GetResource().Resource; resource != "events" && !slices.Contains(childWrites, resource) {
+						childWrites = append(childWrites, resource)
+					}
+				}
+			}
+			if tt.wantPaused && len(childWrites) > 0 {
+				t.Errorf("expected no child writes while paused, got writes to %v", childWrites)
+			}
+			if !tt.wantPaused && len(childWrites) == 0 {
+				t.Errorf("expected the child objects to be created")
+			}
+			paused := meta.FindStatusCondition(f.getInstance(ctx, instance).Status.Conditions, kubelitedbv1.ConditionPaused)
+			if tt.wantPaused {
+				if paused == nil || paused.Status != v1.ConditionTrue || paused.Reason != ReasonPaused {
+					t.Errorf("expected a true Paused condition, got %+v", paused)
+				}
+			} else if paused != nil {
+				t.Errorf("expected no Paused condition, got %+v", paused)
+			}
+		})
+	}
+}
+
+func TestPauseRemoved(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	instance.ResourceVersion, instance.Generation = "1", 1
+	instance.Annotations = map[string]string{kubelitedbv1.PauseAnnotation: "true"}
+	f.addInstance(instance)
+	c, i, _ := f.newController(ctx)
+	defer c.workqueue.ShutDown()
+	key := getKey(instance, t)
+	f.run(ctx, c, key)
+
+	i.Start(ctx.Done())
+	i.WaitForCacheSync(ctx.Done())
+	next := func() string {
+		t.Helper()
+		err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+			return c.workqueue.Len() > 0, nil
+		})
+		if err != nil {
+			t.Fatalf("timed out waiting for the SQLiteInstance to be queued")
+		}
+		key, _ := c.workqueue.Get()
+		c.workqueue.Done(key)
+		return key.(string)
+	}
+	// The initial list is delivered as a resync of the prepopulated cache
+	next()
+
+	unpaused := f.getInstance(ctx, instance)
+	unpaused.ResourceVersion = "2"
+	delete(unpaused.Annotations, kubelitedbv1.PauseAnnotation)
+	if _, err := f.client.KubelitedbV1().SQLiteInstances(unpaused.Namespace).Update(ctx, unpaused, v1.UpdateOptions{}); err != nil {
+		t.Fatalf("error removing the annotation: %v", err)
+	}
+	if got := next(); got != key {
+		t.Fatalf("expected %s to be queued, got %s", key, got)
+	}
+
+	f.run(ctx, c, key)
+	if paused := meta.FindStatusCondition(f.getInstance(ctx, instance).Status.Conditions, kubelitedbv1.ConditionPaused); paused != nil {
+		t.Errorf("expected the Paused condition to be removed, got %+v", paused)
+	}
+	// Fails the test if the StatefulSet was not created once resumed
+	f.getStatefulSet(ctx, instance)
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// phaseForStatus derives the phase and message reported in the status of a
+// SQLiteInstance from the observed state, for clients that predate
+// conditions.
+func phaseForStatus(instance *kubelitedbv1.SQLiteInstance, status *kubelitedbv1.SQLiteInstanceStatus) (kubelitedbv1.SQLiteInstancePhase, string) {
+	if instance.DeletionTimestamp != nil {
+		return kubelitedbv1.PhaseTerminating, ""
+	}
+	if suspended := meta.FindStatusCondition(status.Conditions, kubelitedbv1.ConditionSuspended); suspended != nil && suspended.Status == v1.ConditionTrue {
+		return kubelitedbv1.PhaseSuspended, suspended.Message
+	}
+
+	ready := meta.FindStatusCondition(status.Conditions, kubelitedbv1.ConditionReady)
+	switch {
+	case ready == nil:
+		return kubelitedbv1.PhasePending, ""
+	case ready.Status == v1.ConditionTrue:
+		// A ready instance whose replication is broken still serves, but
+		// its data is no longer durable.
+		if replication := meta.FindStatusCondition(status.Conditions, kubelitedbv1.ConditionReplicationHealthy); replication != nil && replication.Status == v1.ConditionFalse {
+			return kubelitedbv1.PhaseDegraded, replication.Message
+		}
+		return kubelitedbv1.PhaseRunning, ""
+	case meta.IsStatusConditionTrue(status.Conditions, kubelitedbv1.ConditionProgressing):
+		// Pods are still being rolled out. The instance is partially
+		// available as long as some of them are ready.
+		if status.ReadyReplicas > 0 {
+			return kubelitedbv1.PhaseDegraded, ready.Message
+		}
+		return kubelitedbv1.PhaseProvisioning, ready.Message
+	default:
+		return kubelitedbv1.PhaseFailed, ready.Message
+	}
+}
+
+// validPhaseTransition returns whether a SQLiteInstance may go from one phase
+// to the other. A terminating instance never leaves that phase, and an
+// instance only starts out pending, before anything was observed.
+func validPhaseTransition(from, to kubelitedbv1.SQLiteInstancePhase) bool {
+	switch {
+	case from == "" || from == to:
+		return true
+	case from == kubelitedbv1.PhaseTerminating:
+		return false
+	case to == kubelitedbv1.PhasePending:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+func TestPhaseForStatus(t *testing.T) {
+	condition := func(conditionType string, status v1.ConditionStatus) v1.Condition {
+		return v1.Condition{Type: conditionType, Status: status, Message: conditionType + " " + string(status)}
+	}
+	tests := []struct {
+		name          string
+		deleting      bool
+		readyReplicas int32
+		conditions    []v1.Condition
+		want          kubelitedbv1.SQLiteInstancePhase
+		wantMessage   string
+	}{
+		{name: "nothing observed", want: kubelitedbv1.PhasePending},
+		{
+			name:          "every pod ready",
+			readyReplicas: 1,
+			conditions:    []v1.Condition{condition(kubelitedbv1.ConditionReady, v1.ConditionTrue)},
+			want:          kubelitedbv1.PhaseRunning,
+		},
+		{
+			name:          "ready with healthy replication",
+			readyReplicas: 1,
+			conditions: []v1.Condition{
+				condition(kubelitedbv1.ConditionReady, v1.ConditionTrue),
+				condition(kubelitedbv1.ConditionReplicationHealthy, v1.ConditionTrue),
+			},
+			want: kubelitedbv1.PhaseRunning,
+		},
+		{
+			name:          "ready with broken replication",
+			readyReplicas: 1,
+			conditions: []v1.Condition{
+				condition(kubelitedbv1.ConditionReady, v1.ConditionTrue),
+				condition(kubelitedbv1.ConditionReplicationHealthy, v1.ConditionFalse),
+			},
+			want:        kubelitedbv1.PhaseDegraded,
+			wantMessage: "ReplicationHealthy False",
+		},
+		{
+			name: "rolling out without ready pods",
+			conditions: []v1.Condition{
+				condition(kubelitedbv1.ConditionReady, v1.ConditionFalse),
+				condition(kubelitedbv1.ConditionProgressing, v1.ConditionTrue),
+			},
+			want:        kubelitedbv1.PhaseProvisioning,
+			wantMessage: "Ready False",
+		},
+		{
+			name:          "rolling out with some pods ready",
+			readyReplicas: 1,
+			conditions: []v1.Condition{
+				condition(kubelitedbv1.ConditionReady, v1.ConditionFalse),
+				condition(kubelitedbv1.ConditionProgressing, v1.ConditionTrue),
+			},
+			want:        kubelitedbv1.PhaseDegraded,
+			wantMessage: "Ready False",
+		},
+		{
+			name: "not ready and not progressing",
+			conditions: []v1.Condition{
+				condition(kubelitedbv1.ConditionReady, v1.ConditionFalse),
+				condition(kubelitedbv1.ConditionProgressing, v1.ConditionFalse),
+			},
+			want:        kubelitedbv1.PhaseFailed,
+			wantMessage: "Ready False",
+		},
+		{
+			name: "suspended",
+			conditions: []v1.Condition{
+				condition(kubelitedbv1.ConditionReady, v1.ConditionFalse),
+				condition(kubelitedbv1.ConditionSuspended, v1.ConditionTrue),
+			},
+			want:        kubelitedbv1.PhaseSuspended,
+			wantMessage: "Suspended True",
+		},
+		{
+			name:          "being deleted",
+			deleting:      true,
+			readyReplicas: 1,
+			conditions:    []v1.Condition{condition(kubelitedbv1.ConditionReady, v1.ConditionTrue)},
+			want:          kubelitedbv1.PhaseTerminating,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newSQLiteInstance("test")
+			if tt.deleting {
+				now := v1.Now()
+				instance.DeletionTimestamp = &now
+			}
+			status := &kubelitedbv1.SQLiteInstanceStatus{ReadyReplicas: tt.readyReplicas, Conditions: tt.conditions}
+
+			got, message := phaseForStatus(instance, status)
+
+			if got != tt.want || message != tt.wantMessage {
+				t.Errorf("expected phase %s with message %q, got %s with %q", tt.want, tt.wantMessage, got, message)
+			}
+		})
+	}
+}
+
+func TestValidPhaseTransition(t *testing.T) {
+	tests := []struct {
+		from kubelitedbv1.SQLiteInstancePhase
+		to   kubelitedbv1.SQLiteInstancePhase
+		want bool
+	}{
+		{from: "", to: kubelitedbv1.PhasePending, want: true},
+		{from: "", to: kubelitedbv1.PhaseRunning, want: true},
+		{from: kubelitedbv1.PhasePending, to: kubelitedbv1.PhaseProvisioning, want: true},
+		{from: kubelitedbv1.PhaseProvisioning, to: kubelitedbv1.PhaseRunning, want: true},
+		{from: kubelitedbv1.PhaseRunning, to: kubelitedbv1.PhaseDegraded, want: true},
+		{from: kubelitedbv1.PhaseFailed, to: kubelitedbv1.PhaseRunning, want: true},
+		{from: kubelitedbv1.PhaseRunning, to: kubelitedbv1.PhaseTerminating, want: true},
+		{from: kubelitedbv1.PhaseRunning, to: kubelitedbv1.PhaseRunning, want: true},
+		{from: kubelitedbv1.PhaseTerminating, to: kubelitedbv1.PhaseTerminating, want: true},
+		{from: kubelitedbv1.PhaseRunning, to: kubelitedbv1.PhaseSuspended, want: true},
+		{from: kubelitedbv1.PhaseSuspended, to: kubelitedbv1.PhaseProvisioning, want: true},
+		{from: kubelitedbv1.PhaseTerminating, to: kubelitedbv1.PhaseRunning, want: false},
+		{from: kubelitedbv1.PhaseRunning, to: kubelitedbv1.PhasePending, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.from)+" to "+string(tt.to), func(t *testing.T) {
+			if got := validPhaseTransition(tt.from, tt.to); got != tt.want {
+				t.Errorf("expected the transition from %q to %q to be valid %t, got %t", tt.from, tt.to, tt.want, got)
+			}
+		})
+	}
+}
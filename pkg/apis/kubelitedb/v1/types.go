@@ -17,6 +17,9 @@ limitations under the License.
 package v1
 
 import (
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -34,16 +37,521 @@ type SQLiteInstance struct {
 
 // SQLiteInstanceSpec defines the desired state of SQLiteInstance
 type SQLiteInstanceSpec struct {
-	DbName   string `json:"dbName"`
-	Storage  string `json:"storage"`
-	Replicas int    `json:"replicas"`
+	DbName  string `json:"dbName"`
+	Storage string `json:"storage"`
+	// Replicas is the number of writer pods of the StatefulSet, each with
+	// its own volume
+	Replicas int `json:"replicas"`
+	// ReadReplicas is the number of read-only pods opening the database of
+	// the first writer pod. They are reachable through the <name>-sqlite-read
+	// Service, while the writer is reachable through <name>-sqlite-write.
+	ReadReplicas int `json:"readReplicas,omitempty"`
+	// Suspend scales the writer and read-only pods to zero while keeping the
+	// volumes, until it is unset again and the replicas are restored
+	Suspend bool `json:"suspend,omitempty"`
+	// StorageClassName is the storage class requested for the database volume.
+	// The cluster default is used when nil, while an empty string requests a
+	// statically provisioned volume without a class. It can't be changed once
+	// the volumes are provisioned.
+	StorageClassName *string `json:"storageClassName,omitempty"`
+	// AccessMode is the access mode of the database volume. With
+	// ReadWriteOnce, the default, every writer pod gets its own volume. With
+	// ReadWriteMany a single volume is shared by every pod, including the
+	// read-only ones, and only a single writer pod is allowed. It can't be
+	// changed once the volumes are provisioned.
+	AccessMode corev1.PersistentVolumeAccessMode `json:"accessMode,omitempty"`
+	// DeploymentMode is how the pods are run. StatefulSet, the default,
+	// keeps the database on PVCs. DaemonSet runs a pod with its own database
+	// on every node, on a hostPath volume, and rules out the features
+	// relying on PVCs. The databases are left on the nodes when the
+	// SQLiteInstance is deleted. It can't be changed once the pods are
+	// created.
+	DeploymentMode DeploymentMode `json:"deploymentMode,omitempty"`
+	// VolumeClaimAnnotations are set on the data PVCs only, for example for
+	// a CSI driver. They are added to existing PVCs too, but annotations
+	// removed from the spec are left on them.
+	VolumeClaimAnnotations map[string]string `json:"volumeClaimAnnotations,omitempty"`
+	// VolumeClaimName is an existing ReadWriteMany PVC used as the shared
+	// volume instead of creating one, so several SQLiteInstances can keep
+	// their databases on one volume. Requires the ReadWriteMany access mode
+	// and SubPath.
+	VolumeClaimName string `json:"volumeClaimName,omitempty"`
+	// SubPath keeps the database in a directory of the volume named after
+	// DbName, without its .db extension, rather than at the root of the
+	// volume. It can't be changed once the database is created.
+	SubPath bool `json:"subPath,omitempty"`
+	// LegacyDatabasePath is where an earlier version kept the database,
+	// relative to the root of the volume. A database found there is moved to
+	// where the database is kept now before the pods start, once.
+	LegacyDatabasePath string `json:"legacyDatabasePath,omitempty"`
+	// Image is the container image running SQLite. The controller's default
+	// image is used when empty, which is published for every architecture.
+	Image string `json:"image,omitempty"`
+	// Architecture limits the pods to nodes of the given architecture, as in
+	// the kubernetes.io/arch label, such as amd64 or arm64. The pods run on
+	// nodes of any architecture when empty.
+	Architecture string `json:"architecture,omitempty"`
+	// ArchitectureImages map architectures to the images used in place of
+	// Image when the pods are limited to that architecture, for images
+	// published per architecture instead of as a multi-architecture image.
+	ArchitectureImages map[string]string `json:"architectureImages,omitempty"`
+	// Command overrides the entrypoint of the SQLite image. The probes and
+	// the preStop checkpoint still run sqlite3 on DATABASE_PATH, so the
+	// overridden command has to keep serving the database there.
+	Command []string `json:"command,omitempty"`
+	// Args overrides the arguments passed to the entrypoint of the SQLite
+	// image.
+	Args []string `json:"args,omitempty"`
+	// Resources are the compute resources of the SQLite container. Modest
+	// requests are set by the controller when empty.
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+	// Env are environment variables set in the SQLite container. They can't
+	// override the variables set by the controller.
+	Env []corev1.EnvVar `json:"env,omitempty"`
+	// EnvFrom are sources of environment variables set in the SQLite
+	// container. Variables set by the controller take precedence.
+	EnvFrom []corev1.EnvFromSource `json:"envFrom,omitempty"`
+	// PodSecurityContext replaces the security context of the pods. The
+	// controller's default runs them as a non-root user that owns the data
+	// volume, and meets the restricted Pod Security Standard.
+	PodSecurityContext *corev1.PodSecurityContext `json:"podSecurityContext,omitempty"`
+	// SecurityContext replaces the security context of every container of
+	// the pods. The controller's default drops every capability and forbids
+	// privilege escalation.
+	SecurityContext *corev1.SecurityContext `json:"securityContext,omitempty"`
+	// Sidecars are added to the writer pods after the containers of the
+	// controller, for example logging agents or exporters. Sidecars without
+	// a security context get the one of the other containers.
+	Sidecars []corev1.Container `json:"sidecars,omitempty"`
+	// Volumes are added to the writer pods, to be mounted by the sidecars
+	Volumes []corev1.Volume `json:"volumes,omitempty"`
+	// Labels are set on every object created for the SQLiteInstance. They
+	// can't override the labels the controller relies on.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Annotations are set on every object created for the SQLiteInstance
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// NodeSelector constrains the pods to nodes with matching labels
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// Affinity sets the scheduling constraints of the pods
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+	// Tolerations allow the pods to be scheduled onto nodes with matching
+	// taints
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+	// TopologySpreadConstraints spread the pods of the StatefulSet across
+	// the topology of the cluster
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+	// SpreadAcrossZones spreads the pods of the StatefulSet across zones, in
+	// addition to the TopologySpreadConstraints
+	SpreadAcrossZones bool `json:"spreadAcrossZones,omitempty"`
+	// PriorityClassName is the priority class of the pods. The pods have no
+	// priority class when empty.
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+	// ImagePullSecrets are used to pull the SQLite image from a private
+	// registry. No pull secrets are used when empty.
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+	// ServiceAccountName is the service account the pods run as, including
+	// those replicating and backing up the database. The default service
+	// account of the namespace is used when empty.
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+	// UpdateStrategy is how the pods are replaced when the pod template
+	// changes. Defaults to a rolling update of every pod.
+	UpdateStrategy *UpdateStrategy `json:"updateStrategy,omitempty"`
+	// TerminationGracePeriodSeconds is how long the pods have to checkpoint
+	// the WAL and finish replicating it when they are stopped. Defaults to
+	// 60 seconds.
+	TerminationGracePeriodSeconds *int64 `json:"terminationGracePeriodSeconds,omitempty"`
+	// Probes override the timings of the probes of the SQLite container
+	Probes *ProbesSpec `json:"probes,omitempty"`
+	// Pragmas are the SQLite PRAGMAs applied to the database when the pods
+	// start, keyed by name. Only a known safe set of pragmas is allowed.
+	Pragmas map[string]string `json:"pragmas,omitempty"`
+	// Replication continuously replicates the database to object storage
+	// with a Litestream sidecar. Replication is disabled when unset.
+	Replication *ReplicationSpec `json:"replication,omitempty"`
+	// RestoreFrom hydrates the database from a backup when the SQLiteInstance
+	// is created. The restore only runs once.
+	RestoreFrom *RestoreSource `json:"restoreFrom,omitempty"`
+	// CloneFrom copies the database of another SQLiteInstance in the same
+	// namespace when the SQLiteInstance is created. The clone only runs once.
+	CloneFrom *corev1.LocalObjectReference `json:"cloneFrom,omitempty"`
+	// BackupSchedule is the cron schedule the database is backed up on to
+	// BackupDestination. No scheduled backups are taken when empty.
+	BackupSchedule string `json:"backupSchedule,omitempty"`
+	// InitSQL seeds the database when it is first created. It is not run
+	// again once the database exists.
+	InitSQL *InitSQLSource `json:"initSQL,omitempty"`
+	// BackupDestination is where scheduled backups are uploaded to
+	BackupDestination *BackupDestination `json:"backupDestination,omitempty"`
+	// BackupRetention prunes old scheduled backups after every scheduled
+	// backup. Backups are kept forever when unset.
+	BackupRetention *BackupRetention `json:"backupRetention,omitempty"`
+	// BackupStaleAfter sets the BackupStale condition when the database was
+	// not backed up for longer. Staleness is not tracked when unset.
+	BackupStaleAfter *metav1.Duration `json:"backupStaleAfter,omitempty"`
+	// BackupEncryption encrypts the SQLiteBackups and scheduled backups of
+	// the database before they are uploaded. Backups are only encrypted by
+	// the object storage when unset.
+	BackupEncryption *BackupEncryption `json:"backupEncryption,omitempty"`
+	// IntegrityCheck checks a snapshot of the database for corruption with
+	// PRAGMA integrity_check on a schedule. The database is not checked when
+	// unset.
+	IntegrityCheck *IntegrityCheckSpec `json:"integrityCheck,omitempty"`
+	// WAL tunes how the write-ahead log is checkpointed into the database
+	WAL *WALSpec `json:"wal,omitempty"`
+	// TempDir keeps the temp files of SQLite on an emptyDir volume, which
+	// SQLITE_TMPDIR points to
+	TempDir *TempDirSpec `json:"tempDir,omitempty"`
+	// TLS terminates TLS in front of the SQLite container. The Services only
+	// expose a port when it is set.
+	TLS *TLSSpec `json:"tls,omitempty"`
+	// ServiceType is the type of the client Service resolving to the writer
+	// pod, which is created along with TLS. Defaults to ClusterIP.
+	ServiceType corev1.ServiceType `json:"serviceType,omitempty"`
+	// ServiceAnnotations are set on the client Service only, for example to
+	// configure a LoadBalancer.
+	ServiceAnnotations map[string]string `json:"serviceAnnotations,omitempty"`
+}
+
+// TLSPort is the port the pods and Services of a SQLiteInstance serve TLS
+// connections on
+const TLSPort = 8443
+
+// TLSSpec configures the proxy terminating TLS for connections to the SQLite
+// container
+type TLSSpec struct {
+	// SecretRef references the kubernetes.io/tls Secret holding the
+	// certificate and key served. Rotating the Secret rolls the pods.
+	SecretRef corev1.LocalObjectReference `json:"secretRef"`
+	// Port is the plaintext port the SQLite container serves connections on.
+	// The decrypted connections are forwarded to it.
+	Port int32 `json:"port"`
+}
+
+// DeploymentMode is how the pods of a SQLiteInstance are run
+type DeploymentMode string
+
+const (
+	// DeploymentModeStatefulSet runs the pods in a StatefulSet, with the
+	// database on PVCs
+	DeploymentModeStatefulSet DeploymentMode = "StatefulSet"
+	// DeploymentModeDaemonSet runs a pod on every node in a DaemonSet, each
+	// with its own database on a hostPath volume
+	DeploymentModeDaemonSet DeploymentMode = "DaemonSet"
+)
+
+// UpdateStrategy is how the pods of a SQLiteInstance are replaced when the
+// pod template changes
+type UpdateStrategy struct {
+	// Type is RollingUpdate, replacing the pods one at a time, or OnDelete,
+	// only replacing pods once they are deleted. Defaults to RollingUpdate.
+	Type appsv1.StatefulSetUpdateStrategyType `json:"type,omitempty"`
+	// Partition stages a rolling update: only the pods with an ordinal of at
+	// least Partition are replaced. Defaults to 0.
+	Partition *int32 `json:"partition,omitempty"`
+}
+
+// WALSpec tunes how the write-ahead log of the database is checkpointed
+type WALSpec struct {
+	// AutoCheckpoint is the number of pages the WAL grows to before it is
+	// checkpointed by the connection writing to it, set with the
+	// wal_autocheckpoint pragma. Zero disables automatic checkpoints.
+	AutoCheckpoint *int32 `json:"autoCheckpoint,omitempty"`
+	// CheckpointInterval checkpoints the WAL periodically from a sidecar.
+	// The checkpoint is passive while Litestream replicates the database,
+	// as Litestream controls checkpoints itself.
+	CheckpointInterval *metav1.Duration `json:"checkpointInterval,omitempty"`
+}
+
+// TempDirSpec configures the emptyDir volume SQLite keeps its temp files on
+type TempDirSpec struct {
+	// Medium is the storage medium of the volume. Memory keeps the temp files
+	// in a tmpfs, counting against the memory limit of the pod. Defaults to
+	// the disk of the node.
+	Medium corev1.StorageMedium `json:"medium,omitempty"`
+	// SizeLimit is the most storage the temp files can take up, like 256Mi.
+	// The volume is not limited when unset.
+	SizeLimit string `json:"sizeLimit,omitempty"`
+}
+
+// BackupRetention limits how many scheduled backups are kept. A backup is
+// pruned when it is beyond either limit.
+type BackupRetention struct {
+	// Count is the number of most recent backups kept
+	Count *int32 `json:"count,omitempty"`
+	// MaxAge is how long backups are kept for
+	MaxAge *metav1.Duration `json:"maxAge,omitempty"`
+}
+
+// BackupDestination locates where backups are uploaded in S3 compatible
+// object storage
+type BackupDestination struct {
+	// Bucket is the name of the bucket backups are uploaded to
+	Bucket string `json:"bucket"`
+	// Path is the path within the bucket backups are uploaded under.
+	// Defaults to the namespace and name of the SQLiteInstance.
+	Path string `json:"path,omitempty"`
+	// Endpoint is the URL of the object storage, for S3 compatible storage
+	// other than AWS
+	Endpoint string `json:"endpoint,omitempty"`
+	// SecretRef references the Secret holding the AWS_ACCESS_KEY_ID and
+	// AWS_SECRET_ACCESS_KEY used to access the bucket
+	SecretRef corev1.LocalObjectReference `json:"secretRef"`
+}
+
+// ProbesSpec overrides the timings of the probes of the SQLite container
+type ProbesSpec struct {
+	// Startup overrides the timings of the startup probe
+	Startup *ProbeTimings `json:"startup,omitempty"`
+	// Liveness overrides the timings of the liveness probe
+	Liveness *ProbeTimings `json:"liveness,omitempty"`
+	// Readiness overrides the timings of the readiness probe
+	Readiness *ProbeTimings `json:"readiness,omitempty"`
+}
+
+// ProbeTimings are the timings of a probe. Fields left at zero keep the
+// controller's defaults.
+type ProbeTimings struct {
+	InitialDelaySeconds int32 `json:"initialDelaySeconds,omitempty"`
+	PeriodSeconds       int32 `json:"periodSeconds,omitempty"`
+	TimeoutSeconds      int32 `json:"timeoutSeconds,omitempty"`
+	FailureThreshold    int32 `json:"failureThreshold,omitempty"`
+}
+
+// InitSQLSource is a SQL script seeding a new database, given either inline
+// or as a key of a ConfigMap
+type InitSQLSource struct {
+	// Inline is the SQL script
+	Inline string `json:"inline,omitempty"`
+	// ConfigMapKeyRef selects the key of a ConfigMap holding the SQL script
+	ConfigMapKeyRef *corev1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+}
+
+// RestoreSource locates a backup in S3 compatible object storage
+type RestoreSource struct {
+	// Bucket is the name of the bucket holding the backup
+	Bucket string `json:"bucket"`
+	// Key is the key of the backup within the bucket
+	Key string `json:"key"`
+	// Endpoint is the URL of the object storage, for S3 compatible storage
+	// other than AWS
+	Endpoint string `json:"endpoint,omitempty"`
+	// SecretRef references the Secret holding the AWS_ACCESS_KEY_ID and
+	// AWS_SECRET_ACCESS_KEY used to access the bucket
+	SecretRef corev1.LocalObjectReference `json:"secretRef"`
+	// Encryption decrypts a backup encrypted with spec.backupEncryption
+	// before it is restored
+	Encryption *BackupEncryption `json:"encryption,omitempty"`
+}
+
+// BackupEncryption references the key backups are encrypted with. The same
+// key has to be given to restore them.
+type BackupEncryption struct {
+	// SecretRef references the Secret holding the key under the key "key".
+	// The key has to be at least 32 bytes long.
+	SecretRef corev1.LocalObjectReference `json:"secretRef"`
+}
+
+// ReplicationSpec configures continuous replication of the database to S3
+// compatible object storage
+type ReplicationSpec struct {
+	// Bucket is the name of the bucket the database is replicated to
+	Bucket string `json:"bucket"`
+	// Path is the path of the replica within the bucket. Defaults to the
+	// namespace and name of the SQLiteInstance.
+	Path string `json:"path,omitempty"`
+	// Endpoint is the URL of the object storage, for S3 compatible storage
+	// other than AWS
+	Endpoint string `json:"endpoint,omitempty"`
+	// SecretRef references the Secret holding the AWS_ACCESS_KEY_ID and
+	// AWS_SECRET_ACCESS_KEY used to access the bucket
+	SecretRef corev1.LocalObjectReference `json:"secretRef"`
 }
 
 // SQLiteInstanceStatus defines the observed state of SQLiteInstance
 type SQLiteInstanceStatus struct {
-	Phase string `json:"phase"`
+	// Phase is a summary of the conditions, kept for backward compatibility
+	Phase SQLiteInstancePhase `json:"phase"`
+	// Message is a human readable explanation of the current phase
+	Message string `json:"message,omitempty"`
+	// ObservedGeneration is the most recent generation of the spec that was
+	// reconciled successfully
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// ObservedSpecHash is the hash of the spec, labels and annotations the
+	// child objects were last reconciled from
+	ObservedSpecHash string `json:"observedSpecHash,omitempty"`
+	// Replicas is the number of pods of the StatefulSet
+	Replicas int32 `json:"replicas,omitempty"`
+	// Selector is the label selector of the pods of the StatefulSet, for the
+	// scale subresource
+	Selector string `json:"selector,omitempty"`
+	// ReadyReplicas is the number of ready pods of the StatefulSet
+	ReadyReplicas int32 `json:"readyReplicas,omitempty"`
+	// WriterReadyReplicas is the number of ready writer pods
+	WriterReadyReplicas int32 `json:"writerReadyReplicas,omitempty"`
+	// ReaderReadyReplicas is the number of ready read-only pods
+	ReaderReadyReplicas int32 `json:"readerReadyReplicas,omitempty"`
+	// Restored is set once the database was restored from spec.restoreFrom,
+	// so the restore is not run again
+	Restored bool `json:"restored,omitempty"`
+	// Cloned is set once the database was cloned from spec.cloneFrom, so the
+	// clone is not run again
+	Cloned bool `json:"cloned,omitempty"`
+	// LastBackupTime is when the last successful backup of the database,
+	// scheduled or not, completed
+	LastBackupTime *metav1.Time `json:"lastBackupTime,omitempty"`
+	// LastBackupLocation is where the last successful backup was uploaded
+	// to. For scheduled backups it's the prefix the runs are uploaded under.
+	LastBackupLocation string `json:"lastBackupLocation,omitempty"`
+	// LastIntegrityCheck is when the last scheduled integrity check of the
+	// database finished
+	LastIntegrityCheck *metav1.Time `json:"lastIntegrityCheck,omitempty"`
+	// IntegrityOK is whether the last scheduled integrity check passed
+	IntegrityOK *bool `json:"integrityOK,omitempty"`
+	// Endpoints are the in-cluster DNS names the SQLiteInstance can be
+	// reached at. Only Services and pods that are ready are listed.
+	Endpoints []string `json:"endpoints,omitempty"`
+	// Volumes are the data volumes of the SQLiteInstance
+	Volumes []VolumeStatus `json:"volumes,omitempty"`
+	// LastError is the error the last sync of the SQLiteInstance failed
+	// with. It is cleared by the next successful sync.
+	LastError *SyncError `json:"lastError,omitempty"`
+	// Conditions represent the latest available observations of the state
+	// of the SQLiteInstance
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// IntegrityCheckSpec schedules integrity checks of the database
+type IntegrityCheckSpec struct {
+	// Schedule is the cron schedule the database is checked on
+	Schedule string `json:"schedule"`
+}
+
+// VolumeStatus is the observed state of a data volume of a SQLiteInstance
+type VolumeStatus struct {
+	// ClaimName is the name of the PVC
+	ClaimName string `json:"claimName"`
+	// Phase is the phase of the PVC
+	Phase corev1.PersistentVolumeClaimPhase `json:"phase,omitempty"`
+	// Requested is the storage requested by the PVC
+	Requested *resource.Quantity `json:"requested,omitempty"`
+	// Capacity is the storage of the volume bound to the PVC
+	Capacity *resource.Quantity `json:"capacity,omitempty"`
+	// Used is the storage used on the volume, as reported by the kubelet.
+	// It is only collected with a storage pressure threshold configured.
+	Used *resource.Quantity `json:"used,omitempty"`
 }
 
+// SyncError is an error a sync of a SQLiteInstance failed with
+type SyncError struct {
+	// Reason is a machine readable reason for the error
+	Reason string `json:"reason"`
+	// Message is the error
+	Message string `json:"message"`
+	// Time is when the error first occurred
+	Time metav1.Time `json:"time"`
+	// RetryCount is the number of retries that failed with the same error
+	// since
+	RetryCount int32 `json:"retryCount,omitempty"`
+}
+
+// SQLiteInstancePhase is a summary of the state of a SQLiteInstance
+type SQLiteInstancePhase string
+
+const (
+	// PhasePending is the phase of a SQLiteInstance that was not reconciled
+	// yet
+	PhasePending SQLiteInstancePhase = "Pending"
+	// PhaseProvisioning is the phase of a SQLiteInstance whose pods are being
+	// rolled out, none of which is ready yet
+	PhaseProvisioning SQLiteInstancePhase = "Provisioning"
+	// PhaseRunning is the phase of a SQLiteInstance whose pods are all ready
+	PhaseRunning SQLiteInstancePhase = "Running"
+	// PhaseDegraded is the phase of a SQLiteInstance that is only partially
+	// available, or whose replication is broken
+	PhaseDegraded SQLiteInstancePhase = "Degraded"
+	// PhaseFailed is the phase of a SQLiteInstance that cannot be reconciled
+	// until its spec is changed
+	PhaseFailed SQLiteInstancePhase = "Failed"
+	// PhaseSuspended is the phase of a SQLiteInstance scaled to zero by
+	// spec.suspend
+	PhaseSuspended SQLiteInstancePhase = "Suspended"
+	// PhaseTerminating is the phase of a SQLiteInstance that is being deleted
+	PhaseTerminating SQLiteInstancePhase = "Terminating"
+)
+
+const (
+	// ConditionReady indicates whether the SQLiteInstance is ready to serve
+	ConditionReady = "Ready"
+	// ConditionProgressing indicates whether changes to the SQLiteInstance are
+	// still being rolled out
+	ConditionProgressing = "Progressing"
+	// ConditionStorageProvisioned indicates whether the storage requested by
+	// the SQLiteInstance could be provisioned
+	ConditionStorageProvisioned = "StorageProvisioned"
+	// ConditionReplicationHealthy indicates whether the database is being
+	// replicated to object storage by every pod
+	ConditionReplicationHealthy = "ReplicationHealthy"
+	// ConditionScaleDownBlocked indicates whether a scale down of the
+	// SQLiteInstance was blocked as it would remove the writer pod
+	ConditionScaleDownBlocked = "ScaleDownBlocked"
+	// ConditionBackupScheduled indicates whether scheduled backups of the
+	// database are set up
+	ConditionBackupScheduled = "BackupScheduled"
+	// ConditionCloned indicates whether the database was cloned from
+	// spec.cloneFrom
+	ConditionCloned = "Cloned"
+	// ConditionPaused indicates whether reconciliation of the SQLiteInstance
+	// is paused by the PauseAnnotation
+	ConditionPaused = "Paused"
+	// ConditionReplicasLimited indicates whether the replicas of the
+	// SQLiteInstance exceed the maximum allowed by the controller
+	ConditionReplicasLimited = "ReplicasLimited"
+	// ConditionStoragePressure indicates whether a data volume of the
+	// SQLiteInstance is running out of space
+	ConditionStoragePressure = "StoragePressure"
+	// ConditionDeletionBlocked indicates whether the deletion of the
+	// SQLiteInstance waits for the snapshot requested by the
+	// SnapshotOnDeleteAnnotation
+	ConditionDeletionBlocked = "DeletionBlocked"
+	// ConditionBackupStale indicates whether the last successful backup of
+	// the database is older than spec.backupStaleAfter
+	ConditionBackupStale = "BackupStale"
+	// ConditionResizing indicates whether the data volumes of the
+	// SQLiteInstance are being expanded to spec.storage
+	ConditionResizing = "Resizing"
+	// ConditionSuspended indicates whether the pods of the SQLiteInstance are
+	// scaled to zero by spec.suspend
+	ConditionSuspended = "Suspended"
+	// ConditionMigrated indicates whether every pod of the SQLiteInstance
+	// moved its database from spec.legacyDatabasePath
+	ConditionMigrated = "Migrated"
+	// ConditionDeferredUpdate indicates whether changes to the pods of the
+	// SQLiteInstance wait for its MaintenanceWindowAnnotation
+	ConditionDeferredUpdate = "DeferredUpdate"
+	// ConditionCorrupted indicates whether the last integrity check of the
+	// database of the SQLiteInstance failed
+	ConditionCorrupted = "Corrupted"
+)
+
+// AllowDataLossAnnotation allows scaling a SQLiteInstance down to zero
+// replicas, removing the writer pod, when set to "true"
+const AllowDataLossAnnotation = "kubelitedb.fortytwoapps.tech/allow-data-loss"
+
+// PauseAnnotation stops the controller from changing the child objects of a
+// SQLiteInstance when set to "true", for example during maintenance
+const PauseAnnotation = "kubelitedb.fortytwoapps.tech/pause"
+
+// SnapshotOnDeleteAnnotation backs up the database to spec.backupDestination
+// before a SQLiteInstance is deleted when set to "true". The deletion waits
+// for the backup to complete.
+const SnapshotOnDeleteAnnotation = "kubelitedb.fortytwoapps.tech/snapshot-on-delete"
+
+// MaintenanceWindowAnnotation limits changes rolling the pods of a
+// SQLiteInstance to a weekly window, such as "Sat 02:00-04:00" or
+// "Sat,Sun 02:00-04:00 Europe/Berlin". Changes that don't restart the pods
+// are made at any time.
+const MaintenanceWindowAnnotation = "kubelitedb.fortytwoapps.tech/maintenance-window"
+
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // SQLiteInstanceList contains a list of SQLiteInstance
@@ -53,3 +561,61 @@ type SQLiteInstanceList struct {
 
 	Items []SQLiteInstance `json:"items"`
 }
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// SQLiteBackup is a specification for a one-off backup of a SQLiteInstance
+type SQLiteBackup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SQLiteBackupSpec   `json:"spec"`
+	Status SQLiteBackupStatus `json:"status"`
+}
+
+// SQLiteBackupSpec defines the desired state of SQLiteBackup
+type SQLiteBackupSpec struct {
+	// InstanceName is the name of the SQLiteInstance to back up, in the same
+	// namespace as the SQLiteBackup
+	InstanceName string `json:"instanceName"`
+	// Bucket is the name of the bucket the backup is uploaded to
+	Bucket string `json:"bucket"`
+	// Path is the path within the bucket the backup is uploaded under.
+	// Defaults to the namespace and name of the SQLiteInstance.
+	Path string `json:"path,omitempty"`
+	// Endpoint is the URL of the object storage, for S3 compatible storage
+	// other than AWS
+	Endpoint string `json:"endpoint,omitempty"`
+	// SecretRef references the Secret holding the AWS_ACCESS_KEY_ID and
+	// AWS_SECRET_ACCESS_KEY used to access the bucket
+	SecretRef corev1.LocalObjectReference `json:"secretRef"`
+}
+
+// SQLiteBackupStatus defines the observed state of SQLiteBackup
+type SQLiteBackupStatus struct {
+	// Phase is a summary of the conditions
+	Phase string `json:"phase"`
+	// ArtifactPath is the location of the uploaded backup, once completed
+	ArtifactPath string `json:"artifactPath,omitempty"`
+	// CompletionTime is the time the backup completed
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// Conditions represent the latest available observations of the state
+	// of the SQLiteBackup
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+const (
+	// ConditionComplete indicates whether the SQLiteBackup completed
+	ConditionComplete = "Complete"
+)
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// SQLiteBackupList contains a list of SQLiteBackup
+type SQLiteBackupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []SQLiteBackup `json:"items"`
+}
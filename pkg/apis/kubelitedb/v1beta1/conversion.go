@@ -0,0 +1,45 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// ConvertToV1 converts a v1beta1 SQLiteInstance to v1, the storage version
+func ConvertToV1(in *SQLiteInstance) *kubelitedbv1.SQLiteInstance {
+	out := &kubelitedbv1.SQLiteInstance{
+		ObjectMeta: *in.ObjectMeta.DeepCopy(),
+		Spec:       *in.Spec.DeepCopy(),
+		Status:     *in.Status.DeepCopy(),
+	}
+	out.APIVersion = kubelitedbv1.SchemeGroupVersion.String()
+	out.Kind = "SQLiteInstance"
+	return out
+}
+
+// ConvertFromV1 converts a v1 SQLiteInstance to v1beta1
+func ConvertFromV1(in *kubelitedbv1.SQLiteInstance) *SQLiteInstance {
+	out := &SQLiteInstance{
+		ObjectMeta: *in.ObjectMeta.DeepCopy(),
+		Spec:       *in.Spec.DeepCopy(),
+		Status:     *in.Status.DeepCopy(),
+	}
+	out.APIVersion = SchemeGroupVersion.String()
+	out.Kind = "SQLiteInstance"
+	return out
+}
@@ -0,0 +1,22 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// +k8s:deepcopy-gen=package
+// +groupName=kubelitedb.fortytwoapps.tech
+
+// Package v1beta1 is the v1beta1 version of the API. It is only served for
+// older clients, SQLiteInstances are stored as v1.
+package v1beta1 // import "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1beta1"
@@ -28,6 +28,10 @@ type FakeKubelitedbV1 struct {
 	*testing.Fake
 }
 
+func (c *FakeKubelitedbV1) SQLiteBackups(namespace string) v1.SQLiteBackupInterface {
+	return &FakeSQLiteBackups{c, namespace}
+}
+
 func (c *FakeKubelitedbV1) SQLiteInstances(namespace string) v1.SQLiteInstanceInterface {
 	return &FakeSQLiteInstances{c, namespace}
 }
@@ -28,6 +28,7 @@ import (
 
 type KubelitedbV1Interface interface {
 	RESTClient() rest.Interface
+	SQLiteBackupsGetter
 	SQLiteInstancesGetter
 }
 
@@ -36,6 +37,10 @@ type KubelitedbV1Client struct {
 	restClient rest.Interface
 }
 
+func (c *KubelitedbV1Client) SQLiteBackups(namespace string) SQLiteBackupInterface {
+	return newSQLiteBackups(c, namespace)
+}
+
 func (c *KubelitedbV1Client) SQLiteInstances(namespace string) SQLiteInstanceInterface {
 	return newSQLiteInstances(c, namespace)
 }
@@ -53,6 +53,8 @@ func (f *genericInformer) Lister() cache.GenericLister {
 func (f *sharedInformerFactory) ForResource(resource schema.GroupVersionResource) (GenericInformer, error) {
 	switch resource {
 	// Group=kubelitedb.fortytwoapps.tech, Version=v1
+	case v1.SchemeGroupVersion.WithResource("sqlitebackups"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Kubelitedb().V1().SQLiteBackups().Informer()}, nil
 	case v1.SchemeGroupVersion.WithResource("sqliteinstances"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Kubelitedb().V1().SQLiteInstances().Informer()}, nil
 
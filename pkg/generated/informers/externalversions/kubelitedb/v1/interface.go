@@ -24,6 +24,8 @@ import (
 
 // Interface provides access to all the informers in this group version.
 type Interface interface {
+	// SQLiteBackups returns a SQLiteBackupInformer.
+	SQLiteBackups() SQLiteBackupInformer
 	// SQLiteInstances returns a SQLiteInstanceInformer.
 	SQLiteInstances() SQLiteInstanceInformer
 }
@@ -39,6 +41,11 @@ func New(f internalinterfaces.SharedInformerFactory, namespace string, tweakList
 	return &version{factory: f, namespace: namespace, tweakListOptions: tweakListOptions}
 }
 
+// SQLiteBackups returns a SQLiteBackupInformer.
+func (v *version) SQLiteBackups() SQLiteBackupInformer {
+	return &sQLiteBackupInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
+
 // SQLiteInstances returns a SQLiteInstanceInformer.
 func (v *version) SQLiteInstances() SQLiteInstanceInformer {
 	return &sQLiteInstanceInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
@@ -18,6 +18,14 @@ limitations under the License.
 
 package v1
 
+// SQLiteBackupListerExpansion allows custom methods to be added to
+// SQLiteBackupLister.
+type SQLiteBackupListerExpansion interface{}
+
+// SQLiteBackupNamespaceListerExpansion allows custom methods to be added to
+// SQLiteBackupNamespaceLister.
+type SQLiteBackupNamespaceListerExpansion interface{}
+
 // SQLiteInstanceListerExpansion allows custom methods to be added to
 // SQLiteInstanceLister.
 type SQLiteInstanceListerExpansion interface{}
@@ -0,0 +1,159 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics defines the Prometheus metrics exposed by the controller.
+package metrics
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "kubelitedb"
+
+const (
+	// ResultSuccess labels reconciles that completed without error
+	ResultSuccess = "success"
+	// ResultError labels reconciles that returned an error
+	ResultError = "error"
+)
+
+var (
+	// Registry is the registry all controller metrics are registered with
+	Registry = prometheus.NewRegistry()
+
+	// ReconcileTotal counts the reconciles of SQLiteInstances by result
+	ReconcileTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconcile_total",
+		Help:      "Total number of SQLiteInstance reconciles, by result.",
+	}, []string{"result"})
+
+	// ReconcileDuration observes how long reconciles of SQLiteInstances take
+	ReconcileDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "reconcile_duration_seconds",
+		Help:      "Duration of SQLiteInstance reconciles in seconds.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	// WorkqueueDepth reports the number of SQLiteInstances waiting to be
+	// reconciled
+	WorkqueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "workqueue_depth",
+		Help:      "Current number of SQLiteInstances waiting in the workqueue.",
+	})
+
+	// Instances reports the number of SQLiteInstances in each phase
+	Instances = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "instances",
+		Help:      "Current number of SQLiteInstances, by phase.",
+	}, []string{"phase"})
+
+	// ReconcileRetries counts the failed reconciles of each SQLiteInstance
+	// since it last reconciled successfully. Its cardinality grows with the
+	// number of failing SQLiteInstances rather than all of them, as the
+	// series of an instance is removed once it reconciles again, but a
+	// cluster-wide outage can still add a series per SQLiteInstance.
+	ReconcileRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconcile_retries_total",
+		Help:      "Number of times a SQLiteInstance was requeued with backoff since its last successful reconcile.",
+	}, []string{"namespace", "name"})
+
+	// IsLeader reports whether the replica holds the leader election lease.
+	// A replica running without leader election is always the leader.
+	IsLeader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "is_leader",
+		Help:      "Whether this replica of the controller is the leader, 1 when it is and 0 otherwise.",
+	})
+)
+
+// AddRetry counts a requeue with backoff of the SQLiteInstance with the given
+// key in ReconcileRetries.
+func AddRetry(key string) {
+	namespace, name, _ := strings.Cut(key, "/")
+	ReconcileRetries.WithLabelValues(namespace, name).Inc()
+}
+
+// ForgetRetries removes the SQLiteInstance with the given key from
+// ReconcileRetries once it is no longer retried.
+func ForgetRetries(key string) {
+	namespace, name, _ := strings.Cut(key, "/")
+	ReconcileRetries.DeleteLabelValues(namespace, name)
+}
+
+// instancePhases tracks the phase every SQLiteInstance is counted under in
+// Instances, so it can be moved or removed when the phase changes.
+var instancePhases = struct {
+	sync.Mutex
+	phases map[string]string
+}{phases: map[string]string{}}
+
+// SetInstancePhase counts the SQLiteInstance with the given key under phase in
+// Instances, no longer counting it under its previous phase.
+func SetInstancePhase(key, phase string) {
+	instancePhases.Lock()
+	defer instancePhases.Unlock()
+
+	previous, ok := instancePhases.phases[key]
+	if ok && previous == phase {
+		return
+	}
+	if ok {
+		Instances.WithLabelValues(previous).Dec()
+	}
+	Instances.WithLabelValues(phase).Inc()
+	instancePhases.phases[key] = phase
+}
+
+// DeleteInstance stops counting the deleted SQLiteInstance with the given key
+// in Instances.
+func DeleteInstance(key string) {
+	instancePhases.Lock()
+	defer instancePhases.Unlock()
+
+	if previous, ok := instancePhases.phases[key]; ok {
+		Instances.WithLabelValues(previous).Dec()
+		delete(instancePhases.phases, key)
+	}
+}
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		ReconcileTotal,
+		ReconcileDuration,
+		WorkqueueDepth,
+		Instances,
+		ReconcileRetries,
+		IsLeader,
+	)
+}
+
+// Handler returns the http.Handler serving the metrics in Registry
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
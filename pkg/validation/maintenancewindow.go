@@ -0,0 +1,146 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validation
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// weekdays maps the abbreviated day names of a maintenance window to the
+// days they stand for
+var weekdays = map[string]time.Weekday{
+	"Sun": time.Sunday,
+	"Mon": time.Monday,
+	"Tue": time.Tuesday,
+	"Wed": time.Wednesday,
+	"Thu": time.Thursday,
+	"Fri": time.Friday,
+	"Sat": time.Saturday,
+}
+
+// MaintenanceWindow is a weekly window of time, such as the one of the
+// MaintenanceWindowAnnotation.
+type MaintenanceWindow struct {
+	// Days are the days the window starts on
+	Days []time.Weekday
+	// Start is the time of day the window starts at, as a duration since
+	// midnight
+	Start time.Duration
+	// Duration is how long the window lasts. A window may run into the next
+	// day.
+	Duration time.Duration
+	// Location is the time zone the window is given in
+	Location *time.Location
+}
+
+// ParseMaintenanceWindow parses a maintenance window of the form
+// "Sat 02:00-04:00", optionally followed by an IANA time zone such as
+// "Europe/Berlin". Several days are separated by commas, as in
+// "Sat,Sun 02:00-04:00". Times are in UTC unless a time zone is given, and a
+// window ending before it starts ends the next day.
+func ParseMaintenanceWindow(s string) (*MaintenanceWindow, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 && len(fields) != 3 {
+		return nil, fmt.Errorf("must be of the form \"Sat 02:00-04:00\", optionally followed by a time zone")
+	}
+
+	window := &MaintenanceWindow{Location: time.UTC}
+	for _, name := range strings.Split(fields[0], ",") {
+		day, ok := weekdays[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown day %q, must be one of Mon, Tue, Wed, Thu, Fri, Sat and Sun", name)
+		}
+		window.Days = append(window.Days, day)
+	}
+
+	start, end, ok := strings.Cut(fields[1], "-")
+	if !ok {
+		return nil, fmt.Errorf("times %q must be of the form 02:00-04:00", fields[1])
+	}
+	var err error
+	if window.Start, err = parseTimeOfDay(start); err != nil {
+		return nil, err
+	}
+	endOfWindow, err := parseTimeOfDay(end)
+	if err != nil {
+		return nil, err
+	}
+	if endOfWindow == window.Start {
+		return nil, fmt.Errorf("must not start and end at the same time")
+	}
+	window.Duration = endOfWindow - window.Start
+	if window.Duration < 0 {
+		window.Duration += 24 * time.Hour
+	}
+
+	if len(fields) == 3 {
+		if window.Location, err = time.LoadLocation(fields[2]); err != nil {
+			return nil, fmt.Errorf("unknown time zone %q", fields[2])
+		}
+	}
+	return window, nil
+}
+
+// parseTimeOfDay parses a time of day of the form 15:04 into the duration
+// since midnight.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("time %q must be of the form 15:04", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// startOn returns when the window starts on the day of the given time, in
+// the time zone of the window, or false when the window doesn't start on
+// that day. The start is computed from the wall clock, so the window keeps
+// its time of day across daylight saving time changes.
+func (w *MaintenanceWindow) startOn(day time.Time) (time.Time, bool) {
+	for _, d := range w.Days {
+		if d == day.Weekday() {
+			hour, minute := int(w.Start/time.Hour), int(w.Start%time.Hour/time.Minute)
+			return time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, w.Location), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Contains returns whether the given time falls into the window.
+func (w *MaintenanceWindow) Contains(now time.Time) bool {
+	now = now.In(w.Location)
+	// A window may have started the day before and run past midnight
+	for _, day := range []time.Time{now, now.AddDate(0, 0, -1)} {
+		if start, ok := w.startOn(day); ok && !now.Before(start) && now.Before(start.Add(w.Duration)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns when the window starts next after the given time.
+func (w *MaintenanceWindow) Next(now time.Time) time.Time {
+	now = now.In(w.Location)
+	for days := 0; days <= 7; days++ {
+		if start, ok := w.startOn(now.AddDate(0, 0, days)); ok && start.After(now) {
+			return start
+		}
+	}
+	// Unreachable for a parsed window, which starts at least once a week
+	return now
+}
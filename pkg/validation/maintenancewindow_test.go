@@ -0,0 +1,214 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validation
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+func TestParseMaintenanceWindow(t *testing.T) {
+	tests := []struct {
+		name         string
+		window       string
+		wantDays     []time.Weekday
+		wantStart    time.Duration
+		wantDuration time.Duration
+		wantLocation string
+		wantErr      bool
+	}{
+		{
+			name:         "single day",
+			window:       "Sat 02:00-04:00",
+			wantDays:     []time.Weekday{time.Saturday},
+			wantStart:    2 * time.Hour,
+			wantDuration: 2 * time.Hour,
+			wantLocation: "UTC",
+		},
+		{
+			name:         "several days",
+			window:       "Sat,Sun 02:30-03:15",
+			wantDays:     []time.Weekday{time.Saturday, time.Sunday},
+			wantStart:    2*time.Hour + 30*time.Minute,
+			wantDuration: 45 * time.Minute,
+			wantLocation: "UTC",
+		},
+		{
+			name:         "time zone",
+			window:       "Mon 22:00-23:00 Europe/Berlin",
+			wantDays:     []time.Weekday{time.Monday},
+			wantStart:    22 * time.Hour,
+			wantDuration: time.Hour,
+			wantLocation: "Europe/Berlin",
+		},
+		{
+			name:         "past midnight",
+			window:       "Fri 23:00-01:00",
+			wantDays:     []time.Weekday{time.Friday},
+			wantStart:    23 * time.Hour,
+			wantDuration: 2 * time.Hour,
+			wantLocation: "UTC",
+		},
+		{name: "empty", window: "", wantErr: true},
+		{name: "missing times", window: "Sat", wantErr: true},
+		{name: "too many fields", window: "Sat 02:00-04:00 UTC extra", wantErr: true},
+		{name: "unknown day", window: "Saturday 02:00-04:00", wantErr: true},
+		{name: "lowercase day", window: "sat 02:00-04:00", wantErr: true},
+		{name: "empty day", window: "Sat, 02:00-04:00", wantErr: true},
+		{name: "missing end", window: "Sat 02:00", wantErr: true},
+		{name: "invalid start", window: "Sat 2am-04:00", wantErr: true},
+		{name: "invalid end", window: "Sat 02:00-24:00", wantErr: true},
+		{name: "same start and end", window: "Sat 02:00-02:00", wantErr: true},
+		{name: "unknown time zone", window: "Sat 02:00-04:00 Mars/Olympus", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			window, err := ParseMaintenanceWindow(tt.window)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", window)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(window.Days, tt.wantDays) {
+				t.Errorf("expected days %v, got %v", tt.wantDays, window.Days)
+			}
+			if window.Start != tt.wantStart {
+				t.Errorf("expected start %v, got %v", tt.wantStart, window.Start)
+			}
+			if window.Duration != tt.wantDuration {
+				t.Errorf("expected duration %v, got %v", tt.wantDuration, window.Duration)
+			}
+			if window.Location.String() != tt.wantLocation {
+				t.Errorf("expected location %s, got %s", tt.wantLocation, window.Location)
+			}
+		})
+	}
+}
+
+func TestMaintenanceWindowContains(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		window string
+		now    time.Time
+		want   bool
+	}{
+		// 2024-06-01 is a Saturday
+		{name: "at the start", window: "Sat 02:00-04:00", now: time.Date(2024, 6, 1, 2, 0, 0, 0, time.UTC), want: true},
+		{name: "inside", window: "Sat 02:00-04:00", now: time.Date(2024, 6, 1, 3, 59, 0, 0, time.UTC), want: true},
+		{name: "before the start", window: "Sat 02:00-04:00", now: time.Date(2024, 6, 1, 1, 59, 0, 0, time.UTC)},
+		{name: "at the end", window: "Sat 02:00-04:00", now: time.Date(2024, 6, 1, 4, 0, 0, 0, time.UTC)},
+		{name: "other day", window: "Sat 02:00-04:00", now: time.Date(2024, 6, 2, 3, 0, 0, 0, time.UTC)},
+		{name: "second day", window: "Sat,Sun 02:00-04:00", now: time.Date(2024, 6, 2, 3, 0, 0, 0, time.UTC), want: true},
+		{name: "before midnight", window: "Fri 23:00-01:00", now: time.Date(2024, 5, 31, 23, 30, 0, 0, time.UTC), want: true},
+		{name: "past midnight", window: "Fri 23:00-01:00", now: time.Date(2024, 6, 1, 0, 30, 0, 0, time.UTC), want: true},
+		{name: "past midnight after the end", window: "Fri 23:00-01:00", now: time.Date(2024, 6, 1, 1, 0, 0, 0, time.UTC)},
+		{name: "past midnight of the wrong day", window: "Sat 23:00-01:00", now: time.Date(2024, 6, 1, 0, 30, 0, 0, time.UTC)},
+		{name: "other time zone of now", window: "Sat 02:00-04:00", now: time.Date(2024, 6, 1, 5, 0, 0, 0, berlin), want: true},
+		// Berlin is 2 hours ahead of UTC in summer
+		{name: "time zone inside", window: "Sat 02:00-04:00 Europe/Berlin", now: time.Date(2024, 6, 1, 1, 0, 0, 0, time.UTC), want: true},
+		{name: "time zone outside", window: "Sat 02:00-04:00 Europe/Berlin", now: time.Date(2024, 6, 1, 3, 0, 0, 0, time.UTC)},
+		{name: "time zone on the previous day in UTC", window: "Sat 01:00-02:00 Europe/Berlin", now: time.Date(2024, 5, 31, 23, 30, 0, 0, time.UTC), want: true},
+		// and 1 hour ahead in winter, the window keeps its local time of day
+		{name: "time zone in winter", window: "Sat 02:00-04:00 Europe/Berlin", now: time.Date(2024, 1, 6, 2, 30, 0, 0, time.UTC), want: true},
+		{name: "time zone in winter outside", window: "Sat 02:00-04:00 Europe/Berlin", now: time.Date(2024, 1, 6, 0, 30, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			window, err := ParseMaintenanceWindow(tt.window)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := window.Contains(tt.now); got != tt.want {
+				t.Errorf("expected %s to contain %s to be %t, got %t", tt.window, tt.now, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMaintenanceWindowNext(t *testing.T) {
+	tests := []struct {
+		name   string
+		window string
+		now    time.Time
+		want   time.Time
+	}{
+		// 2024-06-01 is a Saturday
+		{name: "later that day", window: "Sat 02:00-04:00", now: time.Date(2024, 6, 1, 1, 0, 0, 0, time.UTC), want: time.Date(2024, 6, 1, 2, 0, 0, 0, time.UTC)},
+		{name: "later that week", window: "Sat 02:00-04:00", now: time.Date(2024, 5, 29, 12, 0, 0, 0, time.UTC), want: time.Date(2024, 6, 1, 2, 0, 0, 0, time.UTC)},
+		{name: "next week", window: "Sat 02:00-04:00", now: time.Date(2024, 6, 1, 4, 0, 0, 0, time.UTC), want: time.Date(2024, 6, 8, 2, 0, 0, 0, time.UTC)},
+		{name: "at the start", window: "Sat 02:00-04:00", now: time.Date(2024, 6, 1, 2, 0, 0, 0, time.UTC), want: time.Date(2024, 6, 8, 2, 0, 0, 0, time.UTC)},
+		{name: "nearest day", window: "Sat,Mon 02:00-04:00", now: time.Date(2024, 6, 1, 4, 0, 0, 0, time.UTC), want: time.Date(2024, 6, 3, 2, 0, 0, 0, time.UTC)},
+		{name: "time zone", window: "Sat 02:00-04:00 Europe/Berlin", now: time.Date(2024, 5, 31, 12, 0, 0, 0, time.UTC), want: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+		// Daylight saving time starts on 2024-03-31 in Berlin
+		{name: "across daylight saving time", window: "Sun 04:00-05:00 Europe/Berlin", now: time.Date(2024, 3, 30, 12, 0, 0, 0, time.UTC), want: time.Date(2024, 3, 31, 2, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			window, err := ParseMaintenanceWindow(tt.window)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := window.Next(tt.now); !got.Equal(tt.want) {
+				t.Errorf("expected the window to start next at %s, got %s", tt.want, got.UTC())
+			}
+		})
+	}
+}
+
+func TestValidateMaintenanceWindow(t *testing.T) {
+	tests := []struct {
+		name string
+		// Whether the annotation is set, as an empty one is invalid
+		set        bool
+		annotation string
+		fields     []string
+	}{
+		{name: "unset"},
+		{name: "valid", set: true, annotation: "Sat,Sun 02:00-04:00 Europe/Berlin"},
+		{name: "invalid", set: true, annotation: "weekends", fields: []string{"metadata.annotations[kubelitedb.fortytwoapps.tech/maintenance-window]"}},
+		{name: "empty", set: true, fields: []string{"metadata.annotations[kubelitedb.fortytwoapps.tech/maintenance-window]"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := &kubelitedbv1.SQLiteInstance{Spec: *validSpec()}
+			if tt.set {
+				instance.ObjectMeta = v1.ObjectMeta{Annotations: map[string]string{kubelitedbv1.MaintenanceWindowAnnotation: tt.annotation}}
+			}
+
+			var got []string
+			for _, err := range ValidateSQLiteInstance(instance) {
+				got = append(got, err.Field)
+			}
+			if !slices.Equal(got, tt.fields) {
+				t.Errorf("expected errors on %v, got %v", tt.fields, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,870 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package validation contains the validation rules for KubeLiteDB resources,
+// shared by the controller, the admission webhooks and the CLI.
+package validation
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	utilvalidation "k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// dbNamePattern matches database names that are safe to use as a file name
+// in the data directory: alphanumerics, dashes and underscores, optionally
+// ending with the .db extension. Path separators and dots are rejected, so a
+// name can't escape the data directory.
+var dbNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+(\.db)?$`)
+
+// reservedDbNameSuffixes are the suffixes SQLite names the files next to a
+// database with. A database named like one of them would share its file
+// with the journal of another database in the same directory.
+var reservedDbNameSuffixes = []string{"-wal", "-shm", "-journal"}
+
+// pragmaValues maps the pragmas that may be set on a SQLiteInstance to the
+// values they accept. Pragmas that could be used to tamper with the database
+// or the file system, such as writable_schema, are deliberately left out.
+var pragmaValues = map[string]*regexp.Regexp{
+	"auto_vacuum":        regexp.MustCompile(`^(?i:none|full|incremental|[0-2])$`),
+	"busy_timeout":       regexp.MustCompile(`^[0-9]+$`),
+	"cache_size":         regexp.MustCompile(`^-?[0-9]+$`),
+	"foreign_keys":       regexp.MustCompile(`^(?i:on|off|true|false|yes|no|[01])$`),
+	"journal_mode":       regexp.MustCompile(`^(?i:delete|truncate|persist|memory|wal|off)$`),
+	"journal_size_limit": regexp.MustCompile(`^-?[0-9]+$`),
+	"mmap_size":          regexp.MustCompile(`^[0-9]+$`),
+	"page_size":          regexp.MustCompile(`^[0-9]+$`),
+	"synchronous":        regexp.MustCompile(`^(?i:off|normal|full|extra|[0-3])$`),
+	"temp_store":         regexp.MustCompile(`^(?i:default|file|memory|[0-2])$`),
+	"wal_autocheckpoint": regexp.MustCompile(`^[0-9]+$`),
+}
+
+// imageReference matches container image references of the form
+// [domain[:port]/]path[:tag][@digest].
+var imageReference = regexp.MustCompile(`^` +
+	// domain
+	`(?:(?:[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?)(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?)*(?::[0-9]+)?/)?` +
+	// path
+	`[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*` +
+	// tag
+	`(?::[\w][\w.-]{0,127})?` +
+	// digest
+	`(?:@[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,})?` +
+	`$`)
+
+const (
+	// maxWALAutoCheckpoint bounds the WAL autocheckpoint threshold, in pages
+	maxWALAutoCheckpoint = 1000000
+	// minWALCheckpointInterval is the shortest interval the WAL can be
+	// checkpointed on
+	minWALCheckpointInterval = 10 * time.Second
+)
+
+// ValidateSQLiteInstance validates a SQLiteInstance and returns the list of
+// rules it violates.
+func ValidateSQLiteInstance(instance *kubelitedbv1.SQLiteInstance) field.ErrorList {
+	allErrs := ValidateSQLiteInstanceSpec(&instance.Spec, field.NewPath("spec"))
+
+	if cloneFrom := instance.Spec.CloneFrom; cloneFrom != nil && cloneFrom.Name == instance.Name {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "cloneFrom", "name"), cloneFrom.Name, "must not reference the SQLiteInstance itself"))
+	}
+	if SnapshotsOnDelete(instance) && instance.Spec.BackupDestination == nil {
+		allErrs = append(allErrs, field.Required(field.NewPath("spec", "backupDestination"),
+			fmt.Sprintf("must specify where the snapshot is uploaded to when the %s annotation is set", kubelitedbv1.SnapshotOnDeleteAnnotation)))
+	}
+	if window, ok := instance.Annotations[kubelitedbv1.MaintenanceWindowAnnotation]; ok {
+		if _, err := ParseMaintenanceWindow(window); err != nil {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("metadata", "annotations").Key(kubelitedbv1.MaintenanceWindowAnnotation), window, err.Error()))
+		}
+	}
+
+	return allErrs
+}
+
+// ValidateDatabaseCollision validates that the database of a new
+// SQLiteInstance is not kept in the same directory of a shared PVC as the
+// database of one of the existing SQLiteInstances in its namespace. Only
+// SQLiteInstances sharing a PVC keep their databases on the same volume.
+func ValidateDatabaseCollision(instance *kubelitedbv1.SQLiteInstance, existing []*kubelitedbv1.SQLiteInstance) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	claimName := instance.Spec.VolumeClaimName
+	if claimName == "" {
+		return allErrs
+	}
+	for _, other := range existing {
+		if other.Name == instance.Name || other.Spec.VolumeClaimName != claimName || other.DeletionTimestamp != nil {
+			continue
+		}
+		// A database at the root of the volume collides with every other
+		// database on it.
+		dir, otherDir := databaseDir(instance), databaseDir(other)
+		if dir != otherDir && dir != "" && otherDir != "" {
+			continue
+		}
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec", "dbName"),
+			fmt.Sprintf("SQLiteInstance %s already keeps its database in the same directory of PVC %s", other.Name, claimName)))
+	}
+
+	return allErrs
+}
+
+// databaseDir returns the directory of the volume a SQLiteInstance keeps its
+// database in, or an empty string for the root of the volume.
+func databaseDir(instance *kubelitedbv1.SQLiteInstance) string {
+	if !instance.Spec.SubPath {
+		return ""
+	}
+	return strings.TrimSuffix(instance.Spec.DbName, ".db")
+}
+
+// ValidateSQLiteInstanceUpdate validates an update of a SQLiteInstance and
+// returns the list of rules it violates.
+func ValidateSQLiteInstanceUpdate(instance, oldInstance *kubelitedbv1.SQLiteInstance) field.ErrorList {
+	allErrs := ValidateSQLiteInstance(instance)
+
+	if oldInstance.Spec.Replicas > 0 && instance.Spec.Replicas == 0 && !AllowsDataLoss(instance) {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec", "replicas"),
+			fmt.Sprintf("scaling to 0 removes the writer pod, set the %s annotation to \"true\" to allow it", kubelitedbv1.AllowDataLossAnnotation)))
+	}
+
+	// Unparseable quantities are reported by ValidateSQLiteInstance
+	newStorage, newErr := resource.ParseQuantity(instance.Spec.Storage)
+	oldStorage, oldErr := resource.ParseQuantity(oldInstance.Spec.Storage)
+	if newErr == nil && oldErr == nil && newStorage.Cmp(oldStorage) < 0 {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec", "storage"),
+			fmt.Sprintf("cannot be shrunk from %s to %s, volumes can only grow", oldInstance.Spec.Storage, instance.Spec.Storage)))
+	}
+
+	for _, immutable := range immutableFields {
+		if !equality.Semantic.DeepEqual(immutable.value(instance), immutable.value(oldInstance)) {
+			allErrs = append(allErrs, field.Forbidden(immutable.path, fmt.Sprintf("is immutable, %s", immutable.reason)))
+		}
+	}
+
+	return allErrs
+}
+
+// immutableFields are the fields of a SQLiteInstance that can't be changed
+// once it is created. Other fields, like the replicas or the resources, can be
+// changed freely.
+var immutableFields = []struct {
+	path   *field.Path
+	reason string
+	value  func(*kubelitedbv1.SQLiteInstance) interface{}
+}{
+	{
+		path:   field.NewPath("spec", "dbName"),
+		reason: "the database file was created under this name",
+		value:  func(instance *kubelitedbv1.SQLiteInstance) interface{} { return instance.Spec.DbName },
+	},
+	{
+		path:   field.NewPath("spec", "storageClassName"),
+		reason: "the volumes were provisioned with this storage class",
+		value:  func(instance *kubelitedbv1.SQLiteInstance) interface{} { return instance.Spec.StorageClassName },
+	},
+	{
+		path:   field.NewPath("spec", "accessMode"),
+		reason: "the volumes were provisioned with this access mode",
+		value:  func(instance *kubelitedbv1.SQLiteInstance) interface{} { return accessMode(instance) },
+	},
+	{
+		path:   field.NewPath("spec", "volumeClaimName"),
+		reason: "the database was created on this volume",
+		value:  func(instance *kubelitedbv1.SQLiteInstance) interface{} { return instance.Spec.VolumeClaimName },
+	},
+	{
+		path:   field.NewPath("spec", "subPath"),
+		reason: "the database was created in this directory",
+		value:  func(instance *kubelitedbv1.SQLiteInstance) interface{} { return instance.Spec.SubPath },
+	},
+	{
+		path:   field.NewPath("spec", "deploymentMode"),
+		reason: "the database was created on the volumes of this mode",
+		value:  func(instance *kubelitedbv1.SQLiteInstance) interface{} { return deploymentMode(instance) },
+	},
+}
+
+// deploymentMode returns the deployment mode of a SQLiteInstance, defaulting
+// to StatefulSet.
+func deploymentMode(instance *kubelitedbv1.SQLiteInstance) kubelitedbv1.DeploymentMode {
+	if instance.Spec.DeploymentMode == "" {
+		return kubelitedbv1.DeploymentModeStatefulSet
+	}
+	return instance.Spec.DeploymentMode
+}
+
+// accessMode returns the access mode of the database volume of a
+// SQLiteInstance, defaulting to ReadWriteOnce.
+func accessMode(instance *kubelitedbv1.SQLiteInstance) corev1.PersistentVolumeAccessMode {
+	if instance.Spec.AccessMode == "" {
+		return corev1.ReadWriteOnce
+	}
+	return instance.Spec.AccessMode
+}
+
+// AllowsDataLoss returns whether the SQLiteInstance allows changes that remove
+// its writer pod.
+func AllowsDataLoss(instance *kubelitedbv1.SQLiteInstance) bool {
+	return instance.Annotations[kubelitedbv1.AllowDataLossAnnotation] == "true"
+}
+
+// SnapshotsOnDelete returns whether the database of the SQLiteInstance is
+// backed up before it is deleted.
+func SnapshotsOnDelete(instance *kubelitedbv1.SQLiteInstance) bool {
+	return instance.Annotations[kubelitedbv1.SnapshotOnDeleteAnnotation] == "true"
+}
+
+// ValidateSQLiteInstanceSpec validates the spec of a SQLiteInstance.
+func ValidateSQLiteInstanceSpec(spec *kubelitedbv1.SQLiteInstanceSpec, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	allErrs = append(allErrs, ValidateDbName(spec.DbName, fldPath.Child("dbName"))...)
+
+	allErrs = append(allErrs, ValidateStorage(spec.Storage, fldPath.Child("storage"))...)
+
+	if spec.Image != "" {
+		allErrs = append(allErrs, ValidateImage(spec.Image, fldPath.Child("image"))...)
+	}
+
+	allErrs = append(allErrs, ValidateArchitecture(spec, fldPath)...)
+
+	if spec.Replicas < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("replicas"), spec.Replicas, "must be greater than or equal to 0"))
+	}
+
+	switch spec.AccessMode {
+	case "", corev1.ReadWriteOnce:
+	case corev1.ReadWriteMany:
+		// SQLite only supports a single writer process, which a shared
+		// volume can't enforce across pods.
+		if spec.Replicas > 1 {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("replicas"), "must not be greater than 1 with the ReadWriteMany access mode, as the writer pods would share the database"))
+		}
+	default:
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("accessMode"), spec.AccessMode, []string{string(corev1.ReadWriteOnce), string(corev1.ReadWriteMany)}))
+	}
+
+	if spec.LegacyDatabasePath != "" {
+		allErrs = append(allErrs, ValidateLegacyDatabasePath(spec.LegacyDatabasePath, fldPath.Child("legacyDatabasePath"))...)
+	}
+
+	// An existing volume may hold the databases of other instances, so each
+	// of them is kept in its own directory.
+	if spec.VolumeClaimName != "" {
+		if spec.AccessMode != corev1.ReadWriteMany {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("volumeClaimName"), "requires the ReadWriteMany access mode"))
+		}
+		if !spec.SubPath {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("volumeClaimName"), "requires subPath, so the databases sharing the volume are kept apart"))
+		}
+	}
+
+	for i, env := range spec.Env {
+		if IsReservedEnvVar(env.Name) {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("env").Index(i).Child("name"), fmt.Sprintf("%s is set by the controller", env.Name)))
+		}
+	}
+
+	allErrs = append(allErrs, ValidateSidecars(spec.Sidecars, spec.Volumes, fldPath)...)
+
+	if spec.ServiceAccountName != "" {
+		for _, msg := range utilvalidation.IsDNS1123Subdomain(spec.ServiceAccountName) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("serviceAccountName"), spec.ServiceAccountName, msg))
+		}
+	}
+
+	if spec.TerminationGracePeriodSeconds != nil && *spec.TerminationGracePeriodSeconds < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("terminationGracePeriodSeconds"), *spec.TerminationGracePeriodSeconds, "must be greater than or equal to 0"))
+	}
+
+	if spec.ReadReplicas < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("readReplicas"), spec.ReadReplicas, "must be greater than or equal to 0"))
+	}
+
+	allErrs = append(allErrs, ValidatePragmas(spec.Pragmas, fldPath.Child("pragmas"))...)
+
+	if spec.WAL != nil {
+		allErrs = append(allErrs, ValidateWAL(spec.WAL, spec.Pragmas, fldPath.Child("wal"))...)
+	}
+
+	if spec.TempDir != nil {
+		allErrs = append(allErrs, ValidateTempDir(spec.TempDir, fldPath.Child("tempDir"))...)
+	}
+
+	if spec.Replication != nil {
+		allErrs = append(allErrs, ValidateReplication(spec.Replication, fldPath.Child("replication"))...)
+		// Every pod replicates to the same path, so the databases of several
+		// writer pods would overwrite each other's generations.
+		if spec.Replicas > 1 {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("replication"), "must not be set with more than 1 replica, as every pod replicates to the same path"))
+		}
+	}
+
+	if spec.InitSQL != nil {
+		allErrs = append(allErrs, ValidateInitSQL(spec.InitSQL, fldPath.Child("initSQL"))...)
+	}
+
+	if spec.RestoreFrom != nil {
+		allErrs = append(allErrs, ValidateRestoreSource(spec.RestoreFrom, fldPath.Child("restoreFrom"))...)
+	}
+
+	if spec.CloneFrom != nil {
+		if spec.CloneFrom.Name == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("cloneFrom", "name"), "must specify the SQLiteInstance to clone"))
+		}
+		if spec.RestoreFrom != nil {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("cloneFrom"), "must not be set together with restoreFrom"))
+		}
+	}
+
+	if spec.BackupSchedule != "" || spec.BackupDestination != nil {
+		allErrs = append(allErrs, ValidateBackupSchedule(spec.BackupSchedule, spec.BackupDestination, fldPath)...)
+	}
+
+	if spec.IntegrityCheck != nil {
+		allErrs = append(allErrs, ValidateIntegrityCheck(spec.IntegrityCheck, fldPath.Child("integrityCheck"))...)
+	}
+
+	if spec.TLS != nil {
+		allErrs = append(allErrs, ValidateTLS(spec.TLS, fldPath.Child("tls"))...)
+	}
+
+	allErrs = append(allErrs, ValidateServiceType(spec, fldPath)...)
+
+	allErrs = append(allErrs, ValidateDeploymentMode(spec, fldPath)...)
+
+	if spec.UpdateStrategy != nil {
+		allErrs = append(allErrs, ValidateUpdateStrategy(spec.UpdateStrategy, fldPath.Child("updateStrategy"))...)
+	}
+
+	if spec.BackupEncryption != nil {
+		allErrs = append(allErrs, ValidateBackupEncryption(spec.BackupEncryption, fldPath.Child("backupEncryption"))...)
+	}
+
+	if spec.BackupRetention != nil {
+		allErrs = append(allErrs, ValidateBackupRetention(spec.BackupRetention, fldPath.Child("backupRetention"))...)
+	}
+	if spec.BackupStaleAfter != nil && spec.BackupStaleAfter.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("backupStaleAfter"), spec.BackupStaleAfter.Duration.String(), "must be positive"))
+	}
+
+	return allErrs
+}
+
+// reservedEnvVars are the environment variables of the SQLite container set by
+// the controller
+var reservedEnvVars = map[string]bool{
+	"DATABASE_PATH":      true,
+	"DATABASE_READ_ONLY": true,
+	"SQLITE_TMPDIR":      true,
+}
+
+// IsReservedEnvVar returns whether the environment variable of the SQLite
+// container is set by the controller, and can't be set in the spec.
+func IsReservedEnvVar(name string) bool {
+	return reservedEnvVars[name]
+}
+
+// reservedContainerNames are the names of the containers and init containers
+// added to the pods by the controller
+var reservedContainerNames = map[string]bool{
+	"sqlite":     true,
+	"migrate":    true,
+	"litestream": true,
+	"tls-proxy":  true,
+	"checkpoint": true,
+	"restore":    true,
+	"decrypt":    true,
+	"init-sql":   true,
+	"pragmas":    true,
+	// Only added in the DaemonSet deployment mode
+	"host-path-permissions": true,
+}
+
+// reservedVolumeNames are the names of the volumes added to the pods by the
+// controller
+var reservedVolumeNames = map[string]bool{
+	"data":              true,
+	"litestream-config": true,
+	"tls":               true,
+	"init-sql":          true,
+	"pragmas":           true,
+	"sqlite-tmp":        true,
+}
+
+// ValidateSidecars validates the sidecars and volumes added to the pods of a
+// SQLiteInstance. Their names must not collide with each other or with the
+// containers and volumes of the controller.
+func ValidateSidecars(sidecars []corev1.Container, volumes []corev1.Volume, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	containerNames := map[string]bool{}
+	for i, sidecar := range sidecars {
+		namePath := fldPath.Child("sidecars").Index(i).Child("name")
+		switch {
+		case sidecar.Name == "":
+			allErrs = append(allErrs, field.Required(namePath, ""))
+		case reservedContainerNames[sidecar.Name]:
+			allErrs = append(allErrs, field.Forbidden(namePath, fmt.Sprintf("%s is the name of a container of the controller", sidecar.Name)))
+		case containerNames[sidecar.Name]:
+			allErrs = append(allErrs, field.Duplicate(namePath, sidecar.Name))
+		}
+		if sidecar.Image == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("sidecars").Index(i).Child("image"), ""))
+		}
+		containerNames[sidecar.Name] = true
+	}
+
+	volumeNames := map[string]bool{}
+	for i, volume := range volumes {
+		namePath := fldPath.Child("volumes").Index(i).Child("name")
+		switch {
+		case volume.Name == "":
+			allErrs = append(allErrs, field.Required(namePath, ""))
+		case reservedVolumeNames[volume.Name]:
+			allErrs = append(allErrs, field.Forbidden(namePath, fmt.Sprintf("%s is the name of a volume of the controller", volume.Name)))
+		case volumeNames[volume.Name]:
+			allErrs = append(allErrs, field.Duplicate(namePath, volume.Name))
+		}
+		volumeNames[volume.Name] = true
+	}
+
+	return allErrs
+}
+
+// ValidateBackupSchedule validates the scheduled backups of a SQLiteInstance.
+func ValidateBackupSchedule(schedule string, destination *kubelitedbv1.BackupDestination, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if schedule == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("backupSchedule"), "must specify a schedule when a backup destination is set"))
+	} else if _, err := cron.ParseStandard(schedule); err != nil {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("backupSchedule"), schedule, err.Error()))
+	}
+
+	destinationPath := fldPath.Child("backupDestination")
+	if destination == nil {
+		return append(allErrs, field.Required(destinationPath, "must specify where scheduled backups are uploaded to"))
+	}
+	if destination.Bucket == "" {
+		allErrs = append(allErrs, field.Required(destinationPath.Child("bucket"), "must specify the bucket to upload backups to"))
+	}
+	if destination.SecretRef.Name == "" {
+		allErrs = append(allErrs, field.Required(destinationPath.Child("secretRef", "name"), "must reference the Secret holding the bucket credentials"))
+	}
+
+	return allErrs
+}
+
+// ValidateIntegrityCheck validates the scheduled integrity checks of a
+// SQLiteInstance.
+func ValidateIntegrityCheck(check *kubelitedbv1.IntegrityCheckSpec, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if check.Schedule == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("schedule"), "must specify when the database is checked"))
+	} else if _, err := cron.ParseStandard(check.Schedule); err != nil {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("schedule"), check.Schedule, err.Error()))
+	}
+
+	return allErrs
+}
+
+// ValidateBackupRetention validates the retention of the scheduled backups of
+// a SQLiteInstance.
+func ValidateBackupRetention(retention *kubelitedbv1.BackupRetention, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if retention.Count == nil && retention.MaxAge == nil {
+		allErrs = append(allErrs, field.Required(fldPath, "must specify count or maxAge"))
+	}
+	if retention.Count != nil && *retention.Count < 1 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("count"), *retention.Count, "must be greater than or equal to 1"))
+	}
+	if retention.MaxAge != nil && retention.MaxAge.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("maxAge"), retention.MaxAge.Duration.String(), "must be greater than 0"))
+	}
+
+	return allErrs
+}
+
+// ValidateWAL validates the WAL tuning of a SQLiteInstance.
+func ValidateWAL(wal *kubelitedbv1.WALSpec, pragmas map[string]string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if wal.AutoCheckpoint != nil {
+		if *wal.AutoCheckpoint < 0 || *wal.AutoCheckpoint > maxWALAutoCheckpoint {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("autoCheckpoint"), *wal.AutoCheckpoint, fmt.Sprintf("must be between 0 and %d", maxWALAutoCheckpoint)))
+		}
+		if _, ok := pragmas["wal_autocheckpoint"]; ok {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("autoCheckpoint"), "must not be set together with the wal_autocheckpoint pragma"))
+		}
+	}
+	if wal.CheckpointInterval != nil && wal.CheckpointInterval.Duration < minWALCheckpointInterval {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("checkpointInterval"), wal.CheckpointInterval.Duration.String(), fmt.Sprintf("must be at least %s", minWALCheckpointInterval)))
+	}
+
+	return allErrs
+}
+
+// ValidateUpdateStrategy validates the update strategy of a SQLiteInstance.
+func ValidateUpdateStrategy(strategy *kubelitedbv1.UpdateStrategy, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	switch strategy.Type {
+	case "", appsv1.RollingUpdateStatefulSetStrategyType:
+	case appsv1.OnDeleteStatefulSetStrategyType:
+		if strategy.Partition != nil {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("partition"), "only applies to the RollingUpdate type"))
+		}
+	default:
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("type"), strategy.Type, []appsv1.StatefulSetUpdateStrategyType{appsv1.RollingUpdateStatefulSetStrategyType, appsv1.OnDeleteStatefulSetStrategyType}))
+	}
+	if strategy.Partition != nil && *strategy.Partition < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("partition"), *strategy.Partition, "must not be negative"))
+	}
+
+	return allErrs
+}
+
+// serviceTypes are the types the client Service of a SQLiteInstance can have
+var serviceTypes = []corev1.ServiceType{corev1.ServiceTypeClusterIP, corev1.ServiceTypeNodePort, corev1.ServiceTypeLoadBalancer}
+
+// ValidateServiceType validates the type and annotations of the client Service
+// of a SQLiteInstance. The Service only exposes the TLS port, so exposing it
+// outside of the cluster requires TLS.
+func ValidateServiceType(spec *kubelitedbv1.SQLiteInstanceSpec, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	typePath := fldPath.Child("serviceType")
+	if spec.ServiceType != "" && !slices.Contains(serviceTypes, spec.ServiceType) {
+		allErrs = append(allErrs, field.NotSupported(typePath, spec.ServiceType, serviceTypes))
+	}
+	if spec.ServiceType != "" && spec.ServiceType != corev1.ServiceTypeClusterIP && spec.TLS == nil {
+		allErrs = append(allErrs, field.Forbidden(typePath, "requires spec.tls, the client Service only exposes the TLS port"))
+	}
+	if len(spec.ServiceAnnotations) > 0 && spec.TLS == nil {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("serviceAnnotations"), "requires spec.tls, the client Service is only created along with TLS"))
+	}
+
+	return allErrs
+}
+
+// ValidateDeploymentMode validates the deployment mode of a SQLiteInstance.
+// The DaemonSet mode keeps a database per node on a hostPath volume, so the
+// features needing PVCs or a single database are forbidden with it.
+func ValidateDeploymentMode(spec *kubelitedbv1.SQLiteInstanceSpec, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	switch spec.DeploymentMode {
+	case "", kubelitedbv1.DeploymentModeStatefulSet:
+		return allErrs
+	case kubelitedbv1.DeploymentModeDaemonSet:
+	default:
+		return append(allErrs, field.NotSupported(fldPath.Child("deploymentMode"), spec.DeploymentMode,
+			[]string{string(kubelitedbv1.DeploymentModeStatefulSet), string(kubelitedbv1.DeploymentModeDaemonSet)}))
+	}
+
+	forbidden := func(child string) {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child(child), "is not supported with the DaemonSet deployment mode"))
+	}
+	if spec.AccessMode == corev1.ReadWriteMany {
+		forbidden("accessMode")
+	}
+	if spec.VolumeClaimName != "" {
+		forbidden("volumeClaimName")
+	}
+	if spec.ReadReplicas > 0 {
+		forbidden("readReplicas")
+	}
+	if spec.Suspend {
+		forbidden("suspend")
+	}
+	if spec.Replication != nil {
+		forbidden("replication")
+	}
+	if spec.BackupSchedule != "" {
+		forbidden("backupSchedule")
+	}
+	if spec.BackupDestination != nil {
+		forbidden("backupDestination")
+	}
+	if spec.IntegrityCheck != nil {
+		forbidden("integrityCheck")
+	}
+	if spec.RestoreFrom != nil {
+		forbidden("restoreFrom")
+	}
+	if spec.CloneFrom != nil {
+		forbidden("cloneFrom")
+	}
+	if spec.LegacyDatabasePath != "" {
+		forbidden("legacyDatabasePath")
+	}
+	if len(spec.TopologySpreadConstraints) > 0 {
+		forbidden("topologySpreadConstraints")
+	}
+	if spec.SpreadAcrossZones {
+		forbidden("spreadAcrossZones")
+	}
+	return allErrs
+}
+
+// architectures are the values of the kubernetes.io/arch label of the
+// nodes the default image is published for
+var architectures = []string{"amd64", "arm64", "arm", "ppc64le", "s390x", "riscv64"}
+
+// ValidateArchitecture validates the architecture the pods of a
+// SQLiteInstance are limited to, and the images of the architectures.
+func ValidateArchitecture(spec *kubelitedbv1.SQLiteInstanceSpec, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if spec.Architecture != "" && !slices.Contains(architectures, spec.Architecture) {
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("architecture"), spec.Architecture, architectures))
+	}
+	if spec.Architecture != "" && spec.NodeSelector[corev1.LabelArchStable] != "" && spec.NodeSelector[corev1.LabelArchStable] != spec.Architecture {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("nodeSelector").Key(corev1.LabelArchStable), spec.NodeSelector[corev1.LabelArchStable], "must match architecture"))
+	}
+
+	imagesPath := fldPath.Child("architectureImages")
+	if len(spec.ArchitectureImages) > 0 && spec.Architecture == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("architecture"), "must specify the architecture to use one of architectureImages"))
+	}
+	// Sorted, so the errors are reported in a stable order
+	keys := make([]string, 0, len(spec.ArchitectureImages))
+	for architecture := range spec.ArchitectureImages {
+		keys = append(keys, architecture)
+	}
+	sort.Strings(keys)
+	for _, architecture := range keys {
+		if !slices.Contains(architectures, architecture) {
+			allErrs = append(allErrs, field.NotSupported(imagesPath.Key(architecture), architecture, architectures))
+			continue
+		}
+		allErrs = append(allErrs, ValidateImage(spec.ArchitectureImages[architecture], imagesPath.Key(architecture))...)
+	}
+
+	return allErrs
+}
+
+// ValidateTLS validates the TLS termination of a SQLiteInstance.
+func ValidateTLS(tls *kubelitedbv1.TLSSpec, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if tls.SecretRef.Name == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("secretRef", "name"), "must reference the Secret holding the certificate"))
+	}
+	if tls.Port < 1 || tls.Port > 65535 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("port"), tls.Port, "must be between 1 and 65535"))
+	} else if tls.Port == kubelitedbv1.TLSPort {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("port"), tls.Port, fmt.Sprintf("must not be %d, which serves the TLS connections", kubelitedbv1.TLSPort)))
+	}
+
+	return allErrs
+}
+
+// ValidateTempDir validates the medium and size limit of the temp directory
+// volume of a SQLiteInstance.
+func ValidateTempDir(tempDir *kubelitedbv1.TempDirSpec, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	switch tempDir.Medium {
+	case corev1.StorageMediumDefault, corev1.StorageMediumMemory:
+	default:
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("medium"), tempDir.Medium, []string{string(corev1.StorageMediumMemory)}))
+	}
+	if tempDir.SizeLimit != "" {
+		sizeLimit, err := resource.ParseQuantity(tempDir.SizeLimit)
+		switch {
+		case err != nil:
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("sizeLimit"), tempDir.SizeLimit, err.Error()))
+		case sizeLimit.Sign() <= 0:
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("sizeLimit"), tempDir.SizeLimit, "must be positive"))
+		}
+	}
+
+	return allErrs
+}
+
+// ValidateInitSQL validates that the init SQL script of a SQLiteInstance is
+// given either inline or as a ConfigMap key.
+func ValidateInitSQL(initSQL *kubelitedbv1.InitSQLSource, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	switch {
+	case initSQL.Inline != "" && initSQL.ConfigMapKeyRef != nil:
+		allErrs = append(allErrs, field.Forbidden(fldPath, "must specify either inline or configMapKeyRef, not both"))
+	case initSQL.Inline == "" && initSQL.ConfigMapKeyRef == nil:
+		allErrs = append(allErrs, field.Required(fldPath, "must specify either inline or configMapKeyRef"))
+	case initSQL.ConfigMapKeyRef != nil:
+		if initSQL.ConfigMapKeyRef.Name == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("configMapKeyRef", "name"), "must reference the ConfigMap holding the script"))
+		}
+		if initSQL.ConfigMapKeyRef.Key == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("configMapKeyRef", "key"), "must specify the key of the script"))
+		}
+	}
+
+	return allErrs
+}
+
+// ValidateRestoreSource validates the backup a SQLiteInstance is restored from.
+func ValidateRestoreSource(restoreFrom *kubelitedbv1.RestoreSource, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if restoreFrom.Bucket == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("bucket"), "must specify the bucket holding the backup"))
+	}
+	if restoreFrom.Key == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("key"), "must specify the key of the backup"))
+	}
+	if restoreFrom.SecretRef.Name == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("secretRef", "name"), "must reference the Secret holding the bucket credentials"))
+	}
+	if restoreFrom.Encryption != nil {
+		allErrs = append(allErrs, ValidateBackupEncryption(restoreFrom.Encryption, fldPath.Child("encryption"))...)
+	}
+
+	return allErrs
+}
+
+// ValidateBackupEncryption validates that the key backups are encrypted with
+// is referenced.
+func ValidateBackupEncryption(encryption *kubelitedbv1.BackupEncryption, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if encryption.SecretRef.Name == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("secretRef", "name"), "must reference the Secret holding the encryption key"))
+	}
+
+	return allErrs
+}
+
+// ValidateReplication validates the replication configuration of a SQLiteInstance.
+func ValidateReplication(replication *kubelitedbv1.ReplicationSpec, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if replication.Bucket == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("bucket"), "must specify the bucket to replicate to"))
+	}
+	if replication.SecretRef.Name == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("secretRef", "name"), "must reference the Secret holding the bucket credentials"))
+	}
+
+	return allErrs
+}
+
+// ValidateDbName validates that the database name is a safe SQLite file name.
+func ValidateDbName(dbName string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if dbName == "" {
+		return append(allErrs, field.Required(fldPath, "must specify the name of the database"))
+	}
+	if len(dbName) > 255 {
+		allErrs = append(allErrs, field.TooLong(fldPath, dbName, 255))
+	}
+	if !dbNamePattern.MatchString(dbName) {
+		allErrs = append(allErrs, field.Invalid(fldPath, dbName, "must consist of alphanumerics, '-' and '_', optionally ending with '.db'"))
+	}
+	for _, suffix := range reservedDbNameSuffixes {
+		if strings.HasSuffix(dbName, suffix) {
+			allErrs = append(allErrs, field.Invalid(fldPath, dbName, fmt.Sprintf("must not end with %q, which SQLite uses for the files next to a database", suffix)))
+		}
+	}
+
+	return allErrs
+}
+
+// ValidateLegacyDatabasePath validates that the legacy path of a database is
+// a file within the volume.
+func ValidateLegacyDatabasePath(legacyPath string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	switch {
+	case path.IsAbs(legacyPath):
+		allErrs = append(allErrs, field.Invalid(fldPath, legacyPath, "must be relative to the root of the volume"))
+	case path.Clean(legacyPath) != legacyPath || legacyPath == "." || strings.HasPrefix(legacyPath, "../") || legacyPath == "..":
+		allErrs = append(allErrs, field.Invalid(fldPath, legacyPath, "must be a clean path within the volume"))
+	}
+
+	return allErrs
+}
+
+// ValidatePragmas validates that only known pragmas are set, to values they
+// accept.
+func ValidatePragmas(pragmas map[string]string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	supported := make([]string, 0, len(pragmaValues))
+	for name := range pragmaValues {
+		supported = append(supported, name)
+	}
+	sort.Strings(supported)
+
+	for name, value := range pragmas {
+		values, ok := pragmaValues[name]
+		if !ok {
+			allErrs = append(allErrs, field.NotSupported(fldPath, name, supported))
+			continue
+		}
+		if !values.MatchString(value) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Key(name), value, "is not a valid value for this pragma"))
+		}
+	}
+
+	return allErrs
+}
+
+// ValidateStorage validates that the storage is a valid resource quantity.
+func ValidateStorage(storage string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if storage == "" {
+		return append(allErrs, field.Required(fldPath, "must specify the amount of storage"))
+	}
+	if _, err := resource.ParseQuantity(storage); err != nil {
+		allErrs = append(allErrs, field.Invalid(fldPath, storage, err.Error()))
+	}
+
+	return allErrs
+}
+
+// ValidateImage validates that the image is a well-formed image reference.
+func ValidateImage(image string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if !imageReference.MatchString(image) {
+		allErrs = append(allErrs, field.Invalid(fldPath, image, "must be a valid image reference"))
+	}
+
+	return allErrs
+}
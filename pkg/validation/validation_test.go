@@ -0,0 +1,1134 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validation
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+func validSpec() *kubelitedbv1.SQLiteInstanceSpec {
+	return &kubelitedbv1.SQLiteInstanceSpec{
+		DbName:   "app.db",
+		Storage:  "1Gi",
+		Replicas: 1,
+	}
+}
+
+// TestValidateSQLiteInstanceSpec changes a valid spec with mutate, and expects
+// it to be rejected on the given fields, or accepted when there are none.
+func TestValidateSQLiteInstanceSpec(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(spec *kubelitedbv1.SQLiteInstanceSpec)
+		fields []string
+	}{
+		{name: "valid", mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {}},
+		{name: "invalid image", mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.Image = "bad image" }, fields: []string{"spec.image"}},
+		{
+			name: "replication",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.Replication = &kubelitedbv1.ReplicationSpec{Bucket: "backups", SecretRef: corev1.LocalObjectReference{Name: "s3-credentials"}}
+			},
+		},
+		{
+			name:   "replication without bucket or secret",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.Replication = &kubelitedbv1.ReplicationSpec{} },
+			fields: []string{"spec.replication.bucket", "spec.replication.secretRef.name"},
+		},
+		{
+			name: "replication with several replicas",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.Replicas = 2
+				spec.Replication = &kubelitedbv1.ReplicationSpec{Bucket: "backups", SecretRef: corev1.LocalObjectReference{Name: "s3-credentials"}}
+			},
+			fields: []string{"spec.replication"},
+		},
+		{
+			name: "restore",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.RestoreFrom = &kubelitedbv1.RestoreSource{Bucket: "backups", Key: "app.db", SecretRef: corev1.LocalObjectReference{Name: "s3-credentials"}}
+			},
+		},
+		{
+			name:   "restore without bucket, key or secret",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.RestoreFrom = &kubelitedbv1.RestoreSource{} },
+			fields: []string{"spec.restoreFrom.bucket", "spec.restoreFrom.key", "spec.restoreFrom.secretRef.name"},
+		},
+		{
+			name: "encrypted restore",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.RestoreFrom = &kubelitedbv1.RestoreSource{
+					Bucket: "backups", Key: "app.db", SecretRef: corev1.LocalObjectReference{Name: "s3-credentials"},
+					Encryption: &kubelitedbv1.BackupEncryption{SecretRef: corev1.LocalObjectReference{Name: "backup-key"}},
+				}
+			},
+		},
+		{
+			name: "encrypted restore without key",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.RestoreFrom = &kubelitedbv1.RestoreSource{
+					Bucket: "backups", Key: "app.db", SecretRef: corev1.LocalObjectReference{Name: "s3-credentials"},
+					Encryption: &kubelitedbv1.BackupEncryption{},
+				}
+			},
+			fields: []string{"spec.restoreFrom.encryption.secretRef.name"},
+		},
+		{
+			name: "backup encryption",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.BackupEncryption = &kubelitedbv1.BackupEncryption{SecretRef: corev1.LocalObjectReference{Name: "backup-key"}}
+			},
+		},
+		{
+			name:   "backup encryption without key",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.BackupEncryption = &kubelitedbv1.BackupEncryption{} },
+			fields: []string{"spec.backupEncryption.secretRef.name"},
+		},
+		{
+			name: "backup schedule",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.BackupSchedule = "0 3 * * *"
+				spec.BackupDestination = &kubelitedbv1.BackupDestination{Bucket: "backups", SecretRef: corev1.LocalObjectReference{Name: "s3-credentials"}}
+			},
+		},
+		{
+			name: "invalid backup schedule",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.BackupSchedule = "every night"
+				spec.BackupDestination = &kubelitedbv1.BackupDestination{Bucket: "backups", SecretRef: corev1.LocalObjectReference{Name: "s3-credentials"}}
+			},
+			fields: []string{"spec.backupSchedule"},
+		},
+		{
+			name:   "backup schedule without destination",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.BackupSchedule = "0 3 * * *" },
+			fields: []string{"spec.backupDestination"},
+		},
+		{name: "single writer", mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.ReadReplicas = 3 }},
+		{name: "several writers", mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.Replicas = 2 }},
+		{name: "negative read replicas", mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.ReadReplicas = -1 }, fields: []string{"spec.readReplicas"}},
+		{
+			name: "negative grace period",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				seconds := int64(-1)
+				spec.TerminationGracePeriodSeconds = &seconds
+			},
+			fields: []string{"spec.terminationGracePeriodSeconds"},
+		},
+		{
+			name: "inline init SQL",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.InitSQL = &kubelitedbv1.InitSQLSource{Inline: "CREATE TABLE t (id INTEGER);"}
+			},
+		},
+		{
+			name: "init SQL from a ConfigMap",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.InitSQL = &kubelitedbv1.InitSQLSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "schema"},
+					Key:                  "schema.sql",
+				}}
+			},
+		},
+		{
+			name: "init SQL inline and from a ConfigMap",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.InitSQL = &kubelitedbv1.InitSQLSource{Inline: "CREATE TABLE t (id INTEGER);", ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "schema"},
+					Key:                  "schema.sql",
+				}}
+			},
+			fields: []string{"spec.initSQL"},
+		},
+		{name: "empty init SQL", mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.InitSQL = &kubelitedbv1.InitSQLSource{} }, fields: []string{"spec.initSQL"}},
+		{
+			name: "init SQL from an unnamed ConfigMap",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.InitSQL = &kubelitedbv1.InitSQLSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{}}
+			},
+			fields: []string{"spec.initSQL.configMapKeyRef.name", "spec.initSQL.configMapKeyRef.key"},
+		},
+		{
+			name: "backup retention",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				count := int32(7)
+				spec.BackupRetention = &kubelitedbv1.BackupRetention{Count: &count, MaxAge: &v1.Duration{Duration: 30 * 24 * time.Hour}}
+			},
+		},
+		{
+			name:   "empty backup retention",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.BackupRetention = &kubelitedbv1.BackupRetention{} },
+			fields: []string{"spec.backupRetention"},
+		},
+		{
+			name: "backup retention keeping nothing",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				count := int32(0)
+				spec.BackupRetention = &kubelitedbv1.BackupRetention{Count: &count, MaxAge: &v1.Duration{}}
+			},
+			fields: []string{"spec.backupRetention.count", "spec.backupRetention.maxAge"},
+		},
+		{name: "ReadWriteMany", mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.AccessMode = corev1.ReadWriteMany }},
+		{
+			name: "ReadWriteMany with several writers",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.AccessMode = corev1.ReadWriteMany
+				spec.Replicas = 2
+			},
+			fields: []string{"spec.replicas"},
+		},
+		{name: "unsupported access mode", mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.AccessMode = corev1.ReadOnlyMany }, fields: []string{"spec.accessMode"}},
+		{
+			name: "WAL",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				autoCheckpoint := int32(4000)
+				spec.WAL = &kubelitedbv1.WALSpec{AutoCheckpoint: &autoCheckpoint, CheckpointInterval: &v1.Duration{Duration: time.Minute}}
+			},
+		},
+		{
+			name: "WAL autocheckpoint out of range",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				autoCheckpoint := int32(-1)
+				spec.WAL = &kubelitedbv1.WALSpec{AutoCheckpoint: &autoCheckpoint}
+			},
+			fields: []string{"spec.wal.autoCheckpoint"},
+		},
+		{
+			name: "WAL autocheckpoint above the maximum",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				autoCheckpoint := int32(maxWALAutoCheckpoint + 1)
+				spec.WAL = &kubelitedbv1.WALSpec{AutoCheckpoint: &autoCheckpoint}
+			},
+			fields: []string{"spec.wal.autoCheckpoint"},
+		},
+		{
+			name: "WAL autocheckpoint along with the pragma",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				autoCheckpoint := int32(4000)
+				spec.WAL = &kubelitedbv1.WALSpec{AutoCheckpoint: &autoCheckpoint}
+				spec.Pragmas = map[string]string{"wal_autocheckpoint": "1000"}
+			},
+			fields: []string{"spec.wal.autoCheckpoint"},
+		},
+		{
+			name: "WAL checkpoint interval too short",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.WAL = &kubelitedbv1.WALSpec{CheckpointInterval: &v1.Duration{Duration: time.Second}}
+			},
+			fields: []string{"spec.wal.checkpointInterval"},
+		},
+		{
+			name: "env",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.Env = []corev1.EnvVar{{Name: "LOG_LEVEL", Value: "debug"}}
+			},
+		},
+		{
+			name: "reserved env",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.Env = []corev1.EnvVar{{Name: "LOG_LEVEL", Value: "debug"}, {Name: "DATABASE_PATH", Value: "/tmp/other.db"}}
+			},
+			fields: []string{"spec.env[1].name"},
+		},
+		{
+			name: "TLS",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.TLS = &kubelitedbv1.TLSSpec{SecretRef: corev1.LocalObjectReference{Name: "db-tls"}, Port: 5432}
+			},
+		},
+		{
+			name:   "TLS without Secret or port",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.TLS = &kubelitedbv1.TLSSpec{} },
+			fields: []string{"spec.tls.secretRef.name", "spec.tls.port"},
+		},
+		{
+			name: "sidecar",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.Sidecars = []corev1.Container{{Name: "log-shipper", Image: "fluent/fluent-bit:3.0", VolumeMounts: []corev1.VolumeMount{{Name: "config", MountPath: "/etc/fluent-bit"}}}}
+				spec.Volumes = []corev1.Volume{{Name: "config", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}}
+			},
+		},
+		{
+			name: "sidecar named like a container of the controller",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.Sidecars = []corev1.Container{{Name: "litestream", Image: "litestream/litestream"}}
+			},
+			fields: []string{"spec.sidecars[0].name"},
+		},
+		{
+			name: "service account",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.ServiceAccountName = "sqlite-backups"
+			},
+		},
+		{
+			name: "invalid service account",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.ServiceAccountName = "SQLite_Backups"
+			},
+			fields: []string{"spec.serviceAccountName"},
+		},
+		{
+			name: "legacy database path",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.LegacyDatabasePath = "data/app.db"
+			},
+		},
+		{
+			name: "legacy database path outside the volume",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.LegacyDatabasePath = "../app.db"
+			},
+			fields: []string{"spec.legacyDatabasePath"},
+		},
+		{
+			name: "duplicate sidecars",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.Sidecars = []corev1.Container{{Name: "exporter", Image: "exporter:1"}, {Name: "exporter", Image: "exporter:2"}}
+			},
+			fields: []string{"spec.sidecars[1].name"},
+		},
+		{
+			name:   "sidecar without name or image",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.Sidecars = []corev1.Container{{}} },
+			fields: []string{"spec.sidecars[0].name", "spec.sidecars[0].image"},
+		},
+		{
+			name:   "volume named like a volume of the controller",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.Volumes = []corev1.Volume{{Name: "data"}} },
+			fields: []string{"spec.volumes[0].name"},
+		},
+		{
+			name: "duplicate volumes",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.Volumes = []corev1.Volume{{Name: "config"}, {Name: "config"}}
+			},
+			fields: []string{"spec.volumes[1].name"},
+		},
+		{
+			name: "backup stale after",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.BackupStaleAfter = &v1.Duration{Duration: 24 * time.Hour}
+			},
+		},
+		{
+			name:   "backup stale after not positive",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.BackupStaleAfter = &v1.Duration{} },
+			fields: []string{"spec.backupStaleAfter"},
+		},
+		{
+			name: "staged rolling update",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				partition := int32(1)
+				spec.UpdateStrategy = &kubelitedbv1.UpdateStrategy{Type: appsv1.RollingUpdateStatefulSetStrategyType, Partition: &partition}
+			},
+		},
+		{
+			name: "on delete update",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.UpdateStrategy = &kubelitedbv1.UpdateStrategy{Type: appsv1.OnDeleteStatefulSetStrategyType}
+			},
+		},
+		{
+			name: "unsupported update strategy",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.UpdateStrategy = &kubelitedbv1.UpdateStrategy{Type: "Recreate"}
+			},
+			fields: []string{"spec.updateStrategy.type"},
+		},
+		{
+			name: "partition with on delete",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				partition := int32(1)
+				spec.UpdateStrategy = &kubelitedbv1.UpdateStrategy{Type: appsv1.OnDeleteStatefulSetStrategyType, Partition: &partition}
+			},
+			fields: []string{"spec.updateStrategy.partition"},
+		},
+		{
+			name: "negative partition",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				partition := int32(-1)
+				spec.UpdateStrategy = &kubelitedbv1.UpdateStrategy{Partition: &partition}
+			},
+			fields: []string{"spec.updateStrategy.partition"},
+		},
+		{
+			name: "LoadBalancer client Service",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.TLS = &kubelitedbv1.TLSSpec{SecretRef: corev1.LocalObjectReference{Name: "db-tls"}, Port: 5432}
+				spec.ServiceType = corev1.ServiceTypeLoadBalancer
+				spec.ServiceAnnotations = map[string]string{"service.beta.kubernetes.io/aws-load-balancer-internal": "true"}
+			},
+		},
+		{
+			name: "unsupported service type",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.TLS = &kubelitedbv1.TLSSpec{SecretRef: corev1.LocalObjectReference{Name: "db-tls"}, Port: 5432}
+				spec.ServiceType = corev1.ServiceTypeExternalName
+			},
+			fields: []string{"spec.serviceType"},
+		},
+		{
+			name:   "NodePort without TLS",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.ServiceType = corev1.ServiceTypeNodePort },
+			fields: []string{"spec.serviceType"},
+		},
+		{
+			name:   "service annotations without TLS",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.ServiceAnnotations = map[string]string{"a": "b"} },
+			fields: []string{"spec.serviceAnnotations"},
+		},
+		{
+			name: "temp dir in memory",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.TempDir = &kubelitedbv1.TempDirSpec{Medium: corev1.StorageMediumMemory, SizeLimit: "256Mi"}
+			},
+		},
+		{name: "temp dir on the node disk", mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.TempDir = &kubelitedbv1.TempDirSpec{} }},
+		{
+			name: "temp dir on huge pages",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.TempDir = &kubelitedbv1.TempDirSpec{Medium: corev1.StorageMediumHugePages}
+			},
+			fields: []string{"spec.tempDir.medium"},
+		},
+		{
+			name: "temp dir size limit invalid",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.TempDir = &kubelitedbv1.TempDirSpec{SizeLimit: "lots"}
+			},
+			fields: []string{"spec.tempDir.sizeLimit"},
+		},
+		{
+			name:   "temp dir size limit zero",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.TempDir = &kubelitedbv1.TempDirSpec{SizeLimit: "0"} },
+			fields: []string{"spec.tempDir.sizeLimit"},
+		},
+		{
+			name: "temp dir size limit negative",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.TempDir = &kubelitedbv1.TempDirSpec{SizeLimit: "-1Gi"}
+			},
+			fields: []string{"spec.tempDir.sizeLimit"},
+		},
+		{
+			name: "TLS forwarding to the TLS port",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.TLS = &kubelitedbv1.TLSSpec{SecretRef: corev1.LocalObjectReference{Name: "db-tls"}, Port: kubelitedbv1.TLSPort}
+			},
+			fields: []string{"spec.tls.port"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := validSpec()
+			tt.mutate(spec)
+
+			errs := ValidateSQLiteInstanceSpec(spec, field.NewPath("spec"))
+
+			var got []string
+			for _, err := range errs {
+				got = append(got, err.Field)
+			}
+			if !slices.Equal(got, tt.fields) {
+				t.Errorf("expected errors on %v, got %v", tt.fields, errs)
+			}
+		})
+	}
+}
+
+// TestValidateSQLiteInstanceUpdate changes a valid SQLiteInstance with mutate,
+// and expects the update to be rejected on the given fields, or accepted when
+// there are none.
+func TestValidateSnapshotOnDelete(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotation  string
+		destination *kubelitedbv1.BackupDestination
+		fields      []string
+	}{
+		{name: "not annotated"},
+		{
+			name:        "annotated with a destination",
+			annotation:  "true",
+			destination: &kubelitedbv1.BackupDestination{Bucket: "backups", SecretRef: corev1.LocalObjectReference{Name: "s3-credentials"}},
+		},
+		{name: "annotated without a destination", annotation: "true", fields: []string{"spec.backupDestination"}},
+		{name: "annotation not true", annotation: "false"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := &kubelitedbv1.SQLiteInstance{Spec: *validSpec()}
+			if tt.annotation != "" {
+				instance.Annotations = map[string]string{kubelitedbv1.SnapshotOnDeleteAnnotation: tt.annotation}
+			}
+			if tt.destination != nil {
+				instance.Spec.BackupDestination, instance.Spec.BackupSchedule = tt.destination, "0 3 * * *"
+			}
+
+			var got []string
+			for _, err := range ValidateSQLiteInstance(instance) {
+				got = append(got, err.Field)
+			}
+			if !slices.Equal(got, tt.fields) {
+				t.Errorf("expected errors on %v, got %v", tt.fields, got)
+			}
+		})
+	}
+}
+
+func TestValidateSQLiteInstanceUpdate(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(instance *kubelitedbv1.SQLiteInstance)
+		fields []string
+	}{
+		{name: "unchanged", mutate: func(instance *kubelitedbv1.SQLiteInstance) {}},
+		{name: "scale to zero", mutate: func(instance *kubelitedbv1.SQLiteInstance) { instance.Spec.Replicas = 0 }, fields: []string{"spec.replicas"}},
+		{
+			name: "scale to zero allowing data loss",
+			mutate: func(instance *kubelitedbv1.SQLiteInstance) {
+				instance.Annotations = map[string]string{kubelitedbv1.AllowDataLossAnnotation: "true"}
+				instance.Spec.Replicas = 0
+			},
+		},
+		{
+			name: "scale to zero with another annotation value",
+			mutate: func(instance *kubelitedbv1.SQLiteInstance) {
+				instance.Annotations = map[string]string{kubelitedbv1.AllowDataLossAnnotation: "yes"}
+				instance.Spec.Replicas = 0
+			},
+			fields: []string{"spec.replicas"},
+		},
+		{
+			name:   "access mode",
+			mutate: func(instance *kubelitedbv1.SQLiteInstance) { instance.Spec.AccessMode = corev1.ReadWriteMany },
+			fields: []string{"spec.accessMode"},
+		},
+		{
+			name:   "default access mode made explicit",
+			mutate: func(instance *kubelitedbv1.SQLiteInstance) { instance.Spec.AccessMode = corev1.ReadWriteOnce },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldInstance := &kubelitedbv1.SQLiteInstance{Spec: *validSpec()}
+			instance := oldInstance.DeepCopy()
+			tt.mutate(instance)
+
+			errs := ValidateSQLiteInstanceUpdate(instance, oldInstance)
+
+			var got []string
+			for _, err := range errs {
+				got = append(got, err.Field)
+			}
+			if !slices.Equal(got, tt.fields) {
+				t.Errorf("expected errors on %v, got %v", tt.fields, errs)
+			}
+		})
+	}
+}
+
+func TestValidateStorageShrink(t *testing.T) {
+	tests := []struct {
+		name       string
+		oldStorage string
+		newStorage string
+		forbidden  bool
+	}{
+		{name: "grow", oldStorage: "1Gi", newStorage: "2Gi"},
+		{name: "equal", oldStorage: "1Gi", newStorage: "1Gi"},
+		{name: "equal in other units", oldStorage: "1Gi", newStorage: "1024Mi"},
+		{name: "shrink", oldStorage: "2Gi", newStorage: "1Gi", forbidden: true},
+		{name: "shrink in other units", oldStorage: "1Gi", newStorage: "1000M", forbidden: true},
+		// Reported by ValidateSQLiteInstance instead
+		{name: "unparseable old storage", oldStorage: "lots", newStorage: "1Gi"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldInstance := &kubelitedbv1.SQLiteInstance{Spec: *validSpec()}
+			oldInstance.Spec.Storage = tt.oldStorage
+			instance := oldInstance.DeepCopy()
+			instance.Spec.Storage = tt.newStorage
+
+			errs := ValidateSQLiteInstanceUpdate(instance, oldInstance)
+
+			if !tt.forbidden {
+				if len(errs) != 0 {
+					t.Errorf("expected the update to be accepted, got %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || errs[0].Field != "spec.storage" || errs[0].Type != field.ErrorTypeForbidden {
+				t.Fatalf("expected spec.storage to be forbidden, got %v", errs)
+			}
+			if !strings.Contains(errs[0].Detail, tt.oldStorage) || !strings.Contains(errs[0].Detail, tt.newStorage) {
+				t.Errorf("expected the message to name both sizes, got %q", errs[0].Detail)
+			}
+		})
+	}
+}
+
+// TestValidateImmutableFields changes a SQLiteInstance with mutate, and
+// expects the update to be rejected on the given immutable fields, or accepted
+// when there are none.
+func TestValidateImmutableFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(spec *kubelitedbv1.SQLiteInstanceSpec)
+		fields []string
+	}{
+		{name: "database name", mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.DbName = "other.db" }, fields: []string{"spec.dbName"}},
+		{
+			name: "storage class",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				fast := "fast"
+				spec.StorageClassName = &fast
+			},
+			fields: []string{"spec.storageClassName"},
+		},
+		{name: "storage class unset", mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.StorageClassName = nil }, fields: []string{"spec.storageClassName"}},
+		{
+			name: "shared volume",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.AccessMode = corev1.ReadWriteMany
+				spec.VolumeClaimName = "shared"
+				spec.SubPath = true
+			},
+			fields: []string{"spec.accessMode", "spec.volumeClaimName", "spec.subPath"},
+		},
+		{name: "sub path", mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.SubPath = true }, fields: []string{"spec.subPath"}},
+		{
+			name: "deployment mode",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.DeploymentMode = kubelitedbv1.DeploymentModeDaemonSet
+			},
+			fields: []string{"spec.deploymentMode"},
+		},
+		{
+			name: "default deployment mode made explicit",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.DeploymentMode = kubelitedbv1.DeploymentModeStatefulSet
+			},
+		},
+		{name: "read replicas", mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.ReadReplicas = 2 }},
+		{
+			name: "resources",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.Resources = corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("256Mi")},
+				}
+			},
+		},
+		{name: "storage grown", mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.Storage = "2Gi" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			standard := "standard"
+			oldInstance := &kubelitedbv1.SQLiteInstance{Spec: *validSpec()}
+			oldInstance.Spec.StorageClassName = &standard
+			instance := oldInstance.DeepCopy()
+			tt.mutate(&instance.Spec)
+
+			errs := ValidateSQLiteInstanceUpdate(instance, oldInstance)
+
+			var got []string
+			for _, err := range errs {
+				got = append(got, err.Field)
+				if err.Type != field.ErrorTypeForbidden || !strings.Contains(err.Detail, "is immutable") {
+					t.Errorf("expected %s to be reported as immutable, got %v", err.Field, err)
+				}
+			}
+			if !slices.Equal(got, tt.fields) {
+				t.Errorf("expected errors on %v, got %v", tt.fields, errs)
+			}
+		})
+	}
+}
+
+func TestValidateImage(t *testing.T) {
+	tests := []struct {
+		name  string
+		image string
+		valid bool
+	}{
+		{name: "short name", image: "alpine", valid: true},
+		{name: "tag", image: "alpine:3.19", valid: true},
+		{name: "registry", image: "ghcr.io/fortytwoapps/kubelitedb:1.0", valid: true},
+		{name: "registry port", image: "localhost:5000/sqlite", valid: true},
+		{name: "digest", image: "alpine@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", valid: true},
+		{name: "empty", image: "", valid: false},
+		{name: "uppercase path", image: "Alpine", valid: false},
+		{name: "whitespace", image: "bad image", valid: false},
+		{name: "empty tag", image: "alpine:", valid: false},
+		{name: "short digest", image: "alpine@sha256:abc", valid: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := ValidateImage(tt.image, field.NewPath("spec", "image"))
+			if tt.valid && len(errs) != 0 {
+				t.Errorf("expected %q to be valid, got %v", tt.image, errs)
+			}
+			if !tt.valid && len(errs) == 0 {
+				t.Errorf("expected %q to be invalid", tt.image)
+			}
+		})
+	}
+}
+
+func TestValidateDbName(t *testing.T) {
+	tests := []struct {
+		dbName string
+		valid  bool
+	}{
+		{dbName: "app.db", valid: true},
+		{dbName: "app", valid: true},
+		{dbName: "my-app_2.db", valid: true},
+		{dbName: "", valid: false},
+		{dbName: "../app.db", valid: false},
+		{dbName: "../../etc/passwd", valid: false},
+		{dbName: "data/app.db", valid: false},
+		{dbName: "/app.db", valid: false},
+		{dbName: "..", valid: false},
+		{dbName: ".db", valid: false},
+		{dbName: "app.sqlite", valid: false},
+		{dbName: "app db", valid: false},
+		{dbName: "app\x00.db", valid: false},
+		{dbName: "app-wal", valid: false},
+		{dbName: "app-shm", valid: false},
+		{dbName: "app-journal", valid: false},
+		{dbName: strings.Repeat("a", 256), valid: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dbName, func(t *testing.T) {
+			errs := ValidateDbName(tt.dbName, field.NewPath("spec", "dbName"))
+			if tt.valid && len(errs) != 0 {
+				t.Errorf("expected %q to be valid, got %v", tt.dbName, errs)
+			}
+			if !tt.valid && len(errs) == 0 {
+				t.Errorf("expected %q to be invalid", tt.dbName)
+			}
+		})
+	}
+}
+
+func TestValidateDatabaseCollision(t *testing.T) {
+	// sharing returns a SQLiteInstance keeping its database on the volume of
+	// the given PVC
+	sharing := func(name, claimName, dbName string, subPath bool) *kubelitedbv1.SQLiteInstance {
+		instance := &kubelitedbv1.SQLiteInstance{
+			ObjectMeta: v1.ObjectMeta{Name: name, Namespace: v1.NamespaceDefault},
+			Spec:       *validSpec(),
+		}
+		instance.Spec.DbName = dbName
+		instance.Spec.VolumeClaimName = claimName
+		instance.Spec.AccessMode = corev1.ReadWriteMany
+		instance.Spec.SubPath = subPath
+		return instance
+	}
+	deleting := sharing("old", "shared", "app.db", true)
+	deleting.DeletionTimestamp = &v1.Time{Time: time.Now()}
+	tests := []struct {
+		name     string
+		instance *kubelitedbv1.SQLiteInstance
+		existing []*kubelitedbv1.SQLiteInstance
+		// collisions are the names of the SQLiteInstances collided with
+		collisions []string
+	}{
+		{name: "no existing SQLiteInstances", instance: sharing("test", "shared", "app.db", true)},
+		{
+			name:       "same directory of the same PVC",
+			instance:   sharing("test", "shared", "app.db", true),
+			existing:   []*kubelitedbv1.SQLiteInstance{sharing("other", "shared", "app", true)},
+			collisions: []string{"other"},
+		},
+		{
+			name:     "other directory of the same PVC",
+			instance: sharing("test", "shared", "app.db", true),
+			existing: []*kubelitedbv1.SQLiteInstance{sharing("other", "shared", "orders.db", true)},
+		},
+		{
+			name:     "same database name on another PVC",
+			instance: sharing("test", "shared", "app.db", true),
+			existing: []*kubelitedbv1.SQLiteInstance{sharing("other", "mine", "app.db", true)},
+		},
+		{
+			name:       "existing database at the root of the volume",
+			instance:   sharing("test", "shared", "app.db", true),
+			existing:   []*kubelitedbv1.SQLiteInstance{sharing("other", "shared", "orders.db", false)},
+			collisions: []string{"other"},
+		},
+		{
+			name:       "new database at the root of the volume",
+			instance:   sharing("test", "shared", "app.db", false),
+			existing:   []*kubelitedbv1.SQLiteInstance{sharing("other", "shared", "orders.db", true)},
+			collisions: []string{"other"},
+		},
+		{
+			name:     "every collision",
+			instance: sharing("test", "shared", "app.db", true),
+			existing: []*kubelitedbv1.SQLiteInstance{
+				sharing("a", "shared", "app.db", true),
+				sharing("b", "shared", "orders.db", true),
+				sharing("c", "shared", "app", true),
+			},
+			collisions: []string{"a", "c"},
+		},
+		{
+			name:     "own volume",
+			instance: sharing("test", "", "app.db", false),
+			existing: []*kubelitedbv1.SQLiteInstance{sharing("other", "", "app.db", false)},
+		},
+		{
+			name:     "itself",
+			instance: sharing("test", "shared", "app.db", true),
+			existing: []*kubelitedbv1.SQLiteInstance{sharing("test", "shared", "app.db", true)},
+		},
+		{
+			name:     "SQLiteInstance being deleted",
+			instance: sharing("test", "shared", "app.db", true),
+			existing: []*kubelitedbv1.SQLiteInstance{deleting},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := ValidateDatabaseCollision(tt.instance, tt.existing)
+
+			if len(errs) != len(tt.collisions) {
+				t.Fatalf("expected collisions with %v, got %v", tt.collisions, errs)
+			}
+			for i, err := range errs {
+				if err.Field != "spec.dbName" || err.Type != field.ErrorTypeForbidden {
+					t.Errorf("expected spec.dbName to be forbidden, got %v", err)
+				}
+				if !strings.Contains(err.Detail, "SQLiteInstance "+tt.collisions[i]+" ") {
+					t.Errorf("expected the collision with %s to be reported, got %q", tt.collisions[i], err.Detail)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateLegacyDatabasePath(t *testing.T) {
+	tests := []struct {
+		legacyPath string
+		valid      bool
+	}{
+		{legacyPath: "app.db", valid: true},
+		{legacyPath: "data/app.db", valid: true},
+		{legacyPath: "sqlite/data/app.sqlite", valid: true},
+		{legacyPath: "/data/app.db", valid: false},
+		{legacyPath: "../app.db", valid: false},
+		{legacyPath: "..", valid: false},
+		{legacyPath: ".", valid: false},
+		{legacyPath: "data/../../app.db", valid: false},
+		{legacyPath: "data//app.db", valid: false},
+		{legacyPath: "./app.db", valid: false},
+		{legacyPath: "data/", valid: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.legacyPath, func(t *testing.T) {
+			errs := ValidateLegacyDatabasePath(tt.legacyPath, field.NewPath("spec", "legacyDatabasePath"))
+			if tt.valid && len(errs) != 0 {
+				t.Errorf("expected %q to be valid, got %v", tt.legacyPath, errs)
+			}
+			if !tt.valid && len(errs) == 0 {
+				t.Errorf("expected %q to be invalid", tt.legacyPath)
+			}
+		})
+	}
+}
+
+func TestValidatePragmas(t *testing.T) {
+	tests := []struct {
+		name    string
+		pragmas map[string]string
+		valid   bool
+	}{
+		{name: "none", valid: true},
+		{name: "journal mode", pragmas: map[string]string{"journal_mode": "WAL"}, valid: true},
+		{name: "negative cache size", pragmas: map[string]string{"cache_size": "-2000"}, valid: true},
+		{name: "several", pragmas: map[string]string{"synchronous": "normal", "foreign_keys": "on", "busy_timeout": "5000"}, valid: true},
+		{name: "unknown", pragmas: map[string]string{"no_such_pragma": "1"}, valid: false},
+		{name: "unsafe", pragmas: map[string]string{"writable_schema": "on"}, valid: false},
+		{name: "invalid value", pragmas: map[string]string{"journal_mode": "fast"}, valid: false},
+		{name: "statement injection", pragmas: map[string]string{"journal_mode": "wal; DROP TABLE users"}, valid: false},
+		{name: "non numeric", pragmas: map[string]string{"busy_timeout": "5s"}, valid: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := ValidatePragmas(tt.pragmas, field.NewPath("spec", "pragmas"))
+			if tt.valid && len(errs) != 0 {
+				t.Errorf("expected %v to be valid, got %v", tt.pragmas, errs)
+			}
+			if !tt.valid && len(errs) == 0 {
+				t.Errorf("expected %v to be invalid", tt.pragmas)
+			}
+		})
+	}
+}
+
+func TestValidateDeploymentMode(t *testing.T) {
+	daemonSet := func(mutate func(spec *kubelitedbv1.SQLiteInstanceSpec)) func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+		return func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+			spec.DeploymentMode = kubelitedbv1.DeploymentModeDaemonSet
+			mutate(spec)
+		}
+	}
+	s3 := corev1.LocalObjectReference{Name: "s3-credentials"}
+	tests := []struct {
+		name   string
+		mutate func(spec *kubelitedbv1.SQLiteInstanceSpec)
+		fields []string
+	}{
+		{name: "default", mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {}},
+		{
+			name: "StatefulSet with the shared volume",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.DeploymentMode = kubelitedbv1.DeploymentModeStatefulSet
+				spec.AccessMode = corev1.ReadWriteMany
+				spec.VolumeClaimName = "shared"
+			},
+		},
+		{name: "DaemonSet", mutate: daemonSet(func(spec *kubelitedbv1.SQLiteInstanceSpec) {})},
+		{
+			name:   "DaemonSet with ReadWriteOnce",
+			mutate: daemonSet(func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.AccessMode = corev1.ReadWriteOnce }),
+		},
+		{
+			name:   "unsupported",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.DeploymentMode = "Deployment" },
+			fields: []string{"spec.deploymentMode"},
+		},
+		{
+			name:   "ReadWriteMany",
+			mutate: daemonSet(func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.AccessMode = corev1.ReadWriteMany }),
+			fields: []string{"spec.accessMode"},
+		},
+		{
+			name:   "shared volume",
+			mutate: daemonSet(func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.VolumeClaimName = "shared" }),
+			fields: []string{"spec.volumeClaimName"},
+		},
+		{
+			name:   "read replicas",
+			mutate: daemonSet(func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.ReadReplicas = 2 }),
+			fields: []string{"spec.readReplicas"},
+		},
+		{
+			name:   "suspend",
+			mutate: daemonSet(func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.Suspend = true }),
+			fields: []string{"spec.suspend"},
+		},
+		{
+			name: "replication",
+			mutate: daemonSet(func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.Replication = &kubelitedbv1.ReplicationSpec{Bucket: "wal", SecretRef: s3}
+			}),
+			fields: []string{"spec.replication"},
+		},
+		{
+			name: "scheduled backups",
+			mutate: daemonSet(func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.BackupSchedule = "0 * * * *"
+				spec.BackupDestination = &kubelitedbv1.BackupDestination{Bucket: "backups", SecretRef: s3}
+			}),
+			fields: []string{"spec.backupSchedule", "spec.backupDestination"},
+		},
+		{
+			name: "restore",
+			mutate: daemonSet(func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.RestoreFrom = &kubelitedbv1.RestoreSource{Bucket: "backups", Key: "app.db", SecretRef: s3}
+			}),
+			fields: []string{"spec.restoreFrom"},
+		},
+		{
+			name: "clone",
+			mutate: daemonSet(func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.CloneFrom = &corev1.LocalObjectReference{Name: "source"}
+			}),
+			fields: []string{"spec.cloneFrom"},
+		},
+		{
+			name:   "legacy database path",
+			mutate: daemonSet(func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.LegacyDatabasePath = "data/app.db" }),
+			fields: []string{"spec.legacyDatabasePath"},
+		},
+		{
+			name: "topology spread constraints",
+			mutate: daemonSet(func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.TopologySpreadConstraints = []corev1.TopologySpreadConstraint{{
+					MaxSkew:           1,
+					TopologyKey:       corev1.LabelHostname,
+					WhenUnsatisfiable: corev1.DoNotSchedule,
+				}}
+			}),
+			fields: []string{"spec.topologySpreadConstraints"},
+		},
+		{
+			name:   "spread across zones",
+			mutate: daemonSet(func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.SpreadAcrossZones = true }),
+			fields: []string{"spec.spreadAcrossZones"},
+		},
+		{
+			name: "integrity check",
+			mutate: daemonSet(func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.IntegrityCheck = &kubelitedbv1.IntegrityCheckSpec{Schedule: "0 4 * * 0"}
+			}),
+			fields: []string{"spec.integrityCheck"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := validSpec()
+			tt.mutate(spec)
+
+			errs := ValidateDeploymentMode(spec, field.NewPath("spec"))
+
+			var got []string
+			for _, err := range errs {
+				got = append(got, err.Field)
+			}
+			if !slices.Equal(got, tt.fields) {
+				t.Errorf("expected errors on %v, got %v", tt.fields, errs)
+			}
+		})
+	}
+}
+
+func TestValidateArchitecture(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(spec *kubelitedbv1.SQLiteInstanceSpec)
+		fields []string
+	}{
+		{name: "any architecture", mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {}},
+		{name: "architecture", mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.Architecture = "arm64" }},
+		{
+			name:   "unknown architecture",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) { spec.Architecture = "aarch64" },
+			fields: []string{"spec.architecture"},
+		},
+		{
+			name: "matching node selector",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.Architecture = "arm64"
+				spec.NodeSelector = map[string]string{corev1.LabelArchStable: "arm64"}
+			},
+		},
+		{
+			name: "conflicting node selector",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.Architecture = "arm64"
+				spec.NodeSelector = map[string]string{corev1.LabelArchStable: "amd64"}
+			},
+			fields: []string{"spec.nodeSelector[kubernetes.io/arch]"},
+		},
+		{
+			name: "images of the architectures",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.Architecture = "amd64"
+				spec.ArchitectureImages = map[string]string{"amd64": "example.com/sqlite:3-amd64", "arm64": "example.com/sqlite:3-arm64"}
+			},
+		},
+		{
+			name: "images without an architecture",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.ArchitectureImages = map[string]string{"arm64": "example.com/sqlite:3-arm64"}
+			},
+			fields: []string{"spec.architecture"},
+		},
+		{
+			name: "image of an unknown architecture",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.Architecture = "amd64"
+				spec.ArchitectureImages = map[string]string{"x86_64": "example.com/sqlite:3-amd64"}
+			},
+			fields: []string{"spec.architectureImages[x86_64]"},
+		},
+		{
+			name: "invalid image",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.Architecture = "arm64"
+				spec.ArchitectureImages = map[string]string{"arm64": "example.com/sqlite:3 arm64"}
+			},
+			fields: []string{"spec.architectureImages[arm64]"},
+		},
+		{
+			name: "errors in a stable order",
+			mutate: func(spec *kubelitedbv1.SQLiteInstanceSpec) {
+				spec.Architecture = "arm64"
+				spec.ArchitectureImages = map[string]string{"x86_64": "a", "aarch64": "b", "i386": "c"}
+			},
+			fields: []string{"spec.architectureImages[aarch64]", "spec.architectureImages[i386]", "spec.architectureImages[x86_64]"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := validSpec()
+			tt.mutate(spec)
+
+			errs := ValidateArchitecture(spec, field.NewPath("spec"))
+
+			var got []string
+			for _, err := range errs {
+				got = append(got, err.Field)
+			}
+			if !slices.Equal(got, tt.fields) {
+				t.Errorf("expected errors on %v, got %v", tt.fields, errs)
+			}
+		})
+	}
+}
+
+func TestValidateIntegrityCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule string
+		fields   []string
+	}{
+		{name: "valid", schedule: "0 4 * * 0"},
+		{name: "descriptor", schedule: "@weekly"},
+		{name: "missing schedule", fields: []string{"spec.integrityCheck.schedule"}},
+		{name: "invalid schedule", schedule: "every sunday", fields: []string{"spec.integrityCheck.schedule"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := validSpec()
+			spec.IntegrityCheck = &kubelitedbv1.IntegrityCheckSpec{Schedule: tt.schedule}
+
+			var got []string
+			for _, err := range ValidateSQLiteInstanceSpec(spec, field.NewPath("spec")) {
+				got = append(got, err.Field)
+			}
+			if !slices.Equal(got, tt.fields) {
+				t.Errorf("expected errors on %v, got %v", tt.fields, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+	kubelitedbv1beta1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1beta1"
+)
+
+// ConvertPath is the path the conversion webhook is served on
+const ConvertPath = "/convert"
+
+// ConversionReview mirrors the apiextensions.k8s.io/v1 ConversionReview sent
+// by the API server to convert custom resources between versions.
+type ConversionReview struct {
+	metav1.TypeMeta `json:",inline"`
+	Request         *ConversionRequest  `json:"request,omitempty"`
+	Response        *ConversionResponse `json:"response,omitempty"`
+}
+
+// ConversionRequest holds the objects to convert to DesiredAPIVersion
+type ConversionRequest struct {
+	UID               types.UID              `json:"uid"`
+	DesiredAPIVersion string                 `json:"desiredAPIVersion"`
+	Objects           []runtime.RawExtension `json:"objects"`
+}
+
+// ConversionResponse holds the converted objects, in the order of the request
+type ConversionResponse struct {
+	UID              types.UID              `json:"uid"`
+	ConvertedObjects []runtime.RawExtension `json:"convertedObjects"`
+	Result           metav1.Status          `json:"result"`
+}
+
+// serveConvert decodes the ConversionReview in the request body and writes
+// back the review with every object converted to the desired version.
+func serveConvert(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if contentType := r.Header.Get("Content-Type"); contentType != "application/json" {
+		http.Error(w, fmt.Sprintf("unsupported content type %q, expected application/json", contentType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	review := ConversionReview{}
+	if err := json.Unmarshal(body, &review); err != nil {
+		http.Error(w, fmt.Sprintf("failed to decode ConversionReview: %v", err), http.StatusBadRequest)
+		return
+	}
+	if review.Request == nil {
+		http.Error(w, "ConversionReview contains no request", http.StatusBadRequest)
+		return
+	}
+
+	response := &ConversionResponse{
+		UID:    review.Request.UID,
+		Result: metav1.Status{Status: metav1.StatusSuccess},
+	}
+	for _, object := range review.Request.Objects {
+		converted, err := convert(object.Raw, review.Request.DesiredAPIVersion)
+		if err != nil {
+			response.ConvertedObjects = nil
+			response.Result = metav1.Status{Status: metav1.StatusFailure, Message: err.Error()}
+			break
+		}
+		response.ConvertedObjects = append(response.ConvertedObjects, runtime.RawExtension{Raw: converted})
+	}
+	review.Response = response
+	review.Request = nil
+
+	data, err := json.Marshal(review)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to encode ConversionReview: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(data); err != nil {
+		klog.ErrorS(err, "Failed to write conversion response")
+	}
+}
+
+// convert converts the SQLiteInstance in raw to the desired API version,
+// going through v1 as the storage version.
+func convert(raw []byte, desiredAPIVersion string) ([]byte, error) {
+	typeMeta := metav1.TypeMeta{}
+	if err := json.Unmarshal(raw, &typeMeta); err != nil {
+		return nil, fmt.Errorf("failed to decode object: %w", err)
+	}
+	if typeMeta.Kind != "SQLiteInstance" {
+		return nil, fmt.Errorf("unexpected kind %q", typeMeta.Kind)
+	}
+
+	instance := &kubelitedbv1.SQLiteInstance{}
+	switch typeMeta.APIVersion {
+	case kubelitedbv1.SchemeGroupVersion.String():
+		if err := json.Unmarshal(raw, instance); err != nil {
+			return nil, fmt.Errorf("failed to decode SQLiteInstance: %w", err)
+		}
+	case kubelitedbv1beta1.SchemeGroupVersion.String():
+		old := &kubelitedbv1beta1.SQLiteInstance{}
+		if err := json.Unmarshal(raw, old); err != nil {
+			return nil, fmt.Errorf("failed to decode SQLiteInstance: %w", err)
+		}
+		instance = kubelitedbv1beta1.ConvertToV1(old)
+	default:
+		return nil, fmt.Errorf("unsupported API version %q", typeMeta.APIVersion)
+	}
+
+	switch desiredAPIVersion {
+	case kubelitedbv1.SchemeGroupVersion.String():
+		instance.APIVersion = desiredAPIVersion
+		return json.Marshal(instance)
+	case kubelitedbv1beta1.SchemeGroupVersion.String():
+		return json.Marshal(kubelitedbv1beta1.ConvertFromV1(instance))
+	default:
+		return nil, fmt.Errorf("unsupported API version %q", desiredAPIVersion)
+	}
+}
@@ -0,0 +1,285 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+	kubelitedbv1beta1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1beta1"
+)
+
+// conversionReview returns the raw ConversionReview of the objects to the
+// desired API version.
+func conversionReview(desiredAPIVersion string, objects ...string) string {
+	return fmt.Sprintf(`{
+		"apiVersion": "apiextensions.k8s.io/v1",
+		"kind": "ConversionReview",
+		"request": {
+			"uid": "705ab4f5-6393-11e8-b7cc-42010a800002",
+			"desiredAPIVersion": %q,
+			"objects": [%s]
+		}
+	}`, desiredAPIVersion, strings.Join(objects, ","))
+}
+
+// versionedSQLiteInstance returns the raw SQLiteInstance of the API version
+// with the given spec and status.
+func versionedSQLiteInstance(apiVersion, spec, status string) string {
+	return fmt.Sprintf(`{
+		"apiVersion": %q,
+		"kind": "SQLiteInstance",
+		"metadata": {"name": "test", "namespace": "default", "uid": "a9b0c1d2", "generation": 3, "labels": {"app": "test"}},
+		"spec": %s,
+		"status": %s
+	}`, apiVersion, spec, status)
+}
+
+// postConversion sends the raw ConversionReview to serveConvert and returns
+// the response of the ConversionReview it replied with.
+func postConversion(t *testing.T, body string) *ConversionResponse {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, ConvertPath, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	serveConvert(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body)
+	}
+
+	review := ConversionReview{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &review); err != nil {
+		t.Fatalf("error decoding ConversionReview: %v", err)
+	}
+	if review.Response == nil {
+		t.Fatalf("expected a response in the ConversionReview")
+	}
+	if review.Response.UID != "705ab4f5-6393-11e8-b7cc-42010a800002" {
+		t.Errorf("expected the response to carry the UID of the request, got %q", review.Response.UID)
+	}
+	return review.Response
+}
+
+// decodeV1 decodes the raw object as a v1 SQLiteInstance.
+func decodeV1(t *testing.T, raw []byte) *kubelitedbv1.SQLiteInstance {
+	t.Helper()
+	instance := &kubelitedbv1.SQLiteInstance{}
+	if err := json.Unmarshal(raw, instance); err != nil {
+		t.Fatalf("error decoding SQLiteInstance: %v", err)
+	}
+	return instance
+}
+
+// representativeSpecs are the specs and statuses of the SQLiteInstances the
+// conversion tests round trip.
+var representativeSpecs = []struct {
+	name   string
+	spec   string
+	status string
+}{
+	{
+		name:   "minimal",
+		spec:   `{"dbName": "test", "storage": "1Gi", "replicas": 1}`,
+		status: `{"phase": "Pending"}`,
+	},
+	{
+		name: "replicated with backups",
+		spec: `{
+			"dbName": "test", "storage": "10Gi", "replicas": 1, "readReplicas": 2,
+			"storageClassName": "fast", "nodeSelector": {"disk": "ssd"},
+			"replication": {"bucket": "wal", "path": "test", "secretRef": {"name": "s3"}},
+			"backupSchedule": "0 * * * *",
+			"backupRetention": {"count": 7, "maxAge": "168h0m0s"},
+			"backupDestination": {"bucket": "backups", "secretRef": {"name": "s3"}},
+			"tls": {"secretRef": {"name": "cert"}, "port": 8443},
+			"pragmas": {"journal_mode": "WAL"}
+		}`,
+		status: `{
+			"phase": "Running", "observedGeneration": 3, "replicas": 3, "readyReplicas": 3,
+			"selector": "app=test", "lastBackupTime": "2024-05-01T10:00:00Z",
+			"volumes": [{"claimName": "data-test-0", "phase": "Bound", "capacity": "10Gi"}],
+			"conditions": [{"type": "Ready", "status": "True", "reason": "Running", "message": "", "lastTransitionTime": "2024-05-01T10:00:00Z"}]
+		}`,
+	},
+	{
+		name: "suspended restore",
+		spec: `{
+			"dbName": "test", "storage": "512Mi", "replicas": 0, "suspend": true,
+			"restoreFrom": {"bucket": "backups", "key": "test/backup.db", "secretRef": {"name": "s3"}},
+			"updateStrategy": {"type": "RollingUpdate", "partition": 1}
+		}`,
+		status: `{"phase": "Suspended", "restored": true, "lastError": {"reason": "Failed", "message": "boom", "time": "2024-05-01T10:00:00Z", "retryCount": 2}}`,
+	},
+}
+
+func TestConvertRoundTrip(t *testing.T) {
+	for _, tt := range representativeSpecs {
+		t.Run(tt.name, func(t *testing.T) {
+			original := versionedSQLiteInstance(kubelitedbv1.SchemeGroupVersion.String(), tt.spec, tt.status)
+
+			old, err := convert([]byte(original), kubelitedbv1beta1.SchemeGroupVersion.String())
+			if err != nil {
+				t.Fatalf("error converting to v1beta1: %v", err)
+			}
+			typeMeta := metav1.TypeMeta{}
+			if err := json.Unmarshal(old, &typeMeta); err != nil {
+				t.Fatalf("error decoding the v1beta1 object: %v", err)
+			}
+			if typeMeta.APIVersion != kubelitedbv1beta1.SchemeGroupVersion.String() || typeMeta.Kind != "SQLiteInstance" {
+				t.Errorf("expected a v1beta1 SQLiteInstance, got %s %s", typeMeta.APIVersion, typeMeta.Kind)
+			}
+
+			converted, err := convert(old, kubelitedbv1.SchemeGroupVersion.String())
+			if err != nil {
+				t.Fatalf("error converting back to v1: %v", err)
+			}
+			want, got := decodeV1(t, []byte(original)), decodeV1(t, converted)
+			if !equality.Semantic.DeepEqual(got, want) {
+				t.Errorf("expected the round trip to keep the SQLiteInstance\nwant: %+v\ngot:  %+v", want, got)
+			}
+		})
+	}
+}
+
+func TestConvertV1beta1ToV1(t *testing.T) {
+	for _, tt := range representativeSpecs {
+		t.Run(tt.name, func(t *testing.T) {
+			old := versionedSQLiteInstance(kubelitedbv1beta1.SchemeGroupVersion.String(), tt.spec, tt.status)
+			current := versionedSQLiteInstance(kubelitedbv1.SchemeGroupVersion.String(), tt.spec, tt.status)
+
+			converted, err := convert([]byte(old), kubelitedbv1.SchemeGroupVersion.String())
+			if err != nil {
+				t.Fatalf("error converting to v1: %v", err)
+			}
+			want, got := decodeV1(t, []byte(current)), decodeV1(t, converted)
+			if !equality.Semantic.DeepEqual(got, want) {
+				t.Errorf("expected the fields of the v1beta1 SQLiteInstance to be kept\nwant: %+v\ngot:  %+v", want, got)
+			}
+		})
+	}
+}
+
+func TestConvertErrors(t *testing.T) {
+	v1 := kubelitedbv1.SchemeGroupVersion.String()
+	tests := []struct {
+		name              string
+		object            string
+		desiredAPIVersion string
+		message           string
+	}{
+		{
+			name:              "unsupported desired version",
+			object:            versionedSQLiteInstance(v1, `{}`, `{}`),
+			desiredAPIVersion: "kubelitedb.fortytwoapps.tech/v2",
+			message:           `unsupported API version "kubelitedb.fortytwoapps.tech/v2"`,
+		},
+		{
+			name:              "unsupported object version",
+			object:            versionedSQLiteInstance("kubelitedb.fortytwoapps.tech/v1alpha1", `{}`, `{}`),
+			desiredAPIVersion: v1,
+			message:           `unsupported API version "kubelitedb.fortytwoapps.tech/v1alpha1"`,
+		},
+		{
+			name:              "unexpected kind",
+			object:            `{"apiVersion": "kubelitedb.fortytwoapps.tech/v1", "kind": "SQLiteBackup"}`,
+			desiredAPIVersion: v1,
+			message:           `unexpected kind "SQLiteBackup"`,
+		},
+		{
+			name:              "invalid spec",
+			object:            versionedSQLiteInstance(v1, `{"replicas": "three"}`, `{}`),
+			desiredAPIVersion: v1,
+			message:           "failed to decode SQLiteInstance",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := convert([]byte(tt.object), tt.desiredAPIVersion)
+			if err == nil || !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("expected an error containing %q, got %v", tt.message, err)
+			}
+		})
+	}
+}
+
+func TestServeConvert(t *testing.T) {
+	v1, v1beta1 := kubelitedbv1.SchemeGroupVersion.String(), kubelitedbv1beta1.SchemeGroupVersion.String()
+	minimal := representativeSpecs[0]
+
+	t.Run("converts every object", func(t *testing.T) {
+		response := postConversion(t, conversionReview(v1,
+			versionedSQLiteInstance(v1beta1, minimal.spec, minimal.status),
+			versionedSQLiteInstance(v1, minimal.spec, minimal.status),
+		))
+		if response.Result.Status != metav1.StatusSuccess {
+			t.Fatalf("expected the conversion to succeed, got %+v", response.Result)
+		}
+		if len(response.ConvertedObjects) != 2 {
+			t.Fatalf("expected 2 converted objects, got %d", len(response.ConvertedObjects))
+		}
+		for _, object := range response.ConvertedObjects {
+			if got := decodeV1(t, object.Raw); got.APIVersion != v1 {
+				t.Errorf("expected a %s object, got %s", v1, got.APIVersion)
+			}
+		}
+	})
+
+	t.Run("fails the whole review", func(t *testing.T) {
+		response := postConversion(t, conversionReview(v1,
+			versionedSQLiteInstance(v1beta1, minimal.spec, minimal.status),
+			`{"apiVersion": "v1", "kind": "ConfigMap"}`,
+		))
+		if response.Result.Status != metav1.StatusFailure || response.Result.Message != `unexpected kind "ConfigMap"` {
+			t.Errorf("expected the conversion to fail on the ConfigMap, got %+v", response.Result)
+		}
+		if len(response.ConvertedObjects) != 0 {
+			t.Errorf("expected no converted objects, got %d", len(response.ConvertedObjects))
+		}
+	})
+}
+
+func TestServeConvertRejectsMalformedRequests(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		code        int
+	}{
+		{name: "wrong content type", contentType: "text/plain", body: "{}", code: http.StatusUnsupportedMediaType},
+		{name: "invalid JSON", contentType: "application/json", body: "{", code: http.StatusBadRequest},
+		{name: "no request", contentType: "application/json", body: `{"kind":"ConversionReview"}`, code: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, ConvertPath, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+			serveConvert(rec, req)
+			if rec.Code != tt.code {
+				t.Errorf("expected status %d, got %d", tt.code, rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// invalidDbNameChars matches the characters that are not allowed in a DNS
+// subdomain name
+var invalidDbNameChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// patchOperation is a single JSON patch operation, as defined in RFC 6902
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// mutator sets defaults on SQLiteInstances
+type mutator struct {
+	defaultStorageClassName string
+}
+
+// mutate responds with the JSON patch setting the defaults on the
+// SQLiteInstance in the request. Defaults are only set when it is created, so
+// updates such as scaling to zero or removing a finalizer are left as is.
+func (m *mutator) mutate(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	if req.Operation != admissionv1.Create {
+		return allowed()
+	}
+	if req.Kind.Kind != "SQLiteInstance" {
+		return denied(http.StatusBadRequest, fmt.Sprintf("unexpected kind %q", req.Kind.Kind))
+	}
+
+	instance := &kubelitedbv1.SQLiteInstance{}
+	if err := json.Unmarshal(req.Object.Raw, instance); err != nil {
+		return denied(http.StatusBadRequest, fmt.Sprintf("failed to decode SQLiteInstance: %v", err))
+	}
+
+	patch := m.defaults(instance)
+	if len(patch) == 0 {
+		return allowed()
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return denied(http.StatusInternalServerError, fmt.Sprintf("failed to encode patch: %v", err))
+	}
+
+	patchType := admissionv1.PatchTypeJSONPatch
+	response := allowed()
+	response.Patch = data
+	response.PatchType = &patchType
+	return response
+}
+
+// defaults returns the JSON patch operations setting the defaults on the given
+// SQLiteInstance being created.
+func (m *mutator) defaults(instance *kubelitedbv1.SQLiteInstance) []patchOperation {
+	// The "add" operation replaces existing members, so it is used for fields
+	// which may or may not be present in the object.
+	var patch []patchOperation
+
+	if instance.Spec.Replicas == 0 {
+		patch = append(patch, patchOperation{Op: "add", Path: "/spec/replicas", Value: 1})
+	}
+
+	if instance.Spec.StorageClassName == nil && m.defaultStorageClassName != "" {
+		patch = append(patch, patchOperation{Op: "add", Path: "/spec/storageClassName", Value: m.defaultStorageClassName})
+	}
+
+	if dbName := normalizeDbName(instance.Spec.DbName); dbName != instance.Spec.DbName {
+		patch = append(patch, patchOperation{Op: "add", Path: "/spec/dbName", Value: dbName})
+	}
+
+	return patch
+}
+
+// normalizeDbName lowercases the database name and replaces the characters
+// that are not DNS-safe with dashes.
+func normalizeDbName(dbName string) string {
+	dbName = strings.ToLower(dbName)
+	dbName = invalidDbNameChars.ReplaceAllString(dbName, "-")
+	return strings.Trim(dbName, "-.")
+}
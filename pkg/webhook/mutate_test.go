@@ -0,0 +1,123 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+func TestMutate(t *testing.T) {
+	tests := []struct {
+		name                    string
+		defaultStorageClassName string
+		review                  string
+		patch                   []patchOperation
+	}{
+		{
+			name:   "nothing to default",
+			review: admissionReview(admissionv1.Create, sqliteInstance(`{"dbName":"app.db","storage":"1Gi","replicas":1}`), ""),
+		},
+		{
+			name:   "unset replicas",
+			review: admissionReview(admissionv1.Create, sqliteInstance(`{"dbName":"app.db","storage":"1Gi"}`), ""),
+			patch:  []patchOperation{{Op: "add", Path: "/spec/replicas", Value: float64(1)}},
+		},
+		{
+			name:   "zero replicas",
+			review: admissionReview(admissionv1.Create, sqliteInstance(`{"dbName":"app.db","storage":"1Gi","replicas":0}`), ""),
+			patch:  []patchOperation{{Op: "add", Path: "/spec/replicas", Value: float64(1)}},
+		},
+		{
+			name:                    "default storage class",
+			defaultStorageClassName: "fast",
+			review:                  admissionReview(admissionv1.Create, sqliteInstance(`{"dbName":"app.db","storage":"1Gi","replicas":1}`), ""),
+			patch:                   []patchOperation{{Op: "add", Path: "/spec/storageClassName", Value: "fast"}},
+		},
+		{
+			name:                    "requested storage class",
+			defaultStorageClassName: "fast",
+			review:                  admissionReview(admissionv1.Create, sqliteInstance(`{"dbName":"app.db","storage":"1Gi","replicas":1,"storageClassName":"slow"}`), ""),
+		},
+		{
+			name:   "database name normalized",
+			review: admissionReview(admissionv1.Create, sqliteInstance(`{"dbName":"My_App.db","storage":"1Gi","replicas":1}`), ""),
+			patch:  []patchOperation{{Op: "add", Path: "/spec/dbName", Value: "my-app.db"}},
+		},
+		{
+			name:                    "nothing defaulted on update",
+			defaultStorageClassName: "fast",
+			review: admissionReview(admissionv1.Update,
+				sqliteInstance(`{"dbName":"My_App.db","storage":"1Gi"}`),
+				sqliteInstance(`{"dbName":"My_App.db","storage":"1Gi","replicas":1}`)),
+		},
+		{
+			name: "scaled to zero",
+			review: admissionReview(admissionv1.Update,
+				sqliteInstance(`{"dbName":"app.db","storage":"1Gi","replicas":0}`),
+				sqliteInstance(`{"dbName":"app.db","storage":"1Gi","replicas":1}`)),
+		},
+		{
+			name:   "scaled through the scale subresource",
+			review: scaleReview(0, 1),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mutator{defaultStorageClassName: tt.defaultStorageClassName}
+			response := post(t, serve(m.mutate), tt.review)
+
+			if !response.Allowed {
+				t.Fatalf("expected the request to be allowed, got %+v", response.Result)
+			}
+			if len(tt.patch) == 0 {
+				if response.Patch != nil {
+					t.Errorf("expected no patch, got %s", response.Patch)
+				}
+				return
+			}
+			if response.PatchType == nil || *response.PatchType != admissionv1.PatchTypeJSONPatch {
+				t.Errorf("expected a JSON patch, got %v", response.PatchType)
+			}
+			var patch []patchOperation
+			if err := json.Unmarshal(response.Patch, &patch); err != nil {
+				t.Fatalf("error decoding patch: %v", err)
+			}
+			if !reflect.DeepEqual(patch, tt.patch) {
+				t.Errorf("expected patch %+v, got %+v", tt.patch, patch)
+			}
+		})
+	}
+}
+
+func TestNormalizeDbName(t *testing.T) {
+	tests := map[string]string{
+		"app.db":       "app.db",
+		"My App.db":    "my-app.db",
+		"-users_db-":   "users-db",
+		"..trailing..": "trailing",
+		"data/../x.db": "data-..-x.db",
+	}
+	for dbName, want := range tests {
+		if got := normalizeDbName(dbName); got != want {
+			t.Errorf("normalizeDbName(%q): expected %q, got %q", dbName, want, got)
+		}
+	}
+}
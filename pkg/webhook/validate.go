@@ -0,0 +1,123 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/labels"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+	listers "github.com/fortytwoapps/kubelitedb/pkg/generated/listers/kubelitedb/v1"
+	"github.com/fortytwoapps/kubelitedb/pkg/validation"
+)
+
+// validator validates SQLiteInstances
+type validator struct {
+	sqliteInstances listers.SQLiteInstanceLister
+}
+
+// validate admits SQLiteInstances that pass validation, and rejects the others
+// with a message listing every violated rule.
+func (v *validator) validate(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	if req.SubResource == "scale" {
+		return v.validateScale(req)
+	}
+	if req.Kind.Kind != "SQLiteInstance" {
+		return denied(http.StatusBadRequest, fmt.Sprintf("unexpected kind %q", req.Kind.Kind))
+	}
+	if req.Operation == admissionv1.Delete {
+		return allowed()
+	}
+
+	instance := &kubelitedbv1.SQLiteInstance{}
+	if err := json.Unmarshal(req.Object.Raw, instance); err != nil {
+		return denied(http.StatusBadRequest, fmt.Sprintf("failed to decode SQLiteInstance: %v", err))
+	}
+
+	errs := validation.ValidateSQLiteInstance(instance)
+	if req.Operation == admissionv1.Update {
+		oldInstance := &kubelitedbv1.SQLiteInstance{}
+		if err := json.Unmarshal(req.OldObject.Raw, oldInstance); err != nil {
+			return denied(http.StatusBadRequest, fmt.Sprintf("failed to decode old SQLiteInstance: %v", err))
+		}
+		// Metadata updates, such as the removal of a finalizer, must not be
+		// held back by rules the spec was admitted before.
+		if instance.DeletionTimestamp != nil || equality.Semantic.DeepEqual(instance.Spec, oldInstance.Spec) {
+			return allowed()
+		}
+		errs = validation.ValidateSQLiteInstanceUpdate(instance, oldInstance)
+	}
+	// The database and its volume can't be changed, so collisions are only
+	// checked when the SQLiteInstance is created.
+	if req.Operation == admissionv1.Create && v.sqliteInstances != nil {
+		existing, err := v.sqliteInstances.SQLiteInstances(req.Namespace).List(labels.Everything())
+		if err != nil {
+			return denied(http.StatusInternalServerError, fmt.Sprintf("failed to list SQLiteInstances: %v", err))
+		}
+		errs = append(errs, validation.ValidateDatabaseCollision(instance, existing)...)
+	}
+	if len(errs) > 0 {
+		return denied(http.StatusUnprocessableEntity, errs.ToAggregate().Error())
+	}
+	return allowed()
+}
+
+// validateScale admits changes to the replicas made through the scale
+// subresource, such as with kubectl scale, under the rules of updates to the
+// SQLiteInstance itself.
+func (v *validator) validateScale(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	if req.Kind.Kind != "Scale" {
+		return denied(http.StatusBadRequest, fmt.Sprintf("unexpected kind %q", req.Kind.Kind))
+	}
+	if req.Operation != admissionv1.Update || v.sqliteInstances == nil {
+		return allowed()
+	}
+
+	scale := &autoscalingv1.Scale{}
+	if err := json.Unmarshal(req.Object.Raw, scale); err != nil {
+		return denied(http.StatusBadRequest, fmt.Sprintf("failed to decode Scale: %v", err))
+	}
+	oldScale := &autoscalingv1.Scale{}
+	if err := json.Unmarshal(req.OldObject.Raw, oldScale); err != nil {
+		return denied(http.StatusBadRequest, fmt.Sprintf("failed to decode old Scale: %v", err))
+	}
+	if scale.Spec.Replicas == oldScale.Spec.Replicas {
+		return allowed()
+	}
+
+	// The Scale only carries the replicas, the annotations allowing the
+	// change are read from the SQLiteInstance.
+	oldInstance, err := v.sqliteInstances.SQLiteInstances(req.Namespace).Get(req.Name)
+	if err != nil {
+		return denied(http.StatusInternalServerError, fmt.Sprintf("failed to get SQLiteInstance %s/%s: %v", req.Namespace, req.Name, err))
+	}
+	oldInstance = oldInstance.DeepCopy()
+	oldInstance.Spec.Replicas = int(oldScale.Spec.Replicas)
+	instance := oldInstance.DeepCopy()
+	instance.Spec.Replicas = int(scale.Spec.Replicas)
+
+	if errs := validation.ValidateSQLiteInstanceUpdate(instance, oldInstance); len(errs) > 0 {
+		return denied(http.StatusUnprocessableEntity, errs.ToAggregate().Error())
+	}
+	return allowed()
+}
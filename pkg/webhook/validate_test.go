@@ -0,0 +1,415 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+	"github.com/fortytwoapps/kubelitedb/pkg/generated/clientset/versioned/fake"
+	informers "github.com/fortytwoapps/kubelitedb/pkg/generated/informers/externalversions"
+	listers "github.com/fortytwoapps/kubelitedb/pkg/generated/listers/kubelitedb/v1"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		review  string
+		allowed bool
+		// Substrings of the message of a denied request
+		messages []string
+	}{
+		{
+			name:    "valid",
+			review:  admissionReview(admissionv1.Create, sqliteInstance(`{"dbName":"app.db","storage":"1Gi","replicas":1}`), ""),
+			allowed: true,
+		},
+		{
+			name:     "negative replicas",
+			review:   admissionReview(admissionv1.Create, sqliteInstance(`{"dbName":"app.db","storage":"1Gi","replicas":-1}`), ""),
+			messages: []string{"spec.replicas"},
+		},
+		{
+			name:     "empty database name",
+			review:   admissionReview(admissionv1.Create, sqliteInstance(`{"dbName":"","storage":"1Gi","replicas":1}`), ""),
+			messages: []string{"spec.dbName"},
+		},
+		{
+			name:     "database name of a WAL file",
+			review:   admissionReview(admissionv1.Create, sqliteInstance(`{"dbName":"app-wal","storage":"1Gi","replicas":1}`), ""),
+			messages: []string{"spec.dbName", `must not end with "-wal"`},
+		},
+		{
+			name:     "database name of a rollback journal",
+			review:   admissionReview(admissionv1.Create, sqliteInstance(`{"dbName":"app-journal","storage":"1Gi","replicas":1}`), ""),
+			messages: []string{"spec.dbName", `must not end with "-journal"`},
+		},
+		{
+			name:     "database name escaping the data directory",
+			review:   admissionReview(admissionv1.Create, sqliteInstance(`{"dbName":"../app.db","storage":"1Gi","replicas":1}`), ""),
+			messages: []string{"spec.dbName"},
+		},
+		{
+			name:     "unparseable storage",
+			review:   admissionReview(admissionv1.Create, sqliteInstance(`{"dbName":"app.db","storage":"lots","replicas":1}`), ""),
+			messages: []string{"spec.storage"},
+		},
+		{
+			name:     "every violated rule",
+			review:   admissionReview(admissionv1.Create, sqliteInstance(`{"dbName":"","storage":"lots","replicas":-1}`), ""),
+			messages: []string{"spec.replicas", "spec.dbName", "spec.storage"},
+		},
+		{
+			name:     "invalid update",
+			review:   admissionReview(admissionv1.Update, sqliteInstance(`{"dbName":"app.db","storage":"1Gi","replicas":-1}`), sqliteInstance(`{"dbName":"app.db","storage":"1Gi","replicas":1}`)),
+			messages: []string{"spec.replicas"},
+		},
+		{
+			name:     "scale to zero",
+			review:   admissionReview(admissionv1.Update, sqliteInstance(`{"dbName":"app.db","storage":"1Gi","replicas":0}`), sqliteInstance(`{"dbName":"app.db","storage":"1Gi","replicas":1}`)),
+			messages: []string{"spec.replicas", "kubelitedb.fortytwoapps.tech/allow-data-loss"},
+		},
+		{
+			name: "scale to zero allowing data loss",
+			review: admissionReview(admissionv1.Update, `{
+				"apiVersion": "kubelitedb.fortytwoapps.tech/v1",
+				"kind": "SQLiteInstance",
+				"metadata": {"name": "test", "namespace": "default", "annotations": {"kubelitedb.fortytwoapps.tech/allow-data-loss": "true"}},
+				"spec": {"dbName":"app.db","storage":"1Gi","replicas":0}
+			}`, sqliteInstance(`{"dbName":"app.db","storage":"1Gi","replicas":1}`)),
+			allowed: true,
+		},
+		{
+			name: "metadata update of an instance admitted before the current rules",
+			review: admissionReview(admissionv1.Update, `{
+				"apiVersion": "kubelitedb.fortytwoapps.tech/v1",
+				"kind": "SQLiteInstance",
+				"metadata": {"name": "test", "namespace": "default", "labels": {"app": "test"}},
+				"spec": {"dbName":"app-wal","storage":"1Gi","replicas":1}
+			}`, sqliteInstance(`{"dbName":"app-wal","storage":"1Gi","replicas":1}`)),
+			allowed: true,
+		},
+		{
+			name: "finalizer removed from a deleted instance",
+			review: admissionReview(admissionv1.Update, `{
+				"apiVersion": "kubelitedb.fortytwoapps.tech/v1",
+				"kind": "SQLiteInstance",
+				"metadata": {"name": "test", "namespace": "default", "deletionTimestamp": "2024-01-01T00:00:00Z"},
+				"spec": {"dbName":"app-wal","storage":"1Gi","replicas":1}
+			}`, `{
+				"apiVersion": "kubelitedb.fortytwoapps.tech/v1",
+				"kind": "SQLiteInstance",
+				"metadata": {"name": "test", "namespace": "default", "deletionTimestamp": "2024-01-01T00:00:00Z", "finalizers": ["kubelitedb.fortytwoapps.tech/cleanup"]},
+				"spec": {"dbName":"app-wal","storage":"1Gi","replicas":1}
+			}`),
+			allowed: true,
+		},
+		{
+			name:     "changed spec of an instance admitted before the current rules",
+			review:   admissionReview(admissionv1.Update, sqliteInstance(`{"dbName":"app-wal","storage":"1Gi","replicas":2}`), sqliteInstance(`{"dbName":"app-wal","storage":"1Gi","replicas":1}`)),
+			messages: []string{"spec.dbName", `must not end with "-wal"`},
+		},
+		{
+			name:     "immutable field changed",
+			review:   admissionReview(admissionv1.Update, sqliteInstance(`{"dbName":"other.db","storage":"1Gi","replicas":1}`), sqliteInstance(`{"dbName":"app.db","storage":"1Gi","replicas":1}`)),
+			messages: []string{"spec.dbName", "is immutable"},
+		},
+		{
+			name:     "storage shrunk",
+			review:   admissionReview(admissionv1.Update, sqliteInstance(`{"dbName":"app.db","storage":"1Gi","replicas":1}`), sqliteInstance(`{"dbName":"app.db","storage":"2Gi","replicas":1}`)),
+			messages: []string{"spec.storage", "cannot be shrunk from 2Gi to 1Gi"},
+		},
+		{
+			name:    "mutable fields changed",
+			review:  admissionReview(admissionv1.Update, sqliteInstance(`{"dbName":"app.db","storage":"2Gi","replicas":1,"readReplicas":2,"resources":{"requests":{"memory":"256Mi"}}}`), sqliteInstance(`{"dbName":"app.db","storage":"1Gi","replicas":1}`)),
+			allowed: true,
+		},
+		{
+			name:    "created with zero replicas",
+			review:  admissionReview(admissionv1.Create, sqliteInstance(`{"dbName":"app.db","storage":"1Gi","replicas":0}`), ""),
+			allowed: true,
+		},
+		{
+			name:     "undecodable object",
+			review:   admissionReview(admissionv1.Create, `{"spec":{"replicas":"one"}}`, ""),
+			messages: []string{"failed to decode SQLiteInstance"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &validator{}
+			response := post(t, serve(v.validate), tt.review)
+
+			if response.Allowed != tt.allowed {
+				t.Fatalf("expected allowed %t, got %t: %+v", tt.allowed, response.Allowed, response.Result)
+			}
+			if tt.allowed {
+				return
+			}
+			if response.Result == nil {
+				t.Fatalf("expected a result explaining the denial")
+			}
+			for _, message := range tt.messages {
+				if !strings.Contains(response.Result.Message, message) {
+					t.Errorf("expected a message containing %q, got %q", message, response.Result.Message)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateDatabaseCollision(t *testing.T) {
+	// sharing returns a SQLiteInstance keeping its database in the directory
+	// of the given name of the shared PVC
+	sharing := func(namespace, name, dir string) *kubelitedbv1.SQLiteInstance {
+		return &kubelitedbv1.SQLiteInstance{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec: kubelitedbv1.SQLiteInstanceSpec{
+				DbName:          dir + ".db",
+				Storage:         "1Gi",
+				Replicas:        1,
+				AccessMode:      corev1.ReadWriteMany,
+				VolumeClaimName: "shared",
+				SubPath:         true,
+			},
+		}
+	}
+	shared := sqliteInstance(`{"dbName":"app.db","storage":"1Gi","replicas":1,"accessMode":"ReadWriteMany","volumeClaimName":"shared","subPath":true}`)
+	tests := []struct {
+		name     string
+		existing []runtime.Object
+		review   string
+		allowed  bool
+		// Substrings of the message of a denied request
+		messages []string
+	}{
+		{
+			name:    "no existing SQLiteInstances",
+			review:  admissionReview(admissionv1.Create, shared, ""),
+			allowed: true,
+		},
+		{
+			name:     "same directory of the shared PVC",
+			existing: []runtime.Object{sharing(metav1.NamespaceDefault, "other", "app")},
+			review:   admissionReview(admissionv1.Create, shared, ""),
+			messages: []string{"spec.dbName", "SQLiteInstance other", "PVC shared"},
+		},
+		{
+			name:     "other directory of the shared PVC",
+			existing: []runtime.Object{sharing(metav1.NamespaceDefault, "other", "orders")},
+			review:   admissionReview(admissionv1.Create, shared, ""),
+			allowed:  true,
+		},
+		{
+			name:     "same directory in another namespace",
+			existing: []runtime.Object{sharing("team-b", "other", "app")},
+			review:   admissionReview(admissionv1.Create, shared, ""),
+			allowed:  true,
+		},
+		{
+			// The database and its volume can't be changed, so an update
+			// can't introduce a collision
+			name:     "update",
+			existing: []runtime.Object{sharing(metav1.NamespaceDefault, "other", "app")},
+			review:   admissionReview(admissionv1.Update, shared, shared),
+			allowed:  true,
+		},
+		{
+			name:     "collision and invalid spec",
+			existing: []runtime.Object{sharing(metav1.NamespaceDefault, "other", "app")},
+			review:   admissionReview(admissionv1.Create, sqliteInstance(`{"dbName":"app.db","storage":"1Gi","replicas":-1,"accessMode":"ReadWriteMany","volumeClaimName":"shared","subPath":true}`), ""),
+			messages: []string{"spec.replicas", "SQLiteInstance other"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			client := fake.NewSimpleClientset(tt.existing...)
+			factory := informers.NewSharedInformerFactory(client, 0)
+			lister := factory.Kubelitedb().V1().SQLiteInstances().Lister()
+			factory.Start(ctx.Done())
+			factory.WaitForCacheSync(ctx.Done())
+
+			v := &validator{sqliteInstances: lister}
+			response := post(t, serve(v.validate), tt.review)
+
+			if response.Allowed != tt.allowed {
+				t.Fatalf("expected allowed %t, got %t: %+v", tt.allowed, response.Allowed, response.Result)
+			}
+			if tt.allowed {
+				return
+			}
+			for _, message := range tt.messages {
+				if !strings.Contains(response.Result.Message, message) {
+					t.Errorf("expected a message containing %q, got %q", message, response.Result.Message)
+				}
+			}
+		})
+	}
+}
+
+// failingLister fails to list SQLiteInstances
+type failingLister struct {
+	listers.SQLiteInstanceLister
+	listers.SQLiteInstanceNamespaceLister
+}
+
+func (l failingLister) List(labels.Selector) ([]*kubelitedbv1.SQLiteInstance, error) {
+	return nil, errors.New("cache not synced")
+}
+
+func (l failingLister) SQLiteInstances(string) listers.SQLiteInstanceNamespaceLister {
+	return l
+}
+
+func TestValidateDatabaseCollisionListError(t *testing.T) {
+	v := &validator{sqliteInstances: failingLister{}}
+	shared := sqliteInstance(`{"dbName":"app.db","storage":"1Gi","replicas":1,"accessMode":"ReadWriteMany","volumeClaimName":"shared","subPath":true}`)
+	response := post(t, serve(v.validate), admissionReview(admissionv1.Create, shared, ""))
+
+	if response.Allowed {
+		t.Fatalf("expected the request to be denied when the SQLiteInstances can't be listed")
+	}
+	if response.Result.Code != http.StatusInternalServerError || !strings.Contains(response.Result.Message, "cache not synced") {
+		t.Errorf("expected an internal error with the list error, got %+v", response.Result)
+	}
+}
+
+// scaleReview returns a raw AdmissionReview of an update of the scale
+// subresource of the SQLiteInstance test from the old to the new replicas.
+func scaleReview(replicas, oldReplicas int) string {
+	scale := `{"apiVersion":"autoscaling/v1","kind":"Scale","metadata":{"name":"test","namespace":"default"},"spec":{"replicas":%d}}`
+	return fmt.Sprintf(`{
+		"apiVersion": "admission.k8s.io/v1",
+		"kind": "AdmissionReview",
+		"request": {
+			"uid": "705ab4f5-6393-11e8-b7cc-42010a800002",
+			"kind": {"group": "autoscaling", "version": "v1", "kind": "Scale"},
+			"resource": {"group": "kubelitedb.fortytwoapps.tech", "version": "v1", "resource": "sqliteinstances"},
+			"subResource": "scale",
+			"name": "test",
+			"namespace": "default",
+			"operation": "UPDATE",
+			"object": %s,
+			"oldObject": %s
+		}
+	}`, fmt.Sprintf(scale, replicas), fmt.Sprintf(scale, oldReplicas))
+}
+
+func TestValidateScale(t *testing.T) {
+	// instance returns the SQLiteInstance test with the given annotations
+	instance := func(annotations map[string]string) *kubelitedbv1.SQLiteInstance {
+		return &kubelitedbv1.SQLiteInstance{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", Annotations: annotations},
+			Spec: kubelitedbv1.SQLiteInstanceSpec{
+				DbName:   "app.db",
+				Storage:  "1Gi",
+				Replicas: 1,
+			},
+		}
+	}
+	tests := []struct {
+		name     string
+		existing []runtime.Object
+		review   string
+		allowed  bool
+		// Substrings of the message of a denied request
+		messages []string
+	}{
+		{
+			name:     "scale to zero",
+			existing: []runtime.Object{instance(nil)},
+			review:   scaleReview(0, 1),
+			messages: []string{"spec.replicas", "kubelitedb.fortytwoapps.tech/allow-data-loss"},
+		},
+		{
+			name:     "scale to zero allowing data loss",
+			existing: []runtime.Object{instance(map[string]string{"kubelitedb.fortytwoapps.tech/allow-data-loss": "true"})},
+			review:   scaleReview(0, 1),
+			allowed:  true,
+		},
+		{
+			name:     "scale up",
+			existing: []runtime.Object{instance(nil)},
+			review:   scaleReview(3, 1),
+			allowed:  true,
+		},
+		{
+			name:     "scale up from zero",
+			existing: []runtime.Object{instance(nil)},
+			review:   scaleReview(1, 0),
+			allowed:  true,
+		},
+		{
+			name:     "negative replicas",
+			existing: []runtime.Object{instance(nil)},
+			review:   scaleReview(-1, 1),
+			messages: []string{"spec.replicas", "must be greater than or equal to 0"},
+		},
+		{
+			name:     "unknown SQLiteInstance",
+			review:   scaleReview(0, 1),
+			messages: []string{"failed to get SQLiteInstance default/test"},
+		},
+		{
+			name:    "replicas unchanged",
+			review:  scaleReview(1, 1),
+			allowed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			client := fake.NewSimpleClientset(tt.existing...)
+			factory := informers.NewSharedInformerFactory(client, 0)
+			lister := factory.Kubelitedb().V1().SQLiteInstances().Lister()
+			factory.Start(ctx.Done())
+			factory.WaitForCacheSync(ctx.Done())
+
+			v := &validator{sqliteInstances: lister}
+			response := post(t, serve(v.validate), tt.review)
+
+			if response.Allowed != tt.allowed {
+				t.Fatalf("expected allowed %t, got %t: %+v", tt.allowed, response.Allowed, response.Result)
+			}
+			if tt.allowed {
+				return
+			}
+			for _, message := range tt.messages {
+				if !strings.Contains(response.Result.Message, message) {
+					t.Errorf("expected a message containing %q, got %q", message, response.Result.Message)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package webhook implements the admission and conversion webhooks for
+// KubeLiteDB resources.
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	listers "github.com/fortytwoapps/kubelitedb/pkg/generated/listers/kubelitedb/v1"
+)
+
+const (
+	// ValidatePath is the path the validating webhook is served on
+	ValidatePath = "/validate-sqliteinstance"
+	// MutatePath is the path the mutating webhook is served on
+	MutatePath = "/mutate-sqliteinstance"
+)
+
+// Config holds the configuration of the webhook Server
+type Config struct {
+	// Addr is the address the server listens on
+	Addr string
+	// CertFile and KeyFile are the certificate and private key served
+	CertFile string
+	KeyFile  string
+	// DefaultStorageClassName is set on SQLiteInstances that do not request a
+	// storage class. No storage class is set when it is empty.
+	DefaultStorageClassName string
+	// SQLiteInstances lists the existing SQLiteInstances new ones are checked
+	// for collisions with, and scaled ones are looked up in. Neither
+	// collisions nor changes made through the scale subresource are checked
+	// when it is nil.
+	SQLiteInstances listers.SQLiteInstanceLister
+}
+
+// admitFunc handles an admission request and returns the response to it
+type admitFunc func(*admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse
+
+// Server serves the admission webhooks over HTTPS
+type Server struct {
+	config Config
+	mux    *http.ServeMux
+}
+
+// NewServer returns a new webhook Server for the given configuration
+func NewServer(config Config) *Server {
+	s := &Server{
+		config: config,
+		mux:    http.NewServeMux(),
+	}
+	m := &mutator{defaultStorageClassName: config.DefaultStorageClassName}
+	v := &validator{sqliteInstances: config.SQLiteInstances}
+	s.mux.HandleFunc(ValidatePath, serve(v.validate))
+	s.mux.HandleFunc(MutatePath, serve(m.mutate))
+	s.mux.HandleFunc(ConvertPath, serveConvert)
+	return s
+}
+
+// Run starts serving admission requests. It will block until the context is
+// cancelled, at which point the server is shut down.
+func (s *Server) Run(ctx context.Context) error {
+	logger := klog.FromContext(ctx)
+
+	server := &http.Server{
+		Addr:              s.config.Addr,
+		Handler:           s.mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.Error(err, "Error shutting down webhook server")
+		}
+	}()
+
+	logger.Info("Starting webhook server", "address", s.config.Addr)
+	if err := server.ListenAndServeTLS(s.config.CertFile, s.config.KeyFile); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// serve returns an http.HandlerFunc decoding the AdmissionReview in the request
+// body, passing it to admit and writing back the AdmissionReview response.
+func serve(admit admitFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to read request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		if contentType := r.Header.Get("Content-Type"); contentType != "application/json" {
+			http.Error(w, fmt.Sprintf("unsupported content type %q, expected application/json", contentType), http.StatusUnsupportedMediaType)
+			return
+		}
+
+		review := admissionv1.AdmissionReview{}
+		if err := json.Unmarshal(body, &review); err != nil {
+			http.Error(w, fmt.Sprintf("failed to decode AdmissionReview: %v", err), http.StatusBadRequest)
+			return
+		}
+		if review.Request == nil {
+			http.Error(w, "AdmissionReview contains no request", http.StatusBadRequest)
+			return
+		}
+
+		response := admit(review.Request)
+		response.UID = review.Request.UID
+		review.Response = response
+		review.Request = nil
+
+		data, err := json.Marshal(review)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to encode AdmissionReview: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(data); err != nil {
+			klog.ErrorS(err, "Failed to write admission response")
+		}
+	}
+}
+
+// allowed returns a response admitting the request
+func allowed() *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{Allowed: true}
+}
+
+// denied returns a response rejecting the request with the given message
+func denied(code int32, message string) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    code,
+			Message: message,
+		},
+	}
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// admissionReview returns the raw AdmissionReview of an operation on a
+// SQLiteInstance. The old object is left out when empty.
+func admissionReview(operation admissionv1.Operation, object, oldObject string) string {
+	old := ""
+	if oldObject != "" {
+		old = fmt.Sprintf(`,"oldObject":%s`, oldObject)
+	}
+	return fmt.Sprintf(`{
+		"apiVersion": "admission.k8s.io/v1",
+		"kind": "AdmissionReview",
+		"request": {
+			"uid": "705ab4f5-6393-11e8-b7cc-42010a800002",
+			"kind": {"group": "kubelitedb.fortytwoapps.tech", "version": "v1", "kind": "SQLiteInstance"},
+			"resource": {"group": "kubelitedb.fortytwoapps.tech", "version": "v1", "resource": "sqliteinstances"},
+			"namespace": "default",
+			"operation": %q,
+			"object": %s%s
+		}
+	}`, operation, object, old)
+}
+
+// sqliteInstance returns the raw SQLiteInstance with the given spec
+func sqliteInstance(spec string) string {
+	return fmt.Sprintf(`{
+		"apiVersion": "kubelitedb.fortytwoapps.tech/v1",
+		"kind": "SQLiteInstance",
+		"metadata": {"name": "test", "namespace": "default"},
+		"spec": %s
+	}`, spec)
+}
+
+// post sends the raw AdmissionReview to the handler and returns the response
+// of the AdmissionReview it replied with.
+func post(t *testing.T, handler http.HandlerFunc, body string) *admissionv1.AdmissionResponse {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body)
+	}
+
+	review := admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &review); err != nil {
+		t.Fatalf("error decoding AdmissionReview: %v", err)
+	}
+	if review.Response == nil {
+		t.Fatalf("expected a response in the AdmissionReview")
+	}
+	if review.Response.UID != "705ab4f5-6393-11e8-b7cc-42010a800002" {
+		t.Errorf("expected the response to carry the UID of the request, got %q", review.Response.UID)
+	}
+	return review.Response
+}
+
+func TestServeRejectsMalformedRequests(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		code        int
+	}{
+		{name: "wrong content type", contentType: "text/plain", body: "{}", code: http.StatusUnsupportedMediaType},
+		{name: "invalid JSON", contentType: "application/json", body: "{", code: http.StatusBadRequest},
+		{name: "no request", contentType: "application/json", body: `{"kind":"AdmissionReview"}`, code: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := serve(func(*admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+				t.Error("unexpected admission of a malformed request")
+				return allowed()
+			})
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			if rec.Code != tt.code {
+				t.Errorf("expected status %d, got %d", tt.code, rec.Code)
+			}
+		})
+	}
+}
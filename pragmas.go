@@ -0,0 +1,190 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+const (
+	// pragmasVolumeName is the name of the volume holding the PRAGMA
+	// statements of the database
+	pragmasVolumeName = "pragmas"
+	// pragmasMountPath is where the PRAGMA statements are mounted in the pods
+	pragmasMountPath = "/etc/kubelitedb"
+	// pragmasKey is the key of the PRAGMA statements in the ConfigMap
+	pragmasKey = "pragmas.sql"
+	// pragmasHashAnnotation records the hash of the PRAGMA statements on the
+	// pod template, so pods are rolled when they change
+	pragmasHashAnnotation = "kubelitedb.fortytwoapps.tech/pragmas-hash"
+)
+
+// pragmasConfigMapName returns the name of the ConfigMap holding the PRAGMA
+// statements of the given SQLiteInstance.
+func pragmasConfigMapName(instance *kubelitedbv1.SQLiteInstance) string {
+	return fmt.Sprintf("%s-pragmas", instance.Name)
+}
+
+// pragmasForInstance returns the pragmas applied to the database of the
+// SQLiteInstance: spec.pragmas, along with the WAL autocheckpoint threshold of
+// spec.wal.
+func pragmasForInstance(instance *kubelitedbv1.SQLiteInstance) map[string]string {
+	pragmas := map[string]string{}
+	for name, value := range instance.Spec.Pragmas {
+		pragmas[name] = value
+	}
+	if wal := instance.Spec.WAL; wal != nil && wal.AutoCheckpoint != nil {
+		pragmas["wal_autocheckpoint"] = strconv.Itoa(int(*wal.AutoCheckpoint))
+	}
+	return pragmas
+}
+
+// renderPragmas renders the pragmas of the SQLiteInstance as SQL statements,
+// sorted by name so the output is stable. The pragmas are validated before
+// they get here, so they can be interpolated as is.
+func renderPragmas(instance *kubelitedbv1.SQLiteInstance) string {
+	pragmas := pragmasForInstance(instance)
+	names := make([]string, 0, len(pragmas))
+	for name := range pragmas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "PRAGMA %s = %s;\n", name, pragmas[name])
+	}
+	return b.String()
+}
+
+// newPragmasConfigMap creates the ConfigMap holding the PRAGMA statements of
+// a SQLiteInstance resource.
+func newPragmasConfigMap(instance *kubelitedbv1.SQLiteInstance) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{
+			Name:        pragmasConfigMapName(instance),
+			Namespace:   instance.Namespace,
+			Labels:      childLabels(instance, labelsForInstance(instance, componentDatabase)),
+			Annotations: childAnnotations(instance),
+			OwnerReferences: []v1.OwnerReference{
+				newOwnerReference(instance),
+			},
+		},
+		Data: map[string]string{
+			pragmasKey: renderPragmas(instance),
+		},
+	}
+}
+
+// syncPragmasConfigMap ensures the PRAGMA statements of the SQLiteInstance
+// exist when pragmas are set, and removes them otherwise.
+func (c *Controller) syncPragmasConfigMap(ctx context.Context, sqliteInstance *kubelitedbv1.SQLiteInstance) error {
+	configMaps := c.kubeclientset.CoreV1().ConfigMaps(sqliteInstance.Namespace)
+	configMap, err := configMaps.Get(ctx, pragmasConfigMapName(sqliteInstance), v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		if len(pragmasForInstance(sqliteInstance)) == 0 {
+			return nil
+		}
+		_, err = configMaps.Create(ctx, newPragmasConfigMap(sqliteInstance), c.createOptions(ctx, sqliteInstance, "ConfigMap", pragmasConfigMapName(sqliteInstance)))
+		logWrite(ctx, "create", "ConfigMap", pragmasConfigMapName(sqliteInstance), err)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if !v1.IsControlledBy(configMap, sqliteInstance) {
+		msg := fmt.Sprintf(MessageResourceExists, configMap.Name)
+		c.recorder.Event(sqliteInstance, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return fmt.Errorf("%s", msg)
+	}
+
+	if len(pragmasForInstance(sqliteInstance)) == 0 {
+		err = configMaps.Delete(ctx, configMap.Name, c.deleteOptions(ctx, sqliteInstance, "ConfigMap", configMap.Name))
+		logWrite(ctx, "delete", "ConfigMap", configMap.Name, err)
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	desired := newPragmasConfigMap(sqliteInstance)
+	if configMap.Data[pragmasKey] != desired.Data[pragmasKey] ||
+		metadataOutOfDate(configMap, desired) {
+		configMapCopy := configMap.DeepCopy()
+		mergeMetadata(configMapCopy, desired)
+		configMapCopy.Data = desired.Data
+		_, err = configMaps.Update(ctx, configMapCopy, c.updateOptions(ctx, sqliteInstance, "ConfigMap", configMap, configMapCopy))
+		logWrite(ctx, "update", "ConfigMap", configMap.Name, err)
+	}
+	return err
+}
+
+// addPragmas applies the pragmas of the SQLiteInstance to the database with
+// an init container before the SQLite container starts. The statements are
+// also mounted into the SQLite container, so connection level pragmas can be
+// applied whenever the database is opened.
+func addPragmas(instance *kubelitedbv1.SQLiteInstance, template *corev1.PodTemplateSpec) {
+	pragmasPath := path.Join(pragmasMountPath, pragmasKey)
+	pragmasMount := corev1.VolumeMount{
+		Name:      pragmasVolumeName,
+		MountPath: pragmasMountPath,
+		ReadOnly:  true,
+	}
+
+	template.Spec.InitContainers = append(template.Spec.InitContainers, corev1.Container{
+		Name:    "pragmas",
+		Image:   imageForInstance(instance),
+		Command: []string{"/bin/sh", "-c", `sqlite3 "$DATABASE_PATH" < "$PRAGMAS_PATH"`},
+		Env: []corev1.EnvVar{
+			{Name: "DATABASE_PATH", Value: databasePath(instance)},
+			{Name: "PRAGMAS_PATH", Value: pragmasPath},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			dataVolumeMount(instance),
+			pragmasMount,
+		},
+	})
+	for i := range template.Spec.Containers {
+		if template.Spec.Containers[i].Name == "sqlite" {
+			template.Spec.Containers[i].VolumeMounts = append(template.Spec.Containers[i].VolumeMounts, pragmasMount)
+		}
+	}
+	template.Spec.Volumes = append(template.Spec.Volumes, corev1.Volume{
+		Name: pragmasVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: pragmasConfigMapName(instance)},
+			},
+		},
+	})
+	if template.Annotations == nil {
+		template.Annotations = map[string]string{}
+	}
+	template.Annotations[pragmasHashAnnotation] = computeHash(renderPragmas(instance))
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/ktesting"
+)
+
+func TestRenderPragmas(t *testing.T) {
+	tests := []struct {
+		name    string
+		pragmas map[string]string
+		want    string
+	}{
+		{name: "none", want: ""},
+		{name: "single", pragmas: map[string]string{"journal_mode": "wal"}, want: "PRAGMA journal_mode = wal;\n"},
+		{
+			name:    "sorted by name",
+			pragmas: map[string]string{"synchronous": "normal", "cache_size": "-2000", "journal_mode": "wal"},
+			want:    "PRAGMA cache_size = -2000;\nPRAGMA journal_mode = wal;\nPRAGMA synchronous = normal;\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newSQLiteInstance("test")
+			instance.Spec.Pragmas = tt.pragmas
+
+			if got := renderPragmas(instance); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSyncPragmasConfigMap(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing map[string]string
+		pragmas  map[string]string
+		verbs    []string
+	}{
+		{name: "no pragmas"},
+		{name: "create", pragmas: map[string]string{"journal_mode": "wal"}, verbs: []string{"create"}},
+		{name: "unchanged", existing: map[string]string{"journal_mode": "wal"}, pragmas: map[string]string{"journal_mode": "wal"}},
+		{name: "update", existing: map[string]string{"journal_mode": "wal"}, pragmas: map[string]string{"journal_mode": "delete"}, verbs: []string{"update"}},
+		{name: "remove", existing: map[string]string{"journal_mode": "wal"}, verbs: []string{"delete"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := newSQLiteInstance("test")
+			instance.Spec.Pragmas = tt.pragmas
+			if tt.existing != nil {
+				existing := newSQLiteInstance("test")
+				existing.Spec.Pragmas = tt.existing
+				f.addKubeObject(newPragmasConfigMap(existing))
+			}
+			c, _, _ := f.newController(ctx)
+
+			if err := c.syncPragmasConfigMap(ctx, instance); err != nil {
+				t.Fatalf("error syncing the pragmas ConfigMap: %v", err)
+			}
+
+			var verbs []string
+			for _, action := range writes(f.kubeclient.Actions(), "configmaps") {
+				verbs = append(verbs, action.GetVerb())
+			}
+			if !slices.Equal(verbs, tt.verbs) {
+				t.Errorf("expected ConfigMap writes %v, got %v", tt.verbs, verbs)
+			}
+			configMap, err := f.kubeclient.CoreV1().ConfigMaps(instance.Namespace).Get(ctx, pragmasConfigMapName(instance), v1.GetOptions{})
+			if tt.pragmas == nil {
+				if !errors.IsNotFound(err) {
+					t.Errorf("expected no pragmas ConfigMap, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error getting the pragmas ConfigMap: %v", err)
+			}
+			if got, want := configMap.Data[pragmasKey], renderPragmas(instance); got != want {
+				t.Errorf("expected the statements %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestPragmasChangeRollsPods(t *testing.T) {
+	instance := newSQLiteInstance("test")
+	none := newStatefulSet(instance)
+	instance.Spec.Pragmas = map[string]string{"journal_mode": "wal"}
+	wal := newStatefulSet(instance)
+	instance.Spec.Pragmas = map[string]string{"journal_mode": "delete"}
+	rollback := newStatefulSet(instance)
+
+	if hasInitContainer(none.Spec.Template, "pragmas") {
+		t.Errorf("expected no pragmas init container without pragmas")
+	}
+	if !hasInitContainer(wal.Spec.Template, "pragmas") {
+		t.Errorf("expected a pragmas init container")
+	}
+	if wal.Spec.Template.Annotations[pragmasHashAnnotation] == rollback.Spec.Template.Annotations[pragmasHashAnnotation] {
+		t.Errorf("expected the pragmas hash to change with the pragmas")
+	}
+	if wal.Spec.Template.Annotations[templateHashAnnotation] == rollback.Spec.Template.Annotations[templateHashAnnotation] {
+		t.Errorf("expected the template hash to change with the pragmas")
+	}
+}
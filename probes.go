@@ -0,0 +1,90 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// Default probe timings. The startup probe allows five minutes for the
+// database to become available, which covers recovering a large WAL.
+var (
+	defaultStartupProbe = kubelitedbv1.ProbeTimings{
+		PeriodSeconds:    10,
+		TimeoutSeconds:   5,
+		FailureThreshold: 30,
+	}
+	defaultLivenessProbe = kubelitedbv1.ProbeTimings{
+		PeriodSeconds:    20,
+		TimeoutSeconds:   5,
+		FailureThreshold: 6,
+	}
+	defaultReadinessProbe = kubelitedbv1.ProbeTimings{
+		PeriodSeconds:    10,
+		TimeoutSeconds:   5,
+		FailureThreshold: 3,
+	}
+)
+
+// newProbe creates a probe running the SQL statement against the database of
+// the SQLiteInstance, with the default timings overridden by the non-zero
+// timings of the spec.
+func newProbe(instance *kubelitedbv1.SQLiteInstance, statement string, defaults kubelitedbv1.ProbeTimings, overrides *kubelitedbv1.ProbeTimings) *corev1.Probe {
+	timings := defaults
+	if overrides != nil {
+		if overrides.InitialDelaySeconds != 0 {
+			timings.InitialDelaySeconds = overrides.InitialDelaySeconds
+		}
+		if overrides.PeriodSeconds != 0 {
+			timings.PeriodSeconds = overrides.PeriodSeconds
+		}
+		if overrides.TimeoutSeconds != 0 {
+			timings.TimeoutSeconds = overrides.TimeoutSeconds
+		}
+		if overrides.FailureThreshold != 0 {
+			timings.FailureThreshold = overrides.FailureThreshold
+		}
+	}
+
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			Exec: &corev1.ExecAction{
+				Command: []string{"sqlite3", databasePath(instance), statement},
+			},
+		},
+		InitialDelaySeconds: timings.InitialDelaySeconds,
+		PeriodSeconds:       timings.PeriodSeconds,
+		TimeoutSeconds:      timings.TimeoutSeconds,
+		FailureThreshold:    timings.FailureThreshold,
+	}
+}
+
+// addProbes adds the startup, liveness and readiness probes to the SQLite
+// container. Readiness is a trivial query, so pods only receive traffic from
+// the Services once the database can be queried. Liveness reads the schema
+// version, which fails when the database can no longer be opened.
+func addProbes(instance *kubelitedbv1.SQLiteInstance, container *corev1.Container) {
+	var probes kubelitedbv1.ProbesSpec
+	if instance.Spec.Probes != nil {
+		probes = *instance.Spec.Probes
+	}
+	container.StartupProbe = newProbe(instance, "SELECT 1;", defaultStartupProbe, probes.Startup)
+	container.LivenessProbe = newProbe(instance, "PRAGMA schema_version;", defaultLivenessProbe, probes.Liveness)
+	container.ReadinessProbe = newProbe(instance, "SELECT 1;", defaultReadinessProbe, probes.Readiness)
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"slices"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+func TestProbes(t *testing.T) {
+	tests := []struct {
+		name      string
+		probes    *kubelitedbv1.ProbesSpec
+		startup   kubelitedbv1.ProbeTimings
+		liveness  kubelitedbv1.ProbeTimings
+		readiness kubelitedbv1.ProbeTimings
+	}{
+		{
+			name:      "defaults",
+			startup:   defaultStartupProbe,
+			liveness:  defaultLivenessProbe,
+			readiness: defaultReadinessProbe,
+		},
+		{
+			name: "overrides",
+			probes: &kubelitedbv1.ProbesSpec{
+				Startup:   &kubelitedbv1.ProbeTimings{FailureThreshold: 120},
+				Liveness:  &kubelitedbv1.ProbeTimings{InitialDelaySeconds: 15, PeriodSeconds: 60},
+				Readiness: &kubelitedbv1.ProbeTimings{TimeoutSeconds: 1},
+			},
+			startup:   kubelitedbv1.ProbeTimings{PeriodSeconds: 10, TimeoutSeconds: 5, FailureThreshold: 120},
+			liveness:  kubelitedbv1.ProbeTimings{InitialDelaySeconds: 15, PeriodSeconds: 60, TimeoutSeconds: 5, FailureThreshold: 6},
+			readiness: kubelitedbv1.ProbeTimings{PeriodSeconds: 10, TimeoutSeconds: 1, FailureThreshold: 3},
+		},
+		{
+			name:      "empty overrides",
+			probes:    &kubelitedbv1.ProbesSpec{Readiness: &kubelitedbv1.ProbeTimings{}},
+			startup:   defaultStartupProbe,
+			liveness:  defaultLivenessProbe,
+			readiness: defaultReadinessProbe,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newSQLiteInstance("test")
+			instance.Spec.Probes = tt.probes
+
+			writer := container(t, newStatefulSet(instance).Spec.Template.Spec.Containers, "sqlite")
+			instance.Spec.ReadReplicas = 1
+			reader := container(t, newReaderDeployment(instance).Spec.Template.Spec.Containers, "sqlite")
+
+			for _, c := range []*corev1.Container{writer, reader} {
+				assertProbe(t, "startup", instance, c.StartupProbe, "SELECT 1;", tt.startup)
+				assertProbe(t, "liveness", instance, c.LivenessProbe, "PRAGMA schema_version;", tt.liveness)
+				assertProbe(t, "readiness", instance, c.ReadinessProbe, "SELECT 1;", tt.readiness)
+			}
+		})
+	}
+}
+
+// assertProbe fails the test unless the probe runs the statement against the
+// database of the SQLiteInstance with the given timings.
+func assertProbe(t *testing.T, name string, instance *kubelitedbv1.SQLiteInstance, probe *corev1.Probe, statement string, timings kubelitedbv1.ProbeTimings) {
+	t.Helper()
+	if probe == nil || probe.Exec == nil {
+		t.Errorf("expected a %s exec probe, got %v", name, probe)
+		return
+	}
+	if want := []string{"sqlite3", databasePath(instance), statement}; !slices.Equal(probe.Exec.Command, want) {
+		t.Errorf("expected the %s probe to run %v, got %v", name, want, probe.Exec.Command)
+	}
+	got := kubelitedbv1.ProbeTimings{
+		InitialDelaySeconds: probe.InitialDelaySeconds,
+		PeriodSeconds:       probe.PeriodSeconds,
+		TimeoutSeconds:      probe.TimeoutSeconds,
+		FailureThreshold:    probe.FailureThreshold,
+	}
+	if got != timings {
+		t.Errorf("expected the %s probe timings %+v, got %+v", name, timings, got)
+	}
+}
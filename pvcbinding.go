@@ -0,0 +1,81 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// volumeBound returns whether the pods mounting the given PVC can be created.
+// Pods of an unbound PVC stay pending, unless its storage class only binds
+// volumes once a pod using them is scheduled.
+func (c *Controller) volumeBound(ctx context.Context, pvc *corev1.PersistentVolumeClaim) (bool, error) {
+	if pvc.Status.Phase == corev1.ClaimBound {
+		return true, nil
+	}
+	if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+		return false, nil
+	}
+	storageClass, err := c.kubeclientset.StorageV1().StorageClasses().Get(ctx, *pvc.Spec.StorageClassName, v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return storageClass.VolumeBindingMode != nil && *storageClass.VolumeBindingMode == storagev1.VolumeBindingWaitForFirstConsumer, nil
+}
+
+// waitForSharedVolume returns whether the StatefulSet of the SQLiteInstance
+// has to wait for its shared volume to be bound before it is created, and
+// reports the wait in the status. Volumes per pod are bound as the pods are
+// created from the volume claim template, and an existing StatefulSet is
+// never held back, so only the first rollout waits.
+func (c *Controller) waitForSharedVolume(ctx context.Context, instance *kubelitedbv1.SQLiteInstance, status *kubelitedbv1.SQLiteInstanceStatus, pvc *corev1.PersistentVolumeClaim) (bool, error) {
+	if pvc == nil {
+		return false, nil
+	}
+	_, err := c.statefulSetsLister.StatefulSets(instance.Namespace).Get(statefulSetName(instance))
+	if err == nil {
+		return false, nil
+	}
+	if !errors.IsNotFound(err) {
+		return false, err
+	}
+
+	bound, err := c.volumeBound(ctx, pvc)
+	if err != nil || bound {
+		return false, err
+	}
+	phase := pvc.Status.Phase
+	if phase == "" {
+		phase = corev1.ClaimPending
+	}
+	msg := fmt.Sprintf(MessageVolumeNotBound, pvc.Name, phase)
+	setCondition(status, instance, kubelitedbv1.ConditionStorageProvisioned, v1.ConditionFalse, ReasonVolumeNotBound, msg)
+	setCondition(status, instance, kubelitedbv1.ConditionProgressing, v1.ConditionTrue, ReasonVolumeNotBound, msg)
+	setCondition(status, instance, kubelitedbv1.ConditionReady, v1.ConditionFalse, ReasonVolumeNotBound, msg)
+	return true, nil
+}
@@ -0,0 +1,218 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// newBindingStorageClass returns a storage class binding its volumes in the
+// given mode.
+func newBindingStorageClass(name string, mode storagev1.VolumeBindingMode) *storagev1.StorageClass {
+	storageClass := newStorageClass(name, false)
+	storageClass.VolumeBindingMode = &mode
+	return storageClass
+}
+
+func TestVolumeBound(t *testing.T) {
+	immediate, waiting, missing := "immediate", "waiting", "missing"
+	tests := []struct {
+		name             string
+		phase            corev1.PersistentVolumeClaimPhase
+		storageClassName *string
+		want             bool
+	}{
+		{name: "bound", phase: corev1.ClaimBound, want: true},
+		{name: "pending", phase: corev1.ClaimPending},
+		{name: "no phase yet"},
+		{name: "lost", phase: corev1.ClaimLost},
+		{name: "immediate binding", phase: corev1.ClaimPending, storageClassName: &immediate},
+		// The volume is only bound once the first pod is scheduled
+		{name: "binding on first consumer", phase: corev1.ClaimPending, storageClassName: &waiting, want: true},
+		{name: "missing storage class", phase: corev1.ClaimPending, storageClassName: &missing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			f.addKubeObject(newBindingStorageClass(immediate, storagev1.VolumeBindingImmediate))
+			f.addKubeObject(newBindingStorageClass(waiting, storagev1.VolumeBindingWaitForFirstConsumer))
+			c, _, _ := f.newController(ctx)
+			pvc := newDataPVC(newSharedSQLiteInstance("test"))
+			pvc.Spec.StorageClassName = tt.storageClassName
+			pvc.Status.Phase = tt.phase
+
+			got, err := c.volumeBound(ctx, pvc)
+			if err != nil {
+				t.Fatalf("error checking the PVC: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected bound %t, got %t", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestWaitForSharedVolume(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSharedSQLiteInstance("test")
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+
+	// The PVC is created first, and the StatefulSet waits for it to be bound
+	result := f.run(ctx, c, getKey(instance, t))
+
+	if actions := writes(f.kubeclient.Actions(), "persistentvolumeclaims"); len(actions) != 1 || actions[0].GetVerb() != "create" {
+		t.Fatalf("expected the PVC to be created, got %v", actions)
+	}
+	if actions := writes(f.kubeclient.Actions(), "statefulsets"); len(actions) != 0 {
+		t.Fatalf("expected no StatefulSet while the PVC is pending, got %v", actions)
+	}
+	if result.RequeueAfter != pendingRequeueAfter {
+		t.Errorf("expected to check the PVC again in %s, got %s", pendingRequeueAfter, result.RequeueAfter)
+	}
+	got := f.getInstance(ctx, instance)
+	if got.Status.Phase != kubelitedbv1.PhaseProvisioning {
+		t.Errorf("expected phase %s, got %s", kubelitedbv1.PhaseProvisioning, got.Status.Phase)
+	}
+	for _, conditionType := range []string{kubelitedbv1.ConditionStorageProvisioned, kubelitedbv1.ConditionReady} {
+		condition := meta.FindStatusCondition(got.Status.Conditions, conditionType)
+		if condition == nil || condition.Status != v1.ConditionFalse || condition.Reason != ReasonVolumeNotBound {
+			t.Errorf("expected condition %s False with reason %s, got %v", conditionType, ReasonVolumeNotBound, condition)
+		}
+	}
+	if condition := meta.FindStatusCondition(got.Status.Conditions, kubelitedbv1.ConditionProgressing); condition == nil || condition.Status != v1.ConditionTrue {
+		t.Errorf("expected the SQLiteInstance to be progressing, got %v", condition)
+	}
+
+	// Still pending
+	f.refreshCaches(ctx)
+	f.kubeclient.ClearActions()
+	f.run(ctx, c, getKey(instance, t))
+	if actions := writes(f.kubeclient.Actions(), "statefulsets"); len(actions) != 0 {
+		t.Fatalf("expected no StatefulSet while the PVC is pending, got %v", actions)
+	}
+
+	// Once bound the StatefulSet is created
+	pvc, err := f.kubeclient.CoreV1().PersistentVolumeClaims(instance.Namespace).Get(ctx, dataPVCName(instance, 0), v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("error getting the PVC: %v", err)
+	}
+	pvc.Status.Phase = corev1.ClaimBound
+	if _, err := f.kubeclient.CoreV1().PersistentVolumeClaims(instance.Namespace).UpdateStatus(ctx, pvc, v1.UpdateOptions{}); err != nil {
+		t.Fatalf("error binding the PVC: %v", err)
+	}
+	f.refreshCaches(ctx)
+	f.kubeclient.ClearActions()
+	f.run(ctx, c, getKey(instance, t))
+
+	if actions := writes(f.kubeclient.Actions(), "statefulsets"); len(actions) != 1 || actions[0].GetVerb() != "create" {
+		t.Fatalf("expected the StatefulSet to be created once the PVC is bound, got %v", actions)
+	}
+	if condition := meta.FindStatusCondition(f.getInstance(ctx, instance).Status.Conditions, kubelitedbv1.ConditionStorageProvisioned); condition != nil && condition.Reason == ReasonVolumeNotBound {
+		t.Errorf("expected the volume to no longer be reported unbound, got %v", condition)
+	}
+}
+
+func TestWaitForSharedVolumeSkipped(t *testing.T) {
+	waiting := "waiting"
+	tests := []struct {
+		name     string
+		instance *kubelitedbv1.SQLiteInstance
+		// objects are added to the informer caches and the fake clientset
+		objects []func(instance *kubelitedbv1.SQLiteInstance) runtime.Object
+		// wantStatefulSet is whether the StatefulSet is created or updated
+		wantStatefulSet bool
+	}{
+		{
+			// Volumes per pod are bound as the pods are created
+			name:            "volume per pod",
+			instance:        newSQLiteInstance("test"),
+			wantStatefulSet: true,
+		},
+		{
+			name:     "existing StatefulSet",
+			instance: newSharedSQLiteInstance("test"),
+			objects: []func(instance *kubelitedbv1.SQLiteInstance) runtime.Object{
+				func(instance *kubelitedbv1.SQLiteInstance) runtime.Object { return newDataPVC(instance) },
+				func(instance *kubelitedbv1.SQLiteInstance) runtime.Object {
+					statefulSet := newStatefulSet(instance)
+					statefulSet.Spec.Template.Annotations[templateHashAnnotation] = "outdated"
+					return statefulSet
+				},
+			},
+			wantStatefulSet: true,
+		},
+		{
+			name: "storage class binding on first consumer",
+			instance: func() *kubelitedbv1.SQLiteInstance {
+				instance := newSharedSQLiteInstance("test")
+				instance.Spec.StorageClassName = &waiting
+				return instance
+			}(),
+			objects: []func(instance *kubelitedbv1.SQLiteInstance) runtime.Object{
+				func(*kubelitedbv1.SQLiteInstance) runtime.Object {
+					return newBindingStorageClass(waiting, storagev1.VolumeBindingWaitForFirstConsumer)
+				},
+			},
+			wantStatefulSet: true,
+		},
+		{
+			name:     "existing PVC from spec.volumeClaimName",
+			instance: newSubPathSQLiteInstance("test", "app.db", 0),
+			objects: []func(instance *kubelitedbv1.SQLiteInstance) runtime.Object{
+				func(*kubelitedbv1.SQLiteInstance) runtime.Object {
+					return &corev1.PersistentVolumeClaim{
+						ObjectMeta: v1.ObjectMeta{Name: "shared", Namespace: v1.NamespaceDefault},
+						Status:     corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimPending},
+					}
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			f.addInstance(tt.instance)
+			for _, object := range tt.objects {
+				f.addKubeObject(object(tt.instance))
+			}
+			c, _, _ := f.newController(ctx)
+
+			f.run(ctx, c, getKey(tt.instance, t))
+
+			statefulSets := writes(f.kubeclient.Actions(), "statefulsets")
+			if tt.wantStatefulSet && len(statefulSets) == 0 {
+				t.Errorf("expected the StatefulSet not to wait for the volume, got %v", f.kubeclient.Actions())
+			}
+			if !tt.wantStatefulSet && len(statefulSets) != 0 {
+				t.Errorf("expected the StatefulSet to wait for the volume, got %v", statefulSets)
+			}
+		})
+	}
+}
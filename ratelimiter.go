@@ -0,0 +1,59 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// RateLimiterOptions configure how often failed SQLiteInstances are
+// requeued. The defaults match workqueue.DefaultControllerRateLimiter.
+type RateLimiterOptions struct {
+	// BaseDelay is the delay before the first retry of a failed item, doubled
+	// on every further failure
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between retries of a failed item
+	MaxDelay time.Duration
+	// QPS is the overall rate at which items are requeued
+	QPS float64
+	// Burst is the number of items that can be requeued at once above QPS
+	Burst int
+}
+
+// DefaultRateLimiterOptions returns the options of
+// workqueue.DefaultControllerRateLimiter.
+func DefaultRateLimiterOptions() RateLimiterOptions {
+	return RateLimiterOptions{
+		BaseDelay: 5 * time.Millisecond,
+		MaxDelay:  1000 * time.Second,
+		QPS:       10,
+		Burst:     100,
+	}
+}
+
+// newRateLimiter builds the rate limiter of the workqueue from the options.
+// As with the default rate limiter, the slower of the per-item exponential
+// backoff and the overall token bucket applies.
+func newRateLimiter(opts RateLimiterOptions) workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(opts.BaseDelay, opts.MaxDelay),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(opts.QPS), opts.Burst)},
+	)
+}
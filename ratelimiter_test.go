@@ -0,0 +1,91 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+func TestRateLimiterBackoff(t *testing.T) {
+	tests := []struct {
+		name string
+		opts RateLimiterOptions
+		want []time.Duration
+	}{
+		{
+			name: "defaults",
+			opts: DefaultRateLimiterOptions(),
+			want: []time.Duration{5 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond},
+		},
+		{
+			name: "tuned",
+			opts: RateLimiterOptions{BaseDelay: time.Second, MaxDelay: 5 * time.Second, QPS: 100, Burst: 1000},
+			want: []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := newRateLimiter(tt.opts)
+
+			for i, want := range tt.want {
+				if got := limiter.When("default/test"); got != want {
+					t.Errorf("failure %d: expected a delay of %v, got %v", i+1, want, got)
+				}
+			}
+			if got := limiter.NumRequeues("default/test"); got != len(tt.want) {
+				t.Errorf("expected %d requeues, got %d", len(tt.want), got)
+			}
+			limiter.Forget("default/test")
+			if got := limiter.When("default/test"); got != tt.opts.BaseDelay {
+				t.Errorf("expected the base delay after forgetting, got %v", got)
+			}
+		})
+	}
+}
+
+func TestRateLimiterMatchesDefault(t *testing.T) {
+	limiter := newRateLimiter(DefaultRateLimiterOptions())
+	defaultLimiter := workqueue.DefaultControllerRateLimiter()
+
+	for i := 0; i < 20; i++ {
+		if got, want := limiter.When("default/test"), defaultLimiter.When("default/test"); got != want {
+			t.Errorf("failure %d: expected a delay of %v, got %v", i+1, want, got)
+		}
+	}
+}
+
+func TestRateLimiterBucket(t *testing.T) {
+	limiter := newRateLimiter(RateLimiterOptions{BaseDelay: time.Millisecond, MaxDelay: time.Second, QPS: 1, Burst: 2})
+
+	var delays []time.Duration
+	for i := 0; i < 3; i++ {
+		delays = append(delays, limiter.When(fmt.Sprintf("default/test-%d", i)))
+	}
+
+	for i, delay := range delays[:2] {
+		if delay != time.Millisecond {
+			t.Errorf("item %d: expected the burst to only apply the base delay, got %v", i, delay)
+		}
+	}
+	if delays[2] < 900*time.Millisecond {
+		t.Errorf("expected the item beyond the burst to wait for the bucket, got %v", delays[2])
+	}
+}
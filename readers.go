@@ -0,0 +1,318 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+const (
+	// roleLabel tells the writer pods of a SQLiteInstance from its read-only
+	// pods
+	roleLabel = "kubelitedb.fortytwoapps.tech/role"
+	// roleWriter is the role of the pods of the StatefulSet
+	roleWriter = "writer"
+	// roleReader is the role of the read-only pods
+	roleReader = "reader"
+)
+
+// readerDeploymentName returns the name of the Deployment running the
+// read-only pods of the given SQLiteInstance.
+func readerDeploymentName(instance *kubelitedbv1.SQLiteInstance) string {
+	return fmt.Sprintf("%s-sqlite-read", instance.Name)
+}
+
+// writeServiceName returns the name of the Service resolving to the writer
+// pod of the given SQLiteInstance.
+func writeServiceName(instance *kubelitedbv1.SQLiteInstance) string {
+	return fmt.Sprintf("%s-sqlite-write", instance.Name)
+}
+
+// readServiceName returns the name of the Service resolving to the read-only
+// pods of the given SQLiteInstance.
+func readServiceName(instance *kubelitedbv1.SQLiteInstance) string {
+	return fmt.Sprintf("%s-sqlite-read", instance.Name)
+}
+
+// readerLabels returns the labels set on the read-only pods of the given
+// SQLiteInstance. They must not match podLabels, or the read-only pods would
+// be selected together with the pods of the StatefulSet.
+func readerLabels(instance *kubelitedbv1.SQLiteInstance) map[string]string {
+	return map[string]string{
+		"app":        "sqlite-reader",
+		"controller": instance.Name,
+		roleLabel:    roleReader,
+	}
+}
+
+// writerSelector selects the first pod of the StatefulSet, which is the only
+// writer of the database the read-only pods open.
+func writerSelector(instance *kubelitedbv1.SQLiteInstance) map[string]string {
+	selector := podLabels(instance)
+	selector[roleLabel] = roleWriter
+	selector["statefulset.kubernetes.io/pod-name"] = fmt.Sprintf("%s-0", statefulSetName(instance))
+	return selector
+}
+
+// newReaderDeployment creates the Deployment running the read-only pods of a
+// SQLiteInstance. The pods open the database on the volume of the writer pod,
+// so they are scheduled onto its node as the volume may only be mounted from
+// a single node.
+func newReaderDeployment(instance *kubelitedbv1.SQLiteInstance) *appsv1.Deployment {
+	// The selector can't be changed once the Deployment exists, so it only
+	// matches the original pod labels.
+	selector := readerLabels(instance)
+	labels := labelsForInstance(instance, componentReader)
+	replicas := int32(instance.Spec.ReadReplicas)
+	if instance.Spec.Suspend {
+		replicas = 0
+	}
+
+	// A ReadWriteOnce volume may only be mounted from a single node, so the
+	// read-only pods run next to the first writer pod. A shared volume can be
+	// mounted from anywhere.
+	affinity := instance.Spec.Affinity
+	if !sharesVolume(instance) {
+		affinity = &corev1.Affinity{}
+		if instance.Spec.Affinity != nil {
+			affinity = instance.Spec.Affinity.DeepCopy()
+		}
+		if affinity.PodAffinity == nil {
+			affinity.PodAffinity = &corev1.PodAffinity{}
+		}
+		affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution = append(affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution, corev1.PodAffinityTerm{
+			LabelSelector: &v1.LabelSelector{
+				MatchLabels: map[string]string{
+					"statefulset.kubernetes.io/pod-name": fmt.Sprintf("%s-0", statefulSetName(instance)),
+				},
+			},
+			TopologyKey: corev1.LabelHostname,
+		})
+	}
+
+	template := corev1.PodTemplateSpec{
+		ObjectMeta: v1.ObjectMeta{
+			Labels:      childLabels(instance, labels),
+			Annotations: childAnnotations(instance),
+		},
+		Spec: corev1.PodSpec{
+			NodeSelector:       nodeSelectorForInstance(instance),
+			Affinity:           affinity,
+			Tolerations:        instance.Spec.Tolerations,
+			PriorityClassName:  instance.Spec.PriorityClassName,
+			ImagePullSecrets:   instance.Spec.ImagePullSecrets,
+			ServiceAccountName: instance.Spec.ServiceAccountName,
+			Containers: []corev1.Container{
+				{
+					Name:      "sqlite",
+					Image:     imageForInstance(instance),
+					Command:   instance.Spec.Command,
+					Args:      instance.Spec.Args,
+					Resources: resourcesForInstance(instance),
+					Env: envForInstance(instance, []corev1.EnvVar{
+						{Name: "DATABASE_PATH", Value: databasePath(instance)},
+						{Name: "DATABASE_READ_ONLY", Value: "true"},
+					}),
+					EnvFrom: instance.Spec.EnvFrom,
+					VolumeMounts: []corev1.VolumeMount{
+						dataVolumeMount(instance),
+					},
+				},
+			},
+			Volumes: []corev1.Volume{
+				{
+					Name: dataVolumeName,
+					VolumeSource: corev1.VolumeSource{
+						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+							ClaimName: dataPVCName(instance, 0),
+						},
+					},
+				},
+			},
+		},
+	}
+	addProbes(instance, &template.Spec.Containers[0])
+	if instance.Spec.TempDir != nil {
+		addTempDir(instance, &template)
+	}
+	if instance.Spec.TLS != nil {
+		addTLSProxySidecar(instance, &template)
+	}
+	setSecurityContexts(instance, &template.Spec)
+	if template.Annotations == nil {
+		template.Annotations = map[string]string{}
+	}
+	template.Annotations[templateHashAnnotation] = computeHash(template)
+
+	return &appsv1.Deployment{
+		ObjectMeta: v1.ObjectMeta{
+			Name:        readerDeploymentName(instance),
+			Namespace:   instance.Namespace,
+			Labels:      childLabels(instance, labels),
+			Annotations: childAnnotations(instance),
+			OwnerReferences: []v1.OwnerReference{
+				newOwnerReference(instance),
+			},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &v1.LabelSelector{
+				MatchLabels: selector,
+			},
+			Template: template,
+		},
+	}
+}
+
+// newRoleService creates a headless Service resolving to the pods of a
+// SQLiteInstance matching the selector.
+func newRoleService(instance *kubelitedbv1.SQLiteInstance, name string, selector map[string]string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: v1.ObjectMeta{
+			Name:        name,
+			Namespace:   instance.Namespace,
+			Labels:      childLabels(instance, labelsForInstance(instance, componentDatabase)),
+			Annotations: childAnnotations(instance),
+			OwnerReferences: []v1.OwnerReference{
+				newOwnerReference(instance),
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: corev1.ClusterIPNone,
+			Selector:  selector,
+			Ports:     servicePorts(instance),
+		},
+	}
+}
+
+// syncReaders runs the read-only pods of the SQLiteInstance together with the
+// write and read Services, and records the number of ready read-only pods in
+// the status. Everything is removed when no read-only pods are requested.
+func (c *Controller) syncReaders(ctx context.Context, sqliteInstance *kubelitedbv1.SQLiteInstance, status *kubelitedbv1.SQLiteInstanceStatus, tlsHash string) error {
+	wanted := sqliteInstance.Spec.ReadReplicas > 0
+
+	if err := c.syncRoleService(ctx, sqliteInstance, newRoleService(sqliteInstance, writeServiceName(sqliteInstance), writerSelector(sqliteInstance)), wanted); err != nil {
+		return err
+	}
+	if err := c.syncRoleService(ctx, sqliteInstance, newRoleService(sqliteInstance, readServiceName(sqliteInstance), readerLabels(sqliteInstance)), wanted); err != nil {
+		return err
+	}
+
+	status.ReaderReadyReplicas = 0
+	deployments := c.kubeclientset.AppsV1().Deployments(sqliteInstance.Namespace)
+	deployment, err := deployments.Get(ctx, readerDeploymentName(sqliteInstance), v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		if !wanted {
+			return nil
+		}
+		desired := newReaderDeployment(sqliteInstance)
+		setTLSSecretHash(&desired.Spec.Template, tlsHash)
+		_, err = deployments.Create(ctx, desired, c.createOptions(ctx, sqliteInstance, "Deployment", readerDeploymentName(sqliteInstance)))
+		logWrite(ctx, "create", "Deployment", readerDeploymentName(sqliteInstance), err)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if !v1.IsControlledBy(deployment, sqliteInstance) {
+		msg := fmt.Sprintf(MessageResourceExists, deployment.Name)
+		c.recorder.Event(sqliteInstance, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return fmt.Errorf("%s", msg)
+	}
+
+	if !wanted {
+		err = deployments.Delete(ctx, deployment.Name, c.deleteOptions(ctx, sqliteInstance, "Deployment", deployment.Name))
+		logWrite(ctx, "delete", "Deployment", deployment.Name, err)
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	desired := newReaderDeployment(sqliteInstance)
+	setTLSSecretHash(&desired.Spec.Template, tlsHash)
+	if *deployment.Spec.Replicas != *desired.Spec.Replicas ||
+		deployment.Spec.Template.Annotations[templateHashAnnotation] != desired.Spec.Template.Annotations[templateHashAnnotation] ||
+		metadataOutOfDate(deployment, desired) {
+		deploymentCopy := deployment.DeepCopy()
+		mergeMetadata(deploymentCopy, desired)
+		deploymentCopy.Spec.Replicas = desired.Spec.Replicas
+		deploymentCopy.Spec.Template = desired.Spec.Template
+		_, err = deployments.Update(ctx, deploymentCopy, c.updateOptions(ctx, sqliteInstance, "Deployment", deployment, deploymentCopy))
+		logWrite(ctx, "update", "Deployment", deployment.Name, err)
+		if err != nil {
+			return err
+		}
+	}
+	status.ReaderReadyReplicas = deployment.Status.ReadyReplicas
+	return nil
+}
+
+// syncRoleService creates or updates the given Service when wanted, and deletes
+// it otherwise.
+func (c *Controller) syncRoleService(ctx context.Context, sqliteInstance *kubelitedbv1.SQLiteInstance, desired *corev1.Service, wanted bool) error {
+	services := c.kubeclientset.CoreV1().Services(sqliteInstance.Namespace)
+	service, err := services.Get(ctx, desired.Name, v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		if !wanted {
+			return nil
+		}
+		_, err = services.Create(ctx, desired, c.createOptions(ctx, sqliteInstance, "Service", desired.Name))
+		logWrite(ctx, "create", "Service", desired.Name, err)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if !v1.IsControlledBy(service, sqliteInstance) {
+		msg := fmt.Sprintf(MessageResourceExists, service.Name)
+		c.recorder.Event(sqliteInstance, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return fmt.Errorf("%s", msg)
+	}
+
+	if !wanted {
+		err = services.Delete(ctx, service.Name, c.deleteOptions(ctx, sqliteInstance, "Service", service.Name))
+		logWrite(ctx, "delete", "Service", service.Name, err)
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if !equality.Semantic.DeepEqual(service.Spec.Selector, desired.Spec.Selector) ||
+		!equality.Semantic.DeepEqual(service.Spec.Ports, desired.Spec.Ports) ||
+		metadataOutOfDate(service, desired) {
+		serviceCopy := service.DeepCopy()
+		mergeMetadata(serviceCopy, desired)
+		serviceCopy.Spec.Selector = desired.Spec.Selector
+		serviceCopy.Spec.Ports = desired.Spec.Ports
+		_, err = services.Update(ctx, serviceCopy, c.updateOptions(ctx, sqliteInstance, "Service", service, serviceCopy))
+		logWrite(ctx, "update", "Service", service.Name, err)
+	}
+	return err
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+func TestRoleLabels(t *testing.T) {
+	instance := newSQLiteInstance("test")
+	instance.Spec.ReadReplicas = 2
+
+	// The StatefulSet controller adds the pod name label to the writer pods
+	writer := labels.Set(newStatefulSet(instance).Spec.Template.Labels)
+	writer["statefulset.kubernetes.io/pod-name"] = fmt.Sprintf("%s-0", statefulSetName(instance))
+	reader := labels.Set(newReaderDeployment(instance).Spec.Template.Labels)
+
+	if writer[roleLabel] != roleWriter {
+		t.Errorf("expected the writer pods to have the %s role, got %q", roleWriter, writer[roleLabel])
+	}
+	if reader[roleLabel] != roleReader {
+		t.Errorf("expected the read-only pods to have the %s role, got %q", roleReader, reader[roleLabel])
+	}
+
+	tests := []struct {
+		name        string
+		selector    map[string]string
+		writer      bool
+		readOnlyPod bool
+	}{
+		{name: "headless Service", selector: podLabels(instance), writer: true},
+		{name: "write Service", selector: newRoleService(instance, writeServiceName(instance), writerSelector(instance)).Spec.Selector, writer: true},
+		{name: "read Service", selector: newRoleService(instance, readServiceName(instance), readerLabels(instance)).Spec.Selector, readOnlyPod: true},
+		{name: "StatefulSet", selector: newStatefulSet(instance).Spec.Selector.MatchLabels, writer: true},
+		{name: "Deployment", selector: newReaderDeployment(instance).Spec.Selector.MatchLabels, readOnlyPod: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector := labels.SelectorFromSet(tt.selector)
+			if got := selector.Matches(writer); got != tt.writer {
+				t.Errorf("expected the selector to match the writer pod %v, got %v", tt.writer, got)
+			}
+			if got := selector.Matches(reader); got != tt.readOnlyPod {
+				t.Errorf("expected the selector to match the read-only pods %v, got %v", tt.readOnlyPod, got)
+			}
+		})
+	}
+}
+
+func TestWriterSelectorOnlyMatchesFirstPod(t *testing.T) {
+	instance := newSQLiteInstance("test")
+	selector := labels.SelectorFromSet(writerSelector(instance))
+
+	for ordinal, want := range []bool{true, false} {
+		pod := labels.Set(newStatefulSet(instance).Spec.Template.Labels)
+		pod["statefulset.kubernetes.io/pod-name"] = fmt.Sprintf("%s-%d", statefulSetName(instance), ordinal)
+		if got := selector.Matches(pod); got != want {
+			t.Errorf("pod %d: expected the write Service to match %v, got %v", ordinal, want, got)
+		}
+	}
+}
+
+// roleObjects returns which of the reader Deployment and the write and read
+// Services of the SQLiteInstance exist.
+func roleObjects(ctx context.Context, t *testing.T, f *fixture, instance *kubelitedbv1.SQLiteInstance) []string {
+	t.Helper()
+	var found []string
+	if _, err := f.kubeclient.AppsV1().Deployments(instance.Namespace).Get(ctx, readerDeploymentName(instance), v1.GetOptions{}); err == nil {
+		found = append(found, "deployment")
+	} else if !errors.IsNotFound(err) {
+		t.Fatalf("error getting the reader Deployment: %v", err)
+	}
+	for _, service := range []string{writeServiceName(instance), readServiceName(instance)} {
+		if _, err := f.kubeclient.CoreV1().Services(instance.Namespace).Get(ctx, service, v1.GetOptions{}); err == nil {
+			found = append(found, service)
+		} else if !errors.IsNotFound(err) {
+			t.Fatalf("error getting Service %s: %v", service, err)
+		}
+	}
+	return found
+}
+
+func TestSyncReaders(t *testing.T) {
+	tests := []struct {
+		name         string
+		existing     int
+		readyReaders int32
+		readReplicas int
+		want         []string
+		wantReady    int32
+	}{
+		{name: "no readers"},
+		{name: "create", readReplicas: 2, want: []string{"deployment", "test-sqlite-write", "test-sqlite-read"}},
+		{name: "ready readers", existing: 2, readyReaders: 1, readReplicas: 2, want: []string{"deployment", "test-sqlite-write", "test-sqlite-read"}, wantReady: 1},
+		{name: "removed", existing: 2, readyReaders: 2, readReplicas: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := newSQLiteInstance("test")
+			instance.Spec.ReadReplicas = tt.readReplicas
+			if tt.existing > 0 {
+				existing := newSQLiteInstance("test")
+				existing.Spec.ReadReplicas = tt.existing
+				deployment := newReaderDeployment(existing)
+				deployment.Status.ReadyReplicas = tt.readyReaders
+				f.addKubeObject(deployment)
+				f.addKubeObject(newRoleService(existing, writeServiceName(existing), writerSelector(existing)))
+				f.addKubeObject(newRoleService(existing, readServiceName(existing), readerLabels(existing)))
+			}
+			c, _, _ := f.newController(ctx)
+			status := instance.Status.DeepCopy()
+			status.ReaderReadyReplicas = 5
+
+			if err := c.syncReaders(ctx, instance, status, ""); err != nil {
+				t.Fatalf("error syncing the readers: %v", err)
+			}
+
+			if got := roleObjects(ctx, t, f, instance); !slices.Equal(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+			if status.ReaderReadyReplicas != tt.wantReady {
+				t.Errorf("expected %d ready read-only pods, got %d", tt.wantReady, status.ReaderReadyReplicas)
+			}
+			if tt.readReplicas == 0 {
+				return
+			}
+			deployment, err := f.kubeclient.AppsV1().Deployments(instance.Namespace).Get(ctx, readerDeploymentName(instance), v1.GetOptions{})
+			if err != nil {
+				t.Fatalf("error getting the reader Deployment: %v", err)
+			}
+			if *deployment.Spec.Replicas != int32(tt.readReplicas) {
+				t.Errorf("expected %d read-only pods, got %d", tt.readReplicas, *deployment.Spec.Replicas)
+			}
+			assertReadOnly(t, deployment)
+		})
+	}
+}
+
+// assertReadOnly fails the test unless the SQLite container of the Deployment
+// opens the database read-only.
+func assertReadOnly(t *testing.T, deployment *appsv1.Deployment) {
+	t.Helper()
+	sqlite := container(t, deployment.Spec.Template.Spec.Containers, "sqlite")
+	if !slices.Contains(sqlite.Env, corev1.EnvVar{Name: "DATABASE_READ_ONLY", Value: "true"}) {
+		t.Errorf("expected the read-only pods to open the database read-only, got %v", sqlite.Env)
+	}
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/yaml"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+	"github.com/fortytwoapps/kubelitedb/pkg/validation"
+)
+
+// renderCommand prints the child objects the controller would create for the
+// SQLiteInstances in the given manifest files, without a cluster. Objects
+// depending on the state of the cluster, like the clone Job or the hash of the
+// TLS Secret, are left out. It returns the exit code of the command.
+func renderCommand(stdout, stderr io.Writer, files []string) int {
+	if len(files) == 0 {
+		fmt.Fprintln(stderr, "usage: kubelitedb render <file.yaml>...")
+		return 1
+	}
+
+	exitCode := 0
+	for _, file := range files {
+		if err := renderFile(stdout, file); err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", file, err)
+			exitCode = 1
+		}
+	}
+	return exitCode
+}
+
+// renderFile prints the child objects of every SQLiteInstance in the YAML or
+// JSON documents of file as YAML documents. Documents of other kinds are
+// ignored, and invalid SQLiteInstances are not rendered.
+func renderFile(out io.Writer, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	decoder := utilyaml.NewYAMLOrJSONDecoder(f, 4096)
+	for {
+		instance := &kubelitedbv1.SQLiteInstance{}
+		if err := decoder.Decode(instance); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("failed to decode manifest: %w", err)
+		}
+		if instance.Kind != "SQLiteInstance" {
+			continue
+		}
+		if errs := validation.ValidateSQLiteInstance(instance); len(errs) > 0 {
+			return fmt.Errorf("SQLiteInstance %s is invalid: %w", instance.Name, errs.ToAggregate())
+		}
+
+		for _, object := range renderObjects(instance, defaultHostPathBase) {
+			data, err := renderObject(object)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "---\n%s", data)
+		}
+	}
+}
+
+// renderObjects returns the child objects the controller manages for the
+// SQLiteInstance in its current state, built the same way syncHandler builds
+// them.
+func renderObjects(instance *kubelitedbv1.SQLiteInstance, hostPathBase string) []runtime.Object {
+	objects := []runtime.Object{}
+	if len(pragmasForInstance(instance)) > 0 {
+		objects = append(objects, newPragmasConfigMap(instance))
+	}
+	if daemonSetMode(instance) {
+		return append(objects, newDaemonSet(instance, hostPathBase))
+	}
+
+	objects = append(objects, newHeadlessService(instance))
+	if minAvailable(instance) > 0 {
+		objects = append(objects, newPodDisruptionBudget(instance))
+	}
+	if instance.Spec.Replication != nil {
+		objects = append(objects, newLitestreamConfigMap(instance))
+	}
+	if sharesVolume(instance) && instance.Spec.VolumeClaimName == "" {
+		objects = append(objects, newDataPVC(instance))
+	}
+	objects = append(objects, newStatefulSet(instance))
+	if instance.Spec.BackupSchedule != "" {
+		objects = append(objects, newBackupCronJob(instance))
+	}
+	if instance.Spec.IntegrityCheck != nil {
+		objects = append(objects, newIntegrityCheckCronJob(instance))
+	}
+	if instance.Spec.ReadReplicas > 0 {
+		objects = append(objects,
+			newRoleService(instance, writeServiceName(instance), writerSelector(instance)),
+			newRoleService(instance, readServiceName(instance), readerLabels(instance)),
+			newReaderDeployment(instance),
+		)
+	}
+	if instance.Spec.TLS != nil {
+		objects = append(objects, newClientService(instance))
+	}
+	return objects
+}
+
+// renderObject returns the YAML of a child object, with its kind set as the
+// builders leave it empty.
+func renderObject(object runtime.Object) ([]byte, error) {
+	kinds, _, err := scheme.Scheme.ObjectKinds(object)
+	if err != nil {
+		return nil, err
+	}
+	object.GetObjectKind().SetGroupVersionKind(kinds[0])
+	return yaml.Marshal(object)
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files of the render tests")
+
+func TestRenderGolden(t *testing.T) {
+	for _, name := range []string{"minimal", "replicated", "daemonset"} {
+		t.Run(name, func(t *testing.T) {
+			manifest := "testdata/render/" + name + ".yaml"
+			golden := "testdata/render/" + name + ".golden"
+			var stdout, stderr bytes.Buffer
+
+			if code := renderCommand(&stdout, &stderr, []string{manifest}); code != 0 {
+				t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+			}
+
+			if *updateGolden {
+				if err := os.WriteFile(golden, stdout.Bytes(), 0o644); err != nil {
+					t.Fatalf("error writing %s: %v", golden, err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("error reading %s, run the tests with -update to create it: %v", golden, err)
+			}
+			if got := stdout.String(); got != string(want) {
+				t.Errorf("expected the output of %s to match %s, run the tests with -update after checking the diff:\n%s", manifest, golden, got)
+			}
+		})
+	}
+}
+
+func TestRenderCommandErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  []string
+		stderr string
+	}{
+		{name: "invalid", files: []string{"testdata/validate/invalid.yaml"}, stderr: "SQLiteInstance broken is invalid"},
+		{name: "malformed", files: []string{"testdata/validate/malformed.yaml"}, stderr: "failed to decode manifest"},
+		{name: "missing file", files: []string{"testdata/render/missing.yaml"}, stderr: "testdata/render/missing.yaml"},
+		{name: "no files", stderr: "usage: kubelitedb render"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+
+			if code := renderCommand(&stdout, &stderr, tt.files); code != 1 {
+				t.Errorf("expected exit code 1, got %d", code)
+			}
+			if !strings.Contains(stderr.String(), tt.stderr) {
+				t.Errorf("expected the errors to contain %q, got %q", tt.stderr, stderr.String())
+			}
+		})
+	}
+}
+
+// TestRenderObjects checks the objects rendered for a SQLiteInstance are the
+// ones syncHandler creates for it.
+func TestRenderObjects(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(instance *kubelitedbv1.SQLiteInstance)
+		kinds  []string
+	}{
+		{name: "minimal", mutate: func(*kubelitedbv1.SQLiteInstance) {}, kinds: []string{"Service", "StatefulSet"}},
+		{
+			name:   "shared volume",
+			mutate: func(instance *kubelitedbv1.SQLiteInstance) { instance.Spec.AccessMode = corev1.ReadWriteMany },
+			kinds:  []string{"Service", "PersistentVolumeClaim", "StatefulSet"},
+		},
+		{
+			name: "several replicas",
+			mutate: func(instance *kubelitedbv1.SQLiteInstance) {
+				instance.Spec.Replicas = 3
+				instance.Spec.AccessMode = corev1.ReadWriteMany
+			},
+			kinds: []string{"Service", "PodDisruptionBudget", "PersistentVolumeClaim", "StatefulSet"},
+		},
+		{
+			name: "DaemonSet",
+			mutate: func(instance *kubelitedbv1.SQLiteInstance) {
+				instance.Spec.DeploymentMode = kubelitedbv1.DeploymentModeDaemonSet
+				instance.Spec.Pragmas = map[string]string{"journal_mode": "WAL"}
+			},
+			kinds: []string{"ConfigMap", "DaemonSet"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newSQLiteInstance("test")
+			tt.mutate(instance)
+
+			objects := renderObjects(instance, defaultHostPathBase)
+
+			// renderObject sets the kinds the builders leave empty
+			var kinds []string
+			for _, object := range objects {
+				if _, err := renderObject(object); err != nil {
+					t.Fatalf("error rendering %T: %v", object, err)
+				}
+				kinds = append(kinds, object.GetObjectKind().GroupVersionKind().Kind)
+			}
+			if !slices.Equal(kinds, tt.kinds) {
+				t.Errorf("expected the objects %v, got %v", tt.kinds, kinds)
+			}
+		})
+	}
+}
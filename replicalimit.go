@@ -0,0 +1,120 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// ReplicaLimitMode is how SQLiteInstances requesting more replicas than the
+// ReplicaLimit allows are handled
+type ReplicaLimitMode string
+
+const (
+	// ReplicaLimitClamp runs the SQLiteInstance with the maximum number of
+	// replicas instead
+	ReplicaLimitClamp ReplicaLimitMode = "clamp"
+	// ReplicaLimitReject leaves the SQLiteInstance as is until its replicas
+	// are lowered
+	ReplicaLimitReject ReplicaLimitMode = "reject"
+)
+
+// ReplicaLimit bounds the number of replicas a SQLiteInstance can run, to
+// protect shared clusters.
+type ReplicaLimit struct {
+	// Max is the maximum number of replicas of a SQLiteInstance. There is no
+	// limit when 0.
+	Max int
+	// Mode is how SQLiteInstances exceeding Max are handled
+	Mode ReplicaLimitMode
+}
+
+// Valid returns an error when the ReplicaLimit can't be applied.
+func (l ReplicaLimit) Valid() error {
+	if l.Max < 0 {
+		return fmt.Errorf("maximum replicas must not be negative, got %d", l.Max)
+	}
+	if l.Mode != ReplicaLimitClamp && l.Mode != ReplicaLimitReject {
+		return fmt.Errorf("replica limit mode must be %q or %q, got %q", ReplicaLimitClamp, ReplicaLimitReject, l.Mode)
+	}
+	return nil
+}
+
+// exceededBy returns whether the SQLiteInstance requests more replicas than
+// the ReplicaLimit allows.
+func (l ReplicaLimit) exceededBy(instance *kubelitedbv1.SQLiteInstance) bool {
+	return l.Max > 0 && instance.Spec.Replicas > l.Max
+}
+
+const (
+	// ReasonReplicasClamped is used as the condition reason when the
+	// replicas of the SQLiteInstance were lowered to the maximum
+	ReasonReplicasClamped = "ReplicasClamped"
+	// ReasonReplicasRejected is used as the condition reason when the
+	// SQLiteInstance is not rolled out as it requests too many replicas
+	ReasonReplicasRejected = "ReplicasRejected"
+
+	// MessageReplicasClamped is the message used when the replicas of the
+	// SQLiteInstance were lowered to the maximum
+	MessageReplicasClamped = "Running %d replicas instead of the %d requested, the controller allows at most %d"
+	// MessageReplicasRejected is the message used when the SQLiteInstance
+	// requests too many replicas
+	MessageReplicasRejected = "%d replicas requested, the controller allows at most %d"
+)
+
+// limitReplicas applies the replica limit of the controller to the
+// SQLiteInstance. In clamp mode a copy of the SQLiteInstance running the
+// maximum number of replicas is returned, so every child object is sized
+// consistently. In reject mode the SQLiteInstance is returned as is along with
+// false, and should not be rolled out.
+func (c *Controller) limitReplicas(instance *kubelitedbv1.SQLiteInstance, status *kubelitedbv1.SQLiteInstanceStatus) (*kubelitedbv1.SQLiteInstance, bool) {
+	if !c.replicaLimit.exceededBy(instance) {
+		meta.RemoveStatusCondition(&status.Conditions, kubelitedbv1.ConditionReplicasLimited)
+		return instance, true
+	}
+
+	if c.replicaLimit.Mode == ReplicaLimitReject {
+		msg := fmt.Sprintf(MessageReplicasRejected, instance.Spec.Replicas, c.replicaLimit.Max)
+		c.setReplicasLimited(status, instance, ReasonReplicasRejected, msg)
+		setCondition(status, instance, kubelitedbv1.ConditionProgressing, v1.ConditionFalse, ReasonReplicasRejected, msg)
+		setCondition(status, instance, kubelitedbv1.ConditionReady, v1.ConditionFalse, ReasonReplicasRejected, msg)
+		return instance, false
+	}
+
+	msg := fmt.Sprintf(MessageReplicasClamped, c.replicaLimit.Max, instance.Spec.Replicas, c.replicaLimit.Max)
+	c.setReplicasLimited(status, instance, ReasonReplicasClamped, msg)
+	clamped := instance.DeepCopy()
+	clamped.Spec.Replicas = c.replicaLimit.Max
+	return clamped, true
+}
+
+// setReplicasLimited sets the ReplicasLimited condition. The Warning event is
+// only emitted when the condition changes, rather than on every reconcile of
+// the SQLiteInstance.
+func (c *Controller) setReplicasLimited(status *kubelitedbv1.SQLiteInstanceStatus, instance *kubelitedbv1.SQLiteInstance, reason, message string) {
+	limited := meta.FindStatusCondition(status.Conditions, kubelitedbv1.ConditionReplicasLimited)
+	if limited == nil || limited.Status != v1.ConditionTrue || limited.Reason != reason || limited.Message != message {
+		c.recorder.Event(instance, corev1.EventTypeWarning, reason, message)
+	}
+	setCondition(status, instance, kubelitedbv1.ConditionReplicasLimited, v1.ConditionTrue, reason, message)
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+func TestReplicaLimitValid(t *testing.T) {
+	tests := []struct {
+		limit ReplicaLimit
+		valid bool
+	}{
+		{limit: ReplicaLimit{Mode: ReplicaLimitClamp}, valid: true},
+		{limit: ReplicaLimit{Max: 3, Mode: ReplicaLimitReject}, valid: true},
+		{limit: ReplicaLimit{Max: -1, Mode: ReplicaLimitClamp}},
+		{limit: ReplicaLimit{Max: 3, Mode: "ignore"}},
+		{limit: ReplicaLimit{Max: 3}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d %s", tt.limit.Max, tt.limit.Mode), func(t *testing.T) {
+			if err := tt.limit.Valid(); (err == nil) != tt.valid {
+				t.Errorf("expected valid %t, got %v", tt.valid, err)
+			}
+		})
+	}
+}
+
+func TestReplicaLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		limit    ReplicaLimit
+		replicas int
+		// The replicas of the StatefulSet, or -1 when it isn't created
+		wantReplicas int32
+		wantReason   string
+	}{
+		{name: "no limit", limit: ReplicaLimit{Mode: ReplicaLimitClamp}, replicas: 3, wantReplicas: 3},
+		{name: "clamp below", limit: ReplicaLimit{Max: 2, Mode: ReplicaLimitClamp}, replicas: 1, wantReplicas: 1},
+		{name: "clamp at", limit: ReplicaLimit{Max: 2, Mode: ReplicaLimitClamp}, replicas: 2, wantReplicas: 2},
+		{name: "clamp above", limit: ReplicaLimit{Max: 2, Mode: ReplicaLimitClamp}, replicas: 3, wantReplicas: 2, wantReason: ReasonReplicasClamped},
+		{name: "reject below", limit: ReplicaLimit{Max: 2, Mode: ReplicaLimitReject}, replicas: 1, wantReplicas: 1},
+		{name: "reject at", limit: ReplicaLimit{Max: 2, Mode: ReplicaLimitReject}, replicas: 2, wantReplicas: 2},
+		{name: "reject above", limit: ReplicaLimit{Max: 2, Mode: ReplicaLimitReject}, replicas: 3, wantReplicas: -1, wantReason: ReasonReplicasRejected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			f.replicaLimit = tt.limit
+			instance := newSQLiteInstance("test")
+			instance.Spec.Replicas = tt.replicas
+			f.addInstance(instance)
+			c, _, _ := f.newController(ctx)
+
+			f.run(ctx, c, getKey(instance, t))
+
+			sts, err := f.kubeclient.AppsV1().StatefulSets(instance.Namespace).Get(ctx, statefulSetName(instance), v1.GetOptions{})
+			switch {
+			case tt.wantReplicas < 0:
+				if !errors.IsNotFound(err) {
+					t.Errorf("expected no StatefulSet, got %v", err)
+				}
+			case err != nil:
+				t.Fatalf("error getting the StatefulSet: %v", err)
+			case *sts.Spec.Replicas != tt.wantReplicas:
+				t.Errorf("expected %d StatefulSet replicas, got %d", tt.wantReplicas, *sts.Spec.Replicas)
+			}
+
+			status := f.getInstance(ctx, instance).Status
+			limited := meta.FindStatusCondition(status.Conditions, kubelitedbv1.ConditionReplicasLimited)
+			if tt.wantReason == "" {
+				if limited != nil {
+					t.Errorf("expected no %s condition, got %+v", kubelitedbv1.ConditionReplicasLimited, limited)
+				}
+				return
+			}
+			if limited == nil || limited.Status != v1.ConditionTrue || limited.Reason != tt.wantReason {
+				t.Errorf("expected a true %s condition for %s, got %+v", kubelitedbv1.ConditionReplicasLimited, tt.wantReason, limited)
+			}
+			expectEvent(t, f.recorder, corev1.EventTypeWarning, tt.wantReason)
+		})
+	}
+}
+
+func TestReplicaLimitLifted(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	f.replicaLimit = ReplicaLimit{Max: 2, Mode: ReplicaLimitClamp}
+	instance := newSQLiteInstance("test")
+	instance.Spec.Replicas = 3
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+	key := getKey(instance, t)
+	f.run(ctx, c, key)
+
+	lowered := f.getInstance(ctx, instance)
+	lowered.Spec.Replicas, lowered.Generation = 2, 2
+	if _, err := f.client.KubelitedbV1().SQLiteInstances(lowered.Namespace).Update(ctx, lowered, v1.UpdateOptions{}); err != nil {
+		t.Fatalf("error lowering the replicas: %v", err)
+	}
+	f.refreshCaches(ctx)
+	f.run(ctx, c, key)
+
+	if limited := meta.FindStatusCondition(f.getInstance(ctx, instance).Status.Conditions, kubelitedbv1.ConditionReplicasLimited); limited != nil {
+		t.Errorf("expected the %s condition to be removed, got %+v", kubelitedbv1.ConditionReplicasLimited, limited)
+	}
+}
+
+func TestReplicaLimitEventOnce(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   ReplicaLimitMode
+		reason string
+	}{
+		{name: "clamp", mode: ReplicaLimitClamp, reason: ReasonReplicasClamped},
+		{name: "reject", mode: ReplicaLimitReject, reason: ReasonReplicasRejected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			f.replicaLimit = ReplicaLimit{Max: 2, Mode: tt.mode}
+			instance := newSQLiteInstance("test")
+			instance.Spec.Replicas = 3
+			f.addInstance(instance)
+			c, _, _ := f.newController(ctx)
+			key := getKey(instance, t)
+			f.run(ctx, c, key)
+			expectEvent(t, f.recorder, corev1.EventTypeWarning, tt.reason)
+
+			// The limit still applies, but the condition is unchanged. The API
+			// server keeps the spec on status updates, which the fake
+			// clientset doesn't, so the requested replicas are set again.
+			for len(f.recorder.Events) > 0 {
+				<-f.recorder.Events
+			}
+			requested := f.getInstance(ctx, instance)
+			requested.Spec.Replicas = 3
+			if _, err := f.client.KubelitedbV1().SQLiteInstances(requested.Namespace).Update(ctx, requested, v1.UpdateOptions{}); err != nil {
+				t.Fatalf("error keeping the replicas: %v", err)
+			}
+			f.refreshCaches(ctx)
+			f.run(ctx, c, key)
+			for len(f.recorder.Events) > 0 {
+				if event := <-f.recorder.Events; strings.HasPrefix(event, corev1.EventTypeWarning+" "+tt.reason+" ") {
+					t.Errorf("expected no further %s Event, got %q", tt.reason, event)
+				}
+			}
+
+			// Requesting even more replicas changes the condition
+			raised := f.getInstance(ctx, instance)
+			raised.Spec.Replicas, raised.Generation = 4, raised.Generation+1
+			if _, err := f.client.KubelitedbV1().SQLiteInstances(raised.Namespace).Update(ctx, raised, v1.UpdateOptions{}); err != nil {
+				t.Fatalf("error raising the replicas: %v", err)
+			}
+			f.refreshCaches(ctx)
+			f.run(ctx, c, key)
+			expectEvent(t, f.recorder, corev1.EventTypeWarning, tt.reason)
+		})
+	}
+}
@@ -0,0 +1,267 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+const (
+	// litestreamImage is the container image of the Litestream sidecar
+	litestreamImage = "litestream/litestream:0.3.13"
+	// litestreamContainerName is the name of the Litestream sidecar container
+	litestreamContainerName = "litestream"
+	// litestreamConfigVolumeName is the name of the volume holding the
+	// Litestream configuration
+	litestreamConfigVolumeName = "litestream-config"
+	// litestreamConfigMountPath is where the Litestream configuration is
+	// mounted in the sidecar
+	litestreamConfigMountPath = "/etc/litestream"
+	// litestreamConfigKey is the key of the Litestream configuration in the
+	// ConfigMap
+	litestreamConfigKey = "litestream.yml"
+	// litestreamConfigHashAnnotation records the hash of the Litestream
+	// configuration on the pod template, so pods are rolled when it changes
+	litestreamConfigHashAnnotation = "kubelitedb.fortytwoapps.tech/litestream-config-hash"
+
+	// ReasonReplicasReplicating is used as the condition reason when every pod
+	// replicates the database
+	ReasonReplicasReplicating = "Replicating"
+	// ReasonSidecarNotReady is used as the condition reason when some of the
+	// Litestream sidecars are not ready
+	ReasonSidecarNotReady = "SidecarNotReady"
+
+	// MessageSidecarNotReady is the message used for the ReplicationHealthy
+	// condition when some of the Litestream sidecars are not ready
+	MessageSidecarNotReady = "%d/%d Litestream sidecars are ready"
+)
+
+// litestreamConfig is the Litestream configuration file, see
+// https://litestream.io/reference/config/
+type litestreamConfig struct {
+	DBs []litestreamDB `json:"dbs"`
+}
+
+type litestreamDB struct {
+	Path     string              `json:"path"`
+	Replicas []litestreamReplica `json:"replicas"`
+}
+
+type litestreamReplica struct {
+	Type     string `json:"type"`
+	Bucket   string `json:"bucket"`
+	Path     string `json:"path"`
+	Endpoint string `json:"endpoint,omitempty"`
+}
+
+// databasePath returns the path of the database file in the SQLite container
+func databasePath(instance *kubelitedbv1.SQLiteInstance) string {
+	return path.Join(dataMountPath, instance.Spec.DbName)
+}
+
+// litestreamConfigMapName returns the name of the ConfigMap holding the
+// Litestream configuration of the given SQLiteInstance.
+func litestreamConfigMapName(instance *kubelitedbv1.SQLiteInstance) string {
+	return fmt.Sprintf("%s-litestream", instance.Name)
+}
+
+// renderLitestreamConfig renders the Litestream configuration replicating the
+// database of the given SQLiteInstance.
+func renderLitestreamConfig(instance *kubelitedbv1.SQLiteInstance) string {
+	replication := instance.Spec.Replication
+	replicaPath := replication.Path
+	if replicaPath == "" {
+		replicaPath = path.Join(instance.Namespace, instance.Name)
+	}
+	config := litestreamConfig{
+		DBs: []litestreamDB{
+			{
+				Path: databasePath(instance),
+				Replicas: []litestreamReplica{
+					{
+						Type:     "s3",
+						Bucket:   replication.Bucket,
+						Path:     replicaPath,
+						Endpoint: replication.Endpoint,
+					},
+				},
+			},
+		},
+	}
+	// The configuration only holds strings, so marshalling cannot fail
+	data, _ := yaml.Marshal(config)
+	return string(data)
+}
+
+// newLitestreamConfigMap creates the ConfigMap holding the Litestream
+// configuration of a SQLiteInstance resource.
+func newLitestreamConfigMap(instance *kubelitedbv1.SQLiteInstance) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{
+			Name:        litestreamConfigMapName(instance),
+			Namespace:   instance.Namespace,
+			Labels:      childLabels(instance, labelsForInstance(instance, componentDatabase)),
+			Annotations: childAnnotations(instance),
+			OwnerReferences: []v1.OwnerReference{
+				newOwnerReference(instance),
+			},
+		},
+		Data: map[string]string{
+			litestreamConfigKey: renderLitestreamConfig(instance),
+		},
+	}
+}
+
+// syncLitestreamConfigMap ensures the Litestream configuration of the
+// SQLiteInstance exists when replication is enabled, and removes it otherwise.
+func (c *Controller) syncLitestreamConfigMap(ctx context.Context, sqliteInstance *kubelitedbv1.SQLiteInstance) error {
+	configMaps := c.kubeclientset.CoreV1().ConfigMaps(sqliteInstance.Namespace)
+	configMap, err := configMaps.Get(ctx, litestreamConfigMapName(sqliteInstance), v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		if sqliteInstance.Spec.Replication == nil {
+			return nil
+		}
+		_, err = configMaps.Create(ctx, newLitestreamConfigMap(sqliteInstance), c.createOptions(ctx, sqliteInstance, "ConfigMap", litestreamConfigMapName(sqliteInstance)))
+		logWrite(ctx, "create", "ConfigMap", litestreamConfigMapName(sqliteInstance), err)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if !v1.IsControlledBy(configMap, sqliteInstance) {
+		msg := fmt.Sprintf(MessageResourceExists, configMap.Name)
+		c.recorder.Event(sqliteInstance, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return fmt.Errorf("%s", msg)
+	}
+
+	if sqliteInstance.Spec.Replication == nil {
+		err = configMaps.Delete(ctx, configMap.Name, c.deleteOptions(ctx, sqliteInstance, "ConfigMap", configMap.Name))
+		logWrite(ctx, "delete", "ConfigMap", configMap.Name, err)
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	desired := newLitestreamConfigMap(sqliteInstance)
+	if configMap.Data[litestreamConfigKey] != desired.Data[litestreamConfigKey] ||
+		metadataOutOfDate(configMap, desired) {
+		configMapCopy := configMap.DeepCopy()
+		mergeMetadata(configMapCopy, desired)
+		configMapCopy.Data = desired.Data
+		_, err = configMaps.Update(ctx, configMapCopy, c.updateOptions(ctx, sqliteInstance, "ConfigMap", configMap, configMapCopy))
+		logWrite(ctx, "update", "ConfigMap", configMap.Name, err)
+	}
+	return err
+}
+
+// addLitestreamSidecar adds the Litestream sidecar replicating the database of
+// the SQLiteInstance to the pod template. The credentials of the bucket are
+// read from the referenced Secret.
+func addLitestreamSidecar(instance *kubelitedbv1.SQLiteInstance, template *corev1.PodTemplateSpec) {
+	secretRef := instance.Spec.Replication.SecretRef
+	template.Spec.Containers = append(template.Spec.Containers, corev1.Container{
+		Name:  litestreamContainerName,
+		Image: litestreamImage,
+		Args:  []string{"replicate", "-config", path.Join(litestreamConfigMountPath, litestreamConfigKey)},
+		Env:   objectStorageCredentials(secretRef),
+		VolumeMounts: []corev1.VolumeMount{
+			dataVolumeMount(instance),
+			{
+				Name:      litestreamConfigVolumeName,
+				MountPath: litestreamConfigMountPath,
+				ReadOnly:  true,
+			},
+		},
+	})
+	template.Spec.Volumes = append(template.Spec.Volumes, corev1.Volume{
+		Name: litestreamConfigVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: litestreamConfigMapName(instance)},
+			},
+		},
+	})
+	if template.Annotations == nil {
+		template.Annotations = map[string]string{}
+	}
+	template.Annotations[litestreamConfigHashAnnotation] = computeHash(renderLitestreamConfig(instance))
+}
+
+// objectStorageCredentials returns the environment variables holding the
+// credentials of a bucket, read from the referenced Secret.
+func objectStorageCredentials(secretRef corev1.LocalObjectReference) []corev1.EnvVar {
+	var env []corev1.EnvVar
+	for _, key := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"} {
+		env = append(env, corev1.EnvVar{
+			Name: key,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: secretRef,
+					Key:                  key,
+				},
+			},
+		})
+	}
+	return env
+}
+
+// setReplicationCondition sets the ReplicationHealthy condition from the
+// readiness of the Litestream sidecars of the SQLiteInstance's pods. The
+// condition is removed when replication is disabled.
+func (c *Controller) setReplicationCondition(ctx context.Context, sqliteInstance *kubelitedbv1.SQLiteInstance, status *kubelitedbv1.SQLiteInstanceStatus) error {
+	if sqliteInstance.Spec.Replication == nil {
+		meta.RemoveStatusCondition(&status.Conditions, kubelitedbv1.ConditionReplicationHealthy)
+		return nil
+	}
+
+	pods, err := c.kubeclientset.CoreV1().Pods(sqliteInstance.Namespace).List(ctx, v1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(podLabels(sqliteInstance)).String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	ready := 0
+	for _, pod := range pods.Items {
+		for _, containerStatus := range pod.Status.ContainerStatuses {
+			if containerStatus.Name == litestreamContainerName && containerStatus.Ready {
+				ready++
+			}
+		}
+	}
+
+	if len(pods.Items) > 0 && ready == len(pods.Items) {
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionReplicationHealthy, v1.ConditionTrue, ReasonReplicasReplicating, "")
+	} else {
+		msg := fmt.Sprintf(MessageSidecarNotReady, ready, len(pods.Items))
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionReplicationHealthy, v1.ConditionFalse, ReasonSidecarNotReady, msg)
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+func newReplicatedSQLiteInstance(name string) *kubelitedbv1.SQLiteInstance {
+	instance := newSQLiteInstance(name)
+	instance.Spec.Replication = &kubelitedbv1.ReplicationSpec{
+		Bucket:    "backups",
+		Endpoint:  "https://s3.example.com",
+		SecretRef: corev1.LocalObjectReference{Name: "s3-credentials"},
+	}
+	return instance
+}
+
+func TestReplication(t *testing.T) {
+	tests := []struct {
+		name       string
+		replicated bool
+	}{
+		{name: "disabled", replicated: false},
+		{name: "enabled", replicated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := newSQLiteInstance("test")
+			if tt.replicated {
+				instance = newReplicatedSQLiteInstance("test")
+			}
+			f.addInstance(instance)
+			c, _, _ := f.newController(ctx)
+
+			f.run(ctx, c, getKey(instance, t))
+
+			configMap, err := f.kubeclient.CoreV1().ConfigMaps(instance.Namespace).Get(ctx, litestreamConfigMapName(instance), v1.GetOptions{})
+			if !tt.replicated {
+				if !errors.IsNotFound(err) {
+					t.Errorf("expected no Litestream ConfigMap, got %v", err)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("error getting the Litestream ConfigMap: %v", err)
+				}
+				if got, want := configMap.Data[litestreamConfigKey], renderLitestreamConfig(instance); got != want {
+					t.Errorf("expected configuration %q, got %q", want, got)
+				}
+				if !v1.IsControlledBy(configMap, instance) {
+					t.Errorf("expected the Litestream ConfigMap to be controlled by the SQLiteInstance")
+				}
+			}
+
+			sts := f.getStatefulSet(ctx, instance)
+			var sidecar *corev1.Container
+			for i, container := range sts.Spec.Template.Spec.Containers {
+				if container.Name == litestreamContainerName {
+					sidecar = &sts.Spec.Template.Spec.Containers[i]
+				}
+			}
+			if !tt.replicated {
+				if sidecar != nil {
+					t.Errorf("expected no Litestream sidecar")
+				}
+				return
+			}
+			if sidecar == nil {
+				t.Fatalf("expected a Litestream sidecar")
+			}
+			for _, key := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"} {
+				found := false
+				for _, env := range sidecar.Env {
+					if env.Name == key && env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil &&
+						env.ValueFrom.SecretKeyRef.Name == "s3-credentials" && env.ValueFrom.SecretKeyRef.Key == key {
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("expected %s to be read from the s3-credentials Secret", key)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderLitestreamConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		contains string
+	}{
+		{name: "default path", path: "", contains: "path: default/test\n"},
+		{name: "explicit path", path: "replicas/app", contains: "path: replicas/app\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newReplicatedSQLiteInstance("test")
+			instance.Spec.Replication.Path = tt.path
+
+			config := renderLitestreamConfig(instance)
+
+			for _, want := range []string{tt.contains, "bucket: backups\n", "endpoint: https://s3.example.com\n", "path: /data/app.db\n"} {
+				if !strings.Contains(config, want) {
+					t.Errorf("expected the configuration to contain %q, got %q", want, config)
+				}
+			}
+		})
+	}
+}
+
+func TestReplicationDisabledDeletesConfigMap(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	f.addInstance(instance)
+	f.addKubeObject(newLitestreamConfigMap(newReplicatedSQLiteInstance("test")))
+	c, _, _ := f.newController(ctx)
+
+	f.run(ctx, c, getKey(instance, t))
+
+	if _, err := f.kubeclient.CoreV1().ConfigMaps(instance.Namespace).Get(ctx, litestreamConfigMapName(instance), v1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("expected the Litestream ConfigMap to be deleted, got %v", err)
+	}
+}
+
+func TestReplicationCondition(t *testing.T) {
+	tests := []struct {
+		name       string
+		replicated bool
+		ready      []bool
+		want       v1.ConditionStatus
+		reason     string
+	}{
+		{name: "disabled", replicated: false},
+		{name: "no pods", replicated: true, want: v1.ConditionFalse, reason: ReasonSidecarNotReady},
+		{name: "sidecar not ready", replicated: true, ready: []bool{false}, want: v1.ConditionFalse, reason: ReasonSidecarNotReady},
+		{name: "sidecar ready", replicated: true, ready: []bool{true}, want: v1.ConditionTrue, reason: ReasonReplicasReplicating},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := newSQLiteInstance("test")
+			if tt.replicated {
+				instance = newReplicatedSQLiteInstance("test")
+			}
+			for i, ready := range tt.ready {
+				f.addKubeObject(&corev1.Pod{
+					ObjectMeta: v1.ObjectMeta{
+						Name:      fmt.Sprintf("%s-%d", instance.Name, i),
+						Namespace: instance.Namespace,
+						Labels:    podLabels(instance),
+					},
+					Status: corev1.PodStatus{
+						ContainerStatuses: []corev1.ContainerStatus{
+							{Name: litestreamContainerName, Ready: ready},
+						},
+					},
+				})
+			}
+			c, _, _ := f.newController(ctx)
+			status := instance.Status.DeepCopy()
+			status.Conditions = append(status.Conditions, v1.Condition{Type: kubelitedbv1.ConditionReplicationHealthy, Status: v1.ConditionTrue})
+
+			if err := c.setReplicationCondition(ctx, instance, status); err != nil {
+				t.Fatalf("error setting the condition: %v", err)
+			}
+
+			condition := meta.FindStatusCondition(status.Conditions, kubelitedbv1.ConditionReplicationHealthy)
+			if !tt.replicated {
+				if condition != nil {
+					t.Errorf("expected no %s condition, got %v", kubelitedbv1.ConditionReplicationHealthy, condition)
+				}
+				return
+			}
+			if condition == nil || condition.Status != tt.want || condition.Reason != tt.reason {
+				t.Errorf("expected condition %s with reason %s, got %v", tt.want, tt.reason, condition)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	core "k8s.io/client-go/testing"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2/ktesting"
+)
+
+// recordingQueue records how the keys are put back onto the workqueue.
+type recordingQueue struct {
+	workqueue.RateLimitingInterface
+	addedAfter  []time.Duration
+	rateLimited int
+}
+
+func (q *recordingQueue) AddAfter(item interface{}, duration time.Duration) {
+	q.addedAfter = append(q.addedAfter, duration)
+}
+
+func (q *recordingQueue) AddRateLimited(item interface{}) {
+	q.rateLimited++
+}
+
+func TestRequeue(t *testing.T) {
+	tests := []struct {
+		name        string
+		statefulSet bool
+		ready       int32
+		// Whether creating the StatefulSet fails
+		fail            bool
+		wantAddedAfter  []time.Duration
+		wantRateLimited int
+	}{
+		{name: "provisioning", wantAddedAfter: []time.Duration{pendingRequeueAfter}},
+		{name: "pods starting", statefulSet: true, ready: 0, wantAddedAfter: []time.Duration{pendingRequeueAfter}},
+		{name: "running", statefulSet: true, ready: 1},
+		{name: "error", fail: true, wantRateLimited: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := newSQLiteInstance("test")
+			f.addInstance(instance)
+			if tt.statefulSet {
+				sts := newStatefulSet(instance)
+				sts.Status.Replicas = 1
+				sts.Status.ReadyReplicas = tt.ready
+				// The rollout is complete, so no deadline is pending
+				sts.Status.UpdatedReplicas = 1
+				f.addKubeObject(sts)
+			}
+			c, _, _ := f.newController(ctx)
+			defer c.workqueue.ShutDown()
+			if tt.fail {
+				f.kubeclient.PrependReactor("create", "statefulsets", func(action core.Action) (bool, runtime.Object, error) {
+					return true, nil, fmt.Errorf("injected error")
+				})
+			}
+			queue := &recordingQueue{RateLimitingInterface: c.workqueue}
+			c.workqueue = queue
+
+			queue.Add(getKey(instance, t))
+			c.processNextWorkItem(ctx)
+
+			if !slices.Equal(queue.addedAfter, tt.wantAddedAfter) {
+				t.Errorf("expected the key to be added after %v, got %v", tt.wantAddedAfter, queue.addedAfter)
+			}
+			if queue.rateLimited != tt.wantRateLimited {
+				t.Errorf("expected the key to be rate limited %d times, got %d", tt.wantRateLimited, queue.rateLimited)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// restoreScript downloads the backup to a temporary file next to the database
+// and then renames it, so a partial download is never mistaken for the
+// database. Nothing is downloaded when the database already exists, so live
+// data is never overwritten. An encrypted backup is renamed by the decrypt
+// init container instead.
+const restoreScript = `set -e
+if [ -f "$DATABASE_PATH" ]; then
+  echo "Database $DATABASE_PATH already exists, skipping restore"
+  exit 0
+fi
+aws s3 cp "$RESTORE_SOURCE" "$DATABASE_PATH.restore" ${RESTORE_ENDPOINT:+--endpoint-url "$RESTORE_ENDPOINT"}
+if [ -n "$RESTORE_ENCRYPTED" ]; then
+  exit 0
+fi
+mv "$DATABASE_PATH.restore" "$DATABASE_PATH"
+`
+
+// needsRestore returns whether the database of the SQLiteInstance still has to
+// be restored from spec.restoreFrom.
+func needsRestore(instance *kubelitedbv1.SQLiteInstance) bool {
+	return instance.Spec.RestoreFrom != nil && !instance.Status.Restored
+}
+
+// addRestoreInitContainer adds the init container restoring the database from
+// spec.restoreFrom to the pod template, so the backup is in place before the
+// SQLite container starts.
+func addRestoreInitContainer(instance *kubelitedbv1.SQLiteInstance, template *corev1.PodTemplateSpec) {
+	restoreFrom := instance.Spec.RestoreFrom
+	env := []corev1.EnvVar{
+		{Name: "DATABASE_PATH", Value: databasePath(instance)},
+		{Name: "RESTORE_SOURCE", Value: "s3://" + restoreFrom.Bucket + "/" + restoreFrom.Key},
+		{Name: "RESTORE_ENDPOINT", Value: restoreFrom.Endpoint},
+	}
+	if restoreFrom.Encryption != nil {
+		env = append(env, corev1.EnvVar{Name: "RESTORE_ENCRYPTED", Value: "true"})
+	}
+	env = append(env, objectStorageCredentials(restoreFrom.SecretRef)...)
+
+	template.Spec.InitContainers = append(template.Spec.InitContainers, corev1.Container{
+		Name:    "restore",
+		Image:   awsCLIImage,
+		Command: []string{"/bin/sh", "-c", restoreScript},
+		Env:     env,
+		VolumeMounts: []corev1.VolumeMount{
+			dataVolumeMount(instance),
+		},
+	})
+	if restoreFrom.Encryption != nil {
+		addDecryptInitContainer(instance, template)
+	}
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+func newRestoringSQLiteInstance(name string) *kubelitedbv1.SQLiteInstance {
+	instance := newSQLiteInstance(name)
+	instance.Spec.RestoreFrom = &kubelitedbv1.RestoreSource{
+		Bucket:    "backups",
+		Key:       "default/old/nightly/app.db",
+		SecretRef: corev1.LocalObjectReference{Name: "s3-credentials"},
+	}
+	return instance
+}
+
+// hasInitContainer returns whether the pod template has an init container
+// with the given name.
+func hasInitContainer(template corev1.PodTemplateSpec, name string) bool {
+	for _, container := range template.Spec.InitContainers {
+		if container.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRestoreInitContainer(t *testing.T) {
+	tests := []struct {
+		name     string
+		restore  bool
+		restored bool
+		want     bool
+	}{
+		{name: "no restore", want: false},
+		{name: "not restored yet", restore: true, want: true},
+		{name: "restored", restore: true, restored: true, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newSQLiteInstance("test")
+			if tt.restore {
+				instance = newRestoringSQLiteInstance("test")
+			}
+			instance.Status.Restored = tt.restored
+
+			sts := newStatefulSet(instance)
+
+			if got := hasInitContainer(sts.Spec.Template, "restore"); got != tt.want {
+				t.Fatalf("expected a restore init container %v, got %v", tt.want, got)
+			}
+			if !tt.want {
+				return
+			}
+			restore := container(t, sts.Spec.Template.Spec.InitContainers, "restore")
+			env := map[string]string{}
+			for _, envVar := range restore.Env {
+				env[envVar.Name] = envVar.Value
+			}
+			if want := "s3://backups/default/old/nightly/app.db"; env["RESTORE_SOURCE"] != want {
+				t.Errorf("expected RESTORE_SOURCE %q, got %q", want, env["RESTORE_SOURCE"])
+			}
+			if want := databasePath(instance); env["DATABASE_PATH"] != want {
+				t.Errorf("expected DATABASE_PATH %q, got %q", want, env["DATABASE_PATH"])
+			}
+		})
+	}
+}
+
+func TestRestoreRunsOnce(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newRestoringSQLiteInstance("test")
+	sts := newStatefulSet(instance)
+	sts.Status.Replicas = 1
+	sts.Status.ReadyReplicas = 1
+	f.addInstance(instance)
+	f.addKubeObject(sts)
+	c, _, _ := f.newController(ctx)
+
+	f.run(ctx, c, getKey(instance, t))
+
+	got := f.getInstance(ctx, instance)
+	if !got.Status.Restored {
+		t.Fatalf("expected the database to be recorded as restored once a pod is ready")
+	}
+	if !hasInitContainer(f.getStatefulSet(ctx, instance).Spec.Template, "restore") {
+		t.Errorf("expected the restore init container to be kept until the restore is recorded")
+	}
+
+	f.refreshCaches(ctx)
+	f.run(ctx, c, getKey(instance, t))
+
+	if hasInitContainer(f.getStatefulSet(ctx, instance).Spec.Template, "restore") {
+		t.Errorf("expected the restore init container to be removed once restored")
+	}
+	if got := f.getInstance(ctx, instance); !got.Status.Restored {
+		t.Errorf("expected the database to stay recorded as restored")
+	}
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"time"
+
+	"github.com/fortytwoapps/kubelitedb/pkg/metrics"
+)
+
+// reconcileResult tells processNextWorkItem when a synced SQLiteInstance is
+// synced again, on top of the syncs triggered by watched changes. The zero
+// value only syncs it again once something changes.
+type reconcileResult struct {
+	// Requeue syncs the SQLiteInstance again with the backoff of the rate
+	// limiter, for states that are not errors but may take a while to clear.
+	Requeue bool
+	// RequeueAfter syncs the SQLiteInstance again after the given delay, for
+	// states expected to change by then, such as a volume being bound or a
+	// rollout reaching its deadline. It takes precedence over Requeue.
+	RequeueAfter time.Duration
+}
+
+// requeueSynced puts the key of a synced SQLiteInstance back onto the work
+// queue as the result asks. Expected transient states are checked again after
+// the requested delay, or with backoff when none was requested. The backoff
+// keeps growing until the key is forgotten, otherwise the key is forgotten so
+// it does not get queued again until another change happens. It returns
+// whether the key was requeued with backoff.
+func (c *Controller) requeueSynced(key string, result reconcileResult) bool {
+	if result.RequeueAfter <= 0 && result.Requeue {
+		c.workqueue.AddRateLimited(key)
+		return true
+	}
+	c.workqueue.Forget(key)
+	metrics.ForgetRetries(key)
+	if result.RequeueAfter > 0 {
+		c.workqueue.AddAfter(key, result.RequeueAfter)
+	}
+	return false
+}
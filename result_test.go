@@ -0,0 +1,106 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/klog/v2/ktesting"
+
+	"github.com/fortytwoapps/kubelitedb/pkg/metrics"
+)
+
+// forgettingQueue records how often items are forgotten, on top of the
+// requeues recorded by recordingQueue.
+type forgettingQueue struct {
+	recordingQueue
+	forgotten int
+}
+
+func (q *forgettingQueue) Forget(item interface{}) {
+	q.forgotten++
+	q.recordingQueue.Forget(item)
+}
+
+func TestRequeueSynced(t *testing.T) {
+	tests := []struct {
+		name            string
+		result          reconcileResult
+		wantAddedAfter  []time.Duration
+		wantRateLimited int
+		// Whether the key is forgotten, ending its backoff and retries
+		wantForgotten bool
+	}{
+		{name: "zero value", wantForgotten: true},
+		{
+			name:           "requeue after",
+			result:         reconcileResult{RequeueAfter: time.Minute},
+			wantAddedAfter: []time.Duration{time.Minute},
+			wantForgotten:  true,
+		},
+		{
+			name:            "requeue",
+			result:          reconcileResult{Requeue: true},
+			wantRateLimited: 1,
+		},
+		{
+			name:           "requeue after takes precedence",
+			result:         reconcileResult{Requeue: true, RequeueAfter: time.Second},
+			wantAddedAfter: []time.Duration{time.Second},
+			wantForgotten:  true,
+		},
+		{
+			name:            "negative requeue after",
+			result:          reconcileResult{Requeue: true, RequeueAfter: -time.Second},
+			wantRateLimited: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			c, _, _ := f.newController(ctx)
+			defer c.workqueue.ShutDown()
+			queue := &forgettingQueue{recordingQueue: recordingQueue{RateLimitingInterface: c.workqueue}}
+			c.workqueue = queue
+
+			key := "result/" + strings.ReplaceAll(tt.name, " ", "-")
+			metrics.AddRetry(key)
+			requeued := c.requeueSynced(key, tt.result)
+
+			if requeued != (tt.wantRateLimited > 0) {
+				t.Errorf("expected requeued with backoff to be %t, got %t", tt.wantRateLimited > 0, requeued)
+			}
+			if !slices.Equal(queue.addedAfter, tt.wantAddedAfter) {
+				t.Errorf("expected the key to be added after %v, got %v", tt.wantAddedAfter, queue.addedAfter)
+			}
+			if queue.rateLimited != tt.wantRateLimited {
+				t.Errorf("expected the key to be rate limited %d times, got %d", tt.wantRateLimited, queue.rateLimited)
+			}
+			if forgotten := queue.forgotten > 0; forgotten != tt.wantForgotten {
+				t.Errorf("expected forgotten to be %t, got %t", tt.wantForgotten, forgotten)
+			}
+			namespace, name, _ := strings.Cut(key, "/")
+			if retried := metrics.ReconcileRetries.DeleteLabelValues(namespace, name); retried == tt.wantForgotten {
+				t.Errorf("expected the retries of the key to be kept to be %t, got %t", !tt.wantForgotten, retried)
+			}
+		})
+	}
+}
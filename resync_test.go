@@ -0,0 +1,147 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// enqueueRecorder records which keys are put onto the wrapped work queue
+// immediately and which after a delay. Keys are added from the informer
+// goroutines, so the records are guarded by a mutex.
+type enqueueRecorder struct {
+	workqueue.RateLimitingInterface
+
+	mu      sync.Mutex
+	added   []string
+	delayed map[string]time.Duration
+}
+
+func newEnqueueRecorder(queue workqueue.RateLimitingInterface) *enqueueRecorder {
+	return &enqueueRecorder{RateLimitingInterface: queue, delayed: map[string]time.Duration{}}
+}
+
+func (q *enqueueRecorder) Add(item interface{}) {
+	q.mu.Lock()
+	q.added = append(q.added, item.(string))
+	q.mu.Unlock()
+	q.RateLimitingInterface.Add(item)
+}
+
+func (q *enqueueRecorder) AddAfter(item interface{}, duration time.Duration) {
+	q.mu.Lock()
+	q.delayed[item.(string)] = duration
+	q.mu.Unlock()
+	q.RateLimitingInterface.AddAfter(item, duration)
+}
+
+// recorded returns the keys added immediately and the delays of the keys
+// added after a delay.
+func (q *enqueueRecorder) recorded() ([]string, map[string]time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delayed := make(map[string]time.Duration, len(q.delayed))
+	for key, duration := range q.delayed {
+		delayed[key] = duration
+	}
+	return append([]string(nil), q.added...), delayed
+}
+
+func TestResyncJitter(t *testing.T) {
+	tests := []struct {
+		name         string
+		resyncJitter time.Duration
+		wantDelayed  bool
+	}{
+		{name: "jitter", resyncJitter: time.Minute, wantDelayed: true},
+		{name: "no jitter"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			f := newFixture(t)
+			f.resyncJitter = tt.resyncJitter
+			var instances []*kubelitedbv1.SQLiteInstance
+			for _, name := range []string{"a", "b", "c"} {
+				instance := newSQLiteInstance(name)
+				instance.ResourceVersion = "1"
+				instances = append(instances, instance)
+				f.addInstance(instance)
+			}
+			c, i, _ := f.newController(ctx)
+			queue := newEnqueueRecorder(c.workqueue)
+			c.workqueue = queue
+			defer queue.ShutDown()
+
+			// The initial list is delivered as a resync of the prepopulated
+			// cache, without changing the resource versions
+			i.Start(ctx.Done())
+			i.WaitForCacheSync(ctx.Done())
+			err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+				added, delayed := queue.recorded()
+				return len(added)+len(delayed) >= len(instances), nil
+			})
+			if err != nil {
+				t.Fatalf("timed out waiting for the resync to be queued")
+			}
+			added, delayed := queue.recorded()
+			if tt.wantDelayed {
+				if len(added) != 0 {
+					t.Errorf("expected no resync to be queued immediately, got %v", added)
+				}
+				for _, instance := range instances {
+					duration, ok := delayed[getKey(instance, t)]
+					if !ok || duration < 0 || duration >= tt.resyncJitter {
+						t.Errorf("expected %s to be delayed by less than %s, got %s (queued %t)", instance.Name, tt.resyncJitter, duration, ok)
+					}
+				}
+			} else if len(added) != len(instances) || len(delayed) != 0 {
+				t.Errorf("expected every resync to be queued immediately, got %v and delayed %v", added, delayed)
+			}
+
+			// An edit is reconciled immediately, whatever the jitter
+			edited := instances[0].DeepCopy()
+			edited.ResourceVersion, edited.Generation = "2", 2
+			edited.Spec.Storage = "2Gi"
+			if _, err := f.client.KubelitedbV1().SQLiteInstances(edited.Namespace).Update(ctx, edited, v1.UpdateOptions{}); err != nil {
+				t.Fatalf("error updating the spec: %v", err)
+			}
+			err = wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+				got, _ := queue.recorded()
+				return len(got) > len(added), nil
+			})
+			if err != nil {
+				t.Fatalf("timed out waiting for the edit to be queued")
+			}
+			if got, _ := queue.recorded(); got[len(got)-1] != getKey(edited, t) {
+				t.Errorf("expected %s to be queued immediately, got %v", edited.Name, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+const (
+	// ReasonRolloutInProgress is used as the condition reason while the pods
+	// of the StatefulSet are being updated or are not ready yet
+	ReasonRolloutInProgress = "RolloutInProgress"
+	// ReasonRolloutComplete is used as the condition reason once every pod of
+	// the StatefulSet is updated and ready
+	ReasonRolloutComplete = "RolloutComplete"
+	// ReasonProgressDeadlineExceeded is used as the condition reason when a
+	// rollout did not complete within the progress deadline
+	ReasonProgressDeadlineExceeded = "ProgressDeadlineExceeded"
+
+	// MessageRolloutInProgress is the message used while the StatefulSet is
+	// being rolled out
+	MessageRolloutInProgress = "StatefulSet %q is being rolled out, %d of %d pods updated and %d ready"
+	// MessageProgressDeadlineExceeded is the message used when a rollout did
+	// not complete within the progress deadline
+	MessageProgressDeadlineExceeded = "StatefulSet %q did not finish rolling out within %s, %d of %d pods updated and %d ready"
+)
+
+// rolloutComplete returns whether every pod of the StatefulSet the update
+// strategy rolls out runs the latest revision and is ready. Pods below the
+// partition, and every pod with the OnDelete strategy, are only replaced
+// when deleted, so their revision is not compared.
+func rolloutComplete(statefulSet *appsv1.StatefulSet, replicas int32) bool {
+	if statefulSet.Status.ObservedGeneration < statefulSet.Generation || statefulSet.Status.ReadyReplicas < replicas {
+		return false
+	}
+	strategy := statefulSet.Spec.UpdateStrategy
+	if strategy.Type == appsv1.OnDeleteStatefulSetStrategyType {
+		return true
+	}
+	if partition := partitionOf(strategy); partition > 0 {
+		return statefulSet.Status.UpdatedReplicas >= replicas-partition
+	}
+	return statefulSet.Status.UpdatedReplicas >= replicas && statefulSet.Status.CurrentRevision == statefulSet.Status.UpdateRevision
+}
+
+// setRolloutCondition sets the Progressing condition from the rollout of the
+// StatefulSet, and returns how long until the rollout exceeds deadline. A
+// rollout that started longer than deadline ago is reported as stalled until
+// it completes or the StatefulSet is updated again, which updated says it
+// just was. No deadline applies when it is 0.
+func setRolloutCondition(status *kubelitedbv1.SQLiteInstanceStatus, instance *kubelitedbv1.SQLiteInstance, statefulSet *appsv1.StatefulSet, replicas int32, updated bool, deadline time.Duration, now time.Time) time.Duration {
+	if !updated && rolloutComplete(statefulSet, replicas) {
+		setCondition(status, instance, kubelitedbv1.ConditionProgressing, v1.ConditionFalse, ReasonRolloutComplete, "")
+		return 0
+	}
+
+	updatedReplicas, readyReplicas := statefulSet.Status.UpdatedReplicas, statefulSet.Status.ReadyReplicas
+	if previous := meta.FindStatusCondition(status.Conditions, kubelitedbv1.ConditionProgressing); !updated && previous != nil && deadline > 0 {
+		stalled := previous.Reason == ReasonProgressDeadlineExceeded ||
+			(previous.Status == v1.ConditionTrue && now.Sub(previous.LastTransitionTime.Time) >= deadline)
+		if stalled {
+			msg := fmt.Sprintf(MessageProgressDeadlineExceeded, statefulSet.Name, deadline, updatedReplicas, replicas, readyReplicas)
+			setCondition(status, instance, kubelitedbv1.ConditionProgressing, v1.ConditionFalse, ReasonProgressDeadlineExceeded, msg)
+			return 0
+		}
+	}
+
+	msg := fmt.Sprintf(MessageRolloutInProgress, statefulSet.Name, updatedReplicas, replicas, readyReplicas)
+	setCondition(status, instance, kubelitedbv1.ConditionProgressing, v1.ConditionTrue, ReasonRolloutInProgress, msg)
+	if deadline <= 0 {
+		return 0
+	}
+	started := meta.FindStatusCondition(status.Conditions, kubelitedbv1.ConditionProgressing).LastTransitionTime
+	return started.Add(deadline).Sub(now)
+}
+
+// soonest returns the shorter of two requeue delays, where 0 means no requeue.
+func soonest(a, b time.Duration) time.Duration {
+	if a <= 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
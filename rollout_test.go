@@ -0,0 +1,274 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// rolloutStatus returns a StatefulSet of 3 replicas with the given rollout
+// status, observed at its generation.
+func rolloutStatus(updated, ready int32, currentRevision, updateRevision string) *appsv1.StatefulSet {
+	statefulSet := newStatefulSet(newSQLiteInstance("test"))
+	statefulSet.Generation = 2
+	statefulSet.Status = appsv1.StatefulSetStatus{
+		ObservedGeneration: 2,
+		Replicas:           3,
+		UpdatedReplicas:    updated,
+		ReadyReplicas:      ready,
+		CurrentRevision:    currentRevision,
+		UpdateRevision:     updateRevision,
+	}
+	return statefulSet
+}
+
+func TestRolloutComplete(t *testing.T) {
+	tests := []struct {
+		name        string
+		statefulSet *appsv1.StatefulSet
+		want        bool
+	}{
+		{name: "complete", statefulSet: rolloutStatus(3, 3, "v2", "v2"), want: true},
+		{name: "pods updating", statefulSet: rolloutStatus(1, 3, "v1", "v2")},
+		{name: "pods updated, revision not current yet", statefulSet: rolloutStatus(3, 3, "v1", "v2")},
+		{name: "pods not ready", statefulSet: rolloutStatus(3, 2, "v2", "v2")},
+		{
+			name: "spec not observed yet",
+			statefulSet: func() *appsv1.StatefulSet {
+				statefulSet := rolloutStatus(3, 3, "v2", "v2")
+				statefulSet.Generation = 3
+				return statefulSet
+			}(),
+		},
+		{
+			name: "partition rolled out",
+			statefulSet: func() *appsv1.StatefulSet {
+				statefulSet := rolloutStatus(1, 3, "v1", "v2")
+				statefulSet.Spec.UpdateStrategy = rollingUpdate(2)
+				return statefulSet
+			}(),
+			want: true,
+		},
+		{
+			name: "partition rolling out",
+			statefulSet: func() *appsv1.StatefulSet {
+				statefulSet := rolloutStatus(0, 3, "v1", "v2")
+				statefulSet.Spec.UpdateStrategy = rollingUpdate(2)
+				return statefulSet
+			}(),
+		},
+		{
+			name: "OnDelete",
+			statefulSet: func() *appsv1.StatefulSet {
+				statefulSet := rolloutStatus(0, 3, "v1", "v2")
+				statefulSet.Spec.UpdateStrategy = appsv1.StatefulSetUpdateStrategy{Type: appsv1.OnDeleteStatefulSetStrategyType}
+				return statefulSet
+			}(),
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rolloutComplete(tt.statefulSet, 3); got != tt.want {
+				t.Errorf("expected rollout complete %t, got %t", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSetRolloutCondition(t *testing.T) {
+	now := time.Now()
+	deadline := 10 * time.Minute
+	progressingSince := func(ago time.Duration) *v1.Condition {
+		return &v1.Condition{
+			Type:               kubelitedbv1.ConditionProgressing,
+			Status:             v1.ConditionTrue,
+			Reason:             ReasonRolloutInProgress,
+			LastTransitionTime: v1.NewTime(now.Add(-ago)),
+		}
+	}
+	stalledSince := func(ago time.Duration) *v1.Condition {
+		return &v1.Condition{
+			Type:               kubelitedbv1.ConditionProgressing,
+			Status:             v1.ConditionFalse,
+			Reason:             ReasonProgressDeadlineExceeded,
+			LastTransitionTime: v1.NewTime(now.Add(-ago)),
+		}
+	}
+	tests := []struct {
+		name        string
+		previous    *v1.Condition
+		statefulSet *appsv1.StatefulSet
+		updated     bool
+		deadline    time.Duration
+		status      v1.ConditionStatus
+		reason      string
+		// requeue is how long until the deadline is checked again, within a
+		// second
+		requeue time.Duration
+	}{
+		{
+			name:        "in progress",
+			statefulSet: rolloutStatus(1, 3, "v1", "v2"),
+			deadline:    deadline,
+			status:      v1.ConditionTrue,
+			reason:      ReasonRolloutInProgress,
+			requeue:     deadline,
+		},
+		{
+			name:        "in progress for a while",
+			previous:    progressingSince(4 * time.Minute),
+			statefulSet: rolloutStatus(2, 3, "v1", "v2"),
+			deadline:    deadline,
+			status:      v1.ConditionTrue,
+			reason:      ReasonRolloutInProgress,
+			requeue:     6 * time.Minute,
+		},
+		{
+			name:        "complete",
+			previous:    progressingSince(4 * time.Minute),
+			statefulSet: rolloutStatus(3, 3, "v2", "v2"),
+			deadline:    deadline,
+			status:      v1.ConditionFalse,
+			reason:      ReasonRolloutComplete,
+		},
+		{
+			name:        "just updated",
+			statefulSet: rolloutStatus(3, 3, "v2", "v2"),
+			updated:     true,
+			deadline:    deadline,
+			status:      v1.ConditionTrue,
+			reason:      ReasonRolloutInProgress,
+			requeue:     deadline,
+		},
+		{
+			name:        "stalled",
+			previous:    progressingSince(11 * time.Minute),
+			statefulSet: rolloutStatus(1, 2, "v1", "v2"),
+			deadline:    deadline,
+			status:      v1.ConditionFalse,
+			reason:      ReasonProgressDeadlineExceeded,
+		},
+		{
+			name:        "stays stalled",
+			previous:    stalledSince(time.Minute),
+			statefulSet: rolloutStatus(1, 2, "v1", "v2"),
+			deadline:    deadline,
+			status:      v1.ConditionFalse,
+			reason:      ReasonProgressDeadlineExceeded,
+		},
+		{
+			name:        "stalled rollout completing",
+			previous:    stalledSince(time.Minute),
+			statefulSet: rolloutStatus(3, 3, "v2", "v2"),
+			deadline:    deadline,
+			status:      v1.ConditionFalse,
+			reason:      ReasonRolloutComplete,
+		},
+		{
+			name:        "stalled rollout updated again",
+			previous:    stalledSince(time.Hour),
+			statefulSet: rolloutStatus(1, 2, "v1", "v2"),
+			updated:     true,
+			deadline:    deadline,
+			status:      v1.ConditionTrue,
+			reason:      ReasonRolloutInProgress,
+			requeue:     deadline,
+		},
+		{
+			name:        "no deadline",
+			previous:    progressingSince(time.Hour),
+			statefulSet: rolloutStatus(1, 2, "v1", "v2"),
+			status:      v1.ConditionTrue,
+			reason:      ReasonRolloutInProgress,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newSQLiteInstance("test")
+			instance.Spec.Replicas = 3
+			status := instance.Status.DeepCopy()
+			if tt.previous != nil {
+				status.Conditions = []v1.Condition{*tt.previous}
+			}
+
+			requeue := setRolloutCondition(status, instance, tt.statefulSet, 3, tt.updated, tt.deadline, now)
+
+			condition := meta.FindStatusCondition(status.Conditions, kubelitedbv1.ConditionProgressing)
+			if condition == nil || condition.Status != tt.status || condition.Reason != tt.reason {
+				t.Fatalf("expected Progressing %s with reason %s, got %v", tt.status, tt.reason, condition)
+			}
+			if diff := requeue - tt.requeue; diff < -time.Second || diff > time.Second {
+				t.Errorf("expected the deadline to be checked in %s, got %s", tt.requeue, requeue)
+			}
+		})
+	}
+}
+
+func TestSoonest(t *testing.T) {
+	tests := []struct {
+		a, b time.Duration
+		want time.Duration
+	}{
+		{a: 0, b: 0, want: 0},
+		{a: time.Minute, b: 0, want: time.Minute},
+		{a: 0, b: time.Minute, want: time.Minute},
+		{a: time.Minute, b: time.Hour, want: time.Minute},
+		{a: time.Hour, b: time.Minute, want: time.Minute},
+	}
+	for _, tt := range tests {
+		if got := soonest(tt.a, tt.b); got != tt.want {
+			t.Errorf("expected soonest(%s, %s) to be %s, got %s", tt.a, tt.b, tt.want, got)
+		}
+	}
+}
+
+func TestRolloutStalledSync(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	instance.Status.Conditions = []v1.Condition{{
+		Type:               kubelitedbv1.ConditionProgressing,
+		Status:             v1.ConditionTrue,
+		Reason:             ReasonRolloutInProgress,
+		LastTransitionTime: v1.NewTime(time.Now().Add(-time.Hour)),
+	}}
+	// The new pod never gets ready
+	statefulSet := newStatefulSet(instance)
+	statefulSet.Status.Replicas = 1
+	statefulSet.Status.UpdatedReplicas = 1
+	statefulSet.Status.CurrentRevision = "v1"
+	statefulSet.Status.UpdateRevision = "v2"
+	f.addKubeObject(statefulSet)
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+
+	f.run(ctx, c, getKey(instance, t))
+
+	condition := meta.FindStatusCondition(f.getInstance(ctx, instance).Status.Conditions, kubelitedbv1.ConditionProgressing)
+	if condition == nil || condition.Status != v1.ConditionFalse || condition.Reason != ReasonProgressDeadlineExceeded {
+		t.Errorf("expected Progressing False with reason %s, got %v", ReasonProgressDeadlineExceeded, condition)
+	}
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2/ktesting"
+)
+
+func TestScaleStatus(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	statefulSet := newStatefulSet(instance)
+	statefulSet.Status.Replicas = 1
+	statefulSet.Status.ReadyReplicas = 1
+	statefulSet.Status.UpdatedReplicas = 1
+	f.addKubeObject(statefulSet)
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+
+	f.run(ctx, c, getKey(instance, t))
+
+	got := f.getInstance(ctx, instance)
+	if got.Status.Replicas != 1 {
+		t.Errorf("expected 1 replica in the status, got %d", got.Status.Replicas)
+	}
+	// kubectl scale and the HPA find the pods of the SQLiteInstance with the selector
+	selector, err := labels.Parse(got.Status.Selector)
+	if err != nil {
+		t.Fatalf("error parsing the selector %q: %v", got.Status.Selector, err)
+	}
+	if want := v1.FormatLabelSelector(statefulSet.Spec.Selector); got.Status.Selector != want {
+		t.Errorf("expected the selector %q, got %q", want, got.Status.Selector)
+	}
+	if !selector.Matches(labels.Set(statefulSet.Spec.Template.Labels)) {
+		t.Errorf("expected the selector %q to match the pods %v", got.Status.Selector, statefulSet.Spec.Template.Labels)
+	}
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// defaultRunAsUser is the non-root user and group the pods run as by default.
+// The data volume is owned by the group, so the database stays writable.
+const defaultRunAsUser = 65532
+
+// readOnlyRootFilesystemContainers are the containers known not to write
+// outside of their volumes, so their root filesystem is read-only by default.
+// SQLite may write temporary files, so the containers running it are left out.
+var readOnlyRootFilesystemContainers = []string{litestreamContainerName, "tls-proxy"}
+
+// podSecurityContextForInstance returns the security context of the pods of
+// the SQLiteInstance, spec.podSecurityContext or the restricted default.
+func podSecurityContextForInstance(instance *kubelitedbv1.SQLiteInstance) *corev1.PodSecurityContext {
+	if instance.Spec.PodSecurityContext != nil {
+		return instance.Spec.PodSecurityContext.DeepCopy()
+	}
+	runAsNonRoot := true
+	user := int64(defaultRunAsUser)
+	fsGroupChangePolicy := corev1.FSGroupChangeOnRootMismatch
+	return &corev1.PodSecurityContext{
+		RunAsNonRoot:        &runAsNonRoot,
+		RunAsUser:           &user,
+		RunAsGroup:          &user,
+		FSGroup:             &user,
+		FSGroupChangePolicy: &fsGroupChangePolicy,
+		SeccompProfile: &corev1.SeccompProfile{
+			Type: corev1.SeccompProfileTypeRuntimeDefault,
+		},
+	}
+}
+
+// securityContextForContainer returns the security context of the named
+// container of the pods of the SQLiteInstance, spec.securityContext or the
+// restricted default.
+func securityContextForContainer(instance *kubelitedbv1.SQLiteInstance, name string) *corev1.SecurityContext {
+	if instance.Spec.SecurityContext != nil {
+		return instance.Spec.SecurityContext.DeepCopy()
+	}
+	allowPrivilegeEscalation := false
+	runAsNonRoot := true
+	readOnlyRootFilesystem := slices.Contains(readOnlyRootFilesystemContainers, name)
+	return &corev1.SecurityContext{
+		AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+		RunAsNonRoot:             &runAsNonRoot,
+		ReadOnlyRootFilesystem:   &readOnlyRootFilesystem,
+		Capabilities: &corev1.Capabilities{
+			Drop: []corev1.Capability{"ALL"},
+		},
+	}
+}
+
+// setSecurityContexts sets the security contexts of the SQLiteInstance on the
+// pod spec and every container and init container of it.
+func setSecurityContexts(instance *kubelitedbv1.SQLiteInstance, spec *corev1.PodSpec) {
+	spec.SecurityContext = podSecurityContextForInstance(instance)
+	for i := range spec.InitContainers {
+		spec.InitContainers[i].SecurityContext = securityContextForContainer(instance, spec.InitContainers[i].Name)
+	}
+	for i := range spec.Containers {
+		spec.Containers[i].SecurityContext = securityContextForContainer(instance, spec.Containers[i].Name)
+	}
+}
+
+// addSidecars adds the sidecars and volumes from the spec to the pod spec.
+// It runs after setSecurityContexts, so the security context of a sidecar is
+// only defaulted when it has none.
+func addSidecars(instance *kubelitedbv1.SQLiteInstance, spec *corev1.PodSpec) {
+	for _, sidecar := range instance.Spec.Sidecars {
+		sidecar := *sidecar.DeepCopy()
+		if sidecar.SecurityContext == nil {
+			sidecar.SecurityContext = securityContextForContainer(instance, sidecar.Name)
+		}
+		spec.Containers = append(spec.Containers, sidecar)
+	}
+	for _, volume := range instance.Spec.Volumes {
+		spec.Volumes = append(spec.Volumes, *volume.DeepCopy())
+	}
+}
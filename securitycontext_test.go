@@ -0,0 +1,217 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"slices"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// restrictedViolations returns how the pod spec breaks the restricted Pod
+// Security Standard, checking the controls that apply to the pods the
+// controller creates.
+func restrictedViolations(spec *corev1.PodSpec) []string {
+	var violations []string
+	if spec.HostNetwork || spec.HostPID || spec.HostIPC {
+		violations = append(violations, "host namespaces")
+	}
+	for _, volume := range spec.Volumes {
+		if volume.HostPath != nil {
+			violations = append(violations, "hostPath volume "+volume.Name)
+		}
+	}
+	pod := spec.SecurityContext
+	if pod == nil {
+		pod = &corev1.PodSecurityContext{}
+	}
+	for _, c := range slices.Concat(spec.InitContainers, spec.Containers) {
+		sc := c.SecurityContext
+		if sc == nil {
+			sc = &corev1.SecurityContext{}
+		}
+		if sc.Privileged != nil && *sc.Privileged {
+			violations = append(violations, c.Name+" privileged")
+		}
+		if sc.AllowPrivilegeEscalation == nil || *sc.AllowPrivilegeEscalation {
+			violations = append(violations, c.Name+" allows privilege escalation")
+		}
+		if sc.Capabilities == nil || !slices.Contains(sc.Capabilities.Drop, "ALL") {
+			violations = append(violations, c.Name+" keeps capabilities")
+		} else if slices.ContainsFunc(sc.Capabilities.Add, func(capability corev1.Capability) bool { return capability != "NET_BIND_SERVICE" }) {
+			violations = append(violations, c.Name+" adds capabilities")
+		}
+		runAsNonRoot := sc.RunAsNonRoot
+		if runAsNonRoot == nil {
+			runAsNonRoot = pod.RunAsNonRoot
+		}
+		if runAsNonRoot == nil || !*runAsNonRoot {
+			violations = append(violations, c.Name+" may run as root")
+		}
+		if (sc.RunAsUser != nil && *sc.RunAsUser == 0) || (sc.RunAsUser == nil && pod.RunAsUser != nil && *pod.RunAsUser == 0) {
+			violations = append(violations, c.Name+" runs as root")
+		}
+		seccomp := sc.SeccompProfile
+		if seccomp == nil {
+			seccomp = pod.SeccompProfile
+		}
+		if seccomp == nil || (seccomp.Type != corev1.SeccompProfileTypeRuntimeDefault && seccomp.Type != corev1.SeccompProfileTypeLocalhost) {
+			violations = append(violations, c.Name+" has no seccomp profile")
+		}
+	}
+	return violations
+}
+
+func TestRestrictedPodSecurity(t *testing.T) {
+	instance := newReplicatedSQLiteInstance("test")
+	instance.Spec.TLS = &kubelitedbv1.TLSSpec{SecretRef: corev1.LocalObjectReference{Name: "db-tls"}, Port: 5432}
+	instance.Spec.ReadReplicas = 1
+	tests := []struct {
+		name string
+		spec corev1.PodSpec
+	}{
+		{name: "StatefulSet", spec: newStatefulSet(instance).Spec.Template.Spec},
+		{name: "readers", spec: newReaderDeployment(instance).Spec.Template.Spec},
+		{name: "backup", spec: newBackupJob(newSQLiteBackup("nightly", instance.Name), instance).Spec.Template.Spec},
+		{name: "clone", spec: newCloneJob(instance, newSQLiteInstance("source")).Spec.Template.Spec},
+		{name: "cleanup", spec: newCleanupJob(instance).Spec.Template.Spec},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if violations := restrictedViolations(&tt.spec); len(violations) != 0 {
+				t.Errorf("expected the pods to meet the restricted policy, got %v", violations)
+			}
+		})
+	}
+}
+
+func TestRestrictedViolations(t *testing.T) {
+	root := int64(0)
+	// The policy check itself has to reject pods the API server would
+	tests := []struct {
+		name   string
+		mutate func(spec *corev1.PodSpec)
+	}{
+		{name: "no security context", mutate: func(spec *corev1.PodSpec) { spec.SecurityContext = nil }},
+		{name: "root user", mutate: func(spec *corev1.PodSpec) { spec.Containers[0].SecurityContext.RunAsUser = &root }},
+		{name: "capabilities kept", mutate: func(spec *corev1.PodSpec) { spec.Containers[0].SecurityContext.Capabilities = nil }},
+		{name: "host network", mutate: func(spec *corev1.PodSpec) { spec.HostNetwork = true }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := newStatefulSet(newSQLiteInstance("test")).Spec.Template.Spec
+			tt.mutate(&spec)
+
+			if violations := restrictedViolations(&spec); len(violations) == 0 {
+				t.Errorf("expected the pods to break the restricted policy")
+			}
+		})
+	}
+}
+
+func TestSecurityContextDefaults(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newReplicatedSQLiteInstance("test")
+	instance.Spec.TLS = &kubelitedbv1.TLSSpec{SecretRef: corev1.LocalObjectReference{Name: "db-tls"}, Port: 5432}
+	f.addInstance(instance)
+	f.addKubeObject(newTLSSecret("cert"))
+	c, _, _ := f.newController(ctx)
+
+	f.run(ctx, c, getKey(instance, t))
+
+	spec := f.getStatefulSet(ctx, instance).Spec.Template.Spec
+	pod := spec.SecurityContext
+	if pod == nil || pod.RunAsUser == nil || *pod.RunAsUser != defaultRunAsUser || pod.FSGroup == nil || *pod.FSGroup != defaultRunAsUser {
+		t.Fatalf("expected the pods to run as %d with the data volume owned by the group, got %+v", defaultRunAsUser, pod)
+	}
+	tests := []struct {
+		container    string
+		readOnlyRoot bool
+	}{
+		{container: "sqlite"},
+		{container: litestreamContainerName, readOnlyRoot: true},
+		{container: "tls-proxy", readOnlyRoot: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.container, func(t *testing.T) {
+			sc := container(t, spec.Containers, tt.container).SecurityContext
+			if sc == nil || sc.ReadOnlyRootFilesystem == nil || *sc.ReadOnlyRootFilesystem != tt.readOnlyRoot {
+				t.Errorf("expected a read-only root filesystem %t, got %+v", tt.readOnlyRoot, sc)
+			}
+		})
+	}
+	// The database is written to the data volume whatever the root filesystem
+	for _, mount := range container(t, spec.Containers, "sqlite").VolumeMounts {
+		if mount.Name == dataVolumeName && mount.ReadOnly {
+			t.Errorf("expected the data volume to be writable")
+		}
+	}
+}
+
+func TestSecurityContextOverride(t *testing.T) {
+	user := int64(1000)
+	privileged := false
+	podSecurityContext := &corev1.PodSecurityContext{RunAsUser: &user, FSGroup: &user}
+	securityContext := &corev1.SecurityContext{Privileged: &privileged}
+	instance := newReplicatedSQLiteInstance("test")
+	instance.Spec.PodSecurityContext = podSecurityContext
+	instance.Spec.SecurityContext = securityContext
+
+	spec := newStatefulSet(instance).Spec.Template.Spec
+
+	if !equality.Semantic.DeepEqual(spec.SecurityContext, podSecurityContext) {
+		t.Errorf("expected the pod security context %+v, got %+v", podSecurityContext, spec.SecurityContext)
+	}
+	for _, c := range slices.Concat(spec.InitContainers, spec.Containers) {
+		if !equality.Semantic.DeepEqual(c.SecurityContext, securityContext) {
+			t.Errorf("expected container %s to have the security context %+v, got %+v", c.Name, securityContext, c.SecurityContext)
+		}
+	}
+	// The spec is copied, not shared with the pod template
+	spec.SecurityContext.RunAsUser = nil
+	if instance.Spec.PodSecurityContext.RunAsUser == nil {
+		t.Errorf("expected the spec not to be modified through the pod template")
+	}
+}
+
+func TestSecurityContextChangeRollsPods(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	old := newStatefulSet(instance)
+	f.addKubeObject(old)
+	user := int64(1000)
+	instance.Spec.PodSecurityContext = &corev1.PodSecurityContext{RunAsUser: &user}
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+
+	f.run(ctx, c, getKey(instance, t))
+
+	sts := f.getStatefulSet(ctx, instance)
+	if got := sts.Spec.Template.Spec.SecurityContext; !equality.Semantic.DeepEqual(got, instance.Spec.PodSecurityContext) {
+		t.Errorf("expected the pod security context %+v, got %+v", instance.Spec.PodSecurityContext, got)
+	}
+	if sts.Spec.Template.Annotations[templateHashAnnotation] == old.Spec.Template.Annotations[templateHashAnnotation] {
+		t.Errorf("expected the template hash to change with the security context")
+	}
+}
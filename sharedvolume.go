@@ -0,0 +1,184 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// sharesVolume returns whether the pods of the SQLiteInstance share a single
+// ReadWriteMany volume, rather than each writer pod getting its own volume from
+// the volume claim template of the StatefulSet.
+func sharesVolume(instance *kubelitedbv1.SQLiteInstance) bool {
+	return instance.Spec.AccessMode == corev1.ReadWriteMany
+}
+
+// statefulSetSharesVolume returns whether the pods of the StatefulSet mount a
+// shared volume, as they have no volume claim template.
+func statefulSetSharesVolume(statefulSet *appsv1.StatefulSet) bool {
+	return len(statefulSet.Spec.VolumeClaimTemplates) == 0
+}
+
+// accessModeForInstance returns the access mode of the database volume of the
+// SQLiteInstance, defaulting to ReadWriteOnce.
+func accessModeForInstance(instance *kubelitedbv1.SQLiteInstance) corev1.PersistentVolumeAccessMode {
+	if sharesVolume(instance) {
+		return corev1.ReadWriteMany
+	}
+	return corev1.ReadWriteOnce
+}
+
+// newDataPVCSpec creates the spec of the PVC holding the database of the
+// SQLiteInstance.
+func newDataPVCSpec(instance *kubelitedbv1.SQLiteInstance) corev1.PersistentVolumeClaimSpec {
+	// syncHandler refuses to build the PVCs for unparseable storage values,
+	// so the error can be ignored here.
+	storage, _ := resource.ParseQuantity(instance.Spec.Storage)
+	return corev1.PersistentVolumeClaimSpec{
+		AccessModes: []corev1.PersistentVolumeAccessMode{
+			accessModeForInstance(instance),
+		},
+		Resources: corev1.VolumeResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceStorage: storage,
+			},
+		},
+		StorageClassName: instance.Spec.StorageClassName,
+	}
+}
+
+// volumeClaimAnnotations returns the annotations to set on the data PVCs of
+// the SQLiteInstance, or nil when there are none.
+func volumeClaimAnnotations(instance *kubelitedbv1.SQLiteInstance) map[string]string {
+	annotations := childAnnotations(instance)
+	for key, value := range instance.Spec.VolumeClaimAnnotations {
+		if strings.HasPrefix(key, reservedPrefix) {
+			continue
+		}
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[key] = value
+	}
+	return annotations
+}
+
+// newDataPVC creates the PVC holding the database of the SQLiteInstance. For
+// instances with their own volume per pod this is the PVC of the first pod,
+// which the StatefulSet adopts when it is created. A shared volume is owned
+// by the SQLiteInstance instead, so it is removed together with it.
+func newDataPVC(instance *kubelitedbv1.SQLiteInstance) *corev1.PersistentVolumeClaim {
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: v1.ObjectMeta{
+			Name:        dataPVCName(instance, 0),
+			Namespace:   instance.Namespace,
+			Labels:      childLabels(instance, labelsForInstance(instance, componentDatabase)),
+			Annotations: volumeClaimAnnotations(instance),
+		},
+		Spec: newDataPVCSpec(instance),
+	}
+	if sharesVolume(instance) {
+		pvc.OwnerReferences = []v1.OwnerReference{newOwnerReference(instance)}
+	}
+	return pvc
+}
+
+// syncSharedDataPVC ensures the shared volume of a SQLiteInstance using
+// ReadWriteMany storage exists, and returns it. It returns nil for instances
+// with a volume per pod. The PVC is only updated by expandDataVolumes, as
+// the rest of its spec can't be changed once provisioned. An existing PVC
+// from spec.volumeClaimName is only looked up, as it is not managed by the
+// controller.
+func (c *Controller) syncSharedDataPVC(ctx context.Context, sqliteInstance *kubelitedbv1.SQLiteInstance) (*corev1.PersistentVolumeClaim, error) {
+	if !sharesVolume(sqliteInstance) {
+		return nil, nil
+	}
+
+	pvcs := c.kubeclientset.CoreV1().PersistentVolumeClaims(sqliteInstance.Namespace)
+	if claimName := sqliteInstance.Spec.VolumeClaimName; claimName != "" {
+		pvc, err := pvcs.Get(ctx, claimName, v1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get PVC %q: %w", claimName, err)
+		}
+		return pvc, nil
+	}
+
+	pvc, err := pvcs.Get(ctx, dataPVCName(sqliteInstance, 0), v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		pvc, err = pvcs.Create(ctx, newDataPVC(sqliteInstance), c.createOptions(ctx, sqliteInstance, "PersistentVolumeClaim", dataPVCName(sqliteInstance, 0)))
+		logWrite(ctx, "create", "PersistentVolumeClaim", dataPVCName(sqliteInstance, 0), err)
+		return pvc, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !v1.IsControlledBy(pvc, sqliteInstance) {
+		msg := fmt.Sprintf(MessageResourceExists, pvc.Name)
+		c.recorder.Event(sqliteInstance, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return nil, fmt.Errorf("%s", msg)
+	}
+	return pvc, nil
+}
+
+// syncDataPVCAnnotations adds the annotations of the SQLiteInstance to its
+// existing data volumes. The volume claim template of the StatefulSet can't
+// be changed, so PVCs created from it only have the annotations it was
+// created with. An existing PVC from spec.volumeClaimName is left as is.
+func (c *Controller) syncDataPVCAnnotations(ctx context.Context, sqliteInstance *kubelitedbv1.SQLiteInstance, replicas int) error {
+	if sqliteInstance.Spec.VolumeClaimName != "" {
+		return nil
+	}
+	desired := &corev1.PersistentVolumeClaim{
+		ObjectMeta: v1.ObjectMeta{Annotations: volumeClaimAnnotations(sqliteInstance)},
+	}
+
+	if sharesVolume(sqliteInstance) {
+		replicas = 1
+	}
+	pvcs := c.kubeclientset.CoreV1().PersistentVolumeClaims(sqliteInstance.Namespace)
+	for ordinal := 0; ordinal < replicas; ordinal++ {
+		pvc, err := c.pvcsLister.PersistentVolumeClaims(sqliteInstance.Namespace).Get(dataPVCName(sqliteInstance, ordinal))
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !metadataOutOfDate(pvc, desired) {
+			continue
+		}
+		pvcCopy := pvc.DeepCopy()
+		mergeMetadata(pvcCopy, desired)
+		_, err = pvcs.Update(ctx, pvcCopy, c.updateOptions(ctx, sqliteInstance, "PersistentVolumeClaim", pvc, pvcCopy))
+		logWrite(ctx, "update", "PersistentVolumeClaim", pvc.Name, err)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"slices"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// newSharedSQLiteInstance returns a SQLiteInstance whose pods share a single
+// ReadWriteMany volume.
+func newSharedSQLiteInstance(name string) *kubelitedbv1.SQLiteInstance {
+	instance := newSQLiteInstance(name)
+	instance.Spec.AccessMode = corev1.ReadWriteMany
+	return instance
+}
+
+func TestAccessMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		instance *kubelitedbv1.SQLiteInstance
+		shared   bool
+	}{
+		{name: "default", instance: newSQLiteInstance("test")},
+		{name: "ReadWriteOnce", instance: func() *kubelitedbv1.SQLiteInstance {
+			instance := newSQLiteInstance("test")
+			instance.Spec.AccessMode = corev1.ReadWriteOnce
+			return instance
+		}()},
+		{name: "ReadWriteMany", instance: newSharedSQLiteInstance("test"), shared: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			f.addInstance(tt.instance)
+			if tt.shared {
+				pvc := newDataPVC(tt.instance)
+				pvc.Status.Phase = corev1.ClaimBound
+				f.addKubeObject(pvc)
+			}
+			c, _, _ := f.newController(ctx)
+
+			f.run(ctx, c, getKey(tt.instance, t))
+
+			sts := f.getStatefulSet(ctx, tt.instance)
+			i := slices.IndexFunc(sts.Spec.Template.Spec.Volumes, func(volume corev1.Volume) bool { return volume.Name == dataVolumeName })
+			if !tt.shared {
+				if len(sts.Spec.VolumeClaimTemplates) != 1 {
+					t.Fatalf("expected a volume claim template, got %v", sts.Spec.VolumeClaimTemplates)
+				}
+				if got := sts.Spec.VolumeClaimTemplates[0].Spec.AccessModes; !slices.Equal(got, []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}) {
+					t.Errorf("expected the volume claim template to request ReadWriteOnce, got %v", got)
+				}
+				if i >= 0 {
+					t.Errorf("expected no shared %s volume in the pod template, got %v", dataVolumeName, sts.Spec.Template.Spec.Volumes[i])
+				}
+				return
+			}
+			if len(sts.Spec.VolumeClaimTemplates) != 0 {
+				t.Errorf("expected no volume claim template, got %v", sts.Spec.VolumeClaimTemplates)
+			}
+			if i < 0 {
+				t.Fatalf("expected a shared %s volume in the pod template, got %v", dataVolumeName, sts.Spec.Template.Spec.Volumes)
+			}
+			if claim := sts.Spec.Template.Spec.Volumes[i].PersistentVolumeClaim; claim == nil || claim.ClaimName != dataPVCName(tt.instance, 0) {
+				t.Errorf("expected the pods to mount the PVC %s, got %v", dataPVCName(tt.instance, 0), sts.Spec.Template.Spec.Volumes[i])
+			}
+		})
+	}
+}
+
+func TestSyncSharedDataPVC(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSharedSQLiteInstance("test")
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+
+	f.run(ctx, c, getKey(instance, t))
+
+	pvc, err := f.kubeclient.CoreV1().PersistentVolumeClaims(instance.Namespace).Get(ctx, dataPVCName(instance, 0), v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the shared PVC to be created: %v", err)
+	}
+	if !slices.Equal(pvc.Spec.AccessModes, []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}) {
+		t.Errorf("expected the shared PVC to request ReadWriteMany, got %v", pvc.Spec.AccessModes)
+	}
+	if !v1.IsControlledBy(pvc, instance) {
+		t.Errorf("expected the shared PVC to be controlled by the SQLiteInstance, got %v", pvc.OwnerReferences)
+	}
+}
+
+func TestSyncSharedDataPVCNotControlled(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSharedSQLiteInstance("test")
+	pvc := newDataPVC(instance)
+	pvc.OwnerReferences = nil
+	f.addKubeObject(pvc)
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+
+	if _, err := c.syncHandler(ctx, getKey(instance, t)); err == nil {
+		t.Errorf("expected an error for a PVC not controlled by the SQLiteInstance")
+	}
+	expectEvent(t, f.recorder, corev1.EventTypeWarning, ErrResourceExists)
+}
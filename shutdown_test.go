@@ -0,0 +1,90 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	core "k8s.io/client-go/testing"
+	"k8s.io/klog/v2/ktesting"
+)
+
+func TestRunShutdownDrain(t *testing.T) {
+	tests := []struct {
+		name            string
+		shutdownTimeout time.Duration
+		// How long the in-flight sync keeps running after the shutdown, or
+		// until Run returned when zero
+		syncDuration  time.Duration
+		wantCompleted bool
+	}{
+		{name: "drained", shutdownTimeout: wait.ForeverTestTimeout, syncDuration: 100 * time.Millisecond, wantCompleted: true},
+		{name: "timed out", shutdownTimeout: 100 * time.Millisecond},
+		{name: "no drain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			f := newFixture(t)
+			instance := newSQLiteInstance("test")
+			f.addInstance(instance)
+			c, _, _ := f.newController(ctx)
+
+			started, release := make(chan struct{}), make(chan struct{})
+			f.kubeclient.PrependReactor("create", "statefulsets", func(action core.Action) (bool, runtime.Object, error) {
+				close(started)
+				<-release
+				return false, nil, nil
+			})
+			c.workqueue.Add(getKey(instance, t))
+			stopped := make(chan error)
+			go func() { stopped <- c.Run(ctx, 1, tt.shutdownTimeout) }()
+
+			select {
+			case <-started:
+			case <-time.After(wait.ForeverTestTimeout):
+				t.Fatalf("timed out waiting for the sync to start")
+			}
+			cancel()
+			if tt.syncDuration > 0 {
+				time.AfterFunc(tt.syncDuration, func() { close(release) })
+			} else {
+				defer close(release)
+			}
+
+			select {
+			case err := <-stopped:
+				if err != nil {
+					t.Fatalf("unexpected error running the controller: %v", err)
+				}
+			case <-time.After(tt.shutdownTimeout + wait.ForeverTestTimeout):
+				t.Fatalf("expected the controller to stop within the shutdown timeout of %v", tt.shutdownTimeout)
+			}
+			// The status is written at the end of the sync
+			completed := f.getInstance(ctx, instance).Status.Phase != ""
+			if completed != tt.wantCompleted {
+				t.Errorf("expected the in-flight sync to complete %t, got %t", tt.wantCompleted, completed)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+	"github.com/fortytwoapps/kubelitedb/pkg/validation"
+)
+
+// newSidecarSQLiteInstance returns a SQLiteInstance with a log shipping
+// sidecar reading from a volume of the spec.
+func newSidecarSQLiteInstance(name string) *kubelitedbv1.SQLiteInstance {
+	instance := newSQLiteInstance(name)
+	instance.Spec.Sidecars = []corev1.Container{{
+		Name:         "log-shipper",
+		Image:        "fluent/fluent-bit:3.0",
+		VolumeMounts: []corev1.VolumeMount{{Name: "fluent-bit-config", MountPath: "/fluent-bit/etc"}},
+	}}
+	instance.Spec.Volumes = []corev1.Volume{{
+		Name: "fluent-bit-config",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "fluent-bit"}},
+		},
+	}}
+	return instance
+}
+
+func TestSidecars(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSidecarSQLiteInstance("test")
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+
+	f.run(ctx, c, getKey(instance, t))
+
+	spec := f.getStatefulSet(ctx, instance).Spec.Template.Spec
+	if last := spec.Containers[len(spec.Containers)-1]; last.Name != "log-shipper" || last.Image != "fluent/fluent-bit:3.0" {
+		t.Errorf("expected the sidecar after the containers of the controller, got %v", last)
+	}
+	if spec.Containers[0].Name != "sqlite" {
+		t.Errorf("expected the SQLite container first, got %s", spec.Containers[0].Name)
+	}
+	if !slices.ContainsFunc(spec.Volumes, func(volume corev1.Volume) bool { return volume.Name == "fluent-bit-config" }) {
+		t.Errorf("expected the volume of the spec in the pods, got %v", spec.Volumes)
+	}
+	sidecar := container(t, spec.Containers, "log-shipper")
+	if sidecar.SecurityContext == nil || sidecar.SecurityContext.AllowPrivilegeEscalation == nil || *sidecar.SecurityContext.AllowPrivilegeEscalation {
+		t.Errorf("expected the sidecar to get the default security context, got %+v", sidecar.SecurityContext)
+	}
+	if violations := restrictedViolations(&spec); len(violations) != 0 {
+		t.Errorf("expected the pods to meet the restricted policy, got %v", violations)
+	}
+}
+
+func TestSidecarSecurityContextKept(t *testing.T) {
+	instance := newSidecarSQLiteInstance("test")
+	readOnly := true
+	instance.Spec.Sidecars[0].SecurityContext = &corev1.SecurityContext{ReadOnlyRootFilesystem: &readOnly}
+
+	spec := newStatefulSet(instance).Spec.Template.Spec
+
+	sc := container(t, spec.Containers, "log-shipper").SecurityContext
+	if sc == nil || sc.Capabilities != nil || sc.ReadOnlyRootFilesystem == nil || !*sc.ReadOnlyRootFilesystem {
+		t.Errorf("expected the security context of the sidecar to be kept, got %+v", sc)
+	}
+	if instance.Spec.Sidecars[0].SecurityContext.Capabilities != nil {
+		t.Errorf("expected the spec not to be modified")
+	}
+}
+
+func TestSidecarChangeRollsPods(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	old := newStatefulSet(instance)
+	f.addKubeObject(old)
+	instance = newSidecarSQLiteInstance("test")
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+
+	f.run(ctx, c, getKey(instance, t))
+
+	sts := f.getStatefulSet(ctx, instance)
+	if len(sts.Spec.Template.Spec.Containers) != len(old.Spec.Template.Spec.Containers)+1 {
+		t.Errorf("expected the sidecar to be added, got %v", sts.Spec.Template.Spec.Containers)
+	}
+	if sts.Spec.Template.Annotations[templateHashAnnotation] == old.Spec.Template.Annotations[templateHashAnnotation] {
+		t.Errorf("expected the template hash to change with the sidecars")
+	}
+}
+
+// TestSidecarNamesReserved checks every container and volume the controller
+// adds to the pods can't be shadowed by a sidecar or volume of the spec.
+func TestSidecarNamesReserved(t *testing.T) {
+	instance := newReplicatedSQLiteInstance("test")
+	instance.Spec.TLS = &kubelitedbv1.TLSSpec{SecretRef: corev1.LocalObjectReference{Name: "db-tls"}, Port: 5432}
+	instance.Spec.InitSQL = &kubelitedbv1.InitSQLSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "schema"}, Key: "schema.sql"}}
+	instance.Spec.Pragmas = map[string]string{"journal_mode": "wal"}
+	instance.Spec.RestoreFrom = newRestoringSQLiteInstance("test").Spec.RestoreFrom
+	instance.Spec.WAL = &kubelitedbv1.WALSpec{CheckpointInterval: &v1.Duration{Duration: time.Minute}}
+	instance.Spec.TempDir = &kubelitedbv1.TempDirSpec{}
+	statefulSet := newStatefulSet(instance)
+	spec := statefulSet.Spec.Template.Spec
+	volumes := slices.Clone(spec.Volumes)
+	for _, claim := range statefulSet.Spec.VolumeClaimTemplates {
+		volumes = append(volumes, corev1.Volume{Name: claim.Name})
+	}
+
+	for _, c := range slices.Concat(spec.InitContainers, spec.Containers) {
+		t.Run("container "+c.Name, func(t *testing.T) {
+			errs := validation.ValidateSidecars([]corev1.Container{{Name: c.Name, Image: "busybox"}}, nil, field.NewPath("spec"))
+			if len(errs) != 1 || errs[0].Type != field.ErrorTypeForbidden {
+				t.Errorf("expected a sidecar named %s to be forbidden, got %v", c.Name, errs)
+			}
+		})
+	}
+	for _, volume := range volumes {
+		t.Run("volume "+volume.Name, func(t *testing.T) {
+			errs := validation.ValidateSidecars(nil, []corev1.Volume{{Name: volume.Name}}, field.NewPath("spec"))
+			if len(errs) != 1 || errs[0].Type != field.ErrorTypeForbidden {
+				t.Errorf("expected a volume named %s to be forbidden, got %v", volume.Name, errs)
+			}
+		})
+	}
+}
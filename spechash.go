@@ -0,0 +1,254 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// fullReconcileInterval is how long the child objects of a SQLiteInstance
+// reconciled from the same spec are trusted to be in sync, before they are
+// compared again to catch changes the controller isn't notified of, such as a
+// rotated TLS certificate.
+const fullReconcileInterval = 10 * time.Minute
+
+// fullReconcile records the spec hash a SQLiteInstance was last fully
+// reconciled from, and when
+type fullReconcile struct {
+	specHash string
+	at       time.Time
+}
+
+// specHash returns the hash of everything of the SQLiteInstance its child
+// objects are generated from: the spec, the labels and annotations copied to
+// them, and whether the database was restored already.
+func specHash(instance *kubelitedbv1.SQLiteInstance) string {
+	return computeHash(struct {
+		Spec        kubelitedbv1.SQLiteInstanceSpec
+		Labels      map[string]string
+		Annotations map[string]string
+		Restored    bool
+	}{instance.Spec, instance.Labels, instance.Annotations, instance.Status.Restored})
+}
+
+// recordFullReconcile records that the child objects of the SQLiteInstance
+// with the given key were reconciled from the spec with the given hash.
+func (c *Controller) recordFullReconcile(key, hash string, now time.Time) {
+	c.fullReconcilesMu.Lock()
+	defer c.fullReconcilesMu.Unlock()
+	c.fullReconciles[key] = fullReconcile{specHash: hash, at: now}
+}
+
+// forgetFullReconcile drops the record of the last full reconcile of a
+// deleted SQLiteInstance.
+func (c *Controller) forgetFullReconcile(key string) {
+	c.fullReconcilesMu.Lock()
+	defer c.fullReconcilesMu.Unlock()
+	delete(c.fullReconciles, key)
+}
+
+// inSync returns whether the reconcile of the SQLiteInstance can be skipped:
+// it was fully reconciled from the same spec recently, it is ready, every
+// child object is as the reconcile leaves it, and its StatefulSet and reader
+// Deployment are rolled out with the status the SQLiteInstance recorded, so
+// a reconcile would neither change a child object nor the status. It also
+// returns when to reconcile again for the BackupStale condition to flip.
+func (c *Controller) inSync(key string, sqliteInstance *kubelitedbv1.SQLiteInstance, now time.Time) (time.Duration, bool) {
+	if daemonSetMode(sqliteInstance) || c.statusFromUnwatchedObjects(sqliteInstance) {
+		return 0, false
+	}
+	status := sqliteInstance.Status
+	hash := specHash(sqliteInstance)
+	if status.ObservedGeneration != sqliteInstance.Generation || status.ObservedSpecHash != hash || status.LastError != nil {
+		return 0, false
+	}
+	c.fullReconcilesMu.Lock()
+	last, ok := c.fullReconciles[key]
+	c.fullReconcilesMu.Unlock()
+	if !ok || last.specHash != hash || now.Sub(last.at) >= fullReconcileInterval {
+		return 0, false
+	}
+	if !meta.IsStatusConditionTrue(status.Conditions, kubelitedbv1.ConditionReady) ||
+		meta.FindStatusCondition(status.Conditions, kubelitedbv1.ConditionPaused) != nil ||
+		meta.FindStatusCondition(status.Conditions, kubelitedbv1.ConditionDeferredUpdate) != nil {
+		return 0, false
+	}
+
+	statefulSet, err := c.statefulSetsLister.StatefulSets(sqliteInstance.Namespace).Get(statefulSetName(sqliteInstance))
+	if err != nil || !v1.IsControlledBy(statefulSet, sqliteInstance) {
+		return 0, false
+	}
+	// The pod template is compared through its hash, so that edits of the
+	// StatefulSet and changes of the defaults of the controller are rolled
+	// out. The certificate is only watched through its Secret.
+	desired := newStatefulSet(sqliteInstance)
+	setTLSSecretHash(&desired.Spec.Template, statefulSet.Spec.Template.Annotations[tlsSecretHashAnnotation])
+	if statefulSetOutOfDate(statefulSet, desired) {
+		return 0, false
+	}
+	stsStatus := statefulSet.Status
+	if stsStatus.ObservedGeneration < statefulSet.Generation ||
+		stsStatus.Replicas != status.Replicas ||
+		stsStatus.ReadyReplicas != status.ReadyReplicas ||
+		stsStatus.UpdatedReplicas != stsStatus.Replicas ||
+		stsStatus.CurrentRevision != stsStatus.UpdateRevision {
+		return 0, false
+	}
+	if !c.childrenInSync(sqliteInstance, statefulSet) {
+		return 0, false
+	}
+
+	if sqliteInstance.Spec.BackupStaleAfter == nil {
+		return 0, true
+	}
+	// A backup recorded since the last reconcile leaves the condition
+	// stale until it is set again.
+	if status.LastBackupTime == nil || !meta.IsStatusConditionFalse(status.Conditions, kubelitedbv1.ConditionBackupStale) {
+		return 0, false
+	}
+	staleIn := status.LastBackupTime.Add(sqliteInstance.Spec.BackupStaleAfter.Duration).Sub(now)
+	if staleIn <= 0 {
+		return 0, false
+	}
+	return staleIn, true
+}
+
+// statusFromUnwatchedObjects returns whether the status of the SQLiteInstance
+// is recorded from objects the controller doesn't watch, which change without
+// the SQLiteInstance being reconciled: the pods reporting the health of the
+// replication, the usage of the data volumes reported by the kubelets, and the
+// Jobs of the integrity checks.
+func (c *Controller) statusFromUnwatchedObjects(instance *kubelitedbv1.SQLiteInstance) bool {
+	return instance.Spec.Replication != nil || instance.Spec.IntegrityCheck != nil || c.storagePressureThreshold > 0
+}
+
+// childInSync returns whether a child object looked up in a lister is as the
+// reconcile leaves it: missing when it isn't wanted, and otherwise controlled
+// by the SQLiteInstance with the labels and annotations of the desired object.
+// The spec of the object is compared by the caller.
+func childInSync(instance *kubelitedbv1.SQLiteInstance, current v1.Object, err error, desired v1.Object, wanted bool) bool {
+	if errors.IsNotFound(err) {
+		return !wanted
+	}
+	return err == nil && wanted &&
+		v1.IsControlledBy(current, instance) &&
+		current.GetDeletionTimestamp() == nil &&
+		!metadataOutOfDate(current, desired)
+}
+
+// childrenInSync returns whether the child objects of the SQLiteInstance other
+// than its StatefulSet and volumes are as the reconcile leaves them, comparing
+// the same fields the reconcile does, and whether the reader Deployment is
+// rolled out with the ready replicas recorded in the status. The certificate
+// served over TLS is taken from the StatefulSet, as its Secret isn't watched.
+func (c *Controller) childrenInSync(instance *kubelitedbv1.SQLiteInstance, statefulSet *appsv1.StatefulSet) bool {
+	namespace := instance.Namespace
+
+	services := []struct {
+		desired *corev1.Service
+		wanted  bool
+	}{
+		{newHeadlessService(instance), true},
+		{newClientService(instance), instance.Spec.TLS != nil},
+		{newRoleService(instance, writeServiceName(instance), writerSelector(instance)), instance.Spec.ReadReplicas > 0},
+		{newRoleService(instance, readServiceName(instance), readerLabels(instance)), instance.Spec.ReadReplicas > 0},
+	}
+	for _, s := range services {
+		service, err := c.servicesLister.Services(namespace).Get(s.desired.Name)
+		if !childInSync(instance, service, err, s.desired, s.wanted) {
+			return false
+		}
+		if !s.wanted {
+			continue
+		}
+		// The node ports are allocated by the API server, so they are kept
+		// rather than compared.
+		ports := servicePortsWithNodePorts(service.Spec.Ports, s.desired.Spec.Ports, s.desired.Spec.Type)
+		if (s.desired.Spec.Type != "" && service.Spec.Type != s.desired.Spec.Type) ||
+			!equality.Semantic.DeepEqual(service.Spec.Selector, s.desired.Spec.Selector) ||
+			!equality.Semantic.DeepEqual(service.Spec.Ports, ports) {
+			return false
+		}
+	}
+
+	// Neither replication nor integrity checks are set, as the status would
+	// depend on unwatched objects, so their child objects must be gone.
+	if _, err := c.configMapsLister.ConfigMaps(namespace).Get(litestreamConfigMapName(instance)); !errors.IsNotFound(err) {
+		return false
+	}
+	if _, err := c.cronJobsLister.CronJobs(namespace).Get(integrityCheckCronJobName(instance)); !errors.IsNotFound(err) {
+		return false
+	}
+
+	desiredPragmas := newPragmasConfigMap(instance)
+	pragmasWanted := len(pragmasForInstance(instance)) > 0
+	configMap, err := c.configMapsLister.ConfigMaps(namespace).Get(desiredPragmas.Name)
+	if !childInSync(instance, configMap, err, desiredPragmas, pragmasWanted) ||
+		pragmasWanted && configMap.Data[pragmasKey] != desiredPragmas.Data[pragmasKey] {
+		return false
+	}
+
+	desiredPDB := newPodDisruptionBudget(instance)
+	pdbWanted := minAvailable(instance) > 0
+	pdb, err := c.pdbsLister.PodDisruptionBudgets(namespace).Get(desiredPDB.Name)
+	if !childInSync(instance, pdb, err, desiredPDB, pdbWanted) ||
+		pdbWanted && (pdb.Spec.MinAvailable == nil || *pdb.Spec.MinAvailable != *desiredPDB.Spec.MinAvailable) {
+		return false
+	}
+
+	// The CronJob is only created once the schedule is valid, which needs a
+	// backup destination.
+	backupsWanted := instance.Spec.BackupSchedule != "" && instance.Spec.BackupDestination != nil
+	cronJob, err := c.cronJobsLister.CronJobs(namespace).Get(backupCronJobName(instance))
+	if !backupsWanted {
+		if !errors.IsNotFound(err) {
+			return false
+		}
+	} else {
+		desired := newBackupCronJob(instance)
+		if !childInSync(instance, cronJob, err, desired, backupsWanted) ||
+			cronJob.Spec.Schedule != desired.Spec.Schedule ||
+			cronJob.Spec.JobTemplate.Annotations[backupCronJobTemplateHashAnnotation] != desired.Spec.JobTemplate.Annotations[backupCronJobTemplateHashAnnotation] {
+			return false
+		}
+	}
+
+	readersWanted := instance.Spec.ReadReplicas > 0
+	deployment, err := c.deploymentsLister.Deployments(namespace).Get(readerDeploymentName(instance))
+	if errors.IsNotFound(err) {
+		return !readersWanted && instance.Status.ReaderReadyReplicas == 0
+	}
+	if !readersWanted || err != nil {
+		return false
+	}
+	desired := newReaderDeployment(instance)
+	setTLSSecretHash(&desired.Spec.Template, statefulSet.Spec.Template.Annotations[tlsSecretHashAnnotation])
+	return childInSync(instance, deployment, err, desired, readersWanted) &&
+		*deployment.Spec.Replicas == *desired.Spec.Replicas &&
+		deployment.Spec.Template.Annotations[templateHashAnnotation] == desired.Spec.Template.Annotations[templateHashAnnotation] &&
+		deployment.Status.ObservedGeneration >= deployment.Generation &&
+		deployment.Status.ReadyReplicas == instance.Status.ReaderReadyReplicas
+}
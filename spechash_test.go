@@ -0,0 +1,448 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+func TestSpecHashStable(t *testing.T) {
+	instance := newSQLiteInstance("test")
+	instance.Labels = map[string]string{"team": "payments", "app": "orders", "tier": "db"}
+	hash := specHash(instance)
+
+	// The labels are built in another order, which doesn't change the hash
+	reordered := instance.DeepCopy()
+	reordered.Labels = map[string]string{}
+	for _, key := range []string{"tier", "app", "team"} {
+		reordered.Labels[key] = instance.Labels[key]
+	}
+	tests := []struct {
+		name   string
+		mutate func(instance *kubelitedbv1.SQLiteInstance)
+		same   bool
+	}{
+		{name: "unchanged", mutate: func(*kubelitedbv1.SQLiteInstance) {}, same: true},
+		{name: "labels in another order", mutate: func(i *kubelitedbv1.SQLiteInstance) { i.Labels = reordered.Labels }, same: true},
+		{name: "resource version", mutate: func(i *kubelitedbv1.SQLiteInstance) { i.ResourceVersion = "42" }, same: true},
+		{name: "generation", mutate: func(i *kubelitedbv1.SQLiteInstance) { i.Generation = 7 }, same: true},
+		{name: "observed spec hash", mutate: func(i *kubelitedbv1.SQLiteInstance) { i.Status.ObservedSpecHash = "previous" }, same: true},
+		{name: "ready replicas", mutate: func(i *kubelitedbv1.SQLiteInstance) { i.Status.ReadyReplicas = 1 }, same: true},
+		{
+			name: "conditions",
+			mutate: func(i *kubelitedbv1.SQLiteInstance) {
+				i.Status.Conditions = []v1.Condition{{Type: kubelitedbv1.ConditionReady, Status: v1.ConditionTrue}}
+			},
+			same: true,
+		},
+		{name: "storage", mutate: func(i *kubelitedbv1.SQLiteInstance) { i.Spec.Storage = "2Gi" }},
+		{name: "image", mutate: func(i *kubelitedbv1.SQLiteInstance) { i.Spec.Image = "example.com/sqlite:2" }},
+		{name: "read replicas", mutate: func(i *kubelitedbv1.SQLiteInstance) { i.Spec.ReadReplicas = 1 }},
+		{name: "label", mutate: func(i *kubelitedbv1.SQLiteInstance) { i.Labels["tier"] = "cache" }},
+		{name: "annotation", mutate: func(i *kubelitedbv1.SQLiteInstance) { i.Annotations = map[string]string{"owner": "orders"} }},
+		{name: "restored", mutate: func(i *kubelitedbv1.SQLiteInstance) { i.Status.Restored = true }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mutated := instance.DeepCopy()
+			tt.mutate(mutated)
+
+			got := specHash(mutated)
+			if got == "" {
+				t.Fatalf("expected a hash")
+			}
+			if tt.same && got != hash {
+				t.Errorf("expected the hash %s to be kept, got %s", hash, got)
+			}
+			if !tt.same && got == hash {
+				t.Errorf("expected the hash to change from %s", hash)
+			}
+			// The hash is the same every time it is computed
+			if again := specHash(mutated); again != got {
+				t.Errorf("expected the same hash twice, got %s and %s", got, again)
+			}
+		})
+	}
+}
+
+// settle reconciles the SQLiteInstance until its StatefulSet is rolled out
+// with every pod ready, and the status records it.
+func (f *fixture) settle(ctx context.Context, c *Controller, instance *kubelitedbv1.SQLiteInstance) {
+	f.t.Helper()
+	key := getKey(instance, f.t)
+	f.run(ctx, c, key)
+	statefulSet := f.getStatefulSet(ctx, instance)
+	replicas := *statefulSet.Spec.Replicas
+	statefulSet.Status = appsv1.StatefulSetStatus{
+		ObservedGeneration: statefulSet.Generation,
+		Replicas:           replicas,
+		ReadyReplicas:      replicas,
+		AvailableReplicas:  replicas,
+		UpdatedReplicas:    replicas,
+		CurrentReplicas:    replicas,
+		CurrentRevision:    "test-1",
+		UpdateRevision:     "test-1",
+	}
+	if _, err := f.kubeclient.AppsV1().StatefulSets(instance.Namespace).UpdateStatus(ctx, statefulSet, v1.UpdateOptions{}); err != nil {
+		f.t.Fatalf("error updating the StatefulSet status: %v", err)
+	}
+	f.refreshCaches(ctx)
+	f.run(ctx, c, key)
+	f.refreshCaches(ctx)
+	if got := f.getInstance(ctx, instance); !meta.IsStatusConditionTrue(got.Status.Conditions, kubelitedbv1.ConditionReady) {
+		f.t.Fatalf("expected the SQLiteInstance to be ready, got %+v", got.Status.Conditions)
+	}
+}
+
+// drainEvents returns the Events recorded so far.
+func drainEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestObservedSpecHash(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+
+	f.run(ctx, c, getKey(instance, t))
+
+	got := f.getInstance(ctx, instance)
+	if got.Status.ObservedSpecHash != specHash(got) {
+		t.Errorf("expected the observed spec hash %s, got %q", specHash(got), got.Status.ObservedSpecHash)
+	}
+}
+
+func TestInSyncShortCircuit(t *testing.T) {
+	tests := []struct {
+		name string
+		// change changes the SQLiteInstance, its child objects or the
+		// controller after the SQLiteInstance settled
+		change func(ctx context.Context, t *testing.T, f *fixture, c *Controller, instance *kubelitedbv1.SQLiteInstance)
+		// skipped is whether the reconcile is expected to be skipped
+		skipped bool
+		// resource is a resource the full reconcile is expected to write
+		resource string
+	}{
+		{
+			name:    "in sync",
+			change:  func(context.Context, *testing.T, *fixture, *Controller, *kubelitedbv1.SQLiteInstance) {},
+			skipped: true,
+		},
+		{
+			name: "spec changed",
+			change: func(ctx context.Context, t *testing.T, f *fixture, c *Controller, instance *kubelitedbv1.SQLiteInstance) {
+				updated := f.getInstance(ctx, instance)
+				updated.Spec.Image = "example.com/sqlite:2"
+				updated.Generation++
+				if _, err := f.client.KubelitedbV1().SQLiteInstances(instance.Namespace).Update(ctx, updated, v1.UpdateOptions{}); err != nil {
+					t.Fatalf("error updating the SQLiteInstance: %v", err)
+				}
+			},
+			resource: "statefulsets",
+		},
+		{
+			// The labels don't change the generation
+			name: "labels changed",
+			change: func(ctx context.Context, t *testing.T, f *fixture, c *Controller, instance *kubelitedbv1.SQLiteInstance) {
+				updated := f.getInstance(ctx, instance)
+				updated.Labels = map[string]string{"team": "payments"}
+				if _, err := f.client.KubelitedbV1().SQLiteInstances(instance.Namespace).Update(ctx, updated, v1.UpdateOptions{}); err != nil {
+					t.Fatalf("error updating the SQLiteInstance: %v", err)
+				}
+			},
+		},
+		{
+			name: "child object deleted",
+			change: func(ctx context.Context, t *testing.T, f *fixture, c *Controller, instance *kubelitedbv1.SQLiteInstance) {
+				if err := f.kubeclient.CoreV1().Services(instance.Namespace).Delete(ctx, headlessServiceName(instance), v1.DeleteOptions{}); err != nil {
+					t.Fatalf("error deleting the Service: %v", err)
+				}
+			},
+			resource: "services",
+		},
+		{
+			name: "child object edited",
+			change: func(ctx context.Context, t *testing.T, f *fixture, c *Controller, instance *kubelitedbv1.SQLiteInstance) {
+				service, err := f.kubeclient.CoreV1().Services(instance.Namespace).Get(ctx, headlessServiceName(instance), v1.GetOptions{})
+				if err != nil {
+					t.Fatalf("error getting the Service: %v", err)
+				}
+				service.Spec.Selector = map[string]string{"app": "other"}
+				if _, err := f.kubeclient.CoreV1().Services(instance.Namespace).Update(ctx, service, v1.UpdateOptions{}); err != nil {
+					t.Fatalf("error updating the Service: %v", err)
+				}
+			},
+			resource: "services",
+		},
+		{
+			name: "unwanted child object",
+			change: func(ctx context.Context, t *testing.T, f *fixture, c *Controller, instance *kubelitedbv1.SQLiteInstance) {
+				desired := instance.DeepCopy()
+				desired.Spec.ReadReplicas = 1
+				if _, err := f.kubeclient.AppsV1().Deployments(instance.Namespace).Create(ctx, newReaderDeployment(desired), v1.CreateOptions{}); err != nil {
+					t.Fatalf("error creating the Deployment: %v", err)
+				}
+			},
+			resource: "deployments",
+		},
+		{
+			name: "pod no longer ready",
+			change: func(ctx context.Context, t *testing.T, f *fixture, c *Controller, instance *kubelitedbv1.SQLiteInstance) {
+				statefulSet := f.getStatefulSet(ctx, instance)
+				statefulSet.Status.ReadyReplicas = 0
+				if _, err := f.kubeclient.AppsV1().StatefulSets(instance.Namespace).UpdateStatus(ctx, statefulSet, v1.UpdateOptions{}); err != nil {
+					t.Fatalf("error updating the StatefulSet status: %v", err)
+				}
+			},
+		},
+		{
+			name: "pod template edited",
+			change: func(ctx context.Context, t *testing.T, f *fixture, c *Controller, instance *kubelitedbv1.SQLiteInstance) {
+				statefulSet := f.getStatefulSet(ctx, instance)
+				statefulSet.Spec.Template.Spec.Containers[0].Image = "example.com/sqlite:edited"
+				statefulSet.Spec.Template.Annotations[templateHashAnnotation] = "edited"
+				if _, err := f.kubeclient.AppsV1().StatefulSets(instance.Namespace).Update(ctx, statefulSet, v1.UpdateOptions{}); err != nil {
+					t.Fatalf("error updating the StatefulSet: %v", err)
+				}
+			},
+			resource: "statefulsets",
+		},
+		{
+			name: "StatefulSet scaled",
+			change: func(ctx context.Context, t *testing.T, f *fixture, c *Controller, instance *kubelitedbv1.SQLiteInstance) {
+				statefulSet := f.getStatefulSet(ctx, instance)
+				replicas := int32(2)
+				statefulSet.Spec.Replicas = &replicas
+				if _, err := f.kubeclient.AppsV1().StatefulSets(instance.Namespace).Update(ctx, statefulSet, v1.UpdateOptions{}); err != nil {
+					t.Fatalf("error updating the StatefulSet: %v", err)
+				}
+			},
+			resource: "statefulsets",
+		},
+		{
+			name: "last full reconcile long ago",
+			change: func(ctx context.Context, t *testing.T, f *fixture, c *Controller, instance *kubelitedbv1.SQLiteInstance) {
+				key := getKey(instance, t)
+				c.fullReconciles[key] = fullReconcile{specHash: c.fullReconciles[key].specHash, at: time.Now().Add(-fullReconcileInterval)}
+			},
+		},
+		{
+			name: "controller restarted",
+			change: func(ctx context.Context, t *testing.T, f *fixture, c *Controller, instance *kubelitedbv1.SQLiteInstance) {
+				c.forgetFullReconcile(getKey(instance, t))
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := newSQLiteInstance("test")
+			f.addInstance(instance)
+			c, _, _ := f.newController(ctx)
+			f.settle(ctx, c, instance)
+
+			tt.change(ctx, t, f, c, f.getInstance(ctx, instance))
+			f.refreshCaches(ctx)
+			f.client.ClearActions()
+			f.kubeclient.ClearActions()
+			drainEvents(f.recorder)
+
+			f.run(ctx, c, getKey(instance, t))
+
+			events := drainEvents(f.recorder)
+			synced := slices.ContainsFunc(events, func(event string) bool {
+				return strings.HasPrefix(event, corev1.EventTypeNormal+" "+SuccessSynced+" ")
+			})
+			if tt.skipped {
+				if synced {
+					t.Errorf("expected the reconcile to be skipped, got the Events %v", events)
+				}
+				if actions := f.kubeclient.Actions(); len(actions) != 0 {
+					t.Errorf("expected no API calls for the child objects, got %v", actions)
+				}
+				if actions := f.client.Actions(); len(actions) != 0 {
+					t.Errorf("expected the status not to be written, got %v", actions)
+				}
+				return
+			}
+			if !synced {
+				t.Errorf("expected a full reconcile, got the Events %v", events)
+			}
+			if tt.resource != "" && len(writes(f.kubeclient.Actions(), tt.resource)) == 0 {
+				t.Errorf("expected the full reconcile to write the %s, got %v", tt.resource, f.kubeclient.Actions())
+			}
+		})
+	}
+}
+
+func TestInSyncBackupStale(t *testing.T) {
+	tests := []struct {
+		name string
+		// lastBackup is how long ago the last backup completed, none when 0
+		lastBackup time.Duration
+		// stale is the expected BackupStale condition, the next reconcile
+		// is skipped when it is False
+		stale v1.ConditionStatus
+	}{
+		{name: "never backed up", stale: v1.ConditionTrue},
+		{name: "backup recorded", lastBackup: 10 * time.Minute, stale: v1.ConditionFalse},
+		{name: "stale backup recorded", lastBackup: 2 * time.Hour, stale: v1.ConditionTrue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := newSQLiteInstance("test")
+			instance.Spec.BackupStaleAfter = &v1.Duration{Duration: time.Hour}
+			f.addInstance(instance)
+			c, _, _ := f.newController(ctx)
+			f.settle(ctx, c, instance)
+
+			// The backup controller records the backups in the status
+			if tt.lastBackup != 0 {
+				updated := f.getInstance(ctx, instance)
+				recordBackup(&updated.Status, v1.NewTime(time.Now().Add(-tt.lastBackup)), "s3://backups/app.db")
+				if _, err := f.client.KubelitedbV1().SQLiteInstances(instance.Namespace).UpdateStatus(ctx, updated, v1.UpdateOptions{}); err != nil {
+					t.Fatalf("error recording the backup: %v", err)
+				}
+				f.refreshCaches(ctx)
+			}
+			f.run(ctx, c, getKey(instance, t))
+
+			condition := meta.FindStatusCondition(f.getInstance(ctx, instance).Status.Conditions, kubelitedbv1.ConditionBackupStale)
+			if condition == nil || condition.Status != tt.stale {
+				t.Fatalf("expected the BackupStale condition to be %s, got %+v", tt.stale, condition)
+			}
+			if tt.stale == v1.ConditionTrue {
+				return
+			}
+			// The reconcile of the SQLiteInstance now in sync is skipped, and
+			// it is reconciled again once the backup becomes stale
+			f.refreshCaches(ctx)
+			f.client.ClearActions()
+			result := f.run(ctx, c, getKey(instance, t))
+			if actions := f.client.Actions(); len(actions) != 0 {
+				t.Errorf("expected the reconcile to be skipped, got %v", actions)
+			}
+			want := time.Hour - tt.lastBackup
+			if result.RequeueAfter > want || result.RequeueAfter < want-time.Minute {
+				t.Errorf("expected to be reconciled again in %s, got %s", want, result.RequeueAfter)
+			}
+		})
+	}
+}
+
+func TestInSyncStatusFromUnwatchedObjects(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(c *Controller, instance *kubelitedbv1.SQLiteInstance)
+	}{
+		{
+			name: "replication",
+			mutate: func(c *Controller, instance *kubelitedbv1.SQLiteInstance) {
+				instance.Spec.Replication = &kubelitedbv1.ReplicationSpec{Bucket: "wal", SecretRef: corev1.LocalObjectReference{Name: "s3-credentials"}}
+			},
+		},
+		{
+			name: "integrity checks",
+			mutate: func(c *Controller, instance *kubelitedbv1.SQLiteInstance) {
+				instance.Spec.IntegrityCheck = &kubelitedbv1.IntegrityCheckSpec{Schedule: "0 3 * * *"}
+			},
+		},
+		{
+			name: "storage pressure",
+			mutate: func(c *Controller, instance *kubelitedbv1.SQLiteInstance) {
+				c.storagePressureThreshold = 80
+			},
+		},
+		{
+			name: "DaemonSet",
+			mutate: func(c *Controller, instance *kubelitedbv1.SQLiteInstance) {
+				instance.Spec.DeploymentMode = kubelitedbv1.DeploymentModeDaemonSet
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := newSQLiteInstance("test")
+			f.addInstance(instance)
+			c, _, _ := f.newController(ctx)
+			f.settle(ctx, c, instance)
+			key := getKey(instance, t)
+			settled := f.getInstance(ctx, instance)
+			if _, ok := c.inSync(key, settled, time.Now()); !ok {
+				t.Fatalf("expected the settled SQLiteInstance to be in sync")
+			}
+
+			// Even reconciled from the same spec, the status may be out of date
+			changed := settled.DeepCopy()
+			tt.mutate(c, changed)
+			changed.Status.ObservedSpecHash = specHash(changed)
+			c.recordFullReconcile(key, changed.Status.ObservedSpecHash, time.Now())
+			if _, ok := c.inSync(key, changed, time.Now()); ok {
+				t.Errorf("expected the reconcile not to be skipped")
+			}
+		})
+	}
+}
+
+func TestFullReconcileForgottenOnDelete(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	f.addInstance(instance)
+	c, i, _ := f.newController(ctx)
+	f.run(ctx, c, getKey(instance, t))
+	if _, ok := c.fullReconciles[getKey(instance, t)]; !ok {
+		t.Fatalf("expected the full reconcile to be recorded")
+	}
+
+	if err := i.Kubelitedb().V1().SQLiteInstances().Informer().GetIndexer().Delete(instance); err != nil {
+		t.Fatalf("error deleting the SQLiteInstance from the cache: %v", err)
+	}
+	f.run(ctx, c, getKey(instance, t))
+
+	if _, ok := c.fullReconciles[getKey(instance, t)]; ok {
+		t.Errorf("expected the full reconcile of the deleted SQLiteInstance to be forgotten")
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+const (
+	// ReasonCleanupRunning is used as the condition reason while the
+	// deletion waits for the purge of object storage
+	ReasonCleanupRunning = "CleanupRunning"
+	// ReasonCleanupFailed is used as the condition reason when the purge of
+	// object storage failed, blocking the deletion
+	ReasonCleanupFailed = "CleanupFailed"
+
+	// MessageCleanupRunning is the message used while the deletion waits for
+	// the purge of object storage
+	MessageCleanupRunning = "Waiting for Job %q to delete the backups and replicas from object storage"
+	// MessageCleanupFailed is the message used when the purge of object
+	// storage failed
+	MessageCleanupFailed = "Job %q failed to delete the backups and replicas from object storage, delete it to retry"
+)
+
+// cleanupJobName returns the name of the Job purging object storage when the
+// SQLiteInstance is deleted
+func cleanupJobName(instance *kubelitedbv1.SQLiteInstance) string {
+	return fmt.Sprintf("%s-cleanup", instance.Name)
+}
+
+// objectStoragePrefix returns the URL of the prefix holding everything under
+// the given path of the bucket, defaulting to the namespace and name of the
+// SQLiteInstance. The trailing slash keeps the prefix from matching instances
+// whose name starts with this one.
+func objectStoragePrefix(instance *kubelitedbv1.SQLiteInstance, bucket, prefix string) string {
+	if prefix == "" {
+		prefix = path.Join(instance.Namespace, instance.Name)
+	}
+	return fmt.Sprintf("s3://%s/%s/", bucket, prefix)
+}
+
+// newCleanupContainer creates the container deleting everything under the
+// prefix of the bucket but the deletion snapshot, which outlives the
+// SQLiteInstance. The snapshot is only uploaded under the backup destination,
+// but it is also kept when the replicas share the prefix.
+func newCleanupContainer(instance *kubelitedbv1.SQLiteInstance, name, url, endpoint string, secretRef corev1.LocalObjectReference) corev1.Container {
+	args := []string{"s3", "rm", url, "--recursive", "--exclude", deletionSnapshotName(instance) + "/*"}
+	if endpoint != "" {
+		args = append(args, "--endpoint-url", endpoint)
+	}
+	return corev1.Container{
+		Name:  name,
+		Image: awsCLIImage,
+		Args:  args,
+		Env:   objectStorageCredentials(secretRef),
+	}
+}
+
+// newCleanupJob creates the Job deleting the scheduled backups and the
+// replicas of the SQLiteInstance from object storage. It returns nil when
+// nothing was pushed outside of the cluster.
+func newCleanupJob(instance *kubelitedbv1.SQLiteInstance) *batchv1.Job {
+	var containers []corev1.Container
+	if destination := instance.Spec.BackupDestination; destination != nil {
+		containers = append(containers, newCleanupContainer(instance, "backups",
+			objectStoragePrefix(instance, destination.Bucket, destination.Path), destination.Endpoint, destination.SecretRef))
+	}
+	if replication := instance.Spec.Replication; replication != nil {
+		containers = append(containers, newCleanupContainer(instance, "replicas",
+			objectStoragePrefix(instance, replication.Bucket, replication.Path), replication.Endpoint, replication.SecretRef))
+	}
+	if len(containers) == 0 {
+		return nil
+	}
+	backoffLimit := int32(2)
+
+	job := &batchv1.Job{
+		ObjectMeta: v1.ObjectMeta{
+			Name:            cleanupJobName(instance),
+			Namespace:       instance.Namespace,
+			Labels:          childLabels(instance, labelsForInstance(instance, componentCleanup)),
+			Annotations:     childAnnotations(instance),
+			OwnerReferences: []v1.OwnerReference{newOwnerReference(instance)},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: v1.ObjectMeta{
+					Labels:      childLabels(instance, labelsForInstance(instance, componentCleanup)),
+					Annotations: childAnnotations(instance),
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					// The deletion may authenticate as the service account,
+					// for example with workload identity
+					ServiceAccountName: instance.Spec.ServiceAccountName,
+					Containers:         containers,
+				},
+			},
+		},
+	}
+	setSecurityContexts(instance, &job.Spec.Template.Spec)
+	return job
+}
+
+// cleanupExternalStorage purges the scheduled backups and the replicas the
+// SQLiteInstance pushed to object storage, which are not removed by garbage
+// collection of the owned objects, and returns whether the deletion can go
+// ahead. SQLiteBackups created by users are left alone, as they outlive the
+// SQLiteInstance. While the purge is running or after it failed, the
+// DeletionBlocked condition explains what the deletion waits for.
+func (c *Controller) cleanupExternalStorage(ctx context.Context, sqliteInstance *kubelitedbv1.SQLiteInstance) (bool, error) {
+	desired := newCleanupJob(sqliteInstance)
+	if desired == nil {
+		return true, nil
+	}
+
+	jobs := c.kubeclientset.BatchV1().Jobs(sqliteInstance.Namespace)
+	job, err := jobs.Get(ctx, desired.Name, v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		job, err = jobs.Create(ctx, desired, c.createOptions(ctx, sqliteInstance, "Job", desired.Name))
+		logWrite(ctx, "create", "Job", desired.Name, err)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if !v1.IsControlledBy(job, sqliteInstance) {
+		msg := fmt.Sprintf(MessageResourceExists, job.Name)
+		c.recorder.Event(sqliteInstance, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return false, fmt.Errorf("%s", msg)
+	}
+
+	status := sqliteInstance.Status.DeepCopy()
+	_, ok, done := jobOutcome(job)
+	switch {
+	case done && ok:
+		return true, nil
+	case done:
+		msg := fmt.Sprintf(MessageCleanupFailed, job.Name)
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionDeletionBlocked, v1.ConditionTrue, ReasonCleanupFailed, msg)
+		c.recorder.Event(sqliteInstance, corev1.EventTypeWarning, ReasonCleanupFailed, msg)
+	default:
+		setCondition(status, sqliteInstance, kubelitedbv1.ConditionDeletionBlocked, v1.ConditionTrue, ReasonCleanupRunning, fmt.Sprintf(MessageCleanupRunning, job.Name))
+	}
+	return false, c.updateSQLiteInstanceStatus(ctx, sqliteInstance, status)
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+const (
+	// ReasonVolumeNotBound is used as the condition reason while a data
+	// volume is not bound yet
+	ReasonVolumeNotBound = "VolumeNotBound"
+	// ReasonCapacityBelowRequest is used as the condition reason when a data
+	// volume is smaller than requested, such as while it is being resized
+	ReasonCapacityBelowRequest = "CapacityBelowRequest"
+	// ReasonUsageAboveThreshold is used as the condition reason when the
+	// usage of a data volume crossed the storage pressure threshold
+	ReasonUsageAboveThreshold = "UsageAboveThreshold"
+	// ReasonStorageAvailable is used as the condition reason when every data
+	// volume has the requested capacity and space left
+	ReasonStorageAvailable = "StorageAvailable"
+
+	// MessageVolumeNotBound is the message used while a data volume is not
+	// bound yet
+	MessageVolumeNotBound = "PVC %q is %s"
+	// MessageVolumeNotFound is the message used while the data volumes are
+	// not created yet
+	MessageVolumeNotFound = "Waiting for the data volumes to be created"
+	// MessageCapacityBelowRequest is the message used when a data volume is
+	// smaller than requested
+	MessageCapacityBelowRequest = "PVC %q has a capacity of %s, %s was requested"
+	// MessageUsageAboveThreshold is the message used when the usage of a data
+	// volume crossed the storage pressure threshold
+	MessageUsageAboveThreshold = "PVC %q is %d%% full, %s of %s used"
+)
+
+// dataVolumeStatuses returns the state of the data volumes of the pods of the
+// SQLiteInstance up to the given number of replicas. Volumes that don't exist
+// yet are left out.
+func (c *Controller) dataVolumeStatuses(ctx context.Context, instance *kubelitedbv1.SQLiteInstance, replicas int) ([]kubelitedbv1.VolumeStatus, error) {
+	claimNames := []string{}
+	if sharesVolume(instance) {
+		claimNames = append(claimNames, dataPVCName(instance, 0))
+	} else {
+		for ordinal := 0; ordinal < replicas; ordinal++ {
+			claimNames = append(claimNames, dataPVCName(instance, ordinal))
+		}
+	}
+
+	var usage map[string]int64
+	if c.storagePressureThreshold > 0 {
+		usage = c.dataVolumeUsage(ctx, instance, replicas)
+	}
+
+	volumes := []kubelitedbv1.VolumeStatus{}
+	for _, claimName := range claimNames {
+		pvc, err := c.pvcsLister.PersistentVolumeClaims(instance.Namespace).Get(claimName)
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		volume := volumeStatusForPVC(pvc)
+		if used, ok := usage[claimName]; ok {
+			volume.Used = resource.NewQuantity(used, resource.BinarySI)
+		}
+		volumes = append(volumes, volume)
+	}
+	return volumes, nil
+}
+
+// volumeStatusForPVC returns the observed state of the given data volume.
+func volumeStatusForPVC(pvc *corev1.PersistentVolumeClaim) kubelitedbv1.VolumeStatus {
+	volume := kubelitedbv1.VolumeStatus{
+		ClaimName: pvc.Name,
+		Phase:     pvc.Status.Phase,
+	}
+	if requested, ok := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; ok {
+		volume.Requested = &requested
+	}
+	if capacity, ok := pvc.Status.Capacity[corev1.ResourceStorage]; ok {
+		volume.Capacity = &capacity
+	}
+	return volume
+}
+
+// storagePressureCondition derives the StoragePressure condition from the
+// data volumes. Usage is only compared against the threshold, a percentage of
+// the capacity, when it is above 0.
+func storagePressureCondition(volumes []kubelitedbv1.VolumeStatus, threshold int) (v1.ConditionStatus, string, string) {
+	if len(volumes) == 0 {
+		return v1.ConditionUnknown, ReasonVolumeNotBound, MessageVolumeNotFound
+	}
+	for _, volume := range volumes {
+		if volume.Phase != corev1.ClaimBound || volume.Capacity == nil {
+			return v1.ConditionUnknown, ReasonVolumeNotBound, fmt.Sprintf(MessageVolumeNotBound, volume.ClaimName, volume.Phase)
+		}
+	}
+	for _, volume := range volumes {
+		if threshold > 0 && volume.Used != nil && !volume.Capacity.IsZero() {
+			percent := int(volume.Used.Value() * 100 / volume.Capacity.Value())
+			if percent >= threshold {
+				return v1.ConditionTrue, ReasonUsageAboveThreshold, fmt.Sprintf(MessageUsageAboveThreshold, volume.ClaimName, percent, volume.Used, volume.Capacity)
+			}
+		}
+		if volume.Requested != nil && volume.Capacity.Cmp(*volume.Requested) < 0 {
+			return v1.ConditionTrue, ReasonCapacityBelowRequest, fmt.Sprintf(MessageCapacityBelowRequest, volume.ClaimName, volume.Capacity, volume.Requested)
+		}
+	}
+	return v1.ConditionFalse, ReasonStorageAvailable, ""
+}
+
+// kubeletStatsSummary is the part of the stats summary of the kubelet
+// reporting the usage of the volumes of every pod.
+type kubeletStatsSummary struct {
+	Pods []struct {
+		PodRef struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"podRef"`
+		Volumes []struct {
+			UsedBytes *int64 `json:"usedBytes"`
+			PVCRef    *struct {
+				Name string `json:"name"`
+			} `json:"pvcRef"`
+		} `json:"volume"`
+	} `json:"pods"`
+}
+
+// dataVolumeUsage returns the bytes used on the data volumes of the pods of
+// the SQLiteInstance, by PVC name, as reported by the kubelets running them.
+// The usage is best effort, volumes whose usage can't be fetched are left out.
+func (c *Controller) dataVolumeUsage(ctx context.Context, instance *kubelitedbv1.SQLiteInstance, replicas int) map[string]int64 {
+	logger := klog.FromContext(ctx)
+	usage := map[string]int64{}
+	summaries := map[string]*kubeletStatsSummary{}
+	for ordinal := 0; ordinal < replicas; ordinal++ {
+		pod, err := c.kubeclientset.CoreV1().Pods(instance.Namespace).Get(ctx, fmt.Sprintf("%s-%d", statefulSetName(instance), ordinal), v1.GetOptions{})
+		if err != nil || pod.Spec.NodeName == "" {
+			continue
+		}
+		summary, ok := summaries[pod.Spec.NodeName]
+		if !ok {
+			summary, err = c.kubeletStatsSummary(ctx, pod.Spec.NodeName)
+			if err != nil {
+				logger.V(4).Info("Failed to fetch the volume usage", "node", pod.Spec.NodeName, "err", err)
+			}
+			summaries[pod.Spec.NodeName] = summary
+		}
+		if summary == nil {
+			continue
+		}
+		for _, podStats := range summary.Pods {
+			if podStats.PodRef.Namespace != pod.Namespace || podStats.PodRef.Name != pod.Name {
+				continue
+			}
+			for _, volume := range podStats.Volumes {
+				if volume.PVCRef != nil && volume.UsedBytes != nil {
+					usage[volume.PVCRef.Name] = *volume.UsedBytes
+				}
+			}
+		}
+	}
+	return usage
+}
+
+// kubeletStatsSummary fetches the stats summary of the kubelet of the given
+// node through the API server.
+func (c *Controller) kubeletStatsSummary(ctx context.Context, nodeName string) (*kubeletStatsSummary, error) {
+	data, err := c.kubeclientset.CoreV1().RESTClient().Get().
+		Resource("nodes").Name(nodeName).SubResource("proxy").Suffix("stats", "summary").
+		DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	summary := &kubeletStatsSummary{}
+	if err := json.Unmarshal(data, summary); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
@@ -0,0 +1,216 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// newBoundDataPVC returns the data PVC of the first pod of the SQLiteInstance,
+// owned by its StatefulSet, in the given phase and bound to a volume of the
+// given capacity.
+func newBoundDataPVC(instance *kubelitedbv1.SQLiteInstance, phase corev1.PersistentVolumeClaimPhase, capacity string) *corev1.PersistentVolumeClaim {
+	pvc := newDataPVC(instance)
+	pvc.OwnerReferences = []v1.OwnerReference{*v1.NewControllerRef(newStatefulSet(instance), appsv1.SchemeGroupVersion.WithKind("StatefulSet"))}
+	pvc.Status.Phase = phase
+	if capacity != "" {
+		pvc.Status.Capacity = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(capacity)}
+	}
+	return pvc
+}
+
+func TestVolumeStatusForPVC(t *testing.T) {
+	instance := newSQLiteInstance("test")
+	got := volumeStatusForPVC(newBoundDataPVC(instance, corev1.ClaimBound, "2Gi"))
+
+	if got.ClaimName != dataPVCName(instance, 0) || got.Phase != corev1.ClaimBound {
+		t.Errorf("expected bound PVC %s, got %+v", dataPVCName(instance, 0), got)
+	}
+	if got.Requested == nil || got.Requested.String() != "1Gi" {
+		t.Errorf("expected 1Gi requested, got %v", got.Requested)
+	}
+	if got.Capacity == nil || got.Capacity.String() != "2Gi" {
+		t.Errorf("expected a capacity of 2Gi, got %v", got.Capacity)
+	}
+
+	pending := volumeStatusForPVC(newBoundDataPVC(instance, corev1.ClaimPending, ""))
+	if pending.Capacity != nil {
+		t.Errorf("expected no capacity for a pending PVC, got %v", pending.Capacity)
+	}
+}
+
+func TestStoragePressureCondition(t *testing.T) {
+	quantity := func(s string) *resource.Quantity {
+		q := resource.MustParse(s)
+		return &q
+	}
+	bound := func(requested, capacity, used string) kubelitedbv1.VolumeStatus {
+		volume := kubelitedbv1.VolumeStatus{ClaimName: "data", Phase: corev1.ClaimBound, Requested: quantity(requested), Capacity: quantity(capacity)}
+		if used != "" {
+			volume.Used = quantity(used)
+		}
+		return volume
+	}
+	tests := []struct {
+		name       string
+		volumes    []kubelitedbv1.VolumeStatus
+		threshold  int
+		wantStatus v1.ConditionStatus
+		wantReason string
+	}{
+		{name: "no volumes", wantStatus: v1.ConditionUnknown, wantReason: ReasonVolumeNotBound},
+		{
+			name:       "pending",
+			volumes:    []kubelitedbv1.VolumeStatus{{ClaimName: "data", Phase: corev1.ClaimPending, Requested: quantity("1Gi")}},
+			wantStatus: v1.ConditionUnknown,
+			wantReason: ReasonVolumeNotBound,
+		},
+		{
+			name:       "one of several pending",
+			volumes:    []kubelitedbv1.VolumeStatus{bound("1Gi", "1Gi", ""), {ClaimName: "data-1", Phase: corev1.ClaimPending}},
+			wantStatus: v1.ConditionUnknown,
+			wantReason: ReasonVolumeNotBound,
+		},
+		{name: "available", volumes: []kubelitedbv1.VolumeStatus{bound("1Gi", "1Gi", "")}, wantStatus: v1.ConditionFalse, wantReason: ReasonStorageAvailable},
+		{name: "larger than requested", volumes: []kubelitedbv1.VolumeStatus{bound("1Gi", "2Gi", "")}, wantStatus: v1.ConditionFalse, wantReason: ReasonStorageAvailable},
+		{name: "below request", volumes: []kubelitedbv1.VolumeStatus{bound("2Gi", "1Gi", "")}, wantStatus: v1.ConditionTrue, wantReason: ReasonCapacityBelowRequest},
+		{name: "below threshold", volumes: []kubelitedbv1.VolumeStatus{bound("1Gi", "1Gi", "512Mi")}, threshold: 80, wantStatus: v1.ConditionFalse, wantReason: ReasonStorageAvailable},
+		{name: "at threshold", volumes: []kubelitedbv1.VolumeStatus{bound("1Gi", "1000Mi", "800Mi")}, threshold: 80, wantStatus: v1.ConditionTrue, wantReason: ReasonUsageAboveThreshold},
+		{name: "above threshold", volumes: []kubelitedbv1.VolumeStatus{bound("1Gi", "1Gi", "900Mi")}, threshold: 80, wantStatus: v1.ConditionTrue, wantReason: ReasonUsageAboveThreshold},
+		{name: "no threshold", volumes: []kubelitedbv1.VolumeStatus{bound("1Gi", "1Gi", "1Gi")}, wantStatus: v1.ConditionFalse, wantReason: ReasonStorageAvailable},
+		{name: "usage unknown", volumes: []kubelitedbv1.VolumeStatus{bound("1Gi", "1Gi", "")}, threshold: 80, wantStatus: v1.ConditionFalse, wantReason: ReasonStorageAvailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, reason, msg := storagePressureCondition(tt.volumes, tt.threshold)
+			if status != tt.wantStatus || reason != tt.wantReason {
+				t.Errorf("expected %s %s, got %s %s: %s", tt.wantStatus, tt.wantReason, status, reason, msg)
+			}
+		})
+	}
+}
+
+func TestStoragePressureFromPVC(t *testing.T) {
+	tests := []struct {
+		name       string
+		pvc        *corev1.PersistentVolumeClaim
+		wantStatus v1.ConditionStatus
+		wantReason string
+		wantPhase  corev1.PersistentVolumeClaimPhase
+	}{
+		{name: "not created", wantStatus: v1.ConditionUnknown, wantReason: ReasonVolumeNotBound},
+		{
+			name:       "pending",
+			pvc:        newBoundDataPVC(newSQLiteInstance("test"), corev1.ClaimPending, ""),
+			wantStatus: v1.ConditionUnknown,
+			wantReason: ReasonVolumeNotBound,
+			wantPhase:  corev1.ClaimPending,
+		},
+		{
+			name:       "bound",
+			pvc:        newBoundDataPVC(newSQLiteInstance("test"), corev1.ClaimBound, "1Gi"),
+			wantStatus: v1.ConditionFalse,
+			wantReason: ReasonStorageAvailable,
+			wantPhase:  corev1.ClaimBound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := newSQLiteInstance("test")
+			f.addInstance(instance)
+			if tt.pvc != nil {
+				f.addKubeObject(tt.pvc)
+			}
+			c, _, _ := f.newController(ctx)
+
+			f.run(ctx, c, getKey(instance, t))
+
+			status := f.getInstance(ctx, instance).Status
+			pressure := meta.FindStatusCondition(status.Conditions, kubelitedbv1.ConditionStoragePressure)
+			if pressure == nil || pressure.Status != tt.wantStatus || pressure.Reason != tt.wantReason {
+				t.Errorf("expected StoragePressure %s %s, got %+v", tt.wantStatus, tt.wantReason, pressure)
+			}
+			if tt.pvc == nil {
+				if len(status.Volumes) != 0 {
+					t.Errorf("expected no volumes, got %+v", status.Volumes)
+				}
+				return
+			}
+			if len(status.Volumes) != 1 || status.Volumes[0].ClaimName != tt.pvc.Name || status.Volumes[0].Phase != tt.wantPhase {
+				t.Errorf("expected %s to be %s, got %+v", tt.pvc.Name, tt.wantPhase, status.Volumes)
+			}
+		})
+	}
+}
+
+func TestPVCPhaseChangeEnqueuesOwner(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	f.addInstance(instance)
+	f.addKubeObject(newStatefulSet(instance))
+	pvc := newBoundDataPVC(instance, corev1.ClaimPending, "")
+	pvc.ResourceVersion = "1"
+	f.addKubeObject(pvc)
+	c, _, k8sI := f.newController(ctx)
+	defer c.workqueue.ShutDown()
+	k8sI.Start(ctx.Done())
+	k8sI.WaitForCacheSync(ctx.Done())
+	drain := func() []interface{} {
+		t.Helper()
+		err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+			return c.workqueue.Len() > 0, nil
+		})
+		if err != nil {
+			t.Fatalf("timed out waiting for the SQLiteInstance to be queued")
+		}
+		var keys []interface{}
+		for c.workqueue.Len() > 0 {
+			key, _ := c.workqueue.Get()
+			c.workqueue.Done(key)
+			keys = append(keys, key)
+		}
+		return keys
+	}
+
+	// The prepopulated cache is relisted unchanged, which enqueues nothing,
+	// so the first key queued is the one of the update.
+	pvc = newBoundDataPVC(instance, corev1.ClaimBound, "1Gi")
+	pvc.ResourceVersion = "2"
+	if _, err := f.kubeclient.CoreV1().PersistentVolumeClaims(pvc.Namespace).UpdateStatus(ctx, pvc, v1.UpdateOptions{}); err != nil {
+		t.Fatalf("error binding the PVC: %v", err)
+	}
+	if keys := drain(); len(keys) != 1 || keys[0] != getKey(instance, t) {
+		t.Errorf("expected %s to be queued once the PVC is bound, got %v", getKey(instance, t), keys)
+	}
+}
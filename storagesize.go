@@ -0,0 +1,168 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+const (
+	// ReasonStorageShrinkForbidden is used as the condition reason when the
+	// requested storage is smaller than the volumes already provisioned
+	ReasonStorageShrinkForbidden = "StorageShrinkForbidden"
+	// MessageStorageShrinkForbidden is the message used when the requested
+	// storage is smaller than the volumes already provisioned
+	MessageStorageShrinkForbidden = "Storage cannot be shrunk to %s, PVC %q already requests %s"
+)
+
+// storageShrunk returns a message when spec.storage is smaller than the
+// storage requested by the data volumes of the SQLiteInstance, as volumes can
+// only grow. Volumes referenced by spec.volumeClaimName are not managed by the
+// controller, so they are never compared.
+func (c *Controller) storageShrunk(instance *kubelitedbv1.SQLiteInstance) (string, error) {
+	if instance.Spec.VolumeClaimName != "" {
+		return "", nil
+	}
+	storage, err := resource.ParseQuantity(instance.Spec.Storage)
+	if err != nil {
+		return "", err
+	}
+
+	replicas := instance.Spec.Replicas
+	if sharesVolume(instance) {
+		replicas = 1
+	}
+	for ordinal := 0; ordinal < replicas; ordinal++ {
+		pvc, err := c.pvcsLister.PersistentVolumeClaims(instance.Namespace).Get(dataPVCName(instance, ordinal))
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		requested, ok := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+		if ok && storage.Cmp(requested) < 0 {
+			return fmt.Sprintf(MessageStorageShrinkForbidden, instance.Spec.Storage, pvc.Name, requested.String()), nil
+		}
+	}
+	return "", nil
+}
+
+const (
+	// ReasonVolumeExpanding is used as the condition reason while the data
+	// volumes are being expanded
+	ReasonVolumeExpanding = "VolumeExpanding"
+	// ReasonVolumeExpansionUnsupported is used as the condition reason when
+	// the storage class of a data volume doesn't allow volume expansion
+	ReasonVolumeExpansionUnsupported = "VolumeExpansionUnsupported"
+
+	// MessageVolumeExpanding is the message used while a data volume is
+	// being expanded
+	MessageVolumeExpanding = "PVC %q is being expanded from %s to %s"
+	// MessageVolumeExpansionUnsupported is the message used when the storage
+	// class of a data volume doesn't allow volume expansion
+	MessageVolumeExpansionUnsupported = "PVC %q can't be expanded to %s, volume expansion is not allowed by %s"
+)
+
+// expandDataVolumes requests spec.storage on the data volumes of the
+// SQLiteInstance that are smaller, as long as their storage class allows
+// volume expansion. The Resizing condition is set until every volume reports
+// the new capacity, and removed afterwards.
+func (c *Controller) expandDataVolumes(ctx context.Context, instance *kubelitedbv1.SQLiteInstance, status *kubelitedbv1.SQLiteInstanceStatus, replicas int) error {
+	if instance.Spec.VolumeClaimName != "" {
+		meta.RemoveStatusCondition(&status.Conditions, kubelitedbv1.ConditionResizing)
+		return nil
+	}
+	storage, err := resource.ParseQuantity(instance.Spec.Storage)
+	if err != nil {
+		return err
+	}
+
+	if sharesVolume(instance) {
+		replicas = 1
+	}
+	pvcs := c.kubeclientset.CoreV1().PersistentVolumeClaims(instance.Namespace)
+	expanding := ""
+	for ordinal := 0; ordinal < replicas; ordinal++ {
+		pvc, err := c.pvcsLister.PersistentVolumeClaims(instance.Namespace).Get(dataPVCName(instance, ordinal))
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		requested := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+		if requested.Cmp(storage) < 0 {
+			allowed, err := c.allowsVolumeExpansion(ctx, pvc.Spec.StorageClassName)
+			if err != nil {
+				return err
+			}
+			if !allowed {
+				msg := fmt.Sprintf(MessageVolumeExpansionUnsupported, pvc.Name, instance.Spec.Storage, storageClassString(pvc.Spec.StorageClassName))
+				setCondition(status, instance, kubelitedbv1.ConditionResizing, v1.ConditionFalse, ReasonVolumeExpansionUnsupported, msg)
+				c.recorder.Event(instance, corev1.EventTypeWarning, ReasonVolumeExpansionUnsupported, msg)
+				return nil
+			}
+
+			pvcCopy := pvc.DeepCopy()
+			pvcCopy.Spec.Resources.Requests[corev1.ResourceStorage] = storage
+			_, err = pvcs.Update(ctx, pvcCopy, c.updateOptions(ctx, instance, "PersistentVolumeClaim", pvc, pvcCopy))
+			logWrite(ctx, "update", "PersistentVolumeClaim", pvc.Name, err)
+			if err != nil {
+				return err
+			}
+		}
+
+		capacity := pvc.Status.Capacity[corev1.ResourceStorage]
+		if expanding == "" && capacity.Cmp(storage) < 0 {
+			expanding = fmt.Sprintf(MessageVolumeExpanding, pvc.Name, capacity.String(), instance.Spec.Storage)
+		}
+	}
+
+	if expanding != "" {
+		setCondition(status, instance, kubelitedbv1.ConditionResizing, v1.ConditionTrue, ReasonVolumeExpanding, expanding)
+	} else {
+		meta.RemoveStatusCondition(&status.Conditions, kubelitedbv1.ConditionResizing)
+	}
+	return nil
+}
+
+// allowsVolumeExpansion returns whether the given storage class allows
+// volume expansion. PVCs without a storage class can't be expanded.
+func (c *Controller) allowsVolumeExpansion(ctx context.Context, storageClassName *string) (bool, error) {
+	if storageClassName == nil || *storageClassName == "" {
+		return false, nil
+	}
+	storageClass, err := c.kubeclientset.StorageV1().StorageClasses().Get(ctx, *storageClassName, v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return storageClass.AllowVolumeExpansion != nil && *storageClass.AllowVolumeExpansion, nil
+}
@@ -0,0 +1,266 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+func TestStorageShrunk(t *testing.T) {
+	tests := []struct {
+		name    string
+		storage string
+		// Whether the data volume is referenced by spec.volumeClaimName
+		shared bool
+		shrunk bool
+	}{
+		{name: "grow", storage: "3Gi"},
+		{name: "equal", storage: "2Gi"},
+		{name: "equal in other units", storage: "2048Mi"},
+		{name: "shrink", storage: "1Gi", shrunk: true},
+		{name: "shared volume not managed", storage: "1Gi", shared: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := newSQLiteInstance("test")
+			pvc := newDataPVC(instance)
+			pvc.Spec.Resources.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("2Gi")}
+			f.addKubeObject(pvc)
+			instance.Spec.Storage = tt.storage
+			if tt.shared {
+				instance = newSharedSQLiteInstance("test")
+				instance.Spec.Storage = tt.storage
+			}
+			f.addInstance(instance)
+			c, _, _ := f.newController(ctx)
+
+			msg, err := c.storageShrunk(instance)
+			if err != nil {
+				t.Fatalf("error comparing the storage: %v", err)
+			}
+			if (msg != "") != tt.shrunk {
+				t.Errorf("expected shrunk %t, got %q", tt.shrunk, msg)
+			}
+		})
+	}
+}
+
+func TestStorageShrinkBlocksSync(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	instance.Spec.Storage = "2Gi"
+	f.addKubeObject(newStatefulSet(instance))
+	f.addKubeObject(newDataPVC(instance))
+	instance.Spec.Storage = "1Gi"
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+
+	f.run(ctx, c, getKey(instance, t))
+
+	if actions := writes(f.kubeclient.Actions(), "statefulsets"); len(actions) != 0 {
+		t.Errorf("expected the StatefulSet not to be updated, got %v", actions)
+	}
+	if actions := writes(f.kubeclient.Actions(), "persistentvolumeclaims"); len(actions) != 0 {
+		t.Errorf("expected the PVC not to be updated, got %v", actions)
+	}
+	msg := expectEvent(t, f.recorder, corev1.EventTypeWarning, ReasonStorageShrinkForbidden)
+	if want := `Storage cannot be shrunk to 1Gi, PVC "data-test-sqlite-0" already requests 2Gi`; !strings.HasSuffix(msg, want) {
+		t.Errorf("expected an event %q, got %q", want, msg)
+	}
+	status := f.getInstance(ctx, instance).Status
+	for _, conditionType := range []string{kubelitedbv1.ConditionStorageProvisioned, kubelitedbv1.ConditionReady} {
+		condition := meta.FindStatusCondition(status.Conditions, conditionType)
+		if condition == nil || condition.Status != v1.ConditionFalse || condition.Reason != ReasonStorageShrinkForbidden {
+			t.Errorf("expected condition %s False with reason %s, got %v", conditionType, ReasonStorageShrinkForbidden, condition)
+		}
+	}
+}
+
+// newStorageClass returns a storage class allowing volume expansion or not.
+func newStorageClass(name string, allowVolumeExpansion bool) *storagev1.StorageClass {
+	return &storagev1.StorageClass{
+		ObjectMeta:           v1.ObjectMeta{Name: name},
+		Provisioner:          "csi.example.com",
+		AllowVolumeExpansion: &allowVolumeExpansion,
+	}
+}
+
+// newExpandableSQLiteInstance returns a SQLiteInstance requesting 2Gi, which
+// data volume of the given storage class requests and holds the given sizes.
+func newExpandableSQLiteInstance(name string, storageClassName *string, requested, capacity string) (*kubelitedbv1.SQLiteInstance, *corev1.PersistentVolumeClaim) {
+	instance := newSQLiteInstance(name)
+	instance.Spec.StorageClassName = storageClassName
+	pvc := newBoundDataPVC(instance, corev1.ClaimBound, capacity)
+	pvc.Spec.Resources.Requests[corev1.ResourceStorage] = resource.MustParse(requested)
+	instance.Spec.Storage = "2Gi"
+	return instance, pvc
+}
+
+func TestExpandDataVolumes(t *testing.T) {
+	standard, missing := "standard", "missing"
+	tests := []struct {
+		name             string
+		storageClassName *string
+		allowed          bool
+		requested        string
+		capacity         string
+		wantRequested    string
+		wantStatus       v1.ConditionStatus
+		wantReason       string
+	}{
+		{
+			name:             "expansion started",
+			storageClassName: &standard,
+			allowed:          true,
+			requested:        "1Gi",
+			capacity:         "1Gi",
+			wantRequested:    "2Gi",
+			wantStatus:       v1.ConditionTrue,
+			wantReason:       ReasonVolumeExpanding,
+		},
+		{
+			name:             "expansion in progress",
+			storageClassName: &standard,
+			allowed:          true,
+			requested:        "2Gi",
+			capacity:         "1Gi",
+			wantRequested:    "2Gi",
+			wantStatus:       v1.ConditionTrue,
+			wantReason:       ReasonVolumeExpanding,
+		},
+		{
+			name:             "expanded",
+			storageClassName: &standard,
+			allowed:          true,
+			requested:        "2Gi",
+			capacity:         "2Gi",
+			wantRequested:    "2Gi",
+		},
+		{
+			name:             "expansion not allowed",
+			storageClassName: &standard,
+			requested:        "1Gi",
+			capacity:         "1Gi",
+			wantRequested:    "1Gi",
+			wantStatus:       v1.ConditionFalse,
+			wantReason:       ReasonVolumeExpansionUnsupported,
+		},
+		{
+			name:             "storage class not found",
+			storageClassName: &missing,
+			requested:        "1Gi",
+			capacity:         "1Gi",
+			wantRequested:    "1Gi",
+			wantStatus:       v1.ConditionFalse,
+			wantReason:       ReasonVolumeExpansionUnsupported,
+		},
+		{
+			name:          "no storage class",
+			requested:     "1Gi",
+			capacity:      "1Gi",
+			wantRequested: "1Gi",
+			wantStatus:    v1.ConditionFalse,
+			wantReason:    ReasonVolumeExpansionUnsupported,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance, pvc := newExpandableSQLiteInstance("test", tt.storageClassName, tt.requested, tt.capacity)
+			f.addKubeObject(pvc)
+			f.addKubeObject(newStorageClass(standard, tt.allowed))
+			f.addInstance(instance)
+			c, _, _ := f.newController(ctx)
+			status := &kubelitedbv1.SQLiteInstanceStatus{
+				// Left over from an expansion that completed
+				Conditions: []v1.Condition{{Type: kubelitedbv1.ConditionResizing, Status: v1.ConditionTrue, Reason: ReasonVolumeExpanding}},
+			}
+
+			if err := c.expandDataVolumes(ctx, instance, status, 1); err != nil {
+				t.Fatalf("error expanding the data volumes: %v", err)
+			}
+
+			got, err := f.kubeclient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Get(ctx, pvc.Name, v1.GetOptions{})
+			if err != nil {
+				t.Fatalf("error getting the PVC: %v", err)
+			}
+			if requested := got.Spec.Resources.Requests[corev1.ResourceStorage]; requested.String() != tt.wantRequested {
+				t.Errorf("expected the PVC to request %s, got %s", tt.wantRequested, requested.String())
+			}
+			wantWrites := 0
+			if tt.wantRequested != tt.requested {
+				wantWrites = 1
+			}
+			if actions := writes(f.kubeclient.Actions(), "persistentvolumeclaims"); len(actions) != wantWrites {
+				t.Errorf("expected %d PVC writes, got %v", wantWrites, actions)
+			}
+
+			condition := meta.FindStatusCondition(status.Conditions, kubelitedbv1.ConditionResizing)
+			if tt.wantReason == "" {
+				if condition != nil {
+					t.Errorf("expected no %s condition, got %v", kubelitedbv1.ConditionResizing, condition)
+				}
+				return
+			}
+			if condition == nil || condition.Status != tt.wantStatus || condition.Reason != tt.wantReason {
+				t.Errorf("expected condition %s with reason %s, got %v", tt.wantStatus, tt.wantReason, condition)
+			}
+			if tt.wantReason == ReasonVolumeExpansionUnsupported {
+				expectEvent(t, f.recorder, corev1.EventTypeWarning, ReasonVolumeExpansionUnsupported)
+			}
+		})
+	}
+}
+
+func TestStorageGrowthExpandsPVC(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	standard := "standard"
+	instance, pvc := newExpandableSQLiteInstance("test", &standard, "1Gi", "1Gi")
+	f.addKubeObject(pvc)
+	f.addKubeObject(newStorageClass(standard, true))
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+
+	f.run(ctx, c, getKey(instance, t))
+
+	got, err := f.kubeclient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Get(ctx, pvc.Name, v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("error getting the PVC: %v", err)
+	}
+	if requested := got.Spec.Resources.Requests[corev1.ResourceStorage]; requested.String() != "2Gi" {
+		t.Errorf("expected the PVC to request 2Gi, got %s", requested.String())
+	}
+	condition := meta.FindStatusCondition(f.getInstance(ctx, instance).Status.Conditions, kubelitedbv1.ConditionResizing)
+	if condition == nil || condition.Status != v1.ConditionTrue || condition.Message != `PVC "data-test-sqlite-0" is being expanded from 1Gi to 2Gi` {
+		t.Errorf("expected the expansion to be reported, got %v", condition)
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+const (
+	// ReasonVolumeConflict is used as the condition reason when another
+	// SQLiteInstance already keeps its database in the same directory of a
+	// shared volume
+	ReasonVolumeConflict = "VolumeConflict"
+
+	// MessageVolumeConflict is the message used when another SQLiteInstance
+	// already keeps its database in the same directory of a shared volume
+	MessageVolumeConflict = "SQLiteInstance %q already keeps its database in %q of PVC %q"
+)
+
+// dataSubPath returns the directory of the data volume the database of the
+// SQLiteInstance is kept in, or an empty string when it is kept at the root of
+// the volume. The directory is named after the database, without its .db
+// extension.
+func dataSubPath(instance *kubelitedbv1.SQLiteInstance) string {
+	if !instance.Spec.SubPath {
+		return ""
+	}
+	return strings.TrimSuffix(instance.Spec.DbName, ".db")
+}
+
+// dataVolumeMount returns the mount of the data volume of the SQLiteInstance
+// at dataMountPath, restricted to the directory of its database when
+// spec.subPath is set.
+func dataVolumeMount(instance *kubelitedbv1.SQLiteInstance) corev1.VolumeMount {
+	return corev1.VolumeMount{
+		Name:      dataVolumeName,
+		MountPath: dataMountPath,
+		SubPath:   dataSubPath(instance),
+	}
+}
+
+// volumeConflict returns the message describing why the SQLiteInstance can't
+// use the directory of the existing PVC it shares with other SQLiteInstances,
+// or an empty string when it can. When two instances would use the same
+// directory, the one created first keeps it.
+func (c *Controller) volumeConflict(sqliteInstance *kubelitedbv1.SQLiteInstance) (string, error) {
+	claimName := sqliteInstance.Spec.VolumeClaimName
+	if claimName == "" {
+		return "", nil
+	}
+
+	instances, err := c.sqliteInstancesLister.SQLiteInstances(sqliteInstance.Namespace).List(labels.Everything())
+	if err != nil {
+		return "", err
+	}
+	for _, other := range instances {
+		if other.Name == sqliteInstance.Name || other.Spec.VolumeClaimName != claimName || other.DeletionTimestamp != nil {
+			continue
+		}
+		// A database at the root of the volume conflicts with every other
+		// database on it.
+		if dataSubPath(other) != dataSubPath(sqliteInstance) && dataSubPath(other) != "" && dataSubPath(sqliteInstance) != "" {
+			continue
+		}
+		if createdBefore(sqliteInstance, other) {
+			continue
+		}
+		return fmt.Sprintf(MessageVolumeConflict, other.Name, dataSubPath(other), claimName), nil
+	}
+	return "", nil
+}
+
+// createdBefore returns whether the SQLiteInstance was created before the
+// other one, breaking ties by name.
+func createdBefore(instance, other *kubelitedbv1.SQLiteInstance) bool {
+	if !instance.CreationTimestamp.Equal(&other.CreationTimestamp) {
+		return instance.CreationTimestamp.Before(&other.CreationTimestamp)
+	}
+	return instance.Name < other.Name
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// newSubPathSQLiteInstance returns a SQLiteInstance keeping the database of
+// the given name in its directory of the shared PVC, created the given number
+// of minutes after the others.
+func newSubPathSQLiteInstance(name, dbName string, created int) *kubelitedbv1.SQLiteInstance {
+	instance := newSharedSQLiteInstance(name)
+	instance.CreationTimestamp = v1.NewTime(time.Date(2024, 1, 1, 0, created, 0, 0, time.UTC))
+	instance.Spec.DbName = dbName
+	instance.Spec.VolumeClaimName = "shared"
+	instance.Spec.SubPath = true
+	return instance
+}
+
+func TestDataSubPath(t *testing.T) {
+	tests := []struct {
+		dbName  string
+		subPath bool
+		want    string
+	}{
+		{dbName: "app.db"},
+		{dbName: "app.db", subPath: true, want: "app"},
+		{dbName: "orders", subPath: true, want: "orders"},
+		{dbName: "app.db.db", subPath: true, want: "app.db"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %t", tt.dbName, tt.subPath), func(t *testing.T) {
+			instance := newSQLiteInstance("test")
+			instance.Spec.DbName = tt.dbName
+			instance.Spec.SubPath = tt.subPath
+			if got := dataSubPath(instance); got != tt.want {
+				t.Errorf("expected subPath %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDataVolumeMount(t *testing.T) {
+	for _, subPath := range []bool{false, true} {
+		t.Run(fmt.Sprint(subPath), func(t *testing.T) {
+			instance := newSQLiteInstance("test")
+			instance.Spec.SubPath = subPath
+			want := dataVolumeMount(instance)
+			if want.Name != dataVolumeName || want.MountPath != dataMountPath || want.SubPath != dataSubPath(instance) {
+				t.Errorf("expected the data volume at %s restricted to %q, got %+v", dataMountPath, dataSubPath(instance), want)
+			}
+
+			// Every container opening the database sees the same directory
+			spec := newStatefulSet(instance).Spec.Template.Spec
+			var mounts int
+			for _, container := range append(spec.InitContainers, spec.Containers...) {
+				for _, mount := range container.VolumeMounts {
+					if mount.Name != dataVolumeName {
+						continue
+					}
+					mounts++
+					if mount != want {
+						t.Errorf("expected container %s to mount %+v, got %+v", container.Name, want, mount)
+					}
+				}
+			}
+			if mounts == 0 {
+				t.Errorf("expected the data volume to be mounted")
+			}
+		})
+	}
+}
+
+func TestVolumeConflict(t *testing.T) {
+	tests := []struct {
+		name     string
+		instance *kubelitedbv1.SQLiteInstance
+		other    *kubelitedbv1.SQLiteInstance
+		conflict bool
+	}{
+		{
+			name:     "different directories",
+			instance: newSubPathSQLiteInstance("test", "app.db", 1),
+			other:    newSubPathSQLiteInstance("other", "orders.db", 0),
+		},
+		{
+			name:     "same directory of an earlier instance",
+			instance: newSubPathSQLiteInstance("test", "app.db", 1),
+			other:    newSubPathSQLiteInstance("other", "app.db", 0),
+			conflict: true,
+		},
+		{
+			name:     "same directory of a later instance",
+			instance: newSubPathSQLiteInstance("test", "app.db", 0),
+			other:    newSubPathSQLiteInstance("other", "app.db", 1),
+		},
+		{
+			name:     "same directory created together",
+			instance: newSubPathSQLiteInstance("test", "app.db", 0),
+			other:    newSubPathSQLiteInstance("other", "app.db", 0),
+			conflict: true,
+		},
+		{
+			name:     "earlier instance at the root of the volume",
+			instance: newSubPathSQLiteInstance("test", "app.db", 1),
+			other: func() *kubelitedbv1.SQLiteInstance {
+				other := newSubPathSQLiteInstance("other", "orders.db", 0)
+				other.Spec.SubPath = false
+				return other
+			}(),
+			conflict: true,
+		},
+		{
+			name:     "another volume",
+			instance: newSubPathSQLiteInstance("test", "app.db", 1),
+			other: func() *kubelitedbv1.SQLiteInstance {
+				other := newSubPathSQLiteInstance("other", "app.db", 0)
+				other.Spec.VolumeClaimName = "elsewhere"
+				return other
+			}(),
+		},
+		{
+			name:     "earlier instance being deleted",
+			instance: newSubPathSQLiteInstance("test", "app.db", 1),
+			other: func() *kubelitedbv1.SQLiteInstance {
+				other := newSubPathSQLiteInstance("other", "app.db", 0)
+				now := v1.Now()
+				other.DeletionTimestamp = &now
+				return other
+			}(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			f.addInstance(tt.instance)
+			f.addInstance(tt.other)
+			c, _, _ := f.newController(ctx)
+
+			msg, err := c.volumeConflict(tt.instance)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if conflict := msg != ""; conflict != tt.conflict {
+				t.Errorf("expected a conflict %t, got %q", tt.conflict, msg)
+			}
+		})
+	}
+}
+
+func TestVolumeConflictBlocksSync(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	first := newSubPathSQLiteInstance("first", "app.db", 0)
+	second := newSubPathSQLiteInstance("second", "app.db", 1)
+	f.addInstance(first)
+	f.addInstance(second)
+	c, _, _ := f.newController(ctx)
+
+	f.run(ctx, c, getKey(second, t))
+
+	ready := meta.FindStatusCondition(f.getInstance(ctx, second).Status.Conditions, kubelitedbv1.ConditionReady)
+	if ready == nil || ready.Status != v1.ConditionFalse || ready.Reason != ReasonVolumeConflict {
+		t.Errorf("expected Ready to be false for the volume conflict, got %+v", ready)
+	}
+	expectEvent(t, f.recorder, corev1.EventTypeWarning, ReasonVolumeConflict)
+	if _, err := f.kubeclient.AppsV1().StatefulSets(second.Namespace).Get(ctx, statefulSetName(second), v1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("expected no StatefulSet for the conflicting SQLiteInstance, got %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+const (
+	// ReasonSuspended is used as the condition reason while the pods are
+	// scaled to zero by spec.suspend
+	ReasonSuspended = "Suspended"
+
+	// MessageSuspended is the message used while the pods are scaled to zero
+	// by spec.suspend
+	MessageSuspended = "The pods are scaled to zero by spec.suspend, the volumes are kept"
+)
+
+// setSuspendedCondition sets the Suspended condition while spec.suspend is
+// set, and removes it once the SQLiteInstance is resumed.
+func setSuspendedCondition(status *kubelitedbv1.SQLiteInstanceStatus, instance *kubelitedbv1.SQLiteInstance) {
+	if !instance.Spec.Suspend {
+		meta.RemoveStatusCondition(&status.Conditions, kubelitedbv1.ConditionSuspended)
+		return
+	}
+	setCondition(status, instance, kubelitedbv1.ConditionSuspended, v1.ConditionTrue, ReasonSuspended, MessageSuspended)
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+func TestSuspend(t *testing.T) {
+	tests := []struct {
+		name string
+		// Whether the StatefulSet was scaled to zero by an earlier suspend
+		wasSuspended bool
+		suspend      bool
+		wantReplicas int32
+		wantPhase    kubelitedbv1.SQLiteInstancePhase
+	}{
+		{name: "suspend", suspend: true, wantReplicas: 0, wantPhase: kubelitedbv1.PhaseSuspended},
+		{name: "stay suspended", wasSuspended: true, suspend: true, wantReplicas: 0, wantPhase: kubelitedbv1.PhaseSuspended},
+		{name: "resume", wasSuspended: true, wantReplicas: 1, wantPhase: kubelitedbv1.PhaseProvisioning},
+		{name: "running", wantReplicas: 1, wantPhase: kubelitedbv1.PhaseRunning},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := newSQLiteInstance("test")
+			instance.Spec.ReadReplicas = 1
+			instance.Spec.Suspend = tt.wasSuspended
+			statefulSet := newStatefulSet(instance)
+			if !tt.wasSuspended {
+				statefulSet.Status.Replicas = 1
+				statefulSet.Status.ReadyReplicas = 1
+				statefulSet.Status.UpdatedReplicas = 1
+			}
+			f.addKubeObject(statefulSet)
+			f.addKubeObject(newBoundDataPVC(instance, corev1.ClaimBound, "1Gi"))
+			instance.Spec.Suspend = tt.suspend
+			if tt.wasSuspended {
+				instance.Status.Conditions = []v1.Condition{{Type: kubelitedbv1.ConditionSuspended, Status: v1.ConditionTrue, Reason: ReasonSuspended}}
+			}
+			f.addInstance(instance)
+			c, _, _ := f.newController(ctx)
+
+			f.run(ctx, c, getKey(instance, t))
+
+			if got := *f.getStatefulSet(ctx, instance).Spec.Replicas; got != tt.wantReplicas {
+				t.Errorf("expected %d writer replicas, got %d", tt.wantReplicas, got)
+			}
+			readers, err := f.kubeclient.AppsV1().Deployments(instance.Namespace).Get(ctx, readerDeploymentName(instance), v1.GetOptions{})
+			if err != nil {
+				t.Fatalf("error getting the readers: %v", err)
+			}
+			if got := *readers.Spec.Replicas; got != tt.wantReplicas {
+				t.Errorf("expected %d read-only replicas, got %d", tt.wantReplicas, got)
+			}
+			// The volumes are kept, and still reported, while suspended
+			if actions := writes(f.kubeclient.Actions(), "persistentvolumeclaims"); len(actions) != 0 {
+				t.Errorf("expected the PVC to be kept, got %v", actions)
+			}
+
+			status := f.getInstance(ctx, instance).Status
+			if status.Phase != tt.wantPhase {
+				t.Errorf("expected phase %s, got %s", tt.wantPhase, status.Phase)
+			}
+			if len(status.Volumes) != 1 {
+				t.Errorf("expected the data volume in the status, got %v", status.Volumes)
+			}
+			suspended := meta.FindStatusCondition(status.Conditions, kubelitedbv1.ConditionSuspended)
+			ready := meta.FindStatusCondition(status.Conditions, kubelitedbv1.ConditionReady)
+			if !tt.suspend {
+				if suspended != nil {
+					t.Errorf("expected no %s condition, got %v", kubelitedbv1.ConditionSuspended, suspended)
+				}
+				return
+			}
+			if suspended == nil || suspended.Status != v1.ConditionTrue || suspended.Reason != ReasonSuspended {
+				t.Errorf("expected condition %s True, got %v", kubelitedbv1.ConditionSuspended, suspended)
+			}
+			if ready == nil || ready.Status != v1.ConditionFalse || ready.Reason != ReasonSuspended {
+				t.Errorf("expected condition Ready False with reason %s, got %v", ReasonSuspended, ready)
+			}
+			// Suspending keeps the volumes, so it isn't blocked like scaling to zero
+			if blocked := meta.FindStatusCondition(status.Conditions, kubelitedbv1.ConditionScaleDownBlocked); blocked != nil && blocked.Status == v1.ConditionTrue {
+				t.Errorf("expected the suspend not to be blocked, got %v", blocked)
+			}
+		})
+	}
+}
+
+func TestSuspendKeepsTemplate(t *testing.T) {
+	instance := newSQLiteInstance("test")
+	suspended := instance.DeepCopy()
+	suspended.Spec.Suspend = true
+
+	// Resuming brings back the same pods, without a rollout
+	if got, want := newStatefulSet(suspended).Spec.Template.Annotations[templateHashAnnotation], newStatefulSet(instance).Spec.Template.Annotations[templateHashAnnotation]; got != want {
+		t.Errorf("expected the template hash %q to be kept while suspended, got %q", want, got)
+	}
+}
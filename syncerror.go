@@ -0,0 +1,80 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// ReasonReconcileError is the reason recorded for errors that are not
+// returned by the API server
+const ReasonReconcileError = "ReconcileError"
+
+// reasonForSyncError returns the reason recorded in the status for an error
+// returned by syncHandler.
+func reasonForSyncError(err error) string {
+	if reason := apierrors.ReasonForError(err); reason != v1.StatusReasonUnknown {
+		return string(reason)
+	}
+	return ReasonReconcileError
+}
+
+// newSyncError returns the error recorded in the status for err, given the
+// previously recorded one. Repeating the previous error only increments the
+// retry count, so retries don't cause the status to flap.
+func newSyncError(previous *kubelitedbv1.SyncError, err error, now v1.Time) *kubelitedbv1.SyncError {
+	syncError := &kubelitedbv1.SyncError{
+		Reason:  reasonForSyncError(err),
+		Message: err.Error(),
+		Time:    now,
+	}
+	if previous != nil && previous.Reason == syncError.Reason && previous.Message == syncError.Message {
+		syncError.Time = previous.Time
+		syncError.RetryCount = previous.RetryCount + 1
+	}
+	return syncError
+}
+
+// recordSyncError records the error returned by syncHandler for the
+// SQLiteInstance with the given key in its status. The error is cleared by
+// the next successful sync. Failing to record it is only logged, as the sync
+// is retried anyway.
+func (c *Controller) recordSyncError(ctx context.Context, key string, syncErr error) {
+	logger := klog.FromContext(ctx)
+
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return
+	}
+	sqliteInstance, err := c.sqliteInstancesLister.SQLiteInstances(namespace).Get(name)
+	if err != nil || sqliteInstance.DeletionTimestamp != nil {
+		return
+	}
+
+	status := sqliteInstance.Status.DeepCopy()
+	status.LastError = newSyncError(sqliteInstance.Status.LastError, syncErr, v1.Now())
+	if err := c.updateSQLiteInstanceStatus(ctx, sqliteInstance, status); err != nil {
+		logger.V(4).Info("Failed to record sync error", "sqliteInstance", klog.KObj(sqliteInstance), "err", err)
+	}
+}
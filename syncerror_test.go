@@ -0,0 +1,159 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	core "k8s.io/client-go/testing"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+func TestNewSyncError(t *testing.T) {
+	earlier := v1.NewTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	now := v1.NewTime(earlier.Add(time.Minute))
+	conflict := apierrors.NewConflict(schema.GroupResource{Group: "apps", Resource: "statefulsets"}, "test", fmt.Errorf("stale"))
+
+	tests := []struct {
+		name           string
+		previous       *kubelitedbv1.SyncError
+		err            error
+		wantReason     string
+		wantTime       v1.Time
+		wantRetryCount int32
+	}{
+		{
+			name:       "first error",
+			err:        fmt.Errorf("boom"),
+			wantReason: ReasonReconcileError,
+			wantTime:   now,
+		},
+		{
+			name:       "api error",
+			err:        conflict,
+			wantReason: string(v1.StatusReasonConflict),
+			wantTime:   now,
+		},
+		{
+			name:           "repeated error",
+			previous:       &kubelitedbv1.SyncError{Reason: ReasonReconcileError, Message: "boom", Time: earlier, RetryCount: 2},
+			err:            fmt.Errorf("boom"),
+			wantReason:     ReasonReconcileError,
+			wantTime:       earlier,
+			wantRetryCount: 3,
+		},
+		{
+			name:       "different message",
+			previous:   &kubelitedbv1.SyncError{Reason: ReasonReconcileError, Message: "boom", Time: earlier, RetryCount: 2},
+			err:        fmt.Errorf("bang"),
+			wantReason: ReasonReconcileError,
+			wantTime:   now,
+		},
+		{
+			name:       "different reason",
+			previous:   &kubelitedbv1.SyncError{Reason: ReasonReconcileError, Message: conflict.Error(), Time: earlier, RetryCount: 2},
+			err:        conflict,
+			wantReason: string(v1.StatusReasonConflict),
+			wantTime:   now,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newSyncError(tt.previous, tt.err, now)
+			if got.Reason != tt.wantReason {
+				t.Errorf("expected reason %q, got %q", tt.wantReason, got.Reason)
+			}
+			if got.Message != tt.err.Error() {
+				t.Errorf("expected message %q, got %q", tt.err.Error(), got.Message)
+			}
+			if !got.Time.Equal(&tt.wantTime) {
+				t.Errorf("expected time %v, got %v", tt.wantTime, got.Time)
+			}
+			if got.RetryCount != tt.wantRetryCount {
+				t.Errorf("expected retry count %d, got %d", tt.wantRetryCount, got.RetryCount)
+			}
+		})
+	}
+}
+
+func TestRecordSyncError(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+	defer c.workqueue.ShutDown()
+
+	fail := true
+	f.kubeclient.PrependReactor("create", "statefulsets", func(action core.Action) (bool, runtime.Object, error) {
+		if fail {
+			return true, nil, fmt.Errorf("injected error")
+		}
+		return false, nil, nil
+	})
+	key := getKey(instance, t)
+
+	for i := int32(0); i < 2; i++ {
+		c.workqueue.Add(key)
+		c.processNextWorkItem(ctx)
+		lastError := f.getInstance(ctx, instance).Status.LastError
+		if lastError == nil {
+			t.Fatalf("expected the sync error to be recorded")
+		}
+		if lastError.Reason != ReasonReconcileError {
+			t.Errorf("expected reason %q, got %q", ReasonReconcileError, lastError.Reason)
+		}
+		if lastError.RetryCount != i {
+			t.Errorf("expected retry count %d, got %d", i, lastError.RetryCount)
+		}
+		f.refreshCaches(ctx)
+	}
+
+	fail = false
+	c.workqueue.Add(key)
+	c.processNextWorkItem(ctx)
+	if lastError := f.getInstance(ctx, instance).Status.LastError; lastError != nil {
+		t.Errorf("expected the sync error to be cleared, got %+v", lastError)
+	}
+}
+
+func TestRecordSyncErrorSkipsDeletedInstance(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	now := v1.Now()
+	instance.DeletionTimestamp = &now
+	instance.Finalizers = []string{"test"}
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+	defer c.workqueue.ShutDown()
+	f.client.ClearActions()
+
+	c.recordSyncError(ctx, getKey(instance, t), fmt.Errorf("boom"))
+
+	if n := len(writes(f.client.Actions(), "sqliteinstances")); n != 0 {
+		t.Errorf("expected no status writes for a deleted instance, got %d", n)
+	}
+}
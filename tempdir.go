@@ -0,0 +1,66 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+const (
+	// tempDirVolumeName is the name of the emptyDir volume SQLite keeps its
+	// temp files on
+	tempDirVolumeName = "sqlite-tmp"
+	// tempDirMountPath is where the temp directory volume is mounted in the
+	// SQLite container
+	tempDirMountPath = "/tmp/sqlite"
+)
+
+// addTempDir mounts an emptyDir volume into the SQLite container and points
+// SQLITE_TMPDIR at it, so the temp files of SQLite don't end up on the
+// container filesystem. The size limit was validated by the webhook.
+func addTempDir(instance *kubelitedbv1.SQLiteInstance, template *corev1.PodTemplateSpec) {
+	tempDir := instance.Spec.TempDir
+	emptyDir := &corev1.EmptyDirVolumeSource{
+		Medium: tempDir.Medium,
+	}
+	if tempDir.SizeLimit != "" {
+		if sizeLimit, err := resource.ParseQuantity(tempDir.SizeLimit); err == nil {
+			emptyDir.SizeLimit = &sizeLimit
+		}
+	}
+
+	for i := range template.Spec.Containers {
+		container := &template.Spec.Containers[i]
+		if container.Name != "sqlite" {
+			continue
+		}
+		container.Env = append(container.Env, corev1.EnvVar{Name: "SQLITE_TMPDIR", Value: tempDirMountPath})
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      tempDirVolumeName,
+			MountPath: tempDirMountPath,
+		})
+	}
+	template.Spec.Volumes = append(template.Spec.Volumes, corev1.Volume{
+		Name: tempDirVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: emptyDir,
+		},
+	})
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// volume returns the volume of the given name, failing the test when there is
+// none.
+func volume(t *testing.T, volumes []corev1.Volume, name string) *corev1.Volume {
+	t.Helper()
+	for i := range volumes {
+		if volumes[i].Name == name {
+			return &volumes[i]
+		}
+	}
+	t.Fatalf("expected a %s volume, got %v", name, volumes)
+	return nil
+}
+
+func TestTempDir(t *testing.T) {
+	sizeLimit := resource.MustParse("256Mi")
+	tests := []struct {
+		name    string
+		tempDir *kubelitedbv1.TempDirSpec
+		want    *corev1.EmptyDirVolumeSource
+	}{
+		{name: "disabled"},
+		{name: "node disk", tempDir: &kubelitedbv1.TempDirSpec{}, want: &corev1.EmptyDirVolumeSource{}},
+		{
+			name:    "memory with a size limit",
+			tempDir: &kubelitedbv1.TempDirSpec{Medium: corev1.StorageMediumMemory, SizeLimit: "256Mi"},
+			want:    &corev1.EmptyDirVolumeSource{Medium: corev1.StorageMediumMemory, SizeLimit: &sizeLimit},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newSQLiteInstance("test")
+			instance.Spec.ReadReplicas = 1
+			instance.Spec.TempDir = tt.tempDir
+
+			// The readers run SQLite too, so they get the same temp directory
+			for kind, podSpec := range map[string]corev1.PodSpec{
+				"StatefulSet": newStatefulSet(instance).Spec.Template.Spec,
+				"Deployment":  newReaderDeployment(instance).Spec.Template.Spec,
+			} {
+				sqlite := container(t, podSpec.Containers, "sqlite")
+				if tt.want == nil {
+					for _, v := range podSpec.Volumes {
+						if v.Name == tempDirVolumeName {
+							t.Errorf("expected no %s volume in the %s", tempDirVolumeName, kind)
+						}
+					}
+					if got := envValue(sqlite, "SQLITE_TMPDIR"); got != "" {
+						t.Errorf("expected SQLITE_TMPDIR to be unset in the %s, got %q", kind, got)
+					}
+					continue
+				}
+
+				emptyDir := volume(t, podSpec.Volumes, tempDirVolumeName).EmptyDir
+				if emptyDir == nil || emptyDir.Medium != tt.want.Medium ||
+					(emptyDir.SizeLimit == nil) != (tt.want.SizeLimit == nil) ||
+					(emptyDir.SizeLimit != nil && emptyDir.SizeLimit.Cmp(*tt.want.SizeLimit) != 0) {
+					t.Errorf("expected the %s to have the emptyDir %v, got %v", kind, tt.want, emptyDir)
+				}
+				mounted := false
+				for _, mount := range sqlite.VolumeMounts {
+					mounted = mounted || (mount.Name == tempDirVolumeName && mount.MountPath == tempDirMountPath)
+				}
+				if !mounted {
+					t.Errorf("expected the %s to mount the temp directory at %s, got %v", kind, tempDirMountPath, sqlite.VolumeMounts)
+				}
+				if got := envValue(sqlite, "SQLITE_TMPDIR"); got != tempDirMountPath {
+					t.Errorf("expected SQLITE_TMPDIR to be %s in the %s, got %q", tempDirMountPath, kind, got)
+				}
+			}
+		})
+	}
+}
+
+func TestTempDirOnlyMountedInSQLite(t *testing.T) {
+	instance := newSQLiteInstance("test")
+	instance.Spec.TempDir = &kubelitedbv1.TempDirSpec{}
+	instance.Spec.TLS = &kubelitedbv1.TLSSpec{SecretRef: corev1.LocalObjectReference{Name: "cert"}, Port: 8443}
+
+	for _, c := range newStatefulSet(instance).Spec.Template.Spec.Containers {
+		if c.Name == "sqlite" {
+			continue
+		}
+		for _, mount := range c.VolumeMounts {
+			if mount.Name == tempDirVolumeName {
+				t.Errorf("expected container %s not to mount the temp directory", c.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+const (
+	// tlsProxyImage is the container image terminating TLS in front of the
+	// SQLite container
+	tlsProxyImage = "ghostunnel/ghostunnel:v1.7.3"
+	// tlsPortName is the name of the port serving TLS connections
+	tlsPortName = "tls"
+	// tlsVolumeName is the name of the volume holding the certificate
+	tlsVolumeName = "tls"
+	// tlsMountPath is where the certificate is mounted in the proxy
+	tlsMountPath = "/etc/kubelitedb/tls"
+)
+
+// tlsSecretHashAnnotation records the hash of the certificate on the pod
+// template, so rotating the certificate rolls the pods.
+const tlsSecretHashAnnotation = "kubelitedb.fortytwoapps.tech/tls-secret-hash"
+
+// addTLSProxySidecar adds the sidecar terminating TLS on kubelitedbv1.TLSPort and
+// forwarding the connections to the plaintext port of the SQLite container in
+// the same pod.
+func addTLSProxySidecar(instance *kubelitedbv1.SQLiteInstance, template *corev1.PodTemplateSpec) {
+	tls := instance.Spec.TLS
+	template.Spec.Containers = append(template.Spec.Containers, corev1.Container{
+		Name:  "tls-proxy",
+		Image: tlsProxyImage,
+		Args: []string{
+			"server",
+			fmt.Sprintf("--listen=0.0.0.0:%d", kubelitedbv1.TLSPort),
+			fmt.Sprintf("--target=127.0.0.1:%d", tls.Port),
+			fmt.Sprintf("--cert=%s/%s", tlsMountPath, corev1.TLSCertKey),
+			fmt.Sprintf("--key=%s/%s", tlsMountPath, corev1.TLSPrivateKeyKey),
+			"--disable-authentication",
+		},
+		Ports: []corev1.ContainerPort{
+			{
+				Name:          tlsPortName,
+				ContainerPort: kubelitedbv1.TLSPort,
+				Protocol:      corev1.ProtocolTCP,
+			},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      tlsVolumeName,
+				MountPath: tlsMountPath,
+				ReadOnly:  true,
+			},
+		},
+	})
+	template.Spec.Volumes = append(template.Spec.Volumes, corev1.Volume{
+		Name: tlsVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: tls.SecretRef.Name,
+			},
+		},
+	})
+}
+
+// servicePorts returns the ports exposed by the Services of the
+// SQLiteInstance. Only the TLS port is exposed, and only when TLS is set.
+func servicePorts(instance *kubelitedbv1.SQLiteInstance) []corev1.ServicePort {
+	if instance.Spec.TLS == nil {
+		return nil
+	}
+	return []corev1.ServicePort{
+		{
+			Name:       tlsPortName,
+			Port:       kubelitedbv1.TLSPort,
+			TargetPort: intstr.FromString(tlsPortName),
+			Protocol:   corev1.ProtocolTCP,
+		},
+	}
+}
+
+// tlsSecretHash returns the hash of the certificate referenced by spec.tls,
+// or an empty string when TLS is not set.
+func (c *Controller) tlsSecretHash(ctx context.Context, sqliteInstance *kubelitedbv1.SQLiteInstance) (string, error) {
+	if sqliteInstance.Spec.TLS == nil {
+		return "", nil
+	}
+	secret, err := c.kubeclientset.CoreV1().Secrets(sqliteInstance.Namespace).Get(ctx, sqliteInstance.Spec.TLS.SecretRef.Name, v1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get TLS secret %q: %w", sqliteInstance.Spec.TLS.SecretRef.Name, err)
+	}
+	return computeHash(secret.Data), nil
+}
+
+// setTLSSecretHash records the hash of the certificate on the pod template and
+// updates the template hash accordingly. Templates without TLS are left as is.
+func setTLSSecretHash(template *corev1.PodTemplateSpec, hash string) {
+	if hash == "" {
+		return
+	}
+	delete(template.Annotations, templateHashAnnotation)
+	template.Annotations[tlsSecretHashAnnotation] = hash
+	template.Annotations[templateHashAnnotation] = computeHash(*template)
+}
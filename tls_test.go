@@ -0,0 +1,158 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"slices"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// newTLSSecret returns the certificate Secret served by the SQLiteInstances
+// of newTLSSQLiteInstance.
+func newTLSSecret(cert string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{Name: "db-tls", Namespace: v1.NamespaceDefault},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       []byte(cert),
+			corev1.TLSPrivateKeyKey: []byte("key"),
+		},
+	}
+}
+
+// newTLSSQLiteInstance returns a SQLiteInstance serving TLS with the
+// certificate of newTLSSecret.
+func newTLSSQLiteInstance(name string) *kubelitedbv1.SQLiteInstance {
+	instance := newSQLiteInstance(name)
+	instance.Spec.TLS = &kubelitedbv1.TLSSpec{SecretRef: corev1.LocalObjectReference{Name: "db-tls"}, Port: 5432}
+	return instance
+}
+
+func TestTLS(t *testing.T) {
+	tests := []struct {
+		name     string
+		instance *kubelitedbv1.SQLiteInstance
+		tls      bool
+	}{
+		{name: "plaintext", instance: newSQLiteInstance("test")},
+		{name: "TLS", instance: newTLSSQLiteInstance("test"), tls: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			f.addInstance(tt.instance)
+			f.addKubeObject(newTLSSecret("cert"))
+			c, _, _ := f.newController(ctx)
+
+			f.run(ctx, c, getKey(tt.instance, t))
+
+			template := f.getStatefulSet(ctx, tt.instance).Spec.Template
+			service, err := f.kubeclient.CoreV1().Services(tt.instance.Namespace).Get(ctx, headlessServiceName(tt.instance), v1.GetOptions{})
+			if err != nil {
+				t.Fatalf("error getting the headless Service: %v", err)
+			}
+			hasProxy := slices.ContainsFunc(template.Spec.Containers, func(container corev1.Container) bool { return container.Name == "tls-proxy" })
+			if !tt.tls {
+				if hasProxy {
+					t.Errorf("expected no tls-proxy sidecar, got %v", template.Spec.Containers)
+				}
+				if len(service.Spec.Ports) != 0 {
+					t.Errorf("expected no ports on the Service, got %v", service.Spec.Ports)
+				}
+				if _, ok := template.Annotations[tlsSecretHashAnnotation]; ok {
+					t.Errorf("expected no %s annotation", tlsSecretHashAnnotation)
+				}
+				return
+			}
+
+			proxy := container(t, template.Spec.Containers, "tls-proxy")
+			wantPort := corev1.ContainerPort{Name: tlsPortName, ContainerPort: kubelitedbv1.TLSPort, Protocol: corev1.ProtocolTCP}
+			if !slices.Equal(proxy.Ports, []corev1.ContainerPort{wantPort}) {
+				t.Errorf("expected the proxy to serve %v, got %v", wantPort, proxy.Ports)
+			}
+			if !slices.Contains(proxy.Args, "--target=127.0.0.1:5432") {
+				t.Errorf("expected the proxy to forward to the plaintext port, got %v", proxy.Args)
+			}
+			i := slices.IndexFunc(template.Spec.Volumes, func(volume corev1.Volume) bool { return volume.Name == tlsVolumeName })
+			if i < 0 || template.Spec.Volumes[i].Secret == nil || template.Spec.Volumes[i].Secret.SecretName != "db-tls" {
+				t.Errorf("expected the db-tls Secret to be mounted, got %v", template.Spec.Volumes)
+			}
+			if len(service.Spec.Ports) != 1 || service.Spec.Ports[0].Name != tlsPortName || service.Spec.Ports[0].Port != kubelitedbv1.TLSPort {
+				t.Errorf("expected the Service to expose the %s port %d, got %v", tlsPortName, kubelitedbv1.TLSPort, service.Spec.Ports)
+			}
+			if got, want := template.Annotations[tlsSecretHashAnnotation], computeHash(newTLSSecret("cert").Data); got != want {
+				t.Errorf("expected the %s annotation %q, got %q", tlsSecretHashAnnotation, want, got)
+			}
+		})
+	}
+}
+
+func TestTLSSecretRotationRollsPods(t *testing.T) {
+	tests := []struct {
+		name string
+		cert string
+		want bool
+	}{
+		{name: "unchanged", cert: "cert"},
+		{name: "rotated", cert: "rotated", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := newTLSSQLiteInstance("test")
+			sts := newStatefulSet(instance)
+			setTLSSecretHash(&sts.Spec.Template, computeHash(newTLSSecret("cert").Data))
+			f.addKubeObject(sts)
+			f.addKubeObject(newTLSSecret(tt.cert))
+			f.addInstance(instance)
+			c, _, _ := f.newController(ctx)
+
+			f.run(ctx, c, getKey(instance, t))
+
+			got := f.getStatefulSet(ctx, instance).Spec.Template.Annotations
+			if rolled := got[templateHashAnnotation] != sts.Spec.Template.Annotations[templateHashAnnotation]; rolled != tt.want {
+				t.Errorf("expected the pods to roll %t, got template hash %q from %q", tt.want, got[templateHashAnnotation], sts.Spec.Template.Annotations[templateHashAnnotation])
+			}
+			if want := computeHash(newTLSSecret(tt.cert).Data); got[tlsSecretHashAnnotation] != want {
+				t.Errorf("expected the %s annotation %q, got %q", tlsSecretHashAnnotation, want, got[tlsSecretHashAnnotation])
+			}
+		})
+	}
+}
+
+func TestTLSSecretNotFound(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newTLSSQLiteInstance("test")
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+
+	if _, err := c.syncHandler(ctx, getKey(instance, t)); err == nil {
+		t.Errorf("expected an error for a missing TLS Secret")
+	}
+	if n := len(writes(f.kubeclient.Actions(), "statefulsets")); n > 0 {
+		t.Errorf("expected no StatefulSet without the certificate, got %d writes", n)
+	}
+}
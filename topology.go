@@ -0,0 +1,49 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// topologySpreadConstraintsForInstance returns the topology spread
+// constraints of the pods of the StatefulSet. With spec.spreadAcrossZones
+// the pods are also spread across zones, unless the spec already spreads
+// them by zone. The pods are still scheduled when the zones can't be
+// balanced, as the zone of a pod is fixed by its volume once provisioned.
+func topologySpreadConstraintsForInstance(instance *kubelitedbv1.SQLiteInstance) []corev1.TopologySpreadConstraint {
+	constraints := instance.Spec.TopologySpreadConstraints
+	if !instance.Spec.SpreadAcrossZones {
+		return constraints
+	}
+	for _, constraint := range constraints {
+		if constraint.TopologyKey == corev1.LabelTopologyZone {
+			return constraints
+		}
+	}
+	return append(append([]corev1.TopologySpreadConstraint{}, constraints...), corev1.TopologySpreadConstraint{
+		MaxSkew:           1,
+		TopologyKey:       corev1.LabelTopologyZone,
+		WhenUnsatisfiable: corev1.ScheduleAnyway,
+		LabelSelector: &v1.LabelSelector{
+			MatchLabels: podLabels(instance),
+		},
+	})
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2/ktesting"
+)
+
+func TestTopologySpreadConstraints(t *testing.T) {
+	byHostname := corev1.TopologySpreadConstraint{
+		MaxSkew:           1,
+		TopologyKey:       corev1.LabelHostname,
+		WhenUnsatisfiable: corev1.DoNotSchedule,
+	}
+	byZone := corev1.TopologySpreadConstraint{
+		MaxSkew:           2,
+		TopologyKey:       corev1.LabelTopologyZone,
+		WhenUnsatisfiable: corev1.DoNotSchedule,
+	}
+	instance := newSQLiteInstance("test")
+	defaultZone := corev1.TopologySpreadConstraint{
+		MaxSkew:           1,
+		TopologyKey:       corev1.LabelTopologyZone,
+		WhenUnsatisfiable: corev1.ScheduleAnyway,
+		LabelSelector:     &v1.LabelSelector{MatchLabels: podLabels(instance)},
+	}
+	tests := []struct {
+		name              string
+		constraints       []corev1.TopologySpreadConstraint
+		spreadAcrossZones bool
+		want              []corev1.TopologySpreadConstraint
+	}{
+		{name: "unset"},
+		{
+			name:        "explicit",
+			constraints: []corev1.TopologySpreadConstraint{byHostname},
+			want:        []corev1.TopologySpreadConstraint{byHostname},
+		},
+		{
+			name:              "spread across zones",
+			spreadAcrossZones: true,
+			want:              []corev1.TopologySpreadConstraint{defaultZone},
+		},
+		{
+			name:              "spread across zones with explicit constraints",
+			constraints:       []corev1.TopologySpreadConstraint{byHostname},
+			spreadAcrossZones: true,
+			want:              []corev1.TopologySpreadConstraint{byHostname, defaultZone},
+		},
+		{
+			name:              "spread across zones already spread by zone",
+			constraints:       []corev1.TopologySpreadConstraint{byHostname, byZone},
+			spreadAcrossZones: true,
+			want:              []corev1.TopologySpreadConstraint{byHostname, byZone},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := instance.DeepCopy()
+			instance.Spec.ReadReplicas = 1
+			instance.Spec.TopologySpreadConstraints = tt.constraints
+			instance.Spec.SpreadAcrossZones = tt.spreadAcrossZones
+
+			got := newStatefulSet(instance).Spec.Template.Spec.TopologySpreadConstraints
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected the constraints %+v, got %+v", tt.want, got)
+			}
+			if !reflect.DeepEqual(instance.Spec.TopologySpreadConstraints, tt.constraints) {
+				t.Errorf("expected the constraints of the spec to be left as is, got %+v", instance.Spec.TopologySpreadConstraints)
+			}
+			// The read-only pods are scheduled by their own affinity
+			if got := newReaderDeployment(instance).Spec.Template.Spec.TopologySpreadConstraints; len(got) != 0 {
+				t.Errorf("expected no constraints on the readers, got %+v", got)
+			}
+		})
+	}
+}
+
+func TestSpreadAcrossZonesSelectsThePods(t *testing.T) {
+	instance := newSQLiteInstance("test")
+	instance.Spec.SpreadAcrossZones = true
+	statefulSet := newStatefulSet(instance)
+
+	constraints := statefulSet.Spec.Template.Spec.TopologySpreadConstraints
+	if len(constraints) != 1 {
+		t.Fatalf("expected a single constraint, got %+v", constraints)
+	}
+	selector, err := v1.LabelSelectorAsSelector(constraints[0].LabelSelector)
+	if err != nil {
+		t.Fatalf("error parsing the selector: %v", err)
+	}
+	if !selector.Matches(labels.Set(statefulSet.Spec.Template.Labels)) {
+		t.Errorf("expected the selector %s to match the pods labelled %v", selector, statefulSet.Spec.Template.Labels)
+	}
+}
+
+func TestSpreadAcrossZonesUpdatesStatefulSet(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+	f.run(ctx, c, getKey(instance, t))
+
+	updated := f.getInstance(ctx, instance)
+	updated.Spec.SpreadAcrossZones = true
+	updated.Generation++
+	if _, err := f.client.KubelitedbV1().SQLiteInstances(instance.Namespace).Update(ctx, updated, v1.UpdateOptions{}); err != nil {
+		t.Fatalf("error updating the SQLiteInstance: %v", err)
+	}
+	f.refreshCaches(ctx)
+	f.kubeclient.ClearActions()
+
+	f.run(ctx, c, getKey(instance, t))
+
+	if actions := writes(f.kubeclient.Actions(), "statefulsets"); len(actions) != 1 || actions[0].GetVerb() != "update" {
+		t.Fatalf("expected the StatefulSet to be updated, got %v", actions)
+	}
+	constraints := f.getStatefulSet(ctx, instance).Spec.Template.Spec.TopologySpreadConstraints
+	if len(constraints) != 1 || constraints[0].TopologyKey != corev1.LabelTopologyZone {
+		t.Errorf("expected the pods to be spread across zones, got %+v", constraints)
+	}
+}
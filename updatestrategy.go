@@ -0,0 +1,61 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// updateStrategyForInstance returns the update strategy of the StatefulSet of
+// the SQLiteInstance, defaulted the way the API server defaults it so it can
+// be compared with the current one.
+func updateStrategyForInstance(instance *kubelitedbv1.SQLiteInstance) appsv1.StatefulSetUpdateStrategy {
+	var spec kubelitedbv1.UpdateStrategy
+	if instance.Spec.UpdateStrategy != nil {
+		spec = *instance.Spec.UpdateStrategy
+	}
+	if spec.Type == appsv1.OnDeleteStatefulSetStrategyType {
+		return appsv1.StatefulSetUpdateStrategy{Type: appsv1.OnDeleteStatefulSetStrategyType}
+	}
+	partition := int32(0)
+	if spec.Partition != nil {
+		partition = *spec.Partition
+	}
+	return appsv1.StatefulSetUpdateStrategy{
+		Type: appsv1.RollingUpdateStatefulSetStrategyType,
+		RollingUpdate: &appsv1.RollingUpdateStatefulSetStrategy{
+			Partition: &partition,
+		},
+	}
+}
+
+// updateStrategyOutOfDate returns whether the current update strategy of a
+// StatefulSet differs from the desired type or partition. Other fields
+// defaulted by the API server are ignored.
+func updateStrategyOutOfDate(current, desired appsv1.StatefulSetUpdateStrategy) bool {
+	return current.Type != desired.Type || partitionOf(current) != partitionOf(desired)
+}
+
+// partitionOf returns the partition of an update strategy, 0 when unset.
+func partitionOf(strategy appsv1.StatefulSetUpdateStrategy) int32 {
+	if strategy.RollingUpdate == nil || strategy.RollingUpdate.Partition == nil {
+		return 0
+	}
+	return *strategy.RollingUpdate.Partition
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/klog/v2/ktesting"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// rollingUpdate returns a RollingUpdate strategy with the given partition.
+func rollingUpdate(partition int32) appsv1.StatefulSetUpdateStrategy {
+	return appsv1.StatefulSetUpdateStrategy{
+		Type:          appsv1.RollingUpdateStatefulSetStrategyType,
+		RollingUpdate: &appsv1.RollingUpdateStatefulSetStrategy{Partition: &partition},
+	}
+}
+
+func TestUpdateStrategy(t *testing.T) {
+	partition := int32(2)
+	tests := []struct {
+		name     string
+		strategy *kubelitedbv1.UpdateStrategy
+		want     appsv1.StatefulSetUpdateStrategy
+	}{
+		{
+			name: "default",
+			want: rollingUpdate(0),
+		},
+		{
+			name:     "rolling update",
+			strategy: &kubelitedbv1.UpdateStrategy{Type: appsv1.RollingUpdateStatefulSetStrategyType},
+			want:     rollingUpdate(0),
+		},
+		{
+			name:     "partition",
+			strategy: &kubelitedbv1.UpdateStrategy{Partition: &partition},
+			want:     rollingUpdate(2),
+		},
+		{
+			name:     "on delete",
+			strategy: &kubelitedbv1.UpdateStrategy{Type: appsv1.OnDeleteStatefulSetStrategyType},
+			want:     appsv1.StatefulSetUpdateStrategy{Type: appsv1.OnDeleteStatefulSetStrategyType},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := newSQLiteInstance("test")
+			instance.Spec.UpdateStrategy = tt.strategy
+			f.addInstance(instance)
+			c, _, _ := f.newController(ctx)
+
+			f.run(ctx, c, getKey(instance, t))
+
+			got := f.getStatefulSet(ctx, instance).Spec.UpdateStrategy
+			if !equality.Semantic.DeepEqual(got, tt.want) {
+				t.Errorf("expected update strategy %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestUpdateStrategyOutOfDate(t *testing.T) {
+	partition := int32(0)
+	maxUnavailable := intstr.FromInt32(1)
+	tests := []struct {
+		name     string
+		current  appsv1.StatefulSetUpdateStrategy
+		desired  appsv1.StatefulSetUpdateStrategy
+		expected bool
+	}{
+		{
+			name:    "same",
+			current: rollingUpdate(1),
+			desired: rollingUpdate(1),
+		},
+		{
+			name:    "unset partition is 0",
+			current: appsv1.StatefulSetUpdateStrategy{Type: appsv1.RollingUpdateStatefulSetStrategyType},
+			desired: rollingUpdate(0),
+		},
+		{
+			name: "defaulted max unavailable",
+			current: appsv1.StatefulSetUpdateStrategy{
+				Type: appsv1.RollingUpdateStatefulSetStrategyType,
+				RollingUpdate: &appsv1.RollingUpdateStatefulSetStrategy{
+					Partition:      &partition,
+					MaxUnavailable: &maxUnavailable,
+				},
+			},
+			desired: rollingUpdate(0),
+		},
+		{
+			name:     "partition changed",
+			current:  rollingUpdate(0),
+			desired:  rollingUpdate(1),
+			expected: true,
+		},
+		{
+			name:     "type changed",
+			current:  rollingUpdate(0),
+			desired:  appsv1.StatefulSetUpdateStrategy{Type: appsv1.OnDeleteStatefulSetStrategyType},
+			expected: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := updateStrategyOutOfDate(tt.current, tt.desired); got != tt.expected {
+				t.Errorf("expected %t, got %t", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestUpdateStrategyChangeUpdatesStatefulSet(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSQLiteInstance("test")
+	f.addKubeObject(newStatefulSet(instance))
+	instance.Spec.UpdateStrategy = &kubelitedbv1.UpdateStrategy{Type: appsv1.OnDeleteStatefulSetStrategyType}
+	f.addInstance(instance)
+	c, _, _ := f.newController(ctx)
+
+	f.run(ctx, c, getKey(instance, t))
+
+	if got := len(writes(f.kubeclient.Actions(), "statefulsets")); got != 1 {
+		t.Fatalf("expected the StatefulSet to be updated once, got %d writes", got)
+	}
+	statefulSet := f.getStatefulSet(ctx, instance)
+	if statefulSet.Spec.UpdateStrategy.Type != appsv1.OnDeleteStatefulSetStrategyType {
+		t.Errorf("expected the OnDelete strategy, got %s", statefulSet.Spec.UpdateStrategy.Type)
+	}
+	// Only the strategy changed, so the pods aren't replaced
+	if got, want := statefulSet.Spec.Template.Annotations[templateHashAnnotation], newStatefulSet(newSQLiteInstance("test")).Spec.Template.Annotations[templateHashAnnotation]; got != want {
+		t.Errorf("expected the template hash %q to be kept, got %q", want, got)
+	}
+}
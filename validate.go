@@ -0,0 +1,94 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+	"github.com/fortytwoapps/kubelitedb/pkg/validation"
+)
+
+// validateCommand validates the SQLiteInstances in the given manifest files
+// with the same rules as the validating webhook, without a cluster. It prints
+// the result for every SQLiteInstance found and returns the exit code of the
+// command: 0 when all of them are valid, 1 otherwise.
+func validateCommand(stdout, stderr io.Writer, files []string) int {
+	if len(files) == 0 {
+		fmt.Fprintln(stderr, "usage: kubelitedb validate <file.yaml>...")
+		return 1
+	}
+
+	exitCode := 0
+	for _, file := range files {
+		if err := validateFile(stdout, file); err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", file, err)
+			exitCode = 1
+		}
+	}
+	return exitCode
+}
+
+// validateFile validates every SQLiteInstance in the YAML or JSON documents of
+// file. Documents of other kinds are ignored.
+func validateFile(out io.Writer, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	decoder := utilyaml.NewYAMLOrJSONDecoder(f, 4096)
+	invalid := 0
+	for {
+		instance := &kubelitedbv1.SQLiteInstance{}
+		if err := decoder.Decode(instance); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return fmt.Errorf("failed to decode manifest: %w", err)
+		}
+		if instance.Kind != "SQLiteInstance" {
+			continue
+		}
+
+		name := instance.Name
+		if instance.Namespace != "" {
+			name = instance.Namespace + "/" + name
+		}
+		errs := validation.ValidateSQLiteInstance(instance)
+		if len(errs) == 0 {
+			fmt.Fprintf(out, "PASS %s: SQLiteInstance %s\n", file, name)
+			continue
+		}
+		invalid++
+		fmt.Fprintf(out, "FAIL %s: SQLiteInstance %s\n", file, name)
+		for _, err := range errs {
+			fmt.Fprintf(out, "  - %v\n", err)
+		}
+	}
+
+	if invalid > 0 {
+		return fmt.Errorf("%d invalid SQLiteInstance(s)", invalid)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestValidateCommand(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		want  int
+		// Substrings of the output and the errors of the command
+		stdout []string
+		stderr []string
+	}{
+		{
+			name:   "valid",
+			files:  []string{"testdata/validate/valid.yaml"},
+			stdout: []string{"PASS testdata/validate/valid.yaml: SQLiteInstance default/app"},
+		},
+		{
+			name:   "example",
+			files:  []string{"examples/example-sqlite-instance.yaml"},
+			stdout: []string{"PASS examples/example-sqlite-instance.yaml: SQLiteInstance default/example-sqlite-instance"},
+		},
+		{
+			name:   "invalid",
+			files:  []string{"testdata/validate/invalid.yaml"},
+			want:   1,
+			stdout: []string{"FAIL testdata/validate/invalid.yaml: SQLiteInstance broken", "spec.dbName", "spec.storage", "spec.replicas"},
+			stderr: []string{"testdata/validate/invalid.yaml: 1 invalid SQLiteInstance(s)"},
+		},
+		{
+			name:   "several documents",
+			files:  []string{"testdata/validate/mixed.yaml"},
+			want:   1,
+			stdout: []string{"PASS testdata/validate/mixed.yaml: SQLiteInstance app", "FAIL testdata/validate/mixed.yaml: SQLiteInstance broken"},
+			stderr: []string{"1 invalid SQLiteInstance(s)"},
+		},
+		{
+			name:   "several files",
+			files:  []string{"testdata/validate/invalid.yaml", "testdata/validate/valid.yaml"},
+			want:   1,
+			stdout: []string{"FAIL testdata/validate/invalid.yaml", "PASS testdata/validate/valid.yaml"},
+		},
+		{
+			name:   "malformed",
+			files:  []string{"testdata/validate/malformed.yaml"},
+			want:   1,
+			stderr: []string{"failed to decode manifest"},
+		},
+		{
+			name:   "missing file",
+			files:  []string{"testdata/validate/missing.yaml"},
+			want:   1,
+			stderr: []string{"testdata/validate/missing.yaml"},
+		},
+		{name: "no files", want: 1, stderr: []string{"usage: kubelitedb validate"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+
+			if got := validateCommand(&stdout, &stderr, tt.files); got != tt.want {
+				t.Errorf("expected exit code %d, got %d: %s%s", tt.want, got, stdout.String(), stderr.String())
+			}
+			for _, want := range tt.stdout {
+				if !strings.Contains(stdout.String(), want) {
+					t.Errorf("expected the output to contain %q, got %q", want, stdout.String())
+				}
+			}
+			for _, want := range tt.stderr {
+				if !strings.Contains(stderr.String(), want) {
+					t.Errorf("expected the errors to contain %q, got %q", want, stderr.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"maps"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/ktesting"
+)
+
+func TestVolumeClaimAnnotations(t *testing.T) {
+	tests := []struct {
+		name                   string
+		annotations            map[string]string
+		volumeClaimAnnotations map[string]string
+		want                   map[string]string
+	}{
+		{name: "none"},
+		{
+			name:        "annotations of every child",
+			annotations: map[string]string{"team": "payments"},
+			want:        map[string]string{"team": "payments"},
+		},
+		{
+			name:                   "volume claim annotations",
+			volumeClaimAnnotations: map[string]string{"snapshot.storage.kubernetes.io/class": "csi-snapclass"},
+			want:                   map[string]string{"snapshot.storage.kubernetes.io/class": "csi-snapclass"},
+		},
+		{
+			name:                   "merged, the ones of the volume winning",
+			annotations:            map[string]string{"team": "payments", "tier": "db"},
+			volumeClaimAnnotations: map[string]string{"tier": "storage", "backup": "daily"},
+			want:                   map[string]string{"team": "payments", "tier": "storage", "backup": "daily"},
+		},
+		{
+			name:                   "reserved prefix",
+			volumeClaimAnnotations: map[string]string{reservedPrefix + "spec-hash": "x", "backup": "daily"},
+			want:                   map[string]string{"backup": "daily"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newSQLiteInstance("test")
+			instance.Spec.Annotations = tt.annotations
+			instance.Spec.VolumeClaimAnnotations = tt.volumeClaimAnnotations
+
+			if got := volumeClaimAnnotations(instance); !maps.Equal(got, tt.want) {
+				t.Errorf("expected the annotations %v, got %v", tt.want, got)
+			}
+			if got := newDataPVC(instance).Annotations; !maps.Equal(got, tt.want) {
+				t.Errorf("expected the data PVC to have the annotations %v, got %v", tt.want, got)
+			}
+			templates := newStatefulSet(instance).Spec.VolumeClaimTemplates
+			if len(templates) != 1 || !maps.Equal(templates[0].Annotations, tt.want) {
+				t.Errorf("expected the volume claim template to have the annotations %v, got %v", tt.want, templates)
+			}
+			// Only the data volumes get them
+			if got := newStatefulSet(instance).Annotations; !maps.Equal(got, tt.annotations) {
+				t.Errorf("expected the StatefulSet to have the annotations %v, got %v", tt.annotations, got)
+			}
+		})
+	}
+}
+
+func TestVolumeClaimAnnotationsAddedToPVCs(t *testing.T) {
+	tests := []struct {
+		name                   string
+		pvcAnnotations         map[string]string
+		volumeClaimAnnotations map[string]string
+		want                   map[string]string
+		updated                bool
+	}{
+		{
+			name:                   "added",
+			pvcAnnotations:         map[string]string{"pv.kubernetes.io/bind-completed": "yes"},
+			volumeClaimAnnotations: map[string]string{"snapshot.storage.kubernetes.io/class": "csi-snapclass"},
+			want:                   map[string]string{"pv.kubernetes.io/bind-completed": "yes", "snapshot.storage.kubernetes.io/class": "csi-snapclass"},
+			updated:                true,
+		},
+		{
+			name:                   "changed",
+			pvcAnnotations:         map[string]string{"snapshot.storage.kubernetes.io/class": "old"},
+			volumeClaimAnnotations: map[string]string{"snapshot.storage.kubernetes.io/class": "csi-snapclass"},
+			want:                   map[string]string{"snapshot.storage.kubernetes.io/class": "csi-snapclass"},
+			updated:                true,
+		},
+		{
+			name:                   "up to date",
+			pvcAnnotations:         map[string]string{"snapshot.storage.kubernetes.io/class": "csi-snapclass"},
+			volumeClaimAnnotations: map[string]string{"snapshot.storage.kubernetes.io/class": "csi-snapclass"},
+			want:                   map[string]string{"snapshot.storage.kubernetes.io/class": "csi-snapclass"},
+		},
+		{
+			name:           "removed from the spec",
+			pvcAnnotations: map[string]string{"snapshot.storage.kubernetes.io/class": "csi-snapclass"},
+			want:           map[string]string{"snapshot.storage.kubernetes.io/class": "csi-snapclass"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			instance := newSQLiteInstance("test")
+			instance.Spec.VolumeClaimAnnotations = tt.volumeClaimAnnotations
+			pvc := newBoundDataPVC(instance, corev1.ClaimBound, "1Gi")
+			pvc.Annotations = tt.pvcAnnotations
+			f.addInstance(instance)
+			f.addKubeObject(pvc)
+			c, _, _ := f.newController(ctx)
+
+			f.run(ctx, c, getKey(instance, t))
+
+			if actions := writes(f.kubeclient.Actions(), "persistentvolumeclaims"); (len(actions) != 0) != tt.updated {
+				t.Errorf("expected the PVC to be updated %t, got %v", tt.updated, actions)
+			}
+			got, err := f.kubeclient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Get(ctx, pvc.Name, v1.GetOptions{})
+			if err != nil {
+				t.Fatalf("error getting the PVC: %v", err)
+			}
+			if !maps.Equal(got.Annotations, tt.want) {
+				t.Errorf("expected the PVC to have the annotations %v, got %v", tt.want, got.Annotations)
+			}
+		})
+	}
+}
+
+func TestVolumeClaimAnnotationsLeaveExistingVolumeClaim(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	instance := newSubPathSQLiteInstance("test", "app.db", 0)
+	instance.Spec.VolumeClaimAnnotations = map[string]string{"snapshot.storage.kubernetes.io/class": "csi-snapclass"}
+	pvc := newBoundDataPVC(instance, corev1.ClaimBound, "1Gi")
+	pvc.OwnerReferences = nil
+	pvc.Annotations = nil
+	f.addInstance(instance)
+	f.addKubeObject(pvc)
+	c, _, _ := f.newController(ctx)
+
+	f.run(ctx, c, getKey(instance, t))
+
+	// The PVC of spec.volumeClaimName is not managed by the SQLiteInstance
+	if actions := writes(f.kubeclient.Actions(), "persistentvolumeclaims"); len(actions) != 0 {
+		t.Errorf("expected the existing PVC to be left as is, got %v", actions)
+	}
+}
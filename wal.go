@@ -0,0 +1,52 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+// checkpointScript checkpoints the WAL on the given interval. A failed
+// checkpoint is retried on the next interval.
+const checkpointScript = `while true; do
+  sleep "$CHECKPOINT_INTERVAL_SECONDS"
+  sqlite3 "$DATABASE_PATH" "PRAGMA wal_checkpoint($CHECKPOINT_MODE);" || echo "Failed to checkpoint $DATABASE_PATH" >&2
+done
+`
+
+// addCheckpointSidecar adds the sidecar checkpointing the WAL of the
+// database on spec.wal.checkpointInterval to the pod template.
+func addCheckpointSidecar(instance *kubelitedbv1.SQLiteInstance, template *corev1.PodTemplateSpec) {
+	interval := instance.Spec.WAL.CheckpointInterval.Duration
+	template.Spec.Containers = append(template.Spec.Containers, corev1.Container{
+		Name:    "checkpoint",
+		Image:   imageForInstance(instance),
+		Command: []string{"/bin/sh", "-c", checkpointScript},
+		Env: []corev1.EnvVar{
+			{Name: "DATABASE_PATH", Value: databasePath(instance)},
+			{Name: "CHECKPOINT_MODE", Value: checkpointMode(instance)},
+			{Name: "CHECKPOINT_INTERVAL_SECONDS", Value: strconv.FormatInt(int64(interval.Seconds()), 10)},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			dataVolumeMount(instance),
+		},
+	})
+}
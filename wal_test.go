@@ -0,0 +1,122 @@
+/*
+Copyright 2024 Forty Two Apps.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubelitedbv1 "github.com/fortytwoapps/kubelitedb/pkg/apis/kubelitedb/v1"
+)
+
+func TestWALAutoCheckpointPragma(t *testing.T) {
+	tests := []struct {
+		name           string
+		autoCheckpoint int32
+		pragmas        map[string]string
+		want           string
+	}{
+		{name: "threshold", autoCheckpoint: 4000, want: "PRAGMA wal_autocheckpoint = 4000;\n"},
+		{name: "disabled", autoCheckpoint: 0, want: "PRAGMA wal_autocheckpoint = 0;\n"},
+		{
+			name:           "with pragmas",
+			autoCheckpoint: 4000,
+			pragmas:        map[string]string{"synchronous": "normal", "busy_timeout": "5000"},
+			want:           "PRAGMA busy_timeout = 5000;\nPRAGMA synchronous = normal;\nPRAGMA wal_autocheckpoint = 4000;\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newSQLiteInstance("test")
+			instance.Spec.Pragmas = tt.pragmas
+			instance.Spec.WAL = &kubelitedbv1.WALSpec{AutoCheckpoint: &tt.autoCheckpoint}
+
+			if got := renderPragmas(instance); got != tt.want {
+				t.Errorf("expected pragmas %q, got %q", tt.want, got)
+			}
+			if _, ok := tt.pragmas["wal_autocheckpoint"]; ok {
+				t.Fatalf("spec.pragmas must not be changed")
+			}
+			if !hasInitContainer(newStatefulSet(instance).Spec.Template, "pragmas") {
+				t.Errorf("expected the pragmas to be applied by an init container")
+			}
+		})
+	}
+}
+
+func TestCheckpointSidecar(t *testing.T) {
+	tests := []struct {
+		name         string
+		instance     *kubelitedbv1.SQLiteInstance
+		interval     time.Duration
+		wantSidecar  bool
+		wantMode     string
+		wantInterval string
+	}{
+		{name: "no interval", instance: newSQLiteInstance("test"), wantMode: "TRUNCATE"},
+		{name: "interval", instance: newSQLiteInstance("test"), interval: time.Minute, wantSidecar: true, wantMode: "TRUNCATE", wantInterval: "60"},
+		{
+			name:         "interval while replicating",
+			instance:     newReplicatedSQLiteInstance("test"),
+			interval:     90 * time.Second,
+			wantSidecar:  true,
+			wantMode:     "PASSIVE",
+			wantInterval: "90",
+		},
+		{name: "no interval while replicating", instance: newReplicatedSQLiteInstance("test"), wantMode: "PASSIVE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.interval > 0 {
+				tt.instance.Spec.WAL = &kubelitedbv1.WALSpec{CheckpointInterval: &v1.Duration{Duration: tt.interval}}
+			}
+			containers := newStatefulSet(tt.instance).Spec.Template.Spec.Containers
+
+			// The checkpoint on shutdown uses the same mode as the sidecar
+			sqlite := container(t, containers, "sqlite")
+			if command := sqlite.Lifecycle.PreStop.Exec.Command; !strings.Contains(strings.Join(command, " "), "wal_checkpoint("+tt.wantMode+")") {
+				t.Errorf("expected a %s checkpoint on shutdown, got %v", tt.wantMode, command)
+			}
+
+			hasSidecar := slices.ContainsFunc(containers, func(c corev1.Container) bool { return c.Name == "checkpoint" })
+			if hasSidecar != tt.wantSidecar {
+				t.Fatalf("expected a checkpoint sidecar %t, got %t", tt.wantSidecar, hasSidecar)
+			}
+			if !tt.wantSidecar {
+				return
+			}
+			sidecar := container(t, containers, "checkpoint")
+			if got := envValue(sidecar, "CHECKPOINT_MODE"); got != tt.wantMode {
+				t.Errorf("expected CHECKPOINT_MODE %s, got %q", tt.wantMode, got)
+			}
+			if got := envValue(sidecar, "CHECKPOINT_INTERVAL_SECONDS"); got != tt.wantInterval {
+				t.Errorf("expected CHECKPOINT_INTERVAL_SECONDS %s, got %q", tt.wantInterval, got)
+			}
+			if got, want := envValue(sidecar, "DATABASE_PATH"), databasePath(tt.instance); got != want {
+				t.Errorf("expected DATABASE_PATH %s, got %q", want, got)
+			}
+			if !slices.Contains(sidecar.VolumeMounts, dataVolumeMount(tt.instance)) {
+				t.Errorf("expected the sidecar to mount the data volume, got %v", sidecar.VolumeMounts)
+			}
+		})
+	}
+}